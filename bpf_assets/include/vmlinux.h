@@ -0,0 +1,201 @@
+/*
+
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// The kernel types of the BPF modules. Only the fields the modules read are declared, their offsets are
+// relocated against the BTF of the running kernel when the objects are loaded, so the objects are compiled
+// once instead of against the headers of every kernel.
+
+#ifndef __VMLINUX_H__
+#define __VMLINUX_H__
+
+typedef unsigned char __u8;
+typedef signed char __s8;
+typedef unsigned short __u16;
+typedef signed short __s16;
+typedef unsigned int __u32;
+typedef signed int __s32;
+typedef unsigned long long __u64;
+typedef signed long long __s64;
+
+typedef __u8 u8;
+typedef __s8 s8;
+typedef __u16 u16;
+typedef __s16 s16;
+typedef __u32 u32;
+typedef __s32 s32;
+typedef __u64 u64;
+typedef __s64 s64;
+
+typedef __u16 __be16;
+typedef __u32 __be32;
+typedef __u32 __wsum;
+typedef __u16 __sum16;
+
+typedef long unsigned int size_t;
+typedef int pid_t;
+
+typedef _Bool bool;
+enum
+{
+    false = 0,
+    true = 1,
+};
+
+enum bpf_map_type
+{
+    BPF_MAP_TYPE_UNSPEC = 0,
+    BPF_MAP_TYPE_HASH = 1,
+    BPF_MAP_TYPE_ARRAY = 2,
+    BPF_MAP_TYPE_PROG_ARRAY = 3,
+    BPF_MAP_TYPE_PERF_EVENT_ARRAY = 4,
+    BPF_MAP_TYPE_STACK_TRACE = 7,
+};
+
+enum
+{
+    BPF_ANY = 0,
+    BPF_NOEXIST = 1,
+    BPF_EXIST = 2,
+};
+
+enum
+{
+    BPF_F_INDEX_MASK = 0xffffffffULL,
+    BPF_F_CURRENT_CPU = 0xffffffffULL,
+};
+
+// the flags of bpf_get_stackid
+enum
+{
+    BPF_F_SKIP_FIELD_MASK = 0xffULL,
+    BPF_F_USER_STACK = (1ULL << 8),
+    BPF_F_FAST_STACK_CMP = (1ULL << 9),
+    BPF_F_REUSE_STACKID = (1ULL << 10),
+};
+
+struct bpf_perf_event_value
+{
+    __u64 counter;
+    __u64 enabled;
+    __u64 running;
+};
+
+#ifndef BPF_NO_PRESERVE_ACCESS_INDEX
+#pragma clang attribute push(__attribute__((preserve_access_index)), apply_to = record)
+#endif
+
+#if defined(__TARGET_ARCH_x86)
+struct pt_regs
+{
+    long unsigned int r15;
+    long unsigned int r14;
+    long unsigned int r13;
+    long unsigned int r12;
+    long unsigned int bp;
+    long unsigned int bx;
+    long unsigned int r11;
+    long unsigned int r10;
+    long unsigned int r9;
+    long unsigned int r8;
+    long unsigned int ax;
+    long unsigned int cx;
+    long unsigned int dx;
+    long unsigned int si;
+    long unsigned int di;
+    long unsigned int orig_ax;
+    long unsigned int ip;
+    long unsigned int cs;
+    long unsigned int flags;
+    long unsigned int sp;
+    long unsigned int ss;
+};
+#elif defined(__TARGET_ARCH_arm64)
+struct user_pt_regs
+{
+    __u64 regs[31];
+    __u64 sp;
+    __u64 pc;
+    __u64 pstate;
+};
+
+struct pt_regs
+{
+    union
+    {
+        struct user_pt_regs user_regs;
+        struct
+        {
+            u64 regs[31];
+            u64 sp;
+            u64 pc;
+            u64 pstate;
+        };
+    };
+    u64 orig_x0;
+};
+#endif
+
+struct trace_entry
+{
+    short unsigned int type;
+    unsigned char flags;
+    unsigned char preempt_count;
+    int pid;
+};
+
+struct trace_event_raw_sched_switch
+{
+    struct trace_entry ent;
+    char prev_comm[16];
+    pid_t prev_pid;
+    int prev_prio;
+    long int prev_state;
+    char next_comm[16];
+    pid_t next_pid;
+    int next_prio;
+};
+
+struct task_struct
+{
+    u64 start_time;
+};
+
+struct sock
+{
+};
+
+struct tcp_sock
+{
+    u32 mss_cache;
+};
+
+struct inet_connection_sock
+{
+    struct
+    {
+        __u16 rcv_mss;
+    } icsk_ack;
+};
+
+struct msghdr;
+struct sk_buff;
+
+#ifndef BPF_NO_PRESERVE_ACCESS_INDEX
+#pragma clang attribute pop
+#endif
+
+#endif /* __VMLINUX_H__ */
@@ -0,0 +1,115 @@
+/*
+
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
+#include <bpf/bpf_tracing.h>
+
+// a transfer is sent to the collector in the context of the sending and receiving processes, keyed as in
+// the CPU module so the collector joins their traffic with their CPU time
+typedef struct traffic_event_t
+{
+    u64 cgroup_id;
+    u64 pid;
+    u64 tgid;
+    u64 start_time;
+    u64 tx_bytes;
+    u64 rx_bytes;
+    u64 tx_packets;
+    u64 rx_packets;
+    char comm[16];
+} traffic_event_t;
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, sizeof(u32));
+} events SEC(".maps");
+
+// send_traffic sends a transfer of the current process
+static __always_inline void send_traffic(struct pt_regs *ctx, u64 tx_bytes, u64 rx_bytes, u64 tx_packets, u64 rx_packets)
+{
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
+    traffic_event_t event = {};
+    event.cgroup_id = bpf_get_current_cgroup_id();
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    event.pid = pid_tgid & 0xffffffff;
+    event.tgid = pid_tgid >> 32;
+    event.start_time = BPF_CORE_READ(task, group_leader, start_time);
+    event.tx_bytes = tx_bytes;
+    event.rx_bytes = rx_bytes;
+    event.tx_packets = tx_packets;
+    event.rx_packets = rx_packets;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+}
+
+// segments returns the number of TCP segments of a transfer, the exact count is only known in the
+// softirq context where the process is not the current task
+static __always_inline u64 segments(u64 bytes, u32 mss)
+{
+    if (mss == 0)
+    {
+        return 1;
+    }
+    return (bytes + mss - 1) / mss;
+}
+
+// the probes are attached by the attacher, udp_sendmsg_entry to both udp_sendmsg and udpv6_sendmsg
+SEC("kprobe/tcp_sendmsg")
+int BPF_KPROBE(tcp_sendmsg_entry, struct sock *sk, struct msghdr *msg, size_t size)
+{
+    struct tcp_sock *tp = (struct tcp_sock *)sk;
+    u32 mss = BPF_CORE_READ(tp, mss_cache);
+    send_traffic(ctx, size, 0, segments(size, mss), 0);
+    return 0;
+}
+
+SEC("kprobe/tcp_cleanup_rbuf")
+int BPF_KPROBE(tcp_cleanup_rbuf_entry, struct sock *sk, int copied)
+{
+    if (copied <= 0)
+    {
+        return 0;
+    }
+    struct inet_connection_sock *icsk = (struct inet_connection_sock *)sk;
+    u32 mss = BPF_CORE_READ(icsk, icsk_ack.rcv_mss);
+    send_traffic(ctx, 0, copied, 0, segments(copied, mss));
+    return 0;
+}
+
+SEC("kprobe/udp_sendmsg")
+int BPF_KPROBE(udp_sendmsg_entry, struct sock *sk, struct msghdr *msg, size_t len)
+{
+    send_traffic(ctx, len, 0, 1, 0);
+    return 0;
+}
+
+SEC("kprobe/skb_consume_udp")
+int BPF_KPROBE(skb_consume_udp_entry, struct sock *sk, struct sk_buff *skb, int len)
+{
+    if (len <= 0)
+    {
+        return 0;
+    }
+    send_traffic(ctx, 0, len, 0, 1);
+    return 0;
+}
+
+char LICENSE[] SEC("license") = "Dual BSD/GPL";
@@ -15,91 +15,196 @@ See the License for the specific language governing permissions and
 limitations under the License.
 */
 
-#include <uapi/linux/ptrace.h>
-#include <uapi/linux/bpf_perf_event.h>
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+#include <bpf/bpf_core_read.h>
 
-#ifndef NUM_CPUS
-#define NUM_CPUS 128
-#endif
+// the hardware counters, in the order of the counter_time array: cpu_cycles, cpu_instr, cache_miss
+#define NUM_COUNTERS 3
 
-// we cannot define it dynamically as NUM_CPUS because the golang needs to know this
-// size at compiler time for decoding
-#define CPU_VECTOR_SIZE 128
+// the arrays per CPU are sized by the attacher to the CPUs of the node, the pid_time map to --bpf-map-size,
+// a full map drops the new processes
+#define NUM_CPUS 128
+#define MAP_SIZE 10240
 
-typedef struct switch_args
-{
-    u64 pad;
-    char prev_comm[16];
-    int prev_pid;
-    int prev_prio;
-    long long prev_state;
-    char next_comm[16];
-    int next_pid;
-    int next_prio;
-} switch_args;
-
-typedef struct process_time_t
+// a process slice is sent to the collector when the thread is switched out, the collector keys the
+// threads by their cgroup and process start time as well as their pid, so a process moving to another
+// cgroup within an interval is accounted to both owners and a reused pid is not merged with the exited
+// process. The tgid is the pid of the process the thread belongs to.
+typedef struct process_event_t
 {
     u64 cgroup_id;
     u64 pid;
+    u64 tgid;
+    u64 start_time;
     u64 process_run_time;
     u64 cpu_cycles;
     u64 cpu_instr;
     u64 cache_misses;
+    u32 cpu_id;
+    u32 pad;
     char comm[16];
-    //u64 pad;
-    // the max eBPF stack limit is 512 bytes, which is a vector of u16 with 128 elements
-    // the time is calculated in miliseconds, uint16 max size is 65K, ~1mim
-    u16 cpu_time[CPU_VECTOR_SIZE];
-}  process_time_t;
+} process_event_t;
 
 typedef struct pid_time_t
 {
     int pid;
 } pid_time_t;
 
-BPF_PERF_OUTPUT(events);
+struct
+{
+    __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, sizeof(u32));
+} events SEC(".maps");
 
-// processes and pid time
-BPF_HASH(processes, u64, process_time_t);
-BPF_HASH(pid_time, pid_time_t);
+// pid time
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, pid_time_t);
+    __type(value, u64);
+    __uint(max_entries, MAP_SIZE);
+} pid_time SEC(".maps");
 
-// perf counters
-BPF_PERF_ARRAY(cpu_cycles, NUM_CPUS);
-BPF_PERF_ARRAY(cpu_instr, NUM_CPUS);
-BPF_PERF_ARRAY(cache_miss, NUM_CPUS);
+// the failed inserts into the pid_time map
+struct
+{
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 1);
+} map_update_failures SEC(".maps");
+
+static __always_inline void count_update_failure(u32 map_id)
+{
+    u64 *failures = bpf_map_lookup_elem(&map_update_failures, &map_id);
+    if (failures)
+    {
+        __sync_fetch_and_add(failures, 1);
+    }
+}
+
+// perf counters, the attacher opens the counter of each CPU
+#define PERF_ARRAY(name)                             \
+    struct                                           \
+    {                                                \
+        __uint(type, BPF_MAP_TYPE_PERF_EVENT_ARRAY); \
+        __uint(key_size, sizeof(u32));               \
+        __uint(value_size, sizeof(u32));             \
+    } name SEC(".maps")
+
+PERF_ARRAY(cpu_cycles);
+PERF_ARRAY(cpu_instr);
+PERF_ARRAY(cache_miss);
 
 // tracking counters
-BPF_ARRAY(prev_cpu_cycles, u64, NUM_CPUS);
-BPF_ARRAY(prev_cpu_instr, u64, NUM_CPUS);
-BPF_ARRAY(prev_cache_miss, u64, NUM_CPUS);
+#define PREV_ARRAY(name)                  \
+    struct                                \
+    {                                     \
+        __uint(type, BPF_MAP_TYPE_ARRAY); \
+        __type(key, u32);                 \
+        __type(value, u64);               \
+        __uint(max_entries, NUM_CPUS);    \
+    } name SEC(".maps")
+
+PREV_ARRAY(prev_cpu_cycles);
+PREV_ARRAY(prev_cpu_instr);
+PREV_ARRAY(prev_cache_miss);
+
+// the last value of each counter on each CPU, at cpu * NUM_COUNTERS + counter
+struct
+{
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, struct bpf_perf_event_value);
+    __uint(max_entries, NUM_CPUS * NUM_COUNTERS);
+} prev_counter_value SEC(".maps");
+
+// the enabled and running time (ns) of each counter summed over the CPUs, the counter ran for a
+// fraction of the time it was enabled when the PMU is multiplexed
+typedef struct counter_time_t
+{
+    u64 enabled;
+    u64 running;
+} counter_time_t;
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, counter_time_t);
+    __uint(max_entries, NUM_COUNTERS);
+} counter_time SEC(".maps");
 
-static void safe_array_add(u32 idx, u16 *array, u16 value)
+// scaled_delta returns the increase of a counter on the CPU since its last read, scaled by its enabled
+// over running time so the multiplexed counts are not undercounted
+static __always_inline u64 scaled_delta(u32 cpu_id, u32 counter_id, struct bpf_perf_event_value *value)
 {
-#pragma clang loop unroll(full)
-    for (int array_index = 0; array_index < CPU_VECTOR_SIZE; array_index++)
+    u32 idx = cpu_id * NUM_COUNTERS + counter_id;
+    struct bpf_perf_event_value *prev = bpf_map_lookup_elem(&prev_counter_value, &idx);
+    if (prev == 0)
+    {
+        return 0;
+    }
+    u64 delta = 0;
+    if (prev->enabled > 0 && value->counter >= prev->counter && value->enabled >= prev->enabled && value->running >= prev->running)
     {
-        if (array_index == idx)
+        delta = value->counter - prev->counter;
+        u64 enabled = value->enabled - prev->enabled;
+        u64 running = value->running - prev->running;
+        if (running > 0 && running < enabled)
         {
-            array[array_index] += value;
-            break;
+            delta = delta * enabled / running;
+        }
+        counter_time_t *t = bpf_map_lookup_elem(&counter_time, &counter_id);
+        if (t)
+        {
+            __sync_fetch_and_add(&t->enabled, enabled);
+            __sync_fetch_and_add(&t->running, running);
         }
     }
+    prev->counter = value->counter;
+    prev->enabled = value->enabled;
+    prev->running = value->running;
+    return delta;
 }
 
-int sched_switch(switch_args *ctx)
+// read_delta returns the increase of a counter on the CPU since its last read, for the kernels reading
+// the counters without their enabled and running time
+static __always_inline u64 read_delta(void *counter, void *prev_values, u32 cpu_id)
 {
-    u64 pid = bpf_get_current_pid_tgid() & 0xffffffff;
+    u64 delta = 0;
+    u64 val = bpf_perf_event_read(counter, BPF_F_CURRENT_CPU);
+    if (((s64)val > 0) || ((s64)val < -256))
+    {
+        u64 *prev = bpf_map_lookup_elem(prev_values, &cpu_id);
+        if (prev)
+        {
+            delta = val - *prev;
+        }
+        bpf_map_update_elem(prev_values, &cpu_id, &val, BPF_ANY);
+    }
+    return delta;
+}
+
+// account_switch sends the slice of the process switched out, the counters are scaled with their
+// enabled and running time (kernel 4.15) when scaled is set
+static __always_inline int account_switch(struct trace_event_raw_sched_switch *ctx, bool scaled)
+{
+    u64 pid_tgid = bpf_get_current_pid_tgid();
+    u64 pid = pid_tgid & 0xffffffff;
     u64 cgroup_id = bpf_get_current_cgroup_id();
+    struct task_struct *task = (struct task_struct *)bpf_get_current_task();
 
     u64 time = bpf_ktime_get_ns();
     u64 delta = 0;
     u32 cpu_id = bpf_get_smp_processor_id();
-    pid_time_t new_pid, old_pid;
+    pid_time_t new_pid = {}, old_pid = {};
 
     // get pid time
     old_pid.pid = ctx->prev_pid;
-    u64 *last_time = pid_time.lookup(&old_pid);
+    u64 *last_time = bpf_map_lookup_elem(&pid_time, &old_pid);
     if (last_time != 0)
     {
         delta = (time - *last_time) / 1000000; /*milisecond*/
@@ -108,78 +213,73 @@ int sched_switch(switch_args *ctx)
         {
             return 0;
         }
-        pid_time.delete(&old_pid);
+        bpf_map_delete_elem(&pid_time, &old_pid);
     }
 
     new_pid.pid = ctx->next_pid;
-    pid_time.lookup_or_try_init(&new_pid, &time);
+    if (bpf_map_lookup_elem(&pid_time, &new_pid) == 0 &&
+        bpf_map_update_elem(&pid_time, &new_pid, &time, BPF_NOEXIST) != 0 &&
+        bpf_map_lookup_elem(&pid_time, &new_pid) == 0)
+    {
+        count_update_failure(0);
+    }
 
     u64 cpu_cycles_delta = 0;
     u64 cpu_instr_delta = 0;
     u64 cache_miss_delta = 0;
-    u64 *prev;
 
-    u64 val = cpu_cycles.perf_read(CUR_CPU_IDENTIFIER);
-    if (((s64)val > 0) || ((s64)val < -256))
+    if (scaled)
     {
-        prev = prev_cpu_cycles.lookup(&cpu_id);
-        if (prev)
+        struct bpf_perf_event_value value = {};
+        if (bpf_perf_event_read_value(&cpu_cycles, BPF_F_CURRENT_CPU, &value, sizeof(value)) == 0)
         {
-            cpu_cycles_delta = val - *prev;
+            cpu_cycles_delta = scaled_delta(cpu_id, 0, &value);
         }
-        prev_cpu_cycles.update(&cpu_id, &val);
-    }
-    val = cpu_instr.perf_read(CUR_CPU_IDENTIFIER);
-    if (((s64)val > 0) || ((s64)val < -256))
-    {
-        prev = prev_cpu_instr.lookup(&cpu_id);
-        if (prev)
+        if (bpf_perf_event_read_value(&cpu_instr, BPF_F_CURRENT_CPU, &value, sizeof(value)) == 0)
         {
-            cpu_instr_delta = val - *prev;
+            cpu_instr_delta = scaled_delta(cpu_id, 1, &value);
         }
-        prev_cpu_instr.update(&cpu_id, &val);
-    }
-    val = cache_miss.perf_read(CUR_CPU_IDENTIFIER);
-    if (((s64)val > 0) || ((s64)val < -256))
-    {
-        prev = prev_cache_miss.lookup(&cpu_id);
-        if (prev)
+        if (bpf_perf_event_read_value(&cache_miss, BPF_F_CURRENT_CPU, &value, sizeof(value)) == 0)
         {
-            cache_miss_delta = val - *prev;
+            cache_miss_delta = scaled_delta(cpu_id, 2, &value);
         }
-        prev_cache_miss.update(&cpu_id, &val);
-    }
-
-    // init process time
-    struct process_time_t *process_time;
-    process_time = processes.lookup(&pid);
-    if (process_time == 0)
-    {
-        process_time_t new_process = {};
-        new_process.pid = pid;
-        new_process.cgroup_id = cgroup_id;
-        new_process.cpu_cycles = cpu_cycles_delta;
-        new_process.cpu_instr = cpu_instr_delta;
-        new_process.cache_misses = cache_miss_delta;
-        new_process.process_run_time += delta;
-#ifdef CPU_FREQ
-        //FIXME: for certain reason, hyper-v seems to always get a cpu_id that is same as NUM_CPUS and cause stack overrun
-        safe_array_add(cpu_id, new_process.cpu_time, delta);
-#endif        
-        bpf_get_current_comm(&new_process.comm, sizeof(new_process.comm));
-        processes.update(&pid, &new_process);
     }
     else
     {
-        // update process time
-        process_time->cpu_cycles += cpu_cycles_delta;
-        process_time->cpu_instr += cpu_instr_delta;
-        process_time->cache_misses += cache_miss_delta;
-        process_time->process_run_time += delta;
-#ifdef CPU_FREQ
-        safe_array_add(cpu_id, process_time->cpu_time, delta);
-#endif        
+        cpu_cycles_delta = read_delta(&cpu_cycles, &prev_cpu_cycles, cpu_id);
+        cpu_instr_delta = read_delta(&cpu_instr, &prev_cpu_instr, cpu_id);
+        cache_miss_delta = read_delta(&cache_miss, &prev_cache_miss, cpu_id);
     }
 
+    // send the slice of the process
+    process_event_t event = {};
+    event.cgroup_id = cgroup_id;
+    event.pid = pid;
+    event.tgid = pid_tgid >> 32;
+    event.start_time = BPF_CORE_READ(task, group_leader, start_time);
+    event.process_run_time = delta;
+    event.cpu_cycles = cpu_cycles_delta;
+    event.cpu_instr = cpu_instr_delta;
+    event.cache_misses = cache_miss_delta;
+    event.cpu_id = cpu_id;
+    bpf_get_current_comm(&event.comm, sizeof(event.comm));
+    bpf_perf_event_output(ctx, &events, BPF_F_CURRENT_CPU, &event, sizeof(event));
+
     return 0;
-}
\ No newline at end of file
+}
+
+// the attacher loads one of the programs, sched_switch_unscaled when the kernel cannot read the
+// enabled and running time of the counters
+SEC("tp/sched/sched_switch")
+int sched_switch(struct trace_event_raw_sched_switch *ctx)
+{
+    return account_switch(ctx, true);
+}
+
+SEC("tp/sched/sched_switch")
+int sched_switch_unscaled(struct trace_event_raw_sched_switch *ctx)
+{
+    return account_switch(ctx, false);
+}
+
+char LICENSE[] SEC("license") = "Dual BSD/GPL";
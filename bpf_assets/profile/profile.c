@@ -0,0 +1,109 @@
+/*
+
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+
+#include "vmlinux.h"
+#include <bpf/bpf_helpers.h>
+
+// The profile module samples the stacks of the profiled cgroups at the frequency of the CPU clock events
+// the attacher opens on every CPU, and counts the samples per process and pair of user and kernel
+// stacks. The collector drains the counts each sample and weights them by the energy of the container.
+
+// the sizes are set by the attacher, a full map drops the new stacks
+#define MAP_SIZE 10240
+#define MAX_STACK_DEPTH 127
+
+typedef struct stack_key_t
+{
+    u64 cgroup_id;
+    u32 pid;
+    s32 user_stack_id;
+    s32 kernel_stack_id;
+    u32 pad;
+    char comm[16];
+} stack_key_t;
+
+// the cgroups whose stacks are sampled, set by the collector
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, u64);
+    __type(value, u8);
+    __uint(max_entries, 64);
+} profiled_cgroups SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_HASH);
+    __type(key, stack_key_t);
+    __type(value, u64);
+    __uint(max_entries, MAP_SIZE);
+} stack_counts SEC(".maps");
+
+struct
+{
+    __uint(type, BPF_MAP_TYPE_STACK_TRACE);
+    __uint(key_size, sizeof(u32));
+    __uint(value_size, MAX_STACK_DEPTH * sizeof(u64));
+    __uint(max_entries, MAP_SIZE);
+} stack_traces SEC(".maps");
+
+// the samples dropped since the stack_counts map was full
+struct
+{
+    __uint(type, BPF_MAP_TYPE_ARRAY);
+    __type(key, u32);
+    __type(value, u64);
+    __uint(max_entries, 1);
+} dropped_samples SEC(".maps");
+
+SEC("perf_event")
+int profile_sample(void *ctx)
+{
+    u64 cgroup_id = bpf_get_current_cgroup_id();
+    if (bpf_map_lookup_elem(&profiled_cgroups, &cgroup_id) == 0)
+    {
+        return 0;
+    }
+    stack_key_t key = {};
+    key.cgroup_id = cgroup_id;
+    key.pid = bpf_get_current_pid_tgid() >> 32;
+    // a stack missing from stack_traces is counted with a negative id, as an unknown frame
+    key.user_stack_id = bpf_get_stackid(ctx, &stack_traces, BPF_F_USER_STACK);
+    key.kernel_stack_id = bpf_get_stackid(ctx, &stack_traces, 0);
+    bpf_get_current_comm(&key.comm, sizeof(key.comm));
+
+    u64 *count = bpf_map_lookup_elem(&stack_counts, &key);
+    if (count)
+    {
+        __sync_fetch_and_add(count, 1);
+        return 0;
+    }
+    u64 one = 1;
+    if (bpf_map_update_elem(&stack_counts, &key, &one, BPF_NOEXIST) != 0)
+    {
+        u32 zero = 0;
+        u64 *dropped = bpf_map_lookup_elem(&dropped_samples, &zero);
+        if (dropped)
+        {
+            __sync_fetch_and_add(dropped, 1);
+        }
+    }
+    return 0;
+}
+
+char LICENSE[] SEC("license") = "Dual BSD/GPL";
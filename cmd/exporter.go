@@ -17,14 +17,59 @@ limitations under the License.
 package main
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"flag"
+	"io/ioutil"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/sustainable-computing-io/kepler/pkg/ambient"
+	"github.com/sustainable-computing-io/kepler/pkg/api"
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
 	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+	"github.com/sustainable-computing-io/kepler/pkg/gateway"
+	"github.com/sustainable-computing-io/kepler/pkg/governor"
+	"github.com/sustainable-computing-io/kepler/pkg/grouping"
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+	"github.com/sustainable-computing-io/kepler/pkg/images"
+	"github.com/sustainable-computing-io/kepler/pkg/leader"
+	"github.com/sustainable-computing-io/kepler/pkg/logging"
+	"github.com/sustainable-computing-io/kepler/pkg/modbus"
 	"github.com/sustainable-computing-io/kepler/pkg/model"
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/accelerator"
+	"github.com/sustainable-computing-io/kepler/pkg/power/dpu"
 	"github.com/sustainable-computing-io/kepler/pkg/power/gpu"
+	"github.com/sustainable-computing-io/kepler/pkg/power/nic"
+	"github.com/sustainable-computing-io/kepler/pkg/power/npu"
+	"github.com/sustainable-computing-io/kepler/pkg/power/platform"
 	"github.com/sustainable-computing-io/kepler/pkg/power/rapl"
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
+	"github.com/sustainable-computing-io/kepler/pkg/power/solar"
+	"github.com/sustainable-computing-io/kepler/pkg/profile"
+	"github.com/sustainable-computing-io/kepler/pkg/profiler"
+	"github.com/sustainable-computing-io/kepler/pkg/publisher"
+	"github.com/sustainable-computing-io/kepler/pkg/resources"
+	"github.com/sustainable-computing-io/kepler/pkg/runtimestats"
+	"github.com/sustainable-computing-io/kepler/pkg/script"
+	"github.com/sustainable-computing-io/kepler/pkg/snmp"
+	"github.com/sustainable-computing-io/kepler/pkg/spool"
+	"github.com/sustainable-computing-io/kepler/pkg/startup"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+	"github.com/sustainable-computing-io/kepler/pkg/stream"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+	"github.com/sustainable-computing-io/kepler/pkg/trend"
+	"github.com/sustainable-computing-io/kepler/pkg/units"
+	"github.com/sustainable-computing-io/kepler/pkg/wasm"
 
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
@@ -34,51 +79,582 @@ import (
 var (
 	address             = flag.String("address", "0.0.0.0:8888", "bind address")
 	metricsPath         = flag.String("metrics-path", "/metrics", "metrics path")
-	enableGPU           = flag.Bool("enable-gpu", false, "whether enable gpu (need to have libnvidia-ml installed)")
-	modelServerEndpoint = flag.String("model-server-endpoint", "", "model server endpoint")
+	logFormat           = flag.String("log-format", logging.Text, "format of the sample logs: text or json")
+	logVerbosity        = flag.Int("v", 0, "verbosity of the sample logs, 2 logs the node energy of each sample and 4 the energy of each pod")
+	enableGPU           = flag.Bool("enable-gpu", false, "whether enable gpu (need to have libnvidia-ml installed, or the INA3221 GPU rail on Jetson modules)")
+	modelServerEndpoint = flag.String("model-server-endpoint", "", "model server endpoint estimating the core and DRAM energy of the containers instead of the ratio model, http://host:port/path or unix:///path/to.sock")
+	estimatorModel      = flag.String("estimator-model", "", "model of the estimator model server, empty for its default")
+	coefficientsFile    = flag.String("coefficients-file", model.DefaultCoefficientsPath, "JSON or YAML bundle of the power model coefficients by CPU architecture and GPU model")
+	trainModel          = flag.Bool("train-model", false, "whether learn the power model coefficients of the CPU from the RAPL measurements, saved in the store across restarts")
+	wasmRuntimeEndpoint = flag.String("wasm-runtime-endpoint", "", "WASM runtime API endpoint listing the thread of each hosted module")
+	maintenanceWindows  = flag.String("maintenance-windows", "", "comma separated weekly maintenance windows, e.g. \"Sat 02:00-04:00,* 23:00-01:00\"")
+	enableNPU           = flag.Bool("enable-npu", false, "whether enable the NPU energy attribution (Jetson NVDLA through tegrastats, RK3588 RKNPU)")
+	referenceTemp       = flag.Float64("estimate-reference-temperature", source.DefaultReferenceTemperature, "SoC temperature in °C at which the power estimates were measured")
+	leakageDoubling     = flag.Float64("leakage-doubling-temperature", source.DefaultLeakageDoubling, "temperature increase in °C doubling the static power of the estimates, 0 to disable the derating")
+	ambientMQTTBroker   = flag.String("ambient-mqtt-broker", "", "MQTT broker of the ambient sensors, e.g. tcp://broker:1883")
+	mqttBroker          = flag.String("mqtt-broker", "", "MQTT broker the samples are published to, e.g. tcp://broker:1883 or ssl://broker:8883")
+	mqttTopic           = flag.String("mqtt-topic", publisher.DefaultTopic, "MQTT topic of the samples, {node} is replaced by the node name")
+	mqttQoS             = flag.Int("mqtt-qos", 1, "MQTT QoS of the samples")
+	mqttFormat          = flag.String("mqtt-format", publisher.FormatJSON, "payload format of the samples, json or cbor")
+	mqttClientID        = flag.String("mqtt-client-id", "", "MQTT client ID (default kepler-<node>)")
+	mqttUsername        = flag.String("mqtt-username", "", "MQTT username")
+	mqttPasswordFile    = flag.String("mqtt-password-file", "", "file holding the MQTT password")
+	mqttCAFile          = flag.String("mqtt-ca-file", "", "CA certificate of the MQTT broker")
+	mqttCertFile        = flag.String("mqtt-cert-file", "", "client certificate for the MQTT broker")
+	mqttKeyFile         = flag.String("mqtt-key-file", "", "client key for the MQTT broker")
+	enableSampleAPI     = flag.Bool("enable-sample-api", false, "whether serve POST /api/v1/sample, taking a sample right away and returning its snapshot")
+	enableEnergyAPI     = flag.Bool("enable-energy-api", false, "whether serve /api/v1/containers and /api/v1/node, the energy of the last sample or summed over a window, as JSON")
+	energyAPIWindow     = flag.Duration("energy-api-window", api.DefaultEnergyWindow, "longest window the energy API sums the energy over, in one minute buckets")
+	enableSessionAPI    = flag.Bool("enable-session-api", false, "whether serve /api/v1/sessions, measuring the energy of each container within named windows")
+	enableAttestation   = flag.Bool("enable-attestation-api", false, "whether serve /api/v1/attestation, the statement of the settings, power model and sensors signed with the signing key")
+	otlpEndpoint        = flag.String("otlp-endpoint", "", "OpenTelemetry collector the energy is pushed to over OTLP/HTTP, e.g. http://otel-collector:4318")
+	otlpHeaders         = flag.String("otlp-headers", "", "comma separated key=value headers of the OTLP requests")
+	otlpAttributes      = flag.String("otlp-resource-attributes", "", "comma separated key=value resource attributes of the device in the OTLP metrics")
+	spoolDir            = flag.String("spool-dir", "", "directory the samples are persisted to and replayed from to the MQTT broker and the OTLP collector, backfilling the disconnections, empty to disable")
+	spoolMaxBytes       = flag.Int64("spool-max-bytes", spool.DefaultMaxBytes, "size of the spooled samples, the oldest are dropped first")
+	spoolRetention      = flag.Duration("spool-retention", spool.DefaultRetention, "age of the spooled samples, the older are dropped")
+	journalLog          = flag.Bool("enable-journal", false, "whether log the power of the node and of the containers to the systemd journal with structured fields")
+	journalMinWatts     = flag.Float64("journal-min-watts", 0, "containers drawing less are not logged to the journal")
+	ambientMQTTTopics   = flag.String("ambient-mqtt-topics", "", "comma separated MQTT topics of the ambient sensors, topic=<temperature|humidity|pressure> for plain number payloads")
+	runtimeConfig       = flag.String("runtime-metrics-config", "", "config file of the metrics endpoints of the Go and JVM workloads, their GC time and allocation rate are attached to the container samples")
+	runtimeInterval     = flag.Duration("runtime-metrics-interval", runtimestats.DefaultInterval, "interval between the scrapes of the workload runtime metrics")
+	chargeController    = flag.String("charge-controller", "", "charge controller of off-grid sites: epever (Modbus RTU) or victron (VE.Direct)")
+	chargeControllerDev = flag.String("charge-controller-device", "/dev/ttyUSB0", "serial device of the charge controller")
+	dpuEndpoint         = flag.String("dpu-telemetry-endpoint", "", "DPU telemetry endpoint exposing the DPU power and offloaded flows in the prometheus text format")
+	dpuPowerMetric      = flag.String("dpu-power-metric", dpu.DefaultPowerMetric, "name of the DPU power metric in watts")
+	dpuFlowMetric       = flag.String("dpu-flow-metric", dpu.DefaultFlowMetric, "name of the DPU offloaded flow bytes metric")
+	excludeRealTime     = flag.Bool("exclude-realtime-from-actuation", true, "whether containers with real-time threads are excluded from power actuation policies")
+	storeDir            = flag.String("store-dir", store.DefaultDir, "directory of the state kept across restarts, empty to disable")
+	leaderElect         = flag.Bool("leader-elect", false, "elect a leader among the instances to run the cluster level rollups, e.g. the gateway replica scraping the fleet")
+	leaderNamespace     = flag.String("leader-election-namespace", "", "namespace of the leader election lease, defaults to the service account namespace")
+	leaderLease         = flag.String("leader-election-lease", leader.DefaultLeaseName, "name of the leader election lease")
+	sampleHookScript    = flag.String("sample-hook-script", "", "Starlark script defining on_sample(snapshot), run after each sample to derive custom metrics")
+	profileCatalogDir   = flag.String("profile-catalog-dir", profile.DefaultCatalogDir, "directory of the shipped hardware profiles")
+	profileOverrideDir  = flag.String("profile-override-dir", profile.DefaultOverrideDir, "directory of the user hardware profiles, replacing the shipped ones with the same name")
+	hardwareProfile     = flag.String("hardware-profile", "", "name of the hardware profile to use instead of matching the device")
+	embodiedCarbon      = flag.Float64("embodied-carbon-kg", 0, "embodied carbon of the device in kgCO2e, overriding the hardware profile")
+	embodiedEnergy      = flag.Float64("embodied-energy-kwh", 0, "embodied energy of the device in kWh, overriding the hardware profile")
+	deviceLifetime      = flag.Float64("device-lifetime-years", 0, "expected lifetime of the device the embodied carbon and energy are amortized over, overriding the hardware profile")
+	modbusAddress       = flag.String("modbus-address", "", "Modbus TCP bind address exposing the node power and top consumer registers to SCADA systems, e.g. :502")
+	snmpAddress         = flag.String("snmp-address", "", "UDP bind address of the SNMP agent exposing KEPLER-EDGE-MIB, e.g. :161")
+	grpcAddress         = flag.String("grpc-address", "", "address of the gRPC EnergyService streaming the energy of each sample, TCP (e.g. :9102) or unix socket (e.g. unix:///run/kepler/energy.sock)")
+	snmpCommunity       = flag.String("snmp-community", "public", "SNMPv1/v2c community of the SNMP agent")
+	enableDashboard     = flag.Bool("enable-dashboard", true, "whether serve the built-in web dashboard of the live power")
+	historyRetention    = flag.Duration("history-retention", history.DefaultRetention, "how long the local history served by the query API is kept, 0 to disable")
+	groupingRules       = flag.String("grouping-rules", "", "JSON file of the rules grouping the containers into services, by namespace, pod, command and pod labels")
+	imageMetrics        = flag.Bool("enable-image-metrics", true, "whether aggregate the energy per container image")
+	trendWindows        = flag.String("trend-windows", trend.DefaultWindows, "comma separated windows of the average power metrics per container and node, empty to disable")
+	configPath          = flag.String("config", config.DefaultPath, "YAML config file, read again on SIGHUP")
+	samplePeriod        = flag.Duration("sample-period", 0, "period of the energy samples, overriding $"+config.SamplePeriodEnv+" and the config file (default 3s)")
+	enableResources     = flag.Bool("enable-resource-enrichment", false, "whether join the pod energy with the CPU requests and limits from the API server, exporting the watts per requested core and provisioning indicators")
+	netnsAccounting     = flag.Bool("netns-accounting", true, "read the traffic of the pods from the interfaces of their network namespaces when the net bpf module is not attached")
+	nicEnergyPerByte    = flag.Float64("nic-energy-per-byte", collector.DefaultNICEnergyPerByte, "energy per byte of socket traffic in nJ of the NIC model, if the NIC is not calibrated")
+	storagePerByte      = flag.Float64("storage-energy-per-byte", collector.DefaultStorageEnergyPerByte, "energy per byte read or written in nJ of the storage model of the node power breakdown")
+	nicEnergyPerPacket  = flag.Float64("nic-energy-per-packet", collector.DefaultNICEnergyPerPacket, "energy per packet of socket traffic in nJ of the NIC model, if the NIC is not calibrated")
+	nicInterface        = flag.String("nic-interface", "", "interface whose coefficients calibrated by nic-calibration are used for the socket traffic (default the only calibrated interface)")
+	startupMetrics      = flag.Bool("enable-startup-metrics", false, "whether measure the energy of the pods from their creation to Ready")
+	processMetrics      = flag.Bool("enable-process-metrics", false, "whether account the energy per process, for the devices running plain systemd services")
+	standalone          = flag.Bool("standalone", false, "whether run without kubelet, resolving the podman containers and the systemd services of the device")
+	systemdUnits        = flag.Bool("enable-systemd-units", false, "whether account the system processes per systemd service (e.g. NetworkManager.service) instead of as system_processes, same as -system-processes=unit")
+	systemProcesses     = flag.String("system-processes", "", "how the processes outside of the pods are accounted: catch-all entry, split per systemd slice or unit, or drop (default catch-all, unit with -standalone or -enable-systemd-units)")
+	systemProcessName   = flag.String("system-processes-name", pod_lister.DefaultSystemProcessName, "name of the catch-all entry of the processes outside of the pods, in the system namespace")
+	sensorSubsystems    = flag.String("power-sensor-subsystems", "", "comma separated subsystems of the INA/PMIC power sensors overriding the ones guessed from their labels, sensor=<cpu|dram|gpu|npu|other|total>")
+	coreAttribution     = flag.String("core-attribution", collector.CoreAttributionRatio, "how the core energy is attributed: ratio of the CPU time, or per-core weighting the CPU time on each core by its frequency")
+	idlePower           = flag.Float64("idle-power", 0, "static power (W) of the node outside the CPU, DRAM, GPU and accelerators, 0 if unknown")
+	measureIdlePower    = flag.Bool("measure-idle-power", false, "measure the idle power at startup as the lowest other power of the first samples, if no idle power is given")
+	idleAttribution     = flag.String("idle-attribution", collector.IdleAttributionEven, "how the static energy is attributed among the pods: even, cpu, memory or unattributed")
+	signingKey          = flag.String("signing-key", "", "PKCS#8 PEM ed25519 device key signing the exported snapshots, the API reports and the attestation, so the fleet can verify they were not tampered with")
+	peakPowerWatts      = flag.Float64("peak-power-watts", 0, "node power (W) above which the samples are taken at the peak sample period and recorded as a peak event in the store, 0 to disable")
+	peakSamplePeriod    = flag.Duration("peak-sample-period", collector.DefaultPeakSamplePeriod, "sample period while capturing a peak event")
+	peakCaptureDuration = flag.Duration("peak-capture-duration", collector.DefaultPeakCaptureDuration, "how long a peak event is captured after the node power falls below the threshold")
+	containerRetention  = flag.Duration("container-retention", collector.DefaultContainerRetention, "how long a container without processes whose cgroup was removed is kept before its last sample marked terminated, 0 to never evict")
+	bpfMapSize          = flag.Int("bpf-map-size", 10240, "capacity of the eBPF processes and traffic maps, the processes beyond it are not accounted")
+	bpfModules          = flag.String("bpf-modules", "cpu,net", "comma separated eBPF modules to load: cpu for the CPU time and hardware counters, net for the socket traffic")
+	profileContainer    = flag.String("profile-container", "", "namespace/name of a container whose stacks are sampled with eBPF and weighted by its energy, empty to disable")
+	profileOutput       = flag.String("profile-output", "/var/lib/kepler/energy.folded", "folded stack file of the energy profile in µJ, for flamegraph.pl or speedscope")
+	profileFrequency    = flag.Int("profile-frequency", attacher.DefaultProfileFrequency, "stack samples per second on each CPU of the energy profile")
+	raplMSR             = flag.Bool("rapl-msr", false, "whether read the RAPL MSRs rather than the powercap sysfs, falling back to the sysfs when the MSRs can not be read")
+	governorAdvice      = flag.Bool("enable-governor-advice", false, "whether recommend the cpufreq governor and energy_performance_preference from the load and idle residency over a window, served at /api/v1/governor")
+	governorWindow      = flag.Duration("governor-window", governor.DefaultWindow, "window of the load the governor is recommended from")
+	governorAutoTune    = flag.Bool("governor-auto-tune", false, "whether apply the recommended governor and EPP at the end of each window, recording the node power before and after")
+	gatewayConfig       = flag.String("gateway-config", "", "run in gateway mode, aggregating the exporters of several edge clusters listed in this config file")
 )
 
 func main() {
 	flag.Parse()
+	if err := logging.Setup(*logFormat, *logVerbosity); err != nil {
+		log.Fatalf("failed to set up the logs: %v", err)
+	}
 
 	err := prometheus.Register(version.NewCollector("energy_stats_exporter"))
 	if err != nil {
 		log.Fatalf("failed to register : %v", err)
 	}
+	if err = prometheus.Register(supervisor.NewCollector()); err != nil {
+		log.Fatalf("failed to register subsystem panic counters: %v", err)
+	}
 
+	if *coefficientsFile != model.DefaultCoefficientsPath {
+		if err = model.LoadCoefficients(*coefficientsFile); err != nil {
+			log.Fatalf("failed to load the coefficients: %v", err)
+		}
+	}
 	if *enableGPU {
 		err = gpu.Init()
 		if err == nil {
 			defer gpu.Shutdown()
+			model.SetGPUModel(gpu.GetDeviceName())
 		}
 	}
+	rapl.SetUseMSR(*raplMSR)
+	attacher.SetMapSize(*bpfMapSize)
+	if err = attacher.SetModules(*bpfModules); err != nil {
+		log.Fatalf("failed to set the bpf modules: %v", err)
+	}
+	if err = soc.SetSensorSubsystems(*sensorSubsystems); err != nil {
+		log.Fatalf("failed to parse the power sensor subsystems: %v", err)
+	}
 	if modelServerEndpoint != nil {
 		model.SetModelServerEndpoint(*modelServerEndpoint)
 	}
-
-	collector, err := collector.New()
-	if err != nil {
-		log.Fatalf("failed to create collector: %v", err)
+	if len(*modelServerEndpoint) > 0 {
+		model.SetEstimator(model.NewServerEstimator(*modelServerEndpoint, *estimatorModel))
 	}
-	err = collector.Attach()
+	catalog, err := profile.LoadCatalog(*profileCatalogDir, *profileOverrideDir)
 	if err != nil {
-		log.Fatalf("failed to attach : %v", err)
+		log.Printf("failed to load the hardware profiles: %v", err)
+	}
+	p := catalog[*hardwareProfile]
+	if len(*hardwareProfile) == 0 {
+		p = profile.Detect(catalog)
+	}
+	if p != nil {
+		log.Printf("using hardware profile %s\n", p.Name)
+		if p.Coefficients != nil {
+			model.SetHardwareCoeff(*p.Coefficients)
+		}
+		if err = prometheus.Register(p); err != nil {
+			log.Fatalf("failed to register hardware profile: %v", err)
+		}
+	} else if len(*hardwareProfile) > 0 {
+		log.Fatalf("unknown hardware profile %s", *hardwareProfile)
+	}
+	embodied := &profile.Embodied{}
+	if p != nil && p.Embodied != nil {
+		*embodied = *p.Embodied
+	}
+	embodied.Override(profile.Embodied{CarbonKg: *embodiedCarbon, EnergyKWh: *embodiedEnergy, LifetimeYears: *deviceLifetime})
+	if embodied.IsSet() {
+		if err = prometheus.Register(embodied); err != nil {
+			log.Fatalf("failed to register embodied footprint: %v", err)
+		}
+	}
+	source.SetTemperatureDerating(*referenceTemp, *leakageDoubling)
+	wasm.SetRuntimeEndpoint(*wasmRuntimeEndpoint)
+	store.SetDir(*storeDir)
+	if *enableNPU {
+		if n := npu.Detect(); n != nil {
+			accelerator.Register(n)
+			defer n.Close()
+			if err = prometheus.Register(n); err != nil {
+				log.Fatalf("failed to register npu: %v", err)
+			}
+		} else {
+			log.Printf("no supported NPU found\n")
+		}
+	}
+	if len(*ambientMQTTBroker) > 0 {
+		if err = ambient.SubscribeMQTT(*ambientMQTTBroker, *ambientMQTTTopics, "kepler-"+collector.EdgeDeviceName+"-ambient"); err != nil {
+			log.Printf("failed to subscribe to the ambient sensors: %v\n", err)
+		}
+	}
+	if len(*runtimeConfig) > 0 {
+		config, err := runtimestats.LoadConfig(*runtimeConfig)
+		if err != nil {
+			log.Fatalf("failed to load runtime metrics config: %v", err)
+		}
+		runtimestats.Start(config, *runtimeInterval)
+	}
+	if len(*chargeController) > 0 {
+		c, err := solar.Start(*chargeController, *chargeControllerDev)
+		if err != nil {
+			log.Fatalf("failed to start charge controller: %v", err)
+		}
+		defer c.Close()
+		if err = prometheus.Register(c); err != nil {
+			log.Fatalf("failed to register charge controller: %v", err)
+		}
+	}
+	if len(*dpuEndpoint) > 0 {
+		accelerator.Register(dpu.New(*dpuEndpoint, *dpuPowerMetric, *dpuFlowMetric))
+	}
+	if err = collector.SetModelTraining(*trainModel); err != nil {
+		log.Printf("failed to restore the trained power model: %v\n", err)
+	}
+	collector.SetExcludeRealTimeFromActuation(*excludeRealTime)
+	collector.SetProcessAccounting(*processMetrics)
+	pod_lister.SetStandalone(*standalone)
+	mode := *systemProcesses
+	if len(mode) == 0 {
+		mode = collector.SystemProcessesCatchAll
+		if *systemdUnits || *standalone {
+			mode = collector.SystemProcessesUnit
+		}
+	}
+	if err = collector.SetSystemProcesses(mode, *systemProcessName); err != nil {
+		log.Fatalf("failed to set the system processes accounting: %v", err)
+	}
+	if err = collector.SetContainerRetention(*containerRetention); err != nil {
+		log.Fatalf("failed to set the container retention: %v", err)
+	}
+	if err = collector.SetPeakCapture(*peakPowerWatts, *peakSamplePeriod, *peakCaptureDuration); err != nil {
+		log.Fatalf("failed to set the peak capture: %v", err)
+	}
+	if err = collector.SetSigningKey(*signingKey); err != nil {
+		log.Fatalf("failed to load the signing key: %v", err)
+	}
+	collector.SetNICModel(*nicEnergyPerByte, *nicEnergyPerPacket)
+	collector.SetNetnsAccounting(*netnsAccounting)
+	collector.SetStorageModel(*storagePerByte)
+	if err = nic.LoadModel(); err != nil {
+		log.Printf("failed to load the NIC model: %v\n", err)
+	} else if c, ok := nic.GetModelCoefficients(*nicInterface); ok {
+		collector.SetNICModel(c.EnergyPerByte, c.EnergyPerPacket)
+	} else if len(*nicInterface) > 0 {
+		log.Printf("interface %s is not calibrated, using the default NIC model\n", *nicInterface)
+	}
+	if err = collector.SetMaintenanceWindows(*maintenanceWindows); err != nil {
+		log.Fatalf("failed to parse maintenance windows: %v", err)
+	}
+	if err = collector.SetCoreAttribution(*coreAttribution); err != nil {
+		log.Fatalf("failed to set the core attribution: %v", err)
+	}
+	if err = collector.SetIdlePower(*idlePower, *measureIdlePower); err != nil {
+		log.Fatalf("failed to set the idle power: %v", err)
+	}
+	if err = collector.SetIdleAttribution(*idleAttribution); err != nil {
+		log.Fatalf("failed to set the idle attribution: %v", err)
 	}
-	defer collector.Destroy()
-	defer rapl.StopPower()
 
-	err = prometheus.Register(collector)
-	if err != nil {
-		log.Fatalf("failed to register collector: %v", err)
+	// main returns once the server is shut down, running the deferred stops
+	metricsServer := &http.Server{Addr: *address}
+	if len(*gatewayConfig) > 0 {
+		config, err := gateway.LoadConfig(*gatewayConfig)
+		if err != nil {
+			log.Fatalf("failed to load gateway config: %v", err)
+		}
+		g := gateway.New(config)
+		if *leaderElect {
+			// the replicas of the gateway elect the one scraping the fleet
+			g.SetStandby(true)
+			leader.OnStartedLeading(func() { g.SetStandby(false) })
+			leader.OnStoppedLeading(func() { g.SetStandby(true) })
+		}
+		err = prometheus.Register(g)
+		if err != nil {
+			log.Fatalf("failed to register gateway: %v", err)
+		}
+	} else {
+		if len(*sampleHookScript) > 0 {
+			hook, err := script.Load(*sampleHookScript)
+			if err != nil {
+				log.Fatalf("failed to load sample hook: %v", err)
+			}
+			collector.OnSample(hook.Run)
+			if err = prometheus.Register(hook); err != nil {
+				log.Fatalf("failed to register sample hook: %v", err)
+			}
+		}
+		if *historyRetention > 0 {
+			db := history.New(*historyRetention)
+			collector.OnSample(db.Record)
+			collector.OnFlush(db.Save)
+			api.RegisterQuery(db)
+			api.RegisterAdvisor(db)
+		}
+		if *enableDashboard {
+			collector.OnSample(api.UpdatePower)
+			api.RegisterDashboard()
+		}
+		if *enableSampleAPI {
+			api.RegisterSample()
+		}
+		if *enableEnergyAPI {
+			if err = api.SetEnergyWindow(*energyAPIWindow); err != nil {
+				log.Fatalf("failed to set the energy window: %v", err)
+			}
+			collector.OnSample(api.UpdateEnergy)
+			api.RegisterEnergy()
+		}
+		if *enableSessionAPI {
+			api.RegisterSessions()
+		}
+		if *enableAttestation {
+			api.RegisterAttestation()
+		}
+		if *governorAdvice {
+			advisor, err := governor.New(*governorWindow, *governorAutoTune)
+			if err != nil {
+				log.Printf("failed to start the governor advisor: %v\n", err)
+			} else {
+				collector.OnSample(advisor.Record)
+				api.RegisterGovernor(advisor)
+			}
+		}
+		if len(*groupingRules) > 0 {
+			config, err := grouping.LoadConfig(*groupingRules)
+			if err != nil {
+				log.Fatalf("failed to load grouping rules: %v", err)
+			}
+			grouper := grouping.New(config)
+			collector.OnSample(grouper.Update)
+			if err = prometheus.Register(grouper); err != nil {
+				log.Fatalf("failed to register grouping: %v", err)
+			}
+		}
+		if *imageMetrics {
+			aggregator := images.New()
+			collector.OnSample(aggregator.Update)
+			if err := prometheus.Register(aggregator); err != nil {
+				log.Fatalf("failed to register image metrics: %v", err)
+			}
+		}
+		if len(*trendWindows) > 0 {
+			windows, err := trend.ParseWindows(*trendWindows)
+			if err != nil {
+				log.Fatalf("failed to parse trend windows: %v", err)
+			}
+			trends := trend.New(windows)
+			collector.OnSample(trends.Update)
+			if err = prometheus.Register(trends); err != nil {
+				log.Fatalf("failed to register trends: %v", err)
+			}
+		}
+		if *enableResources {
+			enricher, err := resources.New()
+			if err != nil {
+				log.Fatalf("failed to create resource enrichment: %v", err)
+			}
+			enricher.Run()
+			collector.OnSample(enricher.Update)
+			if err = prometheus.Register(enricher); err != nil {
+				log.Fatalf("failed to register resource enrichment: %v", err)
+			}
+		}
+		if *startupMetrics {
+			tracker := startup.New()
+			tracker.Run()
+			collector.OnSample(tracker.Update)
+			if err = prometheus.Register(tracker); err != nil {
+				log.Fatalf("failed to register startup metrics: %v", err)
+			}
+		}
+		if len(*modbusAddress) > 0 {
+			server := modbus.New()
+			if err = server.ListenAndServe(*modbusAddress); err != nil {
+				log.Fatalf("failed to start modbus server: %v", err)
+			}
+			collector.OnSample(server.Update)
+		}
+		if len(*snmpAddress) > 0 {
+			agent := snmp.New(*snmpCommunity)
+			if err = agent.ListenAndServe(*snmpAddress); err != nil {
+				log.Fatalf("failed to start snmp agent: %v", err)
+			}
+			collector.OnSample(agent.Update)
+		}
+		if len(*grpcAddress) > 0 {
+			server := stream.New()
+			if err = server.ListenAndServe(*grpcAddress); err != nil {
+				log.Fatalf("failed to start grpc server: %v", err)
+			}
+			defer server.Stop()
+			collector.OnSample(server.Update)
+		}
+		if len(*profileContainer) > 0 {
+			profile, err := profiler.New(*profileContainer, *profileOutput, *profileFrequency)
+			if err != nil {
+				log.Fatalf("failed to start the energy profile: %v", err)
+			}
+			defer profile.Close()
+			collector.OnSample(profile.Update)
+		}
+		cfg, err := config.Load(*configPath)
+		if err != nil {
+			log.Fatalf("failed to load config: %v", err)
+		}
+		if err = pod_lister.SetContainerRuntime(cfg.ContainerRuntime.Type, cfg.ContainerRuntime.Socket); err != nil {
+			log.Fatalf("failed to set the container runtime: %v", err)
+		}
+		dashboardUnits, err := units.New(cfg.Units.Energy, cfg.Units.Power, cfg.Units.Locale)
+		if err != nil {
+			log.Fatalf("failed to set the units: %v", err)
+		}
+		api.SetDashboardUnits(dashboardUnits)
+		period, err := loadSamplePeriod()
+		if err != nil {
+			log.Fatalf("failed to load config: %v", err)
+		}
+		platformMeter, err := platform.New(&cfg.PlatformMeter, period, "kepler-"+collector.EdgeDeviceName+"-plug")
+		if err != nil {
+			log.Fatalf("failed to create platform power meter: %v", err)
+		}
+		if platformMeter != nil {
+			log.Printf("reading the node power from %s\n", platformMeter.Name())
+			platformMeter.Run()
+			collector.SetHostPowerMeter(platformMeter)
+		}
+		collector.SetSamplePeriod(period)
+		collector.SetAttestedSettings(attestedSettings(p))
+		reload := make(chan os.Signal, 1)
+		signal.Notify(reload, syscall.SIGHUP)
+		go func() {
+			for range reload {
+				log.Printf("reloading %s\n", *configPath)
+				period, err := loadSamplePeriod()
+				if err != nil {
+					log.Printf("failed to reload config: %v\n", err)
+					continue
+				}
+				collector.SetSamplePeriod(period)
+				collector.SetAttestedSettings(attestedSettings(p))
+			}
+		}()
+		var samples *spool.Spool
+		if len(*spoolDir) > 0 {
+			samples, err = spool.New(*spoolDir, *spoolMaxBytes, *spoolRetention)
+			if err != nil {
+				log.Fatalf("failed to open the spool: %v", err)
+			}
+			defer samples.Close()
+			collector.OnSample(samples.Append)
+		}
+		var mqttPublisher *publisher.Publisher
+		if len(*mqttBroker) > 0 {
+			clientID := *mqttClientID
+			if len(clientID) == 0 {
+				clientID = "kepler-" + collector.EdgeDeviceName
+			}
+			mqttPublisher, err = publisher.New(&publisher.Config{
+				Broker:       *mqttBroker,
+				Topic:        *mqttTopic,
+				QoS:          byte(*mqttQoS),
+				Format:       *mqttFormat,
+				ClientID:     clientID,
+				Username:     *mqttUsername,
+				PasswordFile: *mqttPasswordFile,
+				CAFile:       *mqttCAFile,
+				CertFile:     *mqttCertFile,
+				KeyFile:      *mqttKeyFile,
+			})
+			if err != nil {
+				log.Fatalf("failed to create MQTT publisher: %v", err)
+			}
+			mqttPublisher.Run()
+			defer mqttPublisher.Stop()
+			if samples != nil {
+				go samples.Run(context.Background(), "mqtt", mqttPublisher.Send)
+			} else {
+				collector.OnSample(mqttPublisher.Publish)
+			}
+		}
+		if len(*otlpEndpoint) > 0 {
+			otlp, err := publisher.NewOTLP(&publisher.OTLPConfig{
+				Endpoint:   *otlpEndpoint,
+				Headers:    *otlpHeaders,
+				Attributes: *otlpAttributes,
+			})
+			if err != nil {
+				log.Fatalf("failed to create OTLP exporter: %v", err)
+			}
+			otlp.Run()
+			if samples != nil {
+				go samples.Run(context.Background(), "otlp", otlp.Send)
+			} else {
+				collector.OnSample(otlp.Publish)
+			}
+		}
+		if *journalLog {
+			journal, err := publisher.NewJournal(&publisher.JournalConfig{
+				Socket:     publisher.DefaultJournalSocket,
+				Identifier: publisher.DefaultJournalIdentifier,
+				MinWatts:   *journalMinWatts,
+			})
+			if err != nil {
+				log.Fatalf("failed to create journal writer: %v", err)
+			}
+			collector.OnSample(journal.Publish)
+		}
+		if err = prometheus.Register(collector.NewExporter()); err != nil {
+			log.Fatalf("failed to register exporter: %v", err)
+		}
+		if err = prometheus.Register(rapl.NewBreakdown(collector.EdgeDeviceName)); err != nil {
+			log.Fatalf("failed to register RAPL breakdown: %v", err)
+		}
+		collector, err := collector.New()
+		if err != nil {
+			log.Fatalf("failed to create collector: %v", err)
+		}
+		err = collector.Start(context.Background())
+		if err != nil {
+			log.Fatalf("failed to attach : %v", err)
+		}
+		defer power.Shutdown()
+		defer collector.Stop()
+
+		err = prometheus.Register(collector)
+		if err != nil {
+			log.Fatalf("failed to register collector: %v", err)
+		}
+
+		// systemd and the kubelet graceful node shutdown (which holds the logind inhibitor lock)
+		// send SIGTERM before powering off, flush the last interval before exiting
+		shutdown := make(chan os.Signal, 1)
+		signal.Notify(shutdown, syscall.SIGTERM, syscall.SIGINT, syscall.SIGPWR)
+		go func() {
+			sig := <-shutdown
+			log.Printf("received %v, flushing the energy data\n", sig)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := metricsServer.Shutdown(ctx); err != nil {
+				log.Printf("failed to shut down the metrics server: %v\n", err)
+			}
+		}()
+	}
+
+	if *leaderElect {
+		if len(*leaderNamespace) == 0 {
+			*leaderNamespace = leader.DefaultNamespace()
+		}
+		if err = leader.Run(*leaderNamespace, *leaderLease); err != nil {
+			log.Fatalf("failed to start leader election: %v", err)
+		}
+		err = prometheus.Register(prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "kepler_aggregator_leader",
+			Help: "Whether this instance is the leader running the cluster level rollups.",
+		}, func() float64 {
+			if leader.IsLeader() {
+				return 1
+			}
+			return 0
+		}))
+		if err != nil {
+			log.Fatalf("failed to register leader metric: %v", err)
+		}
 	}
 
 	http.Handle(*metricsPath, promhttp.Handler())
+	dashboardLink := ""
+	if *enableDashboard && len(*gatewayConfig) == 0 {
+		dashboardLink = `<p><a href="` + api.DashboardPath + `">Dashboard</a></p>`
+	}
 	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		_, err = w.Write([]byte(`<html>
 			<head><title>Energy Stats Exporter</title></head>
 			<body>
 			<h1>Energy Stats Exporter</h1>
 			<p><a href="` + *metricsPath + `">Metrics</a></p>
+			` + dashboardLink + `
 			</body>
 			</html>`))
 		if err != nil {
@@ -86,8 +662,37 @@ func main() {
 		}
 	})
 
-	err = http.ListenAndServe(*address, nil)
-	if err != nil {
+	err = metricsServer.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
 		log.Fatalf("failed to bind on %s: %v", *address, err)
 	}
 }
+
+// loadSamplePeriod resolves the sample period from the flag, the environment and the config file
+func loadSamplePeriod() (time.Duration, error) {
+	c, err := config.Load(*configPath)
+	if err != nil {
+		return 0, err
+	}
+	return c.GetSamplePeriod(*samplePeriod)
+}
+
+// attestedSettings returns the flags, the hardware profile and the config file hash stated by the
+// attestation, without the SNMP community
+func attestedSettings(p *profile.Profile) map[string]string {
+	settings := map[string]string{}
+	flag.VisitAll(func(f *flag.Flag) {
+		settings[f.Name] = f.Value.String()
+	})
+	// the secrets are not attested
+	delete(settings, "snmp-community")
+	delete(settings, "otlp-headers")
+	if p != nil {
+		settings["hardware-profile"] = p.Name
+	}
+	if data, err := ioutil.ReadFile(*configPath); err == nil {
+		sum := sha256.Sum256(data)
+		settings["config-sha256"] = hex.EncodeToString(sum[:])
+	}
+	return settings
+}
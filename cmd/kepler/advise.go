@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+)
+
+// advise prints the scale-to-zero and consolidation candidates of the advisor of the exporter, with
+// the energy they would save over the analyzed window.
+type advisorReport struct {
+	Start           time.Time `json:"start"`
+	End             time.Time `json:"end"`
+	Recommendations []struct {
+		Namespace          string  `json:"namespace"`
+		Name               string  `json:"name"`
+		Action             string  `json:"action"`
+		AverageWatts       float64 `json:"average_watts"`
+		IdleWatts          float64 `json:"idle_watts"`
+		IdleRatio          float64 `json:"idle_ratio"`
+		AverageCores       float64 `json:"average_cores"`
+		Activations        int     `json:"activations"`
+		LongestIdleSeconds float64 `json:"longest_idle_seconds"`
+		SavingsJoules      float64 `json:"savings_joules"`
+		SavingsWatts       float64 `json:"savings_watts"`
+	} `json:"recommendations"`
+	TotalSavingsJoules float64 `json:"total_savings_joules"`
+	TotalSavingsWatts  float64 `json:"total_savings_watts"`
+}
+
+func advise(args []string) int {
+	flags := flag.NewFlagSet("advise", flag.ExitOnError)
+	server := flags.String("server", defaultServer, "address of the exporter serving the advisor API")
+	window := flags.Duration("window", 24*time.Hour, "history analyzed, up to the history retention of the exporter")
+	cooldown := flags.Duration("cooldown", 15*time.Minute, "idle time before a workload would be scaled to zero")
+	idleCores := flags.Float64("idle-cores", 0.01, "CPU usage in cores below which a workload is idle")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	format := unitFlags(flags)
+	_ = flags.Parse(args)
+	f, err := format()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+
+	params := url.Values{}
+	params.Set("window", window.String())
+	params.Set("cooldown", cooldown.String())
+	params.Set("idle_cores", fmt.Sprintf("%g", *idleCores))
+	report, err := getAdvisorReport(*server, params)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(report); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the report: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	fmt.Printf("%s to %s\n\n", report.Start.Format(time.RFC3339), report.End.Format(time.RFC3339))
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	e, p := f.EnergyUnit(), f.PowerUnit()
+	fmt.Fprintf(w, "WORKLOAD\tACTION\tAVG (%s)\tIDLE (%s)\tIDLE\tCOLD STARTS\tSAVINGS (%s)\tSAVINGS (%s)\n", p, p, e, p)
+	for _, r := range report.Recommendations {
+		fmt.Fprintf(w, "%s/%s\t%s\t%s\t%s\t%s%%\t%d\t%s\t%s\n", r.Namespace, r.Name, r.Action,
+			f.Power(r.AverageWatts, 3), f.Power(r.IdleWatts, 3), f.Number(100*r.IdleRatio, 0), r.Activations,
+			f.Energy(r.SavingsJoules, 1), f.Power(r.SavingsWatts, 3))
+	}
+	w.Flush()
+	fmt.Printf("\ntotal savings: %s %s (%s %s)\n", f.Energy(report.TotalSavingsJoules, 1), e, f.Power(report.TotalSavingsWatts, 3), p)
+	return 0
+}
+
+func getAdvisorReport(server string, params url.Values) (*advisorReport, error) {
+	client := &http.Client{Timeout: queryTimeout}
+	resp, err := client.Get(server + "/api/v1/advisor?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response from %q: %v", server, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	r := &struct {
+		Status string        `json:"status"`
+		Error  string        `json:"error"`
+		Data   advisorReport `json:"data"`
+	}{}
+	if err = json.Unmarshal(body, r); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("advisor failed: %s", r.Error)
+	}
+	return &r.Data, nil
+}
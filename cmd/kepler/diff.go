@@ -0,0 +1,230 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// diff compares the energy of each workload between two time ranges, e.g. before and after an
+// optimization was deployed. The ranges may have different lengths, the workloads are compared by
+// their average power.
+const nodeWorkload = "(node)"
+
+type timeRange struct {
+	start, end time.Time
+}
+
+type workloadDiff struct {
+	Workload string  `json:"workload"`
+	EnergyA  float64 `json:"energy_a_joules"`
+	PowerA   float64 `json:"power_a_watts"`
+	EnergyB  float64 `json:"energy_b_joules"`
+	PowerB   float64 `json:"power_b_watts"`
+	Change   float64 `json:"change_percent"`
+	// New and Gone are the workloads running in only one of the ranges
+	New         bool `json:"new"`
+	Gone        bool `json:"gone"`
+	Significant bool `json:"significant"`
+}
+
+func diff(args []string) int {
+	flags := flag.NewFlagSet("diff", flag.ExitOnError)
+	server := flags.String("server", defaultServer, "address of the exporter serving the query API")
+	threshold := flags.Float64("threshold", 10, "relative change of the average power in % reported as significant")
+	minWatts := flags.Float64("min-watts", 0.1, "absolute change of the average power in W below which a change is not significant")
+	all := flags.Bool("all", false, "list all the workloads, not only the significant changes")
+	jsonOutput := flags.Bool("json", false, "print the comparison as JSON")
+	format := unitFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: kepler diff [flags] <rangeA> <rangeB>\n\n")
+		fmt.Fprintf(os.Stderr, "a range is START..END, each an RFC 3339 time, \"now\" or a duration before now, e.g. -26h..-24h\n")
+		fmt.Fprintf(os.Stderr, "the ranges must be within the history retention of the exporter (--history-retention)\n\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if flags.NArg() != 2 {
+		flags.Usage()
+		return 2
+	}
+	f, err := format()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	now := time.Now()
+	ranges := make([]timeRange, 2)
+	for i, arg := range flags.Args() {
+		r, err := parseRange(arg, now)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid range %q: %v\n", arg, err)
+			return 2
+		}
+		ranges[i] = r
+	}
+
+	energyA, err := rangeEnergy(*server, ranges[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	energyB, err := rangeEnergy(*server, ranges[1])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	diffs := compare(energyA, energyB, ranges[0], ranges[1], *threshold, *minWatts)
+
+	if *jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err = encoder.Encode(diffs); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write the comparison: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	e, p := f.EnergyUnit(), f.PowerUnit()
+	fmt.Fprintf(w, "WORKLOAD\tA (%s)\tA (%s)\tB (%s)\tB (%s)\tCHANGE\n", e, p, e, p)
+	for _, d := range diffs {
+		if !*all && !d.Significant && d.Workload != nodeWorkload {
+			continue
+		}
+		change := f.Number(d.Change, 1) + "%"
+		if d.Change >= 0 {
+			change = "+" + change
+		}
+		if d.New {
+			change = "new"
+		} else if d.Gone {
+			change = "gone"
+		}
+		if d.Significant {
+			change += " *"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", d.Workload, f.Energy(d.EnergyA, 1), f.Power(d.PowerA, 3),
+			f.Energy(d.EnergyB, 1), f.Power(d.PowerB, 3), change)
+	}
+	w.Flush()
+	return 0
+}
+
+// parseRange parses START..END
+func parseRange(s string, now time.Time) (timeRange, error) {
+	parts := strings.Split(s, "..")
+	if len(parts) != 2 {
+		return timeRange{}, fmt.Errorf("expected START..END")
+	}
+	start, err := parseRangeTime(parts[0], now)
+	if err != nil {
+		return timeRange{}, err
+	}
+	end, err := parseRangeTime(parts[1], now)
+	if err != nil {
+		return timeRange{}, err
+	}
+	if !end.After(start) {
+		return timeRange{}, fmt.Errorf("the end is not after the start")
+	}
+	return timeRange{start: start, end: end}, nil
+}
+
+func parseRangeTime(s string, now time.Time) (time.Time, error) {
+	if s == "now" {
+		return now, nil
+	}
+	if strings.HasPrefix(s, "-") {
+		d, err := time.ParseDuration(s[1:])
+		if err != nil {
+			return time.Time{}, err
+		}
+		return now.Add(-d), nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
+
+// rangeEnergy returns the energy in J of the node and of each namespace/container over the range
+func rangeEnergy(server string, r timeRange) (map[string]float64, error) {
+	window := fmt.Sprintf("[%ds]", int64(r.end.Sub(r.start).Seconds()))
+	energy := map[string]float64{}
+	samples, err := query(server, "sum(increase(node_energy_joule_total"+window+"))", r.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range samples {
+		energy[nodeWorkload] = s.value()
+	}
+	samples, err = query(server, "increase(container_energy_joule_total"+window+")", r.end)
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range samples {
+		energy[s.Metric["container_namespace"]+"/"+s.Metric["container_name"]] += s.value()
+	}
+	return energy, nil
+}
+
+// compare returns the workloads of both ranges, the node first then by decreasing power change
+func compare(energyA, energyB map[string]float64, a, b timeRange, threshold, minWatts float64) []workloadDiff {
+	secondsA, secondsB := a.end.Sub(a.start).Seconds(), b.end.Sub(b.start).Seconds()
+	workloads := map[string]bool{}
+	for w := range energyA {
+		workloads[w] = true
+	}
+	for w := range energyB {
+		workloads[w] = true
+	}
+	diffs := make([]workloadDiff, 0, len(workloads))
+	for w := range workloads {
+		d := workloadDiff{
+			Workload: w,
+			EnergyA:  energyA[w],
+			PowerA:   energyA[w] / secondsA,
+			EnergyB:  energyB[w],
+			PowerB:   energyB[w] / secondsB,
+		}
+		delta := d.PowerB - d.PowerA
+		_, inA := energyA[w]
+		_, inB := energyB[w]
+		d.New, d.Gone = !inA, !inB
+		if d.PowerA > 0 {
+			d.Change = 100 * delta / d.PowerA
+		}
+		d.Significant = math.Abs(delta) >= minWatts && (d.New || math.Abs(d.Change) >= threshold)
+		diffs = append(diffs, d)
+	}
+	sort.Slice(diffs, func(i, j int) bool {
+		if (diffs[i].Workload == nodeWorkload) != (diffs[j].Workload == nodeWorkload) {
+			return diffs[i].Workload == nodeWorkload
+		}
+		di, dj := math.Abs(diffs[i].PowerB-diffs[i].PowerA), math.Abs(diffs[j].PowerB-diffs[j].PowerA)
+		if di != dj {
+			return di > dj
+		}
+		return diffs[i].Workload < diffs[j].Workload
+	})
+	return diffs
+}
@@ -0,0 +1,279 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/exec"
+	"regexp"
+	"time"
+)
+
+// gate measures the energy of a workload while a test command runs (or for a duration) and compares
+// it with a budget, for the energy regression gates of the hardware-in-the-loop CI. The energy is
+// integrated from the live power of the exporter samples overlapping the run.
+const (
+	gatePass        = 0
+	gateOverBudget  = 1
+	gateUsage       = 2
+	gateError       = 3
+	gateTestFailure = 4
+
+	pollInterval = time.Second
+	// the exporter samples every 3s, the sample covering the end of the run is awaited this long
+	lastSampleTimeout = 10 * time.Second
+)
+
+type livePower struct {
+	Status string `json:"status"`
+	Data   struct {
+		Time       time.Time `json:"time"`
+		Containers []struct {
+			Name      string  `json:"name"`
+			Namespace string  `json:"namespace"`
+			Total     float64 `json:"total"`
+		} `json:"containers"`
+	} `json:"data"`
+}
+
+// powerSample is the power in W of the matching containers over (prev, time]
+type powerSample struct {
+	prev, time time.Time
+	watts      float64
+	matched    bool
+}
+
+type verdict struct {
+	Workload        string  `json:"workload"`
+	BudgetJoules    float64 `json:"budget_joules"`
+	EnergyJoules    float64 `json:"energy_joules"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	AverageWatts    float64 `json:"average_watts"`
+	Samples         int     `json:"samples"`
+	Verdict         string  `json:"verdict"`
+	Error           string  `json:"error,omitempty"`
+	CommandExitCode *int    `json:"command_exit_code,omitempty"`
+}
+
+func gate(args []string) int {
+	flags := flag.NewFlagSet("gate", flag.ExitOnError)
+	server := flags.String("server", defaultServer, "address of the exporter serving the live power API (--enable-dashboard)")
+	workload := flags.String("workload", "", "regexp matching the namespace/container of the measured workload")
+	budget := flags.Float64("budget-joules", 0, "energy budget of the workload in J")
+	duration := flags.Duration("duration", 0, "measure for this duration instead of running a command")
+	output := flags.String("output", "", "file the JSON verdict is written to, in addition to stdout")
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: kepler gate --workload <regexp> --budget-joules <J> [flags] (-- <command> [args] | --duration <d>)\n\n")
+		fmt.Fprintf(os.Stderr, "exit codes: 0 within budget, 1 over budget, 2 usage, 3 measurement error, 4 command failed\n\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	command := flags.Args()
+	if len(*workload) == 0 || *budget <= 0 || (len(command) == 0) == (*duration == 0) {
+		flags.Usage()
+		return gateUsage
+	}
+	re, err := regexp.Compile("^(?:" + *workload + ")$")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid workload regexp: %v\n", err)
+		return gateUsage
+	}
+
+	v := &verdict{Workload: *workload, BudgetJoules: *budget}
+	code := measure(*server, re, command, *duration, v)
+	data, _ := json.MarshalIndent(v, "", "  ")
+	fmt.Println(string(data))
+	if len(*output) > 0 {
+		if err = ioutil.WriteFile(*output, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+			return gateError
+		}
+	}
+	return code
+}
+
+// measure runs the command (or waits for the duration) while polling the live power, it fills the verdict
+func measure(server string, workload *regexp.Regexp, command []string, duration time.Duration, v *verdict) int {
+	fail := func(code int, err error) int {
+		v.Verdict = "error"
+		v.Error = err.Error()
+		return code
+	}
+	samples := make(chan powerSample)
+	stop := make(chan struct{})
+	errs := make(chan error, 1)
+	go poll(server, workload, samples, stop, errs)
+	defer close(stop)
+
+	// the run starts once the sampling is known to work
+	select {
+	case <-samples:
+	case err := <-errs:
+		return fail(gateError, err)
+	case <-time.After(lastSampleTimeout):
+		return fail(gateError, fmt.Errorf("no sample from %s", server))
+	}
+	start := time.Now()
+	done := make(chan error, 1)
+	if len(command) > 0 {
+		cmd := exec.Command(command[0], command[1:]...)
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+		if err := cmd.Start(); err != nil {
+			return fail(gateError, fmt.Errorf("failed to start %s: %v", command[0], err))
+		}
+		go func() { done <- cmd.Wait() }()
+	} else {
+		go func() {
+			time.Sleep(duration)
+			done <- nil
+		}()
+	}
+
+	var collected []powerSample
+	var end time.Time
+	var commandErr error
+	for end.IsZero() {
+		select {
+		case s := <-samples:
+			collected = append(collected, s)
+		case err := <-errs:
+			return fail(gateError, err)
+		case commandErr = <-done:
+			end = time.Now()
+		}
+	}
+	timeout := time.After(lastSampleTimeout)
+	for len(collected) == 0 || collected[len(collected)-1].time.Before(end) {
+		select {
+		case s := <-samples:
+			collected = append(collected, s)
+		case err := <-errs:
+			return fail(gateError, err)
+		case <-timeout:
+			return fail(gateError, fmt.Errorf("no sample after the end of the run"))
+		}
+	}
+
+	matched := false
+	for _, s := range collected {
+		// the energy of a sample is prorated by its overlap with the run
+		overlap := math.Min(s.time.Sub(start).Seconds(), s.time.Sub(s.prev).Seconds()) -
+			math.Max(s.time.Sub(end).Seconds(), 0)
+		if overlap <= 0 {
+			continue
+		}
+		v.EnergyJoules += s.watts * overlap
+		v.Samples++
+		matched = matched || s.matched
+	}
+	v.DurationSeconds = end.Sub(start).Seconds()
+	if v.DurationSeconds > 0 {
+		v.AverageWatts = v.EnergyJoules / v.DurationSeconds
+	}
+
+	if commandErr != nil {
+		exitCode := -1
+		if exitErr, ok := commandErr.(*exec.ExitError); ok {
+			exitCode = exitErr.ExitCode()
+		}
+		v.CommandExitCode = &exitCode
+		return fail(gateTestFailure, fmt.Errorf("command failed: %v", commandErr))
+	}
+	if len(command) > 0 {
+		exitCode := 0
+		v.CommandExitCode = &exitCode
+	}
+	if !matched {
+		return fail(gateError, fmt.Errorf("no container matching %s ran during the measurement", workload))
+	}
+	if v.EnergyJoules > v.BudgetJoules {
+		v.Verdict = "fail"
+		return gateOverBudget
+	}
+	v.Verdict = "pass"
+	return gatePass
+}
+
+// poll sends each new sample of the exporter, with the power of the containers matching the workload
+func poll(server string, workload *regexp.Regexp, samples chan<- powerSample, stop <-chan struct{}, errs chan<- error) {
+	client := &http.Client{Timeout: pollInterval * 5}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	var last time.Time
+	for {
+		p, err := getLivePower(client, server)
+		if err != nil {
+			errs <- err
+			return
+		}
+		if p.Data.Time.After(last) {
+			s := powerSample{prev: last, time: p.Data.Time}
+			for _, c := range p.Data.Containers {
+				if workload.MatchString(c.Namespace + "/" + c.Name) {
+					s.watts += c.Total
+					s.matched = true
+				}
+			}
+			if !last.IsZero() {
+				select {
+				case samples <- s:
+				case <-stop:
+					return
+				}
+			} else {
+				// the first sample only gives the start of the next interval
+				select {
+				case samples <- powerSample{}:
+				case <-stop:
+					return
+				}
+			}
+			last = p.Data.Time
+		}
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+func getLivePower(client *http.Client, server string) (*livePower, error) {
+	resp, err := client.Get(server + "/api/v1/power")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response from %q: %v", server, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	p := &livePower{}
+	if err = json.Unmarshal(body, p); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	if p.Status != "success" {
+		return nil, fmt.Errorf("no live power from %q, is the exporter running with --enable-dashboard?", server)
+	}
+	return p, nil
+}
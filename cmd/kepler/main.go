@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/units"
+)
+
+// kepler is the command line client of the exporter running on the device, it reads the local
+// history through the query API.
+const (
+	defaultServer = "http://localhost:8888"
+	queryTimeout  = 30 * time.Second
+
+	// the units and the locale of the reports, overridden by the flags
+	energyUnitEnv = "KEPLER_ENERGY_UNIT"
+	powerUnitEnv  = "KEPLER_POWER_UNIT"
+	localeEnv     = "KEPLER_LOCALE"
+)
+
+var commands = map[string]func(args []string) int{
+	"advise": advise,
+	"diff":   diff,
+	"gate":   gate,
+	"tune":   tune,
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: kepler <command> [flags]\n\ncommands:\n")
+	fmt.Fprintf(os.Stderr, "  advise  recommend the idle workloads to scale to zero or consolidate\n")
+	fmt.Fprintf(os.Stderr, "  diff    compare the energy per workload between two time ranges\n")
+	fmt.Fprintf(os.Stderr, "  gate    measure the energy of a workload during a test run against a budget\n")
+	fmt.Fprintf(os.Stderr, "  tune    sweep the CPU frequency caps or governors and report the energy and latency tradeoff\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	os.Exit(command(os.Args[2:]))
+}
+
+type sample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+type queryResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error"`
+	Data   struct {
+		ResultType string          `json:"resultType"`
+		Result     json.RawMessage `json:"result"`
+	} `json:"data"`
+}
+
+// query evaluates an instant query at t on the exporter, it returns the value per labels
+func query(server, q string, t time.Time) ([]sample, error) {
+	params := url.Values{}
+	params.Set("query", q)
+	params.Set("time", strconv.FormatInt(t.Unix(), 10))
+	client := &http.Client{Timeout: queryTimeout}
+	resp, err := client.Get(server + "/api/v1/query?" + params.Encode())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response from %q: %v", server, err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	r := &queryResponse{}
+	if err = json.Unmarshal(body, r); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	if r.Status != "success" {
+		return nil, fmt.Errorf("query %q failed: %s", q, r.Error)
+	}
+	if r.Data.ResultType != "vector" {
+		return nil, fmt.Errorf("query %q returned a %s", q, r.Data.ResultType)
+	}
+	samples := []sample{}
+	if err = json.Unmarshal(r.Data.Result, &samples); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	return samples, nil
+}
+
+// value returns the value of a sample
+func (s sample) value() float64 {
+	str, _ := s.Value[1].(string)
+	v, _ := strconv.ParseFloat(str, 64)
+	return v
+}
+
+// unitFlags adds the flags of the units and the locale of the printed reports, the JSON reports stay in
+// J and W
+func unitFlags(flags *flag.FlagSet) func() (*units.Format, error) {
+	energy := flags.String("energy-unit", os.Getenv(energyUnitEnv), "unit of the printed energies: mJ, J, kJ, Wh, kWh, MWh or BTU (default J, $"+energyUnitEnv+")")
+	power := flags.String("power-unit", os.Getenv(powerUnitEnv), "unit of the printed powers: mW, W, kW or BTU/h (default W, $"+powerUnitEnv+")")
+	locale := flags.String("locale", os.Getenv(localeEnv), "locale of the printed numbers, e.g. de_DE (default C, $"+localeEnv+")")
+	return func() (*units.Format, error) {
+		return units.New(*energy, *power, *locale)
+	}
+}
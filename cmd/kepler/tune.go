@@ -0,0 +1,394 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"text/tabwriter"
+	"time"
+)
+
+// tune sweeps the CPU frequency caps or the cpufreq governors while a probe loads the workload through
+// its URL, and reports the energy, the throughput and the latency of each setting. The Pareto front of
+// the energy per request and the p95 latency are the settings worth choosing from when tuning an edge
+// device. It runs as root on the device, the caps and governors are restored at the end.
+const (
+	cpufreqGlob = "/sys/devices/system/cpu/cpu[0-9]*/cpufreq"
+	// the settings are applied for this long before the measurement, for the workload to settle
+	defaultSettle = 10 * time.Second
+)
+
+// tuneSetting is a governor, or a cap of the max frequency in kHz, 0 for the max of the CPU
+type tuneSetting struct {
+	name       string
+	governor   string
+	maxFreqKHz uint64
+}
+
+type tuneResult struct {
+	Setting          string  `json:"setting"`
+	EnergyJoules     float64 `json:"energy_joules"`
+	AverageWatts     float64 `json:"average_watts"`
+	DurationSeconds  float64 `json:"duration_seconds"`
+	Requests         int     `json:"requests"`
+	Errors           int     `json:"errors"`
+	ThroughputRPS    float64 `json:"throughput_rps"`
+	JoulesPerRequest float64 `json:"joules_per_request"`
+	LatencyP50Ms     float64 `json:"latency_p50_ms"`
+	LatencyP95Ms     float64 `json:"latency_p95_ms"`
+	LatencyP99Ms     float64 `json:"latency_p99_ms"`
+	Pareto           bool    `json:"pareto"`
+	Error            string  `json:"error,omitempty"`
+}
+
+type tuneReport struct {
+	Workload string       `json:"workload"`
+	ProbeURL string       `json:"probe_url"`
+	Results  []tuneResult `json:"results"`
+}
+
+// cpufreqPolicy is the governor and the max frequency of a CPU, restored at the end
+type cpufreqPolicy struct {
+	governor string
+	maxFreq  string
+}
+
+func tune(args []string) int {
+	flags := flag.NewFlagSet("tune", flag.ExitOnError)
+	server := flags.String("server", defaultServer, "address of the exporter serving the live power API (--enable-dashboard)")
+	workload := flags.String("workload", "", "regexp matching the namespace/container of the measured workload")
+	probeURL := flags.String("probe-url", "", "URL of the workload requested by the probe, its latency and throughput are measured")
+	freqCaps := flags.String("freq-caps", "", "comma separated caps of the CPU frequency in MHz swept, max for no cap, e.g. 1200,1800,max")
+	governors := flags.String("governors", "", "comma separated cpufreq governors swept instead of the caps, e.g. powersave,schedutil,performance")
+	step := flags.Duration("step-duration", time.Minute, "measurement of each setting")
+	settle := flags.Duration("settle", defaultSettle, "wait after applying a setting before measuring")
+	concurrency := flags.Int("concurrency", 4, "concurrent requests of the probe")
+	timeout := flags.Duration("probe-timeout", 5*time.Second, "timeout of a probe request, counted as an error")
+	jsonOutput := flags.Bool("json", false, "print the report as JSON")
+	output := flags.String("output", "", "file the JSON report is written to, in addition to stdout")
+	format := unitFlags(flags)
+	flags.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: kepler tune --workload <regexp> --probe-url <url> (--freq-caps <MHz,...> | --governors <governor,...>) [flags]\n\n")
+		flags.PrintDefaults()
+	}
+	_ = flags.Parse(args)
+	if len(*workload) == 0 || len(*probeURL) == 0 || (len(*freqCaps) == 0) == (len(*governors) == 0) || *concurrency < 1 || *step <= 0 {
+		flags.Usage()
+		return 2
+	}
+	f, err := format()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	re, err := regexp.Compile("^(?:" + *workload + ")$")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid workload regexp: %v\n", err)
+		return 2
+	}
+	settings, err := parseSettings(*freqCaps, *governors)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 2
+	}
+	dirs, _ := filepath.Glob(cpufreqGlob)
+	if len(dirs) == 0 {
+		fmt.Fprintf(os.Stderr, "no cpufreq policy, the CPU frequency can not be set\n")
+		return 1
+	}
+	saved, err := saveCPUFreq(dirs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	// the original settings are restored on interruption as well
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupted
+		restoreCPUFreq(saved)
+		os.Exit(1)
+	}()
+	defer restoreCPUFreq(saved)
+
+	report := &tuneReport{Workload: *workload, ProbeURL: *probeURL}
+	client := &http.Client{Timeout: *timeout}
+	for _, s := range settings {
+		fmt.Fprintf(os.Stderr, "measuring %s\n", s.name)
+		r := tuneResult{Setting: s.name}
+		if err = applySetting(dirs, s); err != nil {
+			r.Error = err.Error()
+			report.Results = append(report.Results, r)
+			continue
+		}
+		time.Sleep(*settle)
+		measureSetting(*server, re, client, *probeURL, *concurrency, *step, &r)
+		report.Results = append(report.Results, r)
+	}
+	markPareto(report.Results)
+
+	data, _ := json.MarshalIndent(report, "", "  ")
+	if len(*output) > 0 {
+		if err = ioutil.WriteFile(*output, append(data, '\n'), 0644); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s: %v\n", *output, err)
+			return 1
+		}
+	}
+	if *jsonOutput {
+		fmt.Println(string(data))
+		return 0
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	e, p := f.EnergyUnit(), f.PowerUnit()
+	fmt.Fprintf(w, "SETTING\tAVG (%s)\tREQ/S\tENERGY/REQ (%s)\tP50 (ms)\tP95 (ms)\tP99 (ms)\tERRORS\tPARETO\n", p, e)
+	for _, r := range report.Results {
+		if len(r.Error) > 0 {
+			fmt.Fprintf(w, "%s\t%s\n", r.Setting, r.Error)
+			continue
+		}
+		pareto := ""
+		if r.Pareto {
+			pareto = "*"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%d\t%s\n", r.Setting, f.Power(r.AverageWatts, 3),
+			f.Number(r.ThroughputRPS, 1), f.Energy(r.JoulesPerRequest, 4), f.Number(r.LatencyP50Ms, 1),
+			f.Number(r.LatencyP95Ms, 1), f.Number(r.LatencyP99Ms, 1), r.Errors, pareto)
+	}
+	w.Flush()
+	return 0
+}
+
+// parseSettings returns the swept settings, the caps are in MHz
+func parseSettings(freqCaps, governors string) ([]tuneSetting, error) {
+	settings := []tuneSetting{}
+	for _, g := range strings.Split(governors, ",") {
+		if g = strings.TrimSpace(g); len(g) > 0 {
+			settings = append(settings, tuneSetting{name: g, governor: g})
+		}
+	}
+	for _, c := range strings.Split(freqCaps, ",") {
+		c = strings.TrimSpace(c)
+		switch {
+		case len(c) == 0:
+		case c == "max":
+			settings = append(settings, tuneSetting{name: "max"})
+		default:
+			mhz, err := strconv.ParseUint(c, 10, 64)
+			if err != nil || mhz == 0 {
+				return nil, fmt.Errorf("invalid frequency cap %q in MHz", c)
+			}
+			settings = append(settings, tuneSetting{name: c + "MHz", maxFreqKHz: mhz * 1000})
+		}
+	}
+	if len(settings) == 0 {
+		return nil, fmt.Errorf("no setting to sweep")
+	}
+	return settings, nil
+}
+
+func readCPUFreqFile(dir, name string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", name, err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeCPUFreqFile(dir, name, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+		return fmt.Errorf("failed to write %s of %s: %v", name, dir, err)
+	}
+	return nil
+}
+
+func saveCPUFreq(dirs []string) (map[string]cpufreqPolicy, error) {
+	saved := map[string]cpufreqPolicy{}
+	for _, dir := range dirs {
+		governor, err := readCPUFreqFile(dir, "scaling_governor")
+		if err != nil {
+			return nil, err
+		}
+		maxFreq, err := readCPUFreqFile(dir, "scaling_max_freq")
+		if err != nil {
+			return nil, err
+		}
+		saved[dir] = cpufreqPolicy{governor: governor, maxFreq: maxFreq}
+	}
+	return saved, nil
+}
+
+func restoreCPUFreq(saved map[string]cpufreqPolicy) {
+	for dir, p := range saved {
+		if err := writeCPUFreqFile(dir, "scaling_governor", p.governor); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+		if err := writeCPUFreqFile(dir, "scaling_max_freq", p.maxFreq); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+		}
+	}
+}
+
+// applySetting sets the governor, or caps the max frequency of every CPU to the cap or to the max of the CPU
+func applySetting(dirs []string, s tuneSetting) error {
+	for _, dir := range dirs {
+		if len(s.governor) > 0 {
+			if err := writeCPUFreqFile(dir, "scaling_governor", s.governor); err != nil {
+				return err
+			}
+			continue
+		}
+		cpuMax, err := readCPUFreqFile(dir, "cpuinfo_max_freq")
+		if err != nil {
+			return err
+		}
+		maxFreq, err := strconv.ParseUint(cpuMax, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid cpuinfo_max_freq %q", cpuMax)
+		}
+		if s.maxFreqKHz > 0 && s.maxFreqKHz < maxFreq {
+			maxFreq = s.maxFreqKHz
+		}
+		if err = writeCPUFreqFile(dir, "scaling_max_freq", strconv.FormatUint(maxFreq, 10)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// measureSetting loads the workload with the probe while measuring its energy
+func measureSetting(server string, workload *regexp.Regexp, client *http.Client, probeURL string, concurrency int, step time.Duration, r *tuneResult) {
+	stop := make(chan struct{})
+	done := make(chan probeStats, 1)
+	go func() { done <- probe(client, probeURL, concurrency, stop) }()
+	v := &verdict{BudgetJoules: math.Inf(1)}
+	code := measure(server, workload, nil, step, v)
+	close(stop)
+	stats := <-done
+	if code != gatePass {
+		r.Error = v.Error
+		return
+	}
+	r.EnergyJoules, r.AverageWatts, r.DurationSeconds = v.EnergyJoules, v.AverageWatts, v.DurationSeconds
+	r.Requests, r.Errors = len(stats.latencies), stats.errors
+	if stats.seconds > 0 {
+		r.ThroughputRPS = float64(r.Requests) / stats.seconds
+	}
+	if r.ThroughputRPS > 0 {
+		r.JoulesPerRequest = r.AverageWatts / r.ThroughputRPS
+	}
+	r.LatencyP50Ms = percentile(stats.latencies, 0.50)
+	r.LatencyP95Ms = percentile(stats.latencies, 0.95)
+	r.LatencyP99Ms = percentile(stats.latencies, 0.99)
+}
+
+// probeStats are the latencies in ms of the successful requests of a probe run
+type probeStats struct {
+	latencies []float64
+	errors    int
+	seconds   float64
+}
+
+// probe requests the URL from concurrent workers until stopped, the 5xx responses are errors
+func probe(client *http.Client, url string, concurrency int, stop <-chan struct{}) probeStats {
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	stats := probeStats{}
+	start := time.Now()
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				t := time.Now()
+				resp, err := client.Get(url)
+				if err == nil {
+					_, _ = ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+				latency := float64(time.Since(t).Microseconds()) / 1000
+				lock.Lock()
+				if err != nil || resp.StatusCode >= 500 {
+					stats.errors++
+				} else {
+					stats.latencies = append(stats.latencies, latency)
+				}
+				lock.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	stats.seconds = time.Since(start).Seconds()
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of the values, 0 without values
+func percentile(values []float64, p float64) float64 {
+	if len(values) == 0 {
+		return 0
+	}
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	i := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if i < 0 {
+		i = 0
+	}
+	return sorted[i]
+}
+
+// markPareto marks the results no other result beats on both the energy per request and the p95 latency
+func markPareto(results []tuneResult) {
+	valid := func(r *tuneResult) bool {
+		return len(r.Error) == 0 && r.Requests > 0
+	}
+	for i := range results {
+		a := &results[i]
+		if !valid(a) {
+			continue
+		}
+		a.Pareto = true
+		for j := range results {
+			b := &results[j]
+			if i == j || !valid(b) {
+				continue
+			}
+			if b.JoulesPerRequest <= a.JoulesPerRequest && b.LatencyP95Ms <= a.LatencyP95Ms &&
+				(b.JoulesPerRequest < a.JoulesPerRequest || b.LatencyP95Ms < a.LatencyP95Ms) {
+				a.Pareto = false
+				break
+			}
+		}
+	}
+}
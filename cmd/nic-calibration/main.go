@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package main
+
+import (
+	"flag"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/nic"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+var (
+	iface        = flag.String("interface", "", "comma separated network interfaces to calibrate")
+	target       = flag.String("target", "", "UDP host:port receiving the calibration traffic, reachable through the interfaces")
+	rates        = flag.String("rates", "1M,10M,50M,100M", "comma separated transmit rates in bytes per second (K, M, G suffixes)")
+	stepDuration = flag.Duration("step-duration", 20*time.Second, "duration of each measurement step")
+	storeDir     = flag.String("store-dir", store.DefaultDir, "directory of the exporter store where the coefficients are saved")
+)
+
+func main() {
+	flag.Parse()
+	if len(*iface) == 0 || len(*target) == 0 {
+		log.Fatalf("--interface and --target are required")
+	}
+	r, err := parseRates(*rates)
+	if err != nil {
+		log.Fatalf("failed to parse rates: %v", err)
+	}
+	store.SetDir(*storeDir)
+	if err = nic.LoadModel(); err != nil {
+		log.Fatalf("failed to load the NIC model: %v", err)
+	}
+	for _, i := range strings.Split(*iface, ",") {
+		c, err := nic.Calibrate(i, *target, r, *stepDuration)
+		if err != nil {
+			log.Fatalf("failed to calibrate %s: %v", i, err)
+		}
+		log.Printf("%s: %.3f nJ/byte %.3f nJ/packet\n", i, c.EnergyPerByte, c.EnergyPerPacket)
+		if err = nic.SetCoefficients(i, c); err != nil {
+			log.Fatalf("failed to save the coefficients of %s: %v", i, err)
+		}
+	}
+}
+
+func parseRates(s string) ([]float64, error) {
+	multipliers := map[string]float64{"K": 1e3, "M": 1e6, "G": 1e9}
+	rates := []float64{}
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if len(r) == 0 {
+			continue
+		}
+		multiplier := float64(1)
+		if m, ok := multipliers[strings.ToUpper(r[len(r)-1:])]; ok {
+			multiplier = m
+			r = r[:len(r)-1]
+		}
+		v, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return nil, err
+		}
+		rates = append(rates, v*multiplier)
+	}
+	return rates, nil
+}
@@ -24,10 +24,9 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"unsafe"
 
 	"golang.org/x/sys/unix"
-
-	bpf "github.com/iovisor/gobpf/bcc"
 )
 
 type ContainerInfo struct {
@@ -54,11 +53,20 @@ var (
 )
 
 func init() {
-	byteOrder = bpf.GetHostByteOrder()
+	byteOrder = hostByteOrder()
 	podLister = KubeletPodLister{}
 	updateListPodCache("", false)
 }
 
+// hostByteOrder returns the native byte order, the one of the cgroup file handles
+func hostByteOrder() binary.ByteOrder {
+	var i int32 = 0x01020304
+	if *(*byte)(unsafe.Pointer(&i)) == 0x04 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
 func GetSystemProcessName() string {
 	return systemProcessName
 }
@@ -0,0 +1,109 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"time"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+)
+
+const (
+	// measureWindow is long enough for several samples of the exporter
+	measureWindow = 30 * time.Second
+	// powerTolerance is the relative error allowed on the power derived from the counters
+	powerTolerance = 0.2
+)
+
+var _ = Describe("Exporter", func() {
+	var families map[string]*dto.MetricFamily
+
+	BeforeEach(func() {
+		Eventually(func() error {
+			var err error
+			families, err = scrape()
+			return err
+		}, 2*time.Minute, 5*time.Second).Should(Succeed())
+	})
+
+	It("exports the node energy counters per component", func() {
+		for _, component := range []string{"core", "dram", "other"} {
+			_, ok := value(families, "node_energy_joule_total", map[string]string{"component": component})
+			Expect(ok).To(BeTrue(), "missing node_energy_joule_total{component=%q}", component)
+		}
+	})
+
+	It("derives the emulated power from the node energy counters", func() {
+		start := families
+		time.Sleep(measureWindow)
+		end, err := scrape()
+		Expect(err).NotTo(HaveOccurred())
+
+		for component, expected := range map[string]float64{"core": expectedCoreWatts, "dram": expectedDramWatts} {
+			labels := map[string]string{"component": component}
+			before, _ := value(start, "node_energy_joule_total", labels)
+			after, ok := value(end, "node_energy_joule_total", labels)
+			Expect(ok).To(BeTrue())
+			Expect(after).To(BeNumerically(">=", before), "%s energy counter decreased", component)
+			if expected == 0 {
+				continue
+			}
+			watts := (after - before) / measureWindow.Seconds()
+			Expect(watts).To(BeNumerically("~", expected, expected*powerTolerance), "%s power", component)
+		}
+	})
+
+	It("attributes more energy to a busy pod than to an idle pod", func() {
+		busy := map[string]string{"pod": "cpu-burn", "namespace": workloadNamespace, "component": "core"}
+		idle := map[string]string{"pod": "idle", "namespace": workloadNamespace, "component": "core"}
+		Eventually(func() bool {
+			families, _ = scrape()
+			_, ok := value(families, "pod_energy_joule_total", busy)
+			return ok
+		}, 2*time.Minute, 5*time.Second).Should(BeTrue(), "missing pod_energy_joule_total of cpu-burn")
+
+		busyEnergy, _ := value(families, "pod_energy_joule_total", busy)
+		idleEnergy, _ := value(families, "pod_energy_joule_total", idle)
+		Expect(busyEnergy).To(BeNumerically(">", 0))
+		Expect(busyEnergy).To(BeNumerically(">", idleEnergy))
+
+		cpuTime, ok := value(families, "pod_cpu_time_seconds_total", map[string]string{"pod": "cpu-burn", "namespace": workloadNamespace})
+		Expect(ok).To(BeTrue())
+		Expect(cpuTime).To(BeNumerically(">", 0))
+	})
+
+	It("attributes the fake GPU power to the GPU pod", func() {
+		if !checkGPU {
+			Skip("the fake NVML library is not installed")
+		}
+		labels := map[string]string{"pod": "gpu-burn", "namespace": workloadNamespace, "component": "gpu"}
+		Eventually(func() float64 {
+			families, _ = scrape()
+			v, _ := value(families, "pod_energy_joule_total", labels)
+			return v
+		}, 2*time.Minute, 5*time.Second).Should(BeNumerically(">", 0))
+
+		gpu, ok := value(families, "node_energy_joule_total", map[string]string{"component": "gpu"})
+		Expect(ok).To(BeTrue())
+		Expect(gpu).To(BeNumerically(">", 0))
+	})
+})
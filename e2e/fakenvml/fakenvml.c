@@ -0,0 +1,176 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+/*
+ * Fake libnvidia-ml.so.1 implementing the subset of NVML used by pkg/power/gpu.
+ *
+ * FAKE_NVML_DEVICES   number of devices (default 1)
+ * FAKE_NVML_POWER_MW  power usage of each device in mW (default 50000)
+ * FAKE_NVML_PROCS     file with one "pid used_memory" line per process running on
+ *                     each device, written by fake-sensors (default /fake/nvml/procs)
+ */
+
+#include <stdio.h>
+#include <stdlib.h>
+
+#define NVML_SUCCESS 0
+#define NVML_ERROR_UNINITIALIZED 1
+#define NVML_ERROR_INVALID_ARGUMENT 2
+#define NVML_ERROR_INSUFFICIENT_SIZE 7
+#define NVML_ERROR_UNKNOWN 999
+
+#define MAX_DEVICES 16
+#define MAX_PROCS 1024
+
+typedef int nvmlReturn_t;
+
+struct nvmlDevice_st {
+	unsigned int index;
+};
+typedef struct nvmlDevice_st *nvmlDevice_t;
+
+/* nvmlProcessInfo_v1_t, the layout of nvmlDeviceGetComputeRunningProcesses */
+typedef struct {
+	unsigned int pid;
+	unsigned long long usedGpuMemory;
+} nvmlProcessInfo_t;
+
+static struct nvmlDevice_st devices[MAX_DEVICES];
+static int initialized;
+
+static unsigned int env_uint(const char *name, unsigned int def)
+{
+	const char *v = getenv(name);
+	return v ? (unsigned int)strtoul(v, NULL, 10) : def;
+}
+
+static unsigned int device_count(void)
+{
+	unsigned int n = env_uint("FAKE_NVML_DEVICES", 1);
+	return n > MAX_DEVICES ? MAX_DEVICES : n;
+}
+
+nvmlReturn_t nvmlInit_v2(void)
+{
+	unsigned int i;
+	for (i = 0; i < MAX_DEVICES; i++)
+		devices[i].index = i;
+	initialized = 1;
+	return NVML_SUCCESS;
+}
+
+nvmlReturn_t nvmlInit(void)
+{
+	return nvmlInit_v2();
+}
+
+nvmlReturn_t nvmlShutdown(void)
+{
+	initialized = 0;
+	return NVML_SUCCESS;
+}
+
+const char *nvmlErrorString(nvmlReturn_t result)
+{
+	switch (result) {
+	case NVML_SUCCESS:
+		return "Success";
+	case NVML_ERROR_UNINITIALIZED:
+		return "Uninitialized";
+	case NVML_ERROR_INVALID_ARGUMENT:
+		return "Invalid Argument";
+	case NVML_ERROR_INSUFFICIENT_SIZE:
+		return "Insufficient Size";
+	default:
+		return "Unknown Error";
+	}
+}
+
+nvmlReturn_t nvmlDeviceGetCount_v2(unsigned int *count)
+{
+	if (!initialized)
+		return NVML_ERROR_UNINITIALIZED;
+	if (!count)
+		return NVML_ERROR_INVALID_ARGUMENT;
+	*count = device_count();
+	return NVML_SUCCESS;
+}
+
+nvmlReturn_t nvmlDeviceGetCount(unsigned int *count)
+{
+	return nvmlDeviceGetCount_v2(count);
+}
+
+nvmlReturn_t nvmlDeviceGetHandleByIndex_v2(unsigned int index, nvmlDevice_t *device)
+{
+	if (!initialized)
+		return NVML_ERROR_UNINITIALIZED;
+	if (!device || index >= device_count())
+		return NVML_ERROR_INVALID_ARGUMENT;
+	*device = &devices[index];
+	return NVML_SUCCESS;
+}
+
+nvmlReturn_t nvmlDeviceGetHandleByIndex(unsigned int index, nvmlDevice_t *device)
+{
+	return nvmlDeviceGetHandleByIndex_v2(index, device);
+}
+
+nvmlReturn_t nvmlDeviceGetPowerUsage(nvmlDevice_t device, unsigned int *power)
+{
+	if (!initialized)
+		return NVML_ERROR_UNINITIALIZED;
+	if (!device || !power)
+		return NVML_ERROR_INVALID_ARGUMENT;
+	*power = env_uint("FAKE_NVML_POWER_MW", 50000);
+	return NVML_SUCCESS;
+}
+
+/* read_procs reads the process list, the processes run on every device */
+static unsigned int read_procs(nvmlProcessInfo_t *procs)
+{
+	const char *path = getenv("FAKE_NVML_PROCS");
+	unsigned int n = 0;
+	FILE *f;
+
+	f = fopen(path ? path : "/fake/nvml/procs", "r");
+	if (!f)
+		return 0;
+	while (n < MAX_PROCS && fscanf(f, "%u %llu", &procs[n].pid, &procs[n].usedGpuMemory) == 2)
+		n++;
+	fclose(f);
+	return n;
+}
+
+nvmlReturn_t nvmlDeviceGetComputeRunningProcesses(nvmlDevice_t device, unsigned int *infoCount, nvmlProcessInfo_t *infos)
+{
+	static nvmlProcessInfo_t procs[MAX_PROCS];
+	unsigned int i, n;
+
+	if (!initialized)
+		return NVML_ERROR_UNINITIALIZED;
+	if (!device || !infoCount)
+		return NVML_ERROR_INVALID_ARGUMENT;
+	n = read_procs(procs);
+	if (*infoCount < n) {
+		*infoCount = n;
+		return NVML_ERROR_INSUFFICIENT_SIZE;
+	}
+	for (i = 0; i < n; i++)
+		infos[i] = procs[i];
+	*infoCount = n;
+	return NVML_SUCCESS;
+}
@@ -0,0 +1,159 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// fake-sensors writes an emulated powercap tree and the process list of the fake NVML library,
+// so that the exporter can run end to end on a kind node or a CI runner without RAPL or a GPU.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// maxEnergyRange is the wrap around of energy_uj, the value of a Skylake server package
+	maxEnergyRange = uint64(262143328850)
+)
+
+var (
+	root         = flag.String("root", "/fake/powercap", "directory mounted as /sys/class/powercap in the exporter")
+	packages     = flag.Int("packages", 1, "number of emulated packages")
+	packageWatts = flag.Float64("package-watts", 20, "power of each package zone in watts")
+	coreWatts    = flag.Float64("core-watts", 12, "power of each core subzone in watts")
+	dramWatts    = flag.Float64("dram-watts", 3, "power of each dram subzone in watts")
+	psysWatts    = flag.Float64("psys-watts", 0, "power of the psys zone in watts, 0 to not emulate it")
+	interval     = flag.Duration("interval", time.Second, "update interval of the counters")
+	nvmlProcs    = flag.String("nvml-procs-file", "", "process list read by the fake NVML library, empty to disable")
+	gpuProcess   = flag.String("gpu-process", "", "command name of the processes reported as running on the fake GPU")
+	gpuMemory    = flag.Uint64("gpu-memory", 512<<20, "GPU memory in bytes reported for each GPU process")
+)
+
+// zone is an emulated powercap zone
+type zone struct {
+	path   string
+	watts  float64
+	energy float64 // µJ
+}
+
+func main() {
+	flag.Parse()
+
+	zones, err := createZones()
+	if err != nil {
+		log.Fatalf("failed to create the powercap tree: %v", err)
+	}
+	log.Printf("emulating %d powercap zones under %s\n", len(zones), *root)
+
+	last := time.Now()
+	for {
+		now := time.Now()
+		elapsed := now.Sub(last).Seconds()
+		last = now
+		for _, z := range zones {
+			z.energy += z.watts * elapsed * 1e6
+			if z.energy >= float64(maxEnergyRange) {
+				z.energy -= float64(maxEnergyRange)
+			}
+			if err := writeFile(filepath.Join(z.path, "energy_uj"), strconv.FormatUint(uint64(z.energy), 10)); err != nil {
+				log.Printf("failed to update %s: %v\n", z.path, err)
+			}
+		}
+		if len(*nvmlProcs) > 0 {
+			if err := writeProcs(); err != nil {
+				log.Printf("failed to update the fake NVML processes: %v\n", err)
+			}
+		}
+		time.Sleep(*interval)
+	}
+}
+
+// createZones lays out the zones the way the intel_rapl driver does: a package zone per socket
+// with its core and dram subzones, and the psys zone after the packages
+func createZones() ([]*zone, error) {
+	var zones []*zone
+	add := func(path, name string, watts float64) error {
+		if err := os.MkdirAll(path, 0755); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(path, "name"), name); err != nil {
+			return err
+		}
+		if err := writeFile(filepath.Join(path, "max_energy_range_uj"), strconv.FormatUint(maxEnergyRange, 10)); err != nil {
+			return err
+		}
+		zones = append(zones, &zone{path: path, watts: watts})
+		return writeFile(filepath.Join(path, "energy_uj"), "0")
+	}
+
+	base := filepath.Join(*root, "intel-rapl")
+	for i := 0; i < *packages; i++ {
+		pkg := filepath.Join(base, fmt.Sprintf("intel-rapl:%d", i))
+		if err := add(pkg, fmt.Sprintf("package-%d", i), *packageWatts); err != nil {
+			return nil, err
+		}
+		if err := add(filepath.Join(pkg, fmt.Sprintf("intel-rapl:%d:0", i)), "core", *coreWatts); err != nil {
+			return nil, err
+		}
+		if err := add(filepath.Join(pkg, fmt.Sprintf("intel-rapl:%d:1", i)), "dram", *dramWatts); err != nil {
+			return nil, err
+		}
+	}
+	if *psysWatts > 0 {
+		if err := add(filepath.Join(base, fmt.Sprintf("intel-rapl:%d", *packages)), "psys", *psysWatts); err != nil {
+			return nil, err
+		}
+	}
+	return zones, nil
+}
+
+// writeProcs lists the pids whose command is gpuProcess, one "pid memory" line each
+func writeProcs() error {
+	var lines []string
+	if len(*gpuProcess) > 0 {
+		dirs, err := ioutil.ReadDir("/proc")
+		if err != nil {
+			return err
+		}
+		for _, d := range dirs {
+			pid, err := strconv.Atoi(d.Name())
+			if err != nil {
+				continue
+			}
+			comm, err := ioutil.ReadFile(filepath.Join("/proc", d.Name(), "comm"))
+			if err != nil || strings.TrimSpace(string(comm)) != *gpuProcess {
+				continue
+			}
+			lines = append(lines, fmt.Sprintf("%d %d", pid, *gpuMemory))
+		}
+	}
+	return writeFile(*nvmlProcs, strings.Join(lines, "\n"))
+}
+
+// writeFile replaces the file atomically so that the readers never see a partial value
+func writeFile(path, content string) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, []byte(content+"\n"), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
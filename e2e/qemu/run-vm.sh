@@ -0,0 +1,60 @@
+#!/bin/bash
+# Runs the end to end tests against the exporter in a QEMU guest with virtual RAPL MSRs, so that
+# the powercap zones are created by the intel_rapl driver of a real kernel instead of fake-sensors.
+#
+# Needs QEMU >= 9.1 on an Intel host, with qemu-vmsr-helper running as root to read the host
+# MSRs for the guest:
+#   sudo qemu-vmsr-helper -k /var/run/qemu-vmsr-helper.sock &
+#
+# Usage: e2e/qemu/run-vm.sh <guest image>
+#   the guest must run sshd reachable as root with $SSH_KEY, have k3s installed and a kernel with
+#   BTF, the kepler:e2e image is imported in it and deployed without fake-sensors
+set -euo pipefail
+
+IMAGE=${1:?disk image of the guest}
+SOCKET=${VMSR_HELPER_SOCKET:-/var/run/qemu-vmsr-helper.sock}
+SSH_KEY=${SSH_KEY:-$HOME/.ssh/id_ed25519}
+SSH_PORT=${SSH_PORT:-10022}
+METRICS_PORT=${KEPLER_E2E_PORT:-18888}
+ROOT=$(cd "$(dirname "$0")/../.." && pwd)
+
+OVERLAY=$(mktemp --suffix .qcow2)
+qemu-img create -q -f qcow2 -b "$(realpath "$IMAGE")" -F qcow2 "$OVERLAY"
+
+qemu-system-x86_64 \
+	-accel kvm,rapl=true,rapl-helper-socket="$SOCKET" \
+	-cpu host -smp 2 -m 2G \
+	-drive file="$OVERLAY",if=virtio \
+	-nic user,hostfwd=tcp::"$SSH_PORT"-:22,hostfwd=tcp::"$METRICS_PORT"-:8888 \
+	-display none -daemonize -pidfile "$OVERLAY.pid"
+
+cleanup() {
+	kill "$(cat "$OVERLAY.pid")" 2>/dev/null || true
+	rm -f "$OVERLAY" "$OVERLAY.pid"
+}
+trap cleanup EXIT
+
+SSH="ssh -i $SSH_KEY -p $SSH_PORT -o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null root@localhost"
+for i in $(seq 60); do
+	$SSH true 2>/dev/null && break
+	sleep 5
+done
+
+docker build -f "$ROOT/e2e/Dockerfile" -t kepler:e2e "$ROOT"
+docker save kepler:e2e | $SSH "k3s ctr images import -"
+$SSH "modprobe intel_rapl_msr && kubectl apply -f -" <"$ROOT/e2e/manifests/workload.yaml"
+$SSH "kubectl apply -f -" <"$ROOT/e2e/manifests/kepler.yaml"
+# drop fake-sensors and its volume over /sys/class/powercap to read the virtual RAPL zones
+$SSH "kubectl -n kepler patch daemonset kepler --type=json -p '[
+	{\"op\": \"remove\", \"path\": \"/spec/template/spec/containers/0\"},
+	{\"op\": \"remove\", \"path\": \"/spec/template/spec/containers/0/volumeMounts/0\"}]'"
+$SSH "kubectl -n e2e wait --for=condition=Ready pod --all --timeout=5m && kubectl -n kepler rollout status daemonset/kepler --timeout=5m"
+
+cd "$ROOT"
+# the guest power follows the host load, only the consistency of the counters is checked
+KEPLER_E2E_ENDPOINT="http://localhost:$METRICS_PORT/metrics" \
+	KEPLER_E2E_GPU=false \
+	go test -tags e2e -count=1 -v ./e2e/... || {
+	$SSH "kubectl -n kepler logs daemonset/kepler --tail=100"
+	exit 1
+}
@@ -0,0 +1,47 @@
+#!/bin/bash
+# Runs the end to end tests on a kind cluster: the exporter reads the powercap tree emulated by
+# fake-sensors and the fake NVML library, and the tests assert on its metrics.
+#
+# Usage: e2e/run.sh [--keep]   (--keep leaves the cluster running for debugging)
+set -euo pipefail
+
+CLUSTER=${KIND_CLUSTER:-kepler-e2e}
+IMAGE=kepler:e2e
+PORT=${KEPLER_E2E_PORT:-18888}
+ROOT=$(cd "$(dirname "$0")/.." && pwd)
+KEEP=false
+[ "${1:-}" = "--keep" ] && KEEP=true
+
+cleanup() {
+	[ -n "${FORWARD_PID:-}" ] && kill "$FORWARD_PID" 2>/dev/null || true
+	if [ "$KEEP" = false ]; then
+		kind delete cluster --name "$CLUSTER"
+	fi
+}
+trap cleanup EXIT
+
+if ! kind get clusters | grep -qx "$CLUSTER"; then
+	kind create cluster --name "$CLUSTER" --config "$ROOT/e2e/kind-cluster.yaml" --wait 2m
+fi
+
+docker build -f "$ROOT/e2e/Dockerfile" -t "$IMAGE" "$ROOT"
+kind load docker-image "$IMAGE" --name "$CLUSTER"
+
+kubectl --context "kind-$CLUSTER" apply -f "$ROOT/e2e/manifests/workload.yaml"
+kubectl --context "kind-$CLUSTER" apply -f "$ROOT/e2e/manifests/kepler.yaml"
+kubectl --context "kind-$CLUSTER" -n e2e wait --for=condition=Ready pod --all --timeout=3m
+kubectl --context "kind-$CLUSTER" -n kepler rollout status daemonset/kepler --timeout=5m
+
+kubectl --context "kind-$CLUSTER" -n kepler port-forward daemonset/kepler "$PORT:8888" >/dev/null &
+FORWARD_PID=$!
+sleep 3
+
+cd "$ROOT"
+KEPLER_E2E_ENDPOINT="http://localhost:$PORT/metrics" \
+	KEPLER_E2E_CORE_WATTS=12 \
+	KEPLER_E2E_DRAM_WATTS=3 \
+	KEPLER_E2E_GPU=true \
+	go test -tags e2e -count=1 -v ./e2e/... || {
+	kubectl --context "kind-$CLUSTER" -n kepler logs daemonset/kepler -c kepler --tail=100
+	exit 1
+}
@@ -0,0 +1,122 @@
+//go:build e2e
+// +build e2e
+
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package e2e
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"testing"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+const defaultEndpoint = "http://localhost:8888/metrics"
+
+var (
+	// endpoint is the metrics endpoint of the exporter under test
+	endpoint string
+	// expectedCoreWatts and expectedDramWatts are the power emulated by fake-sensors,
+	// 0 on real or virtual RAPL where only the consistency of the counters is checked
+	expectedCoreWatts, expectedDramWatts float64
+	// workloadNamespace holds the pods of e2e/manifests/workload.yaml
+	workloadNamespace string
+	// checkGPU is whether the fake NVML library is installed
+	checkGPU bool
+)
+
+func TestE2E(t *testing.T) {
+	RegisterFailHandler(Fail)
+	RunSpecs(t, "E2E Suite")
+}
+
+var _ = BeforeSuite(func() {
+	endpoint = getEnv("KEPLER_E2E_ENDPOINT", defaultEndpoint)
+	workloadNamespace = getEnv("KEPLER_E2E_NAMESPACE", "e2e")
+	expectedCoreWatts = getFloatEnv("KEPLER_E2E_CORE_WATTS")
+	expectedDramWatts = getFloatEnv("KEPLER_E2E_DRAM_WATTS")
+	checkGPU = getEnv("KEPLER_E2E_GPU", "true") == "true"
+})
+
+func getEnv(name, def string) string {
+	if v := os.Getenv(name); len(v) > 0 {
+		return v
+	}
+	return def
+}
+
+func getFloatEnv(name string) float64 {
+	v, err := strconv.ParseFloat(getEnv(name, "0"), 64)
+	Expect(err).NotTo(HaveOccurred(), "invalid %s", name)
+	return v
+}
+
+// scrape returns the metric families exposed by the exporter
+func scrape() (map[string]*dto.MetricFamily, error) {
+	resp, err := http.Get(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// value returns the value of the series of the family matching the labels, and whether it exists
+func value(families map[string]*dto.MetricFamily, name string, labels map[string]string) (float64, bool) {
+	f, ok := families[name]
+	if !ok {
+		return 0, false
+	}
+	for _, m := range f.GetMetric() {
+		if !matches(m, labels) {
+			continue
+		}
+		switch {
+		case m.GetCounter() != nil:
+			return m.GetCounter().GetValue(), true
+		case m.GetGauge() != nil:
+			return m.GetGauge().GetValue(), true
+		case m.GetUntyped() != nil:
+			return m.GetUntyped().GetValue(), true
+		}
+	}
+	return 0, false
+}
+
+func matches(m *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, l := range m.GetLabel() {
+		if v, ok := labels[l.GetName()]; ok {
+			if v != l.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
@@ -0,0 +1,209 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package advisor
+
+import (
+	"sort"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+)
+
+// The advisor looks for the workloads of the history which spend most of their time idle, drawing
+// power while serving no request, and estimates the energy scaling them to zero or consolidating them
+// would save. The requests are not observed, a workload is idle when it uses almost no CPU.
+const (
+	ScaleToZero = "scale-to-zero"
+	Consolidate = "consolidate"
+)
+
+type Options struct {
+	// Window is the history analyzed, up to now
+	Window time.Duration
+	// IdleCores is the CPU usage below which a workload is idle
+	IdleCores float64
+	// Cooldown is the idle time before a workload is scaled to zero, e.g. the KEDA cooldown period.
+	// The cold starts are not counted, they cost little next to the idle stretches longer than it.
+	Cooldown time.Duration
+	// MinIdleRatio is the share of idle time of the scale-to-zero candidates
+	MinIdleRatio float64
+	// LowUsageCores is the average CPU usage below which an always-on workload may be consolidated
+	LowUsageCores float64
+	// ExcludeNamespaces are not analyzed, e.g. the system processes and the cluster services
+	ExcludeNamespaces []string
+}
+
+var DefaultOptions = Options{
+	Window:        24 * time.Hour,
+	IdleCores:     0.01,
+	Cooldown:      15 * time.Minute,
+	MinIdleRatio:  0.5,
+	LowUsageCores: 0.1,
+
+	ExcludeNamespaces: []string{"system", "kube-system"},
+}
+
+type Recommendation struct {
+	Namespace    string  `json:"namespace"`
+	Name         string  `json:"name"`
+	Action       string  `json:"action"`
+	AverageWatts float64 `json:"average_watts"`
+	// IdleWatts is the average power while idle, the baseline of the always-on workloads
+	IdleWatts    float64 `json:"idle_watts"`
+	IdleRatio    float64 `json:"idle_ratio"`
+	AverageCores float64 `json:"average_cores"`
+	// Activations are the idle stretches longer than the cooldown ended by activity, the cold starts
+	// the workload would have after scaling to zero
+	Activations        int     `json:"activations"`
+	LongestIdleSeconds float64 `json:"longest_idle_seconds"`
+	SavingsJoules      float64 `json:"savings_joules"`
+	SavingsWatts       float64 `json:"savings_watts"`
+}
+
+type Report struct {
+	Start              time.Time        `json:"start"`
+	End                time.Time        `json:"end"`
+	Recommendations    []Recommendation `json:"recommendations"`
+	TotalSavingsJoules float64          `json:"total_savings_joules"`
+	TotalSavingsWatts  float64          `json:"total_savings_watts"`
+}
+
+// step is the power and CPU usage of a workload between two history points
+type step struct {
+	seconds, watts, cores float64
+}
+
+// Analyze returns the recommendations over the window of the history ending at now, by decreasing savings
+func Analyze(db *history.DB, now time.Time, opts Options) *Report {
+	start := now.Add(-opts.Window)
+	mint, maxt := start.UnixNano()/int64(time.Millisecond), now.UnixNano()/int64(time.Millisecond)
+	energy := db.Select(mint, maxt, func(metric map[string]string) bool {
+		return metric[history.NameLabel] == "container_energy_joule_total"
+	})
+	cpuTime := map[string][]history.Point{}
+	for _, s := range db.Select(mint, maxt, func(metric map[string]string) bool {
+		return metric[history.NameLabel] == "container_cpu_time_seconds_total"
+	}) {
+		cpuTime[s.Metric["container_namespace"]+"/"+s.Metric["container_name"]] = s.Points
+	}
+
+	excluded := map[string]bool{}
+	for _, namespace := range opts.ExcludeNamespaces {
+		excluded[namespace] = true
+	}
+	report := &Report{Start: start, End: now, Recommendations: []Recommendation{}}
+	for _, s := range energy {
+		namespace, name := s.Metric["container_namespace"], s.Metric["container_name"]
+		if excluded[namespace] {
+			continue
+		}
+		steps := getSteps(s.Points, cpuTime[namespace+"/"+name])
+		r, ok := recommend(steps, opts)
+		if !ok {
+			continue
+		}
+		r.Namespace, r.Name = namespace, name
+		r.SavingsWatts = r.SavingsJoules / opts.Window.Seconds()
+		report.Recommendations = append(report.Recommendations, r)
+		report.TotalSavingsJoules += r.SavingsJoules
+	}
+	report.TotalSavingsWatts = report.TotalSavingsJoules / opts.Window.Seconds()
+	sort.Slice(report.Recommendations, func(i, j int) bool {
+		return report.Recommendations[i].SavingsJoules > report.Recommendations[j].SavingsJoules
+	})
+	return report
+}
+
+// getSteps returns the steps between the consecutive points of the energy and CPU time counters
+func getSteps(energy, cpuTime []history.Point) []step {
+	cpu := make(map[int64]float64, len(cpuTime))
+	for _, p := range cpuTime {
+		cpu[p.T] = p.V
+	}
+	steps := []step{}
+	for i := 1; i < len(energy); i++ {
+		prev, curr := energy[i-1], energy[i]
+		seconds := float64(curr.T-prev.T) / 1000
+		prevCPU, ok1 := cpu[prev.T]
+		currCPU, ok2 := cpu[curr.T]
+		// a counter going back was reset, a missing CPU time is from a history without it
+		if seconds <= 0 || curr.V < prev.V || !ok1 || !ok2 || currCPU < prevCPU {
+			continue
+		}
+		steps = append(steps, step{
+			seconds: seconds,
+			watts:   (curr.V - prev.V) / seconds,
+			cores:   (currCPU - prevCPU) / seconds,
+		})
+	}
+	return steps
+}
+
+// recommend classifies a workload from its steps, false if it is not a candidate
+func recommend(steps []step, opts Options) (Recommendation, bool) {
+	r := Recommendation{}
+	var total, idle, energy, idleEnergy, cpu, stretch float64
+	cooldown := opts.Cooldown.Seconds()
+	savedSeconds := 0.0
+	endStretch := func(activated bool) {
+		if stretch > cooldown {
+			// the energy after the cooldown is saved, at the average idle power of the stretch
+			savedSeconds += stretch - cooldown
+			if activated {
+				r.Activations++
+			}
+		}
+		if stretch > r.LongestIdleSeconds {
+			r.LongestIdleSeconds = stretch
+		}
+		stretch = 0
+	}
+	for _, s := range steps {
+		total += s.seconds
+		energy += s.watts * s.seconds
+		cpu += s.cores * s.seconds
+		if s.cores < opts.IdleCores {
+			idle += s.seconds
+			idleEnergy += s.watts * s.seconds
+			stretch += s.seconds
+		} else {
+			endStretch(true)
+		}
+	}
+	endStretch(false)
+	if total == 0 {
+		return r, false
+	}
+	r.AverageWatts = energy / total
+	r.AverageCores = cpu / total
+	r.IdleRatio = idle / total
+	if idle > 0 {
+		r.IdleWatts = idleEnergy / idle
+	}
+	switch {
+	case r.IdleRatio >= opts.MinIdleRatio && savedSeconds > 0:
+		r.Action = ScaleToZero
+		r.SavingsJoules = savedSeconds * r.IdleWatts
+	case r.AverageCores < opts.LowUsageCores && r.IdleWatts > 0:
+		// an upper bound, the baseline of a workload sharing a process with others is not all saved
+		r.Action = Consolidate
+		r.SavingsJoules = idle * r.IdleWatts
+	default:
+		return r, false
+	}
+	return r, r.SavingsJoules > 0
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ambient
+
+import (
+	"sync"
+	"time"
+)
+
+// Environmental conditions (ambient temperature, humidity) change the power of edge devices and explain
+// many of their failures. They are read from I2C sensors through the kernel IIO drivers (BME280, SHT3x...)
+// or from MQTT topics published by the site sensors, and attached to the node samples.
+const (
+	Temperature = "temperature"
+	Humidity    = "humidity"
+	Pressure    = "pressure"
+
+	// a reading not updated for this long is dropped, e.g. a sensor that stopped publishing
+	readingTTL = 5 * time.Minute
+)
+
+// Reading is the last value of each quantity of a sensor: °C, %RH and kPa
+type Reading struct {
+	Values  map[string]float64
+	Updated time.Time
+}
+
+var (
+	readings = map[string]*Reading{}
+	lock     sync.Mutex
+)
+
+func set(sensor, quantity string, value float64, now time.Time) {
+	lock.Lock()
+	defer lock.Unlock()
+	r, ok := readings[sensor]
+	if !ok {
+		r = &Reading{Values: map[string]float64{}}
+		readings[sensor] = r
+	}
+	r.Values[quantity] = value
+	r.Updated = now
+}
+
+// Update reads the IIO sensors, the MQTT readings are updated when published
+func Update() {
+	updateIIO(time.Now())
+}
+
+// GetReadings returns a copy of the current readings per sensor
+func GetReadings() map[string]map[string]float64 {
+	lock.Lock()
+	defer lock.Unlock()
+	now := time.Now()
+	m := make(map[string]map[string]float64, len(readings))
+	for sensor, r := range readings {
+		if now.Sub(r.Updated) > readingTTL {
+			delete(readings, sensor)
+			continue
+		}
+		values := make(map[string]float64, len(r.Values))
+		for q, v := range r.Values {
+			values[q] = v
+		}
+		m[sensor] = values
+	}
+	return m
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ambient
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The IIO drivers of the environmental sensors (bmp280 for BME280/BMP280, bme680, sht3x, hdc100x, si7020...)
+// expose in_temp_input in m°C, in_humidityrelative_input in m%RH and in_pressure_input in kPa.
+// Other IIO devices (ADCs, IMUs) also report a die temperature, so a device is an ambient sensor only
+// if it measures humidity or pressure, or its driver is a known environmental sensor.
+const (
+	iioDevicesGlob = "/sys/bus/iio/devices/iio:device*"
+)
+
+var (
+	iioSensorNames = []string{"bme280", "bmp280", "bme680", "sht3x", "sht4x", "hdc100x", "hdc2010", "si7020", "htu21", "dht11", "aht20"}
+	iioChannels    = []struct {
+		file     string
+		quantity string
+		scale    float64
+	}{
+		{"in_temp_input", Temperature, 1000},
+		{"in_humidityrelative_input", Humidity, 1000},
+		{"in_pressure_input", Pressure, 1},
+	}
+)
+
+func updateIIO(now time.Time) {
+	devices, _ := filepath.Glob(iioDevicesGlob)
+	for _, dev := range devices {
+		data, err := ioutil.ReadFile(filepath.Join(dev, "name"))
+		if err != nil {
+			continue
+		}
+		name := strings.TrimSpace(string(data))
+		values := map[string]float64{}
+		for _, c := range iioChannels {
+			data, err := ioutil.ReadFile(filepath.Join(dev, c.file))
+			if err != nil {
+				continue
+			}
+			v, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+			if err != nil {
+				continue
+			}
+			values[c.quantity] = v / c.scale
+		}
+		_, hasHumidity := values[Humidity]
+		_, hasPressure := values[Pressure]
+		if !hasHumidity && !hasPressure && !isEnvironmentalSensor(name) {
+			continue
+		}
+		sensor := name + "/" + filepath.Base(dev)
+		for q, v := range values {
+			set(sensor, q, v, now)
+		}
+	}
+}
+
+func isEnvironmentalSensor(name string) bool {
+	for _, n := range iioSensorNames {
+		if strings.HasPrefix(name, n) {
+			return true
+		}
+	}
+	return false
+}
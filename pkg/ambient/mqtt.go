@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package ambient
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// The site sensors publish either a plain number on a topic per quantity, e.g. "site/rack1/temperature" -> "23.5",
+// mapped with "site/rack1/temperature=temperature", or a JSON object with temperature, humidity and pressure
+// fields on a topic mapped without a quantity. The topic is the sensor name.
+const mqttConnectTimeout = 10 * time.Second
+
+// SubscribeMQTT connects to the broker (e.g. tcp://broker:1883) and subscribes to the comma separated topics,
+// each topic optionally followed by =<quantity> if its payload is a plain number. The client ID must be unique
+// among the clients of the broker, which disconnects the older client of an ID.
+func SubscribeMQTT(broker, topics, clientID string) error {
+	subscriptions := map[string]byte{}
+	quantities := map[string]string{}
+	for _, t := range strings.Split(topics, ",") {
+		t = strings.TrimSpace(t)
+		if len(t) == 0 {
+			continue
+		}
+		fields := strings.SplitN(t, "=", 2)
+		subscriptions[fields[0]] = 0
+		if len(fields) == 2 {
+			switch fields[1] {
+			case Temperature, Humidity, Pressure:
+				quantities[fields[0]] = fields[1]
+			default:
+				return fmt.Errorf("unknown quantity %q for topic %s", fields[1], fields[0])
+			}
+		}
+	}
+	if len(subscriptions) == 0 {
+		return fmt.Errorf("no topic")
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(c mqtt.Client) {
+			// subscribe again after a reconnection
+			token := c.SubscribeMultiple(subscriptions, func(_ mqtt.Client, msg mqtt.Message) {
+				handleMessage(msg.Topic(), msg.Payload(), quantities[msg.Topic()], time.Now())
+			})
+			if token.WaitTimeout(mqttConnectTimeout) && token.Error() != nil {
+				log.Printf("failed to subscribe to %v: %v\n", topics, token.Error())
+			}
+		})
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttConnectTimeout) {
+		return fmt.Errorf("timeout connecting to %s", broker)
+	}
+	return token.Error()
+}
+
+func handleMessage(topic string, payload []byte, quantity string, now time.Time) {
+	if len(quantity) > 0 {
+		v, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64)
+		if err != nil {
+			log.Printf("failed to parse %s payload %q: %v\n", topic, payload, err)
+			return
+		}
+		set(topic, quantity, v, now)
+		return
+	}
+	values := map[string]interface{}{}
+	if err := json.Unmarshal(payload, &values); err != nil {
+		log.Printf("failed to parse %s payload: %v\n", topic, err)
+		return
+	}
+	for _, q := range []string{Temperature, Humidity, Pressure} {
+		if v, ok := values[q].(float64); ok {
+			set(topic, q, v, now)
+		}
+	}
+}
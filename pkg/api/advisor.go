@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/advisor"
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+)
+
+const advisorPath = "/api/v1/advisor"
+
+// RegisterAdvisor adds the scale-to-zero advisor over the history to the default mux, the window,
+// idle_cores and cooldown parameters override the defaults
+func RegisterAdvisor(db *history.DB) {
+	http.HandleFunc(advisorPath, func(w http.ResponseWriter, r *http.Request) {
+		opts := advisor.DefaultOptions
+		var err error
+		if s := r.FormValue("window"); len(s) > 0 {
+			if opts.Window, err = parseStep(s); err != nil || opts.Window <= 0 {
+				writeError(w, fmt.Errorf("invalid window %q", s))
+				return
+			}
+		}
+		if s := r.FormValue("cooldown"); len(s) > 0 {
+			if opts.Cooldown, err = parseStep(s); err != nil || opts.Cooldown < 0 {
+				writeError(w, fmt.Errorf("invalid cooldown %q", s))
+				return
+			}
+		}
+		if s := r.FormValue("idle_cores"); len(s) > 0 {
+			if opts.IdleCores, err = strconv.ParseFloat(s, 64); err != nil || opts.IdleCores < 0 {
+				writeError(w, fmt.Errorf("invalid idle_cores %q", s))
+				return
+			}
+		}
+		writeReport(w, advisor.Analyze(db, time.Now(), opts))
+	})
+}
@@ -0,0 +1,185 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+	"github.com/sustainable-computing-io/kepler/pkg/query"
+)
+
+// The REST API serves the local history with the query endpoints of the prometheus HTTP API, so the
+// on-device dashboards (and a grafana prometheus datasource) work without a prometheus server.
+const (
+	queryPath      = "/api/v1/query"
+	queryRangePath = "/api/v1/query_range"
+)
+
+type response struct {
+	Status    string      `json:"status"`
+	Data      interface{} `json:"data,omitempty"`
+	ErrorType string      `json:"errorType,omitempty"`
+	Error     string      `json:"error,omitempty"`
+	// KeyID, Hash and Signature seal the data of the reports, see collector.VerifyReport
+	KeyID     string `json:"key_id,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type queryData struct {
+	ResultType string      `json:"resultType"`
+	Result     interface{} `json:"result"`
+}
+
+type vectorSample struct {
+	Metric map[string]string `json:"metric"`
+	Value  [2]interface{}    `json:"value"`
+}
+
+type matrixSeries struct {
+	Metric map[string]string `json:"metric"`
+	Values [][2]interface{}  `json:"values"`
+}
+
+// RegisterQuery adds the query endpoints over the history to the default mux
+func RegisterQuery(db *history.DB) {
+	engine := query.NewEngine(db)
+	http.HandleFunc(queryPath, func(w http.ResponseWriter, r *http.Request) {
+		t := time.Now()
+		if s := r.FormValue("time"); len(s) > 0 {
+			var err error
+			if t, err = parseTime(s); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+		result, err := engine.Instant(r.FormValue("query"), t)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		if result.Scalar != nil {
+			writeData(w, queryData{ResultType: "scalar", Result: point(toMillis(t), *result.Scalar)})
+			return
+		}
+		vector := make([]vectorSample, 0, len(result.Vector))
+		for _, s := range result.Vector {
+			vector = append(vector, vectorSample{Metric: s.Metric, Value: point(toMillis(t), s.Value)})
+		}
+		writeData(w, queryData{ResultType: "vector", Result: vector})
+	})
+	http.HandleFunc(queryRangePath, func(w http.ResponseWriter, r *http.Request) {
+		start, err := parseTime(r.FormValue("start"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		end, err := parseTime(r.FormValue("end"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		step, err := parseStep(r.FormValue("step"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		series, err := engine.Range(r.FormValue("query"), start, end, step)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		matrix := make([]matrixSeries, 0, len(series))
+		for _, s := range series {
+			values := make([][2]interface{}, 0, len(s.Points))
+			for _, p := range s.Points {
+				values = append(values, point(p.T, p.V))
+			}
+			matrix = append(matrix, matrixSeries{Metric: s.Metric, Values: values})
+		}
+		writeData(w, queryData{ResultType: "matrix", Result: matrix})
+	})
+}
+
+// point is the [unix seconds, "value"] pair of the prometheus API
+func point(t int64, v float64) [2]interface{} {
+	return [2]interface{}{float64(t) / 1000, strconv.FormatFloat(v, 'f', -1, 64)}
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+// parseTime parses a unix time in seconds or an RFC 3339 time
+func parseTime(s string) (time.Time, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		sec, frac := math.Modf(f)
+		return time.Unix(int64(sec), int64(frac*float64(time.Second))), nil
+	}
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time %q", s)
+	}
+	return t, nil
+}
+
+// parseStep parses a step in seconds or a duration
+func parseStep(s string) (time.Duration, error) {
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return time.Duration(f * float64(time.Second)), nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid step %q", s)
+	}
+	return d, nil
+}
+
+func writeData(w http.ResponseWriter, data interface{}) {
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: data})
+}
+
+// writeReport writes the data with its hash and signature with the device key, so the fleet backends can
+// verify the energy and billing reports
+func writeReport(w http.ResponseWriter, data interface{}) {
+	keyID, hash, signature, err := collector.SealReport(data)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, response{Status: "error", ErrorType: "internal", Error: err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, response{Status: "success", Data: data, KeyID: keyID, Hash: hash, Signature: signature})
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	writeJSON(w, http.StatusBadRequest, response{Status: "error", ErrorType: "bad_data", Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("failed to write response: %v\n", err)
+	}
+}
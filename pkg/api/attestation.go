@@ -0,0 +1,39 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The attestation endpoint returns the signed statement of the measurement configuration of the device,
+// verified by the auditors with the device public key.
+const attestationPath = "/api/v1/attestation"
+
+// RegisterAttestation adds the attestation endpoint to the default mux
+func RegisterAttestation() {
+	http.HandleFunc(attestationPath, func(w http.ResponseWriter, r *http.Request) {
+		a, err := collector.GetAttestation()
+		if err != nil {
+			writeJSON(w, http.StatusInternalServerError, response{Status: "error", ErrorType: "internal", Error: err.Error()})
+			return
+		}
+		writeData(w, a)
+	})
+}
@@ -0,0 +1,312 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The energy endpoints return the energy of the containers and of the node in the last sample, or summed
+// over a window with the window parameter. The samples are summed into one minute buckets kept for the
+// longest window, the window is rounded to the buckets, its start and end are returned.
+const (
+	containersPath = "/api/v1/containers"
+	nodePath       = "/api/v1/node"
+
+	DefaultEnergyWindow = 24 * time.Hour
+	energyBucket        = time.Minute
+)
+
+// Energy is the energy in mJ and the CPU time of a sample or a window, with the average power in W
+type Energy struct {
+	CPUTime       float64            `json:"cpu_time"`
+	EnergyInCore  float64            `json:"energy_in_core"`
+	EnergyInDram  float64            `json:"energy_in_dram"`
+	EnergyInGPU   float64            `json:"energy_in_gpu"`
+	EnergyInOther float64            `json:"energy_in_other"`
+	Accelerators  map[string]float64 `json:"accelerators,omitempty"`
+	EnergyTotal   float64            `json:"energy_total"`
+	AveragePower  float64            `json:"average_power"`
+}
+
+type ContainerEnergy struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Command   string `json:"command"`
+	Energy
+}
+
+type NodeEnergy struct {
+	Name   string   `json:"name"`
+	States []string `json:"states,omitempty"`
+	Energy
+}
+
+// ContainersEnergy and NodeEnergyWindow are the responses of the energy endpoints
+type ContainersEnergy struct {
+	Start      time.Time         `json:"start"`
+	End        time.Time         `json:"end"`
+	Containers []ContainerEnergy `json:"containers"`
+}
+
+type NodeEnergyWindow struct {
+	Start time.Time  `json:"start"`
+	End   time.Time  `json:"end"`
+	Node  NodeEnergy `json:"node"`
+}
+
+// energyBucketSums are the energies summed over the samples ending in [start, end]
+type energyBucketSums struct {
+	start      time.Time
+	end        time.Time
+	node       NodeEnergy
+	containers map[string]*ContainerEnergy
+}
+
+var (
+	errNoSample = errors.New("no sample yet")
+
+	energyLock    sync.Mutex
+	maxWindow     = DefaultEnergyWindow
+	energyBuckets []*energyBucketSums
+	// lastEnergy is the last sample, a bucket of its own
+	lastEnergy       *energyBucketSums
+	lastEnergySample time.Time
+)
+
+// SetEnergyWindow sets the longest window the energy is summed over
+func SetEnergyWindow(window time.Duration) error {
+	if window < energyBucket {
+		return fmt.Errorf("invalid energy window %v, at least %v", window, energyBucket)
+	}
+	energyLock.Lock()
+	defer energyLock.Unlock()
+	maxWindow = window
+	return nil
+}
+
+// add sums the energy of a sample, or of a bucket
+func (e *Energy) add(o *Energy) {
+	e.CPUTime += o.CPUTime
+	e.EnergyInCore += o.EnergyInCore
+	e.EnergyInDram += o.EnergyInDram
+	e.EnergyInGPU += o.EnergyInGPU
+	e.EnergyInOther += o.EnergyInOther
+	e.EnergyTotal += o.EnergyTotal
+	for name, v := range o.Accelerators {
+		if e.Accelerators == nil {
+			e.Accelerators = map[string]float64{}
+		}
+		e.Accelerators[name] += v
+	}
+}
+
+// add sums a bucket into b
+func (b *energyBucketSums) add(o *energyBucketSums) {
+	if b.start.IsZero() || o.start.Before(b.start) {
+		b.start = o.start
+	}
+	if o.end.After(b.end) {
+		b.end = o.end
+	}
+	b.node.Name, b.node.States = o.node.Name, o.node.States
+	b.node.add(&o.node.Energy)
+	for key, c := range o.containers {
+		sum, ok := b.containers[key]
+		if !ok {
+			sum = &ContainerEnergy{Name: c.Name, Namespace: c.Namespace}
+			b.containers[key] = sum
+		}
+		sum.Command = c.Command
+		sum.add(&c.Energy)
+	}
+}
+
+// sampleEnergy returns the energies of a sample over [start, s.Time]
+func sampleEnergy(s *collector.Snapshot, start time.Time) *energyBucketSums {
+	n := s.EdgeDevice
+	b := &energyBucketSums{start: start, end: s.Time, containers: map[string]*ContainerEnergy{}}
+	b.node = NodeEnergy{Name: n.Name, States: n.States, Energy: Energy{
+		CPUTime:       n.CPUTime,
+		EnergyInCore:  n.EnergyInCore,
+		EnergyInDram:  n.EnergyInDram,
+		EnergyInGPU:   n.EnergyInGPU,
+		EnergyInOther: n.EnergyInOther,
+		EnergyTotal:   n.EnergyInCore + n.EnergyInDram + n.EnergyInGPU + n.EnergyInOther,
+	}}
+	for name, v := range n.Accelerators {
+		if b.node.Accelerators == nil {
+			b.node.Accelerators = map[string]float64{}
+		}
+		b.node.Accelerators[name] = v
+		b.node.EnergyTotal += v
+	}
+	for _, c := range s.Containers {
+		e := &ContainerEnergy{Name: c.Name, Namespace: c.Namespace, Command: c.Command, Energy: Energy{
+			CPUTime:       c.CPUTime,
+			EnergyInCore:  float64(c.EnergyInCore),
+			EnergyInDram:  float64(c.EnergyInDram),
+			EnergyInGPU:   float64(c.EnergyInGPU),
+			EnergyInOther: float64(c.EnergyInOther),
+			EnergyTotal:   float64(c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther),
+		}}
+		for name, v := range c.Accelerators {
+			if e.Accelerators == nil {
+				e.Accelerators = map[string]float64{}
+			}
+			e.Accelerators[name] = float64(v)
+			e.EnergyTotal += float64(v)
+		}
+		b.containers[c.Namespace+"/"+c.Name] = e
+	}
+	return b
+}
+
+// UpdateEnergy sums the energy of a sample into its bucket, it is registered with collector.OnSample
+func UpdateEnergy(s *collector.Snapshot) {
+	energyLock.Lock()
+	defer energyLock.Unlock()
+	start := lastEnergySample
+	lastEnergySample = s.Time
+	if start.IsZero() || s.Time.Sub(start) > time.Hour {
+		// the first sample has no interval to compute the power over
+		start = s.Time
+	}
+	lastEnergy = sampleEnergy(s, start)
+	bucketStart := s.Time.Truncate(energyBucket)
+	if n := len(energyBuckets); n == 0 || energyBuckets[n-1].end.Truncate(energyBucket) != bucketStart {
+		energyBuckets = append(energyBuckets, &energyBucketSums{containers: map[string]*ContainerEnergy{}})
+	}
+	energyBuckets[len(energyBuckets)-1].add(lastEnergy)
+	expired := 0
+	for expired < len(energyBuckets) && s.Time.Sub(energyBuckets[expired].end) > maxWindow {
+		expired++
+	}
+	energyBuckets = energyBuckets[expired:]
+}
+
+// energyOver returns the energies of the last sample, or summed over the buckets ending within window
+func energyOver(window time.Duration) (*energyBucketSums, error) {
+	energyLock.Lock()
+	defer energyLock.Unlock()
+	if lastEnergy == nil {
+		return nil, errNoSample
+	}
+	if window <= 0 {
+		return lastEnergy, nil
+	}
+	if window > maxWindow {
+		return nil, fmt.Errorf("window %v longer than the energy window %v", window, maxWindow)
+	}
+	sum := &energyBucketSums{containers: map[string]*ContainerEnergy{}}
+	for _, b := range energyBuckets {
+		if lastEnergy.end.Sub(b.end) < window {
+			sum.add(b)
+		}
+	}
+	return sum, nil
+}
+
+// averagePower sets the average power (W) of an energy over seconds
+func (e *Energy) averagePower(seconds float64) {
+	if seconds > 0 {
+		/* power (W) = energy (mJ) / 1000 / time(second) */
+		e.AveragePower = e.EnergyTotal / 1000 / seconds
+	}
+}
+
+// parseWindow parses the window parameter, none is the last sample
+func parseWindow(r *http.Request) (time.Duration, error) {
+	s := r.FormValue("window")
+	if len(s) == 0 {
+		return 0, nil
+	}
+	window, err := parseStep(s)
+	if err != nil || window < 0 {
+		return 0, fmt.Errorf("invalid window %q", s)
+	}
+	return window, nil
+}
+
+// writeEnergyError writes the errors of the energy endpoints, unavailable before the first sample
+func writeEnergyError(w http.ResponseWriter, err error) {
+	if err == errNoSample {
+		writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", ErrorType: "unavailable", Error: err.Error()})
+		return
+	}
+	writeError(w, err)
+}
+
+// RegisterEnergy adds the container and node energy endpoints to the default mux. The containers are
+// filtered with the comma separated namespace parameter and sorted by their energy.
+func RegisterEnergy() {
+	http.HandleFunc(containersPath, func(w http.ResponseWriter, r *http.Request) {
+		window, err := parseWindow(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		namespaces := map[string]bool{}
+		for _, ns := range strings.Split(r.FormValue("namespace"), ",") {
+			if ns = strings.TrimSpace(ns); len(ns) > 0 {
+				namespaces[ns] = true
+			}
+		}
+		sum, err := energyOver(window)
+		if err != nil {
+			writeEnergyError(w, err)
+			return
+		}
+		seconds := sum.end.Sub(sum.start).Seconds()
+		data := ContainersEnergy{Start: sum.start, End: sum.end, Containers: []ContainerEnergy{}}
+		for _, c := range sum.containers {
+			if len(namespaces) > 0 && !namespaces[c.Namespace] {
+				continue
+			}
+			e := *c
+			e.averagePower(seconds)
+			data.Containers = append(data.Containers, e)
+		}
+		sort.Slice(data.Containers, func(i, j int) bool {
+			return data.Containers[i].EnergyTotal > data.Containers[j].EnergyTotal
+		})
+		writeReport(w, data)
+	})
+	http.HandleFunc(nodePath, func(w http.ResponseWriter, r *http.Request) {
+		window, err := parseWindow(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		sum, err := energyOver(window)
+		if err != nil {
+			writeEnergyError(w, err)
+			return
+		}
+		data := NodeEnergyWindow{Start: sum.start, End: sum.end, Node: sum.node}
+		data.Node.averagePower(sum.end.Sub(sum.start).Seconds())
+		writeReport(w, data)
+	})
+}
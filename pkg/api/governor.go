@@ -0,0 +1,34 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"net/http"
+
+	"github.com/sustainable-computing-io/kepler/pkg/governor"
+)
+
+// The governor endpoint returns the governor and EPP recommended over the last window, and the applied
+// tunings with the node power before and after.
+const governorPath = "/api/v1/governor"
+
+// RegisterGovernor adds the governor endpoint to the default mux
+func RegisterGovernor(a *governor.Advisor) {
+	http.HandleFunc(governorPath, func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, a.Report())
+	})
+}
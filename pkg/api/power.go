@@ -0,0 +1,162 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/power/solar"
+	"github.com/sustainable-computing-io/kepler/pkg/units"
+)
+
+// The dashboard is a single static page for the technicians plugging a laptop into a field device,
+// it polls the live power endpoint and, when the history is enabled, the query API.
+const (
+	powerPath     = "/api/v1/power"
+	unitsPath     = "/api/v1/units"
+	DashboardPath = "/dashboard/"
+)
+
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// Power is the power in W of the last sample
+type Power struct {
+	Time       time.Time                     `json:"time"`
+	BootID     string                        `json:"boot_id"`
+	Sequence   uint64                        `json:"sequence"`
+	Node       NodePower                     `json:"node"`
+	Containers []ContainerPower              `json:"containers"`
+	Solar      *solar.Status                 `json:"solar,omitempty"`
+	Ambient    map[string]map[string]float64 `json:"ambient,omitempty"`
+}
+
+type NodePower struct {
+	Name  string  `json:"name"`
+	Total float64 `json:"total"`
+	Core  float64 `json:"core"`
+	Dram  float64 `json:"dram"`
+	GPU   float64 `json:"gpu"`
+	Other float64 `json:"other"`
+}
+
+type ContainerPower struct {
+	Name      string  `json:"name"`
+	Namespace string  `json:"namespace"`
+	Total     float64 `json:"total"`
+}
+
+// Units are the units and the locale the dashboard shows the energies and the powers in
+type Units struct {
+	Energy       string  `json:"energy"`
+	EnergyFactor float64 `json:"energy_factor"`
+	Power        string  `json:"power"`
+	PowerFactor  float64 `json:"power_factor"`
+	Locale       string  `json:"locale"`
+}
+
+var (
+	power          *Power
+	lastSample     time.Time
+	powerLock      sync.Mutex
+	dashboardUnits = Units{Energy: units.DefaultEnergy, EnergyFactor: 1, Power: units.DefaultPower, PowerFactor: 1, Locale: "c"}
+)
+
+// SetDashboardUnits sets the units and the locale of the dashboard
+func SetDashboardUnits(f *units.Format) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	dashboardUnits = Units{
+		Energy:       f.EnergyUnit(),
+		EnergyFactor: f.EnergyFactor(),
+		Power:        f.PowerUnit(),
+		PowerFactor:  f.PowerFactor(),
+		Locale:       f.Language(),
+	}
+}
+
+// UpdatePower computes the power of a sample, it is registered with collector.OnSample
+func UpdatePower(s *collector.Snapshot) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	seconds := s.Time.Sub(lastSample).Seconds()
+	lastSample = s.Time
+	if seconds <= 0 || seconds > time.Hour.Seconds() {
+		// the first sample has no interval to compute the power over
+		return
+	}
+	/* power (W) = energy (mJ) / 1000 / time(second) */
+	watts := func(mJ float64) float64 {
+		return mJ / 1000 / seconds
+	}
+	node := s.EdgeDevice
+	p := &Power{
+		Time:     s.Time,
+		BootID:   s.BootID,
+		Sequence: s.Sequence,
+		Node: NodePower{
+			Name:  node.Name,
+			Total: watts(node.EnergyInCore + node.EnergyInDram + node.EnergyInGPU + node.EnergyInOther),
+			Core:  watts(node.EnergyInCore),
+			Dram:  watts(node.EnergyInDram),
+			GPU:   watts(node.EnergyInGPU),
+			Other: watts(node.EnergyInOther),
+		},
+		Containers: make([]ContainerPower, 0, len(s.Containers)),
+		Solar:      node.Solar,
+		Ambient:    node.Ambient,
+	}
+	for _, c := range s.Containers {
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, e := range c.Accelerators {
+			energy += e
+		}
+		p.Containers = append(p.Containers, ContainerPower{Name: c.Name, Namespace: c.Namespace, Total: watts(float64(energy))})
+	}
+	sort.Slice(p.Containers, func(i, j int) bool {
+		return p.Containers[i].Total > p.Containers[j].Total
+	})
+	power = p
+}
+
+// RegisterDashboard adds the live power and the units endpoints and the dashboard to the default mux
+func RegisterDashboard() {
+	http.HandleFunc(powerPath, func(w http.ResponseWriter, r *http.Request) {
+		powerLock.Lock()
+		p := power
+		powerLock.Unlock()
+		if p == nil {
+			writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", ErrorType: "unavailable", Error: "no sample yet"})
+			return
+		}
+		writeData(w, p)
+	})
+	http.HandleFunc(unitsPath, func(w http.ResponseWriter, r *http.Request) {
+		powerLock.Lock()
+		u := dashboardUnits
+		powerLock.Unlock()
+		writeData(w, u)
+	})
+	files, _ := fs.Sub(dashboardFiles, "dashboard")
+	http.Handle(DashboardPath, http.StripPrefix(DashboardPath, http.FileServer(http.FS(files))))
+}
@@ -0,0 +1,60 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The sample endpoint takes a sample right away and returns its snapshot, for the scripted experiments
+// on the edge benches: sample, run the workload, sample again and compare the energies.
+const (
+	samplePath    = "/api/v1/sample"
+	sampleTimeout = 30 * time.Second
+)
+
+// RegisterSample adds the on-demand sample endpoint to the default mux, it must be POSTed
+func RegisterSample() {
+	http.HandleFunc(samplePath, func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), sampleTimeout)
+		defer cancel()
+		s, err := collector.SampleNow(ctx)
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", ErrorType: "timeout", Error: fmt.Sprintf("no sample taken: %v", err)})
+			return
+		}
+		writeData(w, s)
+	})
+}
+
+// requirePost answers 405 to the requests other than POST
+func requirePost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method == http.MethodPost {
+		return true
+	}
+	w.Header().Set("Allow", http.MethodPost)
+	writeJSON(w, http.StatusMethodNotAllowed, response{Status: "error", ErrorType: "bad_data", Error: "the request must be POSTed"})
+	return false
+}
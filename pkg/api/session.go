@@ -0,0 +1,69 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package api
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The session endpoints measure the energy of named windows: POST start?name=, run the operation, then
+// POST stop?name= returns the energy of each container within the window. The running sessions are listed
+// with their energy so far.
+const (
+	sessionsPath     = "/api/v1/sessions"
+	sessionStartPath = "/api/v1/sessions/start"
+	sessionStopPath  = "/api/v1/sessions/stop"
+)
+
+// RegisterSessions adds the measurement session endpoints to the default mux
+func RegisterSessions() {
+	http.HandleFunc(sessionsPath, func(w http.ResponseWriter, r *http.Request) {
+		writeReport(w, collector.GetSessions())
+	})
+	http.HandleFunc(sessionStartPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		s, err := collector.StartSession(r.FormValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReport(w, s)
+	})
+	http.HandleFunc(sessionStopPath, func(w http.ResponseWriter, r *http.Request) {
+		if !requirePost(w, r) {
+			return
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), sampleTimeout)
+		defer cancel()
+		s, err := collector.StopSession(ctx, r.FormValue("name"))
+		if err == context.DeadlineExceeded || err == context.Canceled {
+			writeJSON(w, http.StatusServiceUnavailable, response{Status: "error", ErrorType: "timeout", Error: fmt.Sprintf("no sample taken: %v", err)})
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		writeReport(w, s)
+	})
+}
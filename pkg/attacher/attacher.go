@@ -0,0 +1,393 @@
+/*
+Copyright 2021.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attacher
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"strings"
+
+	"golang.org/x/sys/unix"
+
+	assets "github.com/sustainable-computing-io/kepler/pkg/bpf_assets"
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+
+	"github.com/cilium/ebpf"
+	"github.com/cilium/ebpf/link"
+	"github.com/cilium/ebpf/rlimit"
+)
+
+const defaultMapSize = 10240
+
+// Each accounting concern is a BPF module, a program with its own maps loaded on its own, so the constrained
+// devices can run only the CPU module: cpu accounts the CPU time and hardware counters of the processes,
+// net their socket traffic. The modules stream their events through a perf buffer, which the collector
+// aggregates per process, instead of keeping them in maps the collector reads and resets.
+const (
+	ModuleCPU = "cpu"
+	ModuleNet = "net"
+)
+
+type perfCounter struct {
+	evType   int
+	evConfig int
+	enabled  bool
+}
+
+// Module is a loaded BPF module, its maps and programs and the links attaching the programs
+type Module struct {
+	collection *ebpf.Collection
+	links      []link.Link
+}
+
+type BpfModuleTables struct {
+	// Modules are the attached BPF modules by name
+	Modules map[string]*Module
+	// Processes are the process events of the CPU module, nil without it
+	Processes *EventStream
+	// Traffic are the traffic events of the net module, nil without it
+	Traffic *EventStream
+}
+
+var (
+	Counters = map[string]perfCounter{
+		"cpu_cycles": {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CPU_CYCLES, true},
+		"cpu_instr":  {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_INSTRUCTIONS, true},
+		"cache_miss": {unix.PERF_TYPE_HARDWARE, unix.PERF_COUNT_HW_CACHE_MISSES, true},
+	}
+	EnableCPUFreq = true
+	// ScaleMultiplexed is set when the counters are read with their enabled and running time, which
+	// needs kernel 4.15, and scaled when the PMU is multiplexed
+	ScaleMultiplexed = true
+	// counterOrder is the index of the counters in the counter_time array
+	counterOrder = []string{"cpu_cycles", "cpu_instr", "cache_miss"}
+	// MapSize is the capacity of the pid_time map and of the processes and traffic the collector
+	// aggregates per sample
+	MapSize = defaultMapSize
+	// mapOrder is the index of the maps in the map_update_failures array of the modules
+	mapOrder = map[string][]string{
+		ModuleCPU: {"pid_time"},
+	}
+	// moduleOrder is the order the modules are loaded in, moduleLoaders their loaders
+	moduleOrder   = []string{ModuleCPU, ModuleNet}
+	moduleLoaders = map[string]func() (*Module, error){
+		ModuleCPU: loadCPUModule,
+		ModuleNet: loadNetModule,
+	}
+	// Modules are the enabled BPF modules
+	Modules = map[string]bool{ModuleCPU: true, ModuleNet: true}
+	// cpuMaps are the arrays of the CPU module indexed by CPU, with their entries per CPU
+	cpuMaps = map[string]int{
+		"prev_cpu_cycles":    1,
+		"prev_cpu_instr":     1,
+		"prev_cache_miss":    1,
+		"prev_counter_value": len(counterOrder),
+	}
+	// kernel functions probed for the socket traffic of the processes, by probe function
+	netProbes = map[string][]string{
+		"tcp_sendmsg_entry":      {"tcp_sendmsg"},
+		"tcp_cleanup_rbuf_entry": {"tcp_cleanup_rbuf"},
+		"udp_sendmsg_entry":      {"udp_sendmsg", "udpv6_sendmsg"},
+		"skb_consume_udp_entry":  {"skb_consume_udp"},
+	}
+	EnableNetwork = true
+)
+
+// loadSpec reads the object of a module
+func loadSpec(program string) (*ebpf.CollectionSpec, error) {
+	obj, err := assets.Asset(program)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get program %q: %v", program, err)
+	}
+	spec, err := ebpf.LoadCollectionSpecFromReader(bytes.NewReader(obj))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse program %q: %v", program, err)
+	}
+	return spec, nil
+}
+
+// newModule loads the maps and the given programs of a module, the others are not loaded since the
+// kernel may not support them
+func newModule(spec *ebpf.CollectionSpec, programs ...string) (*Module, error) {
+	spec = spec.Copy()
+	for name := range spec.Programs {
+		keep := false
+		for _, program := range programs {
+			keep = keep || name == program
+		}
+		if !keep {
+			delete(spec.Programs, name)
+		}
+	}
+	collection, err := ebpf.NewCollection(spec)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load the programs %v: %v", programs, err)
+	}
+	return &Module{collection: collection}, nil
+}
+
+// Close detaches the programs of the module and releases its maps
+func (m *Module) Close() {
+	for _, l := range m.links {
+		l.Close()
+	}
+	m.collection.Close()
+}
+
+func loadModule(spec *ebpf.CollectionSpec, program string) (*Module, error) {
+	m, err := newModule(spec, program)
+	if err != nil {
+		return nil, err
+	}
+	//TODO make all entrypoints yaml-declarable
+	l, err := link.Tracepoint("sched", "sched_switch", m.collection.Programs[program], nil)
+	if err != nil {
+		m.Close()
+		return nil, fmt.Errorf("failed to attach %s: %s", program, err)
+	}
+	m.links = append(m.links, l)
+
+	for arrayName, counter := range Counters {
+		t := m.collection.Maps[arrayName]
+		if t == nil {
+			m.Close()
+			return nil, fmt.Errorf("failed to find perf array: %s", arrayName)
+		}
+		model.SetBMCoeff()
+		perfErr := openPerfEvent(t, counter.evType, counter.evConfig)
+		if perfErr != nil {
+			// some hypervisors don't expose perf counters
+			fmt.Printf("failed to attach perf event %s: %v\n", arrayName, perfErr)
+			counter.enabled = false
+			// if perf counters are not available, it is likely running on a VM
+			model.SetVMCoeff()
+		}
+	}
+	return m, nil
+}
+
+// attachNetProbes attaches the socket traffic probes, the net module is not loaded if any is missing
+// since a partial count (e.g. TCP without UDP) would skew the NIC model
+func attachNetProbes(m *Module) error {
+	for probe, functions := range netProbes {
+		prog := m.collection.Programs[probe]
+		if prog == nil {
+			return fmt.Errorf("failed to load %s", probe)
+		}
+		for _, function := range functions {
+			l, err := link.Kprobe(function, prog, nil)
+			if err != nil {
+				return fmt.Errorf("failed to attach %s to %s: %v", probe, function, err)
+			}
+			m.links = append(m.links, l)
+		}
+	}
+	return nil
+}
+
+// SetModules enables the comma separated BPF modules, cpu and net, it applies to the next attach
+func SetModules(names string) error {
+	modules := map[string]bool{}
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		if _, ok := moduleLoaders[name]; !ok {
+			return fmt.Errorf("unknown bpf module %q, cpu or net", name)
+		}
+		modules[name] = true
+	}
+	if len(modules) == 0 {
+		return fmt.Errorf("no bpf module enabled")
+	}
+	Modules = modules
+	return nil
+}
+
+// AttachBPFAssets loads the enabled modules and opens their event streams, the CPU module must load
+// while the traffic is not accounted if the net module fails
+func AttachBPFAssets() (*BpfModuleTables, error) {
+	bpfModules := &BpfModuleTables{Modules: map[string]*Module{}}
+	// the kernels before 5.11 charge the BPF maps to the locked memory limit
+	if err := rlimit.RemoveMemlock(); err != nil {
+		fmt.Printf("failed to raise the locked memory limit: %v\n", err)
+	}
+	if !Modules[ModuleCPU] {
+		EnableCPUFreq = false
+		ScaleMultiplexed = false
+	}
+	EnableNetwork = false
+	for _, name := range moduleOrder {
+		if !Modules[name] {
+			continue
+		}
+		m, err := moduleLoaders[name]()
+		var events *EventStream
+		if err == nil {
+			if events, err = openEventStream(m, "events"); err != nil {
+				m.Close()
+			}
+		}
+		if err != nil {
+			if name == ModuleNet {
+				fmt.Printf("failed to attach the net module, the traffic is not accounted: %v\n", err)
+				continue
+			}
+			DetachBPFModules(bpfModules)
+			return nil, err
+		}
+		bpfModules.Modules[name] = m
+		switch name {
+		case ModuleCPU:
+			bpfModules.Processes = events
+		case ModuleNet:
+			bpfModules.Traffic = events
+			EnableNetwork = true
+		}
+	}
+	if len(bpfModules.Modules) == 0 {
+		return nil, fmt.Errorf("no bpf module attached")
+	}
+	return bpfModules, nil
+}
+
+// loadCPUModule loads the CPU module with the features the kernel supports
+func loadCPUModule() (*Module, error) {
+	spec, err := loadSpec(assets.Program)
+	if err != nil {
+		return nil, err
+	}
+	if m, ok := spec.Maps["pid_time"]; ok {
+		m.MaxEntries = uint32(MapSize)
+	}
+	for name, entries := range cpuMaps {
+		if m, ok := spec.Maps[name]; ok {
+			m.MaxEntries = uint32(runtime.NumCPU() * entries)
+		}
+	}
+	// the CPU time per core is aggregated from the CPU of the events
+	EnableCPUFreq = true
+	ScaleMultiplexed = true
+	m, err := loadModule(spec, "sched_switch")
+	if err != nil {
+		fmt.Printf("failed to attach perf module with scaled counters: %v\n", err)
+		ScaleMultiplexed = false
+		m, err = loadModule(spec, "sched_switch_unscaled")
+		if err != nil {
+			fmt.Printf("failed to attach perf module: %v\n", err)
+			// at this time, there is not much we can do with the eBPF module
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// loadNetModule loads the net module and attaches its socket probes
+func loadNetModule() (*Module, error) {
+	spec, err := loadSpec(assets.NetProgram)
+	if err != nil {
+		return nil, err
+	}
+	probes := make([]string, 0, len(netProbes))
+	for probe := range netProbes {
+		probes = append(probes, probe)
+	}
+	m, err := newModule(spec, probes...)
+	if err != nil {
+		return nil, err
+	}
+	if err = attachNetProbes(m); err != nil {
+		m.Close()
+		return nil, err
+	}
+	return m, nil
+}
+
+// SetMapSize sets the capacity of the pid_time map and of the aggregated processes and traffic, busy
+// nodes with many short lived processes need more than the default, it applies to the next attach
+func SetMapSize(size int) {
+	if size <= 0 {
+		size = defaultMapSize
+	}
+	MapSize = size
+}
+
+// ReadMapUpdateFailures returns the cumulative failed inserts into each map, the processes dropped
+// from the accounting when the map was full
+func ReadMapUpdateFailures(bpfModules *BpfModuleTables) map[string]uint64 {
+	if bpfModules == nil {
+		return nil
+	}
+	failures := map[string]uint64{}
+	for module, m := range bpfModules.Modules {
+		names, ok := mapOrder[module]
+		table := m.collection.Maps["map_update_failures"]
+		if !ok || table == nil {
+			continue
+		}
+		for i, name := range names {
+			var n uint64
+			if err := table.Lookup(uint32(i), &n); err != nil {
+				continue
+			}
+			failures[name] = n
+		}
+	}
+	return failures
+}
+
+// CounterTime is the enabled and running time (ns) of a hardware counter summed over the CPUs
+type CounterTime struct {
+	Enabled uint64
+	Running uint64
+}
+
+// ReadCounterTime returns the cumulative enabled and running time of each counter, nil when the
+// counters are not read with their times
+func ReadCounterTime(bpfModules *BpfModuleTables) map[string]CounterTime {
+	if !ScaleMultiplexed || bpfModules == nil {
+		return nil
+	}
+	m, ok := bpfModules.Modules[ModuleCPU]
+	if !ok || m.collection.Maps["counter_time"] == nil {
+		return nil
+	}
+	table := m.collection.Maps["counter_time"]
+	times := map[string]CounterTime{}
+	for i, name := range counterOrder {
+		var t CounterTime
+		if err := table.Lookup(uint32(i), &t); err != nil {
+			continue
+		}
+		times[name] = t
+	}
+	return times
+}
+
+func DetachBPFModules(bpfModules *BpfModuleTables) {
+	for _, s := range []*EventStream{bpfModules.Processes, bpfModules.Traffic} {
+		if s != nil {
+			s.stop()
+		}
+	}
+	closePerfEvent()
+	for _, m := range bpfModules.Modules {
+		m.Close()
+	}
+}
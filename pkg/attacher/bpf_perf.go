@@ -16,81 +16,86 @@ limitations under the License.
 
 package attacher
 
-/*
- temporary placeholder till PR resolved
- https://github.com/iovisor/gobpf/pull/310
-*/
-
 import (
-	"encoding/binary"
 	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
 	"unsafe"
 
-	bpf "github.com/iovisor/gobpf/bcc"
-	"github.com/iovisor/gobpf/pkg/cpuonline"
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
 )
 
-/*
-#cgo CFLAGS: -I/usr/include/bcc/compat
-#cgo LDFLAGS: -lbcc
-#include <bcc/bcc_common.h>
-#include <bcc/libbpf.h>
-*/
-import "C"
-
-var (
-	perfEvents = map[string][]int{}
-	byteOrder  binary.ByteOrder
-)
+const cpuOnlinePath = "/sys/devices/system/cpu/online"
 
-func init() {
-	byteOrder = bpf.GetHostByteOrder()
-}
+var perfEvents = map[string][]int{}
 
-func determineHostByteOrder() binary.ByteOrder {
-	var i int32 = 0x01020304
-	u := unsafe.Pointer(&i)
-	pb := (*byte)(u)
-	b := *pb
-	if b == 0x04 {
-		return binary.LittleEndian
+// onlineCPUs returns the online CPUs, listed as ranges such as 0-3,6
+func onlineCPUs() ([]int, error) {
+	data, err := ioutil.ReadFile(cpuOnlinePath)
+	if err != nil {
+		return nil, err
 	}
-
-	return binary.BigEndian
+	cpus := []int{}
+	for _, r := range strings.Split(strings.TrimSpace(string(data)), ",") {
+		bounds := strings.SplitN(r, "-", 2)
+		first, err := strconv.Atoi(bounds[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cpu range %q: %v", r, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.Atoi(bounds[1]); err != nil {
+				return nil, fmt.Errorf("failed to parse cpu range %q: %v", r, err)
+			}
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, cpu)
+		}
+	}
+	return cpus, nil
 }
 
-func openPerfEvent(table *bpf.Table, typ, config int) error {
+// openPerfEvent opens a hardware counter on every online CPU and stores it in the perf array read by
+// the CPU module
+func openPerfEvent(table *ebpf.Map, typ, config int) error {
 	perfKey := fmt.Sprintf("%d:%d", typ, config)
 	if _, ok := perfEvents[perfKey]; ok {
 		return nil
 	}
 
-	cpus, err := cpuonline.Get()
+	cpus, err := onlineCPUs()
 	if err != nil {
 		return fmt.Errorf("failed to determine online cpus: %v", err)
 	}
-	keySize := table.Config()["key_size"].(uint64)
-	leafSize := table.Config()["leaf_size"].(uint64)
 
-	if keySize != 4 || leafSize != 4 {
+	if table.KeySize() != 4 || table.ValueSize() != 4 {
 		return fmt.Errorf("passed table has wrong size")
 	}
 
 	res := []int{}
 
 	for _, i := range cpus {
-		fd, err := C.bpf_open_perf_event(C.uint(typ), C.ulong(config), C.int(-1), C.int(i))
-		if fd < 0 {
+		attr := unix.PerfEventAttr{
+			Type:   uint32(typ),
+			Config: uint64(config),
+		}
+		attr.Size = uint32(unsafe.Sizeof(attr))
+		fd, err := unix.PerfEventOpen(&attr, -1, i, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			closeFds(res)
 			return fmt.Errorf("failed to open bpf perf event: %v", err)
 		}
-		key := make([]byte, keySize)
-		leaf := make([]byte, leafSize)
-		byteOrder.PutUint32(key, uint32(i))
-		byteOrder.PutUint32(leaf, uint32(fd))
-		keyP := unsafe.Pointer(&key[0])
-		leafP := unsafe.Pointer(&leaf[0])
-		table.SetP(keyP, leafP)
-		res = append(res, int(fd))
+		res = append(res, fd)
+		if err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			closeFds(res)
+			return fmt.Errorf("failed to enable bpf perf event: %v", err)
+		}
+		if err = table.Put(uint32(i), uint32(fd)); err != nil {
+			closeFds(res)
+			return fmt.Errorf("failed to store bpf perf event: %v", err)
+		}
 	}
 
 	perfEvents[perfKey] = res
@@ -98,10 +103,15 @@ func openPerfEvent(table *bpf.Table, typ, config int) error {
 	return nil
 }
 
+func closeFds(fds []int) {
+	for _, fd := range fds {
+		unix.Close(fd)
+	}
+}
+
 func closePerfEvent() {
 	for _, vs := range perfEvents {
-		for _, v := range vs {
-			C.bpf_close_perf_event_fd((C.int)(v))
-		}
+		closeFds(vs)
 	}
+	perfEvents = map[string][]int{}
 }
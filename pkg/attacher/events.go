@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attacher
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/cilium/ebpf/perf"
+)
+
+const (
+	// eventBufferSize is the number of events queued for the collector before the perf buffer fills
+	eventBufferSize = 4096
+	// perfBufferPages is the size of the perf buffer of each CPU in pages
+	perfBufferPages = 8
+)
+
+// EventStream is the perf buffer of a module, its events are received on Events and the number of
+// events the kernel dropped when the buffer was full on Lost
+type EventStream struct {
+	Events chan []byte
+	Lost   chan uint64
+	reader *perf.Reader
+	done   chan struct{}
+}
+
+// openEventStream starts polling the perf buffer of a module
+func openEventStream(m *Module, name string) (*EventStream, error) {
+	table := m.collection.Maps[name]
+	if table == nil {
+		return nil, fmt.Errorf("failed to find perf buffer %s", name)
+	}
+	reader, err := perf.NewReader(table, perfBufferPages*os.Getpagesize())
+	if err != nil {
+		return nil, fmt.Errorf("failed to open perf buffer %s: %v", name, err)
+	}
+	s := &EventStream{
+		Events: make(chan []byte, eventBufferSize),
+		Lost:   make(chan uint64, 16),
+		reader: reader,
+		done:   make(chan struct{}),
+	}
+	go s.poll()
+	return s, nil
+}
+
+// poll forwards the records of the perf buffer until the stream is stopped
+func (s *EventStream) poll() {
+	for {
+		record, err := s.reader.Read()
+		if err != nil {
+			if errors.Is(err, perf.ErrClosed) {
+				return
+			}
+			continue
+		}
+		if record.LostSamples > 0 {
+			select {
+			case s.Lost <- record.LostSamples:
+			case <-s.done:
+				return
+			}
+			continue
+		}
+		select {
+		case s.Events <- record.RawSample:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// stop stops polling the perf buffer, the pending events are discarded so the poller does not block
+// once the collector stopped receiving
+func (s *EventStream) stop() {
+	close(s.done)
+	s.reader.Close()
+}
@@ -0,0 +1,215 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package attacher
+
+import (
+	"bytes"
+	"fmt"
+	"unsafe"
+
+	"github.com/cilium/ebpf"
+	"golang.org/x/sys/unix"
+
+	assets "github.com/sustainable-computing-io/kepler/pkg/bpf_assets"
+)
+
+// The profile module is loaded on demand, apart from the accounting modules, it samples the stacks of the
+// profiled cgroups at the frequency of a CPU clock event opened on every CPU.
+const (
+	DefaultProfileFrequency = 99
+	profileStackDepth       = 127
+)
+
+// stackKey is the stack_key_t of the profile module
+type stackKey struct {
+	CgroupID      uint64
+	PID           uint32
+	UserStackID   int32
+	KernelStackID int32
+	Pad           uint32
+	Comm          [16]byte
+}
+
+// StackSample is the number of samples of a process in a pair of stacks, the frames are the instruction
+// pointers from the innermost, nil when the stack was not captured
+type StackSample struct {
+	CgroupID uint64
+	PID      uint32
+	Comm     string
+	User     []uint64
+	Kernel   []uint64
+	Count    uint64
+}
+
+// Profile is the attached profile module
+type Profile struct {
+	module *Module
+	fds    []int
+}
+
+// StartProfile loads the profile module and samples the stacks frequency times per second on every CPU,
+// no stack is sampled before the cgroups are set
+func StartProfile(frequency int) (*Profile, error) {
+	if frequency <= 0 {
+		return nil, fmt.Errorf("invalid profile frequency %d", frequency)
+	}
+	spec, err := loadSpec(assets.ProfileProgram)
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"stack_counts", "stack_traces"} {
+		if m, ok := spec.Maps[name]; ok {
+			m.MaxEntries = uint32(MapSize)
+		}
+	}
+	m, err := newModule(spec, "profile_sample")
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{module: m}
+	if err = p.openClockEvents(m.collection.Programs["profile_sample"], frequency); err != nil {
+		p.Close()
+		return nil, err
+	}
+	return p, nil
+}
+
+// openClockEvents opens a CPU clock event sampling at frequency on every online CPU and attaches the
+// program to them
+func (p *Profile) openClockEvents(prog *ebpf.Program, frequency int) error {
+	cpus, err := onlineCPUs()
+	if err != nil {
+		return fmt.Errorf("failed to determine online cpus: %v", err)
+	}
+	for _, cpu := range cpus {
+		attr := unix.PerfEventAttr{
+			Type:   unix.PERF_TYPE_SOFTWARE,
+			Config: unix.PERF_COUNT_SW_CPU_CLOCK,
+			Sample: uint64(frequency),
+			Bits:   unix.PerfBitFreq,
+		}
+		attr.Size = uint32(unsafe.Sizeof(attr))
+		fd, err := unix.PerfEventOpen(&attr, -1, cpu, -1, unix.PERF_FLAG_FD_CLOEXEC)
+		if err != nil {
+			return fmt.Errorf("failed to open the clock event of cpu %d: %v", cpu, err)
+		}
+		p.fds = append(p.fds, fd)
+		if err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, prog.FD()); err != nil {
+			return fmt.Errorf("failed to attach the profile program: %v", err)
+		}
+		if err = unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+			return fmt.Errorf("failed to enable the clock event of cpu %d: %v", cpu, err)
+		}
+	}
+	return nil
+}
+
+// SetCgroups sets the cgroups whose stacks are sampled
+func (p *Profile) SetCgroups(cgroupIDs []uint64) error {
+	table := p.module.collection.Maps["profiled_cgroups"]
+	wanted := map[uint64]bool{}
+	for _, id := range cgroupIDs {
+		wanted[id] = true
+	}
+	var id uint64
+	var flag uint8
+	stale := []uint64{}
+	iter := table.Iterate()
+	for iter.Next(&id, &flag) {
+		if !wanted[id] {
+			stale = append(stale, id)
+		}
+		delete(wanted, id)
+	}
+	for _, id := range stale {
+		if err := table.Delete(id); err != nil {
+			return fmt.Errorf("failed to remove profiled cgroup %d: %v", id, err)
+		}
+	}
+	for id := range wanted {
+		if err := table.Put(id, uint8(1)); err != nil {
+			return fmt.Errorf("failed to add profiled cgroup %d: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// Drain returns the stacks sampled since the last drain and the samples dropped when the map was full.
+// The counts incremented between their read and their removal are lost, a few samples per drain.
+func (p *Profile) Drain() ([]StackSample, uint64, error) {
+	counts := p.module.collection.Maps["stack_counts"]
+	traces := p.module.collection.Maps["stack_traces"]
+	var key stackKey
+	var count uint64
+	keys := []stackKey{}
+	samples := []StackSample{}
+	iter := counts.Iterate()
+	for iter.Next(&key, &count) {
+		keys = append(keys, key)
+		samples = append(samples, StackSample{
+			CgroupID: key.CgroupID,
+			PID:      key.PID,
+			Comm:     string(bytes.TrimRight(key.Comm[:], "\x00")),
+			Count:    count,
+		})
+	}
+	if err := iter.Err(); err != nil {
+		return nil, 0, fmt.Errorf("failed to read the stack counts: %v", err)
+	}
+	stacks := map[int32][]uint64{}
+	stack := func(id int32) []uint64 {
+		if id < 0 {
+			return nil
+		}
+		if frames, ok := stacks[id]; ok {
+			return frames
+		}
+		var ips [profileStackDepth]uint64
+		var frames []uint64
+		if err := traces.Lookup(uint32(id), &ips); err == nil {
+			for _, ip := range ips {
+				if ip == 0 {
+					break
+				}
+				frames = append(frames, ip)
+			}
+		}
+		stacks[id] = frames
+		return frames
+	}
+	for i, k := range keys {
+		samples[i].User = stack(k.UserStackID)
+		samples[i].Kernel = stack(k.KernelStackID)
+		_ = counts.Delete(k)
+	}
+	// the stacks are removed once resolved so the map does not fill, they are captured again if sampled
+	for id := range stacks {
+		_ = traces.Delete(uint32(id))
+	}
+	var dropped uint64
+	if table := p.module.collection.Maps["dropped_samples"]; table != nil && table.Lookup(uint32(0), &dropped) == nil && dropped > 0 {
+		_ = table.Put(uint32(0), uint64(0))
+	}
+	return samples, dropped, nil
+}
+
+// Close detaches the profile program and releases its maps
+func (p *Profile) Close() {
+	closeFds(p.fds)
+	p.fds = nil
+	p.module.Close()
+}
@@ -16,6 +16,34 @@ limitations under the License.
 
 package bpf_assets
 
+import (
+	"embed"
+	"fmt"
+	"runtime"
+)
+
+// The BPF modules are compiled once per architecture into CO-RE objects embedded in the binary, their
+// field offsets are relocated against the BTF of the running kernel when they are loaded, so the devices
+// need neither BCC nor the kernel headers. The objects are built with make bpf (go generate), which needs
+// clang and the libbpf headers, before the binary: the build fails without them.
+//go:generate ./build_objects.sh
+
 const (
-	Program = "bpf_assets/perf_event/perf_event.c"
+	// Program is the CPU module, NetProgram the net module, ProfileProgram the stack sampling module
+	Program        = "perf_event"
+	NetProgram     = "net"
+	ProfileProgram = "profile"
 )
+
+//go:embed objects/*.bpf.o
+var objects embed.FS
+
+// Asset returns the object of a module for the architecture of the binary
+func Asset(name string) ([]byte, error) {
+	path := fmt.Sprintf("objects/%s_%s.bpf.o", name, runtime.GOARCH)
+	data, err := objects.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s object for %s: %v", name, runtime.GOARCH, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,22 @@
+#!/bin/sh
+# Compiles the BPF modules in bpf_assets into the CO-RE objects embedded by this package, one per
+# module and architecture. Needs clang >= 10, llvm-strip and the libbpf headers (libbpf-devel).
+set -eu
+
+cd "$(dirname "$0")"
+CLANG=${CLANG:-clang}
+STRIP=${STRIP:-llvm-strip}
+SRC=../../bpf_assets
+
+# GOARCH:__TARGET_ARCH of the kprobe registers, both are little endian
+for arch in amd64:x86 arm64:arm64; do
+	goarch=${arch%%:*}
+	target=${arch#*:}
+	for module in perf_event net profile; do
+		out=objects/${module}_${goarch}.bpf.o
+		"$CLANG" -O2 -g -Wall -target bpfel -D__TARGET_ARCH_"$target" \
+			-I"$SRC"/include -c "$SRC/$module/$module.c" -o "$out"
+		# the DWARF is not needed at runtime, the BTF used by the relocations is kept
+		"$STRIP" -g "$out"
+	done
+done
@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"crypto/ed25519"
+	"time"
+
+	"github.com/prometheus/common/version"
+
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+)
+
+// The attestation states how the energy of the device is produced: the exporter build, its settings, the
+// power model coefficients and the sensors of the last sample with their status. It is sealed like the
+// snapshots, so the auditors can check the statement comes from the device key and was not altered.
+type Attestation struct {
+	Time     time.Time `json:"time"`
+	Node     string    `json:"node"`
+	BootID   string    `json:"boot_id"`
+	Version  string    `json:"version"`
+	Revision string    `json:"revision"`
+	// Settings are the flags and the config of the exporter, without the secrets
+	Settings     map[string]string `json:"settings"`
+	SamplePeriod string            `json:"sample_period"`
+	Model        model.Coeff       `json:"model"`
+	// Sensors are the sources of the last sample and their status: measured, estimated, stale or missing
+	Sensors   map[string]string `json:"sensors"`
+	KeyID     string            `json:"key_id,omitempty"`
+	Hash      string            `json:"hash,omitempty"`
+	Signature string            `json:"signature,omitempty"`
+}
+
+var attestedSettings = map[string]string{}
+
+// SetAttestedSettings sets the settings stated by the attestation
+func SetAttestedSettings(settings map[string]string) {
+	lock.Lock()
+	defer lock.Unlock()
+	attestedSettings = settings
+}
+
+// GetAttestation returns the sealed statement of the current measurement configuration
+func GetAttestation() (*Attestation, error) {
+	lock.Lock()
+	a := &Attestation{
+		Time:         time.Now().UTC().Truncate(time.Microsecond),
+		Node:         EdgeDeviceName,
+		BootID:       BootID,
+		Version:      version.Version,
+		Revision:     version.Revision,
+		Settings:     map[string]string{},
+		SamplePeriod: samplePeriod.String(),
+		Model:        model.RunTimeCoeff,
+		Sensors:      map[string]string{},
+		KeyID:        signingKeyID(),
+	}
+	for k, v := range attestedSettings {
+		a.Settings[k] = v
+	}
+	if q := currEdgeDeviceEnergy.Quality; q != nil {
+		for source, status := range q.Sources {
+			a.Sensors[source] = status
+		}
+	}
+	lock.Unlock()
+	var err error
+	a.Hash, a.Signature, err = sealJSON(a)
+	return a, err
+}
+
+// Verify checks the hash of the attestation and its signature with the device public key
+func (a *Attestation) Verify(key ed25519.PublicKey) error {
+	unsealed := *a
+	unsealed.Hash, unsealed.Signature = "", ""
+	return verifyJSON(&unsealed, a.KeyID, a.Hash, a.Signature, key)
+}
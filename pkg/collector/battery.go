@@ -0,0 +1,75 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/battery"
+)
+
+var (
+	// batteryMeter reads the discharge of the batteries, nil if the node has none
+	batteryMeter *battery.Meter
+	// batteryEnergy is the accumulated energy (mJ) discharged by the batteries
+	batteryEnergy float64
+)
+
+// batterySource reads the node energy from the discharge of the batteries
+type batterySource struct{}
+
+func init() {
+	if batteryMeter = battery.NewMeter(); batteryMeter != nil {
+		log.Printf("batteries: %s\n", strings.Join(batteryMeter.Names(), ", "))
+		power.Register(batterySource{}, power.PriorityBattery)
+	}
+}
+
+func (batterySource) Name() string {
+	return "battery"
+}
+
+func (batterySource) Init() error {
+	return nil
+}
+
+func (batterySource) IsAvailable() bool {
+	return true
+}
+
+func (batterySource) GetEnergy(zone string) (float64, error) {
+	if zone != power.ZoneNode {
+		return 0, power.ErrNoZone
+	}
+	return readBatteryEnergy(), nil
+}
+
+func (batterySource) Shutdown() {}
+
+// readBatteryEnergy returns the energy (mJ) discharged by the batteries in the last interval, zero on AC power
+func readBatteryEnergy() float64 {
+	if batteryMeter == nil {
+		return 0
+	}
+	e := batteryMeter.GetEnergy()
+	lock.Lock()
+	defer lock.Unlock()
+	batteryEnergy += e
+	return e
+}
@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+)
+
+// A full pid_time map, or a full aggregation of the processes or traffic, drops the new processes from
+// the accounting without any error. The entries aggregated each sample are the occupancy, which is
+// logged when it nears the capacity, and the failed inserts are the processes dropped. The events the
+// kernel drops while a perf buffer is full are counted by module.
+const mapNearFull = 0.9
+
+var (
+	mapEntries        = map[string]int{}
+	mapUpdateFailures = map[string]uint64{}
+	mapNearFullLogged = map[string]bool{}
+	eventsLost        = map[string]uint64{}
+)
+
+// updateMapOccupancy records the entries and drops of the sample events, the collector lock must be held
+func updateMapOccupancy(modules *attacher.BpfModuleTables, events eventSample) {
+	mapEntries = map[string]int{}
+	if modules.Processes != nil {
+		mapEntries["processes"] = len(events.processes)
+	}
+	if modules.Traffic != nil {
+		mapEntries["traffic"] = len(events.traffic)
+	}
+	failures := attacher.ReadMapUpdateFailures(modules)
+	for name, n := range events.dropped {
+		failures[name] = n
+	}
+	for name, n := range failures {
+		if n > mapUpdateFailures[name] {
+			log.Printf("%d processes dropped, the %s map is full, raise --bpf-map-size (%d)\n", n-mapUpdateFailures[name], name, attacher.MapSize)
+		}
+		mapUpdateFailures[name] = n
+	}
+	for name, entries := range mapEntries {
+		nearFull := float64(entries) >= mapNearFull*float64(attacher.MapSize)
+		if nearFull && !mapNearFullLogged[name] {
+			log.Printf("the %s map is near full, %d entries of %d\n", name, entries, attacher.MapSize)
+		}
+		mapNearFullLogged[name] = nearFull
+	}
+	for module, n := range events.lost {
+		if n > eventsLost[module] {
+			log.Printf("%d events of the %s module lost, the perf buffer is full\n", n-eventsLost[module], module)
+		}
+		eventsLost[module] = n
+	}
+}
@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+// The node breakdown splits the node energy of a sample into components that sum to the measured node
+// energy. The CPU, DRAM, GPU and accelerator energies are measured, the NIC and storage energies are
+// modelled from the traffic and the block I/O and are scaled down to fit in what the measured components
+// leave. The platform energy outside the CPU package and DRAM is counted as platform-other when a source
+// measures it (psys, the SoC rails), the rest is unaccounted. Without a node meter the node energy is the
+// sum of the components. If the measured components exceed the node energy they are scaled down to it.
+const (
+	ComponentCPU           = "cpu"
+	ComponentDRAM          = "dram"
+	ComponentGPU           = "gpu"
+	ComponentAccelerator   = "accelerator"
+	ComponentNIC           = "nic"
+	ComponentStorage       = "storage"
+	ComponentPlatformOther = "platform-other"
+	ComponentUnaccounted   = "unaccounted"
+
+	// DefaultStorageEnergyPerByte is in the range of the SD cards, eMMC and SATA SSDs of edge devices
+	DefaultStorageEnergyPerByte = 5.0 // nJ
+)
+
+var storageEnergyPerByte = DefaultStorageEnergyPerByte
+
+// SetStorageModel sets the energy per byte read or written of the storage model in nJ
+func SetStorageModel(perByte float64) {
+	lock.Lock()
+	defer lock.Unlock()
+	storageEnergyPerByte = perByte
+}
+
+// storageEnergy returns the energy in mJ of the storage model for a block I/O
+func storageEnergy(bytes uint64) float64 {
+	/* energy (mJ) = energy (nJ) / 10^6 */
+	return float64(bytes) * storageEnergyPerByte / 1e6
+}
+
+// breakdownInput holds the energies (mJ) of a sample split by nodeBreakdown
+type breakdownInput struct {
+	// node is the measured node energy, zero without a node meter
+	node float64
+	// the measured components
+	cpu, dram, gpu, accelerator float64
+	// the modelled components
+	nic, storage float64
+	// platform is the measured energy outside the CPU package and DRAM, zero if not measured
+	platform float64
+}
+
+// nodeBreakdown returns the energy per component, summing to the node energy
+func nodeBreakdown(in breakdownInput) map[string]float64 {
+	b := map[string]float64{
+		ComponentCPU:           in.cpu,
+		ComponentDRAM:          in.dram,
+		ComponentGPU:           in.gpu,
+		ComponentAccelerator:   in.accelerator,
+		ComponentNIC:           in.nic,
+		ComponentStorage:       in.storage,
+		ComponentPlatformOther: 0,
+		ComponentUnaccounted:   0,
+	}
+	if in.node <= 0 {
+		return b
+	}
+	measured := in.cpu + in.dram + in.gpu + in.accelerator
+	if measured >= in.node {
+		scale := in.node / measured
+		for _, c := range []string{ComponentCPU, ComponentDRAM, ComponentGPU, ComponentAccelerator} {
+			b[c] *= scale
+		}
+		b[ComponentNIC], b[ComponentStorage] = 0, 0
+		return b
+	}
+	rest := in.node - measured
+	if modelled := in.nic + in.storage; modelled > rest {
+		b[ComponentNIC] = in.nic * rest / modelled
+		b[ComponentStorage] = rest - b[ComponentNIC]
+		return b
+	}
+	rest -= in.nic + in.storage
+	// the modelled NIC and storage are part of the measured platform energy
+	platform := in.platform - in.nic - in.storage
+	switch {
+	case platform >= rest:
+		b[ComponentPlatformOther] = rest
+	case platform > 0:
+		b[ComponentPlatformOther] = platform
+		b[ComponentUnaccounted] = rest - platform
+	default:
+		b[ComponentUnaccounted] = rest
+	}
+	return b
+}
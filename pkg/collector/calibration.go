@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+)
+
+// While a node meter (smart plug, BMC, ACPI) reads, the other power, the node power the CPU, DRAM, GPU and
+// accelerators don't account for, is learned as a moving average of the measured residual. When the meter
+// is missing or stale the learned power completes the components, so the node energy keeps its other part
+// instead of dropping to the sum of the components.
+const (
+	otherCalibrationWeight     = 0.1
+	otherCalibrationMinSamples = 10
+)
+
+var (
+	// calibratedOtherPower is the learned other power in W
+	calibratedOtherPower    float64
+	otherCalibrationSamples int
+)
+
+// calibrateOther learns the other power from the residual energy (mJ) of a measured sample
+func calibrateOther(otherDelta, seconds float64) {
+	if seconds <= 0 || otherDelta < 0 {
+		return
+	}
+	/* power (W) = energy (mJ) / 1000 / time(second) */
+	power := otherDelta / 1000 / seconds
+	if otherCalibrationSamples == 0 {
+		calibratedOtherPower = power
+	} else {
+		calibratedOtherPower += otherCalibrationWeight * (power - calibratedOtherPower)
+	}
+	otherCalibrationSamples++
+}
+
+// calibratedOther returns the calibrated other energy in mJ over seconds, ok is false until enough
+// measured samples were seen
+func calibratedOther(seconds float64) (float64, bool) {
+	if otherCalibrationSamples < otherCalibrationMinSamples || seconds <= 0 {
+		return 0, false
+	}
+	return calibratedOtherPower * 1000 * seconds, true
+}
+
+// SetModelTraining trains the power model coefficients of the CPU architecture from the samples measured
+// by RAPL, saving it with the state flushed before the node powers off
+func SetModelTraining(enabled bool) error {
+	if !enabled {
+		return nil
+	}
+	if err := model.EnableTraining(cpuArch); err != nil {
+		return err
+	}
+	OnFlush(model.SaveTraining)
+	return nil
+}
@@ -17,32 +17,77 @@ limitations under the License.
 package collector
 
 import (
+	"context"
 	"fmt"
 	"strconv"
+	"strings"
+	"sync"
 
+	"github.com/sustainable-computing-io/kepler/pkg/ambient"
 	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
 
 	"github.com/prometheus/client_golang/prometheus"
 )
 
 type Collector struct {
 	modules *attacher.BpfModuleTables
+	// cancel stops the reader, which closes done once it exited
+	cancel context.CancelFunc
+	done   chan struct{}
+	// stop makes the concurrent Stop calls stop the collector once
+	stop *sync.Once
 }
 
 func New() (*Collector, error) {
 	return &Collector{}, nil
 }
 
+// Attach starts the collector until Stop is called
 func (c *Collector) Attach() error {
+	return c.Start(context.Background())
+}
+
+// Start attaches the eBPF programs and starts sampling until the context is done or Stop is called,
+// so the collector can be embedded (e.g. in the Flotta device agent) and restarted
+func (c *Collector) Start(ctx context.Context) error {
+	if c.done != nil {
+		return fmt.Errorf("collector already started")
+	}
 	m, err := attacher.AttachBPFAssets()
 	if err != nil {
 		return fmt.Errorf("failed to attach bpf assets: %v", err)
 	}
 	c.modules = m
-	c.reader()
+	// the attacher resets the coefficients to the bare-metal or VM ones
+	model.ApplyTraining()
+	resetEvents()
+	loadPeriodTotals()
+	loadExportedCounters()
+	loadSequence()
+	ctx, c.cancel = context.WithCancel(ctx)
+	c.done = make(chan struct{})
+	c.stop = &sync.Once{}
+	c.reader(ctx)
 	return nil
 }
 
+// Stop takes the last sample, flushes the state, stops sampling and detaches the eBPF programs, it
+// returns once the reader and the power meter exited
+func (c *Collector) Stop() {
+	if c.stop == nil {
+		return
+	}
+	c.stop.Do(func() {
+		c.Flush()
+		c.cancel()
+		<-c.done
+		c.done = nil
+		c.Destroy()
+	})
+}
+
 func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 	lock.Lock()
 	defer lock.Unlock()
@@ -76,6 +121,7 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 				"total_bytes_read",
 				"curr_bytes_writes",
 				"total_bytes_writes",
+				"cpuset",
 			},
 			nil,
 		)
@@ -91,10 +137,13 @@ func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
 		nil,
 	)
 	ch <- desc
+	ch <- containerFingerprintDesc
+	ch <- workloadClassDesc
 }
 
 //To calculate energy from the whole EdgeDevice
 func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	defer persistExportedCounters(false)
 	lock.Lock()
 	defer lock.Unlock()
 	de := prometheus.NewDesc(
@@ -165,6 +214,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 				"total_bytes_read",
 				"curr_bytes_writes",
 				"total_bytes_writes",
+				"cpuset",
 			},
 			nil,
 		)
@@ -188,6 +238,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			avgFreq, disks,
 			strconv.FormatUint(v.CurrBytesRead, 10), strconv.FormatUint(v.AggBytesRead, 10),
 			strconv.FormatUint(v.CurrBytesWrite, 10), strconv.FormatUint(v.AggBytesWrite, 10),
+			v.CPUSet,
 		)
 		ch <- desc
 
@@ -197,13 +248,13 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Container total energy consumption",
 			[]string{
 				"container_name",
-				//			"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
-		desc_total := prometheus.MustNewConstMetric(
+		desc_total := monotonicCounter(
 			de_total,
-			prometheus.CounterValue,
+			"container_energy_total",
 			float64(v.AggEnergyInCore+v.AggEnergyInDram+v.AggEnergyInOther),
 			v.ContainerName, v.Namespace,
 		)
@@ -227,13 +278,17 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		)
 		ch <- desc_current
 
+		if len(v.Fingerprint) > 0 {
+			ch <- prometheus.MustNewConstMetric(containerFingerprintDesc, prometheus.GaugeValue, 1, v.ContainerName, v.Namespace, v.Fingerprint)
+		}
+
 		// de_cpu_current and desc_cpu_current give indexable values for current CPU energy consumptions (in 3 seconds) for all pods
 		de_cpu_current := prometheus.NewDesc(
 			"container_cpu_energy_current",
 			"Container CPU current energy consumption",
 			[]string{
 				"container_name",
-				//			"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
@@ -255,9 +310,9 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			},
 			nil,
 		)
-		desc_cpu_total := prometheus.MustNewConstMetric(
+		desc_cpu_total := monotonicCounter(
 			de_cpu_total,
-			prometheus.CounterValue,
+			"container_cpu_energy_total",
 			float64(v.AggEnergyInCore),
 			v.ContainerName, v.Namespace,
 		)
@@ -269,7 +324,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Container DRAM current energy consumption",
 			[]string{
 				"container_name",
-				//				"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
@@ -287,13 +342,13 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Container DRAM total energy consumption",
 			[]string{
 				"container_name",
-				//			"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
-		desc_dram_total := prometheus.MustNewConstMetric(
+		desc_dram_total := monotonicCounter(
 			de_dram_total,
-			prometheus.CounterValue,
+			"container_dram_energy_total",
 			float64(v.AggEnergyInDram),
 			v.ContainerName, v.Namespace,
 		)
@@ -305,7 +360,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Container GPU current energy consumption",
 			[]string{
 				"container_name",
-				//			"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
@@ -323,13 +378,13 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Pod GPU total energy consumption",
 			[]string{
 				"container_name",
-				//				"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
-		desc_gpu_total := prometheus.MustNewConstMetric(
+		desc_gpu_total := monotonicCounter(
 			de_gpu_total,
-			prometheus.CounterValue,
+			"pod_gpu_energy_total",
 			float64(v.AggEnergyInGPU),
 			v.ContainerName, v.Namespace,
 		)
@@ -341,7 +396,7 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Pod OTHER current energy consumption besides CPU and memory",
 			[]string{
 				"container_name",
-				//				"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
@@ -359,18 +414,147 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 			"Pod OTHER total energy consumption besides CPU and memory",
 			[]string{
 				"container_name",
-				//				"pod_namespace",
+				"container_namespace",
 			},
 			nil,
 		)
-		desc_other_total := prometheus.MustNewConstMetric(
+		desc_other_total := monotonicCounter(
 			de_other_total,
-			prometheus.CounterValue,
+			"pod_other_energy_joule_total",
 			float64(v.AggEnergyInOther),
 			v.ContainerName, v.Namespace,
 		)
 		ch <- desc_other_total
 
+		// de_accelerator_current and de_accelerator_total give the energy of the device classes registered through the accelerator hooks
+		de_accelerator_current := prometheus.NewDesc(
+			"container_accelerator_energy_current",
+			"Container accelerator current energy consumption",
+			[]string{
+				"container_name",
+				"container_namespace",
+				"device_class",
+			},
+			nil,
+		)
+		de_accelerator_total := prometheus.NewDesc(
+			"container_accelerator_energy_total",
+			"Container accelerator total energy consumption",
+			[]string{
+				"container_name",
+				"container_namespace",
+				"device_class",
+			},
+			nil,
+		)
+		for class, energy := range v.AggEnergyInAccelerator {
+			ch <- prometheus.MustNewConstMetric(
+				de_accelerator_current,
+				prometheus.GaugeValue,
+				float64(v.CurrEnergyInAccelerator[class]),
+				v.ContainerName, v.Namespace, class,
+			)
+			ch <- monotonicCounter(
+				de_accelerator_total,
+				"container_accelerator_energy_total",
+				float64(energy),
+				v.ContainerName, v.Namespace, class,
+			)
+		}
+
+		// de_network_energy, de_network_bytes and de_network_packets give the socket traffic of the containers and its energy from the NIC model
+		if networkAccounted() {
+			de_network_energy := prometheus.NewDesc(
+				"container_network_energy_total",
+				"Container network total energy consumption estimated by the NIC model, part of the other energy",
+				[]string{
+					"container_name",
+					"container_namespace",
+				},
+				nil,
+			)
+			ch <- monotonicCounter(
+				de_network_energy,
+				"container_network_energy_total",
+				float64(v.AggEnergyInNetwork),
+				v.ContainerName, v.Namespace,
+			)
+			de_network_bytes := prometheus.NewDesc(
+				"container_network_bytes_total",
+				"Container socket traffic in bytes",
+				[]string{
+					"container_name",
+					"container_namespace",
+					"direction",
+				},
+				nil,
+			)
+			ch <- monotonicCounter(de_network_bytes, "container_network_bytes_total", float64(v.AggBytesTx), v.ContainerName, v.Namespace, "tx")
+			ch <- monotonicCounter(de_network_bytes, "container_network_bytes_total", float64(v.AggBytesRx), v.ContainerName, v.Namespace, "rx")
+			de_network_packets := prometheus.NewDesc(
+				"container_network_packets_total",
+				"Container socket traffic in packets, the TCP segments are estimated from the MSS",
+				[]string{
+					"container_name",
+					"container_namespace",
+					"direction",
+				},
+				nil,
+			)
+			ch <- monotonicCounter(de_network_packets, "container_network_packets_total", float64(v.AggPacketsTx), v.ContainerName, v.Namespace, "tx")
+			ch <- monotonicCounter(de_network_packets, "container_network_packets_total", float64(v.AggPacketsRx), v.ContainerName, v.Namespace, "rx")
+		}
+
+		// de_realtime and desc_realtime flag the containers running real-time (SCHED_FIFO/RR/DEADLINE) threads
+		if len(v.SchedPolicy) > 0 {
+			de_realtime := prometheus.NewDesc(
+				"container_realtime_sched",
+				"Container running real-time scheduled threads",
+				[]string{
+					"container_name",
+					"container_namespace",
+					"policy",
+					"actuation_excluded",
+				},
+				nil,
+			)
+			desc_realtime := prometheus.MustNewConstMetric(
+				de_realtime,
+				prometheus.GaugeValue,
+				1,
+				v.ContainerName, v.Namespace, v.SchedPolicy,
+				strconv.FormatBool(IsActuationExcluded(v)),
+			)
+			ch <- desc_realtime
+		}
+	}
+
+	// de_period_energy and desc_period_energy give the energy of each container since the start of the day and of the week
+	de_period_energy := prometheus.NewDesc(
+		"container_energy_period_joule_total",
+		"Container energy consumed in joules since the start of the current day or week.",
+		[]string{
+			"container_name",
+			"container_namespace",
+			"period",
+		},
+		nil,
+	)
+	for period, t := range periodTotals {
+		for k, energy := range t.Energy {
+			// the key is "namespace/container", the container name of a WASM module contains a "/" too
+			names := strings.SplitN(k, "/", 2)
+			if len(names) != 2 {
+				continue
+			}
+			desc_period_energy := prometheus.MustNewConstMetric(
+				de_period_energy,
+				prometheus.CounterValue,
+				energy/1000.0, /*miliJoule to Joule*/
+				names[1], names[0], period,
+			)
+			ch <- desc_period_energy
+		}
 	}
 
 	// de_EdgeDevice_energy and desc_EdgeDevice_energy give indexable values for total energy consumptions of a EdgeDevice
@@ -385,9 +569,9 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		nil,
 	)
 	for sensorID, energy := range EdgeDeviceEnergy {
-		desc_total := prometheus.MustNewConstMetric(
+		desc_total := monotonicCounter(
 			de_EdgeDevice_energy,
-			prometheus.CounterValue,
+			"EdgeDevice_hwmon_energy_joule_total",
 			energy/1000.0, /*miliJoule to Joule*/
 			EdgeDeviceName,
 			sensorID,
@@ -396,6 +580,77 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		ch <- desc_total
 	}
 
+	// de_accelerator_energy and desc_accelerator_energy give the current energy of each registered accelerator device class
+	de_accelerator_energy := prometheus.NewDesc(
+		"EdgeDevice_accelerator_energy_current",
+		"EdgeDevice accelerator current energy consumption",
+		[]string{
+			"EdgeDevice_name",
+			"device_class",
+		},
+		nil,
+	)
+	for class, energy := range currEdgeDeviceEnergy.EnergyInAccelerator {
+		desc_accelerator_energy := prometheus.MustNewConstMetric(
+			de_accelerator_energy,
+			prometheus.GaugeValue,
+			energy,
+			EdgeDeviceName,
+			class,
+		)
+		ch <- desc_accelerator_energy
+	}
+
+	// de_gpu_utilization and de_gpu_pcie give the activity of each GPU splitting its power between compute and data movement
+	de_gpu_utilization := prometheus.NewDesc(
+		"EdgeDevice_gpu_utilization_percent",
+		"EdgeDevice GPU utilization of the SMs (compute) and of the memory bandwidth (memory)",
+		[]string{
+			"EdgeDevice_name",
+			"gpu",
+			"kind",
+		},
+		nil,
+	)
+	de_gpu_pcie := prometheus.NewDesc(
+		"EdgeDevice_gpu_pcie_bytes_per_second",
+		"EdgeDevice GPU PCIe throughput",
+		[]string{
+			"EdgeDevice_name",
+			"gpu",
+			"direction",
+		},
+		nil,
+	)
+	for i, counters := range gpuCounters {
+		id := strconv.Itoa(i)
+		ch <- prometheus.MustNewConstMetric(de_gpu_utilization, prometheus.GaugeValue, float64(counters.SMUtil), EdgeDeviceName, id, "compute")
+		ch <- prometheus.MustNewConstMetric(de_gpu_utilization, prometheus.GaugeValue, float64(counters.MemUtil), EdgeDeviceName, id, "memory")
+		ch <- prometheus.MustNewConstMetric(de_gpu_pcie, prometheus.GaugeValue, float64(counters.PCIeRx), EdgeDeviceName, id, "rx")
+		ch <- prometheus.MustNewConstMetric(de_gpu_pcie, prometheus.GaugeValue, float64(counters.PCIeTx), EdgeDeviceName, id, "tx")
+	}
+
+	// de_state_energy and desc_state_energy give the energy consumed by a EdgeDevice in each node state (Ready, Cordoned, Maintenance...)
+	de_state_energy := prometheus.NewDesc(
+		"EdgeDevice_state_energy_joule_total",
+		"Energy consumed in joules by the EdgeDevice while in a given node state.",
+		[]string{
+			"EdgeDevice_name",
+			"state",
+		},
+		nil,
+	)
+	for state, energy := range nodeStateEnergy {
+		desc_state_energy := monotonicCounter(
+			de_state_energy,
+			"EdgeDevice_state_energy_joule_total",
+			energy/1000.0, /*miliJoule to Joule*/
+			EdgeDeviceName,
+			state,
+		)
+		ch <- desc_state_energy
+	}
+
 	// de_core_freq and desc_core_freq give indexable values for each cpu core freq of a EdgeDevice
 	de_core_freq := prometheus.NewDesc(
 		"node_cpu_scaling_frequency_hertz",
@@ -415,10 +670,291 @@ func (c *Collector) Collect(ch chan<- prometheus.Metric) {
 		)
 		ch <- desc_total
 	}
+
+	// de_cpu_isolation and desc_cpu_isolation give the role (housekeeping, isolated, nohz_full) of each cpu of a EdgeDevice
+	de_cpu_isolation := prometheus.NewDesc(
+		"node_cpu_isolation",
+		"CPU isolation role and default IRQ affinity.",
+		[]string{
+			"cpu",
+			"role",
+			"irq_affinity",
+		},
+		nil,
+	)
+	for cpuID, role := range cpuRoles {
+		desc_cpu_isolation := prometheus.MustNewConstMetric(
+			de_cpu_isolation,
+			prometheus.GaugeValue,
+			1,
+			fmt.Sprintf("%d", cpuID), role, strconv.FormatBool(irqAffinityCPUs[cpuID]),
+		)
+		ch <- desc_cpu_isolation
+	}
+
+	// de_ptp_clock and desc_ptp_clock give the PTP hardware clocks of a EdgeDevice used by the TSN stack
+	de_ptp_clock := prometheus.NewDesc(
+		"node_ptp_clock",
+		"PTP hardware clock and its network interface.",
+		[]string{
+			"clock",
+			"clock_name",
+			"interface",
+		},
+		nil,
+	)
+	for clockID, clock := range ptpClocks {
+		desc_ptp_clock := prometheus.MustNewConstMetric(
+			de_ptp_clock,
+			prometheus.GaugeValue,
+			1,
+			clockID, clock.name, clock.iface,
+		)
+		ch <- desc_ptp_clock
+	}
+
+	// de_power_rail and desc_power_rail give the power rails of a SoC EdgeDevice and the subsystem they supply
+	if socTopology != nil {
+		de_power_rail := prometheus.NewDesc(
+			"node_power_rail_info",
+			"SoC power rail (regulator) and the subsystem it supplies.",
+			[]string{
+				"rail",
+				"subsystem",
+			},
+			nil,
+		)
+		for name := range socTopology.Rails {
+			desc_power_rail := prometheus.MustNewConstMetric(
+				de_power_rail,
+				prometheus.GaugeValue,
+				1,
+				name, socTopology.Subsystem(name),
+			)
+			ch <- desc_power_rail
+		}
+		de_thermal_zone := prometheus.NewDesc(
+			"node_thermal_zone_info",
+			"SoC thermal zone and the subsystem it measures.",
+			[]string{
+				"zone",
+				"subsystem",
+			},
+			nil,
+		)
+		for _, zone := range socTopology.ThermalZones {
+			desc_thermal_zone := prometheus.MustNewConstMetric(
+				de_thermal_zone,
+				prometheus.GaugeValue,
+				1,
+				zone.Name, zone.Subsystem,
+			)
+			ch <- desc_thermal_zone
+		}
+	}
+
+	// de_rail_energy and desc_rail_energy give the energy of each regulator rail of a SoC EdgeDevice
+	de_rail_energy := prometheus.NewDesc(
+		"node_power_rail_energy_joule_total",
+		"Energy consumed in joules by a SoC power rail, read from the regulator framework.",
+		[]string{
+			"rail",
+			"subsystem",
+		},
+		nil,
+	)
+	for rail, energy := range railEnergy {
+		desc_rail_energy := monotonicCounter(
+			de_rail_energy,
+			"node_power_rail_energy_joule_total",
+			energy/1000.0, /*miliJoule to Joule*/
+			rail, railSubsystem(rail),
+		)
+		ch <- desc_rail_energy
+	}
+
+	// de_sensor_energy and desc_sensor_energy give the energy measured by each power sensor (INA, PMIC) of a EdgeDevice
+	de_sensor_energy := prometheus.NewDesc(
+		"node_power_sensor_energy_joule_total",
+		"Energy consumed in joules by the rail of a power sensor (INA hwmon/IIO, PMIC).",
+		[]string{
+			"sensor",
+			"subsystem",
+		},
+		nil,
+	)
+	for sensor, energy := range sensorEnergy {
+		desc_sensor_energy := monotonicCounter(
+			de_sensor_energy,
+			"node_power_sensor_energy_joule_total",
+			energy/1000.0, /*miliJoule to Joule*/
+			sensor, soc.SensorSubsystem(sensor),
+		)
+		ch <- desc_sensor_energy
+	}
+
+	// de_battery_energy and desc_battery_energy give the energy discharged by the batteries of a EdgeDevice
+	if batteryMeter != nil {
+		de_battery_energy := prometheus.NewDesc(
+			"node_battery_discharge_energy_joule_total",
+			"Energy discharged in joules by the batteries, while the EdgeDevice is not on AC power.",
+			[]string{},
+			nil,
+		)
+		desc_battery_energy := monotonicCounter(
+			de_battery_energy,
+			"node_battery_discharge_energy_joule_total",
+			batteryEnergy/1000.0, /*miliJoule to Joule*/
+		)
+		ch <- desc_battery_energy
+	}
+
+	// de_subsystem_energy and desc_subsystem_energy give the current energy of a SoC EdgeDevice per subsystem (cpu clusters, gpu, npu...)
+	if socTopology != nil {
+		de_subsystem_energy := prometheus.NewDesc(
+			"EdgeDevice_subsystem_energy_current",
+			"EdgeDevice current energy consumption per SoC subsystem",
+			[]string{
+				"EdgeDevice_name",
+				"subsystem",
+			},
+			nil,
+		)
+		for subsystem, energy := range socTopology.Breakdown(currRailEnergy) {
+			desc_subsystem_energy := prometheus.MustNewConstMetric(
+				de_subsystem_energy,
+				prometheus.GaugeValue,
+				energy,
+				EdgeDeviceName,
+				subsystem,
+			)
+			ch <- desc_subsystem_energy
+		}
+	}
+
+	// de_ambient and desc_ambient give the environmental readings of the sensors around a EdgeDevice
+	ambientMetrics := map[string]string{
+		ambient.Temperature: "node_ambient_temperature_celsius",
+		ambient.Humidity:    "node_ambient_humidity_percent",
+		ambient.Pressure:    "node_ambient_pressure_kpa",
+	}
+	for sensor, values := range ambient.GetReadings() {
+		for quantity, value := range values {
+			de_ambient := prometheus.NewDesc(
+				ambientMetrics[quantity],
+				"Ambient "+quantity+" around the EdgeDevice.",
+				[]string{
+					"sensor",
+				},
+				nil,
+			)
+			desc_ambient := prometheus.MustNewConstMetric(
+				de_ambient,
+				prometheus.GaugeValue,
+				value,
+				sensor,
+			)
+			ch <- desc_ambient
+		}
+	}
+
+	collectProcessEnergy(ch)
+	collectWorkloadClasses(ch)
+
+	// de_platform_energy and desc_platform_energy give the current energy of the psys RAPL domain and
+	// the residual validating the decomposition
+	if psysSupported {
+		de_platform_energy := prometheus.NewDesc(
+			"EdgeDevice_platform_energy_current",
+			"EdgeDevice current energy consumption measured by the psys RAPL domain",
+			[]string{
+				"EdgeDevice_name",
+			},
+			nil,
+		)
+		desc_platform_energy := prometheus.MustNewConstMetric(
+			de_platform_energy,
+			prometheus.GaugeValue,
+			currEdgeDeviceEnergy.EnergyInPlatform,
+			EdgeDeviceName,
+		)
+		ch <- desc_platform_energy
+		de_platform_residual := prometheus.NewDesc(
+			"EdgeDevice_platform_residual_energy_current",
+			"EdgeDevice current psys energy not measured by the package and DRAM RAPL domains",
+			[]string{
+				"EdgeDevice_name",
+			},
+			nil,
+		)
+		desc_platform_residual := prometheus.MustNewConstMetric(
+			de_platform_residual,
+			prometheus.GaugeValue,
+			currEdgeDeviceEnergy.PlatformResidual,
+			EdgeDeviceName,
+		)
+		ch <- desc_platform_residual
+		if currEdgeDeviceEnergy.EnergyInPlatform > 0 {
+			de_platform_residual_ratio := prometheus.NewDesc(
+				"EdgeDevice_platform_residual_ratio",
+				"Ratio of the psys energy not measured by the package and DRAM RAPL domains, negative if the domains are inconsistent",
+				[]string{
+					"EdgeDevice_name",
+				},
+				nil,
+			)
+			desc_platform_residual_ratio := prometheus.MustNewConstMetric(
+				de_platform_residual_ratio,
+				prometheus.GaugeValue,
+				currEdgeDeviceEnergy.PlatformResidual/currEdgeDeviceEnergy.EnergyInPlatform,
+				EdgeDeviceName,
+			)
+			ch <- desc_platform_residual_ratio
+		}
+	}
+
+	// de_network_energy and desc_network_energy give the current network energy of the NIC model
+	if networkAccounted() {
+		de_network_energy := prometheus.NewDesc(
+			"EdgeDevice_network_energy_current",
+			"EdgeDevice network current energy consumption estimated by the NIC model, part of the other energy",
+			[]string{
+				"EdgeDevice_name",
+			},
+			nil,
+		)
+		desc_network_energy := prometheus.MustNewConstMetric(
+			de_network_energy,
+			prometheus.GaugeValue,
+			currEdgeDeviceEnergy.EnergyInNetwork,
+			EdgeDeviceName,
+		)
+		ch <- desc_network_energy
+	}
+
+	// de_housekeeping_energy and desc_housekeeping_energy give the current core energy consumed on the housekeeping cpus
+	if cpuIsolation {
+		de_housekeeping_energy := prometheus.NewDesc(
+			"EdgeDevice_housekeeping_energy_current",
+			"EdgeDevice core current energy consumption on the housekeeping cpus",
+			[]string{
+				"EdgeDevice_name",
+			},
+			nil,
+		)
+		desc_housekeeping_energy := prometheus.MustNewConstMetric(
+			de_housekeeping_energy,
+			prometheus.GaugeValue,
+			currEdgeDeviceEnergy.EnergyInHousekeeping,
+			EdgeDeviceName,
+		)
+		ch <- desc_housekeeping_energy
+	}
 }
 
 func (c *Collector) Destroy() {
 	if c.modules != nil {
 		attacher.DetachBPFModules(c.modules)
+		c.modules = nil
 	}
 }
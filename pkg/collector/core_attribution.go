@@ -0,0 +1,78 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"math"
+)
+
+// The CPU time part of the core energy is attributed by the share of the CPU time of the containers. In
+// the per-core mode the CPU time on each core is weighted by the dynamic power of the core at its
+// frequency, which scales with f*V^2, with the voltage following the frequency across the P-states, so
+// that a workload pinned to boosted cores is attributed more than one pinned to throttled cores.
+const (
+	CoreAttributionRatio   = "ratio"
+	CoreAttributionPerCore = "per-core"
+)
+
+var coreAttribution = CoreAttributionRatio
+
+// SetCoreAttribution selects how the core energy is attributed: ratio or per-core
+func SetCoreAttribution(mode string) error {
+	switch mode {
+	case CoreAttributionRatio, CoreAttributionPerCore:
+		coreAttribution = mode
+		return nil
+	}
+	return fmt.Errorf("invalid core attribution %q, ratio or per-core", mode)
+}
+
+// maxCPUFrequency returns the highest frequency of the cores, the reference of the weights
+func maxCPUFrequency(freq map[int32]uint64) uint64 {
+	max := uint64(0)
+	for _, f := range freq {
+		if f > max {
+			max = f
+		}
+	}
+	return max
+}
+
+// coreWeight returns the CPU time on a core weighted by its relative dynamic power, the plain CPU time
+// in the ratio mode or when the frequency of the core is not known
+func coreWeight(cpu int, t uint16, freq map[int32]uint64, maxFreq uint64) float64 {
+	if coreAttribution != CoreAttributionPerCore || maxFreq == 0 {
+		return float64(t)
+	}
+	f, ok := freq[int32(cpu)]
+	if !ok || f == 0 {
+		return float64(t)
+	}
+	return float64(t) * math.Pow(float64(f)/float64(maxFreq), 3)
+}
+
+// weightedCPUTime returns the CPU time vector of a process weighted per core
+func weightedCPUTime(cpuTime []uint16, freq map[int32]uint64, maxFreq uint64) float64 {
+	weighted := float64(0)
+	for cpu, t := range cpuTime {
+		if t > 0 {
+			weighted += coreWeight(cpu, t, freq, maxFreq)
+		}
+	}
+	return weighted
+}
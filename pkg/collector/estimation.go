@@ -0,0 +1,94 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"math"
+
+	"k8s.io/klog/v2"
+
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+)
+
+// estimatorInput are the features of the containers of a sample, copied under the collector lock so that
+// the estimator is called without it
+type estimatorInput struct {
+	estimator model.Estimator
+	names     []string
+	features  []model.Features
+}
+
+// estimatorFeatures returns the features of the containers for the estimator set, nil without an estimator.
+// The collector lock must be held.
+func estimatorFeatures(podMem map[string]float64) *estimatorInput {
+	e := model.GetEstimator()
+	if e == nil || len(containerEnergy) == 0 {
+		return nil
+	}
+	in := &estimatorInput{
+		estimator: e,
+		names:     make([]string, 0, len(containerEnergy)),
+		features:  make([]model.Features, 0, len(containerEnergy)),
+	}
+	for name, v := range containerEnergy {
+		in.names = append(in.names, name)
+		in.features = append(in.features, model.Features{
+			CPUTime:        v.CurrCPUTime,
+			CPUCycles:      v.CurrCPUCycles,
+			CPUInstr:       v.CurrCPUInstr,
+			CacheMisses:    v.CurrCacheMisses,
+			ResidentMemory: podMem[v.Namespace+"/"+name],
+		})
+	}
+	return in
+}
+
+// estimateContainerEnergy splits the core and DRAM energy of the sample among the containers with the
+// estimator, by container name, scaled to the measured energy. It returns nil maps when the estimator fails,
+// the ratio model splits the energy then. The collector lock must not be held, the estimator may be slow.
+func estimateContainerEnergy(in *estimatorInput, coreDelta, dramDelta float64, node model.Features) (map[string]float64, map[string]float64) {
+	var core, dram map[string]float64
+	if energy, err := in.estimator.EstimateCoreEnergy(coreDelta, node, in.features); err == nil {
+		core = byContainer(in.names, energy, coreDelta)
+	} else {
+		klog.ErrorS(err, "failed to estimate the core energy, using the ratio model", "estimator", in.estimator.Name())
+	}
+	if energy, err := in.estimator.EstimateDramEnergy(dramDelta, node, in.features); err == nil {
+		dram = byContainer(in.names, energy, dramDelta)
+	} else {
+		klog.ErrorS(err, "failed to estimate the dram energy, using the ratio model", "estimator", in.estimator.Name())
+	}
+	return core, dram
+}
+
+// byContainer returns the estimates by container, scaled so that they sum to the measured energy, nil if
+// they are all 0
+func byContainer(names []string, energy []float64, delta float64) map[string]float64 {
+	m := make(map[string]float64, len(names))
+	sum := float64(0)
+	for i, name := range names {
+		m[name] = math.Max(energy[i], 0)
+		sum += m[name]
+	}
+	if sum == 0 {
+		return nil
+	}
+	for name := range m {
+		m[name] *= math.Max(delta, 0) / sum
+	}
+	return m
+}
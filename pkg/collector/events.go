@@ -0,0 +1,180 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+)
+
+// The CPU module sends a slice of a process each time it is switched out and the net module each
+// transfer of a process. A goroutine folds them per process until the sample takes them, so the slices
+// sent while a sample reads are in the next one instead of being lost when the maps were reset. As with
+// the eBPF maps, the processes beyond the map size are dropped and counted.
+var (
+	eventLock        sync.Mutex
+	pendingProcesses = map[processKey]*CgroupTime{}
+	pendingTraffic   = map[processKey]ProcessTraffic{}
+	// droppedEntries are the processes dropped while the aggregation was full, by aggregation, and
+	// lostEvents the events dropped by the kernel while the perf buffer was full, by module
+	droppedEntries = map[string]uint64{}
+	lostEvents     = map[string]uint64{}
+)
+
+// eventSample is the aggregation of the events since the last sample, with the cumulative drops
+type eventSample struct {
+	processes []CgroupTime
+	traffic   map[processKey]ProcessTraffic
+	dropped   map[string]uint64
+	lost      map[string]uint64
+}
+
+// decodeEvent decodes an event into e, the perf buffer pads the samples so the data may be longer
+func decodeEvent(data []byte, e interface{}) error {
+	if size := binary.Size(e); len(data) < size {
+		return fmt.Errorf("unexpected event size %d, expected %d", len(data), size)
+	}
+	return binary.Read(bytes.NewReader(data), binary.LittleEndian, e)
+}
+
+// consumeEvents folds the events of the modules until the context is done
+func consumeEvents(ctx context.Context, modules *attacher.BpfModuleTables) {
+	// the streams of the modules not attached are nil channels, never ready
+	var processEvents, trafficEvents chan []byte
+	var processLost, trafficLost chan uint64
+	if s := modules.Processes; s != nil {
+		processEvents, processLost = s.Events, s.Lost
+	}
+	if s := modules.Traffic; s != nil {
+		trafficEvents, trafficLost = s.Events, s.Lost
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case data := <-processEvents:
+			var e ProcessEvent
+			if err := decodeEvent(data, &e); err != nil {
+				log.Printf("failed to decode process event: %v\n", err)
+				continue
+			}
+			eventLock.Lock()
+			foldProcessEvent(&e)
+			eventLock.Unlock()
+		case data := <-trafficEvents:
+			var t ProcessTraffic
+			if err := decodeEvent(data, &t); err != nil {
+				log.Printf("failed to decode traffic event: %v\n", err)
+				continue
+			}
+			eventLock.Lock()
+			foldTrafficEvent(&t)
+			eventLock.Unlock()
+		case n := <-processLost:
+			eventLock.Lock()
+			lostEvents[attacher.ModuleCPU] += n
+			eventLock.Unlock()
+		case n := <-trafficLost:
+			eventLock.Lock()
+			lostEvents[attacher.ModuleNet] += n
+			eventLock.Unlock()
+		}
+	}
+}
+
+// foldProcessEvent adds a slice to its process, the event lock must be held
+func foldProcessEvent(e *ProcessEvent) {
+	key := processKey{e.CGroupPID, e.PID, e.StartTime}
+	ct, ok := pendingProcesses[key]
+	if !ok {
+		if len(pendingProcesses) >= attacher.MapSize {
+			droppedEntries["processes"]++
+			return
+		}
+		ct = &CgroupTime{CGroupPID: e.CGroupPID, PID: e.PID, TGID: e.TGID, StartTime: e.StartTime, Command: e.Command}
+		pendingProcesses[key] = ct
+	}
+	ct.ProcessRunTime += e.ProcessRunTime
+	ct.CPUCycles += e.CPUCycles
+	ct.CPUInstr += e.CPUInstr
+	ct.CacheMisses += e.CacheMisses
+	// the time is in milliseconds, the vector saturates after ~1min on a CPU
+	if int(e.CPU) < len(ct.CPUTime) {
+		t := uint64(ct.CPUTime[e.CPU]) + e.ProcessRunTime
+		if t > math.MaxUint16 {
+			t = math.MaxUint16
+		}
+		ct.CPUTime[e.CPU] = uint16(t)
+	}
+}
+
+// foldTrafficEvent adds a transfer to its process, the event lock must be held
+func foldTrafficEvent(e *ProcessTraffic) {
+	key := processKey{e.CGroupPID, e.PID, e.StartTime}
+	t, ok := pendingTraffic[key]
+	if !ok && len(pendingTraffic) >= attacher.MapSize {
+		droppedEntries["traffic"]++
+		return
+	}
+	if !ok {
+		t = ProcessTraffic{CGroupPID: e.CGroupPID, PID: e.PID, TGID: e.TGID, StartTime: e.StartTime, Command: e.Command}
+	}
+	t.TxBytes += e.TxBytes
+	t.RxBytes += e.RxBytes
+	t.TxPackets += e.TxPackets
+	t.RxPackets += e.RxPackets
+	pendingTraffic[key] = t
+}
+
+// takeEvents returns the aggregation since the last sample and starts a new one
+func takeEvents() eventSample {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	sample := eventSample{
+		processes: make([]CgroupTime, 0, len(pendingProcesses)),
+		traffic:   pendingTraffic,
+		dropped:   make(map[string]uint64, len(droppedEntries)),
+		lost:      make(map[string]uint64, len(lostEvents)),
+	}
+	for _, ct := range pendingProcesses {
+		sample.processes = append(sample.processes, *ct)
+	}
+	for name, n := range droppedEntries {
+		sample.dropped[name] = n
+	}
+	for module, n := range lostEvents {
+		sample.lost[module] = n
+	}
+	pendingProcesses = map[processKey]*CgroupTime{}
+	pendingTraffic = map[processKey]ProcessTraffic{}
+	return sample
+}
+
+// resetEvents discards the aggregation, e.g. of a previous attach
+func resetEvents() {
+	eventLock.Lock()
+	defer eventLock.Unlock()
+	pendingProcesses = map[processKey]*CgroupTime{}
+	pendingTraffic = map[processKey]ProcessTraffic{}
+}
@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+// The containers of the deleted pods would stay in containerEnergy, which would grow with every pod the
+// node ever ran. A container without processes for the retention window whose cgroup was removed is
+// terminated: the snapshot of the sample reports it a last time, marked terminated, then it is evicted.
+const DefaultContainerRetention = 10 * time.Minute
+
+var containerRetention = DefaultContainerRetention
+
+// SetContainerRetention sets how long a removed container is kept, 0 to never evict the containers
+func SetContainerRetention(retention time.Duration) error {
+	if retention < 0 {
+		return fmt.Errorf("invalid container retention %v", retention)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	containerRetention = retention
+	return nil
+}
+
+// markTerminatedContainers marks the containers evicted after the sample, the collector lock must be held
+func markTerminatedContainers(now time.Time) {
+	if containerRetention <= 0 {
+		return
+	}
+	for _, v := range containerEnergy {
+		if now.Sub(v.lastSeen) > containerRetention && !pod_lister.CgroupExists(v.CGroupPID) {
+			v.Terminated = true
+		}
+	}
+}
+
+// evictTerminatedContainers drops the terminated containers, the collector lock must be held
+func evictTerminatedContainers() {
+	for name, v := range containerEnergy {
+		if !v.Terminated {
+			continue
+		}
+		log.Printf("evicting the terminated container %s/%s\n", v.Namespace, name)
+		pod_lister.ForgetCgroup(v.CGroupPID)
+		delete(containerEnergy, name)
+	}
+}
+
+// ContainerCgroups returns the cgroups of the processes of a container, the removed cgroups of its
+// restarted processes are dropped
+func ContainerCgroups(namespace, name string) []uint64 {
+	lock.Lock()
+	defer lock.Unlock()
+	v, ok := containerEnergy[name]
+	if !ok || v.Namespace != namespace {
+		return nil
+	}
+	ids := []uint64{}
+	for id := range v.cgroupIDs {
+		if id != v.CGroupPID && !pod_lister.CgroupExists(id) {
+			delete(v.cgroupIDs, id)
+			continue
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
@@ -0,0 +1,366 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+)
+
+// Exporter exposes the container and node energy as typed counters and gauges, with the values as
+// samples rather than labels, so they can be aggregated and rated by the prometheus of the edge
+// cluster. The energies are in J and the CPU times in seconds.
+type Exporter struct{}
+
+// aggEdgeDeviceEnergy is the node energy in mJ per component since the exporter started
+var aggEdgeDeviceEnergy = map[string]float64{}
+
+var (
+	podLabels = []string{"pod", "namespace", "command", "node"}
+
+	podEnergyDesc = prometheus.NewDesc(
+		"pod_energy_joule_total",
+		"Energy consumed by the pod per component",
+		append(podLabels, "component"),
+		nil,
+	)
+	podPackageCoreEnergyDesc = prometheus.NewDesc(
+		"pod_package_core_energy_joule_total",
+		"Core energy consumed by the pod per CPU package, on multi package nodes",
+		append(podLabels, "package"),
+		nil,
+	)
+	podCPUTimeDesc = prometheus.NewDesc(
+		"pod_cpu_time_seconds_total",
+		"CPU time of the pod",
+		podLabels,
+		nil,
+	)
+	podCPUCyclesDesc = prometheus.NewDesc(
+		"pod_cpu_cycles_total",
+		"CPU cycles of the pod",
+		podLabels,
+		nil,
+	)
+	podCPUInstrDesc = prometheus.NewDesc(
+		"pod_cpu_instructions_total",
+		"CPU instructions of the pod",
+		podLabels,
+		nil,
+	)
+	podCacheMissesDesc = prometheus.NewDesc(
+		"pod_cache_misses_total",
+		"Cache misses of the pod",
+		podLabels,
+		nil,
+	)
+	podIOBytesDesc = prometheus.NewDesc(
+		"pod_io_bytes_total",
+		"Block I/O of the pod",
+		append(podLabels, "direction"),
+		nil,
+	)
+
+	nodeEnergyDesc = prometheus.NewDesc(
+		"node_energy_joule_total",
+		"Energy consumed by the node per component",
+		[]string{"node", "component"},
+		nil,
+	)
+	nodePowerBreakdownDesc = prometheus.NewDesc(
+		"node_power_breakdown_watts",
+		"Power of the node over the last sample per component (cpu, dram, gpu, accelerator, nic, storage, platform-other, unaccounted), the components sum to the measured node power",
+		[]string{"node", "component"},
+		nil,
+	)
+	nodeOtherCalibratedDesc = prometheus.NewDesc(
+		"node_other_power_calibrated_watts",
+		"Power of the node outside the CPU, DRAM, GPU and accelerators learned from the node meter, completing the components when the meter is missing or stale",
+		[]string{"node"},
+		nil,
+	)
+	nodeIdlePowerDesc = prometheus.NewDesc(
+		"node_idle_power_watts",
+		"Static power of the node outside the CPU, DRAM, GPU and accelerators, configured or measured at startup",
+		[]string{"node"},
+		nil,
+	)
+	nodeOtherEnergyDesc = prometheus.NewDesc(
+		"node_other_energy_joule_total",
+		"Other energy of the node per part, static at the idle power or dynamic above it",
+		[]string{"node", "part"},
+		nil,
+	)
+	nodeUnattributedDesc = prometheus.NewDesc(
+		"node_unattributed_energy_joule_total",
+		"Energy of the node left out of the pods, the static energy with the unattributed idle attribution policy and the energy of the dropped system processes",
+		[]string{"node"},
+		nil,
+	)
+	nodeSampleQualityDesc = prometheus.NewDesc(
+		"node_sample_quality_score",
+		"Quality of the last sample from 0 to 1, the average of the scores of its sources (measured 1, estimated 0.5, stale 0.25, missing 0)",
+		[]string{"node"},
+		nil,
+	)
+	nodeSampleSourceDesc = prometheus.NewDesc(
+		"node_sample_source",
+		"Status of the sources of the last sample (measured, estimated, stale, missing), 1 for the current status",
+		[]string{"node", "source", "status"},
+		nil,
+	)
+	nodeSampleEnergyDesc = prometheus.NewDesc(
+		"node_sample_energy_joule",
+		"Energy consumed by the node per component over the last sample",
+		[]string{"node", "component"},
+		nil,
+	)
+	nodeSampleSequenceDesc = prometheus.NewDesc(
+		"node_sample_sequence",
+		"Number of the last sample in the boot of the device, the fleet deduplicates and detects the missing samples by boot id and sequence",
+		[]string{"node", "boot_id"},
+		nil,
+	)
+	nodeSampleTimeDesc = prometheus.NewDesc(
+		"node_sample_time_seconds",
+		"Unix time of the end of the last sample on the device clock",
+		[]string{"node", "boot_id"},
+		nil,
+	)
+	nodeSampleCPUTimeDesc = prometheus.NewDesc(
+		"node_sample_cpu_time_seconds",
+		"CPU time of the processes of the node over the last sample",
+		[]string{"node"},
+		nil,
+	)
+	nodeSampleCPUCyclesDesc = prometheus.NewDesc(
+		"node_sample_cpu_cycles",
+		"CPU cycles of the node over the last sample",
+		[]string{"node"},
+		nil,
+	)
+	nodeSampleCPUInstrDesc = prometheus.NewDesc(
+		"node_sample_cpu_instructions",
+		"CPU instructions of the node over the last sample",
+		[]string{"node"},
+		nil,
+	)
+	nodeSampleCacheMissesDesc = prometheus.NewDesc(
+		"node_sample_cache_misses",
+		"Cache misses of the node over the last sample",
+		[]string{"node"},
+		nil,
+	)
+	nodeCounterRunningDesc = prometheus.NewDesc(
+		"node_perf_counter_running_ratio",
+		"Fraction of the enabled time the hardware counter was counting over the last sample, below 1 when the PMU is multiplexed and the counts are scaled",
+		[]string{"node", "counter"},
+		nil,
+	)
+	bpfMapEntriesDesc = prometheus.NewDesc(
+		"kepler_bpf_map_entries",
+		"Processes aggregated from the eBPF events of the map in the last sample",
+		[]string{"node", "map"},
+		nil,
+	)
+	bpfMapCapacityDesc = prometheus.NewDesc(
+		"kepler_bpf_map_capacity",
+		"Capacity of the eBPF map, set by --bpf-map-size",
+		[]string{"node", "map"},
+		nil,
+	)
+	bpfMapUpdateFailuresDesc = prometheus.NewDesc(
+		"kepler_bpf_map_update_failures_total",
+		"Failed inserts into the eBPF map, the processes dropped from the accounting while the map was full",
+		[]string{"node", "map"},
+		nil,
+	)
+	bpfEventsLostDesc = prometheus.NewDesc(
+		"kepler_bpf_events_lost_total",
+		"Events of the eBPF module dropped by the kernel while its perf buffer was full",
+		[]string{"node", "module"},
+		nil,
+	)
+	sampleGapsDesc = prometheus.NewDesc(
+		"kepler_sample_gaps_total",
+		"Samples skipped or late, by cause: power_unchanged, sensor_error, unresolved, late or panic",
+		[]string{"node", "cause"},
+		nil,
+	)
+	counterRegressionsDesc = prometheus.NewDesc(
+		"kepler_counter_regressions_total",
+		"Exported counter values that went down and were clamped to the last value, by metric",
+		[]string{"node", "metric"},
+		nil,
+	)
+	nodeMemoryDesc = prometheus.NewDesc(
+		"node_memory_working_set_bytes",
+		"Memory working set of the node, from the kubelet",
+		[]string{"node"},
+		nil,
+	)
+)
+
+func NewExporter() *Exporter {
+	return &Exporter{}
+}
+
+// accountEdgeDeviceEnergy adds the energy of a sample to the node counters, the collector lock must be held
+func accountEdgeDeviceEnergy(e *CurrEdgeDeviceEnergy) {
+	aggEdgeDeviceEnergy["core"] += e.EnergyInCore
+	aggEdgeDeviceEnergy["dram"] += e.EnergyInDram
+	aggEdgeDeviceEnergy["gpu"] += e.EnergyInGPU
+	aggEdgeDeviceEnergy["other"] += e.EnergyInOther
+	for class, energy := range e.EnergyInAccelerator {
+		aggEdgeDeviceEnergy[class] += energy
+	}
+}
+
+func (e *Exporter) Describe(ch chan<- *prometheus.Desc) {
+	ch <- podEnergyDesc
+	ch <- podPackageCoreEnergyDesc
+	ch <- podCPUTimeDesc
+	ch <- podCPUCyclesDesc
+	ch <- podCPUInstrDesc
+	ch <- podCacheMissesDesc
+	ch <- podIOBytesDesc
+	ch <- nodeEnergyDesc
+	ch <- nodePowerBreakdownDesc
+	ch <- nodeOtherCalibratedDesc
+	ch <- nodeIdlePowerDesc
+	ch <- nodeOtherEnergyDesc
+	ch <- nodeUnattributedDesc
+	ch <- nodeSampleQualityDesc
+	ch <- nodeSampleSourceDesc
+	ch <- nodeSampleEnergyDesc
+	ch <- nodeSampleSequenceDesc
+	ch <- nodeSampleTimeDesc
+	ch <- nodeSampleCPUTimeDesc
+	ch <- nodeSampleCPUCyclesDesc
+	ch <- nodeSampleCPUInstrDesc
+	ch <- nodeSampleCacheMissesDesc
+	ch <- nodeCounterRunningDesc
+	ch <- bpfMapEntriesDesc
+	ch <- bpfMapCapacityDesc
+	ch <- bpfMapUpdateFailuresDesc
+	ch <- bpfEventsLostDesc
+	ch <- sampleGapsDesc
+	ch <- counterRegressionsDesc
+	ch <- nodeMemoryDesc
+}
+
+func (e *Exporter) Collect(ch chan<- prometheus.Metric) {
+	defer persistExportedCounters(false)
+	lock.Lock()
+	defer lock.Unlock()
+	for _, v := range containerEnergy {
+		labels := []string{v.ContainerName, v.Namespace, v.Command, EdgeDeviceName}
+		/* energy (J) = energy (mJ) / 1000 */
+		energy := map[string]uint64{
+			"core":  v.AggEnergyInCore,
+			"dram":  v.AggEnergyInDram,
+			"gpu":   v.AggEnergyInGPU,
+			"other": v.AggEnergyInOther,
+		}
+		for class, e := range v.AggEnergyInAccelerator {
+			energy[class] = e
+		}
+		for component, e := range energy {
+			ch <- monotonicCounter(podEnergyDesc, "pod_energy_joule_total", float64(e)/1000, append(labels, component)...)
+		}
+		for pkg, e := range v.AggEnergyInCorePerPackage {
+			ch <- monotonicCounter(podPackageCoreEnergyDesc, "pod_package_core_energy_joule_total", float64(e)/1000, append(labels, strconv.Itoa(pkg))...)
+		}
+		ch <- monotonicCounter(podCPUTimeDesc, "pod_cpu_time_seconds_total", v.AggCPUTime, labels...)
+		ch <- monotonicCounter(podCPUCyclesDesc, "pod_cpu_cycles_total", float64(v.AggCPUCycles), labels...)
+		ch <- monotonicCounter(podCPUInstrDesc, "pod_cpu_instructions_total", float64(v.AggCPUInstr), labels...)
+		ch <- monotonicCounter(podCacheMissesDesc, "pod_cache_misses_total", float64(v.AggCacheMisses), labels...)
+		// the Agg I/O bytes are the cumulative reads and writes of the cgroups
+		ch <- monotonicCounter(podIOBytesDesc, "pod_io_bytes_total", float64(v.AggBytesRead), append(labels, "read")...)
+		ch <- monotonicCounter(podIOBytesDesc, "pod_io_bytes_total", float64(v.AggBytesWrite), append(labels, "write")...)
+	}
+
+	for component, e := range aggEdgeDeviceEnergy {
+		ch <- monotonicCounter(nodeEnergyDesc, "node_energy_joule_total", e/1000, EdgeDeviceName, component)
+	}
+	node := currEdgeDeviceEnergy
+	sample := map[string]float64{
+		"core":  node.EnergyInCore,
+		"dram":  node.EnergyInDram,
+		"gpu":   node.EnergyInGPU,
+		"other": node.EnergyInOther,
+	}
+	for class, e := range node.EnergyInAccelerator {
+		sample[class] = e
+	}
+	for component, e := range sample {
+		ch <- prometheus.MustNewConstMetric(nodeSampleEnergyDesc, prometheus.GaugeValue, e/1000, EdgeDeviceName, component)
+	}
+	if node.SampleSeconds > 0 {
+		for component, e := range node.Breakdown {
+			/* power (W) = energy (mJ) / 1000 / time(second) */
+			ch <- prometheus.MustNewConstMetric(nodePowerBreakdownDesc, prometheus.GaugeValue, e/1000/node.SampleSeconds, EdgeDeviceName, component)
+		}
+	}
+	if node.CalibratedOtherPower > 0 {
+		ch <- prometheus.MustNewConstMetric(nodeOtherCalibratedDesc, prometheus.GaugeValue, node.CalibratedOtherPower, EdgeDeviceName)
+	}
+	if node.IdlePower > 0 {
+		ch <- prometheus.MustNewConstMetric(nodeIdlePowerDesc, prometheus.GaugeValue, node.IdlePower, EdgeDeviceName)
+	}
+	ch <- monotonicCounter(nodeOtherEnergyDesc, "node_other_energy_joule_total", aggStaticEnergy/1000, EdgeDeviceName, "static")
+	ch <- monotonicCounter(nodeOtherEnergyDesc, "node_other_energy_joule_total", aggDynamicOther/1000, EdgeDeviceName, "dynamic")
+	ch <- monotonicCounter(nodeUnattributedDesc, "node_unattributed_energy_joule_total", aggUnattributed/1000, EdgeDeviceName)
+	if node.Quality != nil {
+		ch <- prometheus.MustNewConstMetric(nodeSampleQualityDesc, prometheus.GaugeValue, node.Quality.Score, EdgeDeviceName)
+		for source, status := range node.Quality.Sources {
+			ch <- prometheus.MustNewConstMetric(nodeSampleSourceDesc, prometheus.GaugeValue, 1, EdgeDeviceName, source, status)
+		}
+	}
+	if node.Sequence > 0 {
+		ch <- prometheus.MustNewConstMetric(nodeSampleSequenceDesc, prometheus.GaugeValue, float64(node.Sequence), EdgeDeviceName, BootID)
+		/* time (s) = time (ns) / 10^9 */
+		ch <- prometheus.MustNewConstMetric(nodeSampleTimeDesc, prometheus.GaugeValue, float64(node.Time.UnixNano())/1e9, EdgeDeviceName, BootID)
+	}
+	ch <- prometheus.MustNewConstMetric(nodeSampleCPUTimeDesc, prometheus.GaugeValue, node.CPUTime, EdgeDeviceName)
+	ch <- prometheus.MustNewConstMetric(nodeSampleCPUCyclesDesc, prometheus.GaugeValue, float64(node.CPUCycles), EdgeDeviceName)
+	ch <- prometheus.MustNewConstMetric(nodeSampleCPUInstrDesc, prometheus.GaugeValue, float64(node.CPUInstr), EdgeDeviceName)
+	ch <- prometheus.MustNewConstMetric(nodeSampleCacheMissesDesc, prometheus.GaugeValue, float64(node.CacheMisses), EdgeDeviceName)
+	for counter, ratio := range counterRunningRatio {
+		ch <- prometheus.MustNewConstMetric(nodeCounterRunningDesc, prometheus.GaugeValue, ratio, EdgeDeviceName, counter)
+	}
+	for name, entries := range mapEntries {
+		ch <- prometheus.MustNewConstMetric(bpfMapEntriesDesc, prometheus.GaugeValue, float64(entries), EdgeDeviceName, name)
+		ch <- prometheus.MustNewConstMetric(bpfMapCapacityDesc, prometheus.GaugeValue, float64(attacher.MapSize), EdgeDeviceName, name)
+	}
+	for name, n := range mapUpdateFailures {
+		ch <- prometheus.MustNewConstMetric(bpfMapUpdateFailuresDesc, prometheus.CounterValue, float64(n), EdgeDeviceName, name)
+	}
+	for module, n := range eventsLost {
+		ch <- prometheus.MustNewConstMetric(bpfEventsLostDesc, prometheus.CounterValue, float64(n), EdgeDeviceName, module)
+	}
+	for cause, n := range sampleGaps {
+		ch <- prometheus.MustNewConstMetric(sampleGapsDesc, prometheus.CounterValue, float64(n), EdgeDeviceName, cause)
+	}
+	for metric, n := range counterRegressions {
+		ch <- prometheus.MustNewConstMetric(counterRegressionsDesc, prometheus.CounterValue, float64(n), EdgeDeviceName, metric)
+	}
+	ch <- prometheus.MustNewConstMetric(nodeMemoryDesc, prometheus.GaugeValue, node.EdgeDeviceMem, EdgeDeviceName)
+}
@@ -0,0 +1,53 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+// The fingerprint identifies the same workload across the fleet whatever its pod name and namespace:
+// it is a hash of the sorted image digests of the pod, which do not change while the pod runs, unlike
+// its processes. It is exported as its own series, joined on the container name and namespace.
+const fingerprintLength = 16
+
+var containerFingerprintDesc = prometheus.NewDesc(
+	"container_fingerprint",
+	"Workload fingerprint of the container, a hash of the image digests of its pod.",
+	[]string{
+		"container_name",
+		"container_namespace",
+		"fingerprint",
+	},
+	nil,
+)
+
+// getFingerprint returns the fingerprint of a pod, empty if its images are not known yet
+func getFingerprint(namespace, pod string) string {
+	images := pod_lister.GetPodImages(namespace, pod)
+	if len(images) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.Join(images, ",")))
+	return hex.EncodeToString(sum[:])[:fingerprintLength]
+}
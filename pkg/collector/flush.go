@@ -0,0 +1,68 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// lastSampleTimeout bounds the wait for the last partial sample taken by Flush
+const lastSampleTimeout = 5 * time.Second
+
+var flushHooks []func()
+
+// OnFlush registers a function called by Flush, e.g. to push the buffered samples to a remote sink
+func OnFlush(f func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	flushHooks = append(flushHooks, f)
+}
+
+// Flush takes the last partial sample, saves the state kept between the periodic saves and runs the
+// flush hooks, it is called before the node powers off so that a planned shutdown does not lose the
+// last interval of data
+func (c *Collector) Flush() {
+	if c.done != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), lastSampleTimeout)
+		if _, err := SampleNow(ctx); err != nil {
+			log.Printf("failed to take the last sample: %v\n", err)
+		}
+		cancel()
+	}
+	lock.Lock()
+	if err := store.Save(periodTotalsKey, periodTotals); err != nil {
+		log.Printf("failed to save the period totals: %v\n", err)
+	}
+	var peak *PeakEvent
+	if peakEvent != nil {
+		event := *peakEvent
+		peak = &event
+	}
+	hooks := flushHooks
+	lock.Unlock()
+	if peak != nil {
+		savePeakEvent(peak)
+	}
+	persistExportedCounters(true)
+	for _, f := range hooks {
+		f()
+	}
+}
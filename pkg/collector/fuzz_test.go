@@ -0,0 +1,127 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"testing"
+)
+
+// The fuzz targets run their seeds with go test, and explore with go test -fuzz=FuzzDecodeProcessEvent ./pkg/collector
+
+func encodeProcessEvent(e ProcessEvent) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.LittleEndian, e)
+	return buf.Bytes()
+}
+
+func FuzzDecodeProcessEvent(f *testing.F) {
+	e := ProcessEvent{CGroupPID: 1234, PID: 42, ProcessRunTime: 1000, CPUCycles: 5e6, CacheMisses: 1500, CPU: 3}
+	copy(e.Command[:], "nginx")
+	valid := encodeProcessEvent(e)
+	f.Add(valid)
+	f.Add(valid[:len(valid)-1])
+	// the perf buffer pads the samples to 8 bytes after their 4 bytes size
+	f.Add(append(valid, 0, 0, 0, 0))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var e ProcessEvent
+		err := decodeEvent(data, &e)
+		size := binary.Size(e)
+		if len(data) < size {
+			if err == nil {
+				t.Fatalf("decoded an event of %d bytes", len(data))
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("failed to decode an event of %d bytes: %v", len(data), err)
+		}
+		if !bytes.Equal(encodeProcessEvent(e), data[:size]) {
+			t.Fatalf("decoded event does not encode back to the data")
+		}
+	})
+}
+
+func FuzzCommandString(f *testing.F) {
+	f.Add([]byte("nginx"))
+	f.Add([]byte("sixteen-chars-xx"))
+	f.Add([]byte{0, 'a', 'b'})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		var command [16]byte
+		copy(command[:], data)
+		s := commandString(command)
+		if len(s) > len(command) {
+			t.Fatalf("command %q longer than the comm array", s)
+		}
+		if bytes.IndexByte([]byte(s), 0) >= 0 {
+			t.Fatalf("command %q holds a NUL", s)
+		}
+		if !bytes.HasPrefix(command[:], []byte(s)) {
+			t.Fatalf("command %q is not a prefix of the comm", s)
+		}
+	})
+}
+
+func FuzzNodeBreakdown(f *testing.F) {
+	f.Add(10000.0, 4000.0, 1000.0, 0.0, 0.0, 300.0, 200.0, 2500.0)
+	f.Add(5000.0, 4000.0, 1000.0, 500.0, 0.0, 300.0, 200.0, 0.0)
+	f.Add(0.0, 4000.0, 1000.0, 0.0, 100.0, 300.0, 200.0, 0.0)
+	f.Add(1000.0, 200.0, 100.0, 0.0, 0.0, 5000.0, 5000.0, 9000.0)
+	f.Fuzz(func(t *testing.T, node, cpu, dram, gpu, accelerator, nic, storage, platform float64) {
+		in := breakdownInput{node, cpu, dram, gpu, accelerator, nic, storage, platform}
+		for _, e := range []float64{node, cpu, dram, gpu, accelerator, nic, storage, platform} {
+			if e < 0 || e > 1e12 || math.IsNaN(e) {
+				return
+			}
+		}
+		b := nodeBreakdown(in)
+		sum := float64(0)
+		for component, e := range b {
+			if e < 0 {
+				t.Fatalf("negative %s energy %v for %+v", component, e, in)
+			}
+			sum += e
+		}
+		want := node
+		if node == 0 {
+			want = cpu + dram + gpu + accelerator + nic + storage
+		}
+		if math.Abs(sum-want) > 1e-6*math.Max(want, 1) {
+			t.Fatalf("components sum to %v, not the node energy %v for %+v", sum, want, in)
+		}
+	})
+}
+
+func FuzzParseNetDev(f *testing.F) {
+	f.Add([]byte("Inter-|   Receive                                                |  Transmit\n" +
+		" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+		"    lo:    2776      28    0    0    0     0          0         0     2776      28    0    0    0     0       0          0\n" +
+		"  eth0: 1523786    1190    0    0    0     0          0         0    91652    1047    0    0    0     0       0          0\n"))
+	f.Add([]byte("  eth0: 1 2 3\nnet1:5 6 0 0 0 0 0 0 7 8 0 0 0 0 0 0\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		traffic := parseNetDev(data)
+		if traffic.RxBytes == 0 && traffic.TxBytes == 0 && traffic.RxPackets == 0 && traffic.TxPackets == 0 {
+			return
+		}
+		if !bytes.Contains(data, []byte(":")) {
+			t.Fatalf("traffic %+v parsed without an interface", traffic)
+		}
+	})
+}
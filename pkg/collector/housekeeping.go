@@ -0,0 +1,120 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+// A common edge pattern isolates cores (isolcpus, nohz_full) for a latency critical application
+// and leaves the kernel threads, IRQs and the rest of the system on the housekeeping cores.
+const (
+	isolatedCPUsPath       = "/sys/devices/system/cpu/isolated"
+	nohzFullCPUsPath       = "/sys/devices/system/cpu/nohz_full"
+	defaultIRQAffinityPath = "/proc/irq/default_smp_affinity"
+
+	cpuRoleHousekeeping = "housekeeping"
+	cpuRoleIsolated     = "isolated"
+	cpuRoleNoHzFull     = "nohz_full"
+)
+
+var (
+	cpuRoles        = map[int32]string{}
+	irqAffinityCPUs = map[int32]bool{}
+	// cpuIsolation is true if at least one CPU is isolated from the housekeeping work
+	cpuIsolation = false
+)
+
+func init() {
+	detectCPUIsolation()
+}
+
+func detectCPUIsolation() {
+	for cpu := int32(0); cpu < int32(numCPUs); cpu++ {
+		cpuRoles[cpu] = cpuRoleHousekeeping
+	}
+	// nohz_full CPUs are usually isolated too, in which case isolated wins
+	for _, role := range []string{cpuRoleNoHzFull, cpuRoleIsolated} {
+		path := nohzFullCPUsPath
+		if role == cpuRoleIsolated {
+			path = isolatedCPUsPath
+		}
+		for _, cpu := range readCPUList(path) {
+			cpuRoles[cpu] = role
+			cpuIsolation = true
+		}
+	}
+	if data, err := ioutil.ReadFile(defaultIRQAffinityPath); err == nil {
+		irqAffinityCPUs = parseCPUMask(strings.TrimSpace(string(data)))
+	}
+}
+
+func readCPUList(path string) []int32 {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	list := strings.TrimSpace(string(data))
+	// nohz_full reports "(null)" when not set
+	if len(list) == 0 || strings.HasPrefix(list, "(") {
+		return nil
+	}
+	cpus, err := pod_lister.ParseCPUList(list)
+	if err != nil {
+		return nil
+	}
+	return cpus
+}
+
+// parseCPUMask parses the kernel hex cpu mask format, made of comma separated 32 bit words, e.g. "ff,00000003"
+func parseCPUMask(mask string) map[int32]bool {
+	cpus := map[int32]bool{}
+	words := strings.Split(mask, ",")
+	for i := range words {
+		// the last word holds the first 32 CPUs
+		word, err := strconv.ParseUint(words[len(words)-1-i], 16, 32)
+		if err != nil {
+			return cpus
+		}
+		for bit := int32(0); bit < 32; bit++ {
+			if word&(1<<uint(bit)) != 0 {
+				cpus[int32(i)*32+bit] = true
+			}
+		}
+	}
+	return cpus
+}
+
+// getHousekeepingCPUTime returns the time spent on housekeeping CPUs and on all CPUs
+func getHousekeepingCPUTime(cpuTime []uint16) (float64, float64) {
+	housekeepingTime := float64(0)
+	totalTime := float64(0)
+	for cpu, t := range cpuTime {
+		if t == 0 {
+			continue
+		}
+		totalTime += float64(t)
+		if cpuRoles[int32(cpu)] == cpuRoleHousekeeping {
+			housekeepingTime += float64(t)
+		}
+	}
+	return housekeepingTime, totalTime
+}
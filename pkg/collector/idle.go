@@ -0,0 +1,135 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+	http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"log"
+	"math"
+)
+
+// The other energy, the node energy the CPU, DRAM, GPU and accelerators don't account for, is mostly the
+// static power of the board (regulators, fans, peripherals) drawn whatever the pods run. The idle power,
+// configured or measured at startup as the lowest other power over the first measured samples, splits the
+// other energy into its static part, attributed by the idle attribution policy, and its dynamic rest,
+// attributed by the CPU time. Without an idle power the whole other energy follows the policy.
+const (
+	IdleAttributionEven   = "even"
+	IdleAttributionCPU    = "cpu"
+	IdleAttributionMemory = "memory"
+	IdleAttributionNone   = "unattributed"
+
+	idleMeasureSamples = 20
+)
+
+var (
+	idleAttribution = IdleAttributionEven
+	// idlePower is the static other power in W, 0 if unknown
+	idlePower       float64
+	measureIdle     bool
+	idleMeasured    int
+	idleMeasuredMin float64
+	aggStaticEnergy float64
+	aggDynamicOther float64
+	aggUnattributed float64
+)
+
+// SetIdleAttribution selects how the static energy is attributed: even, cpu, memory or unattributed
+func SetIdleAttribution(policy string) error {
+	switch policy {
+	case IdleAttributionEven, IdleAttributionCPU, IdleAttributionMemory, IdleAttributionNone:
+		idleAttribution = policy
+		return nil
+	}
+	return fmt.Errorf("invalid idle attribution %q, even, cpu, memory or unattributed", policy)
+}
+
+// SetIdlePower sets the static other power in W, or measures it at startup if measure is set and no
+// power is given
+func SetIdlePower(watts float64, measure bool) error {
+	if watts < 0 {
+		return fmt.Errorf("invalid idle power %.2f W", watts)
+	}
+	idlePower = watts
+	measureIdle = measure && watts == 0
+	return nil
+}
+
+// measureIdlePower keeps the lowest other power (mJ over seconds) of the first measured samples
+func measureIdlePower(otherDelta, seconds float64) {
+	if !measureIdle || idleMeasured >= idleMeasureSamples || seconds <= 0 {
+		return
+	}
+	/* power (W) = energy (mJ) / 1000 / time(second) */
+	power := otherDelta / 1000 / seconds
+	if idleMeasured == 0 || power < idleMeasuredMin {
+		idleMeasuredMin = power
+	}
+	idleMeasured++
+	if idleMeasured == idleMeasureSamples {
+		idlePower = idleMeasuredMin
+		log.Printf("measured idle power %.2f W over %d samples\n", idlePower, idleMeasured)
+	}
+}
+
+// splitOther splits the other energy (mJ over seconds) into its static and dynamic parts
+func splitOther(otherDelta, seconds float64) (float64, float64) {
+	if idlePower == 0 {
+		return otherDelta, 0
+	}
+	static := math.Min(idlePower*1000*seconds, otherDelta)
+	return static, otherDelta - static
+}
+
+// otherShare returns the part of the static and dynamic other energy attributed to a container among
+// count, with its resident memory mem out of memTotal
+func otherShare(v *ContainerEnergy, static, dynamic float64, count int, aggCPUTime, mem, memTotal float64) float64 {
+	even := 1 / float64(count)
+	cpuShare := even
+	if aggCPUTime > 0 {
+		cpuShare = v.CurrCPUTime / aggCPUTime
+	}
+	share := dynamic * cpuShare
+	switch idleAttribution {
+	case IdleAttributionEven:
+		share += static * even
+	case IdleAttributionCPU:
+		share += static * cpuShare
+	case IdleAttributionMemory:
+		if memTotal > 0 {
+			share += static * mem / memTotal
+		} else {
+			share += static * even
+		}
+	}
+	return share
+}
+
+// unattributedEnergy returns the part of the static energy left out of the containers
+func unattributedEnergy(static float64) float64 {
+	if idleAttribution == IdleAttributionNone {
+		return static
+	}
+	return 0
+}
+
+// accountOther adds the parts of the other energy of a sample to the node totals
+func accountOther(static, dynamic, unattributed float64) {
+	aggStaticEnergy += static
+	aggDynamicOther += dynamic
+	aggUnattributed += unattributed
+}
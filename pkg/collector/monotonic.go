@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// The Agg counters restart from zero with the exporter and go down when a container is recreated under the
+// same name or the cgroup I/O is rebased, breaking rate() downstream. Where they are exported, a value
+// below the last exported one is clamped to it, the series continuing from there, and the regression is
+// counted. The last values are saved in the store every minute and by the flush at shutdown, so the series
+// continue across restarts. After a crash, a series may go down by what was exported since the last save.
+const (
+	exportedCountersKey        = "exported_counters"
+	exportedCountersSavePeriod = time.Minute
+	// the series not exported for that long are forgotten, e.g. of the removed containers
+	exportedCounterTTL = 24 * time.Hour
+)
+
+type exportedCounter struct {
+	Last   float64   `json:"last"`
+	Offset float64   `json:"offset"`
+	Seen   time.Time `json:"seen"`
+	// restored is set on the counters loaded from the store, going down after the restart is expected
+	restored bool
+}
+
+var (
+	exportedCounters   = map[string]*exportedCounter{}
+	counterRegressions = map[string]uint64{}
+	// exportedCountersSave orders the saves, an older copy must not overwrite a newer one
+	exportedCountersSave     sync.Mutex
+	lastExportedCountersSave time.Time
+)
+
+// monotonicCounter returns the counter metric name{labels} of the value, never below its last exported
+// value. The collector lock must be held.
+func monotonicCounter(desc *prometheus.Desc, name string, value float64, labels ...string) prometheus.Metric {
+	// the keys are saved in JSON, the separator must be valid UTF-8
+	key := name + "\x00" + strings.Join(labels, "\x00")
+	c, ok := exportedCounters[key]
+	if !ok {
+		c = &exportedCounter{}
+		exportedCounters[key] = c
+	}
+	exported := c.Offset + value
+	if exported < c.Last {
+		if !c.restored {
+			counterRegressions[name]++
+		}
+		c.Offset = c.Last - value
+		exported = c.Last
+	}
+	c.restored = false
+	c.Last = exported
+	c.Seen = time.Now()
+	return prometheus.MustNewConstMetric(desc, prometheus.CounterValue, exported, labels...)
+}
+
+func loadExportedCounters() {
+	counters := map[string]*exportedCounter{}
+	if _, err := store.Load(exportedCountersKey, &counters); err != nil {
+		log.Printf("failed to load the exported counters: %v\n", err)
+		return
+	}
+	for key, c := range counters {
+		c.restored = true
+		exportedCounters[key] = c
+	}
+}
+
+// pruneExportedCounters forgets the series not exported lately. The collector lock must be held.
+func pruneExportedCounters(now time.Time) {
+	for key, c := range exportedCounters {
+		if now.Sub(c.Seen) > exportedCounterTTL {
+			delete(exportedCounters, key)
+		}
+	}
+}
+
+// persistExportedCounters saves the last exported values at most every exportedCountersSavePeriod, unless
+// forced by the flush. It is called after the scrapes without the collector lock held, which is only taken
+// to copy them.
+func persistExportedCounters(force bool) {
+	exportedCountersSave.Lock()
+	defer exportedCountersSave.Unlock()
+	now := time.Now()
+	if !force && now.Sub(lastExportedCountersSave) < exportedCountersSavePeriod {
+		return
+	}
+	lastExportedCountersSave = now
+	lock.Lock()
+	counters := make(map[string]exportedCounter, len(exportedCounters))
+	for key, c := range exportedCounters {
+		counters[key] = *c
+	}
+	lock.Unlock()
+	if err := store.Save(exportedCountersKey, counters); err != nil {
+		log.Printf("failed to save the exported counters: %v\n", err)
+	}
+}
@@ -0,0 +1,57 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+)
+
+// When more hardware counters are opened than the PMU has, the kernel multiplexes them and each counts
+// a fraction of the time. The eBPF program scales the counts by the enabled over running time, the
+// running ratio of each counter over the sample tells how much of the cycle and instruction counts
+// was extrapolated.
+var (
+	lastCounterTime map[string]attacher.CounterTime
+	// counterRunningRatio is the running over enabled time of each counter in the last sample
+	counterRunningRatio = map[string]float64{}
+	multiplexingLogged  bool
+)
+
+// updateCounterMultiplexing reads the counter times, the collector lock must be held
+func updateCounterMultiplexing(modules *attacher.BpfModuleTables) {
+	times := attacher.ReadCounterTime(modules)
+	last := lastCounterTime
+	lastCounterTime = times
+	counterRunningRatio = map[string]float64{}
+	for counter, t := range times {
+		l, ok := last[counter]
+		if !ok || t.Enabled <= l.Enabled || t.Running < l.Running {
+			continue
+		}
+		ratio := float64(t.Running-l.Running) / float64(t.Enabled-l.Enabled)
+		if ratio > 1 {
+			ratio = 1
+		}
+		counterRunningRatio[counter] = ratio
+		if ratio < 1 && !multiplexingLogged {
+			log.Printf("the hardware counters are multiplexed, %s counted %.0f%% of the time, the counts are scaled\n", counter, ratio*100)
+			multiplexingLogged = true
+		}
+	}
+}
@@ -0,0 +1,195 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+)
+
+// Without the net bpf module, the traffic of the pods is read from the interface counters of their
+// network namespaces, in /proc/<pid>/net/dev of a process of the pod: the veth, macvlan or ipvlan
+// interface of the pod, less the loopback. The namespace is attributed to the container of the first
+// process seen in it, the containers of a pod share it. The pods in the host network namespace are not
+// accounted, their traffic can not be told from the node's.
+const (
+	hostNetnsPath = "/proc/1/ns/net"
+	// the counters of a namespace are kept for netnsRetention after it was last read, a pod without any
+	// process scheduled in a sample keeps its baseline
+	netnsRetention = 10 * time.Minute
+)
+
+var (
+	netnsAccounting = true
+	hostNetns, _    = netnsInode(hostNetnsPath)
+	// lastNetnsTraffic are the interface counters of the namespaces when they were last read
+	lastNetnsTraffic = map[uint64]netnsCounters{}
+)
+
+type netnsCounters struct {
+	netnsTraffic
+	seen time.Time
+}
+
+type netnsTraffic struct {
+	RxBytes   uint64
+	RxPackets uint64
+	TxBytes   uint64
+	TxPackets uint64
+}
+
+// netnsSample maps the network namespaces of the processes of a sample to the container and the process
+// their counters are read from
+type netnsSample struct {
+	owners  map[uint64]netnsOwner
+	cgroups map[uint64]bool
+}
+
+type netnsOwner struct {
+	container string
+	pid       uint64
+}
+
+// SetNetnsAccounting sets whether the traffic of the pods is read from their interfaces without the net module
+func SetNetnsAccounting(enable bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	netnsAccounting = enable
+}
+
+// netnsAccounted returns whether the traffic is read from the network namespaces
+func netnsAccounted() bool {
+	return netnsAccounting && !attacher.EnableNetwork
+}
+
+// networkAccounted returns whether the traffic of the containers is accounted, by the net module or the namespaces
+func networkAccounted() bool {
+	return attacher.EnableNetwork || netnsAccounted()
+}
+
+func newNetnsSample() *netnsSample {
+	return &netnsSample{owners: map[uint64]netnsOwner{}, cgroups: map[uint64]bool{}}
+}
+
+// add records the network namespace of the first process of each cgroup
+func (s *netnsSample) add(cgroupID, pid uint64, container string) {
+	if s.cgroups[cgroupID] {
+		return
+	}
+	s.cgroups[cgroupID] = true
+	ino, err := netnsInode(fmt.Sprintf("/proc/%d/ns/net", pid))
+	if err != nil || ino == hostNetns {
+		return
+	}
+	if _, ok := s.owners[ino]; !ok {
+		s.owners[ino] = netnsOwner{container: container, pid: pid}
+	}
+}
+
+// accountNetnsTraffic adds the traffic of the namespaces since the last sample to their containers, the collector
+// lock must be held
+func accountNetnsTraffic(s *netnsSample) {
+	now := time.Now()
+	for ino, c := range lastNetnsTraffic {
+		if now.Sub(c.seen) > netnsRetention {
+			delete(lastNetnsTraffic, ino)
+		}
+	}
+	for ino, owner := range s.owners {
+		data, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/net/dev", owner.pid))
+		if err != nil {
+			continue
+		}
+		t := parseNetDev(data)
+		last, ok := lastNetnsTraffic[ino]
+		lastNetnsTraffic[ino] = netnsCounters{netnsTraffic: t, seen: now}
+		v := containerEnergy[owner.container]
+		// the first sample of a namespace is its baseline, the counters of a recreated interface restart
+		if !ok || v == nil || t.RxBytes < last.RxBytes || t.TxBytes < last.TxBytes ||
+			t.RxPackets < last.RxPackets || t.TxPackets < last.TxPackets {
+			continue
+		}
+		accountTraffic(v, &ProcessTraffic{
+			RxBytes:   t.RxBytes - last.RxBytes,
+			RxPackets: t.RxPackets - last.RxPackets,
+			TxBytes:   t.TxBytes - last.TxBytes,
+			TxPackets: t.TxPackets - last.TxPackets,
+		})
+	}
+}
+
+// netnsInode returns the inode identifying a network namespace
+func netnsInode(path string) (uint64, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, fmt.Errorf("no inode for %s", path)
+	}
+	return stat.Ino, nil
+}
+
+// parseNetDev sums the counters of the interfaces of /proc/net/dev but the loopback, e.g.
+//
+//	Inter-|   Receive                                                |  Transmit
+//	 face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed
+//	    lo:    2776      28    0    0    0     0          0         0     2776      28    0    0    0     0       0          0
+//	  eth0: 1523786    1190    0    0    0     0          0         0    91652    1047    0    0    0     0       0          0
+func parseNetDev(data []byte) netnsTraffic {
+	t := netnsTraffic{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		i := strings.IndexByte(scanner.Text(), ':')
+		if i < 0 {
+			continue
+		}
+		iface := strings.TrimSpace(scanner.Text()[:i])
+		fields := strings.Fields(scanner.Text()[i+1:])
+		if iface == "lo" || len(fields) < 10 {
+			continue
+		}
+		counters := [4]uint64{}
+		valid := true
+		for j, field := range []int{0, 1, 8, 9} {
+			v, err := strconv.ParseUint(fields[field], 10, 64)
+			if err != nil {
+				valid = false
+				break
+			}
+			counters[j] = v
+		}
+		if !valid {
+			continue
+		}
+		t.RxBytes += counters[0]
+		t.RxPackets += counters[1]
+		t.TxBytes += counters[2]
+		t.TxPackets += counters[3]
+	}
+	return t
+}
@@ -0,0 +1,113 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+// The network energy of a container is estimated with a linear NIC model from its socket traffic:
+// a cost per byte (DMA, serialization) and per packet (interrupts, descriptors, protocol
+// processing). The coefficients calibrated by nic-calibration are used if any, the defaults are in
+// the range measured on edge gigabit and WiFi interfaces.
+const (
+	DefaultNICEnergyPerByte   = 5.0    // nJ
+	DefaultNICEnergyPerPacket = 2000.0 // nJ
+)
+
+var (
+	nicEnergyPerByte   = DefaultNICEnergyPerByte
+	nicEnergyPerPacket = DefaultNICEnergyPerPacket
+)
+
+// SetNICModel sets the energy per byte and per packet of the NIC model in nJ
+func SetNICModel(perByte, perPacket float64) {
+	lock.Lock()
+	defer lock.Unlock()
+	nicEnergyPerByte = perByte
+	nicEnergyPerPacket = perPacket
+}
+
+// ProcessTraffic is a transfer of a process sent by the net module, in sync with traffic_event_t, and
+// the traffic of a process summed over the sample
+type ProcessTraffic struct {
+	CGroupPID uint64
+	PID       uint64
+	TGID      uint64
+	StartTime uint64
+	TxBytes   uint64
+	RxBytes   uint64
+	TxPackets uint64
+	RxPackets uint64
+	Command   [16]byte
+}
+
+// processKey is the key of the processes in the aggregation of the events
+type processKey struct {
+	cgroupID  uint64
+	pid       uint64
+	startTime uint64
+}
+
+// addTrafficProcesses adds an entry for the processes with traffic but no CPU time in the interval,
+// all of them without the CPU module
+func addTrafficProcesses(processes []CgroupTime, traffic map[processKey]ProcessTraffic) []CgroupTime {
+	seen := make(map[processKey]bool, len(processes))
+	for _, ct := range processes {
+		seen[processKey{ct.CGroupPID, ct.PID, ct.StartTime}] = true
+	}
+	for key, t := range traffic {
+		if !seen[key] {
+			processes = append(processes, CgroupTime{CGroupPID: t.CGroupPID, PID: t.PID, TGID: t.TGID, StartTime: t.StartTime, Command: t.Command})
+		}
+	}
+	return processes
+}
+
+// accountTraffic adds the socket traffic of a process to its container
+func accountTraffic(v *ContainerEnergy, ct *ProcessTraffic) {
+	v.CurrBytesTx += ct.TxBytes
+	v.CurrBytesRx += ct.RxBytes
+	v.CurrPacketsTx += ct.TxPackets
+	v.CurrPacketsRx += ct.RxPackets
+	v.AggBytesTx += ct.TxBytes
+	v.AggBytesRx += ct.RxBytes
+	v.AggPacketsTx += ct.TxPackets
+	v.AggPacketsRx += ct.RxPackets
+}
+
+// nicEnergy returns the energy in mJ of the NIC model for a traffic
+func nicEnergy(bytes, packets uint64) float64 {
+	/* energy (mJ) = energy (nJ) / 10^6 */
+	return (float64(bytes)*nicEnergyPerByte + float64(packets)*nicEnergyPerPacket) / 1e6
+}
+
+// getNetworkEnergy returns the network energy in mJ of the sample and the scale applied to the
+// container estimates. With a node power meter, the network energy is part of the measured other
+// energy and is scaled down if the model exceeds it; without a meter, it is the model estimate.
+func getNetworkEnergy(otherDelta float64, measured bool) (float64, float64) {
+	if !networkAccounted() {
+		return 0, 0
+	}
+	total := float64(0)
+	for _, v := range containerEnergy {
+		total += nicEnergy(v.CurrBytesTx+v.CurrBytesRx, v.CurrPacketsTx+v.CurrPacketsRx)
+	}
+	if !measured || total <= otherDelta {
+		return total, 1
+	}
+	if otherDelta <= 0 {
+		return 0, 0
+	}
+	return otherDelta, otherDelta / total
+}
@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+const (
+	// the node object changes rarely, no need to query the API server on every sample
+	nodeStateRefreshPeriod = 30 * time.Second
+	nodeStateMaintenance   = "Maintenance"
+	anyWeekday             = -1
+)
+
+// maintenanceWindow is a weekly time window, e.g. "Sat 02:00-04:00" or "* 23:00-01:00"
+type maintenanceWindow struct {
+	weekday    int
+	start, end time.Duration
+}
+
+var (
+	weekdays = map[string]int{
+		"*": anyWeekday, "sun": 0, "mon": 1, "tue": 2, "wed": 3, "thu": 4, "fri": 5, "sat": 6,
+	}
+
+	maintenanceWindows   []maintenanceWindow
+	nodeStates           []string
+	lastNodeStateRefresh time.Time
+	// nodeStateEnergy is the accumulated node energy (mJ) per node state
+	nodeStateEnergy = map[string]float64{}
+)
+
+// SetMaintenanceWindows parses a comma separated list of weekly windows, e.g. "Sat 02:00-04:00,* 23:00-01:00"
+func SetMaintenanceWindows(spec string) error {
+	windows := []maintenanceWindow{}
+	for _, w := range strings.Split(spec, ",") {
+		w = strings.TrimSpace(w)
+		if len(w) == 0 {
+			continue
+		}
+		fields := strings.Fields(w)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid maintenance window %q", w)
+		}
+		weekday, ok := weekdays[strings.ToLower(fields[0])]
+		if !ok {
+			return fmt.Errorf("invalid weekday in maintenance window %q", w)
+		}
+		bounds := strings.Split(fields[1], "-")
+		if len(bounds) != 2 {
+			return fmt.Errorf("invalid time range in maintenance window %q", w)
+		}
+		start, err := parseTimeOfDay(bounds[0])
+		if err != nil {
+			return fmt.Errorf("invalid maintenance window %q: %v", w, err)
+		}
+		end, err := parseTimeOfDay(bounds[1])
+		if err != nil {
+			return fmt.Errorf("invalid maintenance window %q: %v", w, err)
+		}
+		windows = append(windows, maintenanceWindow{weekday: weekday, start: start, end: end})
+	}
+	maintenanceWindows = windows
+	return nil
+}
+
+func parseTimeOfDay(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+func (w maintenanceWindow) contains(now time.Time) bool {
+	if w.weekday != anyWeekday && int(now.Weekday()) != w.weekday {
+		return false
+	}
+	t := time.Duration(now.Hour())*time.Hour + time.Duration(now.Minute())*time.Minute
+	if w.start <= w.end {
+		return t >= w.start && t < w.end
+	}
+	// the window crosses midnight
+	return t >= w.start || t < w.end
+}
+
+// updateNodeStates refreshes the node conditions and the cordon state from the API server
+func updateNodeStates(now time.Time) {
+	if now.Sub(lastNodeStateRefresh) < nodeStateRefreshPeriod {
+		return
+	}
+	lastNodeStateRefresh = now
+	states, err := pod_lister.GetNodeStates()
+	if err != nil {
+		log.Printf("failed to get node states: %v\n", err)
+		// keep the last known states
+		return
+	}
+	nodeStates = states
+}
+
+// accountNodeStateEnergy attributes the node energy of the last sample to all the states the node is in
+func accountNodeStateEnergy(now time.Time, energy float64) {
+	for _, state := range nodeStates {
+		nodeStateEnergy[state] += energy
+	}
+	for _, w := range maintenanceWindows {
+		if w.contains(now) {
+			nodeStateEnergy[nodeStateMaintenance] += energy
+			break
+		}
+	}
+}
@@ -0,0 +1,212 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// A breaker trip at an edge site leaves no trace of what drew the power. When the node power exceeds the
+// peak threshold, the samples are taken at the peak sample period until the power has stayed below the
+// threshold for the capture duration, and recorded as a peak event in the store: the node, GPU and
+// accelerator power, the CPU frequencies and the top containers of each sample. The event is saved every
+// few samples, the power may go before it ends, by the reader once the sample released the lock.
+const (
+	DefaultPeakSamplePeriod    = 500 * time.Millisecond
+	DefaultPeakCaptureDuration = 30 * time.Second
+
+	minPeakSamplePeriod = 100 * time.Millisecond
+	peakEventsKey       = "peak_events"
+	maxPeakEvents       = 10
+	maxPeakSamples      = 240
+	peakSaveSamples     = 10
+	peakTopContainers   = 10
+)
+
+// PeakEvent is the detail of the samples above the peak threshold
+type PeakEvent struct {
+	Start time.Time `json:"start"`
+	// End is zero while the event is being captured
+	End            time.Time    `json:"end"`
+	ThresholdWatts float64      `json:"threshold_watts"`
+	MaxWatts       float64      `json:"max_watts"`
+	Samples        []PeakSample `json:"samples"`
+	// Dropped are the samples beyond maxPeakSamples
+	Dropped int `json:"dropped,omitempty"`
+}
+
+type PeakSample struct {
+	Time             time.Time          `json:"time"`
+	NodeWatts        float64            `json:"node_watts"`
+	CoreWatts        float64            `json:"core_watts"`
+	DramWatts        float64            `json:"dram_watts"`
+	GPUWatts         float64            `json:"gpu_watts"`
+	AcceleratorWatts map[string]float64 `json:"accelerator_watts,omitempty"`
+	// CPUFrequency is the frequency of each CPU in MHz
+	CPUFrequency map[int32]float64 `json:"cpu_frequency_mhz"`
+	States       []string          `json:"states,omitempty"`
+	Containers   []PeakContainer   `json:"containers"`
+}
+
+type PeakContainer struct {
+	Namespace string  `json:"namespace"`
+	Name      string  `json:"name"`
+	Watts     float64 `json:"watts"`
+}
+
+var (
+	peakThreshold    float64
+	peakSamplePeriod = DefaultPeakSamplePeriod
+	peakDuration     = DefaultPeakCaptureDuration
+	// peakEvent is the event being captured, until peakUntil
+	peakEvent *PeakEvent
+	peakUntil time.Time
+)
+
+// SetPeakCapture sets the node power (W) starting a peak event, 0 to disable, the sample period during the
+// event and how long it goes on once the power is below the threshold
+func SetPeakCapture(thresholdWatts float64, period, duration time.Duration) error {
+	if thresholdWatts < 0 {
+		return fmt.Errorf("invalid peak power threshold %v", thresholdWatts)
+	}
+	if period < minPeakSamplePeriod {
+		return fmt.Errorf("peak sample period %v is shorter than %v", period, minPeakSamplePeriod)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("invalid peak capture duration %v", duration)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	peakThreshold, peakSamplePeriod, peakDuration = thresholdWatts, period, duration
+	return nil
+}
+
+// effectiveSamplePeriod is the peak sample period during a peak event, the lock must be held
+func effectiveSamplePeriod() time.Duration {
+	if peakEvent != nil && peakSamplePeriod < samplePeriod {
+		return peakSamplePeriod
+	}
+	return samplePeriod
+}
+
+// capturePeak starts, records and ends the peak event with the snapshot of a sample, the lock must be held.
+// It returns a copy of the event to save with savePeakEvent once the lock is released, nil if none
+func capturePeak(s *Snapshot, seconds float64) *PeakEvent {
+	if peakThreshold <= 0 || seconds <= 0 {
+		return nil
+	}
+	watts := func(mJ float64) float64 {
+		return mJ / 1000 / seconds
+	}
+	node := s.EdgeDevice
+	nodeWatts := watts(node.EnergyInCore + node.EnergyInDram + node.EnergyInGPU + node.EnergyInOther)
+	if peakEvent == nil {
+		if nodeWatts <= peakThreshold {
+			return nil
+		}
+		log.Printf("node power %.1f W above %.1f W, capturing a peak event\n", nodeWatts, peakThreshold)
+		peakEvent = &PeakEvent{Start: s.Time, ThresholdWatts: peakThreshold}
+		notifySamplePeriod()
+	}
+	if nodeWatts > peakThreshold {
+		peakUntil = s.Time.Add(peakDuration)
+	}
+	if nodeWatts > peakEvent.MaxWatts {
+		peakEvent.MaxWatts = nodeWatts
+	}
+	if len(peakEvent.Samples) < maxPeakSamples {
+		sample := PeakSample{
+			Time:             s.Time,
+			NodeWatts:        nodeWatts,
+			CoreWatts:        watts(node.EnergyInCore),
+			DramWatts:        watts(node.EnergyInDram),
+			GPUWatts:         watts(node.EnergyInGPU),
+			AcceleratorWatts: map[string]float64{},
+			CPUFrequency:     map[int32]float64{},
+			States:           node.States,
+			Containers:       make([]PeakContainer, 0, len(s.Containers)),
+		}
+		for class, e := range node.Accelerators {
+			sample.AcceleratorWatts[class] = watts(e)
+		}
+		for cpu, khz := range cpuFrequency {
+			sample.CPUFrequency[cpu] = float64(khz) / 1000
+		}
+		for _, c := range s.Containers {
+			energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+			for _, e := range c.Accelerators {
+				energy += e
+			}
+			sample.Containers = append(sample.Containers, PeakContainer{Namespace: c.Namespace, Name: c.Name, Watts: watts(float64(energy))})
+		}
+		sort.Slice(sample.Containers, func(i, j int) bool {
+			return sample.Containers[i].Watts > sample.Containers[j].Watts
+		})
+		if len(sample.Containers) > peakTopContainers {
+			sample.Containers = sample.Containers[:peakTopContainers]
+		}
+		peakEvent.Samples = append(peakEvent.Samples, sample)
+	} else {
+		peakEvent.Dropped++
+	}
+	// the samples are only appended, the copy shares them
+	event := *peakEvent
+	if s.Time.After(peakUntil) {
+		event.End = s.Time
+		log.Printf("peak event of %v ended, max node power %.1f W\n", event.End.Sub(event.Start), event.MaxWatts)
+		peakEvent = nil
+		notifySamplePeriod()
+		return &event
+	}
+	if (len(event.Samples)+event.Dropped)%peakSaveSamples == 1 {
+		return &event
+	}
+	return nil
+}
+
+// savePeakEvent adds or updates the event in the peak events of the store, the oldest are dropped. It loads
+// and saves up to maxPeakEvents events, it is called without the lock
+func savePeakEvent(event *PeakEvent) {
+	events := []*PeakEvent{}
+	if _, err := store.Load(peakEventsKey, &events); err != nil {
+		log.Printf("failed to load the peak events: %v\n", err)
+	}
+	if n := len(events); n > 0 && events[n-1].Start.Equal(event.Start) {
+		events[n-1] = event
+	} else {
+		events = append(events, event)
+	}
+	if len(events) > maxPeakEvents {
+		events = events[len(events)-maxPeakEvents:]
+	}
+	if err := store.Save(peakEventsKey, events); err != nil {
+		log.Printf("failed to save the peak events: %v\n", err)
+	}
+}
+
+// notifySamplePeriod makes the reader reset its ticker to the effective sample period
+func notifySamplePeriod() {
+	select {
+	case samplePeriodChanged <- struct{}{}:
+	default:
+	}
+}
@@ -0,0 +1,103 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// Edge prometheus instances often keep a few hours of data, too short to compute increase() over a week.
+// The exporter keeps the running daily and weekly energy of each workload itself and saves them in the
+// store, so that a restart does not reset the current period.
+const (
+	periodDay  = "day"
+	periodWeek = "week"
+
+	periodTotalsKey        = "period_totals"
+	periodTotalsSavePeriod = time.Minute
+)
+
+// periodTotal is the energy (mJ) per "namespace/container" since the start of the period
+type periodTotal struct {
+	Start  time.Time          `json:"start"`
+	Energy map[string]float64 `json:"energy"`
+}
+
+var (
+	periodTotals = map[string]*periodTotal{
+		periodDay:  {Energy: map[string]float64{}},
+		periodWeek: {Energy: map[string]float64{}},
+	}
+	lastPeriodTotalsSave time.Time
+)
+
+// periodStart returns the local midnight starting the day, or the monday starting the week
+func periodStart(period string, now time.Time) time.Time {
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	if period == periodWeek {
+		// time.Weekday starts on sunday
+		days := (int(start.Weekday()) + 6) % 7
+		start = start.AddDate(0, 0, -days)
+	}
+	return start
+}
+
+func loadPeriodTotals() {
+	totals := map[string]*periodTotal{}
+	found, err := store.Load(periodTotalsKey, &totals)
+	if err != nil {
+		log.Printf("failed to load the period totals: %v\n", err)
+		return
+	}
+	if !found {
+		return
+	}
+	for period, t := range totals {
+		if _, ok := periodTotals[period]; ok && t.Energy != nil {
+			periodTotals[period] = t
+		}
+	}
+}
+
+// accountPeriodTotals adds the current energy of the containers to the running totals, starting
+// a new period when the day or the week is over
+func accountPeriodTotals(now time.Time) {
+	for period, t := range periodTotals {
+		start := periodStart(period, now)
+		if !t.Start.Equal(start) {
+			t.Start = start
+			t.Energy = map[string]float64{}
+		}
+		for containerName, v := range containerEnergy {
+			energy := float64(v.CurrEnergyInCore + v.CurrEnergyInDram + v.CurrEnergyInGPU + v.CurrEnergyInOther)
+			for _, e := range v.CurrEnergyInAccelerator {
+				energy += float64(e)
+			}
+			t.Energy[v.Namespace+"/"+containerName] += energy
+		}
+	}
+	if now.Sub(lastPeriodTotalsSave) < periodTotalsSavePeriod {
+		return
+	}
+	lastPeriodTotalsSave = now
+	if err := store.Save(periodTotalsKey, periodTotals); err != nil {
+		log.Printf("failed to save the period totals: %v\n", err)
+	}
+}
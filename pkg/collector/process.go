@@ -0,0 +1,73 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+)
+
+// The collector keys the processes by (cgroup id, pid, start time). A process moved to another
+// cgroup within an interval (e.g. a cgroup v2 migration) has an entry per cgroup, holding the time
+// and counters of its slices in each, and a reused pid has an entry per process. The energy measured
+// per process (GPU, accelerators), keyed by its pid (the tgid of its threads), is split among the entries
+// of its threads by their CPU time.
+
+// ProcessEvent is a slice of a process sent by the CPU module when it is switched out, in sync with
+// process_event_t
+type ProcessEvent struct {
+	CGroupPID      uint64
+	PID            uint64
+	TGID           uint64
+	StartTime      uint64
+	ProcessRunTime uint64
+	CPUCycles      uint64
+	CPUInstr       uint64
+	CacheMisses    uint64
+	CPU            uint32
+	Pad            uint32
+	Command        [16]byte
+}
+
+// commandString returns the command of a process entry, the kernel comm is NUL terminated unless it fills the array
+func commandString(command [16]byte) string {
+	if i := bytes.IndexByte(command[:], 0); i >= 0 {
+		return string(command[:i])
+	}
+	return string(command[:])
+}
+
+// getPidShares returns the share of the per process energy of each thread entry
+func getPidShares(processes []CgroupTime) []float64 {
+	runTime := map[uint64]uint64{}
+	entries := map[uint64]int{}
+	for _, ct := range processes {
+		runTime[ct.TGID] += ct.ProcessRunTime
+		entries[ct.TGID]++
+	}
+	shares := make([]float64, len(processes))
+	for i, ct := range processes {
+		switch {
+		case entries[ct.TGID] == 1:
+			shares[i] = 1
+		case runTime[ct.TGID] > 0:
+			shares[i] = float64(ct.ProcessRunTime) / float64(runTime[ct.TGID])
+		default:
+			shares[i] = 1 / float64(entries[ct.TGID])
+		}
+	}
+	return shares
+}
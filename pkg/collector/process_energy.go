@@ -0,0 +1,173 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+)
+
+// Many workloads of the edge devices are plain systemd services in the system_processes bucket, the
+// process accounting attributes the core, DRAM and GPU energy to each process as well. The threads of a
+// process are summed under its pid (the tgid), a reused pid (different start time) starts a new process.
+const processIdleTimeout = 5 * time.Minute
+
+type ProcessEnergy struct {
+	PID       uint64
+	StartTime uint64
+	CGroupID  uint64
+	Command   string
+	// ContainerName and Namespace are the container (pod) the process is accounted to
+	ContainerName string
+	Namespace     string
+
+	CurrCPUTime     float64
+	CurrCPUCycles   uint64
+	CurrCPUInstr    uint64
+	CurrCacheMisses uint64
+
+	CurrEnergyInCore uint64
+	CurrEnergyInDram uint64
+	CurrEnergyInGPU  uint64
+	AggEnergyInCore  uint64
+	AggEnergyInDram  uint64
+	AggEnergyInGPU   uint64
+
+	lastSeen time.Time
+}
+
+var (
+	processAccounting = false
+	processEnergy     = map[uint64]*ProcessEnergy{}
+
+	processEnergyDesc = prometheus.NewDesc(
+		"process_energy_joule_total",
+		"Energy consumed by the process per component",
+		[]string{
+			"pid",
+			"command",
+			"cgroup_id",
+			"container_name",
+			"container_namespace",
+			"component",
+		},
+		nil,
+	)
+)
+
+// SetProcessAccounting enables the per process energy accounting and metrics
+func SetProcessAccounting(enabled bool) {
+	processAccounting = enabled
+}
+
+// resetProcessSample clears the counters of the last sample, the collector lock must be held
+func resetProcessSample() {
+	for _, p := range processEnergy {
+		p.CurrCPUTime = 0
+		p.CurrCPUCycles = 0
+		p.CurrCPUInstr = 0
+		p.CurrCacheMisses = 0
+		p.CurrEnergyInCore = 0
+		p.CurrEnergyInDram = 0
+		p.CurrEnergyInGPU = 0
+	}
+}
+
+// accountProcess adds an eBPF process entry of the sample, the collector lock must be held
+func accountProcess(ct *CgroupTime, command, containerName, namespace string, cpuTime, gpuEnergy float64, now time.Time) {
+	if !processAccounting {
+		return
+	}
+	p, ok := processEnergy[ct.TGID]
+	if !ok || p.StartTime != ct.StartTime {
+		p = &ProcessEnergy{PID: ct.TGID, StartTime: ct.StartTime, Command: command}
+		processEnergy[ct.TGID] = p
+	}
+	// the threads may be named, the process is named after its main thread
+	if ct.PID == ct.TGID {
+		p.Command = command
+	}
+	// a process moved to another cgroup is accounted to its last owner
+	p.CGroupID = ct.CGroupPID
+	p.ContainerName = containerName
+	p.Namespace = namespace
+	p.CurrCPUTime += cpuTime
+	p.CurrCPUCycles += ct.CPUCycles
+	p.CurrCPUInstr += ct.CPUInstr
+	p.CurrCacheMisses += ct.CacheMisses
+	p.CurrEnergyInGPU += uint64(gpuEnergy)
+	p.AggEnergyInGPU += uint64(gpuEnergy)
+	p.lastSeen = now
+}
+
+// attributeProcessEnergy splits the core and DRAM energy of the sample among the processes with the
+// container model, the resident memory share of the DRAM energy is only known per pod. The collector
+// lock must be held.
+func attributeProcessEnergy(coreDelta, dramDelta, aggCPUTime float64, aggCPUCycles, aggCPUInstr, aggCacheMisses uint64, now time.Time) {
+	if !processAccounting {
+		return
+	}
+	for pid, p := range processEnergy {
+		if now.Sub(p.lastSeen) > processIdleTimeout {
+			delete(processEnergy, pid)
+			continue
+		}
+		core := float64(0)
+		if p.CurrCPUTime > 0 && aggCPUTime > 0 {
+			core += p.CurrCPUTime / aggCPUTime * coreDelta * model.RunTimeCoeff.CPUTime
+		}
+		if p.CurrCPUCycles > 0 && aggCPUCycles > 0 {
+			core += float64(p.CurrCPUCycles) / float64(aggCPUCycles) * coreDelta * model.RunTimeCoeff.CPUCycle
+		}
+		if p.CurrCPUInstr > 0 && aggCPUInstr > 0 {
+			core += float64(p.CurrCPUInstr) / float64(aggCPUInstr) * coreDelta * model.RunTimeCoeff.CPUInstr
+		}
+		p.CurrEnergyInCore = uint64(core)
+		p.AggEnergyInCore += p.CurrEnergyInCore
+		if p.CurrCacheMisses > 0 && aggCacheMisses > 0 {
+			p.CurrEnergyInDram = uint64(float64(p.CurrCacheMisses) / float64(aggCacheMisses) * dramDelta * model.RunTimeCoeff.CacheMisses)
+		}
+		p.AggEnergyInDram += p.CurrEnergyInDram
+	}
+}
+
+// collectProcessEnergy sends the process metrics, the collector lock must be held
+func collectProcessEnergy(ch chan<- prometheus.Metric) {
+	if !processAccounting {
+		return
+	}
+	for _, p := range processEnergy {
+		pid := strconv.FormatUint(p.PID, 10)
+		cgroupID := strconv.FormatUint(p.CGroupID, 10)
+		for component, energy := range map[string]uint64{
+			"core": p.AggEnergyInCore,
+			"dram": p.AggEnergyInDram,
+			"gpu":  p.AggEnergyInGPU,
+		} {
+			ch <- monotonicCounter(
+				processEnergyDesc,
+				"process_energy_joule_total",
+				float64(energy)/1000.0, /*miliJoule to Joule*/
+				pid, p.Command, cgroupID, p.ContainerName, p.Namespace, component,
+			)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl"
+)
+
+// The psys RAPL domain measures the whole platform on the client CPUs (NUCs, laptops). Without a
+// power meter it is the node energy, and it validates the RAPL decomposition: the platform
+// residual, psys minus the package and DRAM, is the energy the model can only attribute as other.
+// A negative residual means the domains are inconsistent.
+var (
+	psysSupported     = rapl.IsPlatformSupported()
+	lastPsysEnergy    uint64
+	lastPackageEnergy uint64
+	// psysEnergy and psysPackageEnergy are the energy (mJ) of the platform and of the package in the last read
+	psysEnergy        float64
+	psysPackageEnergy float64
+)
+
+// psysSource reads the node energy from the psys domain
+type psysSource struct{}
+
+func init() {
+	if !psysSupported {
+		return
+	}
+	lastPsysEnergy, _ = rapl.GetEnergyFromPlatform()
+	lastPackageEnergy, _ = rapl.GetEnergyFromPackage()
+	power.Register(psysSource{}, power.PriorityPlatform)
+}
+
+func (psysSource) Name() string {
+	return "psys"
+}
+
+func (psysSource) Init() error {
+	return nil
+}
+
+func (psysSource) IsAvailable() bool {
+	return true
+}
+
+func (psysSource) GetEnergy(zone string) (float64, error) {
+	if zone != power.ZoneNode {
+		return 0, power.ErrNoZone
+	}
+	psysEnergy, psysPackageEnergy = readPsysEnergy()
+	return psysEnergy, nil
+}
+
+func (psysSource) Shutdown() {}
+
+// counterDelta returns the increase of a RAPL counter, they are accumulated across their wraps and only
+// decrease when re-initialized
+func counterDelta(curr, last uint64) float64 {
+	if curr >= last {
+		return float64(curr - last)
+	}
+	return 0
+}
+
+// readPsysEnergy returns the energy (mJ) of the platform and of the package in the last interval
+func readPsysEnergy() (float64, float64) {
+	if !psysSupported {
+		return 0, 0
+	}
+	psys, err := rapl.GetEnergyFromPlatform()
+	if err != nil {
+		log.Printf("failed to get platform power: %v\n", err)
+		return 0, 0
+	}
+	pkg, err := rapl.GetEnergyFromPackage()
+	if err != nil {
+		log.Printf("failed to get package power: %v\n", err)
+		return 0, 0
+	}
+	psysDelta := counterDelta(psys, lastPsysEnergy)
+	packageDelta := counterDelta(pkg, lastPackageEnergy)
+	lastPsysEnergy, lastPackageEnergy = psys, pkg
+	return psysDelta, packageDelta
+}
+
+// platformResidual returns the platform energy not measured by the package and DRAM domains
+func platformResidual(psysDelta, packageDelta, dramDelta float64) float64 {
+	if !rapl.HasDramDomain() {
+		// the DRAM energy is derived from the package, the DRAM is part of the residual
+		dramDelta = 0
+	}
+	return psysDelta - packageDelta - dramDelta
+}
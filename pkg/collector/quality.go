@@ -0,0 +1,52 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+// The quality of a sample tells the consumers (fleet analytics, billing) how its energy was obtained, so
+// they can weight or discard the samples of degraded devices. Each source of the sample is measured,
+// estimated (by a model), stale (its reading failed, the last known or a fallback value is used) or
+// missing, the score is the average of the scores of the sources, from 0 to 1.
+const (
+	SourceMeasured  = "measured"
+	SourceEstimated = "estimated"
+	SourceStale     = "stale"
+	SourceMissing   = "missing"
+)
+
+var sourceScores = map[string]float64{
+	SourceMeasured:  1,
+	SourceEstimated: 0.5,
+	SourceStale:     0.25,
+	SourceMissing:   0,
+}
+
+// Quality is the status of each source of a sample and its score
+type Quality struct {
+	Score   float64           `json:"score"`
+	Sources map[string]string `json:"sources"`
+}
+
+func newQuality(sources map[string]string) *Quality {
+	q := &Quality{Sources: sources}
+	for _, status := range sources {
+		q.Score += sourceScores[status]
+	}
+	if len(sources) > 0 {
+		q.Score /= float64(len(sources))
+	}
+	return q
+}
@@ -17,32 +17,39 @@ limitations under the License.
 package collector
 
 import (
-	"bytes"
-	"encoding/binary"
-	"fmt"
-	"log"
+	"context"
 	"os"
 	"runtime"
+	"strings"
 	"sync"
 	"time"
-	"unsafe"
 
+	"k8s.io/klog/v2"
+
+	"github.com/sustainable-computing-io/kepler/pkg/ambient"
 	"github.com/sustainable-computing-io/kepler/pkg/attacher"
 	"github.com/sustainable-computing-io/kepler/pkg/model"
 	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/accelerator"
 	"github.com/sustainable-computing-io/kepler/pkg/power/acpi"
 	"github.com/sustainable-computing-io/kepler/pkg/power/gpu"
 	"github.com/sustainable-computing-io/kepler/pkg/power/rapl"
 	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+	"github.com/sustainable-computing-io/kepler/pkg/wasm"
 )
 
 // #define CPU_VECTOR_SIZE 128
 import "C"
 
-//TODO in sync with bpf program
+// CgroupTime is a thread of the sample, its slices and traffic summed over the sample. PID is the thread
+// id and TGID the pid of its process.
 type CgroupTime struct {
 	CGroupPID      uint64
 	PID            uint64
+	TGID           uint64
+	StartTime      uint64
 	ProcessRunTime uint64
 	CPUCycles      uint64
 	CPUInstr       uint64
@@ -68,6 +75,10 @@ type ContainerEnergy struct {
 	CurrCPUInstr    uint64
 	CurrCacheMisses uint64
 	CurrResidentMem uint64
+	// CurrPackageCPUTime is the CPU time vector summed per package, on multi package nodes
+	CurrPackageCPUTime map[int]float64
+	// CurrWeightedCPUTime is the CPU time vector weighted by the power of the cores, in the per-core mode
+	CurrWeightedCPUTime float64
 
 	CurrEnergyInCore  uint64
 	CurrEnergyInDram  uint64
@@ -77,6 +88,12 @@ type ContainerEnergy struct {
 	AggEnergyInDram   uint64
 	AggEnergyInOther  uint64
 	AggEnergyInGPU    uint64
+	// energy of the device classes registered through the accelerator hooks
+	CurrEnergyInAccelerator map[string]uint64
+	AggEnergyInAccelerator  map[string]uint64
+	// the core energy per package, on multi package nodes
+	CurrEnergyInCorePerPackage map[int]uint64
+	AggEnergyInCorePerPackage  map[int]uint64
 
 	Disks          int
 	CurrBytesRead  uint64
@@ -84,7 +101,42 @@ type ContainerEnergy struct {
 	AggBytesRead   uint64
 	AggBytesWrite  uint64
 
+	CurrBytesTx   uint64
+	CurrBytesRx   uint64
+	CurrPacketsTx uint64
+	CurrPacketsRx uint64
+	AggBytesTx    uint64
+	AggBytesRx    uint64
+	AggPacketsTx  uint64
+	AggPacketsRx  uint64
+	// the network energy of the NIC model, it is part of the other energy
+	CurrEnergyInNetwork uint64
+	AggEnergyInNetwork  uint64
+
 	AvgCPUFreq float64
+	CPUSet     string
+	// SchedPolicy is the real-time scheduling policy of the container threads, if any
+	SchedPolicy string
+	// Fingerprint identifies the workload across the fleet, from its image digests
+	Fingerprint string
+	// Terminated is set on the last sample of a removed container, before it is evicted
+	Terminated bool
+	// Class is the workload class of the container from its activity, see classifyContainer
+	Class string
+
+	lastSeen   time.Time
+	classStats *classStats
+	// cgroupIDs are the cgroups of the processes of the container, the profiled cgroups when it is profiled
+	cgroupIDs map[uint64]bool
+}
+
+func newContainerEnergy(name, namespace string) *ContainerEnergy {
+	return &ContainerEnergy{
+		ContainerName:           name,
+		Namespace:               namespace,
+		CurrEnergyInAccelerator: map[string]uint64{},
+		AggEnergyInAccelerator:  map[string]uint64{},
+	}
 }
 
 type CurrEdgeDeviceEnergy struct {
@@ -98,302 +150,834 @@ type CurrEdgeDeviceEnergy struct {
 	EnergyInDram  float64
 	EnergyInOther float64
 	EnergyInGPU   float64
+	// EnergyInHousekeeping is the share of EnergyInCore spent on the non isolated CPUs
+	EnergyInHousekeeping float64
+	EnergyInAccelerator  map[string]float64
+	// EnergyInNetwork is the share of EnergyInOther estimated by the NIC model
+	EnergyInNetwork float64
+	// EnergyInPlatform is the psys RAPL domain, PlatformResidual its part outside the package and DRAM
+	EnergyInPlatform float64
+	PlatformResidual float64
+	// Breakdown is the node energy per component summing to the node energy, over SampleSeconds
+	Breakdown     map[string]float64
+	SampleSeconds float64
+	// Quality tells how the energy of the sample was obtained
+	Quality *Quality
+	// CalibratedOtherPower is the other power in W learned from the node meter, 0 until calibrated
+	CalibratedOtherPower float64
+	// EnergyInStatic is the static part of EnergyInOther at the idle power, EnergyUnattributed its part left
+	// out of the pods by the idle attribution policy, and the energy of the dropped system processes
+	EnergyInStatic     float64
+	EnergyUnattributed float64
+	// IdlePower is the static other power in W, 0 if unknown
+	IdlePower float64
+	// Sequence numbers the sample in the boot, Time is its end
+	Sequence uint64
+	Time     time.Time
 }
 
+// verbosity (-v) of the sample logs, the energy of each pod is only logged when debugging
 const (
-	samplePeriod = 3000 * time.Millisecond
+	sampleLogLevel = 2
+	podLogLevel    = 4
 )
 
 var (
+	samplePeriod         = 3000 * time.Millisecond
+	samplePeriodChanged  = make(chan struct{}, 1)
 	containerEnergy      = map[string]*ContainerEnergy{}
 	EdgeDeviceEnergy     = map[string]float64{}
 	gpuEnergy            = map[uint32]float64{}
+	gpuCounters          []gpu.DeviceCounters
 	currEdgeDeviceEnergy = &CurrEdgeDeviceEnergy{}
 	cpuFrequency         = map[int32]uint64{}
 	EdgeDeviceName, _    = os.Hostname()
 	cpuArch              = "unknown"
 	acpiPowerMeter       = acpi.NewACPIPowerMeter()
 	numCPUs              = runtime.NumCPU()
+	// kubeletMetricsFailed logs the kubelet metrics failures once, until they succeed again
+	kubeletMetricsFailed = false
 	lock                 sync.Mutex
 )
 
+// HostPowerMeter measures the energy (mJ) of the whole node per sensor since the last call
+type HostPowerMeter interface {
+	GetEnergyFromHost() (map[string]float64, error)
+}
+
+// hostPowerMeter is the node energy source
+var hostPowerMeter HostPowerMeter = acpiPowerMeter
+
+// SetHostPowerMeter replaces the ACPI power meter as the node energy source, e.g. with the BMC
+func SetHostPowerMeter(m HostPowerMeter) {
+	lock.Lock()
+	defer lock.Unlock()
+	hostPowerMeter = m
+}
+
+// hostSource reads the node energy from the host power meter, keeping its energy per sensor
+type hostSource struct{}
+
+func (hostSource) Name() string {
+	return "host"
+}
+
+func (hostSource) Init() error {
+	return nil
+}
+
+func (hostSource) IsAvailable() bool {
+	return true
+}
+
+func (hostSource) GetEnergy(zone string) (float64, error) {
+	if zone != power.ZoneNode {
+		return 0, power.ErrNoZone
+	}
+	lock.Lock()
+	meter := hostPowerMeter
+	lock.Unlock()
+	// the meter is read without the lock, the exporter reads EdgeDeviceEnergy under it
+	sensors, err := meter.GetEnergyFromHost()
+	energy := float64(0)
+	for _, e := range sensors {
+		energy += e
+	}
+	lock.Lock()
+	EdgeDeviceEnergy = sensors
+	lock.Unlock()
+	return energy, err
+}
+
+func (hostSource) Shutdown() {}
+
 func init() {
 	arch, err := source.GetCPUArchitecture()
 	if err == nil {
 		cpuArch = arch
 	}
+	power.Register(hostSource{}, power.PriorityHost)
+}
+
+// SetSamplePeriod changes the period of the samples, the running reader restarts its ticker
+func SetSamplePeriod(period time.Duration) {
+	lock.Lock()
+	changed := period != samplePeriod
+	samplePeriod = period
+	lock.Unlock()
+	if changed {
+		select {
+		case samplePeriodChanged <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// GetSamplePeriod returns the period of the samples
+func GetSamplePeriod() time.Duration {
+	lock.Lock()
+	defer lock.Unlock()
+	return samplePeriod
 }
 
-func (c *Collector) reader() {
+// reader samples until the context is done, then stops the power meter and closes c.done
+func (c *Collector) reader(ctx context.Context) {
+	lock.Lock()
 	ticker := time.NewTicker(samplePeriod)
+	lock.Unlock()
 	go func() {
-		lastEnergyCore, _ := rapl.GetEnergyFromCore()
-		lastEnergyDram, _ := rapl.GetEnergyFromDram()
-		_ = gpu.GetGpuEnergy() // reset power usage counter
+		defer close(c.done)
+		defer ticker.Stop()
+		defer acpiPowerMeter.Stop()
 
 		acpiPowerMeter.Run()
-		for {
-			select {
-			case <-ticker.C:
-				cpuFrequency = acpiPowerMeter.GetCPUCoreFrequency()
-				EdgeDeviceEnergy, _ = acpiPowerMeter.GetEnergyFromHost()
-
-				var aggCPUTime, avgFreq, totalCPUTime float64
-				var aggCPUCycles, aggCPUInstr, aggCacheMisses, aggBytesRead, aggBytesWrite uint64
-				avgFreq = 0
-				totalCPUTime = 0
-				energyCore, err := rapl.GetEnergyFromCore()
-				if err != nil {
-					log.Printf("failed to get core power: %v\n", err)
-					continue
-				}
-				energyDram, err := rapl.GetEnergyFromDram()
-				if err != nil {
-					log.Printf("failed to get dram power: %v\n", err)
-					continue
+		go supervisor.Run(ctx, "events", func() {
+			consumeEvents(ctx, c.modules)
+		})
+		// the ticks and the on-demand sample requests both trigger a sample
+		tick := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				case <-sampleRequested:
 				}
-				if energyCore < lastEnergyCore || energyDram < lastEnergyDram {
-					log.Printf("failed to get latest core or dram energy. Core energy %v should be more than %v; Dram energy %v should be more than %v\n",
-						energyCore, lastEnergyCore, energyDram, lastEnergyDram)
+				select {
+				case <-ctx.Done():
+					return
+				case tick <- struct{}{}:
 				}
-				coreDelta := float64(energyCore - lastEnergyCore)
-				dramDelta := float64(energyDram - lastEnergyDram)
-				if coreDelta == 0 && dramDelta == 0 {
-					log.Printf("power reading not changed, retry\n")
-					continue
+			}
+		}()
+		supervisor.Run(ctx, "collector", func() {
+			// a panic while sampling must not leave the lock held for the restarted reader
+			locked := false
+			sampling := false
+			defer func() {
+				if locked {
+					lock.Unlock()
 				}
-				gpuDelta := float64(0)
-				for _, e := range gpuEnergy {
-					gpuDelta += e
+				if sampling {
+					countSampleGap(GapPanic)
 				}
-				lastEnergyCore = energyCore
-				lastEnergyDram = energyDram
+			}()
+			klog.InfoS("power sources", "sources", strings.Join(power.Detect(), ", "))
+			// the time of the last counters and the cumulative block I/O of the node, for the breakdown
+			lastSample := time.Now()
+			lastIOBytes := uint64(0)
+			// the time of the last tick, the late ticks are counted
+			var lastTick time.Time
+			supervisor.Call("gpu", func() {
+				_ = gpu.GetGpuEnergy() // reset power usage counter
+			})
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case <-samplePeriodChanged:
+					lock.Lock()
+					period := effectiveSamplePeriod()
+					lock.Unlock()
+					ticker.Reset(period)
+					lastTick = time.Time{}
+					klog.InfoS("sample period set", "period", period)
+				case <-tick:
+					sampling = true
+					tickTime := time.Now()
+					lock.Lock()
+					period := effectiveSamplePeriod()
+					lock.Unlock()
+					if isLate(tickTime, lastTick, period) {
+						countSampleGap(GapLate)
+					}
+					lastTick = tickTime
+					cpuFrequency = acpiPowerMeter.GetCPUCoreFrequency()
+					supervisor.Call("wasm", func() {
+						if err := wasm.UpdateModules(); err != nil {
+							klog.ErrorS(err, "failed to update wasm modules")
+						}
+					})
+					supervisor.Call("ambient", ambient.Update)
+					// the node energy of the first source by priority: the host meter, psys, the power sensors,
+					// the discharge of the battery, then the estimate of the SoC rails
+					nodeEnergyTotal, nodeMeter, hostErr := power.GetEnergy(power.ZoneNode)
+					updateNodeStates(time.Now())
+
+					var aggCPUTime, avgFreq, totalCPUTime float64
+					var aggCPUCycles, aggCPUInstr, aggCacheMisses, aggBytesRead, aggBytesWrite uint64
+					avgFreq = 0
+					totalCPUTime = 0
+					// without RAPL the power sensors of the board measure the CPU and DRAM rails
+					coreDelta, coreMeter, coreErr := power.GetEnergy(power.ZoneCore)
+					if coreErr != nil {
+						klog.ErrorS(coreErr, "failed to get core power")
+					}
+					dramDelta, _, dramErr := power.GetEnergy(power.ZoneDram)
+					if dramErr != nil {
+						klog.ErrorS(dramErr, "failed to get dram power")
+					}
+					cpuSource := SourceMeasured
+					switch coreMeter {
+					case "":
+						cpuSource = SourceMissing
+					case rapl.EstimateSourceName:
+						cpuSource = SourceEstimated
+					}
+					var packageCoreDelta map[int]float64
+					if coreMeter == rapl.SourceName {
+						packageCoreDelta = readPackageCoreEnergy(coreDelta)
+					}
+					if coreDelta == 0 && dramDelta == 0 {
+						if coreErr != nil || dramErr != nil {
+							countSampleGap(GapSensorError)
+						} else {
+							countSampleGap(GapPowerUnchanged)
+						}
+						sampling = false
+						klog.V(sampleLogLevel).InfoS("power reading not changed, retry")
+						continue
+					}
+					gpuDelta := float64(0)
+					for _, e := range gpuEnergy {
+						gpuDelta += e
+					}
+					var acceleratorPidEnergy map[string]map[uint32]float64
+					var acceleratorPodEnergy map[string]map[string]float64
+					var acceleratorEnergy map[string]float64
+					supervisor.Call("accelerator", func() {
+						acceleratorPidEnergy, acceleratorPodEnergy, acceleratorEnergy = accelerator.GetEnergyPerPid()
+					})
+					acceleratorDelta := float64(0)
+					for _, e := range acceleratorEnergy {
+						acceleratorDelta += e
+					}
+					intervalStart := lastSample
+					lastSample = time.Now()
+					sampleSeconds := lastSample.Sub(intervalStart).Seconds()
 
-				// calculate the total energy consumed in node from all sensors
-				var nodeEnergyTotal float64 = 0
-				for _, energy := range EdgeDeviceEnergy {
-					nodeEnergyTotal += energy
-				}
-				// calculate the other energy consumed besides CPU/GPU and memory
-				otherDelta := float64(0)
-				if nodeEnergyTotal > 0 {
-					otherDelta = nodeEnergyTotal - coreDelta - dramDelta - gpuDelta
-				}
+					nodeSource := SourceMeasured
+					if nodeMeter == railSourceName {
+						nodeSource = SourceEstimated
+					}
+					if nodeEnergyTotal == 0 {
+						// the node energy is the sum of the components, with the calibrated other energy if any
+						nodeSource = SourceEstimated
+						if hostErr != nil {
+							nodeSource = SourceStale
+						}
+						if other, ok := calibratedOther(sampleSeconds); ok {
+							nodeEnergyTotal = coreDelta + dramDelta + gpuDelta + acceleratorDelta + other
+						}
+					}
+					// calculate the other energy consumed besides CPU/GPU and memory
+					otherDelta := float64(0)
+					if nodeEnergyTotal > 0 {
+						otherDelta = nodeEnergyTotal - coreDelta - dramDelta - gpuDelta - acceleratorDelta
+					}
+					// the measured components may exceed an inaccurate node meter, the breakdown scales them down
+					if otherDelta < 0 {
+						otherDelta = 0
+					}
+					if nodeSource == SourceMeasured {
+						calibrateOther(otherDelta, sampleSeconds)
+						measureIdlePower(otherDelta, sampleSeconds)
+					}
 
-				lock.Lock()
-
-				var ct CgroupTime
-				aggCPUTime = 0
-				aggCPUCycles = 0
-				aggCacheMisses = 0
-				aggCPUCycles = 0
-				aggCPUInstr = 0
-				aggBytesRead = 0
-				aggBytesWrite = 0
-				cgroupIO := make(map[uint64]bool)
-				gpuEnergy, _ = gpu.GetCurrGpuEnergyPerPid()
-				for _, v := range containerEnergy {
-					v.CurrCPUCycles = 0
-					v.CurrCPUTime = 0
-
-					v.CurrCacheMisses = 0
-					v.CurrCPUInstr = 0
-					v.CurrBytesRead = 0
-					v.CurrBytesWrite = 0
-				}
-				for it := c.modules.Table.Iter(); it.Next(); {
-					data := it.Leaf()
-					err := binary.Read(bytes.NewBuffer(data), binary.LittleEndian, &ct)
-					if err != nil {
-						log.Printf("failed to decode received data: %v", err)
-						continue
+					lock.Lock()
+					locked = true
+
+					aggCPUTime = 0
+					aggCPUCycles = 0
+					aggCacheMisses = 0
+					aggCPUCycles = 0
+					aggCPUInstr = 0
+					aggBytesRead = 0
+					aggBytesWrite = 0
+					cgroupIO := make(map[uint64]bool)
+					cgroupCPUSet := make(map[uint64]map[int32]bool)
+					housekeepingCPUTime, vectorCPUTime := float64(0), float64(0)
+					gpuSource := SourceMeasured
+					if !supervisor.Call("gpu", func() {
+						gpuEnergy, _ = gpu.GetCurrGpuEnergyPerPid(model.RunTimeCoeff.GPUCompute, model.RunTimeCoeff.GPUDataMovement)
+						gpuCounters = gpu.GetDeviceCounters()
+					}) {
+						gpuEnergy = map[uint32]float64{}
+						gpuSource = SourceStale
 					}
-					comm := (*C.char)(unsafe.Pointer(&ct.Command))
-					// fmt.Printf("pid %v cgroup %v cmd %v\n", ct.PID, ct.CGroupPID, C.GoString(comm))
-					containerName, err := pod_lister.GetPodNameFromcGgroupID(ct.CGroupPID)
-					if err != nil {
-						log.Printf("failed to resolve pod for cGroup ID %v: %v", ct.CGroupPID, err)
-						continue
+					resetProcessSample()
+					resetDroppedProcesses()
+					for _, v := range containerEnergy {
+						v.CurrCPUCycles = 0
+						v.CurrCPUTime = 0
+
+						v.CurrCacheMisses = 0
+						v.CurrCPUInstr = 0
+						v.CurrBytesRead = 0
+						v.CurrBytesWrite = 0
+						v.CurrBytesTx = 0
+						v.CurrBytesRx = 0
+						v.CurrPacketsTx = 0
+						v.CurrPacketsRx = 0
+						v.CurrEnergyInGPU = 0
+						v.SchedPolicy = ""
+						v.CurrEnergyInAccelerator = map[string]uint64{}
+						v.CurrPackageCPUTime = nil
+						v.CurrWeightedCPUTime = 0
 					}
-					if _, ok := containerEnergy[containerName]; !ok {
-						containerEnergy[containerName] = &ContainerEnergy{}
-						containerEnergy[containerName].ContainerName = containerName
-						containerNamespace, err := pod_lister.GetPodNameSpaceFromcGgroupID(ct.CGroupPID)
+					maxFreq := maxCPUFrequency(cpuFrequency)
+					weightedAggCPUTime := float64(0)
+					sampleStart := time.Now()
+					events := takeEvents()
+					traffic := events.traffic
+					updateCounterMultiplexing(c.modules)
+					updateMapOccupancy(c.modules, events)
+					processes := addTrafficProcesses(events.processes, traffic)
+					pidShares := getPidShares(processes)
+					netns := newNetnsSample()
+					unresolved := false
+					for i, ct := range processes {
+						command := commandString(ct.Command)
+						// fmt.Printf("pid %v cgroup %v cmd %v\n", ct.PID, ct.CGroupPID, command)
+						pod_lister.CheckCgroupID(ct.CGroupPID, ct.PID)
+						containerName, err := pod_lister.GetPodNameFromcGgroupID(ct.CGroupPID)
 						if err != nil {
-							log.Printf("failed to find namespace for cGroup ID %v: %v", ct.CGroupPID, err)
-							containerNamespace = "unknown"
+							klog.ErrorS(err, "failed to resolve pod", "cgroupID", ct.CGroupPID, "pid", ct.PID, "sample", lastSample)
+							unresolved = true
+							continue
+						}
+						sandboxNamespace := ""
+						if containerName == pod_lister.GetSystemProcessName() {
+							// kata VMM or gVisor sentry running outside of the pod cgroup
+							if info, ok := pod_lister.GetSandboxPodFromProcess(ct.TGID, ct.StartTime, command); ok {
+								containerName = info.PodName
+								sandboxNamespace = info.Namespace
+							} else if isTSNStackProcess(command) {
+								containerName = tsnStackName
+							} else if entry, ok := systemProcessEntry(ct.CGroupPID); ok {
+								containerName = entry
+							} else {
+								// the dropped processes stay in the denominators of the ratio model
+								cpuTime := float64(ct.ProcessRunTime)
+								if attacher.EnableCPUFreq {
+									_, cpuTime = getAVGCPUFreqAndTotalCPUTime(cpuFrequency, ct.CPUTime, nil)
+									if cpuIsolation {
+										hk, t := getHousekeepingCPUTime(ct.CPUTime[:])
+										housekeepingCPUTime += hk
+										vectorCPUTime += t
+									}
+									accountPackageCPUTime(droppedProcesses, ct.CPUTime[:], cpuFrequency, maxFreq)
+									if coreAttribution == CoreAttributionPerCore {
+										weighted := weightedCPUTime(ct.CPUTime[:], cpuFrequency, maxFreq)
+										droppedProcesses.CurrWeightedCPUTime += weighted
+										weightedAggCPUTime += weighted
+									}
+								}
+								droppedProcesses.CurrCPUTime += cpuTime / 1000
+								droppedProcesses.CurrCPUCycles += ct.CPUCycles
+								droppedProcesses.CurrCPUInstr += ct.CPUInstr
+								droppedProcesses.CurrCacheMisses += ct.CacheMisses
+								aggCPUTime += cpuTime / 1000
+								aggCPUCycles += ct.CPUCycles
+								aggCPUInstr += ct.CPUInstr
+								aggCacheMisses += ct.CacheMisses
+								continue
+							}
+						}
+						// split WASM runtimes among the modules they host
+						if module, ok := wasm.GetModuleName(ct.PID, command); ok {
+							containerName = wasm.GetModuleEntryName(containerName, module)
 						}
-						containerEnergy[containerName].Namespace = containerNamespace
+						if _, ok := containerEnergy[containerName]; !ok {
+							containerNamespace := sandboxNamespace
+							if len(containerNamespace) == 0 {
+								containerNamespace, err = pod_lister.GetPodNameSpaceFromcGgroupID(ct.CGroupPID)
+								if err != nil {
+									klog.ErrorS(err, "failed to find namespace", "cgroupID", ct.CGroupPID, "container", containerName, "sample", lastSample)
+									containerNamespace = "unknown"
+								}
+							}
+							containerEnergy[containerName] = newContainerEnergy(containerName, containerNamespace)
+							containerEnergy[containerName].PID = ct.PID
+							containerEnergy[containerName].Command = command
+						}
+						// the cgroup of the last process tells whether the container is still there
 						containerEnergy[containerName].CGroupPID = ct.CGroupPID
-						containerEnergy[containerName].PID = ct.PID
-						containerEnergy[containerName].Command = C.GoString(comm)
-					}
-					if attacher.EnableCPUFreq {
-						avgFreq, totalCPUTime = getAVGCPUFreqAndTotalCPUTime(cpuFrequency, ct.CPUTime)
-					} else {
-						totalCPUTime = float64(ct.ProcessRunTime)
-					}
-					// to prevent overflow of the counts we change the unit to have smaller numbers
-					totalCPUTime = totalCPUTime / 1000
-					containerEnergy[containerName].CurrCPUTime += totalCPUTime
-					containerEnergy[containerName].AggCPUTime += totalCPUTime
-					aggCPUTime += totalCPUTime
-					val := ct.CPUCycles
-					containerEnergy[containerName].CurrCPUCycles += val
-					containerEnergy[containerName].AggCPUCycles += val
-					aggCPUCycles += val
-					val = ct.CPUInstr
-					containerEnergy[containerName].CurrCPUInstr += val
-					containerEnergy[containerName].AggCPUInstr += val
-					aggCPUInstr += val
-					val = ct.CacheMisses
-					containerEnergy[containerName].CurrCacheMisses += val
-					containerEnergy[containerName].AggCacheMisses += val
-					aggCacheMisses += val
-
-					containerEnergy[containerName].AvgCPUFreq = avgFreq
-					if e, ok := gpuEnergy[uint32(ct.PID)]; ok {
-						// fmt.Printf("gpu energy pod %v comm %v pid %v: %v\n", containerName, C.GoString(comm), ct.PID, e)
-						containerEnergy[containerName].CurrEnergyInGPU += uint64(e)
-						containerEnergy[containerName].AggEnergyInGPU += containerEnergy[containerName].CurrEnergyInGPU
-					}
-					rBytes, wBytes, disks, err := pod_lister.ReadCgroupIOStat(ct.CGroupPID)
-					// fmt.Printf("read %d write %d. Agg read %d write %d, err %v\n", rBytes, wBytes, aggBytesRead, aggBytesWrite, err)
+						containerEnergy[containerName].lastSeen = sampleStart
+						if v := containerEnergy[containerName]; v.cgroupIDs == nil {
+							v.cgroupIDs = map[uint64]bool{}
+						}
+						containerEnergy[containerName].cgroupIDs[ct.CGroupPID] = true
+						if v := containerEnergy[containerName]; len(v.Fingerprint) == 0 {
+							v.Fingerprint = getFingerprint(v.Namespace, containerName)
+						}
+						cpuSet, ok := cgroupCPUSet[ct.CGroupPID]
+						if !ok {
+							if list, cpus, err := pod_lister.ReadCgroupCPUSet(ct.CGroupPID); err == nil {
+								cpuSet = make(map[int32]bool, len(cpus))
+								for _, cpu := range cpus {
+									cpuSet[cpu] = true
+								}
+								containerEnergy[containerName].CPUSet = list
+							}
+							cgroupCPUSet[ct.CGroupPID] = cpuSet
+						}
+						if attacher.EnableCPUFreq {
+							avgFreq, totalCPUTime = getAVGCPUFreqAndTotalCPUTime(cpuFrequency, ct.CPUTime, cpuSet)
+							if cpuIsolation {
+								hk, t := getHousekeepingCPUTime(ct.CPUTime[:])
+								housekeepingCPUTime += hk
+								vectorCPUTime += t
+							}
+							accountPackageCPUTime(containerEnergy[containerName], ct.CPUTime[:], cpuFrequency, maxFreq)
+							if coreAttribution == CoreAttributionPerCore {
+								weighted := weightedCPUTime(ct.CPUTime[:], cpuFrequency, maxFreq)
+								containerEnergy[containerName].CurrWeightedCPUTime += weighted
+								weightedAggCPUTime += weighted
+							}
+						} else {
+							totalCPUTime = float64(ct.ProcessRunTime)
+						}
+						// to prevent overflow of the counts we change the unit to have smaller numbers
+						totalCPUTime = totalCPUTime / 1000
+						containerEnergy[containerName].CurrCPUTime += totalCPUTime
+						containerEnergy[containerName].AggCPUTime += totalCPUTime
+						aggCPUTime += totalCPUTime
+						val := ct.CPUCycles
+						containerEnergy[containerName].CurrCPUCycles += val
+						containerEnergy[containerName].AggCPUCycles += val
+						aggCPUCycles += val
+						val = ct.CPUInstr
+						containerEnergy[containerName].CurrCPUInstr += val
+						containerEnergy[containerName].AggCPUInstr += val
+						aggCPUInstr += val
+						val = ct.CacheMisses
+						containerEnergy[containerName].CurrCacheMisses += val
+						containerEnergy[containerName].AggCacheMisses += val
+						aggCacheMisses += val
+						if t, ok := traffic[processKey{ct.CGroupPID, ct.PID, ct.StartTime}]; ok {
+							accountTraffic(containerEnergy[containerName], &t)
+						}
+
+						containerEnergy[containerName].AvgCPUFreq = avgFreq
+						if policy, err := getRealTimePolicy(ct.PID); err == nil && len(policy) > 0 {
+							containerEnergy[containerName].SchedPolicy = policy
+						}
+						processGPUEnergy := float64(0)
+						if e, ok := gpuEnergy[uint32(ct.TGID)]; ok {
+							processGPUEnergy = e * pidShares[i]
+							containerEnergy[containerName].CurrEnergyInGPU += uint64(processGPUEnergy)
+							containerEnergy[containerName].AggEnergyInGPU += uint64(processGPUEnergy)
+						}
+						accountProcess(&ct, command, containerName, containerEnergy[containerName].Namespace, totalCPUTime, processGPUEnergy, sampleStart)
+						for class, pidEnergy := range acceleratorPidEnergy {
+							if e, ok := pidEnergy[uint32(ct.TGID)]; ok {
+								e *= pidShares[i]
+								containerEnergy[containerName].CurrEnergyInAccelerator[class] += uint64(e)
+								containerEnergy[containerName].AggEnergyInAccelerator[class] += uint64(e)
+							}
+						}
+						rBytes, wBytes, disks, err := pod_lister.ReadCgroupIOStat(ct.CGroupPID)
+						// fmt.Printf("read %d write %d. Agg read %d write %d, err %v\n", rBytes, wBytes, aggBytesRead, aggBytesWrite, err)
+						if err == nil {
+							// if this is the first time the cgroup's I/O is accounted, add it to the pod
+							if _, ok := cgroupIO[ct.CGroupPID]; !ok {
+								cgroupIO[ct.CGroupPID] = true
+								if disks > containerEnergy[containerName].Disks {
+									containerEnergy[containerName].Disks = disks
+								}
+								// save the current I/O in CurrByteRead and adjust it later
+								containerEnergy[containerName].CurrBytesRead += rBytes
+								aggBytesRead += rBytes
+								containerEnergy[containerName].CurrBytesWrite += wBytes
+								aggBytesWrite += wBytes
+							}
+						}
+						if netnsAccounted() {
+							netns.add(ct.CGroupPID, ct.PID, containerName)
+						}
+					}
+					if unresolved {
+						// countSampleGap takes the lock held by the sample
+						sampleGaps[GapUnresolved]++
+					}
+					if netnsAccounted() {
+						accountNetnsTraffic(netns)
+					}
+					// the device classes attributing their energy to pods instead of processes (e.g. DPU offloaded flows)
+					for class, podEnergy := range acceleratorPodEnergy {
+						for _, v := range containerEnergy {
+							if e, ok := podEnergy[v.Namespace+"/"+v.ContainerName]; ok {
+								v.CurrEnergyInAccelerator[class] += uint64(e)
+								v.AggEnergyInAccelerator[class] += uint64(e)
+							}
+						}
+					}
+					ioDelta := uint64(0)
+					totalReadBytes, totalWriteBytes, disks, err := pod_lister.ReadAllCgroupIOStat()
 					if err == nil {
-						// if this is the first time the cgroup's I/O is accounted, add it to the pod
-						if _, ok := cgroupIO[ct.CGroupPID]; !ok {
-							cgroupIO[ct.CGroupPID] = true
-							if disks > containerEnergy[containerName].Disks {
-								containerEnergy[containerName].Disks = disks
+						if lastIOBytes > 0 && totalReadBytes+totalWriteBytes >= lastIOBytes {
+							ioDelta = totalReadBytes + totalWriteBytes - lastIOBytes
+						}
+						lastIOBytes = totalReadBytes + totalWriteBytes
+						if totalReadBytes > aggBytesRead && totalWriteBytes > aggBytesWrite {
+							rBytes := totalReadBytes - aggBytesRead
+							wBytes := totalWriteBytes - aggBytesWrite
+							// the I/O of the host beyond the pods, left unattributed if the system processes are dropped
+							if v := catchAllEntry(); v != nil {
+								v.Disks = disks
+								v.CurrBytesRead = rBytes
+								v.CurrBytesWrite = wBytes
 							}
-							// save the current I/O in CurrByteRead and adjust it later
-							containerEnergy[containerName].CurrBytesRead += rBytes
-							aggBytesRead += rBytes
-							containerEnergy[containerName].CurrBytesWrite += wBytes
-							aggBytesWrite += wBytes
+						} else {
+							klog.InfoS("node disk I/O below the sum of the pods", "totalRead", totalReadBytes, "totalWrite", totalWriteBytes, "podsRead", aggBytesRead, "podsWrite", aggBytesWrite, "sample", lastSample)
 						}
 					}
-				}
-				// reset all counters in the eBPF table
-				c.modules.Table.DeleteAll()
-				totalReadBytes, totalWriteBytes, disks, err := pod_lister.ReadAllCgroupIOStat()
-				if err == nil {
-					if totalReadBytes > aggBytesRead && totalWriteBytes > aggBytesWrite {
-						rBytes := totalReadBytes - aggBytesRead
-						wBytes := totalWriteBytes - aggBytesWrite
-						podName := pod_lister.GetSystemProcessName()
-						containerEnergy[podName].Disks = disks
-						containerEnergy[podName].CurrBytesRead = rBytes
-						containerEnergy[podName].CurrBytesWrite = wBytes
-					} else {
-						fmt.Printf("total read %d write %d should be greater than agg read %d agg write %d\n", totalReadBytes, totalWriteBytes, aggBytesRead, aggBytesWrite)
+
+					housekeepingDelta := float64(0)
+					if vectorCPUTime > 0 {
+						housekeepingDelta = coreDelta * housekeepingCPUTime / vectorCPUTime
 					}
-				}
 
-				//evenly attribute other energy among all pods
-				perProcessOtherMJ := float64(otherDelta / float64(len(containerEnergy)))
+					// the network energy is taken out of the other energy, the static part of the rest is attributed
+					// by the idle attribution policy and its dynamic part by the CPU time
+					networkDelta, networkScale := getNetworkEnergy(otherDelta, nodeEnergyTotal > 0)
+					if nodeEnergyTotal == 0 {
+						otherDelta = networkDelta
+					}
+					staticDelta, dynamicOtherDelta := splitOther(otherDelta-networkDelta, sampleSeconds)
+					unattributedDelta := unattributedEnergy(staticDelta)
+					accountOther(staticDelta, dynamicOtherDelta, unattributedDelta)
 
-				_, podMem, _, EdgeDeviceMem, err := pod_lister.GetPodMetrics()
-				if err != nil {
-					fmt.Printf("failed to get kubelet metrics: %v", err)
-				}
+					podsSource := SourceMeasured
+					_, podMem, _, EdgeDeviceMem, err := pod_lister.GetPodMetrics()
+					if err != nil {
+						// the memory of the pods is read from their cgroups, if the node memory is known
+						if !kubeletMetricsFailed {
+							klog.ErrorS(err, "failed to get kubelet metrics, reading the memory from the cgroups")
+						}
+						if EdgeDeviceMem == 0 {
+							podsSource = SourceStale
+						}
+					}
+					kubeletMetricsFailed = err != nil
+					if podMem == nil {
+						podMem = map[string]float64{}
+					}
+					podsMem := float64(0)
+					activeContainers := 0
+					for containerName, v := range containerEnergy {
+						k := v.Namespace + "/" + containerName
+						// the pods the kubelet has no metrics for, e.g. just started or without kubelet, use the memory
+						// of their cgroup
+						if _, ok := podMem[k]; !ok && EdgeDeviceMem > 0 {
+							if mem, err := pod_lister.ReadPodMemory(v.CGroupPID); err == nil {
+								podMem[k] = float64(mem)
+							} else if mem, ok := systemEntryMemory(v.CGroupPID, containerName); ok {
+								podMem[k] = float64(mem)
+							}
+						}
+						// the retained containers, not seen in this sample, take no share of the other energy
+						if v.lastSeen.Equal(sampleStart) {
+							podsMem += podMem[k]
+							activeContainers++
+						}
+					}
 
-				log.Printf("energy count: core %.2f dram: %.2f time %.6f cycles %d instructions %d misses %d EdgeDevice memory %f\n",
-					coreDelta, dramDelta, aggCPUTime, aggCPUCycles, aggCPUInstr, aggCacheMisses, EdgeDeviceMem)
-				currEdgeDeviceEnergy = &CurrEdgeDeviceEnergy{
-					CPUTime:       aggCPUTime,
-					CPUCycles:     aggCPUCycles,
-					CPUInstr:      aggCPUInstr,
-					CacheMisses:   aggCacheMisses,
-					EdgeDeviceMem: EdgeDeviceMem,
-					EnergyInCore:  coreDelta,
-					EnergyInDram:  dramDelta,
-					EnergyInOther: otherDelta,
-					EnergyInGPU:   gpuDelta,
-				}
-				for containerName, v := range containerEnergy {
-					cpuTimeRatio := float64(0.0)
-					cpuCycleRatio := float64(0.0)
-					cpuInstrRatio := float64(0.0)
-					dyMemRatio := float64(0.0)
-					bgMemRatio := float64(0.0)
-
-					if v.CurrCPUTime > 0 {
-						cpuTimeRatio = float64(float64(v.CurrCPUTime)/aggCPUTime) * coreDelta * model.RunTimeCoeff.CPUTime
-					}
-					if v.CurrCPUCycles > 0 {
-						cpuCycleRatio = float64(v.CurrCPUCycles) / float64(aggCPUCycles) * coreDelta * model.RunTimeCoeff.CPUCycle
-					}
-					if v.CurrCPUInstr > 0 {
-						cpuInstrRatio = float64(v.CurrCPUInstr) / float64(aggCPUInstr) * coreDelta * model.RunTimeCoeff.CPUInstr
-					}
-
-					v.CurrEnergyInCore = uint64(cpuTimeRatio + cpuCycleRatio + cpuInstrRatio)
-					v.AggEnergyInCore += v.CurrEnergyInCore
-
-					if v.CurrCacheMisses > 0 {
-						dyMemRatio = float64(v.CurrCacheMisses) / float64(aggCacheMisses) * dramDelta * model.RunTimeCoeff.CacheMisses
-					}
-					k := v.Namespace + "/" + containerName
-					if mem, ok := podMem[k]; ok {
-						v.CurrResidentMem = uint64(mem)
-						bgMemRatio = float64(mem/EdgeDeviceMem) * dramDelta * model.RunTimeCoeff.MemoryUsage
-					}
-					v.CurrEnergyInDram = uint64(dyMemRatio + bgMemRatio)
-					v.AggEnergyInDram += v.CurrEnergyInDram
-					v.CurrEnergyInOther = uint64(perProcessOtherMJ)
-					v.AggEnergyInOther += uint64(perProcessOtherMJ)
-
-					val := uint64(0)
-					if v.CurrBytesRead >= v.AggBytesRead {
-						val = v.CurrBytesRead - v.AggBytesRead
-						v.AggBytesRead = v.CurrBytesRead
-						v.CurrBytesRead = val
-					}
-					if v.CurrBytesWrite >= v.AggBytesWrite {
-						val = v.CurrBytesWrite - v.AggBytesWrite
-						v.AggBytesWrite = v.CurrBytesWrite
-						v.CurrBytesWrite = val
-					}
-
-					if v.CurrEnergyInCore > 0 {
-						log.Printf("\tenergy from pod: name: %s namespace: %s \n"+
-							"\teCore: %d(%d) eDram: %d(%d) eOther: %d(%d) eGPU: %d(%d) \n"+
-							"\tCPUTime: %.2f (%.4f) \n\tcycles: %d (%.4f) \n\tinstructions: %d (%.4f) \n"+
-							"\tDiskReadBytes: %d (%d) \n\tDiskWriteBytes: %d (%d)\n"+
-							"\tmisses: %d (%.4f)\tResidentMemRatio: %.4f\n\tavgCPUFreq: %.4f MHZ\n\tpid: %v comm: %v\n",
-							containerName, v.Namespace,
-							v.CurrEnergyInCore, v.AggEnergyInCore,
-							v.CurrEnergyInDram, v.AggEnergyInDram,
-							v.CurrEnergyInOther, v.AggEnergyInOther,
-							v.CurrEnergyInGPU, v.AggEnergyInGPU,
-							v.CurrCPUTime, float64(v.CurrCPUTime)/float64(aggCPUTime),
-							v.CurrCPUCycles, float64(v.CurrCPUCycles)/float64(aggCPUCycles),
-							v.CurrCPUInstr, float64(v.CurrCPUInstr)/float64(aggCPUInstr),
-							v.CurrBytesRead, v.AggBytesRead,
-							v.CurrBytesRead, v.AggBytesWrite,
-							v.CurrCacheMisses, float64(v.CurrCacheMisses)/float64(aggCacheMisses),
-							float64(v.CurrResidentMem)/EdgeDeviceMem,
-							v.AvgCPUFreq/1000, /*MHZ*/
-							v.PID, v.Command)
+					if cpuSource == SourceMeasured {
+						model.Train(model.TrainingSample{
+							Seconds:     sampleSeconds,
+							Core:        coreDelta,
+							Dram:        dramDelta,
+							CPUTime:     aggCPUTime,
+							CPUCycles:   aggCPUCycles,
+							CPUInstr:    aggCPUInstr,
+							CacheMisses: aggCacheMisses,
+							Memory:      EdgeDeviceMem,
+						})
+					}
+					klog.V(sampleLogLevel).InfoS("node energy", "sample", lastSample, "core", coreDelta, "dram", dramDelta,
+						"cpuTime", aggCPUTime, "cycles", aggCPUCycles, "instructions", aggCPUInstr, "misses", aggCacheMisses, "memory", EdgeDeviceMem)
+					currEdgeDeviceEnergy = &CurrEdgeDeviceEnergy{
+						CPUTime:       aggCPUTime,
+						CPUCycles:     aggCPUCycles,
+						CPUInstr:      aggCPUInstr,
+						CacheMisses:   aggCacheMisses,
+						EdgeDeviceMem: EdgeDeviceMem,
+						EnergyInCore:  coreDelta,
+						EnergyInDram:  dramDelta,
+						EnergyInOther: otherDelta,
+						EnergyInGPU:   gpuDelta,
+
+						EnergyInHousekeeping: housekeepingDelta,
+						EnergyInAccelerator:  acceleratorEnergy,
+						EnergyInNetwork:      networkDelta,
+						EnergyInStatic:       staticDelta,
+						EnergyUnattributed:   unattributedDelta,
+						IdlePower:            idlePower,
+					}
+					platformDelta := sensorPlatformEnergy
+					if psysEnergy > 0 {
+						currEdgeDeviceEnergy.EnergyInPlatform = psysEnergy
+						currEdgeDeviceEnergy.PlatformResidual = platformResidual(psysEnergy, psysPackageEnergy, dramDelta)
+						platformDelta = currEdgeDeviceEnergy.PlatformResidual
+					}
+					currEdgeDeviceEnergy.Breakdown = nodeBreakdown(breakdownInput{
+						node:        nodeEnergyTotal,
+						cpu:         coreDelta,
+						dram:        dramDelta,
+						gpu:         gpuDelta,
+						accelerator: acceleratorDelta,
+						nic:         networkDelta,
+						storage:     storageEnergy(ioDelta),
+						platform:    platformDelta,
+					})
+					currEdgeDeviceEnergy.SampleSeconds = sampleSeconds
+					currEdgeDeviceEnergy.Sequence = nextSequence()
+					currEdgeDeviceEnergy.Time = lastSample
+					if otherCalibrationSamples >= otherCalibrationMinSamples {
+						currEdgeDeviceEnergy.CalibratedOtherPower = calibratedOtherPower
+					}
+					sources := map[string]string{"cpu": cpuSource, "node": nodeSource, "pods": podsSource}
+					if gpu.IsEnabled() {
+						sources["gpu"] = gpuSource
+					}
+					if networkAccounted() {
+						sources["network"] = SourceEstimated
+					}
+					currEdgeDeviceEnergy.Quality = newQuality(sources)
+					packageCPUTime := getPackageCPUTime()
+					for pkg, t := range droppedProcesses.CurrPackageCPUTime {
+						packageCPUTime[pkg] += t
+					}
+					droppedCore, droppedDram := droppedProcessesEnergy(coreDelta, dramDelta, aggCPUTime, weightedAggCPUTime, aggCPUCycles, aggCPUInstr, aggCacheMisses, packageCoreDelta, packageCPUTime)
+					currEdgeDeviceEnergy.EnergyUnattributed += droppedCore + droppedDram
+					aggUnattributed += droppedCore + droppedDram
+					accountEdgeDeviceEnergy(currEdgeDeviceEnergy)
+					accountNodeStateEnergy(time.Now(), coreDelta+dramDelta+otherDelta+gpuDelta+acceleratorDelta)
+					attributeProcessEnergy(coreDelta, dramDelta, aggCPUTime, aggCPUCycles, aggCPUInstr, aggCacheMisses, sampleStart)
+					var estimatedCore, estimatedDram map[string]float64
+					if input := estimatorFeatures(podMem); input != nil {
+						// the model server is called without the lock, the exporter and the hooks are not blocked
+						locked = false
+						lock.Unlock()
+						estimatedCore, estimatedDram = estimateContainerEnergy(input, coreDelta-droppedCore, dramDelta-droppedDram, model.Features{
+							CPUTime:        aggCPUTime,
+							CPUCycles:      aggCPUCycles,
+							CPUInstr:       aggCPUInstr,
+							CacheMisses:    aggCacheMisses,
+							ResidentMemory: EdgeDeviceMem,
+						})
+						lock.Lock()
+						locked = true
+					}
+					for containerName, v := range containerEnergy {
+						cpuTimeRatio := float64(0.0)
+						cpuCycleRatio := float64(0.0)
+						cpuInstrRatio := float64(0.0)
+						dyMemRatio := float64(0.0)
+						bgMemRatio := float64(0.0)
+
+						// on multi package nodes the CPU time part is attributed per package, in the per-core mode by
+						// the CPU time weighted by the power of the cores
+						var packageEnergy map[int]float64
+						if len(packageCoreDelta) > 0 && len(packageCPUTime) > 0 {
+							packageEnergy = packageCPUTimeEnergy(v, packageCoreDelta, packageCPUTime, aggCPUTime)
+							for _, e := range packageEnergy {
+								cpuTimeRatio += e * model.RunTimeCoeff.CPUTime
+							}
+						} else if weightedAggCPUTime > 0 {
+							cpuTimeRatio = v.CurrWeightedCPUTime / weightedAggCPUTime * coreDelta * model.RunTimeCoeff.CPUTime
+						} else if v.CurrCPUTime > 0 {
+							cpuTimeRatio = float64(float64(v.CurrCPUTime)/aggCPUTime) * coreDelta * model.RunTimeCoeff.CPUTime
+						}
+						if v.CurrCPUCycles > 0 {
+							cpuCycleRatio = float64(v.CurrCPUCycles) / float64(aggCPUCycles) * coreDelta * model.RunTimeCoeff.CPUCycle
+						}
+						if v.CurrCPUInstr > 0 {
+							cpuInstrRatio = float64(v.CurrCPUInstr) / float64(aggCPUInstr) * coreDelta * model.RunTimeCoeff.CPUInstr
+						}
+
+						v.CurrEnergyInCore = uint64(cpuTimeRatio + cpuCycleRatio + cpuInstrRatio)
+						if e, ok := estimatedCore[containerName]; ok {
+							v.CurrEnergyInCore = uint64(e)
+						}
+						v.AggEnergyInCore += v.CurrEnergyInCore
+						v.CurrEnergyInCorePerPackage = splitPerPackage(v.CurrEnergyInCore, packageEnergy)
+						if len(v.CurrEnergyInCorePerPackage) > 0 && v.AggEnergyInCorePerPackage == nil {
+							v.AggEnergyInCorePerPackage = map[int]uint64{}
+						}
+						for pkg, e := range v.CurrEnergyInCorePerPackage {
+							v.AggEnergyInCorePerPackage[pkg] += e
+						}
+
+						if v.CurrCacheMisses > 0 {
+							dyMemRatio = float64(v.CurrCacheMisses) / float64(aggCacheMisses) * dramDelta * model.RunTimeCoeff.CacheMisses
+						}
+						k := v.Namespace + "/" + containerName
+						if mem, ok := podMem[k]; ok {
+							v.CurrResidentMem = uint64(mem)
+							bgMemRatio = float64(mem/EdgeDeviceMem) * dramDelta * model.RunTimeCoeff.MemoryUsage
+						}
+						v.CurrEnergyInDram = uint64(dyMemRatio + bgMemRatio)
+						if e, ok := estimatedDram[containerName]; ok {
+							v.CurrEnergyInDram = uint64(e)
+						}
+						v.AggEnergyInDram += v.CurrEnergyInDram
+						v.CurrEnergyInNetwork = uint64(nicEnergy(v.CurrBytesTx+v.CurrBytesRx, v.CurrPacketsTx+v.CurrPacketsRx) * networkScale)
+						v.AggEnergyInNetwork += v.CurrEnergyInNetwork
+						otherMJ := float64(0)
+						if v.lastSeen.Equal(sampleStart) {
+							otherMJ = otherShare(v, staticDelta-unattributedDelta, dynamicOtherDelta, activeContainers, aggCPUTime, podMem[k], podsMem)
+						}
+						v.CurrEnergyInOther = uint64(otherMJ) + v.CurrEnergyInNetwork
+						v.AggEnergyInOther += v.CurrEnergyInOther
+
+						val := uint64(0)
+						if v.CurrBytesRead >= v.AggBytesRead {
+							val = v.CurrBytesRead - v.AggBytesRead
+							v.AggBytesRead = v.CurrBytesRead
+							v.CurrBytesRead = val
+						}
+						if v.CurrBytesWrite >= v.AggBytesWrite {
+							val = v.CurrBytesWrite - v.AggBytesWrite
+							v.AggBytesWrite = v.CurrBytesWrite
+							v.CurrBytesWrite = val
+						}
+
+						classifyContainer(v, sampleSeconds)
+						// Enabled first, not to build the fields of every pod when not logged
+						if podLog := klog.V(podLogLevel); podLog.Enabled() && v.CurrEnergyInCore > 0 {
+							podLog.InfoS("pod energy", "container", containerName, "namespace", v.Namespace, "sample", lastSample,
+								"core", v.CurrEnergyInCore, "coreTotal", v.AggEnergyInCore,
+								"dram", v.CurrEnergyInDram, "dramTotal", v.AggEnergyInDram,
+								"other", v.CurrEnergyInOther, "otherTotal", v.AggEnergyInOther,
+								"gpu", v.CurrEnergyInGPU, "gpuTotal", v.AggEnergyInGPU,
+								"cpuTime", v.CurrCPUTime, "cpuTimeRatio", float64(v.CurrCPUTime)/float64(aggCPUTime),
+								"cycles", v.CurrCPUCycles, "cyclesRatio", float64(v.CurrCPUCycles)/float64(aggCPUCycles),
+								"instructions", v.CurrCPUInstr, "instructionsRatio", float64(v.CurrCPUInstr)/float64(aggCPUInstr),
+								"diskRead", v.CurrBytesRead, "diskReadTotal", v.AggBytesRead,
+								"diskWrite", v.CurrBytesWrite, "diskWriteTotal", v.AggBytesWrite,
+								"misses", v.CurrCacheMisses, "missesRatio", float64(v.CurrCacheMisses)/float64(aggCacheMisses),
+								"residentMemRatio", float64(v.CurrResidentMem)/EdgeDeviceMem,
+								"avgCPUFreqMHz", v.AvgCPUFreq/1000,
+								"pid", v.PID, "command", v.Command)
+						}
+					}
+					now := time.Now()
+					accountPeriodTotals(now)
+					pruneExportedCounters(now)
+					accountSessions(intervalStart, lastSample, coreDelta+dramDelta+otherDelta+gpuDelta+acceleratorDelta)
+					markTerminatedContainers(now)
+					snapshot := takeSnapshot(now)
+					peak := capturePeak(snapshot, sampleSeconds)
+					evictTerminatedContainers()
+					hooks := sampleHooks
+					waiters := sampleWaiters
+					sampleWaiters = nil
+					locked = false
+					sampling = false
+					lock.Unlock()
+					if err := snapshot.seal(); err != nil {
+						klog.ErrorS(err, "failed to seal the snapshot", "sample", snapshot.Time)
+					}
+					if peak != nil {
+						savePeakEvent(peak)
+					}
+					// the hooks run without the lock, they may take their time
+					for _, f := range hooks {
+						f(snapshot)
+					}
+					for _, w := range waiters {
+						w <- snapshot
 					}
 				}
-				lock.Unlock()
 			}
-		}
+		})
 	}()
 }
 
 // getAVGCPUFreqAndTotalCPUTime calculates the weighted cpu frequency average
-func getAVGCPUFreqAndTotalCPUTime(cpuFrequency map[int32]uint64, cpuTime [C.CPU_VECTOR_SIZE]uint16) (float64, float64) {
+// if cpuSet is not nil, only the frequency of the CPUs in the container cpuset is averaged
+func getAVGCPUFreqAndTotalCPUTime(cpuFrequency map[int32]uint64, cpuTime [C.CPU_VECTOR_SIZE]uint16, cpuSet map[int32]bool) (float64, float64) {
 	totalFreq := float64(0)
 	totalCPUTime := float64(0)
+	freqCPUTime := float64(0)
 	for cpu, freq := range cpuFrequency {
 		if cpuTime[cpu] != 0 {
-			totalFreq += float64(freq) * float64(cpuTime[cpu])
 			totalCPUTime += float64(cpuTime[cpu])
+			// time on other CPUs was spent before the container was pinned (e.g. by the runtime)
+			if cpuSet != nil && !cpuSet[cpu] {
+				continue
+			}
+			totalFreq += float64(freq) * float64(cpuTime[cpu])
+			freqCPUTime += float64(cpuTime[cpu])
 		}
 	}
-	avgFreq := totalFreq / totalCPUTime
+	avgFreq := float64(0)
+	if freqCPUTime > 0 {
+		avgFreq = totalFreq / freqCPUTime
+	}
 	return avgFreq, totalCPUTime
 }
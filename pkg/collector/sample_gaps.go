@@ -0,0 +1,51 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"time"
+)
+
+// A sample skipped or late leaves a hole in the data that is silent otherwise, they are counted per cause:
+//   - power_unchanged: the power meters did not move since the last sample, the sample is retried
+//   - sensor_error: the core or the DRAM meter failed and neither moved
+//   - unresolved: processes of the sample were skipped, their pod could not be resolved
+//   - late: the sample started more than half a period after its time, e.g. the previous sample overran
+//   - panic: the sample panicked, the collector restarts
+const (
+	GapPowerUnchanged = "power_unchanged"
+	GapSensorError    = "sensor_error"
+	GapUnresolved     = "unresolved"
+	GapLate           = "late"
+	GapPanic          = "panic"
+
+	lateFactor = 1.5
+)
+
+var sampleGaps = map[string]uint64{}
+
+// countSampleGap counts a skipped or late sample
+func countSampleGap(cause string) {
+	lock.Lock()
+	defer lock.Unlock()
+	sampleGaps[cause]++
+}
+
+// isLate tells whether a tick came late after the previous one, a zero previous tick is never late
+func isLate(tick, previous time.Time, period time.Duration) bool {
+	return !previous.IsZero() && tick.Sub(previous) > time.Duration(float64(period)*lateFactor)
+}
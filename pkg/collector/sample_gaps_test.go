@@ -0,0 +1,43 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsLate(t *testing.T) {
+	period := 3 * time.Second
+	previous := time.Unix(1000, 0)
+	for _, c := range []struct {
+		tick, previous time.Time
+		late           bool
+	}{
+		{previous.Add(period), time.Time{}, false},
+		{previous.Add(period), previous, false},
+		{previous.Add(period * 3 / 2), previous, false},
+		{previous.Add(period*3/2 + time.Millisecond), previous, true},
+		{previous.Add(2 * period), previous, true},
+		// a clock step back is not late
+		{previous.Add(-period), previous, false},
+	} {
+		if late := isLate(c.tick, c.previous, period); late != c.late {
+			t.Errorf("isLate(%v, %v, %v) = %v, want %v", c.tick, c.previous, period, late, c.late)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+const (
+	procStatPath = "/proc/%d/stat"
+	// policy is the 41st field of /proc/<pid>/stat, the 39th after the "(comm)" field
+	procStatPolicyIndex = 38
+)
+
+var (
+	realTimePolicies = map[int]string{
+		1: "SCHED_FIFO",
+		2: "SCHED_RR",
+		6: "SCHED_DEADLINE",
+	}
+	// containers with real-time threads are usually safety-critical control loops that
+	// must never be throttled by power capping or frequency tuning
+	excludeRealTimeFromActuation = true
+)
+
+func SetExcludeRealTimeFromActuation(exclude bool) {
+	excludeRealTimeFromActuation = exclude
+}
+
+// IsActuationExcluded returns true if power actuation policies must not touch the container
+func IsActuationExcluded(v *ContainerEnergy) bool {
+	return excludeRealTimeFromActuation && len(v.SchedPolicy) > 0
+}
+
+// GetActuationExcludedCPUs returns the CPUs the actuation policies must not retune, the isolated and nohz_full
+// CPUs and the cpusets of the excluded containers, and whether an excluded container may run on any CPU
+func GetActuationExcludedCPUs() (map[int32]bool, bool) {
+	cpus := map[int32]bool{}
+	for cpu, role := range cpuRoles {
+		if role != cpuRoleHousekeeping {
+			cpus[cpu] = true
+		}
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	for _, v := range containerEnergy {
+		if v.Terminated || !IsActuationExcluded(v) {
+			continue
+		}
+		list, err := pod_lister.ParseCPUList(v.CPUSet)
+		if len(v.CPUSet) == 0 || err != nil {
+			return cpus, true
+		}
+		for _, cpu := range list {
+			cpus[cpu] = true
+		}
+	}
+	return cpus, false
+}
+
+// getRealTimePolicy returns the real-time scheduling policy of a thread, or "" if the thread is not real-time
+func getRealTimePolicy(pid uint64) (string, error) {
+	data, err := ioutil.ReadFile(fmt.Sprintf(procStatPath, pid))
+	if err != nil {
+		return "", err
+	}
+	// the comm can contain spaces and parentheses, the fields start after the last ')'
+	stat := string(data)
+	i := strings.LastIndex(stat, ")")
+	if i < 0 {
+		return "", fmt.Errorf("failed to parse %s", fmt.Sprintf(procStatPath, pid))
+	}
+	fields := strings.Fields(stat[i+1:])
+	if len(fields) <= procStatPolicyIndex {
+		return "", fmt.Errorf("no scheduling policy found in %s", fmt.Sprintf(procStatPath, pid))
+	}
+	policy, err := strconv.Atoi(fields[procStatPolicyIndex])
+	if err != nil {
+		return "", err
+	}
+	return realTimePolicies[policy], nil
+}
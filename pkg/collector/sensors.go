@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
+)
+
+var (
+	// sensorMeter reads the INA and PMIC power sensors of the board, nil if it has none
+	sensorMeter *soc.SensorMeter
+	// sensorEnergy is the accumulated energy (mJ) per sensor
+	sensorEnergy = map[string]float64{}
+	// sensorPlatformEnergy is the energy (mJ) of the other platform rails in the last read
+	sensorPlatformEnergy float64
+)
+
+// sensorSource reads the node, CPU and DRAM energy from the power sensors at once
+type sensorSource struct {
+	pending power.Pending
+}
+
+func init() {
+	if sensorMeter = soc.NewSensorMeter(); sensorMeter != nil {
+		log.Printf("power sensors: %s\n", strings.Join(sensorMeter.Sensors(), ", "))
+		power.Register(&sensorSource{}, power.PrioritySensors)
+	}
+}
+
+func (s *sensorSource) Name() string {
+	return "sensors"
+}
+
+func (s *sensorSource) Init() error {
+	return nil
+}
+
+func (s *sensorSource) IsAvailable() bool {
+	return true
+}
+
+func (s *sensorSource) GetEnergy(zone string) (float64, error) {
+	return s.pending.Take(zone, func() (map[string]float64, error) {
+		core, dram, platform, total := readSensorEnergy()
+		sensorPlatformEnergy = platform
+		return map[string]float64{power.ZoneNode: total, power.ZoneCore: core, power.ZoneDram: dram}, nil
+	})
+}
+
+func (s *sensorSource) Shutdown() {}
+
+// readSensorEnergy reads the power sensors and returns the energy (mJ) of the CPU, DRAM and other platform rails
+// and of the node in the last interval. The node energy is the board input if a sensor measures it, else the sum
+// of the rails.
+func readSensorEnergy() (core, dram, platform, total float64) {
+	if sensorMeter == nil {
+		return 0, 0, 0, 0
+	}
+	energy := sensorMeter.GetEnergy()
+	input, rails := float64(0), float64(0)
+	lock.Lock()
+	defer lock.Unlock()
+	for sensor, e := range energy {
+		sensorEnergy[sensor] += e
+		switch soc.SensorSubsystem(sensor) {
+		case soc.SubsystemTotal:
+			input += e
+			continue
+		case soc.SubsystemCPU:
+			core += e
+		case soc.SubsystemDRAM:
+			dram += e
+		case soc.SubsystemOther:
+			platform += e
+		}
+		rails += e
+	}
+	total = input
+	if total == 0 {
+		total = rails
+	}
+	return core, dram, platform, total
+}
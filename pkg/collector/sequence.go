@@ -0,0 +1,79 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"io/ioutil"
+	"log"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// Every sample is numbered, following the samples of the previous runs in the same boot, so the fleet
+// backends deduplicate the samples published twice and detect the missing ones by boot id and sequence.
+// The numbers are reserved by blocks saved in the store, a run restarting after a crash skips the rest
+// of the block of the previous run, a gap the backends see as the downtime.
+const (
+	bootIDPath    = "/proc/sys/kernel/random/boot_id"
+	sequenceKey   = "sequence"
+	sequenceBlock = 100
+)
+
+type sequenceState struct {
+	BootID   string `json:"boot_id"`
+	Reserved uint64 `json:"reserved"`
+}
+
+var (
+	// BootID identifies the boot of the device
+	BootID           string
+	sampleSequence   uint64
+	sequenceReserved uint64
+)
+
+// loadSequence reads the boot id and continues the sequence of the previous runs of the boot
+func loadSequence() {
+	data, err := ioutil.ReadFile(bootIDPath)
+	if err != nil {
+		log.Printf("failed to read the boot id: %v\n", err)
+	}
+	BootID = strings.TrimSpace(string(data))
+	sampleSequence = 0
+	var state sequenceState
+	found, err := store.Load(sequenceKey, &state)
+	if err != nil {
+		log.Printf("failed to load the sample sequence: %v\n", err)
+	}
+	if found && state.BootID == BootID {
+		sampleSequence = state.Reserved
+	}
+	sequenceReserved = sampleSequence
+}
+
+// nextSequence returns the number of a new sample, reserving a block once the reserved numbers are
+// used, the collector lock must be held
+func nextSequence() uint64 {
+	sampleSequence++
+	if sampleSequence > sequenceReserved {
+		sequenceReserved = sampleSequence + sequenceBlock - 1
+		if err := store.Save(sequenceKey, sequenceState{BootID: BootID, Reserved: sequenceReserved}); err != nil {
+			log.Printf("failed to save the sample sequence: %v\n", err)
+		}
+	}
+	return sampleSequence
+}
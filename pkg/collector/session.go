@@ -0,0 +1,155 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// A session measures the energy of a named window, e.g. one run of an operation, without post-processing
+// the time series. Each sample adds the share of its energy falling within the window, prorated by the
+// overlap of the sample interval with the window, so the windows need not be aligned on the samples.
+const maxSessions = 64
+
+// Session is the energy (mJ) per "namespace/container" and of the node within the window
+type Session struct {
+	Name  string    `json:"name"`
+	Start time.Time `json:"start"`
+	// End is zero while the session runs
+	End        time.Time          `json:"end,omitempty"`
+	Energy     map[string]float64 `json:"energy"`
+	NodeEnergy float64            `json:"node_energy"`
+	// done is set once a sample covered the end of the window
+	done bool
+}
+
+var sessions = map[string]*Session{}
+
+// StartSession starts measuring a window now
+func StartSession(name string) (*Session, error) {
+	if len(name) == 0 {
+		return nil, fmt.Errorf("no session name")
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if _, ok := sessions[name]; ok {
+		return nil, fmt.Errorf("session %q already started", name)
+	}
+	if len(sessions) >= maxSessions {
+		return nil, fmt.Errorf("too many sessions, %d at most", maxSessions)
+	}
+	s := &Session{Name: name, Start: time.Now(), Energy: map[string]float64{}}
+	sessions[name] = s
+	return s.copy(), nil
+}
+
+// StopSession ends the window now and returns its energy once a sample covered the end of the window,
+// triggering the sample right away. The session is removed even if no sample is taken before ctx is done
+func StopSession(ctx context.Context, name string) (*Session, error) {
+	lock.Lock()
+	s, ok := sessions[name]
+	if ok && s.End.IsZero() {
+		s.End = time.Now()
+	}
+	lock.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("no session %q", name)
+	}
+	defer func() {
+		lock.Lock()
+		if sessions[name] == s {
+			delete(sessions, name)
+		}
+		lock.Unlock()
+	}()
+	for {
+		// a sample in progress may end before the window
+		if _, err := SampleNow(ctx); err != nil {
+			return nil, err
+		}
+		lock.Lock()
+		if s.done {
+			result := s.copy()
+			lock.Unlock()
+			return result, nil
+		}
+		lock.Unlock()
+	}
+}
+
+// GetSessions returns the running sessions with their energy so far
+func GetSessions() []*Session {
+	lock.Lock()
+	defer lock.Unlock()
+	result := make([]*Session, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, s.copy())
+	}
+	return result
+}
+
+func (s *Session) copy() *Session {
+	c := *s
+	c.Energy = make(map[string]float64, len(s.Energy))
+	for k, e := range s.Energy {
+		c.Energy[k] = e
+	}
+	return &c
+}
+
+// overlap returns the share of the sample interval falling within the window
+func (s *Session) overlap(sampleStart, sampleEnd time.Time) float64 {
+	if !sampleEnd.After(sampleStart) {
+		return 0
+	}
+	start, end := sampleStart, sampleEnd
+	if s.Start.After(start) {
+		start = s.Start
+	}
+	if !s.End.IsZero() && s.End.Before(end) {
+		end = s.End
+	}
+	if !end.After(start) {
+		return 0
+	}
+	return float64(end.Sub(start)) / float64(sampleEnd.Sub(sampleStart))
+}
+
+// accountSessions adds the share of the current energy within the windows of the sessions, the
+// collector lock must be held
+func accountSessions(sampleStart, sampleEnd time.Time, nodeEnergy float64) {
+	for _, s := range sessions {
+		if s.done {
+			continue
+		}
+		if share := s.overlap(sampleStart, sampleEnd); share > 0 {
+			s.NodeEnergy += share * nodeEnergy
+			for containerName, v := range containerEnergy {
+				energy := float64(v.CurrEnergyInCore + v.CurrEnergyInDram + v.CurrEnergyInGPU + v.CurrEnergyInOther)
+				for _, e := range v.CurrEnergyInAccelerator {
+					energy += float64(e)
+				}
+				s.Energy[v.Namespace+"/"+containerName] += share * energy
+			}
+		}
+		if !s.End.IsZero() && !sampleEnd.Before(s.End) {
+			s.done = true
+		}
+	}
+}
@@ -0,0 +1,264 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf16"
+)
+
+// The snapshots, the attestation and the API reports carry their hash, and the signature of the hash with
+// the device key if one is set, so the fleet backends can check the energy and billing data was not altered
+// on a physically accessible device or on the way. The hash is the SHA-256 of the RFC 8785 (JCS) canonical
+// JSON encoding without the hash and the signature: sorted keys, no whitespace, minimal string escapes and
+// the ECMAScript number format, which the backends reproduce with any JCS library. The numbers are doubles
+// in JCS, so the counters above 2^53 are hashed rounded. The signature is the ed25519 signature of the hash.
+const keyIDLength = 16
+
+var signingKey ed25519.PrivateKey
+
+// SetSigningKey loads the PKCS#8 PEM ed25519 device key (openssl genpkey -algorithm ed25519), empty to
+// not sign the snapshots
+func SetSigningKey(path string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return fmt.Errorf("no PEM key in %s", path)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	edKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return fmt.Errorf("%s is not an ed25519 key", path)
+	}
+	signingKey = edKey
+	log.Printf("signing the snapshots with key %s\n", signingKeyID())
+	return nil
+}
+
+// KeyID identifies a device key, it is the start of the hex SHA-256 of the public key
+func KeyID(key ed25519.PublicKey) string {
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:])[:keyIDLength]
+}
+
+// seal sets the hash of the snapshot and signs it with the device key
+func (s *Snapshot) seal() error {
+	s.Hash, s.Signature, s.KeyID = "", "", signingKeyID()
+	var err error
+	s.Hash, s.Signature, err = sealJSON(s)
+	return err
+}
+
+// Verify checks the hash of the snapshot and its signature with the device public key
+func (s *Snapshot) Verify(key ed25519.PublicKey) error {
+	unsealed := *s
+	unsealed.Hash, unsealed.Signature = "", ""
+	// the CBOR float times decode within a microsecond of the sealed time
+	unsealed.Time = s.Time.UTC().Round(time.Microsecond)
+	return verifyJSON(&unsealed, s.KeyID, s.Hash, s.Signature, key)
+}
+
+func signingKeyID() string {
+	if signingKey == nil {
+		return ""
+	}
+	return KeyID(signingKey.Public().(ed25519.PublicKey))
+}
+
+// SealReport returns the device key ID, the hash and the signature of a report served without them
+func SealReport(v interface{}) (keyID, hash, signature string, err error) {
+	hash, signature, err = sealJSON(v)
+	return signingKeyID(), hash, signature, err
+}
+
+// VerifyReport checks the hash of a report sealed with SealReport and its signature with the device public key
+func VerifyReport(v interface{}, keyID, hash, signature string, key ed25519.PublicKey) error {
+	return verifyJSON(v, keyID, hash, signature, key)
+}
+
+// sealJSON returns the hex SHA-256 of the canonical JSON encoding of v and its signature with the device key, if set
+func sealJSON(v interface{}) (string, string, error) {
+	sum, err := sumJSON(v)
+	if err != nil {
+		return "", "", err
+	}
+	signature := ""
+	if signingKey != nil {
+		signature = hex.EncodeToString(ed25519.Sign(signingKey, sum))
+	}
+	return hex.EncodeToString(sum), signature, nil
+}
+
+// verifyJSON checks the hash and the signature of v, without its hash and signature
+func verifyJSON(v interface{}, keyID, hash, signature string, key ed25519.PublicKey) error {
+	if len(keyID) > 0 && keyID != KeyID(key) {
+		return fmt.Errorf("signed with key %s, not %s", keyID, KeyID(key))
+	}
+	sig, err := hex.DecodeString(signature)
+	if err != nil || len(sig) == 0 {
+		return fmt.Errorf("not signed")
+	}
+	sum, err := sumJSON(v)
+	if err != nil {
+		return err
+	}
+	if hex.EncodeToString(sum) != hash {
+		return fmt.Errorf("hash mismatch")
+	}
+	if !ed25519.Verify(key, sum, sig) {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
+
+func sumJSON(v interface{}) ([]byte, error) {
+	data, err := canonicalJSON(v)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode: %v", err)
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
+
+// canonicalJSON returns the RFC 8785 encoding of the JSON encoding of v
+func canonicalJSON(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := writeCanonical(&buf, value); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func writeCanonical(buf *bytes.Buffer, value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		buf.WriteString("null")
+	case bool:
+		buf.WriteString(strconv.FormatBool(v))
+	case float64:
+		// encoding/json formats the floats as ECMAScript does, except the negative zero
+		if v == 0 {
+			v = 0
+		}
+		data, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		buf.Write(data)
+	case string:
+		writeCanonicalString(buf, v)
+	case []interface{}:
+		buf.WriteByte('[')
+		for i, e := range v {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			if err := writeCanonical(buf, e); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte(']')
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		// the keys are sorted by their UTF-16 code units
+		sort.Slice(keys, func(i, j int) bool {
+			a, b := utf16.Encode([]rune(keys[i])), utf16.Encode([]rune(keys[j]))
+			for n := 0; n < len(a) && n < len(b); n++ {
+				if a[n] != b[n] {
+					return a[n] < b[n]
+				}
+			}
+			return len(a) < len(b)
+		})
+		buf.WriteByte('{')
+		for i, k := range keys {
+			if i > 0 {
+				buf.WriteByte(',')
+			}
+			writeCanonicalString(buf, k)
+			buf.WriteByte(':')
+			if err := writeCanonical(buf, v[k]); err != nil {
+				return err
+			}
+		}
+		buf.WriteByte('}')
+	default:
+		return fmt.Errorf("unexpected JSON value %T", value)
+	}
+	return nil
+}
+
+// writeCanonicalString escapes only the quote, the backslash and the control characters
+func writeCanonicalString(buf *bytes.Buffer, s string) {
+	buf.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			buf.WriteByte('\\')
+			buf.WriteRune(r)
+		case '\b':
+			buf.WriteString(`\b`)
+		case '\t':
+			buf.WriteString(`\t`)
+		case '\n':
+			buf.WriteString(`\n`)
+		case '\f':
+			buf.WriteString(`\f`)
+		case '\r':
+			buf.WriteString(`\r`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(buf, `\u%04x`, r)
+			} else {
+				buf.WriteRune(r)
+			}
+		}
+	}
+	buf.WriteByte('"')
+}
@@ -0,0 +1,105 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"crypto/ed25519"
+	"encoding/json"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestCanonicalJSON(t *testing.T) {
+	v := map[string]interface{}{
+		"numbers":    []interface{}{1e21, math.Copysign(0, -1), 1e-6, 1e-7, 333333333.33333329, 4.5, -1},
+		"string":     "€$\u000f\nA'B\"\\\\\"/<>& ",
+		"literals":   []interface{}{nil, true, false},
+		"é":          1,
+		"\U0001f600": 2,
+		"דּ":          3,
+	}
+	data, err := canonicalJSON(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"literals":[null,true,false],"numbers":[1e+21,0,0.000001,1e-7,333333333.3333333,4.5,-1],` +
+		`"string":"€$\u000f\nA'B\"\\\\\"/<>&` + " " + `","é":1,"` + "\U0001f600" + `":2,"` + "דּ" + `":3}`
+	if string(data) != want {
+		t.Fatalf("got %s, want %s", data, want)
+	}
+}
+
+func TestSealVerify(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKey = private
+	defer func() { signingKey = nil }()
+
+	s := &Snapshot{
+		Time:     time.Now().UTC().Truncate(time.Microsecond),
+		BootID:   "boot",
+		Sequence: 7,
+		EdgeDevice: EdgeDeviceSnapshot{
+			Name:         "edge",
+			EnergyInCore: 12.5,
+			Accelerators: map[string]float64{"npu": 0.25},
+		},
+		Containers: []ContainerSnapshot{{}},
+	}
+	if err := s.seal(); err != nil {
+		t.Fatal(err)
+	}
+	if s.KeyID != KeyID(public) || len(s.Signature) == 0 {
+		t.Fatalf("snapshot not signed: %+v", s)
+	}
+	// the backends verify the snapshots they decoded
+	data, err := json.Marshal(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var received Snapshot
+	if err := json.Unmarshal(data, &received); err != nil {
+		t.Fatal(err)
+	}
+	if err := received.Verify(public); err != nil {
+		t.Fatalf("failed to verify the snapshot: %v", err)
+	}
+	received.EdgeDevice.EnergyInCore++
+	if err := received.Verify(public); err == nil {
+		t.Fatal("verified a tampered snapshot")
+	}
+	other, _, _ := ed25519.GenerateKey(nil)
+	if err := s.Verify(other); err == nil {
+		t.Fatal("verified the snapshot with another key")
+	}
+
+	report := map[string]interface{}{"joules": 42.0, "name": "session"}
+	keyID, hash, signature, err := SealReport(report)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyReport(report, keyID, hash, signature, public); err != nil {
+		t.Fatalf("failed to verify the report: %v", err)
+	}
+	report["joules"] = 41.0
+	if err := VerifyReport(report, keyID, hash, signature, public); err == nil {
+		t.Fatal("verified a tampered report")
+	}
+}
@@ -0,0 +1,171 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/ambient"
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+	"github.com/sustainable-computing-io/kepler/pkg/power/solar"
+	"github.com/sustainable-computing-io/kepler/pkg/runtimestats"
+)
+
+// Snapshot is a copy of the energy of the last sample, handed to the sample hooks so they can
+// read it without holding the collector lock. Energies are in mJ.
+type Snapshot struct {
+	Time time.Time `json:"time"`
+	// BootID and Sequence identify the sample across the fleet, the sequence follows the samples of the boot
+	BootID     string              `json:"boot_id"`
+	Sequence   uint64              `json:"sequence"`
+	EdgeDevice EdgeDeviceSnapshot  `json:"edge_device"`
+	Containers []ContainerSnapshot `json:"containers"`
+	// Hash, and Signature with the device key KeyID, make the snapshot tamper-evident, see Verify
+	KeyID     string `json:"key_id,omitempty"`
+	Hash      string `json:"hash,omitempty"`
+	Signature string `json:"signature,omitempty"`
+}
+
+type EdgeDeviceSnapshot struct {
+	Name          string             `json:"name"`
+	CPUTime       float64            `json:"cpu_time"`
+	EnergyInCore  float64            `json:"energy_in_core"`
+	EnergyInDram  float64            `json:"energy_in_dram"`
+	EnergyInGPU   float64            `json:"energy_in_gpu"`
+	EnergyInOther float64            `json:"energy_in_other"`
+	Accelerators  map[string]float64 `json:"accelerators,omitempty"`
+	States        []string           `json:"states,omitempty"`
+	// Ambient are the environmental readings per sensor
+	Ambient map[string]map[string]float64 `json:"ambient,omitempty"`
+	// Solar is the status of the charge controller of off-grid sites
+	Solar *solar.Status `json:"solar,omitempty"`
+	// Quality tells which sources of the sample were measured, estimated or stale
+	Quality *Quality `json:"quality,omitempty"`
+}
+
+type ContainerSnapshot struct {
+	Name        string            `json:"name"`
+	Namespace   string            `json:"namespace"`
+	Command     string            `json:"command"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	Fingerprint string            `json:"fingerprint,omitempty"`
+	Class       string            `json:"class,omitempty"`
+	// Images are the images of the containers of the pod, one per container
+	Images        []pod_lister.ImageRef `json:"images,omitempty"`
+	CPUTime       float64               `json:"cpu_time"`
+	EnergyInCore  uint64                `json:"energy_in_core"`
+	EnergyInDram  uint64                `json:"energy_in_dram"`
+	EnergyInGPU   uint64                `json:"energy_in_gpu"`
+	EnergyInOther uint64                `json:"energy_in_other"`
+	Accelerators  map[string]uint64     `json:"accelerators,omitempty"`
+	// PeriodEnergy is the energy since the start of the day and of the week
+	PeriodEnergy map[string]float64 `json:"period_energy"`
+	// Terminated marks the last snapshot of a removed container
+	Terminated bool `json:"terminated,omitempty"`
+	// Runtime are the GC and allocation metrics of the workload, if its metrics endpoint is scraped
+	Runtime *runtimestats.Stats `json:"runtime,omitempty"`
+}
+
+var (
+	sampleHooks []func(*Snapshot)
+	// sampleWaiters are the on-demand sample requests waiting for the next sample
+	sampleWaiters   []chan *Snapshot
+	sampleRequested = make(chan struct{}, 1)
+)
+
+// OnSample registers a function called with the snapshot of each sample
+func OnSample(f func(*Snapshot)) {
+	lock.Lock()
+	defer lock.Unlock()
+	sampleHooks = append(sampleHooks, f)
+}
+
+// SampleNow triggers a sample out of the sample period and returns the snapshot of the first sample
+// completed after the request, once the sample hooks ran on it. The concurrent requests share the sample
+func SampleNow(ctx context.Context) (*Snapshot, error) {
+	// buffered, the sample does not block on the requests given up
+	reply := make(chan *Snapshot, 1)
+	lock.Lock()
+	sampleWaiters = append(sampleWaiters, reply)
+	lock.Unlock()
+	select {
+	case sampleRequested <- struct{}{}:
+	default:
+	}
+	select {
+	case s := <-reply:
+		return s, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// takeSnapshot copies the current energy, the collector lock must be held
+func takeSnapshot(now time.Time) *Snapshot {
+	s := &Snapshot{
+		// UTC and microseconds, the time precision of the CBOR payloads, so their hash verifies
+		Time:     now.UTC().Truncate(time.Microsecond),
+		BootID:   BootID,
+		Sequence: currEdgeDeviceEnergy.Sequence,
+		EdgeDevice: EdgeDeviceSnapshot{
+			Name:          EdgeDeviceName,
+			CPUTime:       currEdgeDeviceEnergy.CPUTime,
+			EnergyInCore:  currEdgeDeviceEnergy.EnergyInCore,
+			EnergyInDram:  currEdgeDeviceEnergy.EnergyInDram,
+			EnergyInGPU:   currEdgeDeviceEnergy.EnergyInGPU,
+			EnergyInOther: currEdgeDeviceEnergy.EnergyInOther,
+			Accelerators:  map[string]float64{},
+			States:        append([]string{}, nodeStates...),
+			Ambient:       ambient.GetReadings(),
+			Solar:         solar.GetStatus(),
+			Quality:       currEdgeDeviceEnergy.Quality,
+		},
+		Containers: make([]ContainerSnapshot, 0, len(containerEnergy)),
+	}
+	for class, e := range currEdgeDeviceEnergy.EnergyInAccelerator {
+		s.EdgeDevice.Accelerators[class] = e
+	}
+	for containerName, v := range containerEnergy {
+		c := ContainerSnapshot{
+			Name:          containerName,
+			Namespace:     v.Namespace,
+			Command:       v.Command,
+			Labels:        pod_lister.GetPodLabels(v.Namespace, containerName),
+			Fingerprint:   v.Fingerprint,
+			Class:         v.Class,
+			Images:        pod_lister.GetPodImageRefs(v.Namespace, containerName),
+			CPUTime:       v.CurrCPUTime,
+			EnergyInCore:  v.CurrEnergyInCore,
+			EnergyInDram:  v.CurrEnergyInDram,
+			EnergyInGPU:   v.CurrEnergyInGPU,
+			EnergyInOther: v.CurrEnergyInOther,
+			Accelerators:  map[string]uint64{},
+			PeriodEnergy:  map[string]float64{},
+			Terminated:    v.Terminated,
+			Runtime:       runtimestats.Get(v.Namespace, containerName),
+		}
+		for class, e := range v.CurrEnergyInAccelerator {
+			c.Accelerators[class] = e
+		}
+		for period, t := range periodTotals {
+			c.PeriodEnergy[period] = t.Energy[v.Namespace+"/"+containerName]
+		}
+		s.Containers = append(s.Containers, c)
+	}
+	return s
+}
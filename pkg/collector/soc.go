@@ -0,0 +1,102 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
+)
+
+var (
+	// socTopology is the power topology of ARM SoCs read from the device tree, nil on other platforms
+	socTopology *soc.Topology
+	// regulatorMeter reads the rail power from the regulator framework, nil if no regulator exposes it
+	regulatorMeter *soc.RegulatorMeter
+	// railEnergy is the accumulated energy (mJ) per rail, currRailEnergy the energy of the last sample
+	railEnergy     = map[string]float64{}
+	currRailEnergy = map[string]float64{}
+)
+
+// railSourceName is the name of the rail source, its node energy is an estimate
+const railSourceName = "rails"
+
+// railSource reads the node energy from the regulator rails of SoCs without a power meter. The regulators
+// mostly report their current limit, so the energy overestimates the node and the source is an estimate.
+type railSource struct{}
+
+func init() {
+	if t, err := soc.ParseDeviceTree(); err == nil {
+		socTopology = t
+	}
+	if soc.IsRegulatorPowerSupported() {
+		regulatorMeter = soc.NewRegulatorMeter()
+		power.Register(railSource{}, power.PriorityRails)
+	}
+}
+
+func (railSource) Name() string {
+	return railSourceName
+}
+
+func (railSource) Init() error {
+	return nil
+}
+
+func (railSource) IsAvailable() bool {
+	return true
+}
+
+func (railSource) GetEnergy(zone string) (float64, error) {
+	if zone != power.ZoneNode {
+		return 0, power.ErrNoZone
+	}
+	return readRailEnergy(), nil
+}
+
+func (railSource) Shutdown() {}
+
+func railSubsystem(rail string) string {
+	if socTopology == nil {
+		return soc.SubsystemOther
+	}
+	return socTopology.Subsystem(rail)
+}
+
+// readRailEnergy reads the regulator rails and returns the energy (mJ) of the leaf rails in the last interval
+func readRailEnergy() float64 {
+	if regulatorMeter == nil {
+		return 0
+	}
+	energy, err := regulatorMeter.GetEnergy()
+	if err != nil {
+		log.Printf("failed to read the regulator power: %v\n", err)
+		return 0
+	}
+	total := float64(0)
+	lock.Lock()
+	defer lock.Unlock()
+	currRailEnergy = energy
+	for rail, e := range energy {
+		railEnergy[rail] += e
+		if socTopology == nil || socTopology.IsLeaf(rail) {
+			total += e
+		}
+	}
+	return total
+}
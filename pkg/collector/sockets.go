@@ -0,0 +1,145 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"log"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl"
+)
+
+// Dual-socket edge servers run the containers on either package. When RAPL measures the cores of each
+// package, the CPU time part of the core energy is attributed per package, by the CPU time of the
+// containers on the CPUs of the package read from the per CPU time vector, instead of by their share of
+// the node CPU time.
+var (
+	// cpuPackages is the package of each CPU, nil on single package nodes
+	cpuPackages           map[int]int
+	lastPackageCoreEnergy map[int]uint64
+)
+
+func init() {
+	packages, err := rapl.GetCPUPackages()
+	if err != nil {
+		log.Printf("failed to read the CPU packages: %v\n", err)
+		return
+	}
+	distinct := map[int]bool{}
+	for _, pkg := range packages {
+		distinct[pkg] = true
+	}
+	if len(distinct) > 1 {
+		cpuPackages = packages
+	}
+}
+
+// readPackageCoreEnergy returns the core energy (mJ) of each package in the last interval, scaled to
+// sum to coreDelta, nil on single package nodes or when the packages are not measured separately
+func readPackageCoreEnergy(coreDelta float64) map[int]float64 {
+	if cpuPackages == nil {
+		return nil
+	}
+	energy, err := rapl.GetCoreEnergyPerPackage()
+	if err != nil || len(energy) < 2 {
+		lastPackageCoreEnergy = nil
+		return nil
+	}
+	last := lastPackageCoreEnergy
+	lastPackageCoreEnergy = energy
+	if last == nil {
+		return nil
+	}
+	delta, sum := map[int]float64{}, float64(0)
+	for pkg, e := range energy {
+		if l, ok := last[pkg]; ok && e >= l {
+			delta[pkg] = float64(e - l)
+			sum += delta[pkg]
+		}
+	}
+	if sum == 0 {
+		return nil
+	}
+	for pkg := range delta {
+		delta[pkg] *= coreDelta / sum
+	}
+	return delta
+}
+
+// accountPackageCPUTime adds the CPU time of a process on each package from its per CPU time vector,
+// weighted per core in the per-core mode
+func accountPackageCPUTime(v *ContainerEnergy, cpuTime []uint16, freq map[int32]uint64, maxFreq uint64) {
+	if cpuPackages == nil {
+		return
+	}
+	if v.CurrPackageCPUTime == nil {
+		v.CurrPackageCPUTime = map[int]float64{}
+	}
+	for cpu, t := range cpuTime {
+		if t == 0 {
+			continue
+		}
+		if pkg, ok := cpuPackages[cpu]; ok {
+			v.CurrPackageCPUTime[pkg] += coreWeight(cpu, t, freq, maxFreq)
+		}
+	}
+}
+
+// getPackageCPUTime returns the CPU time of all the containers on each package
+func getPackageCPUTime() map[int]float64 {
+	total := map[int]float64{}
+	for _, v := range containerEnergy {
+		for pkg, t := range v.CurrPackageCPUTime {
+			total[pkg] += t
+		}
+	}
+	return total
+}
+
+// packageCPUTimeEnergy returns the CPU time part of the core energy (mJ) of a container per package: its
+// share of the CPU time of each package, or of the node when no container ran on the package
+func packageCPUTimeEnergy(v *ContainerEnergy, packageDelta, packageCPUTime map[int]float64, aggCPUTime float64) map[int]float64 {
+	energy := map[int]float64{}
+	for pkg, e := range packageDelta {
+		share := float64(0)
+		if packageCPUTime[pkg] > 0 {
+			share = v.CurrPackageCPUTime[pkg] / packageCPUTime[pkg]
+		} else if aggCPUTime > 0 {
+			share = v.CurrCPUTime / aggCPUTime
+		}
+		if share > 0 {
+			energy[pkg] = share * e
+		}
+	}
+	return energy
+}
+
+// splitPerPackage splits the core energy of a container in proportion to its CPU time energy per package,
+// nil if it has none
+func splitPerPackage(core uint64, packageEnergy map[int]float64) map[int]uint64 {
+	sum := float64(0)
+	for _, e := range packageEnergy {
+		sum += e
+	}
+	if sum == 0 {
+		return nil
+	}
+	split := make(map[int]uint64, len(packageEnergy))
+	for pkg, e := range packageEnergy {
+		split[pkg] = uint64(float64(core) * e / sum)
+	}
+	return split
+}
@@ -0,0 +1,138 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"fmt"
+
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+// The processes outside of the pods are accounted to the catch-all entry of the system namespace, named
+// system_processes unless set per device. They are host OS services on the edge devices (NetworkManager,
+// sshd, the flotta agent), which may be split per systemd slice (system.slice, user-1000.slice) or per
+// systemd service (NetworkManager.service) instead, the processes outside of any slice or service staying
+// in the catch-all. Dropped, they are accounted to no entry and their energy is left unattributed.
+const (
+	SystemProcessesCatchAll = "catch-all"
+	SystemProcessesSlice    = "slice"
+	SystemProcessesUnit     = "unit"
+	SystemProcessesDrop     = "drop"
+)
+
+var (
+	systemProcesses = SystemProcessesCatchAll
+	// droppedProcesses sums the counters of the dropped system processes of a sample, they stay in the
+	// denominators of the ratio model so that their share of the energy is not given to the pods
+	droppedProcesses = newContainerEnergy("", "")
+)
+
+// SetSystemProcesses sets how the system processes are accounted, and the name of their catch-all entry
+// if not empty
+func SetSystemProcesses(mode, name string) error {
+	switch mode {
+	case SystemProcessesCatchAll, SystemProcessesSlice, SystemProcessesUnit, SystemProcessesDrop:
+	default:
+		return fmt.Errorf("unknown system processes accounting %q", mode)
+	}
+	if len(name) > 0 {
+		if err := pod_lister.SetSystemProcessName(name); err != nil {
+			return err
+		}
+	}
+	systemProcesses = mode
+	return nil
+}
+
+// systemProcessEntry returns the entry a system process is accounted to, false if it is dropped
+func systemProcessEntry(cgroupID uint64) (string, bool) {
+	switch systemProcesses {
+	case SystemProcessesDrop:
+		return "", false
+	case SystemProcessesSlice:
+		if slice, ok := pod_lister.GetSystemdSliceFromcGgroupID(cgroupID); ok {
+			return slice, true
+		}
+	case SystemProcessesUnit:
+		if unit, ok := pod_lister.GetSystemdUnitFromcGgroupID(cgroupID); ok {
+			return unit, true
+		}
+	}
+	return pod_lister.GetSystemProcessName(), true
+}
+
+// systemEntryMemory reads the memory of the slice or service entry of a cgroup
+func systemEntryMemory(cgroupID uint64, name string) (uint64, bool) {
+	read := pod_lister.ReadSystemdUnitMemory
+	switch systemProcesses {
+	case SystemProcessesSlice:
+		read = pod_lister.ReadSystemdSliceMemory
+	case SystemProcessesUnit:
+	default:
+		return 0, false
+	}
+	if entry, ok := systemProcessEntry(cgroupID); !ok || entry != name {
+		return 0, false
+	}
+	mem, err := read(cgroupID)
+	return mem, err == nil
+}
+
+// catchAllEntry returns the catch-all entry of the system processes, created when no system process was
+// sampled yet, nil if the system processes are dropped
+func catchAllEntry() *ContainerEnergy {
+	if systemProcesses == SystemProcessesDrop {
+		return nil
+	}
+	name := pod_lister.GetSystemProcessName()
+	if _, ok := containerEnergy[name]; !ok {
+		containerEnergy[name] = newContainerEnergy(name, pod_lister.GetSystemProcessNamespace())
+	}
+	return containerEnergy[name]
+}
+
+func resetDroppedProcesses() {
+	droppedProcesses = newContainerEnergy("", "")
+}
+
+// droppedProcessesEnergy returns the core and DRAM energy of the dropped system processes by the ratio model,
+// left unattributed
+func droppedProcessesEnergy(coreDelta, dramDelta, aggCPUTime, weightedAggCPUTime float64, aggCPUCycles, aggCPUInstr, aggCacheMisses uint64, packageCoreDelta, packageCPUTime map[int]float64) (float64, float64) {
+	v := droppedProcesses
+	core := float64(0)
+	if len(packageCoreDelta) > 0 && len(packageCPUTime) > 0 {
+		for _, e := range packageCPUTimeEnergy(v, packageCoreDelta, packageCPUTime, aggCPUTime) {
+			core += e * model.RunTimeCoeff.CPUTime
+		}
+	} else if weightedAggCPUTime > 0 {
+		core = v.CurrWeightedCPUTime / weightedAggCPUTime * coreDelta * model.RunTimeCoeff.CPUTime
+	} else if aggCPUTime > 0 {
+		core = v.CurrCPUTime / aggCPUTime * coreDelta * model.RunTimeCoeff.CPUTime
+	}
+	if aggCPUCycles > 0 {
+		core += float64(v.CurrCPUCycles) / float64(aggCPUCycles) * coreDelta * model.RunTimeCoeff.CPUCycle
+	}
+	if aggCPUInstr > 0 {
+		core += float64(v.CurrCPUInstr) / float64(aggCPUInstr) * coreDelta * model.RunTimeCoeff.CPUInstr
+	}
+	dram := float64(0)
+	if aggCacheMisses > 0 {
+		dram = float64(v.CurrCacheMisses) / float64(aggCacheMisses) * dramDelta * model.RunTimeCoeff.CacheMisses
+	}
+	return core, dram
+}
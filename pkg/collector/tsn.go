@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+// Industrial edge deployments run time-sensitive networking stacks (PTP clock synchronization,
+// gPTP, TSN schedulers) next to their application. They run on the host, so they are accounted
+// under a dedicated entry instead of being mixed with the other system processes.
+const (
+	tsnStackName = "tsn_stack"
+
+	ptpClassPath = "/sys/class/ptp"
+	netClassPath = "/sys/class/net"
+)
+
+type ptpClock struct {
+	name  string
+	iface string
+}
+
+var (
+	tsnCommands = []string{"ptp4l", "phc2sys", "ts2phc", "timemaster", "gptp", "daemon_cl", "pmc"}
+	// ptpClocks are the PTP hardware clocks and the network interface they belong to
+	ptpClocks = map[string]*ptpClock{}
+)
+
+func init() {
+	detectPTPClocks()
+}
+
+func isTSNStackProcess(command string) bool {
+	for _, c := range tsnCommands {
+		if command == c {
+			return true
+		}
+	}
+	return false
+}
+
+func detectPTPClocks() {
+	clocks, err := ioutil.ReadDir(ptpClassPath)
+	if err != nil {
+		return
+	}
+	for _, clock := range clocks {
+		name, _ := ioutil.ReadFile(filepath.Join(ptpClassPath, clock.Name(), "clock_name"))
+		ptpClocks[clock.Name()] = &ptpClock{name: strings.TrimSpace(string(name))}
+	}
+	ifaces, err := ioutil.ReadDir(netClassPath)
+	if err != nil {
+		return
+	}
+	for _, iface := range ifaces {
+		// NICs with a hardware clock list it in device/ptp, virtual clocks (e.g. kvm) have no interface
+		entries, err := ioutil.ReadDir(filepath.Join(netClassPath, iface.Name(), "device", "ptp"))
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if clock, ok := ptpClocks[e.Name()]; ok {
+				clock.iface = iface.Name()
+			}
+		}
+	}
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package collector
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The containers are tagged with a workload class from their activity over the last samples, so the fleet
+// energy can be analysed and the policies targeted per class:
+//   - ai-inference: GPU or accelerator energy in at least a fifth of the samples
+//   - batch: runs of several busy samples separated by idle periods, the periodic jobs
+//   - service: active nearly all the time, or in short bursts, e.g. serving requests
+const (
+	ClassUnknown     = "unknown"
+	ClassService     = "service"
+	ClassBatch       = "batch"
+	ClassAIInference = "ai-inference"
+
+	// weight of a sample in the moving averages, about the last 100 samples
+	classWeight     = 0.01
+	classMinSamples = 20
+	// a container using that fraction of a CPU is active, half a CPU busy
+	activeCPUShare = 0.01
+	busyCPUShare   = 0.5
+	// shares of the samples active for an always-on service, accelerated for an inference workload
+	alwaysOnShare    = 0.9
+	acceleratedShare = 0.2
+	// a batch run lasts that many busy samples at least, and repeats
+	batchMinRun  = 5
+	batchMinRuns = 2
+)
+
+var workloadClassDesc = prometheus.NewDesc(
+	"container_workload_class",
+	"Workload class of the container from its activity pattern, 1 for its class.",
+	[]string{"container_name", "container_namespace", "class"},
+	nil,
+)
+
+// classStats are the moving averages of the activity of a container
+type classStats struct {
+	samples     int
+	active      float64
+	accelerated float64
+	// run is the length of the current busy run, meanRun the mean length of the completed ones
+	run     int
+	runs    int
+	meanRun float64
+}
+
+// classifyContainer updates the activity of the container with its sample and its class
+func classifyContainer(v *ContainerEnergy, seconds float64) {
+	if seconds <= 0 {
+		return
+	}
+	if v.classStats == nil {
+		v.classStats = &classStats{}
+	}
+	s := v.classStats
+	/* CPU share = CPU time (ms) / 1000 / time(second) */
+	cpuShare := v.CurrCPUTime / 1000 / seconds
+	accelerated := v.CurrEnergyInGPU > 0
+	for _, e := range v.CurrEnergyInAccelerator {
+		accelerated = accelerated || e > 0
+	}
+	s.active += classWeight * (indicator(cpuShare >= activeCPUShare) - s.active)
+	s.accelerated += classWeight * (indicator(accelerated) - s.accelerated)
+	if s.samples == 0 {
+		s.active, s.accelerated = indicator(cpuShare >= activeCPUShare), indicator(accelerated)
+	}
+	s.samples++
+	if cpuShare >= busyCPUShare {
+		s.run++
+	} else if s.run > 0 {
+		s.runs++
+		s.meanRun += (float64(s.run) - s.meanRun) / float64(s.runs)
+		s.run = 0
+	}
+	v.Class = s.class()
+}
+
+func (s *classStats) class() string {
+	switch {
+	case s.samples < classMinSamples:
+		return ClassUnknown
+	case s.accelerated >= acceleratedShare:
+		return ClassAIInference
+	case s.active < alwaysOnShare && s.runs >= batchMinRuns && s.meanRun >= batchMinRun:
+		return ClassBatch
+	default:
+		return ClassService
+	}
+}
+
+func indicator(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// collectWorkloadClasses exposes the class of each container, the collector lock must be held
+func collectWorkloadClasses(ch chan<- prometheus.Metric) {
+	for name, v := range containerEnergy {
+		if len(v.Class) == 0 {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(workloadClassDesc, prometheus.GaugeValue, 1, name, v.Namespace, v.Class)
+	}
+}
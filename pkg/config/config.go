@@ -0,0 +1,155 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// The settings are taken from the command line flag, then the environment variable, then the YAML
+// config file, then the default. The config file is read again on SIGHUP, a setting given by a flag
+// or an environment variable is not changed by a reload.
+const (
+	DefaultPath = "/etc/kepler/kepler.yaml"
+
+	SamplePeriodEnv     = "KEPLER_SAMPLE_PERIOD"
+	DefaultSamplePeriod = 3 * time.Second
+	// the power meters and counters are not precise over shorter periods, the sample intervals
+	// longer than an hour are discarded by the power computations
+	MinSamplePeriod = time.Second
+	MaxSamplePeriod = 10 * time.Minute
+)
+
+type Config struct {
+	SamplePeriod time.Duration `yaml:"sample_period"`
+	// PlatformMeter is the BMC or the smart plug measuring the node power, read at startup only
+	PlatformMeter PlatformMeter `yaml:"platform_meter"`
+	// ContainerRuntime is the runtime inspected for the containers unknown to kubelet, read at startup only
+	ContainerRuntime ContainerRuntime `yaml:"container_runtime"`
+	// Units are the units and the locale of the dashboard, read at startup only
+	Units Units `yaml:"units"`
+}
+
+// PlatformMeter selects the BMC power reading of bare-metal servers, e.g.
+//
+//	platform_meter:
+//	  type: redfish
+//	  endpoint: https://10.0.0.10
+//	  username: monitor
+//	  password_file: /etc/kepler/bmc-password
+//
+// or of the smart plug the device is plugged in
+//
+//	platform_meter:
+//	  type: tasmota
+//	  endpoint: 192.168.1.50
+//
+// or of the power the plug publishes on an MQTT broker
+//
+//	platform_meter:
+//	  type: shelly
+//	  endpoint: tcp://broker:1883
+//	  topic: shellyplusplugs-a8032ab12345/status/switch:0
+type PlatformMeter struct {
+	// Type is ipmi (DCMI power reading with ipmitool), redfish, or a smart plug (tasmota, shelly or
+	// tplink), empty to use the ACPI power meter
+	Type string `yaml:"type"`
+	// Endpoint is the Redfish service root URL, the BMC host for IPMI over LAN (the local BMC if empty),
+	// or the address of the plug, or of the MQTT broker if Topic is set
+	Endpoint string `yaml:"endpoint"`
+	// Topic is the MQTT topic the Tasmota or Shelly plug publishes its power on, empty to poll its HTTP API
+	Topic        string `yaml:"topic"`
+	Username     string `yaml:"username"`
+	PasswordFile string `yaml:"password_file"`
+	// Chassis is the Redfish chassis id, the first chassis reporting its power if empty
+	Chassis            string        `yaml:"chassis"`
+	InsecureSkipVerify bool          `yaml:"insecure_skip_verify"`
+	PollInterval       time.Duration `yaml:"poll_interval"`
+}
+
+// ContainerRuntime selects the runtime API the containers are resolved with, e.g. the rootless podman
+// of the edge devices
+//
+//	container_runtime:
+//	  type: podman
+//	  socket: /run/user/1000/podman/podman.sock
+type ContainerRuntime struct {
+	// Type is crio, containerd, docker or podman, auto (or empty) to detect it from the default sockets
+	Type string `yaml:"type"`
+	// Socket is the API socket, the default socket of the runtime if empty
+	Socket string `yaml:"socket"`
+}
+
+// Units selects the units the dashboard shows the energies and the powers in, and the locale of its
+// numbers, e.g.
+//
+//	units:
+//	  energy: kWh
+//	  power: W
+//	  locale: de_DE
+type Units struct {
+	// Energy is mJ, J, kJ, Wh, kWh, MWh or BTU, J if empty
+	Energy string `yaml:"energy"`
+	// Power is mW, W, kW or BTU/h, W if empty
+	Power string `yaml:"power"`
+	// Locale sets the decimal and the thousands separators, the plain C number format if empty
+	Locale string `yaml:"locale"`
+}
+
+// Load reads the config file, a missing file is an empty config
+func Load(path string) (*Config, error) {
+	c := &Config{}
+	if len(path) == 0 {
+		return c, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err = yaml.UnmarshalStrict(data, c); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return c, nil
+}
+
+// GetSamplePeriod returns the sample period of the flag (if non zero), the environment or the config
+func (c *Config) GetSamplePeriod(flagValue time.Duration) (time.Duration, error) {
+	period := DefaultSamplePeriod
+	if flagValue > 0 {
+		period = flagValue
+	} else if s := os.Getenv(SamplePeriodEnv); len(s) > 0 {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s %q: %v", SamplePeriodEnv, s, err)
+		}
+		period = d
+	} else if c.SamplePeriod > 0 {
+		period = c.SamplePeriod
+	}
+	if period < MinSamplePeriod || period > MaxSamplePeriod {
+		return 0, fmt.Errorf("sample period %v is not between %v and %v", period, MinSamplePeriod, MaxSamplePeriod)
+	}
+	return period, nil
+}
@@ -0,0 +1,317 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+)
+
+// In gateway mode the exporter does not collect the local node, it scrapes the exporters of the
+// edge devices of several clusters and exposes them as a single target. Every series is tagged
+// with the cluster and site it comes from, so identical pod and device names do not collide.
+const (
+	clusterIDLabel = "cluster_id"
+	siteIDLabel    = "site_id"
+	// labels already set by the edge device are kept with this prefix, as prometheus does
+	exportedLabelPrefix = "exported_"
+	scrapeTimeout       = 5 * time.Second
+)
+
+// the energy and power per image of the edge devices are also summed over the fleet, keyed by image
+// and digest. The counter sums the last value of each series, a target failing a scrape or an image no
+// longer running on a device keeps its last value so that the sum never goes down.
+var fleetImageFamilies = map[string]*prometheus.Desc{
+	"image_energy_joule_total": prometheus.NewDesc(
+		"fleet_image_energy_joule_total",
+		"Energy consumed by the containers running the image over all the edge devices",
+		[]string{"image", "image_digest"},
+		nil,
+	),
+	"image_power_watts": prometheus.NewDesc(
+		"fleet_image_power_watts",
+		"Power of the containers running the image over all the edge devices",
+		[]string{"image", "image_digest"},
+		nil,
+	),
+}
+
+type Target struct {
+	URL       string `json:"url"`
+	ClusterID string `json:"cluster_id"`
+	SiteID    string `json:"site_id"`
+}
+
+type Config struct {
+	Targets      []Target      `json:"targets"`
+	MappingRules []MappingRule `json:"mapping_rules"`
+	Relabel      []RelabelRule `json:"relabel"`
+}
+
+type Gateway struct {
+	config *Config
+	client *http.Client
+
+	lock sync.Mutex
+	// imageCounters are the last values of the series of the per image counters, by family and series
+	imageCounters map[string]map[string]imageValue
+	// standby is set on the gateway replicas not elected leader, they do not scrape the targets
+	standby bool
+}
+
+type imageValue struct {
+	name, digest string
+	value        float64
+}
+
+type series struct {
+	labels map[string]string
+	metric *dto.Metric
+}
+
+type family struct {
+	help   string
+	typ    dto.MetricType
+	series []series
+}
+
+// LoadConfig reads the gateway configuration in JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for i := range config.MappingRules {
+		if err = config.MappingRules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	for i := range config.Relabel {
+		if err = config.Relabel[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func New(config *Config) *Gateway {
+	return &Gateway{
+		config:        config,
+		client:        &http.Client{Timeout: scrapeTimeout},
+		imageCounters: map[string]map[string]imageValue{},
+	}
+}
+
+// Describe sends no descriptor, the metrics are only known after scraping the targets
+func (g *Gateway) Describe(ch chan<- *prometheus.Desc) {
+}
+
+// SetStandby stops or resumes the scrapes of the targets, e.g. when the replica loses or takes the leadership
+func (g *Gateway) SetStandby(standby bool) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	g.standby = standby
+}
+
+func (g *Gateway) Collect(ch chan<- prometheus.Metric) {
+	g.lock.Lock()
+	standby := g.standby
+	g.lock.Unlock()
+	if standby {
+		return
+	}
+	families := map[string]*family{}
+	for _, target := range g.config.Targets {
+		mf, err := g.scrape(target.URL)
+		if err != nil {
+			log.Printf("failed to scrape %s: %v\n", target.URL, err)
+			continue
+		}
+		for name, f := range mf {
+			if _, ok := families[name]; !ok {
+				families[name] = &family{help: f.GetHelp(), typ: f.GetType()}
+			}
+			for _, m := range f.Metric {
+				if labels, ok := g.relabel(target, m.GetLabel()); ok {
+					families[name].series = append(families[name].series, series{labels: labels, metric: m})
+				}
+			}
+		}
+	}
+	for name, f := range families {
+		g.emit(ch, name, f)
+	}
+	for name, desc := range fleetImageFamilies {
+		g.emitFleetImages(ch, name, desc, families[name])
+	}
+}
+
+// emitFleetImages sums the series of a per image family over the edge devices, the counters from the last
+// value of every series seen
+func (g *Gateway) emitFleetImages(ch chan<- prometheus.Metric, name string, desc *prometheus.Desc, f *family) {
+	type image struct {
+		name, digest string
+	}
+	sums := map[image]float64{}
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	counters, isCounter := g.imageCounters[name]
+	if f != nil && f.typ == dto.MetricType_COUNTER && !isCounter {
+		counters, isCounter = map[string]imageValue{}, true
+		g.imageCounters[name] = counters
+	}
+	if f != nil {
+		for _, s := range f.series {
+			img := image{name: s.labels["image"], digest: s.labels["image_digest"]}
+			if isCounter {
+				counters[seriesKey(s.labels)] = imageValue{name: img.name, digest: img.digest, value: s.metric.GetCounter().GetValue()}
+			} else {
+				sums[img] += s.metric.GetGauge().GetValue()
+			}
+		}
+	}
+	valueType := prometheus.GaugeValue
+	if isCounter {
+		valueType = prometheus.CounterValue
+		for _, v := range counters {
+			sums[image{name: v.name, digest: v.digest}] += v.value
+		}
+	}
+	for img, value := range sums {
+		ch <- prometheus.MustNewConstMetric(desc, valueType, value, img.name, img.digest)
+	}
+}
+
+// seriesKey identifies a series by its sorted labels, the cluster and site tell the edge devices apart
+func seriesKey(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for l, v := range labels {
+		pairs = append(pairs, l+"="+v)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xff")
+}
+
+func (g *Gateway) scrape(url string) (map[string]*dto.MetricFamily, error) {
+	resp, err := g.client.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var parser expfmt.TextParser
+	return parser.TextToMetricFamilies(resp.Body)
+}
+
+// relabel tags the series with its cluster and site and applies the relabel rules
+func (g *Gateway) relabel(target Target, pairs []*dto.LabelPair) (map[string]string, bool) {
+	labels := make(map[string]string, len(pairs)+2)
+	for _, l := range pairs {
+		name := l.GetName()
+		if name == clusterIDLabel || name == siteIDLabel {
+			name = exportedLabelPrefix + name
+		}
+		labels[name] = l.GetValue()
+	}
+	labels[clusterIDLabel] = target.ClusterID
+	labels[siteIDLabel] = target.SiteID
+	for i := range g.config.MappingRules {
+		if g.config.MappingRules[i].apply(labels) {
+			break
+		}
+	}
+	for i := range g.config.Relabel {
+		if !g.config.Relabel[i].apply(labels) {
+			return nil, false
+		}
+	}
+	return labels, true
+}
+
+// emit exposes a family with the union of the label names of all its series,
+// since the targets can run different exporter versions
+func (g *Gateway) emit(ch chan<- prometheus.Metric, name string, f *family) {
+	names := map[string]bool{}
+	for _, s := range f.series {
+		for l := range s.labels {
+			names[l] = true
+		}
+	}
+	labelNames := make([]string, 0, len(names))
+	for l := range names {
+		labelNames = append(labelNames, l)
+	}
+	sort.Strings(labelNames)
+	desc := prometheus.NewDesc(name, f.help, labelNames, nil)
+
+	seen := map[string]bool{}
+	for _, s := range f.series {
+		values := make([]string, len(labelNames))
+		for i, l := range labelNames {
+			values[i] = s.labels[l]
+		}
+		key := strings.Join(values, "\xff")
+		if seen[key] {
+			log.Printf("dropping duplicated series %s%v, check the cluster and site mapping\n", name, s.labels)
+			continue
+		}
+		seen[key] = true
+		metric, err := newConstMetric(desc, f.typ, s.metric, values)
+		if err != nil {
+			log.Printf("failed to expose %s: %v\n", name, err)
+			continue
+		}
+		ch <- metric
+	}
+}
+
+func newConstMetric(desc *prometheus.Desc, typ dto.MetricType, m *dto.Metric, values []string) (prometheus.Metric, error) {
+	switch typ {
+	case dto.MetricType_COUNTER:
+		return prometheus.NewConstMetric(desc, prometheus.CounterValue, m.GetCounter().GetValue(), values...)
+	case dto.MetricType_GAUGE:
+		return prometheus.NewConstMetric(desc, prometheus.GaugeValue, m.GetGauge().GetValue(), values...)
+	case dto.MetricType_SUMMARY:
+		quantiles := map[float64]float64{}
+		for _, q := range m.GetSummary().GetQuantile() {
+			quantiles[q.GetQuantile()] = q.GetValue()
+		}
+		return prometheus.NewConstSummary(desc, m.GetSummary().GetSampleCount(), m.GetSummary().GetSampleSum(), quantiles, values...)
+	case dto.MetricType_HISTOGRAM:
+		buckets := map[float64]uint64{}
+		for _, b := range m.GetHistogram().GetBucket() {
+			buckets[b.GetUpperBound()] = b.GetCumulativeCount()
+		}
+		return prometheus.NewConstHistogram(desc, m.GetHistogram().GetSampleCount(), m.GetHistogram().GetSampleSum(), buckets, values...)
+	default:
+		return prometheus.NewConstMetric(desc, prometheus.UntypedValue, m.GetUntyped().GetValue(), values...)
+	}
+}
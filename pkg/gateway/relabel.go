@@ -0,0 +1,122 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gateway
+
+import (
+	"fmt"
+	"regexp"
+)
+
+const (
+	RelabelReplace   = "replace"
+	RelabelKeep      = "keep"
+	RelabelDrop      = "drop"
+	RelabelLabelDrop = "labeldrop"
+)
+
+// MappingRule assigns the cluster and site of the series whose source label matches the regex,
+// for targets aggregating devices of several clusters (e.g. a site level gateway)
+type MappingRule struct {
+	SourceLabel string `json:"source_label"`
+	Regex       string `json:"regex"`
+	ClusterID   string `json:"cluster_id"`
+	SiteID      string `json:"site_id"`
+
+	re *regexp.Regexp
+}
+
+// RelabelRule follows the prometheus relabel_config semantics, restricted to a single source label
+type RelabelRule struct {
+	SourceLabel string `json:"source_label"`
+	Regex       string `json:"regex"`
+	TargetLabel string `json:"target_label"`
+	Replacement string `json:"replacement"`
+	Action      string `json:"action"`
+
+	re *regexp.Regexp
+}
+
+func (r *MappingRule) compile() error {
+	re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid mapping rule regex %q: %v", r.Regex, err)
+	}
+	r.re = re
+	return nil
+}
+
+func (r *MappingRule) apply(labels map[string]string) bool {
+	if !r.re.MatchString(labels[r.SourceLabel]) {
+		return false
+	}
+	if len(r.ClusterID) > 0 {
+		labels[clusterIDLabel] = r.ClusterID
+	}
+	if len(r.SiteID) > 0 {
+		labels[siteIDLabel] = r.SiteID
+	}
+	return true
+}
+
+func (r *RelabelRule) compile() error {
+	if len(r.Action) == 0 {
+		r.Action = RelabelReplace
+	}
+	switch r.Action {
+	case RelabelReplace, RelabelKeep, RelabelDrop, RelabelLabelDrop:
+	default:
+		return fmt.Errorf("unknown relabel action %q", r.Action)
+	}
+	if len(r.Regex) == 0 {
+		r.Regex = "(.*)"
+	}
+	re, err := regexp.Compile("^(?:" + r.Regex + ")$")
+	if err != nil {
+		return fmt.Errorf("invalid relabel regex %q: %v", r.Regex, err)
+	}
+	r.re = re
+	return nil
+}
+
+// apply relabels in place and returns false if the series must be dropped
+func (r *RelabelRule) apply(labels map[string]string) bool {
+	switch r.Action {
+	case RelabelKeep:
+		return r.re.MatchString(labels[r.SourceLabel])
+	case RelabelDrop:
+		return !r.re.MatchString(labels[r.SourceLabel])
+	case RelabelLabelDrop:
+		for name := range labels {
+			if r.re.MatchString(name) {
+				delete(labels, name)
+			}
+		}
+	case RelabelReplace:
+		value := labels[r.SourceLabel]
+		match := r.re.FindStringSubmatchIndex(value)
+		if match == nil {
+			return true
+		}
+		replaced := string(r.re.ExpandString(nil, r.Replacement, value, match))
+		if len(replaced) == 0 {
+			delete(labels, r.TargetLabel)
+		} else {
+			labels[r.TargetLabel] = replaced
+		}
+	}
+	return true
+}
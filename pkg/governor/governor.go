@@ -0,0 +1,443 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package governor
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// The governor advisor observes the load of the device over a window, the CPU utilization of each
+// sample and the residency of the idle time in the deep C-states, and recommends the cpufreq governor
+// and the energy_performance_preference (EPP) of the intel_pstate and amd-pstate drivers for it. With
+// auto-tuning, the recommendation is applied at the end of the window, and the average node power of the
+// window before and of the window after is recorded, kept in the store.
+const (
+	DefaultWindow = time.Hour
+
+	LoadIdle      = "idle"
+	LoadBursty    = "bursty"
+	LoadModerate  = "moderate"
+	LoadSustained = "sustained"
+
+	cpuPath      = "/sys/devices/system/cpu"
+	procStatPath = "/proc/stat"
+	tuningKey    = "governor_tuning"
+	maxTunings   = 20
+	// the idle states from C2 (index 2, after POLL and C1) are deep
+	deepIdleState = 2
+)
+
+// Settings are the governor and the EPP of the CPUs, the EPP is empty without EPP support
+type Settings struct {
+	Governor string `json:"governor"`
+	EPP      string `json:"energy_performance_preference,omitempty"`
+}
+
+type Recommendation struct {
+	Start time.Time `json:"start"`
+	End   time.Time `json:"end"`
+	Load  string    `json:"load"`
+	// the utilizations are the busy share of the CPU time of the samples
+	AverageUtilization float64 `json:"average_utilization"`
+	P95Utilization     float64 `json:"p95_utilization"`
+	// DeepIdleResidency is the share of the idle time spent in the deep C-states
+	DeepIdleResidency float64  `json:"deep_idle_residency"`
+	AverageWatts      float64  `json:"average_watts"`
+	Current           Settings `json:"current"`
+	Recommended       Settings `json:"recommended"`
+	Reason            string   `json:"reason"`
+}
+
+// Tuning is an applied recommendation, with the node power of the windows before and after it
+type Tuning struct {
+	Time              time.Time `json:"time"`
+	From              Settings  `json:"from"`
+	To                Settings  `json:"to"`
+	Load              string    `json:"load"`
+	BeforeWatts       float64   `json:"before_watts"`
+	BeforeUtilization float64   `json:"before_utilization"`
+	AfterWatts        float64   `json:"after_watts"`
+	AfterUtilization  float64   `json:"after_utilization"`
+	Measured          bool      `json:"measured"`
+}
+
+type Report struct {
+	Recommendation *Recommendation `json:"recommendation"`
+	AutoTune       bool            `json:"auto_tune"`
+	Tunings        []Tuning        `json:"tunings"`
+}
+
+// cpuTimes are the busy and total jiffies of /proc/stat, and the idle state times in µs of cpuidle
+type cpuTimes struct {
+	busy, total    uint64
+	idle, deepIdle uint64
+}
+
+type Advisor struct {
+	lock     sync.Mutex
+	window   time.Duration
+	autoTune bool
+
+	start        time.Time
+	lastTime     time.Time
+	last         *cpuTimes
+	utilizations []float64
+	joules       float64
+	seconds      float64
+	idle         uint64
+	deepIdle     uint64
+
+	recommendation *Recommendation
+	tunings        []Tuning
+}
+
+// New returns an advisor recommending over window, applying the recommendations if autoTune is set
+func New(window time.Duration, autoTune bool) (*Advisor, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid governor window %v", window)
+	}
+	if _, err := readSettings(); err != nil {
+		return nil, err
+	}
+	a := &Advisor{window: window, autoTune: autoTune, tunings: []Tuning{}}
+	if _, err := store.Load(tuningKey, &a.tunings); err != nil {
+		log.Printf("failed to load the governor tunings: %v\n", err)
+	}
+	return a, nil
+}
+
+// Record accounts a sample, it is registered with collector.OnSample
+func (a *Advisor) Record(s *collector.Snapshot) {
+	times, err := readCPUTimes()
+	if err != nil {
+		log.Printf("failed to read the CPU times: %v\n", err)
+		return
+	}
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	last, lastTime := a.last, a.lastTime
+	a.last, a.lastTime = times, s.Time
+	if last == nil {
+		a.start = s.Time
+		return
+	}
+	if times.total > last.total && times.busy >= last.busy {
+		a.utilizations = append(a.utilizations, float64(times.busy-last.busy)/float64(times.total-last.total))
+	}
+	if times.idle >= last.idle && times.deepIdle >= last.deepIdle {
+		a.idle += times.idle - last.idle
+		a.deepIdle += times.deepIdle - last.deepIdle
+	}
+	n := s.EdgeDevice
+	/* energy (J) = energy (mJ) / 1000 */
+	a.joules += (n.EnergyInCore + n.EnergyInDram + n.EnergyInGPU + n.EnergyInOther) / 1000
+	a.seconds += s.Time.Sub(lastTime).Seconds()
+	if s.Time.Sub(a.start) >= a.window {
+		a.endWindow(s.Time)
+	}
+}
+
+// endWindow recommends the settings of the window, measures the pending tuning and applies the
+// recommendation with auto-tuning, the lock must be held
+func (a *Advisor) endWindow(now time.Time) {
+	r, err := a.recommend(now)
+	a.start, a.utilizations, a.joules, a.seconds, a.idle, a.deepIdle = now, nil, 0, 0, 0, 0
+	if err != nil {
+		log.Printf("failed to recommend the governor: %v\n", err)
+		return
+	}
+	a.recommendation = r
+	if n := len(a.tunings); n > 0 && !a.tunings[n-1].Measured {
+		t := &a.tunings[n-1]
+		t.AfterWatts, t.AfterUtilization, t.Measured = r.AverageWatts, r.AverageUtilization, true
+		log.Printf("governor %s/%s: %.2f W at %.0f%% utilization, from %.2f W at %.0f%% with %s/%s\n",
+			t.To.Governor, t.To.EPP, t.AfterWatts, 100*t.AfterUtilization, t.BeforeWatts, 100*t.BeforeUtilization, t.From.Governor, t.From.EPP)
+		a.save()
+	}
+	if !a.autoTune || r.Recommended == r.Current {
+		return
+	}
+	// the real-time containers and the isolated CPUs keep their frequency settings
+	excluded, all := collector.GetActuationExcludedCPUs()
+	if all {
+		log.Printf("not applying the governor %s, a container excluded from the actuation may run on any CPU\n", r.Recommended.Governor)
+		return
+	}
+	skipped, err := applySettings(r.Recommended, excluded)
+	if err != nil {
+		log.Printf("failed to apply the governor %s: %v\n", r.Recommended.Governor, err)
+		return
+	}
+	if skipped > 0 {
+		log.Printf("kept the settings of %d CPUs running isolated or excluded work\n", skipped)
+	}
+	log.Printf("applied the governor %s/%s for the %s load: %s\n", r.Recommended.Governor, r.Recommended.EPP, r.Load, r.Reason)
+	a.tunings = append(a.tunings, Tuning{
+		Time:              now,
+		From:              r.Current,
+		To:                r.Recommended,
+		Load:              r.Load,
+		BeforeWatts:       r.AverageWatts,
+		BeforeUtilization: r.AverageUtilization,
+	})
+	if len(a.tunings) > maxTunings {
+		a.tunings = a.tunings[len(a.tunings)-maxTunings:]
+	}
+	a.save()
+}
+
+func (a *Advisor) save() {
+	if err := store.Save(tuningKey, a.tunings); err != nil {
+		log.Printf("failed to save the governor tunings: %v\n", err)
+	}
+}
+
+// recommend returns the recommendation of the window ending at now, the lock must be held
+func (a *Advisor) recommend(now time.Time) (*Recommendation, error) {
+	if len(a.utilizations) == 0 || a.seconds <= 0 {
+		return nil, fmt.Errorf("no sample in the window")
+	}
+	current, err := readSettings()
+	if err != nil {
+		return nil, err
+	}
+	r := &Recommendation{Start: a.start, End: now, Current: current, AverageWatts: a.joules / a.seconds}
+	sum := float64(0)
+	for _, u := range a.utilizations {
+		sum += u
+	}
+	r.AverageUtilization = sum / float64(len(a.utilizations))
+	sorted := append([]float64(nil), a.utilizations...)
+	sort.Float64s(sorted)
+	r.P95Utilization = sorted[int(math.Ceil(0.95*float64(len(sorted))))-1]
+	if a.idle > 0 {
+		r.DeepIdleResidency = float64(a.deepIdle) / float64(a.idle)
+	}
+	r.Load = classifyLoad(r.AverageUtilization, r.P95Utilization)
+	governors := readList("cpu0/cpufreq/scaling_available_governors")
+	preferences := readList("cpu0/cpufreq/energy_performance_available_preferences")
+	r.Recommended, r.Reason = recommendSettings(r.Load, r.DeepIdleResidency, governors, preferences)
+	return r, nil
+}
+
+// classifyLoad returns the load of a window from the average and the p95 of its utilization
+func classifyLoad(average, p95 float64) string {
+	switch {
+	case average < 0.1 && p95 < 0.5:
+		return LoadIdle
+	case average < 0.3 && p95 >= 0.6:
+		return LoadBursty
+	case average >= 0.6:
+		return LoadSustained
+	}
+	return LoadModerate
+}
+
+// recommendSettings returns the settings of a load among the available governors and EPPs, with the reason.
+// The idle devices run at the lowest frequencies, the bursty ones ramp up fast on demand and settle back,
+// the loaded ones run at the efficient frequencies the EPP favors, the performance governor is never
+// recommended, it keeps the highest frequency of the idle CPUs as well.
+func recommendSettings(load string, deepIdleResidency float64, governors, preferences []string) (Settings, string) {
+	var preferred []string
+	var epp, reason string
+	switch load {
+	case LoadIdle:
+		preferred, epp = []string{"powersave", "schedutil", "conservative"}, "power"
+		reason = fmt.Sprintf("mostly idle, %.0f%% of the idle time in the deep C-states", 100*deepIdleResidency)
+		if deepIdleResidency < 0.5 {
+			reason += ", the frequent wakeups keep the CPUs out of the deep C-states"
+		}
+	case LoadBursty:
+		preferred, epp = []string{"schedutil", "ondemand", "powersave"}, "balance_power"
+		reason = "short bursts over a low average load, the frequency follows the bursts and settles back"
+	case LoadSustained:
+		preferred, epp = []string{"schedutil", "powersave", "ondemand"}, "balance_performance"
+		reason = "sustained load, the work completes at the efficient high frequencies"
+	default:
+		preferred, epp = []string{"schedutil", "powersave", "conservative", "ondemand"}, "balance_power"
+		reason = "moderate load, the frequency follows the load"
+	}
+	s := Settings{}
+	for _, g := range preferred {
+		if contains(governors, g) {
+			s.Governor = g
+			break
+		}
+	}
+	if len(s.Governor) == 0 && len(governors) > 0 {
+		s.Governor = governors[0]
+	}
+	// the EPP is only applied by the powersave governor of intel_pstate and amd-pstate in active mode
+	if contains(preferences, epp) && (s.Governor == "powersave" || s.Governor == "schedutil") {
+		s.EPP = epp
+	}
+	return s, reason
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// readList reads a space separated list of a sysfs file of the CPUs
+func readList(name string) []string {
+	data, err := ioutil.ReadFile(filepath.Join(cpuPath, name))
+	if err != nil {
+		return nil
+	}
+	return strings.Fields(string(data))
+}
+
+// readSettings returns the governor and the EPP of the first CPU
+func readSettings() (Settings, error) {
+	data, err := ioutil.ReadFile(filepath.Join(cpuPath, "cpu0/cpufreq/scaling_governor"))
+	if err != nil {
+		return Settings{}, fmt.Errorf("no cpufreq governor: %v", err)
+	}
+	s := Settings{Governor: strings.TrimSpace(string(data))}
+	if data, err = ioutil.ReadFile(filepath.Join(cpuPath, "cpu0/cpufreq/energy_performance_preference")); err == nil {
+		s.EPP = strings.TrimSpace(string(data))
+	}
+	return s, nil
+}
+
+// applySettings sets the governor, then the EPP, of the CPUs, skipping the cpufreq policies shared with an
+// excluded CPU. It returns the number of skipped policies.
+func applySettings(s Settings, excluded map[int32]bool) (int, error) {
+	dirs, _ := filepath.Glob(filepath.Join(cpuPath, "cpu[0-9]*/cpufreq"))
+	skipped := 0
+	for _, dir := range dirs {
+		if sharesExcludedCPU(dir, excluded) {
+			skipped++
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "scaling_governor"), []byte(s.Governor), 0644); err != nil {
+			return skipped, err
+		}
+		if len(s.EPP) == 0 {
+			continue
+		}
+		if err := ioutil.WriteFile(filepath.Join(dir, "energy_performance_preference"), []byte(s.EPP), 0644); err != nil {
+			return skipped, err
+		}
+	}
+	return skipped, nil
+}
+
+// sharesExcludedCPU returns whether a cpufreq policy covers an excluded CPU, the CPUs of a policy share their
+// frequency and governor
+func sharesExcludedCPU(dir string, excluded map[int32]bool) bool {
+	cpus := readList(filepath.Join(strings.TrimPrefix(dir, cpuPath), "related_cpus"))
+	if len(cpus) == 0 {
+		// the CPU of the cpuN/cpufreq directory
+		cpus = []string{strings.TrimPrefix(filepath.Base(filepath.Dir(dir)), "cpu")}
+	}
+	for _, c := range cpus {
+		cpu, err := strconv.Atoi(c)
+		if err != nil || excluded[int32(cpu)] {
+			return true
+		}
+	}
+	return false
+}
+
+// readCPUTimes reads the CPU times of /proc/stat and the idle state times of cpuidle
+func readCPUTimes() (*cpuTimes, error) {
+	f, err := os.Open(procStatPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	t := &cpuTimes{}
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty %s", procStatPath)
+	}
+	t.busy, t.total, err = parseCPUStat(scanner.Text())
+	if err != nil {
+		return nil, err
+	}
+	states, _ := filepath.Glob(filepath.Join(cpuPath, "cpu[0-9]*/cpuidle/state[0-9]*"))
+	for _, state := range states {
+		index, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(state), "state"))
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(state, "time"))
+		if err != nil {
+			continue
+		}
+		us, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			continue
+		}
+		t.idle += us
+		if index >= deepIdleState {
+			t.deepIdle += us
+		}
+	}
+	return t, nil
+}
+
+// parseCPUStat returns the busy and total jiffies of the cpu line of /proc/stat, the idle and iowait are not busy
+func parseCPUStat(line string) (uint64, uint64, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 5 || fields[0] != "cpu" {
+		return 0, 0, fmt.Errorf("invalid cpu line %q", line)
+	}
+	busy, total := uint64(0), uint64(0)
+	// user nice system idle iowait irq softirq steal, the guest times are in user and nice
+	for i, field := range fields[1:] {
+		if i >= 8 {
+			break
+		}
+		v, err := strconv.ParseUint(field, 10, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("invalid cpu line %q", line)
+		}
+		total += v
+		if i != 3 && i != 4 {
+			busy += v
+		}
+	}
+	return busy, total, nil
+}
+
+// Report returns the last recommendation and the tunings
+func (a *Advisor) Report() *Report {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return &Report{Recommendation: a.recommendation, AutoTune: a.autoTune, Tunings: append([]Tuning{}, a.tunings...)}
+}
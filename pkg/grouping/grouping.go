@@ -0,0 +1,244 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package grouping
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// Grouping rules map the containers to the services users think in (e.g. "video-analytics" spread
+// over a few pods), the energy is aggregated per service on each node. The series carry the node
+// name, so sum by (service) aggregates a service across the nodes.
+
+// Rule matches a container when all its regexes match, an empty regex matches anything. The
+// service may reference the capture groups of the pod regex, e.g. "$1".
+type Rule struct {
+	Service   string            `json:"service"`
+	Namespace string            `json:"namespace"`
+	Pod       string            `json:"pod"`
+	Command   string            `json:"command"`
+	Labels    map[string]string `json:"labels"`
+
+	namespace, pod, command *regexp.Regexp
+	labels                  map[string]*regexp.Regexp
+}
+
+type Config struct {
+	// Rules are evaluated in order, the first match wins
+	Rules []Rule `json:"rules"`
+	// DefaultService groups the containers matching no rule, they are left out if empty
+	DefaultService string `json:"default_service"`
+}
+
+type Grouper struct {
+	config *Config
+
+	lock   sync.Mutex
+	node   string
+	last   time.Time
+	energy map[string]float64
+	power  map[string]float64
+	counts map[string]int
+}
+
+var (
+	energyDesc = prometheus.NewDesc(
+		"service_energy_joule_total",
+		"Energy consumed by the containers grouped into the service",
+		[]string{
+			"service",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+	powerDesc = prometheus.NewDesc(
+		"service_power_watts",
+		"Power of the containers grouped into the service over the last sample",
+		[]string{
+			"service",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+	containersDesc = prometheus.NewDesc(
+		"service_containers",
+		"Number of containers grouped into the service in the last sample",
+		[]string{
+			"service",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+)
+
+// LoadConfig reads the grouping rules in JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for i := range config.Rules {
+		if err = config.Rules[i].compile(); err != nil {
+			return nil, err
+		}
+	}
+	return config, nil
+}
+
+func New(config *Config) *Grouper {
+	return &Grouper{
+		config: config,
+		energy: map[string]float64{},
+		power:  map[string]float64{},
+		counts: map[string]int{},
+	}
+}
+
+func compile(expr string) (*regexp.Regexp, error) {
+	if len(expr) == 0 {
+		expr = ".*"
+	}
+	re, err := regexp.Compile("^(?:" + expr + ")$")
+	if err != nil {
+		return nil, fmt.Errorf("invalid grouping regex %q: %v", expr, err)
+	}
+	return re, nil
+}
+
+func (r *Rule) compile() error {
+	if len(r.Service) == 0 {
+		return fmt.Errorf("grouping rule without service")
+	}
+	var err error
+	if r.namespace, err = compile(r.Namespace); err != nil {
+		return err
+	}
+	if r.pod, err = compile(r.Pod); err != nil {
+		return err
+	}
+	if r.command, err = compile(r.Command); err != nil {
+		return err
+	}
+	r.labels = map[string]*regexp.Regexp{}
+	for name, expr := range r.Labels {
+		if r.labels[name], err = compile(expr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// match returns the service of the container if the rule matches it
+func (r *Rule) match(c *collector.ContainerSnapshot) (string, bool) {
+	if !r.namespace.MatchString(c.Namespace) || !r.command.MatchString(c.Command) {
+		return "", false
+	}
+	for name, re := range r.labels {
+		// a missing label matches as an empty value, like in the prometheus matchers
+		if !re.MatchString(c.Labels[name]) {
+			return "", false
+		}
+	}
+	match := r.pod.FindStringSubmatchIndex(c.Name)
+	if match == nil {
+		return "", false
+	}
+	return string(r.pod.ExpandString(nil, r.Service, c.Name, match)), true
+}
+
+// Service returns the service of a container, false if it is not grouped
+func (g *Grouper) Service(c *collector.ContainerSnapshot) (string, bool) {
+	for i := range g.config.Rules {
+		if service, ok := g.config.Rules[i].match(c); ok && len(service) > 0 {
+			return service, true
+		}
+	}
+	return g.config.DefaultService, len(g.config.DefaultService) > 0
+}
+
+// Update aggregates the energy of a sample per service, it is registered with collector.OnSample
+func (g *Grouper) Update(s *collector.Snapshot) {
+	energy := map[string]float64{}
+	counts := map[string]int{}
+	for i := range s.Containers {
+		c := &s.Containers[i]
+		service, ok := g.Service(c)
+		if !ok {
+			continue
+		}
+		/* energy (mJ) of the sample */
+		e := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, a := range c.Accelerators {
+			e += a
+		}
+		energy[service] += float64(e)
+		counts[service]++
+	}
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	seconds := 0.0
+	if !g.last.IsZero() {
+		seconds = s.Time.Sub(g.last).Seconds()
+	}
+	g.node = s.EdgeDevice.Name
+	g.power = map[string]float64{}
+	for service, mJ := range energy {
+		g.energy[service] += mJ / 1000
+		if seconds > 0 {
+			g.power[service] = mJ / 1000 / seconds
+		}
+	}
+	g.counts = counts
+	g.last = s.Time
+}
+
+func (g *Grouper) Describe(ch chan<- *prometheus.Desc) {
+	ch <- energyDesc
+	ch <- powerDesc
+	ch <- containersDesc
+}
+
+func (g *Grouper) Collect(ch chan<- prometheus.Metric) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+	services := make([]string, 0, len(g.energy))
+	for service := range g.energy {
+		services = append(services, service)
+	}
+	sort.Strings(services)
+	for _, service := range services {
+		// a service whose containers are gone keeps its counter, with no power
+		ch <- prometheus.MustNewConstMetric(energyDesc, prometheus.CounterValue, g.energy[service], service, g.node)
+		ch <- prometheus.MustNewConstMetric(powerDesc, prometheus.GaugeValue, g.power[service], service, g.node)
+		ch <- prometheus.MustNewConstMetric(containersDesc, prometheus.GaugeValue, float64(g.counts[service]), service, g.node)
+	}
+}
@@ -0,0 +1,237 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package history
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// A device with 1GB of RAM cannot run prometheus next to its workloads. The history keeps a few series
+// of the samples in memory, one point per step, for the on-device queries, and saves them in the store
+// so that they survive a restart. The energies are counters in J, like the exported metrics.
+const (
+	DefaultRetention = 24 * time.Hour
+
+	NameLabel = "__name__"
+
+	historyKey = "history"
+	step       = time.Minute
+	savePeriod = 10 * time.Minute
+)
+
+// Point is a value at a unix time in ms
+type Point struct {
+	T int64   `json:"t"`
+	V float64 `json:"v"`
+}
+
+// Series is the points of a metric, in time order. The metric name is the __name__ label.
+type Series struct {
+	Metric map[string]string `json:"metric"`
+	Points []Point           `json:"points"`
+}
+
+type DB struct {
+	lock      sync.Mutex
+	retention time.Duration
+	series    map[string]*Series
+	// the running counters, appended every step
+	counters   map[string]float64
+	lastAppend time.Time
+	lastSave   time.Time
+}
+
+// New returns the history kept for retention, with the series saved in the store
+func New(retention time.Duration) *DB {
+	db := &DB{
+		retention: retention,
+		series:    map[string]*Series{},
+		counters:  map[string]float64{},
+	}
+	saved := []*Series{}
+	if _, err := store.Load(historyKey, &saved); err != nil {
+		log.Printf("failed to load the history: %v\n", err)
+	}
+	for _, s := range saved {
+		if len(s.Points) == 0 {
+			continue
+		}
+		key := seriesKey(s.Metric)
+		db.series[key] = s
+		if strings.HasSuffix(s.Metric[NameLabel], "_total") {
+			// the counters continue from their saved value
+			db.counters[key] = s.Points[len(s.Points)-1].V
+		}
+	}
+	return db
+}
+
+// seriesKey is the sorted labels of a metric
+func seriesKey(metric map[string]string) string {
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(metric[name])
+		b.WriteByte(',')
+	}
+	return b.String()
+}
+
+// Record adds a sample to the history, it is registered with collector.OnSample
+func (db *DB) Record(s *collector.Snapshot) {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	gauges := map[string]float64{}
+	metrics := map[string]map[string]string{}
+	add := func(counter bool, metric map[string]string, value float64) {
+		key := seriesKey(metric)
+		metrics[key] = metric
+		if counter {
+			db.counters[key] += value
+		} else {
+			gauges[key] = value
+		}
+	}
+
+	node := s.EdgeDevice
+	/* energy (J) = energy (mJ) / 1000 */
+	add(true, map[string]string{
+		NameLabel:         "node_energy_joule_total",
+		"EdgeDevice_name": node.Name,
+	}, (node.EnergyInCore+node.EnergyInDram+node.EnergyInGPU+node.EnergyInOther)/1000)
+	for _, c := range s.Containers {
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, e := range c.Accelerators {
+			energy += e
+		}
+		add(true, map[string]string{
+			NameLabel:             "container_energy_joule_total",
+			"container_name":      c.Name,
+			"container_namespace": c.Namespace,
+		}, float64(energy)/1000)
+		add(true, map[string]string{
+			NameLabel:             "container_cpu_time_seconds_total",
+			"container_name":      c.Name,
+			"container_namespace": c.Namespace,
+		}, c.CPUTime)
+	}
+	if node.Solar != nil {
+		add(false, map[string]string{NameLabel: "node_solar_pv_power_watts", "EdgeDevice_name": node.Name}, node.Solar.PVPower)
+		if node.Solar.BatterySoC >= 0 {
+			add(false, map[string]string{NameLabel: "node_battery_soc_percent", "EdgeDevice_name": node.Name}, node.Solar.BatterySoC)
+		}
+	}
+
+	if s.Time.Sub(db.lastAppend) < step {
+		return
+	}
+	db.lastAppend = s.Time
+	t := s.Time.UnixNano() / int64(time.Millisecond)
+	for key, metric := range metrics {
+		v, ok := gauges[key]
+		if !ok {
+			v = db.counters[key]
+		}
+		series, ok := db.series[key]
+		if !ok {
+			series = &Series{Metric: metric}
+			db.series[key] = series
+		}
+		series.Points = append(series.Points, Point{T: t, V: v})
+	}
+	db.expire(t)
+	if s.Time.Sub(db.lastSave) >= savePeriod {
+		db.lastSave = s.Time
+		db.save()
+	}
+}
+
+// expire drops the points older than the retention, the lock must be held
+func (db *DB) expire(now int64) {
+	mint := now - int64(db.retention/time.Millisecond)
+	for key, series := range db.series {
+		i := sort.Search(len(series.Points), func(i int) bool {
+			return series.Points[i].T >= mint
+		})
+		if i == len(series.Points) {
+			delete(db.series, key)
+			delete(db.counters, key)
+			continue
+		}
+		series.Points = append(series.Points[:0:0], series.Points[i:]...)
+	}
+}
+
+// Save writes the history to the store, it is registered with collector.OnFlush
+func (db *DB) Save() {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	db.save()
+}
+
+func (db *DB) save() {
+	series := make([]*Series, 0, len(db.series))
+	for _, s := range db.series {
+		series = append(series, s)
+	}
+	if err := store.Save(historyKey, series); err != nil {
+		log.Printf("failed to save the history: %v\n", err)
+	}
+}
+
+// Select returns a copy of the series matching match, with their points in [mint, maxt]
+func (db *DB) Select(mint, maxt int64, match func(metric map[string]string) bool) []Series {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	result := []Series{}
+	for _, series := range db.series {
+		if !match(series.Metric) {
+			continue
+		}
+		start := sort.Search(len(series.Points), func(i int) bool {
+			return series.Points[i].T >= mint
+		})
+		end := sort.Search(len(series.Points), func(i int) bool {
+			return series.Points[i].T > maxt
+		})
+		if start == end {
+			continue
+		}
+		metric := make(map[string]string, len(series.Metric))
+		for name, value := range series.Metric {
+			metric[name] = value
+		}
+		result = append(result, Series{
+			Metric: metric,
+			Points: append([]Point{}, series.Points[start:end]...),
+		})
+	}
+	return result
+}
@@ -0,0 +1,158 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package images
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The energy of the pods is aggregated per container image, so the images consuming the most energy
+// are visible whatever the pods running them. The energy of a pod is split evenly among its
+// containers, the per container energy is not measured. The gateway sums the series of the fleet.
+
+// image identifies an image by the name it was pulled by and its digest
+type image struct {
+	name, digest string
+}
+
+type Aggregator struct {
+	lock   sync.Mutex
+	node   string
+	last   time.Time
+	energy map[image]float64
+	power  map[image]float64
+	pods   map[image]int
+}
+
+var (
+	energyDesc = prometheus.NewDesc(
+		"image_energy_joule_total",
+		"Energy consumed by the containers running the image",
+		[]string{
+			"image",
+			"image_digest",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+	powerDesc = prometheus.NewDesc(
+		"image_power_watts",
+		"Power of the containers running the image over the last sample",
+		[]string{
+			"image",
+			"image_digest",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+	podsDesc = prometheus.NewDesc(
+		"image_pods",
+		"Number of pods running the image in the last sample",
+		[]string{
+			"image",
+			"image_digest",
+			"EdgeDevice_name",
+		},
+		nil,
+	)
+)
+
+func New() *Aggregator {
+	return &Aggregator{
+		energy: map[image]float64{},
+		power:  map[image]float64{},
+		pods:   map[image]int{},
+	}
+}
+
+// Update aggregates the energy of a sample per image, it is registered with collector.OnSample
+func (a *Aggregator) Update(s *collector.Snapshot) {
+	energy := map[image]float64{}
+	pods := map[image]int{}
+	for i := range s.Containers {
+		c := &s.Containers[i]
+		// the system processes and the pods not listed yet have no image
+		if len(c.Images) == 0 {
+			continue
+		}
+		/* energy (mJ) of the sample */
+		e := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, acc := range c.Accelerators {
+			e += acc
+		}
+		share := float64(e) / float64(len(c.Images))
+		seen := map[image]bool{}
+		for _, ref := range c.Images {
+			img := image{name: ref.Name, digest: ref.Digest}
+			energy[img] += share
+			if !seen[img] {
+				pods[img]++
+				seen[img] = true
+			}
+		}
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	seconds := 0.0
+	if !a.last.IsZero() {
+		seconds = s.Time.Sub(a.last).Seconds()
+	}
+	a.node = s.EdgeDevice.Name
+	a.power = map[image]float64{}
+	for img, mJ := range energy {
+		a.energy[img] += mJ / 1000
+		if seconds > 0 {
+			a.power[img] = mJ / 1000 / seconds
+		}
+	}
+	a.pods = pods
+	a.last = s.Time
+}
+
+func (a *Aggregator) Describe(ch chan<- *prometheus.Desc) {
+	ch <- energyDesc
+	ch <- powerDesc
+	ch <- podsDesc
+}
+
+func (a *Aggregator) Collect(ch chan<- prometheus.Metric) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	images := make([]image, 0, len(a.energy))
+	for img := range a.energy {
+		images = append(images, img)
+	}
+	sort.Slice(images, func(i, j int) bool {
+		if images[i].name != images[j].name {
+			return images[i].name < images[j].name
+		}
+		return images[i].digest < images[j].digest
+	})
+	for _, img := range images {
+		// an image no longer running keeps its counter, with no power
+		ch <- prometheus.MustNewConstMetric(energyDesc, prometheus.CounterValue, a.energy[img], img.name, img.digest, a.node)
+		ch <- prometheus.MustNewConstMetric(powerDesc, prometheus.GaugeValue, a.power[img], img.name, img.digest, a.node)
+		ch <- prometheus.MustNewConstMetric(podsDesc, prometheus.GaugeValue, float64(a.pods[img]), img.name, img.digest, a.node)
+	}
+}
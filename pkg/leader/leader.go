@@ -0,0 +1,281 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package leader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// When the exporter runs as a DaemonSet, every instance collects its own node but the cluster level
+// work (rollups, CRD writes) must be done once. The instances elect a leader with a coordination.k8s.io
+// Lease, the same way the kubernetes controllers do: the holder renews the lease, the others take it
+// over once it has not been renewed for leaseDuration. The renewals are timed with the local clock, from
+// when this instance saw the lease record change, since the clocks of the edge nodes may be skewed.
+const (
+	DefaultLeaseName = "kepler-aggregator"
+
+	leaseDuration = 15 * time.Second
+	retryPeriod   = 2 * time.Second
+	microTime     = "2006-01-02T15:04:05.000000Z07:00"
+
+	saTokenPath     = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	saCAPath        = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	saNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+	podNameEnv      = "POD_NAME"
+)
+
+type leaseMeta struct {
+	Name            string `json:"name"`
+	Namespace       string `json:"namespace"`
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+}
+
+type leaseSpec struct {
+	HolderIdentity       string `json:"holderIdentity"`
+	LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+	AcquireTime          string `json:"acquireTime,omitempty"`
+	RenewTime            string `json:"renewTime,omitempty"`
+	LeaseTransitions     int    `json:"leaseTransitions"`
+}
+
+type lease struct {
+	APIVersion string    `json:"apiVersion"`
+	Kind       string    `json:"kind"`
+	Metadata   leaseMeta `json:"metadata"`
+	Spec       leaseSpec `json:"spec"`
+}
+
+var (
+	identity  string
+	leaseURL  string
+	isLeader  bool
+	lastRenew time.Time
+	started   []func()
+	stopped   []func()
+	lock      sync.Mutex
+	client    *http.Client
+	// observedRecord is the last lease spec read, observedTime the local time it was first read
+	observedRecord leaseSpec
+	observedTime   time.Time
+)
+
+// DefaultNamespace returns the namespace of the exporter service account
+func DefaultNamespace() string {
+	ns, err := ioutil.ReadFile(saNamespacePath)
+	if err != nil {
+		return "default"
+	}
+	return strings.TrimSpace(string(ns))
+}
+
+// OnStartedLeading registers a function called when this instance becomes the leader
+func OnStartedLeading(f func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	started = append(started, f)
+}
+
+// OnStoppedLeading registers a function called when this instance loses the leadership
+func OnStoppedLeading(f func()) {
+	lock.Lock()
+	defer lock.Unlock()
+	stopped = append(stopped, f)
+}
+
+func IsLeader() bool {
+	lock.Lock()
+	defer lock.Unlock()
+	return isLeader
+}
+
+// Run starts the election for the lease namespace/name in the background
+func Run(namespace, name string) error {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	if len(host) == 0 {
+		return fmt.Errorf("not running in a kubernetes cluster")
+	}
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(port) == 0 {
+		port = "443"
+	}
+	identity = os.Getenv(podNameEnv)
+	if len(identity) == 0 {
+		identity, _ = os.Hostname()
+	}
+	leaseURL = "https://" + host + ":" + port + "/apis/coordination.k8s.io/v1/namespaces/" + namespace + "/leases"
+	var err error
+	if client, err = newClient(); err != nil {
+		return err
+	}
+	supervisor.Go("leader", func() {
+		ticker := time.NewTicker(retryPeriod)
+		for {
+			acquired, err := tryAcquireOrRenew(namespace, name, time.Now())
+			if err != nil {
+				log.Printf("failed to acquire or renew lease %s/%s: %v\n", namespace, name, err)
+			}
+			setLeader(acquired, time.Now())
+			<-ticker.C
+		}
+	})
+	return nil
+}
+
+func setLeader(acquired bool, now time.Time) {
+	lock.Lock()
+	if acquired {
+		lastRenew = now
+	} else if isLeader && now.Sub(lastRenew) < leaseDuration {
+		// a failed renewal is retried until the lease expires, the others cannot take it before
+		acquired = true
+	}
+	changed := acquired != isLeader
+	isLeader = acquired
+	callbacks := stopped
+	if acquired {
+		callbacks = started
+	}
+	lock.Unlock()
+	if !changed {
+		return
+	}
+	if acquired {
+		log.Printf("%s is now the leader\n", identity)
+	} else {
+		log.Printf("%s lost the leadership\n", identity)
+	}
+	for _, f := range callbacks {
+		f()
+	}
+}
+
+func tryAcquireOrRenew(namespace, name string, now time.Time) (bool, error) {
+	current := &lease{}
+	status, err := request("GET", leaseURL+"/"+name, nil, current)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusNotFound {
+		l := &lease{
+			APIVersion: "coordination.k8s.io/v1",
+			Kind:       "Lease",
+			Metadata:   leaseMeta{Name: name, Namespace: namespace},
+			Spec: leaseSpec{
+				HolderIdentity:       identity,
+				LeaseDurationSeconds: int(leaseDuration.Seconds()),
+				AcquireTime:          now.Format(microTime),
+				RenewTime:            now.Format(microTime),
+			},
+		}
+		status, err = request("POST", leaseURL, l, nil)
+		return err == nil && status == http.StatusCreated, err
+	}
+	if status != http.StatusOK {
+		return false, fmt.Errorf("unexpected status %d", status)
+	}
+
+	if current.Spec != observedRecord {
+		observedRecord, observedTime = current.Spec, now
+	}
+	if current.Spec.HolderIdentity != identity {
+		duration := time.Duration(current.Spec.LeaseDurationSeconds) * time.Second
+		if len(current.Spec.HolderIdentity) > 0 && now.Before(observedTime.Add(duration)) {
+			// held by another instance
+			return false, nil
+		}
+		current.Spec.HolderIdentity = identity
+		current.Spec.AcquireTime = now.Format(microTime)
+		current.Spec.LeaseTransitions++
+	}
+	current.Spec.LeaseDurationSeconds = int(leaseDuration.Seconds())
+	current.Spec.RenewTime = now.Format(microTime)
+	// the update fails with a conflict if another instance updated the lease since our read
+	status, err = request("PUT", leaseURL+"/"+name, current, nil)
+	if err != nil {
+		return false, err
+	}
+	if status == http.StatusOK {
+		observedRecord, observedTime = current.Spec, now
+	}
+	return status == http.StatusOK, nil
+}
+
+// newClient returns the client of the API server, verified with the service account CA
+func newClient() (*http.Client, error) {
+	ca, err := ioutil.ReadFile(saCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %q: %v", saCAPath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %q", saCAPath)
+	}
+	return &http.Client{
+		Timeout:   retryPeriod,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+	}, nil
+}
+
+func request(method, url string, in, out interface{}) (int, error) {
+	token, err := ioutil.ReadFile(saTokenPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read from %q: %v", saTokenPath, err)
+	}
+	var body []byte
+	if in != nil {
+		if body, err = json.Marshal(in); err != nil {
+			return 0, err
+		}
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Add("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Add("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get response from %q: %v", url, err)
+	}
+	defer resp.Body.Close()
+	// the body is read to the end, so that the connection is reused
+	defer func() { _, _ = io.Copy(ioutil.Discard, resp.Body) }()
+	if out != nil && resp.StatusCode == http.StatusOK {
+		data, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read response body: %v", err)
+		}
+		if err = json.Unmarshal(data, out); err != nil {
+			return 0, fmt.Errorf("failed to parse response body: %v", err)
+		}
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package logging sets the verbosity and the backend of the structured logs, written with klog: its
+// text format by default, or JSON lines for the log collectors
+package logging
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	Text = "text"
+	JSON = "json"
+)
+
+// Setup sets the verbosity of the logs, klog -v, and their format
+func Setup(format string, verbosity int) error {
+	flags := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(flags)
+	if err := flags.Set("v", strconv.Itoa(verbosity)); err != nil {
+		return fmt.Errorf("failed to set the log verbosity: %v", err)
+	}
+	switch format {
+	case Text:
+	case JSON:
+		// klog filters the levels before calling the backend, which must not filter them again
+		klog.SetLogger(funcr.NewJSON(func(obj string) {
+			fmt.Fprintln(os.Stderr, obj)
+		}, funcr.Options{LogTimestamp: true, Verbosity: verbosity}))
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	return nil
+}
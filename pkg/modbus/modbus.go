@@ -0,0 +1,200 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package modbus
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The factory SCADA systems read the node power with Modbus TCP. The server answers the read holding
+// registers (0x03) and read input registers (0x04) functions with the same read-only register map,
+// updated after each sample. 32 bits values are two registers, high word first.
+//
+//	0      map version (1)
+//	1-2    node power, 0.1 W
+//	3-4    node energy since the exporter started, Wh
+//	5      core power, 0.1 W
+//	6      dram power, 0.1 W
+//	7      gpu power, 0.1 W
+//	8      other power, 0.1 W
+//	9      number of top consumers (up to 10)
+//	10-209 top consumers by power, 20 registers each:
+//	         +0-1   power, 0.1 W
+//	         +2-3   energy since the exporter started, Wh
+//	         +4-19  namespace/name, ASCII, 2 characters per register, NUL padded
+const (
+	mapVersion = 1
+
+	nodePowerRegister     = 1
+	nodeEnergyRegister    = 3
+	corePowerRegister     = 5
+	dramPowerRegister     = 6
+	gpuPowerRegister      = 7
+	otherPowerRegister    = 8
+	consumerCountRegister = 9
+	consumersRegister     = 10
+	maxConsumers          = 10
+	registerCount         = consumersRegister + maxConsumers*consumerRegisters
+
+	// offsets in the registers of a consumer
+	consumerEnergyRegister = 2
+	consumerNameRegister   = 4
+	consumerNameRegisters  = 16
+	consumerRegisters      = 20
+
+	joulePerWattHour     = 3600
+	deciWattPerMilliWatt = 0.01
+)
+
+type Server struct {
+	lock      sync.Mutex
+	registers []uint16
+
+	lastSample     time.Time
+	nodeEnergy     float64
+	consumerEnergy map[string]float64
+}
+
+func New() *Server {
+	s := &Server{
+		registers:      make([]uint16, registerCount),
+		consumerEnergy: map[string]float64{},
+	}
+	s.registers[0] = mapVersion
+	return s
+}
+
+type consumer struct {
+	name  string
+	power float64
+}
+
+// Update refreshes the registers with a sample, it is registered with collector.OnSample
+func (s *Server) Update(snapshot *collector.Snapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.lastSample.IsZero() {
+		// the first sample has no interval to compute the power over
+		s.lastSample = snapshot.Time
+		return
+	}
+	seconds := snapshot.Time.Sub(s.lastSample).Seconds()
+	s.lastSample = snapshot.Time
+	if seconds <= 0 {
+		return
+	}
+
+	/* power (mW) = energy (mJ) / time(second) */
+	node := snapshot.EdgeDevice
+	corePower := node.EnergyInCore / seconds
+	dramPower := node.EnergyInDram / seconds
+	gpuPower := node.EnergyInGPU / seconds
+	otherPower := node.EnergyInOther / seconds
+	nodePower := corePower + dramPower + gpuPower + otherPower
+	s.nodeEnergy += (node.EnergyInCore + node.EnergyInDram + node.EnergyInGPU + node.EnergyInOther) / 1000
+	s.setUint32(nodePowerRegister, nodePower*deciWattPerMilliWatt)
+	s.setUint32(nodeEnergyRegister, s.nodeEnergy/joulePerWattHour)
+	s.registers[corePowerRegister] = toUint16(corePower * deciWattPerMilliWatt)
+	s.registers[dramPowerRegister] = toUint16(dramPower * deciWattPerMilliWatt)
+	s.registers[gpuPowerRegister] = toUint16(gpuPower * deciWattPerMilliWatt)
+	s.registers[otherPowerRegister] = toUint16(otherPower * deciWattPerMilliWatt)
+
+	consumers := make([]consumer, 0, len(snapshot.Containers))
+	// the containers gone since the last sample are dropped
+	consumerEnergy := make(map[string]float64, len(snapshot.Containers))
+	for _, c := range snapshot.Containers {
+		name := c.Namespace + "/" + c.Name
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		consumerEnergy[name] = s.consumerEnergy[name] + float64(energy)/1000
+		consumers = append(consumers, consumer{name: name, power: float64(energy) / seconds})
+	}
+	s.consumerEnergy = consumerEnergy
+	sort.Slice(consumers, func(i, j int) bool {
+		if consumers[i].power != consumers[j].power {
+			return consumers[i].power > consumers[j].power
+		}
+		return consumers[i].name < consumers[j].name
+	})
+	if len(consumers) > maxConsumers {
+		consumers = consumers[:maxConsumers]
+	}
+	s.registers[consumerCountRegister] = uint16(len(consumers))
+	for i := 0; i < maxConsumers; i++ {
+		base := consumersRegister + i*consumerRegisters
+		if i >= len(consumers) {
+			for r := base; r < base+consumerRegisters; r++ {
+				s.registers[r] = 0
+			}
+			continue
+		}
+		c := consumers[i]
+		s.setUint32(base, c.power*deciWattPerMilliWatt)
+		s.setUint32(base+consumerEnergyRegister, s.consumerEnergy[c.name]/joulePerWattHour)
+		s.setString(base+consumerNameRegister, consumerNameRegisters, c.name)
+	}
+}
+
+func (s *Server) setUint32(register int, value float64) {
+	v := uint32(0)
+	if value > 0 && value < float64(^uint32(0)) {
+		v = uint32(value)
+	} else if value > 0 {
+		v = ^uint32(0)
+	}
+	s.registers[register] = uint16(v >> 16)
+	s.registers[register+1] = uint16(v)
+}
+
+func (s *Server) setString(register, count int, value string) {
+	if len(value) > count*2 {
+		value = value[:count*2]
+	}
+	for i := 0; i < count; i++ {
+		var hi, lo byte
+		if 2*i < len(value) {
+			hi = value[2*i]
+		}
+		if 2*i+1 < len(value) {
+			lo = value[2*i+1]
+		}
+		s.registers[register+i] = uint16(hi)<<8 | uint16(lo)
+	}
+}
+
+func toUint16(value float64) uint16 {
+	if value <= 0 {
+		return 0
+	}
+	if value > float64(^uint16(0)) {
+		return ^uint16(0)
+	}
+	return uint16(value)
+}
+
+// read returns a copy of count registers from address, false if out of the map
+func (s *Server) read(address, count int) ([]uint16, bool) {
+	if address+count > len(s.registers) {
+		return nil, false
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return append([]uint16{}, s.registers[address:address+count]...), true
+}
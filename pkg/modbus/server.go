@@ -0,0 +1,124 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package modbus
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// A Modbus TCP frame is the MBAP header (transaction id, protocol id 0, length of the unit id and the
+// PDU, unit id) followed by the PDU (function code and data).
+const (
+	mbapHeaderLength = 7
+	maxPDULength     = 253
+	// idle SCADA connections are closed, the clients reconnect
+	idleTimeout = 5 * time.Minute
+
+	readHoldingRegisters = 0x03
+	readInputRegisters   = 0x04
+	maxReadQuantity      = 125
+
+	exceptionFlag               = 0x80
+	exceptionIllegalFunction    = 0x01
+	exceptionIllegalDataAddress = 0x02
+	exceptionIllegalDataValue   = 0x03
+)
+
+// ListenAndServe answers the Modbus TCP requests on address (e.g. ":502") in the background
+func (s *Server) ListenAndServe(address string) error {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+	supervisor.Go("modbus", func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				log.Printf("failed to accept modbus connection: %v\n", err)
+				return
+			}
+			go func() {
+				if !supervisor.Call("modbus", func() { s.serve(conn) }) {
+					conn.Close()
+				}
+			}()
+		}
+	})
+	return nil
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer conn.Close()
+	header := make([]byte, mbapHeaderLength)
+	for {
+		_ = conn.SetReadDeadline(time.Now().Add(idleTimeout))
+		if _, err := io.ReadFull(conn, header); err != nil {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(header[4:6]))
+		if binary.BigEndian.Uint16(header[2:4]) != 0 || length < 2 || length > maxPDULength+1 {
+			log.Printf("invalid modbus frame from %s\n", conn.RemoteAddr())
+			return
+		}
+		pdu := make([]byte, length-1)
+		if _, err := io.ReadFull(conn, pdu); err != nil {
+			return
+		}
+		response := s.handle(pdu)
+		frame := make([]byte, mbapHeaderLength, mbapHeaderLength+len(response))
+		copy(frame, header)
+		binary.BigEndian.PutUint16(frame[4:6], uint16(len(response)+1))
+		frame = append(frame, response...)
+		if _, err := conn.Write(frame); err != nil {
+			return
+		}
+	}
+}
+
+// handle returns the response PDU of a request PDU
+func (s *Server) handle(pdu []byte) []byte {
+	function := pdu[0]
+	if function != readHoldingRegisters && function != readInputRegisters {
+		return []byte{function | exceptionFlag, exceptionIllegalFunction}
+	}
+	if len(pdu) != 5 {
+		return []byte{function | exceptionFlag, exceptionIllegalDataValue}
+	}
+	address := int(binary.BigEndian.Uint16(pdu[1:3]))
+	quantity := int(binary.BigEndian.Uint16(pdu[3:5]))
+	if quantity < 1 || quantity > maxReadQuantity {
+		return []byte{function | exceptionFlag, exceptionIllegalDataValue}
+	}
+	registers, ok := s.read(address, quantity)
+	if !ok {
+		return []byte{function | exceptionFlag, exceptionIllegalDataAddress}
+	}
+	response := make([]byte, 2, 2+2*quantity)
+	response[0] = function
+	response[1] = byte(2 * quantity)
+	for _, r := range registers {
+		response = append(response, byte(r>>8), byte(r))
+	}
+	return response
+}
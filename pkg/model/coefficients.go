@@ -0,0 +1,109 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
+)
+
+// The coefficients tuned for a Xeon are badly wrong on the Atom-class and ARM edge CPUs. The bundle shipped
+// with the exporter (data/coefficients.yaml) sets the bare-metal coefficients per CPU architecture, as named
+// by source.GetCPUArchitecture, or per Go architecture (arm64) for the CPUs it does not know, and the GPU
+// coefficients per GPU model. It is loaded at init, a hardware profile still overrides it.
+const DefaultCoefficientsPath = "/var/lib/kepler/data/coefficients.yaml"
+
+// CoeffBundle is the CPU coefficients by CPU architecture, and the GPU coefficients by GPU model glob
+// pattern (path.Match syntax)
+type CoeffBundle struct {
+	CPU map[string]Coeff `json:"cpu" yaml:"cpu"`
+	GPU map[string]Coeff `json:"gpu" yaml:"gpu"`
+}
+
+var bundle = &CoeffBundle{}
+
+func init() {
+	if err := LoadCoefficients(DefaultCoefficientsPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("failed to load the coefficients: %v\n", err)
+	}
+}
+
+// LoadCoefficients reads the bundle in JSON (.json) or YAML and sets the coefficients of the CPU
+func LoadCoefficients(file string) error {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	b := &CoeffBundle{}
+	if filepath.Ext(file) == ".json" {
+		err = json.Unmarshal(data, b)
+	} else {
+		err = yaml.UnmarshalStrict(data, b)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	bundle = b
+	arch, err := source.GetCPUArchitecture()
+	if err != nil {
+		arch = runtime.GOARCH
+	}
+	c, ok := b.CPU[arch]
+	if !ok {
+		c, ok = b.CPU[runtime.GOARCH]
+	}
+	if !ok {
+		return nil
+	}
+	BareMetalCoeff.CPUTime, BareMetalCoeff.CPUCycle, BareMetalCoeff.CPUInstr = c.CPUTime, c.CPUCycle, c.CPUInstr
+	BareMetalCoeff.MemoryUsage, BareMetalCoeff.CacheMisses = c.MemoryUsage, c.CacheMisses
+	RunTimeCoeff = BareMetalCoeff
+	return nil
+}
+
+// SetGPUModel sets the GPU coefficients of the first pattern by name matching the GPU model
+func SetGPUModel(name string) {
+	if len(name) == 0 {
+		return
+	}
+	patterns := make([]string, 0, len(bundle.GPU))
+	for pattern := range bundle.GPU {
+		patterns = append(patterns, pattern)
+	}
+	sort.Strings(patterns)
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, name); err != nil || !ok {
+			continue
+		}
+		g := bundle.GPU[pattern]
+		for _, coeff := range []*Coeff{&BareMetalCoeff, &VMCoeff, &RunTimeCoeff} {
+			coeff.GPUCompute, coeff.GPUDataMovement = g.GPUCompute, g.GPUDataMovement
+		}
+		return
+	}
+}
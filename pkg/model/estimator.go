@@ -0,0 +1,178 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// By default the core and DRAM energy of the node is split among the containers by the ratio model, the
+// counter shares weighted by RunTimeCoeff. An Estimator replaces it, e.g. a model trained offline and
+// served by a model server next to the agent, swapped without rebuilding the agent.
+const (
+	// the estimator is called while sampling, a slow one falls back to the ratio model
+	estimatorTimeout = time.Second
+	unixPrefix       = "unix://"
+)
+
+// FeatureNames are the names of the counters of the features, in the order sent to the model server
+var FeatureNames = []string{"cpu_time", "cpu_cycles", "cpu_instructions", "cache_misses", "resident_memory"}
+
+// Features are the counters of a container, or of the node, over a sample: CPU time in ms and resident
+// memory in bytes
+type Features struct {
+	CPUTime        float64
+	CPUCycles      uint64
+	CPUInstr       uint64
+	CacheMisses    uint64
+	ResidentMemory float64
+}
+
+// Estimator splits the core and DRAM energy (mJ) of the node over a sample among the containers from
+// their counters, it returns the energy of each container in order
+type Estimator interface {
+	Name() string
+	EstimateCoreEnergy(coreDelta float64, node Features, containers []Features) ([]float64, error)
+	EstimateDramEnergy(dramDelta float64, node Features, containers []Features) ([]float64, error)
+}
+
+var (
+	estimatorLock sync.Mutex
+	estimator     Estimator
+)
+
+// SetEstimator replaces the ratio model, nil restores it
+func SetEstimator(e Estimator) {
+	estimatorLock.Lock()
+	defer estimatorLock.Unlock()
+	estimator = e
+}
+
+// GetEstimator returns the estimator set, nil for the ratio model
+func GetEstimator() Estimator {
+	estimatorLock.Lock()
+	defer estimatorLock.Unlock()
+	return estimator
+}
+
+func (f Features) values() []float64 {
+	return []float64{f.CPUTime, float64(f.CPUCycles), float64(f.CPUInstr), float64(f.CacheMisses), f.ResidentMemory}
+}
+
+// EstimateRequest is posted to the model server, the values of the features are in the order of
+// FeatureNames
+type EstimateRequest struct {
+	ModelName       string      `json:"model_name,omitempty"`
+	Component       string      `json:"component"`
+	Energy          float64     `json:"energy"`
+	FeatureNames    []string    `json:"feature_names"`
+	NodeValues      []float64   `json:"node_values"`
+	ContainerValues [][]float64 `json:"container_values"`
+}
+
+// EstimateResponse is the energy of each container in the order of the request, or an error message
+type EstimateResponse struct {
+	Energy  []float64 `json:"energy"`
+	Message string    `json:"message,omitempty"`
+}
+
+// ServerEstimator calls a model server over HTTP, e.g. http://localhost:8100/estimate, or over a unix
+// socket, unix:///tmp/estimator.sock
+type ServerEstimator struct {
+	url       string
+	modelName string
+	client    *http.Client
+}
+
+// NewServerEstimator estimates with the model modelName of the model server at endpoint, empty for its
+// default model
+func NewServerEstimator(endpoint, modelName string) *ServerEstimator {
+	e := &ServerEstimator{url: endpoint, modelName: modelName, client: &http.Client{Timeout: estimatorTimeout}}
+	if strings.HasPrefix(endpoint, unixPrefix) {
+		socket := strings.TrimPrefix(endpoint, unixPrefix)
+		e.url = "http://estimator/estimate"
+		e.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socket)
+			},
+		}
+	}
+	return e
+}
+
+func (e *ServerEstimator) Name() string {
+	if len(e.modelName) > 0 {
+		return e.modelName
+	}
+	return "model-server"
+}
+
+func (e *ServerEstimator) EstimateCoreEnergy(coreDelta float64, node Features, containers []Features) ([]float64, error) {
+	return e.estimate("core", coreDelta, node, containers)
+}
+
+func (e *ServerEstimator) EstimateDramEnergy(dramDelta float64, node Features, containers []Features) ([]float64, error) {
+	return e.estimate("dram", dramDelta, node, containers)
+}
+
+func (e *ServerEstimator) estimate(component string, energy float64, node Features, containers []Features) ([]float64, error) {
+	req := EstimateRequest{
+		ModelName:       e.modelName,
+		Component:       component,
+		Energy:          energy,
+		FeatureNames:    FeatureNames,
+		NodeValues:      node.values(),
+		ContainerValues: make([][]float64, len(containers)),
+	}
+	for i, c := range containers {
+		req.ContainerValues[i] = c.values()
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", e.url, err)
+	}
+	defer res.Body.Close()
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	resp := EstimateResponse{}
+	if err = json.Unmarshal(data, &resp); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+	if res.StatusCode != http.StatusOK || len(resp.Message) > 0 {
+		return nil, fmt.Errorf("model server status %s: %s", res.Status, resp.Message)
+	}
+	if len(resp.Energy) != len(containers) {
+		return nil, fmt.Errorf("model server returned %d energies for %d containers", len(resp.Energy), len(containers))
+	}
+	return resp.Energy, nil
+}
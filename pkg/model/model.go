@@ -25,11 +25,15 @@ import (
 )
 
 type Coeff struct {
-	CPUTime     float64 `json:"cpu_time"`
-	CPUCycle    float64 `json:"cpu_cycle"`
-	CPUInstr    float64 `json:"cpu_instruction"`
-	MemoryUsage float64 `json:"memory_usage"`
-	CacheMisses float64 `json:"cache_misses"`
+	CPUTime     float64 `json:"cpu_time" yaml:"cpu_time"`
+	CPUCycle    float64 `json:"cpu_cycle" yaml:"cpu_cycle"`
+	CPUInstr    float64 `json:"cpu_instruction" yaml:"cpu_instruction"`
+	MemoryUsage float64 `json:"memory_usage" yaml:"memory_usage"`
+	CacheMisses float64 `json:"cache_misses" yaml:"cache_misses"`
+	// GPUCompute and GPUDataMovement weight the SM utilization and the memory and PCIe utilization
+	// splitting the GPU board power
+	GPUCompute      float64 `json:"gpu_compute" yaml:"gpu_compute"`
+	GPUDataMovement float64 `json:"gpu_data_movement" yaml:"gpu_data_movement"`
 }
 
 type RegressionModel struct {
@@ -45,19 +49,23 @@ type RegressionModel struct {
 var (
 	//TODO obtain the coeff via regression
 	BareMetalCoeff = Coeff{
-		CPUTime:     0.6,
-		CPUCycle:    0.2,
-		CPUInstr:    0.2,
-		MemoryUsage: 0.5,
-		CacheMisses: 0.5,
+		CPUTime:         0.6,
+		CPUCycle:        0.2,
+		CPUInstr:        0.2,
+		MemoryUsage:     0.5,
+		CacheMisses:     0.5,
+		GPUCompute:      0.5,
+		GPUDataMovement: 0.5,
 	}
 	// if per counters are not avail on VMs, don't use them
 	VMCoeff = Coeff{
-		CPUTime:     1.0,
-		CPUCycle:    0,
-		CPUInstr:    0,
-		MemoryUsage: 1.0,
-		CacheMisses: 0,
+		CPUTime:         1.0,
+		CPUCycle:        0,
+		CPUInstr:        0,
+		MemoryUsage:     1.0,
+		CacheMisses:     0,
+		GPUCompute:      0.5,
+		GPUDataMovement: 0.5,
 	}
 	RunTimeCoeff Coeff = BareMetalCoeff
 
@@ -76,6 +84,13 @@ func SetRuntimeCoeff(coeff Coeff) {
 	RunTimeCoeff = coeff
 
 }
+
+// SetHardwareCoeff sets the coefficients of the hardware, e.g. from its profile, as the bare-metal ones so
+// that they are kept when the perf counters are found at attach time
+func SetHardwareCoeff(coeff Coeff) {
+	BareMetalCoeff = coeff
+	RunTimeCoeff = coeff
+}
 func SetModelServerEndpoint(ep string) {
 	modelServerEndpoint = ep
 }
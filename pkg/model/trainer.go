@@ -0,0 +1,264 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package model
+
+import (
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// The static coefficients weight the CPU time, cycles and instructions shares splitting the core energy,
+// and the cache misses and memory shares splitting the DRAM energy, the same on every CPU. While RAPL
+// measures the core and DRAM energy, the trainer regresses them against the counters of the node with
+// recursive least squares, and the weights become the share of the dynamic power each counter explains.
+// The models are kept per CPU architecture and saved in the store, so they survive the restarts.
+const (
+	trainedModelsKey = "trained_models"
+	// forgetting factor, the model follows the workload changes over a few hundred samples
+	forgetting = 0.995
+	// the initial covariance, the coefficients start free, and its bound once the counters stop moving
+	initialCovariance = 1e3
+	maxCovariance     = 1e6
+	// weight of the sample in the mean counters
+	meanWeight = 0.05
+	// the coefficients are used once trained with that many samples
+	minTrainingSamples = 30
+	saveEvery          = 100
+
+	// scales of the counter rates, so the regression is well conditioned: CPU time ms/s, cycles and
+	// instructions G/s, misses M/s and memory GB
+	cpuTimeScale = 1e-3
+	cyclesScale  = 1e-9
+	instrScale   = 1e-9
+	missesScale  = 1e-6
+	memoryScale  = 1e-9
+)
+
+// TrainingSample is a node sample measured by RAPL, energies in mJ
+type TrainingSample struct {
+	Seconds     float64
+	Core        float64
+	Dram        float64
+	CPUTime     float64
+	CPUCycles   uint64
+	CPUInstr    uint64
+	CacheMisses uint64
+	// Memory is the memory in use in bytes
+	Memory float64
+}
+
+// regression is a recursive least squares regression of the power over an intercept, the idle power,
+// and the counters
+type regression struct {
+	Theta []float64   `json:"theta"`
+	P     [][]float64 `json:"p"`
+	// Mean are the mean counters, weighting the coefficients into shares
+	Mean []float64 `json:"mean"`
+}
+
+// trainedModel is the regression of the core and DRAM power of a CPU architecture
+type trainedModel struct {
+	Core    *regression `json:"core"`
+	Dram    *regression `json:"dram"`
+	Samples int         `json:"samples"`
+}
+
+var (
+	trainingLock  sync.Mutex
+	trainingArch  string
+	trainedModels map[string]*trainedModel
+)
+
+func newRegression(features int) *regression {
+	r := &regression{
+		Theta: make([]float64, features+1),
+		P:     make([][]float64, features+1),
+		Mean:  make([]float64, features),
+	}
+	for i := range r.P {
+		r.P[i] = make([]float64, features+1)
+		r.P[i][i] = initialCovariance
+	}
+	return r
+}
+
+// EnableTraining trains the coefficients of the CPU architecture from the measured samples, restarting
+// from the saved model, the training starts over if it cannot be loaded
+func EnableTraining(arch string) error {
+	trainingLock.Lock()
+	defer trainingLock.Unlock()
+	trainingArch = arch
+	trainedModels = map[string]*trainedModel{}
+	_, err := store.Load(trainedModelsKey, &trainedModels)
+	if err != nil {
+		// trained again from scratch
+		trainedModels = map[string]*trainedModel{}
+		err = fmt.Errorf("failed to load the trained models: %v", err)
+	}
+	m, ok := trainedModels[arch]
+	if !ok || !m.valid() {
+		trainedModels[arch] = &trainedModel{Core: newRegression(3), Dram: newRegression(2)}
+		return err
+	}
+	log.Printf("restored the %s power model trained with %d samples\n", arch, m.Samples)
+	if m.Samples >= minTrainingSamples {
+		applyModel(m)
+	}
+	return nil
+}
+
+// Train updates the model with a measured sample, the coefficients follow it once trained
+func Train(s TrainingSample) {
+	trainingLock.Lock()
+	defer trainingLock.Unlock()
+	m, ok := trainedModels[trainingArch]
+	if !ok || s.Seconds <= 0 || s.CPUTime <= 0 {
+		return
+	}
+	/* power (W) = energy (mJ) / 1000 / time(second) */
+	m.Core.update(s.Core/1000/s.Seconds, []float64{
+		s.CPUTime / s.Seconds * cpuTimeScale,
+		float64(s.CPUCycles) / s.Seconds * cyclesScale,
+		float64(s.CPUInstr) / s.Seconds * instrScale,
+	})
+	m.Dram.update(s.Dram/1000/s.Seconds, []float64{
+		float64(s.CacheMisses) / s.Seconds * missesScale,
+		s.Memory * memoryScale,
+	})
+	m.Samples++
+	if m.Samples >= minTrainingSamples {
+		applyModel(m)
+	}
+	if m.Samples%saveEvery == 0 {
+		if err := store.Save(trainedModelsKey, trainedModels); err != nil {
+			log.Printf("failed to save the trained models: %v\n", err)
+		}
+	}
+}
+
+// ApplyTraining sets the coefficients of the trained model again, once the attacher has chosen between the
+// bare-metal and VM coefficients
+func ApplyTraining() {
+	trainingLock.Lock()
+	defer trainingLock.Unlock()
+	if m, ok := trainedModels[trainingArch]; ok && m.Samples >= minTrainingSamples {
+		applyModel(m)
+	}
+}
+
+// SaveTraining saves the trained models, e.g. before the node powers off
+func SaveTraining() {
+	trainingLock.Lock()
+	defer trainingLock.Unlock()
+	if trainedModels == nil {
+		return
+	}
+	if err := store.Save(trainedModelsKey, trainedModels); err != nil {
+		log.Printf("failed to save the trained models: %v\n", err)
+	}
+}
+
+// applyModel sets the CPU and memory coefficients to the shares of the model, the GPU ones are kept
+func applyModel(m *trainedModel) {
+	coeff := RunTimeCoeff
+	if shares, ok := m.Core.shares(); ok {
+		coeff.CPUTime, coeff.CPUCycle, coeff.CPUInstr = shares[0], shares[1], shares[2]
+	}
+	if shares, ok := m.Dram.shares(); ok {
+		coeff.CacheMisses, coeff.MemoryUsage = shares[0], shares[1]
+	}
+	RunTimeCoeff = coeff
+}
+
+func (m *trainedModel) valid() bool {
+	return m.Core.valid(3) && m.Dram.valid(2)
+}
+
+func (r *regression) valid(features int) bool {
+	if r == nil || len(r.Theta) != features+1 || len(r.Mean) != features || len(r.P) != features+1 {
+		return false
+	}
+	for _, row := range r.P {
+		if len(row) != features+1 {
+			return false
+		}
+	}
+	return true
+}
+
+// update is a step of the recursive least squares with the power y of the counters
+func (r *regression) update(y float64, features []float64) {
+	for i, f := range features {
+		r.Mean[i] += meanWeight * (f - r.Mean[i])
+	}
+	x := append([]float64{1}, features...)
+	n := len(x)
+	px := make([]float64, n)
+	denominator := forgetting
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			px[i] += r.P[i][j] * x[j]
+		}
+		denominator += x[i] * px[i]
+	}
+	residual := y
+	for i := 0; i < n; i++ {
+		residual -= r.Theta[i] * x[i]
+	}
+	for i := 0; i < n; i++ {
+		r.Theta[i] += px[i] / denominator * residual
+	}
+	// P = (P - P x xT P / denominator) / forgetting, without forgetting once the covariance is large,
+	// i.e. the counters stopped moving, so it does not grow without bound
+	trace := float64(0)
+	for i := 0; i < n; i++ {
+		for j := 0; j < n; j++ {
+			r.P[i][j] -= px[i] * px[j] / denominator
+		}
+		trace += r.P[i][i]
+	}
+	if trace < maxCovariance {
+		for i := 0; i < n; i++ {
+			for j := 0; j < n; j++ {
+				r.P[i][j] /= forgetting
+			}
+		}
+	}
+}
+
+// shares returns the share of the dynamic power explained by each counter at its mean, the negative
+// coefficients are dropped
+func (r *regression) shares() ([]float64, bool) {
+	shares := make([]float64, len(r.Mean))
+	total := float64(0)
+	for i, mean := range r.Mean {
+		if c := r.Theta[i+1] * mean; c > 0 {
+			shares[i] = c
+			total += c
+		}
+	}
+	if total == 0 {
+		return nil, false
+	}
+	for i := range shares {
+		shares[i] /= total
+	}
+	return shares, true
+}
@@ -0,0 +1,91 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	// maxCPUs is the CONFIG_NR_CPUS limit of the kernel, bounding the cpu lists
+	maxCPUs = 8192
+)
+
+var (
+	// cgroup v2 exposes the cpus actually granted in cpuset.cpus.effective, the v1 cpuset controller in
+	// cpuset.effective_cpus
+	cpuSetFiles   = []string{"cpuset.cpus.effective", "cpuset.cpus"}
+	cpuSetFilesV1 = []string{"cpuset.effective_cpus", "cpuset.cpus"}
+)
+
+// ReadCgroupCPUSet returns the CPUs the cgroup is allowed to run on, in the kernel list format (e.g. "0-3,8") and parsed
+func ReadCgroupCPUSet(cGroupID uint64) (string, []int32, error) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil {
+		return "", nil, err
+	}
+	if path == unknownPath {
+		return "", nil, fmt.Errorf("no cgroup path found")
+	}
+	files := cpuSetFiles
+	if cgroupMode != cgroupUnified {
+		files, path = cpuSetFilesV1, controllerPath(path, "cpuset")
+	}
+	for _, f := range files {
+		data, err := ioutil.ReadFile(filepath.Join(path, f))
+		if err != nil {
+			continue
+		}
+		list := strings.TrimSpace(string(data))
+		if len(list) == 0 {
+			// empty cpuset.cpus means the cgroup inherits the cpus of its parent
+			continue
+		}
+		cpus, err := ParseCPUList(list)
+		return list, cpus, err
+	}
+	return "", nil, fmt.Errorf("no cpuset found in %s", path)
+}
+
+// ParseCPUList parses the kernel cpu list format, e.g. "0-3,8,10-11"
+func ParseCPUList(list string) ([]int32, error) {
+	cpus := []int32{}
+	for _, r := range strings.Split(list, ",") {
+		bounds := strings.SplitN(strings.TrimSpace(r), "-", 2)
+		first, err := strconv.ParseInt(bounds[0], 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cpu list %q: %v", list, err)
+		}
+		last := first
+		if len(bounds) == 2 {
+			if last, err = strconv.ParseInt(bounds[1], 10, 32); err != nil {
+				return nil, fmt.Errorf("failed to parse cpu list %q: %v", list, err)
+			}
+		}
+		if first < 0 || last < first || last >= maxCPUs {
+			return nil, fmt.Errorf("invalid cpu range %q in cpu list %q", r, list)
+		}
+		for cpu := first; cpu <= last; cpu++ {
+			cpus = append(cpus, int32(cpu))
+		}
+	}
+	return cpus, nil
+}
@@ -0,0 +1,187 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// The BPF programs report the kernfs node id of the cgroup of a process. Since kernel 5.5 it is a 64-bit
+// id, the inode number of the cgroup directory on 64-bit architectures, before it was the 32-bit inode
+// number with the generation in the upper half. The file handle of the directory holds the id on both,
+// the inode number is used where the handles are not supported. The first thread of the collector seen
+// by BPF checks the translation against the cgroup of the collector, and the translation matching the
+// kernel is used from then on.
+const (
+	cgroupIDHandle  = "handle"
+	cgroupIDInode   = "inode"
+	cgroupIDInode32 = "inode32"
+
+	procSelfCgroup = "/proc/self/cgroup"
+	procSelfTask   = "/proc/self/task/%d"
+)
+
+var (
+	cgroupIDMode    = cgroupIDHandle
+	cgroupIDModes   = []string{cgroupIDHandle, cgroupIDInode, cgroupIDInode32}
+	cgroupIDChecked bool
+)
+
+// cgroupKey returns the key of a cgroup directory in the translation mode
+func cgroupKey(path, mode string) (uint64, error) {
+	if mode == cgroupIDHandle {
+		handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
+		if err != nil {
+			return 0, fmt.Errorf("error resolving handle: %w", err)
+		}
+		if handle.Size() != 8 {
+			return 0, fmt.Errorf("unexpected handle size %d", handle.Size())
+		}
+		return byteOrder.Uint64(handle.Bytes()), nil
+	}
+	var st unix.Stat_t
+	if err := unix.Stat(path, &st); err != nil {
+		return 0, fmt.Errorf("error reading inode: %w", err)
+	}
+	ino := uint64(st.Ino)
+	if mode == cgroupIDInode32 {
+		return ino & 0xffffffff, nil
+	}
+	return ino, nil
+}
+
+// idKey returns the key of a cgroup id reported by BPF in the translation mode
+func idKey(cgroupID uint64, mode string) uint64 {
+	if mode == cgroupIDInode32 {
+		return cgroupID & 0xffffffff
+	}
+	return cgroupID
+}
+
+// walkCgroups returns the path of every cgroup by key
+func walkCgroups(mode string) (map[uint64]string, error) {
+	paths := map[uint64]string{}
+	err := filepath.WalkDir(cgroupPath, func(path string, dentry fs.DirEntry, err error) error {
+		// a cgroup removed during the walk is skipped
+		if err != nil {
+			if path != cgroupPath && errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if !dentry.IsDir() {
+			return nil
+		}
+		key, err := cgroupKey(path, mode)
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		paths[key] = path
+		return nil
+	})
+	return paths, err
+}
+
+// cachedPath returns the path of a cgroup id if it was resolved
+func cachedPath(cgroupID uint64) (string, bool) {
+	path, ok := cGroupIDToPath[idKey(cgroupID, cgroupIDMode)]
+	return path, ok
+}
+
+// CgroupExists tells whether the cgroup of a cgroup id still exists, false if its path is not known
+func CgroupExists(cGroupID uint64) bool {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil || path == unknownPath {
+		return false
+	}
+	_, err = os.Stat(path)
+	return err == nil
+}
+
+// ForgetCgroup drops the cached translation and container of a removed cgroup
+func ForgetCgroup(cGroupID uint64) {
+	if id, ok := cGroupIDToContainerIDCache[cGroupID]; ok {
+		delete(containerIDToContainerInfo, id)
+		delete(cGroupIDToContainerIDCache, cGroupID)
+	}
+	delete(cGroupIDToPath, idKey(cGroupID, cgroupIDMode))
+}
+
+// resetCgroupCaches drops the translated cgroups, e.g. when the translation changed
+func resetCgroupCaches() {
+	cGroupIDToPath = map[uint64]string{}
+	cGroupIDToContainerIDCache = map[uint64]string{}
+}
+
+// CheckCgroupID checks the translation of the cgroup ids with a process reported by BPF, once a thread
+// of the collector is seen its cgroup id must translate to the cgroup of the collector
+func CheckCgroupID(cgroupID, pid uint64) {
+	if cgroupIDChecked {
+		return
+	}
+	if _, err := os.Stat(fmt.Sprintf(procSelfTask, pid)); err != nil {
+		return
+	}
+	cgroupIDChecked = true
+	path, err := selfCgroupPath()
+	if err != nil {
+		log.Printf("failed to check the cgroup ids: %v\n", err)
+		return
+	}
+	modes := append([]string{cgroupIDMode}, cgroupIDModes...)
+	for _, mode := range modes {
+		key, err := cgroupKey(path, mode)
+		if err != nil || key != idKey(cgroupID, mode) {
+			continue
+		}
+		if mode != cgroupIDMode {
+			log.Printf("cgroup id %d of the collector matches %s by %s instead of %s, switching\n", cgroupID, path, mode, cgroupIDMode)
+			cgroupIDMode = mode
+			resetCgroupCaches()
+		}
+		return
+	}
+	log.Printf("cgroup id %d of the collector does not match its cgroup %s, the processes may be misattributed (cgroup v1 or a cgroup namespace?)\n", cgroupID, path)
+}
+
+// selfCgroupPath returns the cgroup v2 directory of the collector
+func selfCgroupPath() (string, error) {
+	f, err := os.Open(procSelfCgroup)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return filepath.Join(cgroupPath, path), nil
+		}
+	}
+	return "", fmt.Errorf("no cgroup v2 entry in %s", procSelfCgroup)
+}
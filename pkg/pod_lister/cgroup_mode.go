@@ -0,0 +1,70 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"log"
+	"path/filepath"
+
+	"golang.org/x/sys/unix"
+)
+
+// The cgroup hierarchy is detected at startup. bpf_get_current_cgroup_id reports the cgroup of a process in
+// the v2 hierarchy, which is mounted on /sys/fs/cgroup in the unified mode and on /sys/fs/cgroup/unified in
+// the hybrid mode of systemd, next to the v1 controllers holding the stats. In the legacy mode there is no
+// v2 hierarchy and the processes cannot be resolved to their pods.
+const (
+	cgroupUnified = "unified"
+	cgroupHybrid  = "hybrid"
+	cgroupLegacy  = "legacy"
+)
+
+var (
+	cgroupMode = cgroupUnified
+	cgroupRoot = "/sys/fs/cgroup"
+)
+
+// detectCgroupMode sets the cgroup mode and the v2 hierarchy the cgroup ids are resolved in
+func detectCgroupMode() {
+	var st unix.Statfs_t
+	if err := unix.Statfs(cgroupRoot, &st); err != nil {
+		log.Printf("failed to detect the cgroup hierarchy, assuming cgroup v2: %v\n", err)
+		return
+	}
+	if st.Type == unix.CGROUP2_SUPER_MAGIC {
+		cgroupMode, cgroupPath = cgroupUnified, cgroupRoot
+		return
+	}
+	unified := filepath.Join(cgroupRoot, "unified")
+	if err := unix.Statfs(unified, &st); err == nil && st.Type == unix.CGROUP2_SUPER_MAGIC {
+		cgroupMode, cgroupPath = cgroupHybrid, unified
+		log.Printf("cgroup hybrid hierarchy, the cgroups are resolved in %s and their stats read from the v1 controllers\n", unified)
+		return
+	}
+	cgroupMode, cgroupPath = cgroupLegacy, cgroupRoot
+	log.Printf("no cgroup v2 hierarchy in %s, the processes are accounted as system processes\n", cgroupRoot)
+}
+
+// controllerPath returns the directory of a cgroup in the hierarchy of a v1 controller, systemd creates the
+// slices and scopes in the v2 hierarchy and in the v1 ones alike
+func controllerPath(path, controller string) string {
+	rel, err := filepath.Rel(cgroupPath, path)
+	if err != nil {
+		rel = "."
+	}
+	return filepath.Join(cgroupRoot, controller, rel)
+}
@@ -18,7 +18,10 @@ package pod_lister
 
 import (
 	"bufio"
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -29,10 +32,21 @@ import (
 const (
 	ioStatFile = "io.stat"
 	reIOStat   = "([0-9]+):([0-9]+).rbytes=([0-9]+).wbytes=([0-9]+)" // 8:16 rbytes=58032128 wbytes=0 rios=120 wios=0 dbytes=0 dios=0
+	// the memory used by a cgroup, page cache included, in cgroup v2 and in the v1 memory controller
+	memoryCurrentFile = "memory.current"
+	memoryUsageFile   = "memory.usage_in_bytes"
+	// the page cache that can be reclaimed, not counted in the working set like kubelet does
+	memoryStatFile         = "memory.stat"
+	inactiveFileStat       = "inactive_file"
+	inactiveFileStatLegacy = "total_inactive_file"
 )
 
 var (
 	reIO = regexp.MustCompile(reIOStat)
+	// the bytes transferred by a cgroup in the v1 blkio controller, the recursive file is missing on old kernels
+	blkioStatFiles = []string{"blkio.throttle.io_service_bytes_recursive", "blkio.throttle.io_service_bytes"}
+	// the pod cgroups of kubelet (pod<uid>, kubepods-burstable-pod<uid>.slice) and podman (machine-libpod_pod_<id>.slice)
+	rePodCgroup = regexp.MustCompile(`(^|[-_])pod`)
 )
 
 func ReadAllCgroupIOStat() (uint64, uint64, int, error) {
@@ -44,24 +58,45 @@ func ReadCgroupIOStat(cGroupID uint64) (uint64, uint64, int, error) {
 	if err != nil {
 		return 0, 0, 0, err
 	}
-	if strings.Contains(path, "crio-") {
+	// the containers of all the runtimes (CRI-O, containerd, Docker, Podman)
+	if id, err := parseContainerID(path); err == nil && len(id) > 0 {
 		return readIOStat(path)
 	}
-	return 0, 0, 0, fmt.Errorf("no cgroup path found")
+	return 0, 0, 0, fmt.Errorf("no container cgroup path found")
 }
 
 func readIOStat(cgroupPath string) (uint64, uint64, int, error) {
-	rBytes := uint64(0)
-	wBytes := uint64(0)
-	disks := 0
+	if cgroupMode != cgroupUnified {
+		return readBlkioStat(controllerPath(cgroupPath, "blkio"))
+	}
 	path := filepath.Join(cgroupPath, ioStatFile)
 	file, err := os.Open(path)
 	if err != nil {
 		return 0, 0, 0, err
 	}
 	defer file.Close()
+	return parseIOStat(file)
+}
 
-	scanner := bufio.NewScanner(file)
+func readBlkioStat(path string) (uint64, uint64, int, error) {
+	for _, f := range blkioStatFiles {
+		file, err := os.Open(filepath.Join(path, f))
+		if err != nil {
+			continue
+		}
+		defer file.Close()
+		return parseBlkioStat(file)
+	}
+	return 0, 0, 0, fmt.Errorf("no blkio stat found in %s", path)
+}
+
+// parseIOStat returns the bytes read and written and the number of disks of a cgroup io.stat,
+// skipping the virtual disks whose IO is accounted on their backing disks
+func parseIOStat(r io.Reader) (uint64, uint64, int, error) {
+	rBytes := uint64(0)
+	wBytes := uint64(0)
+	disks := 0
+	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		line := scanner.Text()
 		matches := reIO.FindStringSubmatch(line)
@@ -84,7 +119,93 @@ func readIOStat(cgroupPath string) (uint64, uint64, int, error) {
 		}
 	}
 	//fmt.Printf("path %s read %d write %d ", cgroupPath, rBytes, wBytes)
-	return rBytes, wBytes, disks, err
+	return rBytes, wBytes, disks, scanner.Err()
+}
+
+// parseBlkioStat returns the bytes read and written and the number of disks of a cgroup v1
+// blkio.throttle.io_service_bytes, e.g. "8:16 Read 58032128", skipping the virtual disks as parseIOStat
+func parseBlkioStat(r io.Reader) (uint64, uint64, int, error) {
+	rBytes := uint64(0)
+	wBytes := uint64(0)
+	disks := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// the last line is the total of the disks, "Total 58032128"
+		if len(fields) != 3 {
+			continue
+		}
+		if isVirtualDisk(strings.SplitN(fields[0], ":", 2)[0]) {
+			continue
+		}
+		val, err := strconv.ParseUint(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		switch fields[1] {
+		case "Read":
+			rBytes += val
+		case "Write":
+			wBytes += val
+		default:
+			continue
+		}
+		disks[fields[0]] = true
+	}
+	return rBytes, wBytes, len(disks), scanner.Err()
+}
+
+// ReadPodMemory returns the memory (bytes) used by the pod of a container cgroup, read from the pod cgroup
+// holding all its containers, or from the container cgroup for the containers outside of a pod
+func ReadPodMemory(cGroupID uint64) (uint64, error) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil {
+		return 0, err
+	}
+	if id, err := parseContainerID(path); err != nil || len(id) == 0 {
+		return 0, fmt.Errorf("cgroup %s is not a container", path)
+	}
+	if dir := filepath.Dir(path); rePodCgroup.MatchString(filepath.Base(dir)) {
+		return readMemory(dir)
+	}
+	return readMemory(path)
+}
+
+// readMemory returns the working set (bytes) of a cgroup, its memory usage but the inactive page cache
+func readMemory(path string) (uint64, error) {
+	file, stat := memoryCurrentFile, inactiveFileStat
+	if cgroupMode != cgroupUnified {
+		path, file, stat = controllerPath(path, "memory"), memoryUsageFile, inactiveFileStatLegacy
+	}
+	data, err := ioutil.ReadFile(filepath.Join(path, file))
+	if err != nil {
+		return 0, err
+	}
+	usage, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	if data, err = ioutil.ReadFile(filepath.Join(path, memoryStatFile)); err != nil {
+		return usage, nil
+	}
+	if inactive, ok := parseMemoryStat(data, stat); ok && inactive < usage {
+		return usage - inactive, nil
+	}
+	return usage, nil
+}
+
+// parseMemoryStat returns a counter of memory.stat, e.g. inactive_file 1234
+func parseMemoryStat(data []byte, key string) (uint64, bool) {
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 2 || fields[0] != key {
+			continue
+		}
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		return v, err == nil
+	}
+	return 0, false
 }
 
 func isVirtualDisk(major string) bool {
@@ -0,0 +1,233 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	runtimeapi "k8s.io/cri-api/pkg/apis/runtime/v1"
+)
+
+// The container runtime of the node is queried for the containers kubelet does not list, e.g. the
+// podman workloads of the edge devices or the containers started while kubelet is unreachable. CRI-O,
+// Docker and Podman serve their inspect API over HTTP on their socket, containerd is queried with the
+// CRI over gRPC.
+const (
+	RuntimeAuto       = "auto"
+	RuntimeCRIO       = "crio"
+	RuntimeContainerd = "containerd"
+	RuntimeDocker     = "docker"
+	RuntimePodman     = "podman"
+
+	runtimeTimeout = 2 * time.Second
+	// the pod labels kubelet sets on the containers of cri-o, containerd and docker
+	podNameLabel       = "io.kubernetes.pod.name"
+	podNamespaceLabel  = "io.kubernetes.pod.namespace"
+	containerNameLabel = "io.kubernetes.container.name"
+)
+
+type containerRuntime struct {
+	name   string
+	socket string
+	client *http.Client
+	// cri is the CRI client of containerd
+	cri runtimeapi.RuntimeServiceClient
+}
+
+var (
+	// runtimeSockets are the default API sockets, probed in order to detect the runtime
+	runtimeSockets = []struct{ name, socket string }{
+		{RuntimeCRIO, "/var/run/crio/crio.sock"},
+		{RuntimeContainerd, "/run/containerd/containerd.sock"},
+		{RuntimeDocker, "/var/run/docker.sock"},
+		{RuntimePodman, "/run/podman/podman.sock"},
+	}
+	// containerCgroups match the container ID of the runtimes with the systemd (<prefix>-<id>.scope) and
+	// the cgroupfs cgroup drivers
+	containerCgroups = []*regexp.Regexp{
+		regexp.MustCompile(`crio-(.*?)\.scope`),
+		regexp.MustCompile(`cri-containerd-([0-9a-f]{64})\.scope`),
+		regexp.MustCompile(`docker-([0-9a-f]{64})\.scope`),
+		regexp.MustCompile(`libpod-(?:conmon-)?([0-9a-f]{64})(?:\.scope|/|$)`),
+		regexp.MustCompile(`/kubepods/(?:[a-z]+/)?pod[0-9a-f-]{36}/(?:crio-)?([0-9a-f]{64})$`),
+		regexp.MustCompile(`/docker/([0-9a-f]{64})$`),
+	}
+	activeRuntime *containerRuntime
+)
+
+// SetContainerRuntime selects the runtime inspected for the containers unknown to kubelet, auto (or empty)
+// detects it from the default sockets
+func SetContainerRuntime(name, socket string) error {
+	if len(name) == 0 || name == RuntimeAuto {
+		if len(socket) > 0 {
+			return fmt.Errorf("the container runtime socket %s needs the runtime type", socket)
+		}
+		for _, s := range runtimeSockets {
+			if _, err := os.Stat(s.socket); err == nil {
+				name, socket = s.name, s.socket
+				break
+			}
+		}
+		if len(socket) == 0 {
+			log.Printf("no container runtime socket found, resolving the containers with kubelet\n")
+			return nil
+		}
+	}
+	known := false
+	for _, s := range runtimeSockets {
+		if s.name == name {
+			known = true
+			if len(socket) == 0 {
+				socket = s.socket
+			}
+		}
+	}
+	if !known {
+		return fmt.Errorf("unknown container runtime %q", name)
+	}
+	log.Printf("resolving the containers with %s on %s\n", name, socket)
+	if name == RuntimeContainerd {
+		// the connection is established on the first call
+		conn, err := grpc.Dial("unix://"+socket, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return fmt.Errorf("failed to connect to %s: %v", socket, err)
+		}
+		activeRuntime = &containerRuntime{
+			name:   name,
+			socket: socket,
+			cri:    runtimeapi.NewRuntimeServiceClient(conn),
+		}
+		return nil
+	}
+	activeRuntime = &containerRuntime{
+		name:   name,
+		socket: socket,
+		client: &http.Client{
+			Timeout: runtimeTimeout,
+			Transport: &http.Transport{
+				DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+					var d net.Dialer
+					return d.DialContext(ctx, "unix", socket)
+				},
+			},
+		},
+	}
+	return nil
+}
+
+// inspectContainer asks the runtime for the pod of a container, the containers outside of a kubernetes
+// pod are reported as a pod of their own in the runtime namespace
+func inspectContainer(id string) (*ContainerInfo, error) {
+	r := activeRuntime
+	if r == nil {
+		return nil, fmt.Errorf("no container runtime")
+	}
+	switch r.name {
+	case RuntimeCRIO:
+		var c struct {
+			Labels map[string]string `json:"labels"`
+		}
+		if err := r.get("/containers/"+id, &c); err != nil {
+			return nil, err
+		}
+		return labelsContainerInfo(c.Labels, id, r.name), nil
+	case RuntimeDocker:
+		var c struct {
+			Name   string
+			Config struct {
+				Labels map[string]string
+			}
+		}
+		if err := r.get("/containers/"+id+"/json", &c); err != nil {
+			return nil, err
+		}
+		return labelsContainerInfo(c.Config.Labels, strings.TrimPrefix(c.Name, "/"), r.name), nil
+	case RuntimeContainerd:
+		ctx, cancel := context.WithTimeout(context.Background(), runtimeTimeout)
+		defer cancel()
+		resp, err := r.cri.ContainerStatus(ctx, &runtimeapi.ContainerStatusRequest{ContainerId: id})
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %v", r.socket, err)
+		}
+		status := resp.GetStatus()
+		return labelsContainerInfo(status.GetLabels(), status.GetMetadata().GetName(), r.name), nil
+	default:
+		var c struct {
+			Name   string
+			Pod    string
+			Config struct {
+				Labels map[string]string
+			}
+		}
+		if err := r.get("/v1.0.0/libpod/containers/"+id+"/json", &c); err != nil {
+			return nil, err
+		}
+		info := labelsContainerInfo(c.Config.Labels, c.Name, r.name)
+		if _, ok := c.Config.Labels[podNameLabel]; !ok && len(c.Pod) > 0 {
+			var p struct {
+				Name string
+			}
+			if err := r.get("/v1.0.0/libpod/pods/"+c.Pod+"/json", &p); err != nil {
+				return nil, err
+			}
+			info.PodName = p.Name
+		}
+		return info, nil
+	}
+}
+
+// labelsContainerInfo returns the pod of the kubelet labels of a container, or the container as its own pod
+func labelsContainerInfo(labels map[string]string, name, namespace string) *ContainerInfo {
+	if pod, ok := labels[podNameLabel]; ok {
+		return &ContainerInfo{
+			PodName:       pod,
+			Namespace:     labels[podNamespaceLabel],
+			ContainerName: labels[containerNameLabel],
+		}
+	}
+	return &ContainerInfo{
+		PodName:       name,
+		Namespace:     namespace,
+		ContainerName: name,
+	}
+}
+
+func (r *containerRuntime) get(path string, v interface{}) error {
+	resp, err := r.client.Get("http://" + r.name + path)
+	if err != nil {
+		return fmt.Errorf("failed to query %s: %v", r.socket, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query %s%s: %s", r.socket, path, resp.Status)
+	}
+	if err = json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse %s%s: %v", r.socket, path, err)
+	}
+	return nil
+}
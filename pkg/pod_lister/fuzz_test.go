@@ -0,0 +1,191 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+)
+
+// The fuzz targets run their seeds with go test, and explore with go test -fuzz=FuzzParsePodList ./pkg/pod_lister
+
+func FuzzParsePodList(f *testing.F) {
+	f.Add([]byte(`{"kind":"PodList","items":[{"metadata":{"name":"web","namespace":"default","uid":"0d4b7c6e-2f1a-4c3b-9a8d-1e2f3a4b5c6d"},` +
+		`"status":{"containerStatuses":[{"name":"nginx","containerID":"cri-o://3f2a","image":"nginx:1.23","imageID":"docker.io/library/nginx@sha256:ab12"}]}}]}`))
+	f.Add([]byte(`{"items":null}`))
+	f.Add([]byte(`Unauthorized`))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, body []byte) {
+		pods, err := parsePodList(body)
+		if err == nil && pods == nil {
+			t.Fatalf("no pods and no error")
+		}
+	})
+}
+
+func FuzzParseMetrics(f *testing.F) {
+	f.Add("# TYPE node_cpu_usage_seconds_total counter\nnode_cpu_usage_seconds_total 120.5 1660000000000\n" +
+		"# TYPE node_memory_working_set_bytes gauge\nnode_memory_working_set_bytes 1.5e+09\n" +
+		"# TYPE container_cpu_usage_seconds_total counter\n" +
+		"container_cpu_usage_seconds_total{container=\"nginx\",namespace=\"default\",pod=\"web\"} 10.5\n" +
+		"# TYPE container_memory_working_set_bytes gauge\n" +
+		"container_memory_working_set_bytes{container=\"nginx\",namespace=\"default\",pod=\"web\"} 2e+07\n")
+	f.Add("container_cpu_usage_seconds_total{pod=\"web\"} NaN\n")
+	f.Add("# TYPE node_cpu_usage_seconds_total gauge\nnode_cpu_usage_seconds_total +Inf\n")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, text string) {
+		containerCPU, containerMem, _, _, err := parseMetrics(strings.NewReader(text))
+		if err != nil {
+			return
+		}
+		system := systemProcessNamespace + "/" + systemProcessName
+		if _, ok := containerCPU[system]; !ok {
+			t.Fatalf("no CPU usage of the system processes")
+		}
+		if _, ok := containerMem[system]; !ok {
+			t.Fatalf("no memory usage of the system processes")
+		}
+	})
+}
+
+func FuzzParseIOStat(f *testing.F) {
+	f.Add("8:16 rbytes=58032128 wbytes=0 rios=120 wios=0 dbytes=0 dios=0\n253:0 rbytes=4096 wbytes=4096 rios=1 wios=1 dbytes=0 dios=0\n")
+	f.Add("8:0 rbytes=18446744073709551615 wbytes=1\n")
+	f.Add("8:0 rbytes=-1 wbytes=x\n")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, stat string) {
+		_, _, disks, err := parseIOStat(strings.NewReader(stat))
+		if err == nil && disks > strings.Count(stat, "\n")+1 {
+			t.Fatalf("%d disks in %d lines", disks, strings.Count(stat, "\n")+1)
+		}
+	})
+}
+
+func FuzzParseBlkioStat(f *testing.F) {
+	f.Add("8:16 Read 58032128\n8:16 Write 0\n8:16 Sync 58032128\n8:16 Total 58032128\n253:0 Read 4096\nTotal 58036224\n")
+	f.Add("8:0 Read 18446744073709551615\n8:0 Write 1\n")
+	f.Add("8:0 Read -1\n8:0 Write x\n")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, stat string) {
+		_, _, disks, err := parseBlkioStat(strings.NewReader(stat))
+		if err == nil && disks > strings.Count(stat, "\n")+1 {
+			t.Fatalf("%d disks in %d lines", disks, strings.Count(stat, "\n")+1)
+		}
+	})
+}
+
+func FuzzParseCPUList(f *testing.F) {
+	f.Add("0-3,8,10-11")
+	f.Add("0")
+	f.Add("0-4294967295")
+	f.Add("7-3")
+	f.Add("-1")
+	f.Add("")
+	f.Fuzz(func(t *testing.T, list string) {
+		cpus, err := ParseCPUList(list)
+		if err != nil {
+			return
+		}
+		if len(cpus) > len(strings.Split(list, ","))*maxCPUs {
+			t.Fatalf("%d cpus parsed from %q", len(cpus), list)
+		}
+		for _, cpu := range cpus {
+			if cpu < 0 || cpu >= maxCPUs || cpu > math.MaxInt32 {
+				t.Fatalf("cpu %d out of range in %q", cpu, list)
+			}
+		}
+	})
+}
+
+func FuzzParseContainerID(f *testing.F) {
+	f.Add("3f2a9c0e")
+	f.Add("c0ffee1e")
+	f.Add("e")
+	f.Fuzz(func(t *testing.T, id string) {
+		if strings.ContainsAny(id, "./\n") || strings.Contains(id, "crio-") || len(id) == 0 {
+			return
+		}
+		// the ID parsed from the cgroup must match the ID reported by kubelet
+		path := "/sys/fs/cgroup/kubepods.slice/kubepods-pod1.slice/crio-" + id + ".scope"
+		parsed, err := parseContainerID(path)
+		if err != nil {
+			if strings.Contains(id, "-conmon-") || strings.Contains(id, ".service") {
+				return
+			}
+			t.Fatalf("failed to parse %q: %v", path, err)
+		}
+		if status := containerIDFromStatus("cri-o://" + id); parsed != status {
+			t.Fatalf("cgroup container ID %q does not match the status container ID %q", parsed, status)
+		}
+	})
+}
+
+func FuzzParseRuntimeContainerID(f *testing.F) {
+	f.Add([]byte("nginx"))
+	f.Add([]byte{})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		id := fmt.Sprintf("%x", sha256.Sum256(data))
+		// the container cgroups of the runtimes with the systemd and the cgroupfs drivers
+		paths := []string{
+			"/sys/fs/cgroup/kubepods.slice/kubepods-burstable.slice/kubepods-burstable-pod1.slice/cri-containerd-" + id + ".scope",
+			"/sys/fs/cgroup/kubepods/burstable/pod0d4b7c6e-2f1a-4c3b-9a8d-1e2f3a4b5c6d/" + id,
+			"/sys/fs/cgroup/kubepods/pod0d4b7c6e-2f1a-4c3b-9a8d-1e2f3a4b5c6d/crio-" + id,
+			"/sys/fs/cgroup/system.slice/docker-" + id + ".scope",
+			"/sys/fs/cgroup/docker/" + id,
+			"/sys/fs/cgroup/machine.slice/libpod-" + id + ".scope/container",
+			"/sys/fs/cgroup/libpod_parent/libpod-" + id,
+		}
+		for _, path := range paths {
+			if parsed, err := parseContainerID(path); err != nil || parsed != id {
+				t.Fatalf("failed to parse %q: %q %v", path, parsed, err)
+			}
+		}
+		if parsed, err := parseContainerID("/sys/fs/cgroup/machine.slice/libpod-conmon-" + id + ".scope"); err == nil {
+			t.Fatalf("parsed the conmon cgroup as container %q", parsed)
+		}
+	})
+}
+
+func FuzzParseMeminfo(f *testing.F) {
+	f.Add([]byte("MemTotal:        8048576 kB\nMemFree:          512000 kB\nMemAvailable:    6123456 kB\n"))
+	f.Add([]byte("MemTotal: 1024 kB\n"))
+	f.Add([]byte("MemAvailable: x kB\nMemTotal:\n"))
+	f.Fuzz(func(t *testing.T, data []byte) {
+		used, err := parseMeminfo(data)
+		if err != nil {
+			return
+		}
+		if used < 0 || math.IsNaN(used) {
+			t.Fatalf("invalid used memory %v", used)
+		}
+	})
+}
+
+func FuzzParseMemoryStat(f *testing.F) {
+	f.Add([]byte("anon 1048576\nfile 4096\ninactive_file 2048\nactive_file 2048\n"), "inactive_file")
+	f.Add([]byte("cache 8192\nrss 4096\ntotal_inactive_file 1024\n"), "total_inactive_file")
+	f.Add([]byte("inactive_file -1\n"), "inactive_file")
+	f.Fuzz(func(t *testing.T, data []byte, key string) {
+		v, ok := parseMemoryStat(data, key)
+		if !ok && v != 0 {
+			t.Fatalf("counter %d of a missing %q", v, key)
+		}
+	})
+}
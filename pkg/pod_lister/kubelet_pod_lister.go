@@ -20,8 +20,8 @@ import (
 	"crypto/tls"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
 	"net/http"
 	"os"
 
@@ -68,7 +68,7 @@ func init() {
 func httpGet(url string) (*http.Response, error) {
 	objToken, err := ioutil.ReadFile(saPath)
 	if err != nil {
-		log.Fatalf("failed to read from %q: %v", saPath, err)
+		return nil, fmt.Errorf("failed to read from %q: %v", saPath, err)
 	}
 	token := string(objToken)
 
@@ -94,19 +94,23 @@ func (k *KubeletPodLister) ListPods() (*[]corev1.Pod, error) {
 		return nil, fmt.Errorf("failed to get response: %v", err)
 	}
 	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s from %q", resp.Status, podUrl)
+	}
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read response body: %v", err)
 	}
+	return parsePodList(body)
+}
+
+// parsePodList decodes the PodList returned by kubelet
+func parsePodList(body []byte) (*[]corev1.Pod, error) {
 	podList := corev1.PodList{}
-	err = json.Unmarshal(body, &podList)
-	if err != nil {
-		log.Fatalf("failed to parse response body: %v", err)
+	if err := json.Unmarshal(body, &podList); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
 	}
-
-	pods := &podList.Items
-
-	return pods, nil
+	return &podList.Items, nil
 }
 
 // ListMetrics accesses Kubelet's metrics and obtain pods and node metrics
@@ -117,8 +121,14 @@ func (k *KubeletPodLister) ListMetrics() (containerCPU map[string]float64, conta
 		return
 	}
 	defer resp.Body.Close()
+	return parseMetrics(resp.Body)
+}
+
+// parseMetrics returns the CPU and memory usage per pod and of the node from the kubelet resource metrics,
+// the usage not accounted to the pods is the usage of the system processes
+func parseMetrics(r io.Reader) (containerCPU map[string]float64, containerMem map[string]float64, nodeCPU float64, nodeMem float64, retErr error) {
 	var parser expfmt.TextParser
-	mf, err := parser.TextToMetricFamilies(resp.Body)
+	mf, err := parser.TextToMetricFamilies(r)
 	if err != nil {
 		retErr = fmt.Errorf("failed to parse: %v", err)
 		return
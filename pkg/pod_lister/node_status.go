@@ -0,0 +1,83 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	apiServerHostEnv = "KUBERNETES_SERVICE_HOST"
+	apiServerPortEnv = "KUBERNETES_SERVICE_PORT"
+
+	NodeStateCordoned = "Cordoned"
+	NodeStateNotReady = "NotReady"
+)
+
+// GetNodeStates returns the node conditions currently true (Ready, MemoryPressure, DiskPressure, ...)
+// and whether the node is cordoned. The node is read from the API server, since kubelet does not expose it.
+func GetNodeStates() ([]string, error) {
+	host := os.Getenv(apiServerHostEnv)
+	if len(host) == 0 {
+		return nil, fmt.Errorf("not running in a kubernetes cluster")
+	}
+	port := os.Getenv(apiServerPortEnv)
+	if len(port) == 0 {
+		port = "443"
+	}
+	nodeName := os.Getenv(nodeEnv)
+	if len(nodeName) == 0 {
+		return nil, fmt.Errorf("%s is not set", nodeEnv)
+	}
+	resp, err := httpGet("https://" + host + ":" + port + "/api/v1/nodes/" + nodeName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get response: %v", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %v", err)
+	}
+	node := corev1.Node{}
+	if err = json.Unmarshal(body, &node); err != nil {
+		return nil, fmt.Errorf("failed to parse response body: %v", err)
+	}
+
+	states := []string{}
+	ready := false
+	for _, condition := range node.Status.Conditions {
+		if condition.Status != corev1.ConditionTrue {
+			continue
+		}
+		if condition.Type == corev1.NodeReady {
+			ready = true
+		}
+		states = append(states, string(condition.Type))
+	}
+	if !ready {
+		states = append(states, NodeStateNotReady)
+	}
+	if node.Spec.Unschedulable {
+		states = append(states, NodeStateCordoned)
+	}
+	return states, nil
+}
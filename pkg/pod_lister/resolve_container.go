@@ -19,17 +19,21 @@ package pod_lister
 import (
 	"encoding/binary"
 	"fmt"
-	"io/fs"
 	"log"
-	"path/filepath"
-	"regexp"
+	"sort"
 	"strings"
+	"time"
+	"unsafe"
 
-	"golang.org/x/sys/unix"
-
-	bpf "github.com/iovisor/gobpf/bcc"
+	corev1 "k8s.io/api/core/v1"
 )
 
+// PodLister lists the pods of the node and their metrics
+type PodLister interface {
+	ListPods() (*[]corev1.Pod, error)
+	ListMetrics() (containerCPU map[string]float64, containerMem map[string]float64, nodeCPU float64, nodeMem float64, retErr error)
+}
+
 type ContainerInfo struct {
 	PodName       string
 	ContainerName string
@@ -37,32 +41,67 @@ type ContainerInfo struct {
 }
 
 const (
-	systemProcessName      string = "system_processes"
-	systemProcessNamespace string = "system"
-	containerIDPredix      string = "cri-o://"
-	unknownPath            string = "unknown"
+	// DefaultSystemProcessName is the catch-all pod of the processes outside of the pods
+	DefaultSystemProcessName string = "system_processes"
+	unknownPath              string = "unknown"
+	// the containers neither kubelet nor the runtime know are resolved again after unresolvedTTL, e.g.
+	// once kubelet lists their pod
+	unresolvedTTL = time.Minute
 )
 
 var (
-	podLister                  KubeletPodLister
+	systemProcessName      = DefaultSystemProcessName
+	systemProcessNamespace = "system"
+)
+
+var (
+	podLister                  PodLister
 	cGroupIDToContainerIDCache = map[uint64]string{}
 	containerIDToContainerInfo = map[string]*ContainerInfo{}
+	unresolvedContainers       = map[string]time.Time{}
 	cGroupIDToPath             = map[uint64]string{}
-	re                         = regexp.MustCompile(`crio-(.*?)\.scope`)
+	podIPToContainerInfo       = map[string]*ContainerInfo{}
+	podLabels                  = map[string]map[string]string{}
+	podImages                  = map[string][]string{}
+	podImageRefs               = map[string][]ImageRef{}
 	cgroupPath                 = "/sys/fs/cgroup"
 	byteOrder                  binary.ByteOrder
 )
 
 func init() {
-	byteOrder = bpf.GetHostByteOrder()
-	podLister = KubeletPodLister{}
+	byteOrder = hostByteOrder()
+	detectCgroupMode()
+	podLister = &KubeletPodLister{}
 	updateListPodCache("", false)
 }
 
+// hostByteOrder returns the native byte order, the one of the cgroup file handles
+func hostByteOrder() binary.ByteOrder {
+	var i int32 = 0x01020304
+	if *(*byte)(unsafe.Pointer(&i)) == 0x04 {
+		return binary.LittleEndian
+	}
+	return binary.BigEndian
+}
+
 func GetSystemProcessName() string {
 	return systemProcessName
 }
 
+func GetSystemProcessNamespace() string {
+	return systemProcessNamespace
+}
+
+// SetSystemProcessName names the catch-all pod of the processes outside of the pods, it must be set before
+// the first process is resolved
+func SetSystemProcessName(name string) error {
+	if len(name) == 0 || strings.ContainsAny(name, "/ ") {
+		return fmt.Errorf("invalid system process name %q", name)
+	}
+	systemProcessName = name
+	return nil
+}
+
 func GetPodNameFromcGgroupID(cGroupID uint64) (string, error) {
 	info, err := getContainerInfoFromcGgroupID(cGroupID)
 	return info.PodName, err
@@ -78,8 +117,56 @@ func GetPodContainerNameFromcGgroupID(cGroupID uint64) (string, error) {
 	return info.ContainerName, err
 }
 
+// GetPodLabels returns the labels of a pod as of the last pod listing
+func GetPodLabels(namespace, pod string) map[string]string {
+	return podLabels[namespace+"/"+pod]
+}
+
+// GetPodImages returns the sorted image digests of the containers of a pod, or their image names if
+// the digests are not known
+func GetPodImages(namespace, pod string) []string {
+	return podImages[namespace+"/"+pod]
+}
+
+// ImageRef is the image of a container, the name it was pulled by and its digest
+type ImageRef struct {
+	Name   string `json:"name"`
+	Digest string `json:"digest"`
+}
+
+// GetPodImageRefs returns the images of the containers of a pod, one per container
+func GetPodImageRefs(namespace, pod string) []ImageRef {
+	return podImageRefs[namespace+"/"+pod]
+}
+
+// imageDigest returns the digest of a container image, the ImageID without the runtime prefix
+// (e.g. docker-pullable://registry/app@sha256:...)
+func imageDigest(status corev1.ContainerStatus) string {
+	id := status.ImageID
+	if i := strings.LastIndex(id, "@"); i >= 0 {
+		id = id[i+1:]
+	} else if i := strings.Index(id, "://"); i >= 0 {
+		id = id[i+3:]
+	}
+	if len(id) == 0 {
+		return status.Image
+	}
+	return id
+}
+
+// GetPodInfoFromIP returns the pod owning an IP, host network pods are not resolved since they share the node IPs
+func GetPodInfoFromIP(ip string) (*ContainerInfo, bool) {
+	info, ok := podIPToContainerInfo[ip]
+	return info, ok
+}
+
 func GetPodMetrics() (containerCPU map[string]float64, containerMem map[string]float64, nodeCPU float64, nodeMem float64, retErr error) {
-	return podLister.ListMetrics()
+	containerCPU, containerMem, nodeCPU, nodeMem, retErr = podLister.ListMetrics()
+	// without the kubelet stats the memory of the pods is read from their cgroups, which needs the node memory
+	if retErr != nil && nodeMem == 0 {
+		nodeMem, _ = readNodeMemory()
+	}
+	return containerCPU, containerMem, nodeCPU, nodeMem, retErr
 }
 
 func getContainerInfoFromcGgroupID(cGroupID uint64) (*ContainerInfo, error) {
@@ -91,6 +178,12 @@ func getContainerInfoFromcGgroupID(cGroupID uint64) (*ContainerInfo, error) {
 	}
 
 	if containerID, err = getContainerIDFromcGroupID(cGroupID); err != nil {
+		// sandboxed runtimes keep the VMM/sentry in the pod cgroup instead of a container cgroup
+		if path, ok := cachedPath(cGroupID); ok {
+			if i, ok := getPodInfoFromPath(path); ok {
+				return i, nil
+			}
+		}
 		//TODO: print a warn with high verbosity
 		return info, nil
 	}
@@ -98,6 +191,9 @@ func getContainerInfoFromcGgroupID(cGroupID uint64) (*ContainerInfo, error) {
 	if i, ok := containerIDToContainerInfo[containerID]; ok {
 		return i, nil
 	}
+	if t, ok := unresolvedContainers[containerID]; ok && time.Since(t) < unresolvedTTL {
+		return info, nil
+	}
 
 	// update cache info and stop loop if container id found
 	updateListPodCache(containerID, true)
@@ -105,8 +201,38 @@ func getContainerInfoFromcGgroupID(cGroupID uint64) (*ContainerInfo, error) {
 		return i, nil
 	}
 
-	containerIDToContainerInfo[containerID] = info
-	return containerIDToContainerInfo[containerID], nil
+	// the pause container of a kata or gVisor sandbox is not reported by kubelet, use its pod instead
+	if path, ok := cachedPath(cGroupID); ok {
+		if i, ok := getPodInfoFromPath(path); ok {
+			containerIDToContainerInfo[containerID] = i
+			return i, nil
+		}
+	}
+
+	// the containers kubelet does not run, e.g. the podman workloads
+	i, err := inspectContainer(containerID)
+	if err != nil {
+		if activeRuntime != nil {
+			log.Printf("failed to inspect container %s: %v\n", containerID, err)
+		}
+		addUnresolvedContainer(containerID)
+		return info, nil
+	}
+	delete(unresolvedContainers, containerID)
+	containerIDToContainerInfo[containerID] = i
+	return i, nil
+}
+
+// addUnresolvedContainer records a container resolved as a system process, the expired entries of the
+// removed containers are dropped
+func addUnresolvedContainer(containerID string) {
+	now := time.Now()
+	for id, t := range unresolvedContainers {
+		if now.Sub(t) >= unresolvedTTL {
+			delete(unresolvedContainers, id)
+		}
+	}
+	unresolvedContainers[containerID] = now
 }
 
 // updateListPodCache updates cache info with all pods and optionally
@@ -114,9 +240,33 @@ func getContainerInfoFromcGgroupID(cGroupID uint64) (*ContainerInfo, error) {
 func updateListPodCache(targetContainerID string, stopWhenFound bool) {
 	pods, err := podLister.ListPods()
 	if err != nil {
-		log.Fatal(err)
+		log.Printf("failed to list pods: %v\n", err)
+		return
 	}
 	for _, pod := range *pods {
+		podInfo := &ContainerInfo{
+			PodName:   pod.Name,
+			Namespace: pod.Namespace,
+		}
+		podUIDToContainerInfo[string(pod.UID)] = podInfo
+		podLabels[pod.Namespace+"/"+pod.Name] = pod.Labels
+		images := []string{}
+		refs := []ImageRef{}
+		for _, status := range pod.Status.ContainerStatuses {
+			if image := imageDigest(status); len(image) > 0 {
+				images = append(images, image)
+				refs = append(refs, ImageRef{Name: status.Image, Digest: image})
+			}
+		}
+		sort.Strings(images)
+		podImages[pod.Namespace+"/"+pod.Name] = images
+		podImageRefs[pod.Namespace+"/"+pod.Name] = refs
+		// host network pods share the node IPs
+		if !pod.Spec.HostNetwork {
+			for _, ip := range pod.Status.PodIPs {
+				podIPToContainerInfo[ip.IP] = podInfo
+			}
+		}
 		statuses := pod.Status.ContainerStatuses
 		for _, status := range statuses {
 			info := &ContainerInfo{
@@ -124,9 +274,9 @@ func updateListPodCache(targetContainerID string, stopWhenFound bool) {
 				Namespace:     pod.Namespace,
 				ContainerName: status.Name,
 			}
-			containerID := strings.Trim(status.ContainerID, containerIDPredix)
+			containerID := containerIDFromStatus(status.ContainerID)
 			containerIDToContainerInfo[containerID] = info
-			if stopWhenFound && status.ContainerID == targetContainerID {
+			if stopWhenFound && containerID == targetContainerID {
 				return
 			}
 		}
@@ -137,9 +287,9 @@ func updateListPodCache(targetContainerID string, stopWhenFound bool) {
 				Namespace:     pod.Namespace,
 				ContainerName: status.Name,
 			}
-			containerID := strings.Trim(status.ContainerID, containerIDPredix)
+			containerID := containerIDFromStatus(status.ContainerID)
 			containerIDToContainerInfo[containerID] = info
-			if stopWhenFound && status.ContainerID == targetContainerID {
+			if stopWhenFound && containerID == targetContainerID {
 				return
 			}
 		}
@@ -158,54 +308,64 @@ func getContainerIDFromcGroupID(cGroupID uint64) (string, error) {
 		return systemProcessName, err
 	}
 
-	if re.MatchString(path) {
-		sub := re.FindAllString(path, -1)
-		for _, element := range sub {
-			if strings.Contains(element, "-conmon-") || strings.Contains(element, ".service") {
-				return "", fmt.Errorf("process cGroupID %d is not in a kubernetes pod", cGroupID)
-			} else if strings.Contains(element, "crio") {
-				containerID := strings.Trim(element, "crio-")
-				containerID = strings.Trim(containerID, ".scope")
-				cGroupIDToContainerIDCache[cGroupID] = containerID
-				return cGroupIDToContainerIDCache[cGroupID], nil
-			}
-		}
+	containerID, err := parseContainerID(path)
+	if err != nil {
+		return "", fmt.Errorf("process cGroupID %d is not in a kubernetes pod", cGroupID)
+	}
+	if len(containerID) > 0 {
+		cGroupIDToContainerIDCache[cGroupID] = containerID
+		return cGroupIDToContainerIDCache[cGroupID], nil
 	}
 
 	cGroupIDToContainerIDCache[cGroupID] = systemProcessName
 	return cGroupIDToContainerIDCache[cGroupID], fmt.Errorf("failed to find container with cGroup id: %v", cGroupID)
 }
 
+// parseContainerID returns the container ID of a container cgroup path (e.g. .../crio-<id>.scope), an error
+// if the cgroup is a runtime cgroup outside of a container (conmon, services) and an empty ID if it is not
+// a container cgroup
+func parseContainerID(path string) (string, error) {
+	for _, re := range containerCgroups {
+		if m := re.FindStringSubmatch(path); m != nil {
+			if strings.Contains(m[0], "-conmon-") || strings.Contains(m[0], ".service") {
+				return "", fmt.Errorf("not a container cgroup")
+			}
+			return m[1], nil
+		}
+	}
+	return "", nil
+}
+
+// containerIDFromStatus returns the container ID of a kubelet container status without the runtime scheme,
+// e.g. cri-o://<id>
+func containerIDFromStatus(id string) string {
+	if i := strings.Index(id, "://"); i >= 0 {
+		return id[i+3:]
+	}
+	return id
+}
+
 // getPathFromcGroupID uses cgroupfs to get cgroup path from id
 // it needs cgroup v2 (per https://github.com/iovisor/bpftrace/issues/950) and kernel 4.18+ (https://github.com/torvalds/linux/commit/bf6fa2c893c5237b48569a13fa3c673041430b6c)
 func getPathFromcGroupID(cgroupId uint64) (string, error) {
-	if p, ok := cGroupIDToPath[cgroupId]; ok {
+	if p, ok := cachedPath(cgroupId); ok {
 		return p, nil
 	}
+	if cgroupMode == cgroupLegacy {
+		return unknownPath, nil
+	}
 
-	err := filepath.WalkDir(cgroupPath, func(path string, dentry fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-
-		if !dentry.IsDir() {
-			return nil
-		}
-		handle, _, err := unix.NameToHandleAt(unix.AT_FDCWD, path, 0)
-		if err != nil {
-			return fmt.Errorf("error resolving handle: %v", err)
-		}
-		cGroupIDToPath[byteOrder.Uint64(handle.Bytes())] = path
-		return nil
-	})
-
+	paths, err := walkCgroups(cgroupIDMode)
 	if err != nil {
 		return "", fmt.Errorf("failed to find cgroup id: %v", err)
 	}
-	if p, ok := cGroupIDToPath[cgroupId]; ok {
+	// the removed cgroups are dropped, the kernels before 5.5 reuse their inode numbers
+	resetCgroupCaches()
+	cGroupIDToPath = paths
+	if p, ok := cachedPath(cgroupId); ok {
 		return p, nil
 	}
 
-	cGroupIDToPath[cgroupId] = unknownPath
-	return cGroupIDToPath[cgroupId], nil
+	cGroupIDToPath[idKey(cgroupId, cgroupIDMode)] = unknownPath
+	return unknownPath, nil
 }
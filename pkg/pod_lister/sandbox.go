@@ -0,0 +1,170 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Sandboxed runtimes do not run the workload as host processes: kata runs it inside a VM and
+// gVisor inside a user-space kernel (the sentry). The host only sees the VMM/sentry processes,
+// which live either in the pod sandbox cgroup or in a runtime-owned cgroup.
+const (
+	RuntimeRunc   string = "runc"
+	RuntimeKata   string = "kata"
+	RuntimeGVisor string = "gvisor"
+
+	procCmdlinePath = "/proc/%d/cmdline"
+	procExePath     = "/proc/%d/exe"
+	// the sandbox of a process is resolved again after sandboxInfoTTL, e.g. once kubelet lists its pod
+	sandboxInfoTTL = time.Minute
+)
+
+var (
+	// command name prefixes (as reported by the kernel comm, max 15 chars) of the host side sandbox processes
+	sandboxProcessPrefixes = map[string][]string{
+		RuntimeKata: {"qemu-system-", "qemu-kvm", "cloud-hyperviso", "firecracker", "kata-", "virtiofsd"},
+		// runsc re-executes itself, so the sentry and gofer show up as "exe"
+		RuntimeGVisor: {"runsc", "exe"},
+	}
+	// sandboxBinaries are the executables of the host side sandbox processes, the commands matching the
+	// prefixes are only resolved if they run one of them
+	sandboxBinaries = map[string]bool{
+		"qemu-system-x86_64":  true,
+		"qemu-system-aarch64": true,
+		"qemu-system-ppc64":   true,
+		"qemu-system-s390x":   true,
+		"qemu-kvm":            true,
+		"cloud-hypervisor":    true,
+		"firecracker":         true,
+		"kata-runtime":        true,
+		"kata-shim":           true,
+		"kata-proxy":          true,
+		"virtiofsd":           true,
+		"runsc":               true,
+	}
+	// systemd cgroup driver uses '_' instead of '-' in the pod uid
+	rePodUID = regexp.MustCompile(`pod([0-9a-f]{8}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{4}[-_][0-9a-f]{12})`)
+	// both kata and runsc pass the 64 hex digits sandbox id in the process arguments
+	reSandboxID = regexp.MustCompile(`[0-9a-f]{64}`)
+
+	podUIDToContainerInfo = map[string]*ContainerInfo{}
+	pidToSandboxInfo      = map[sandboxProcess]sandboxEntry{}
+)
+
+// sandboxProcess identifies a process, its start time tells a reused pid apart
+type sandboxProcess struct {
+	pid       uint64
+	startTime uint64
+}
+
+// sandboxEntry is the pod of a sandbox process, nil if the process is not a sandbox process
+type sandboxEntry struct {
+	info     *ContainerInfo
+	resolved time.Time
+}
+
+// GetSandboxRuntime returns the sandboxed runtime owning a host process, or RuntimeRunc if the
+// process is not a sandbox process
+func GetSandboxRuntime(command string) string {
+	for runtime, prefixes := range sandboxProcessPrefixes {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(command, prefix) {
+				return runtime
+			}
+		}
+	}
+	return RuntimeRunc
+}
+
+// GetSandboxPodFromProcess maps the host process of a kata VMM or gVisor sentry to the pod owning the sandbox.
+// It is used when the cgroup of the process does not identify the pod, e.g. kata with sandbox_cgroup_only=false.
+func GetSandboxPodFromProcess(pid, startTime uint64, command string) (*ContainerInfo, bool) {
+	if GetSandboxRuntime(command) == RuntimeRunc {
+		return nil, false
+	}
+	key := sandboxProcess{pid, startTime}
+	now := time.Now()
+	if e, ok := pidToSandboxInfo[key]; ok && now.Sub(e.resolved) < sandboxInfoTTL {
+		return e.info, e.info != nil
+	}
+	info, err := getSandboxInfoFromPid(pid)
+	if err != nil {
+		log.Printf("failed to resolve sandbox of pid %d (%s): %v", pid, command, err)
+	}
+	// the negative results are cached as well, the entries of the exited processes expire
+	for k, e := range pidToSandboxInfo {
+		if now.Sub(e.resolved) >= sandboxInfoTTL {
+			delete(pidToSandboxInfo, k)
+		}
+	}
+	pidToSandboxInfo[key] = sandboxEntry{info: info, resolved: now}
+	return info, info != nil
+}
+
+// getSandboxInfoFromPid returns the pod of a sandbox process, nil without error if the process does not run
+// a sandbox binary
+func getSandboxInfoFromPid(pid uint64) (*ContainerInfo, error) {
+	exe, err := os.Readlink(fmt.Sprintf(procExePath, pid))
+	if err != nil {
+		return nil, err
+	}
+	if !sandboxBinaries[filepath.Base(strings.TrimSuffix(exe, " (deleted)"))] {
+		return nil, nil
+	}
+	data, err := ioutil.ReadFile(fmt.Sprintf(procCmdlinePath, pid))
+	if err != nil {
+		return nil, err
+	}
+	sandboxID := reSandboxID.FindString(string(data))
+	if len(sandboxID) == 0 {
+		return nil, fmt.Errorf("no sandbox id found in cmdline")
+	}
+	// the sandbox (pause) cgroup is named after the sandbox id and is nested in the pod cgroup
+	for _, path := range cGroupIDToPath {
+		if !strings.Contains(path, sandboxID) {
+			continue
+		}
+		if info, ok := getPodInfoFromPath(path); ok {
+			return info, nil
+		}
+	}
+	return nil, fmt.Errorf("no pod found for sandbox %s", sandboxID)
+}
+
+// getPodInfoFromPath resolves the pod from the pod level cgroup, which also holds the
+// sandbox processes of kata (sandbox_cgroup_only=true) and gVisor
+func getPodInfoFromPath(path string) (*ContainerInfo, bool) {
+	matches := rePodUID.FindStringSubmatch(path)
+	if len(matches) < 2 {
+		return nil, false
+	}
+	uid := strings.ReplaceAll(matches[1], "_", "-")
+	if info, ok := podUIDToContainerInfo[uid]; ok {
+		return info, true
+	}
+	updateListPodCache("", false)
+	info, ok := podUIDToContainerInfo[uid]
+	return info, ok
+}
@@ -0,0 +1,88 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// The flotta devices run their workloads as podman containers and systemd services, without kubelet. The
+// standalone lister lists no pod: the containers are resolved by the container runtime, the other
+// processes by their systemd service, and the memory is read from the cgroups and /proc/meminfo.
+const meminfoPath = "/proc/meminfo"
+
+type standalonePodLister struct{}
+
+// SetStandalone resolves the containers without kubelet
+func SetStandalone(enabled bool) {
+	if enabled {
+		podLister = standalonePodLister{}
+	}
+}
+
+func (standalonePodLister) ListPods() (*[]corev1.Pod, error) {
+	return &[]corev1.Pod{}, nil
+}
+
+// ListMetrics returns the node memory only, the memory of the containers is read from their cgroups
+func (standalonePodLister) ListMetrics() (containerCPU map[string]float64, containerMem map[string]float64, nodeCPU float64, nodeMem float64, retErr error) {
+	nodeMem, retErr = readNodeMemory()
+	return nil, nil, 0, nodeMem, retErr
+}
+
+// readNodeMemory returns the memory (bytes) used on the node, the total memory but the available one
+func readNodeMemory() (float64, error) {
+	data, err := ioutil.ReadFile(meminfoPath)
+	if err != nil {
+		return 0, err
+	}
+	return parseMeminfo(data)
+}
+
+func parseMeminfo(data []byte) (float64, error) {
+	fields := map[string]float64{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		// e.g. MemAvailable:    6123456 kB
+		f := strings.Fields(scanner.Text())
+		if len(f) < 2 {
+			continue
+		}
+		n, err := strconv.ParseUint(f[1], 10, 64)
+		if err != nil {
+			continue
+		}
+		v := float64(n)
+		if len(f) > 2 && f[2] == "kB" {
+			v *= 1024
+		}
+		fields[strings.TrimSuffix(f[0], ":")] = v
+	}
+	total, ok := fields["MemTotal"]
+	available, ok2 := fields["MemAvailable"]
+	if !ok || !ok2 || available > total {
+		return 0, fmt.Errorf("no memory usage in %s", meminfoPath)
+	}
+	return total - available, nil
+}
@@ -0,0 +1,104 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package pod_lister
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	systemdServiceSuffix = ".service"
+	systemdSliceSuffix   = ".slice"
+)
+
+// GetSystemdUnitFromcGgroupID returns the systemd service of a cgroup outside of the pods, e.g.
+// NetworkManager.service for /system.slice/NetworkManager.service
+func GetSystemdUnitFromcGgroupID(cGroupID uint64) (string, bool) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil || path == unknownPath {
+		return "", false
+	}
+	return parseSystemdUnit(path)
+}
+
+// ReadSystemdUnitMemory returns the working set (bytes) of the systemd service of a cgroup
+func ReadSystemdUnitMemory(cGroupID uint64) (uint64, error) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil {
+		return 0, err
+	}
+	dir, ok := systemdUnitPath(path)
+	if !ok {
+		return 0, fmt.Errorf("cgroup %s is not a service", path)
+	}
+	return readMemory(dir)
+}
+
+// GetSystemdSliceFromcGgroupID returns the systemd slice of a cgroup outside of the pods, e.g. system.slice
+// for /system.slice/NetworkManager.service or user-1000.slice for /user.slice/user-1000.slice/session-2.scope
+func GetSystemdSliceFromcGgroupID(cGroupID uint64) (string, bool) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil || path == unknownPath {
+		return "", false
+	}
+	dir, ok := systemdPath(path, systemdSliceSuffix)
+	if !ok {
+		return "", false
+	}
+	return filepath.Base(dir), true
+}
+
+// ReadSystemdSliceMemory returns the working set (bytes) of the systemd slice of a cgroup
+func ReadSystemdSliceMemory(cGroupID uint64) (uint64, error) {
+	path, err := getPathFromcGroupID(cGroupID)
+	if err != nil {
+		return 0, err
+	}
+	dir, ok := systemdPath(path, systemdSliceSuffix)
+	if !ok {
+		return 0, fmt.Errorf("cgroup %s is not in a slice", path)
+	}
+	return readMemory(dir)
+}
+
+// parseSystemdUnit returns the innermost service of a cgroup path, the services may delegate sub-cgroups
+// to their processes (e.g. /system.slice/foo.service/payload). The scopes and the user sessions are not
+// services and stay system processes.
+func parseSystemdUnit(path string) (string, bool) {
+	dir, ok := systemdUnitPath(path)
+	if !ok {
+		return "", false
+	}
+	return filepath.Base(dir), true
+}
+
+// systemdUnitPath returns the cgroup of the innermost service of a cgroup path
+func systemdUnitPath(path string) (string, bool) {
+	return systemdPath(path, systemdServiceSuffix)
+}
+
+// systemdPath returns the cgroup of the innermost unit of a type (.service, .slice) of a cgroup path
+func systemdPath(path, suffix string) (string, bool) {
+	for dir := path; dir != "/" && dir != "."; dir = filepath.Dir(dir) {
+		if strings.HasSuffix(filepath.Base(dir), suffix) {
+			return dir, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package accelerator
+
+import (
+	"log"
+	"sync"
+)
+
+// Accelerator is implemented by the driver of a device class (NPU, DPU, FPGA...) to take part in the
+// energy attribution. The device energy is split among the processes by their share of the device utilization.
+type Accelerator interface {
+	// Name returns the device class, e.g. "npu"
+	Name() string
+	// GetEnergy returns the energy in mJ consumed by all devices of the class since the last call
+	GetEnergy() (float64, error)
+	// GetProcessUtilization returns the utilization of the devices per process pid (the tgid of its threads,
+	// as listed in /proc), in any unit
+	GetProcessUtilization() (map[uint32]float64, error)
+}
+
+// PodAccelerator is implemented by the device classes that cannot see processes and attribute
+// the utilization to pods instead, e.g. the flows offloaded to a DPU, mapped by pod IP.
+// GetProcessUtilization is not used for them.
+type PodAccelerator interface {
+	Accelerator
+	// GetPodUtilization returns the utilization of the devices per "namespace/pod"
+	GetPodUtilization() (map[string]float64, error)
+}
+
+var (
+	accelerators = map[string]Accelerator{}
+	lock         sync.Mutex
+)
+
+// Register adds a device class to the attribution, replacing a previous one with the same name
+func Register(a Accelerator) {
+	lock.Lock()
+	defer lock.Unlock()
+	accelerators[a.Name()] = a
+}
+
+func Unregister(name string) {
+	lock.Lock()
+	defer lock.Unlock()
+	delete(accelerators, name)
+}
+
+// GetEnergyPerPid returns per device class the energy in mJ attributed to each pid, to each pod
+// (for PodAccelerator) and the total energy of the class
+func GetEnergyPerPid() (map[string]map[uint32]float64, map[string]map[string]float64, map[string]float64) {
+	lock.Lock()
+	defer lock.Unlock()
+	pidEnergy := map[string]map[uint32]float64{}
+	podEnergy := map[string]map[string]float64{}
+	classEnergy := map[string]float64{}
+	for name, a := range accelerators {
+		energy, err := a.GetEnergy()
+		if err != nil {
+			log.Printf("failed to get %s energy: %v\n", name, err)
+			continue
+		}
+		classEnergy[name] = energy
+		if p, ok := a.(PodAccelerator); ok {
+			utilization, err := p.GetPodUtilization()
+			if err != nil {
+				log.Printf("failed to get %s pod utilization: %v\n", name, err)
+				continue
+			}
+			podEnergy[name] = splitPodEnergy(energy, utilization)
+			continue
+		}
+		utilization, err := a.GetProcessUtilization()
+		if err != nil {
+			log.Printf("failed to get %s process utilization: %v\n", name, err)
+			continue
+		}
+		pidEnergy[name] = splitPidEnergy(energy, utilization)
+	}
+	return pidEnergy, podEnergy, classEnergy
+}
+
+func splitPidEnergy(energy float64, utilization map[uint32]float64) map[uint32]float64 {
+	total := float64(0)
+	for _, u := range utilization {
+		total += u
+	}
+	m := make(map[uint32]float64, len(utilization))
+	if total > 0 {
+		for pid, u := range utilization {
+			m[pid] = energy * u / total
+		}
+	}
+	return m
+}
+
+func splitPodEnergy(energy float64, utilization map[string]float64) map[string]float64 {
+	total := float64(0)
+	for _, u := range utilization {
+		total += u
+	}
+	m := make(map[string]float64, len(utilization))
+	if total > 0 {
+		for pod, u := range utilization {
+			m[pod] = energy * u / total
+		}
+	}
+	return m
+}
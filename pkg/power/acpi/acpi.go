@@ -25,6 +25,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
 )
 
 const (
@@ -47,6 +49,7 @@ type ACPI struct {
 	collectEnergy    bool
 	cpuCoreFrequency map[int32]uint64 /*cpuID:value*/
 	stopChannel      chan bool
+	doneChannel      chan bool
 
 	mu sync.Mutex
 }
@@ -55,7 +58,6 @@ func NewACPIPowerMeter() *ACPI {
 	acpi := &ACPI{
 		systemEnergy:     map[string]float64{},
 		cpuCoreFrequency: map[int32]uint64{},
-		stopChannel:      make(chan bool),
 	}
 	if acpi.IsPowerSupported() {
 		acpi.collectEnergy = true
@@ -63,42 +65,69 @@ func NewACPIPowerMeter() *ACPI {
 	return acpi
 }
 
+// Run starts polling the sensors, the meter may be run again after Stop
 func (a *ACPI) Run() {
+	stop, done := make(chan bool), make(chan bool)
+	a.mu.Lock()
+	a.stopChannel, a.doneChannel = stop, done
+	a.mu.Unlock()
 	go func() {
+		defer close(done)
 		for {
 			select {
-			case <-a.stopChannel:
+			case <-stop:
 				return
 			default:
-				cpuCoreFrequency := getCPUCoreFrequency()
-				a.mu.Lock()
-				for cpu, freq := range cpuCoreFrequency {
-					// average cpu frequency
-					a.cpuCoreFrequency[cpu] = (cpuCoreFrequency[cpu] + freq) / 2
-				}
-				a.mu.Unlock()
-
-				if a.collectEnergy {
-					if sensorPower, err := getPowerFromSensor(); err == nil {
-						a.mu.Lock()
-						for sensorID, power := range sensorPower {
-							/* energy (mJ) = miliwatts*time(second) */
-							a.systemEnergy[sensorID] += power * float64(poolingInterval/time.Second)
-						}
-						a.mu.Unlock()
-					} else {
-						log.Fatal(err)
-					}
-				}
+				supervisor.Call("acpi", a.poll)
 
-				time.Sleep(poolingInterval)
+				select {
+				case <-stop:
+					return
+				case <-time.After(poolingInterval):
+				}
 			}
 		}
 	}()
 }
 
+// poll reads the cpu frequencies and the power sensors once
+func (a *ACPI) poll() {
+	if cpuCoreFrequency, err := getCPUCoreFrequency(); err == nil {
+		a.mu.Lock()
+		for cpu, freq := range cpuCoreFrequency {
+			// average cpu frequency
+			a.cpuCoreFrequency[cpu] = (cpuCoreFrequency[cpu] + freq) / 2
+		}
+		a.mu.Unlock()
+	} else {
+		log.Printf("failed to read the cpu frequencies: %v\n", err)
+	}
+
+	if a.collectEnergy {
+		if sensorPower, err := getPowerFromSensor(); err == nil {
+			a.mu.Lock()
+			for sensorID, power := range sensorPower {
+				/* energy (mJ) = miliwatts*time(second) */
+				a.systemEnergy[sensorID] += power * float64(poolingInterval/time.Second)
+			}
+			a.mu.Unlock()
+		} else {
+			log.Printf("failed to read the power sensors: %v\n", err)
+		}
+	}
+}
+
+// Stop stops polling the sensors and returns once the poller exited
 func (a *ACPI) Stop() {
-	close(a.stopChannel)
+	a.mu.Lock()
+	stop, done := a.stopChannel, a.doneChannel
+	a.stopChannel, a.doneChannel = nil, nil
+	a.mu.Unlock()
+	if stop == nil {
+		return
+	}
+	close(stop)
+	<-done
 }
 
 func (a *ACPI) GetCPUCoreFrequency() map[int32]uint64 {
@@ -111,10 +140,10 @@ func (a *ACPI) GetCPUCoreFrequency() map[int32]uint64 {
 	return shallowClone
 }
 
-func getCPUCoreFrequency() map[int32]uint64 {
+func getCPUCoreFrequency() (map[int32]uint64, error) {
 	files, err := ioutil.ReadDir(freqPathDir)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 
 	ch := make(chan []uint64)
@@ -145,7 +174,7 @@ func getCPUCoreFrequency() map[int32]uint64 {
 		}
 	}
 
-	return cpuCoreFrequency
+	return cpuCoreFrequency, nil
 }
 
 func (a *ACPI) IsPowerSupported() bool {
@@ -181,7 +210,7 @@ func getPowerFromSensor() (map[string]float64, error) {
 		if err == nil {
 			power[fmt.Sprintf("%s%d", sensorIDPrefix, i)] = float64(currPower) / 1000 /*miliWatts*/
 		} else {
-			log.Fatal(err)
+			return nil, fmt.Errorf("failed to parse %s: %v", path, err)
 		}
 	}
 
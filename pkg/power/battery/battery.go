@@ -0,0 +1,182 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package battery
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Laptops and battery backed edge gateways often have neither RAPL nor an ACPI power meter, but while
+// they run from their battery its discharge is the power of the node. The power_supply class reports
+// the power (power_now, µW) or the voltage and current (voltage_now, µV and current_now, µA), else only
+// the remaining energy (energy_now, µWh, or charge_now, µAh, times the voltage), whose deltas are used.
+const (
+	powerSupplyPath   = "/sys/class/power_supply"
+	statusDischarging = "Discharging"
+)
+
+// Meter integrates the discharge of the batteries between two reads
+type Meter struct {
+	batteries []string
+	lastRead  time.Time
+	// lastPower (mW) and lastEnergy (µWh) are the last readings per battery
+	lastPower  map[string]float64
+	lastEnergy map[string]float64
+}
+
+// Batteries returns the power supply directories of the batteries
+func Batteries() []string {
+	batteries := []string{}
+	supplies, _ := filepath.Glob(filepath.Join(powerSupplyPath, "*"))
+	for _, dir := range supplies {
+		if readString(filepath.Join(dir, "type")) == "Battery" {
+			batteries = append(batteries, dir)
+		}
+	}
+	return batteries
+}
+
+// NewMeter returns a meter of the batteries, nil if there is none
+func NewMeter() *Meter {
+	batteries := Batteries()
+	if len(batteries) == 0 {
+		return nil
+	}
+	return &Meter{batteries: batteries, lastPower: map[string]float64{}, lastEnergy: map[string]float64{}}
+}
+
+// Names returns the names of the batteries read by the meter
+func (m *Meter) Names() []string {
+	names := []string{}
+	for _, dir := range m.batteries {
+		names = append(names, filepath.Base(dir))
+	}
+	return names
+}
+
+// GetEnergy returns the energy in mJ discharged by the batteries since the last call, the batteries not
+// discharging (on AC power) are not counted
+func (m *Meter) GetEnergy() float64 {
+	now := time.Now()
+	seconds := float64(0)
+	if !m.lastRead.IsZero() {
+		seconds = now.Sub(m.lastRead).Seconds()
+	}
+	m.lastRead = now
+	total := float64(0)
+	for _, dir := range m.batteries {
+		if !isDischarging(dir) {
+			delete(m.lastPower, dir)
+			delete(m.lastEnergy, dir)
+			continue
+		}
+		if p, err := readPower(dir); err == nil {
+			if last, ok := m.lastPower[dir]; ok {
+				/* energy (mJ) = average power (mW) * time(second) */
+				total += (last + p) / 2 * seconds
+			}
+			m.lastPower[dir] = p
+			continue
+		}
+		e, err := readEnergy(dir)
+		if err != nil {
+			continue
+		}
+		if last, ok := m.lastEnergy[dir]; ok && last > e {
+			// 1 µWh = 3.6 mJ
+			total += (last - e) * 3.6
+		}
+		m.lastEnergy[dir] = e
+	}
+	return total
+}
+
+// ReadPower returns the power in W discharged by the batteries
+func ReadPower() (float64, error) {
+	total, found := float64(0), false
+	for _, dir := range Batteries() {
+		if !isDischarging(dir) {
+			continue
+		}
+		p, err := readPower(dir)
+		if err != nil {
+			continue
+		}
+		total += p / 1000
+		found = true
+	}
+	if !found {
+		return 0, fmt.Errorf("no discharging battery reporting its power")
+	}
+	return total, nil
+}
+
+func isDischarging(dir string) bool {
+	return readString(filepath.Join(dir, "status")) == statusDischarging
+}
+
+// readPower returns the power of a battery in mW
+func readPower(dir string) (float64, error) {
+	if microWatts, err := readInt(filepath.Join(dir, "power_now")); err == nil {
+		return math.Abs(microWatts) / 1000, nil
+	}
+	microAmps, err := readInt(filepath.Join(dir, "current_now"))
+	if err != nil {
+		return 0, err
+	}
+	microVolts, err := readInt(filepath.Join(dir, "voltage_now"))
+	if err != nil {
+		return 0, err
+	}
+	// some drivers report the discharge current as negative
+	return math.Abs(microAmps) * microVolts / 1e9, nil
+}
+
+// readEnergy returns the remaining energy of a battery in µWh
+func readEnergy(dir string) (float64, error) {
+	if microWattHours, err := readInt(filepath.Join(dir, "energy_now")); err == nil {
+		return microWattHours, nil
+	}
+	microAmpHours, err := readInt(filepath.Join(dir, "charge_now"))
+	if err != nil {
+		return 0, err
+	}
+	microVolts, err := readInt(filepath.Join(dir, "voltage_now"))
+	if err != nil {
+		return 0, err
+	}
+	return microAmpHours * microVolts / 1e6, nil
+}
+
+func readString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readInt(path string) (float64, error) {
+	v, err := strconv.ParseInt(readString(path), 10, 64)
+	return float64(v), err
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package dpu
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+)
+
+// BlueField-class DPUs run their own OS and measure their own power. The telemetry agent running on
+// the DPU (e.g. DOCA Telemetry Service) exposes in the prometheus text format:
+// - the board power in watts, as a gauge
+// - the bytes of each offloaded flow, as a counter labeled with the flow src_ip and dst_ip
+// The DPU energy is attributed to the pods owning the flows, in proportion to their offloaded bytes.
+const (
+	DeviceClass = "dpu"
+
+	DefaultPowerMetric = "dpu_power_watts"
+	DefaultFlowMetric  = "dpu_flow_bytes_total"
+
+	srcIPLabel  = "src_ip"
+	dstIPLabel  = "dst_ip"
+	httpTimeout = 2 * time.Second
+)
+
+type DPU struct {
+	endpoint    string
+	powerMetric string
+	flowMetric  string
+
+	lastRead  time.Time
+	lastPower float64
+	// flowBytes is the last counter value of each flow
+	flowBytes map[string]float64
+	// podBytes is the bytes offloaded per "namespace/pod" in the last interval
+	podBytes map[string]float64
+}
+
+func New(endpoint, powerMetric, flowMetric string) *DPU {
+	return &DPU{
+		endpoint:    endpoint,
+		powerMetric: powerMetric,
+		flowMetric:  flowMetric,
+		flowBytes:   map[string]float64{},
+		podBytes:    map[string]float64{},
+	}
+}
+
+func (d *DPU) Name() string {
+	return DeviceClass
+}
+
+// GetEnergy scrapes the DPU telemetry and returns the energy in mJ since the last call
+func (d *DPU) GetEnergy() (float64, error) {
+	client := &http.Client{Timeout: httpTimeout}
+	resp, err := client.Get(d.endpoint)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get response from %q: %v", d.endpoint, err)
+	}
+	defer resp.Body.Close()
+	var parser expfmt.TextParser
+	mf, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("failed to parse: %v", err)
+	}
+
+	power := float64(0)
+	if family, ok := mf[d.powerMetric]; ok {
+		for _, m := range family.Metric {
+			power += m.GetGauge().GetValue()
+		}
+	}
+	d.updateFlows(mf[d.flowMetric])
+
+	now := time.Now()
+	energy := float64(0)
+	if !d.lastRead.IsZero() {
+		/* energy (mJ) = average power (W) * time(second) * 1000 */
+		energy = (d.lastPower + power) / 2 * now.Sub(d.lastRead).Seconds() * 1000
+	}
+	d.lastRead = now
+	d.lastPower = power
+	return energy, nil
+}
+
+func (d *DPU) updateFlows(family *dto.MetricFamily) {
+	d.podBytes = map[string]float64{}
+	if family == nil {
+		return
+	}
+	flowBytes := make(map[string]float64, len(family.Metric))
+	for _, m := range family.Metric {
+		var src, dst string
+		for _, l := range m.GetLabel() {
+			switch l.GetName() {
+			case srcIPLabel:
+				src = l.GetValue()
+			case dstIPLabel:
+				dst = l.GetValue()
+			}
+		}
+		value := m.GetCounter().GetValue()
+		flow := src + "/" + dst
+		flowBytes[flow] = value
+		delta := value
+		// a counter lower than the last read means the flow was re-offloaded
+		if last, ok := d.flowBytes[flow]; ok && value >= last {
+			delta = value - last
+		}
+		if delta == 0 {
+			continue
+		}
+		info, ok := pod_lister.GetPodInfoFromIP(src)
+		if !ok {
+			info, ok = pod_lister.GetPodInfoFromIP(dst)
+		}
+		if ok {
+			d.podBytes[info.Namespace+"/"+info.PodName] += delta
+		}
+	}
+	d.flowBytes = flowBytes
+}
+
+// GetProcessUtilization is not supported, the DPU does not see the host processes
+func (d *DPU) GetProcessUtilization() (map[uint32]float64, error) {
+	return nil, nil
+}
+
+// GetPodUtilization returns the bytes offloaded per "namespace/pod" in the last interval
+func (d *DPU) GetPodUtilization() (map[string]float64, error) {
+	return d.podBytes, nil
+}
@@ -21,17 +21,58 @@ import (
 	"github.com/NVIDIA/go-nvml/pkg/nvml"
 )
 
+// The board power of a GPU is split between compute and data movement by the utilization of its SMs, and
+// of its memory bandwidth and PCIe link, weighted by the model. The compute part is shared between the
+// processes by their SM utilization, the data movement part by their memory utilization, and both by their
+// GPU memory when NVML has no process utilization samples. The consumer GPUs have neither per-process energy
+// nor, on some drivers, the running processes, their power is then split by the process utilization alone.
 var (
 	devices []nvml.Device
+	// processesWarned is set once the missing running processes of each device were logged
+	processesWarned []bool
+	// lastSeen is the timestamp of the last process utilization sample of each device
+	lastSeen []uint64
+	// linkBandwidth is the bytes per second of the PCIe link of each device in each direction
+	linkBandwidth []float64
+	counters      []DeviceCounters
 )
 
+// memNotAvailable is the used GPU memory of a process when the driver does not report it
+const memNotAvailable = ^uint64(0)
+
 type pidMem struct {
 	pid uint32
 	mem uint64
 }
 
+// pcieLaneBandwidth is the bytes per second of a PCIe lane in each direction per generation
+var pcieLaneBandwidth = map[int]float64{
+	1: 250e6,
+	2: 500e6,
+	3: 985e6,
+	4: 1969e6,
+	5: 3938e6,
+	6: 7563e6,
+}
+
+// DeviceCounters are the activity of a GPU in the last sample
+type DeviceCounters struct {
+	// SMUtil and MemUtil are the percent of the time the SMs were busy and the memory was read or written
+	SMUtil  uint32
+	MemUtil uint32
+	// PCIeRx and PCIeTx are the bytes per second received and sent over PCIe
+	PCIeRx uint64
+	PCIeTx uint64
+	// PCIeUtil is the percent of the PCIe bandwidth used, 0 if the link is not known
+	PCIeUtil float64
+}
+
+// Init finds the NVML GPUs, or the integrated GPU of a Jetson module when NVML is not available
 func Init() error {
 	if ret := nvml.Init(); ret != nvml.SUCCESS {
+		if err := initJetson(); err == nil {
+			return nil
+		}
 		return fmt.Errorf("failed to init nvml: %v", nvml.ErrorString(ret))
 	}
 	count, ret := nvml.DeviceGetCount()
@@ -49,14 +90,55 @@ func Init() error {
 		}
 		devices[i] = device
 	}
+	lastSeen = make([]uint64, count)
+	processesWarned = make([]bool, count)
+	linkBandwidth = make([]float64, count)
+	counters = make([]DeviceCounters, count)
+	for i, device := range devices {
+		gen, ret := device.GetMaxPcieLinkGeneration()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+		width, ret := device.GetMaxPcieLinkWidth()
+		if ret != nvml.SUCCESS {
+			continue
+		}
+		linkBandwidth[i] = pcieLaneBandwidth[gen] * float64(width)
+	}
 	return nil
 }
 
+// IsEnabled returns whether a GPU was found by Init
+func IsEnabled() bool {
+	return jetson != nil || len(devices) > 0
+}
+
+// GetDeviceName returns the model of the first GPU, jetson for the integrated GPU of a Jetson module
+func GetDeviceName() string {
+	if jetson != nil {
+		return "jetson"
+	}
+	if len(devices) == 0 {
+		return ""
+	}
+	name, ret := devices[0].GetName()
+	if ret != nvml.SUCCESS {
+		return ""
+	}
+	return name
+}
+
 func Shutdown() bool {
+	if jetson != nil {
+		return true
+	}
 	return nvml.Shutdown() == nvml.SUCCESS
 }
 
 func GetGpuEnergy() []uint32 {
+	if jetson != nil {
+		return []uint32{jetson.power()}
+	}
 	e := make([]uint32, len(devices))
 	for i, device := range devices {
 		power, ret := device.GetPowerUsage()
@@ -69,32 +151,125 @@ func GetGpuEnergy() []uint32 {
 	return e
 }
 
-func GetCurrGpuEnergyPerPid() (map[uint32]float64, error) {
+// GetDeviceCounters returns the activity of each NVML GPU in the last GetCurrGpuEnergyPerPid
+func GetDeviceCounters() []DeviceCounters {
+	return append([]DeviceCounters(nil), counters...)
+}
+
+// readCounters reads the utilization and the PCIe throughput of a device, in KB/s in NVML
+func readCounters(i int, device nvml.Device) DeviceCounters {
+	c := DeviceCounters{}
+	if util, ret := device.GetUtilizationRates(); ret == nvml.SUCCESS {
+		c.SMUtil, c.MemUtil = util.Gpu, util.Memory
+	}
+	if rx, ret := device.GetPcieThroughput(nvml.PCIE_UTIL_RX_BYTES); ret == nvml.SUCCESS {
+		c.PCIeRx = uint64(rx) * 1024
+	}
+	if tx, ret := device.GetPcieThroughput(nvml.PCIE_UTIL_TX_BYTES); ret == nvml.SUCCESS {
+		c.PCIeTx = uint64(tx) * 1024
+	}
+	if linkBandwidth[i] > 0 {
+		c.PCIeUtil = float64(c.PCIeRx+c.PCIeTx) / (2 * linkBandwidth[i]) * 100
+	}
+	return c
+}
+
+// readProcessUtilization returns the SM and memory utilization of the processes since the last read
+func readProcessUtilization(i int, device nvml.Device) (map[uint32]float64, map[uint32]float64) {
+	sm, mem := map[uint32]float64{}, map[uint32]float64{}
+	samples, ret := device.GetProcessUtilization(lastSeen[i])
+	if ret != nvml.SUCCESS {
+		return sm, mem
+	}
+	for _, sample := range samples {
+		sm[sample.Pid] += float64(sample.SmUtil)
+		mem[sample.Pid] += float64(sample.MemUtil)
+		if sample.TimeStamp > lastSeen[i] {
+			lastSeen[i] = sample.TimeStamp
+		}
+	}
+	return sm, mem
+}
+
+// readUsedMemory returns the GPU memory used by the compute and graphics processes, the processes are not
+// known on the consumer GPUs whose driver does not list them
+func readUsedMemory(i int, device nvml.Device) map[uint32]float64 {
+	usedMem := map[uint32]float64{}
+	compute, ret := device.GetComputeRunningProcesses()
+	if ret != nvml.SUCCESS {
+		if !processesWarned[i] {
+			fmt.Printf("failed to get compute processes on device %v, splitting its power by the process utilization: %v\n", device, nvml.ErrorString(ret))
+			processesWarned[i] = true
+		}
+		return usedMem
+	}
+	graphics, ret := device.GetGraphicsRunningProcesses()
+	if ret != nvml.SUCCESS {
+		graphics = nil
+	}
+	for _, p := range append(compute, graphics...) {
+		if p.UsedGpuMemory != memNotAvailable {
+			usedMem[p.Pid] += float64(p.UsedGpuMemory)
+		}
+	}
+	return usedMem
+}
+
+// shares returns the share of each process of a split, or of the fallback when the split is all 0
+func shares(split, fallback map[uint32]float64) map[uint32]float64 {
+	total := float64(0)
+	for _, v := range split {
+		total += v
+	}
+	if total == 0 {
+		if fallback == nil {
+			return map[uint32]float64{}
+		}
+		return shares(fallback, nil)
+	}
+	s := make(map[uint32]float64, len(split))
+	for pid, v := range split {
+		s[pid] = v / total
+	}
+	return s
+}
+
+// GetCurrGpuEnergyPerPid returns the GPU energy of each process, the board power is split between compute and
+// data movement by the weights of the SM utilization and of the memory and PCIe utilization
+func GetCurrGpuEnergyPerPid(computeWeight, dataWeight float64) (map[uint32]float64, error) {
+	if jetson != nil {
+		return jetson.powerPerPid(), nil
+	}
 	m := make(map[uint32]float64)
 
-	for _, device := range devices {
+	for i, device := range devices {
 		power, ret := device.GetPowerUsage()
 		if ret != nvml.SUCCESS {
 			fmt.Printf("failed to get power usage on device %v: %v\n", device, nvml.ErrorString(ret))
 			continue
 		}
-		pids, ret := device.GetComputeRunningProcesses()
-		if ret != nvml.SUCCESS {
-			fmt.Printf("failed to get compute processes on device %v: %v", device, nvml.ErrorString(ret))
-			continue
+		c := readCounters(i, device)
+		counters[i] = c
+		usedMem := readUsedMemory(i, device)
+		sm, mem := readProcessUtilization(i, device)
+		compute := computeWeight * float64(c.SMUtil)
+		data := dataWeight * (float64(c.MemUtil) + c.PCIeUtil)
+		computePower := float64(power)
+		if compute+data > 0 {
+			computePower = float64(power) * compute / (compute + data)
+		}
+		computeShares, dataShares := shares(sm, usedMem), shares(mem, usedMem)
+		// without any process to split a part between, it goes to the processes of the other part
+		if len(dataShares) == 0 {
+			dataShares = computeShares
+		} else if len(computeShares) == 0 {
+			computeShares = dataShares
 		}
-		totalMem := uint64(0)
-		pm := make([]pidMem, len(pids))
-		// get used memory of each pid
-		for i, pid := range pids {
-			pm[i].pid = pid.Pid
-			pm[i].mem = pid.UsedGpuMemory
-			totalMem += pm[i].mem
+		for pid, share := range computeShares {
+			m[pid] += computePower * share
 		}
-		// use per pid used memory/total used memory to estimate per pid energy
-		for _, p := range pm {
-			// fmt.Printf("pid %v power %v total mem %v mem %v\n", p.pid, power, totalMem, p.mem)
-			m[p.pid] = float64(uint64(power) * p.mem / totalMem)
+		for pid, share := range dataShares {
+			m[pid] += (float64(power) - computePower) * share
 		}
 	}
 	return m, nil
@@ -0,0 +1,133 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package gpu
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
+)
+
+// Jetson modules have an integrated GPU without NVML, its power is read from the INA3221 rail of the GPU
+// (VDD_GPU, VDD_GPU_SOC, POM_5V_GPU) and shared between the processes by their nvmap memory, as NVML
+// shares it by their GPU memory. The CPU and SoC rails are read by the collector from the same sensors.
+const (
+	deviceTreeCompatible = "/proc/device-tree/compatible"
+	tegraCompatible      = "nvidia,tegra"
+	nvmapClientsPath     = "/sys/kernel/debug/nvmap/iovmm/clients"
+)
+
+type jetsonGPU struct {
+	rails []soc.Sensor
+	// nvmapWarned is set once the missing nvmap clients were logged
+	nvmapWarned bool
+}
+
+var jetson *jetsonGPU
+
+// initJetson finds the GPU rails of a Jetson module
+func initJetson() error {
+	compatible, err := ioutil.ReadFile(deviceTreeCompatible)
+	if err != nil || !bytes.Contains(compatible, []byte(tegraCompatible)) {
+		return fmt.Errorf("not a Jetson module")
+	}
+	rails := []soc.Sensor{}
+	for _, s := range soc.DiscoverSensors() {
+		if soc.SensorSubsystem(s.Name) == soc.SubsystemGPU {
+			rails = append(rails, s)
+		}
+	}
+	if len(rails) == 0 {
+		return fmt.Errorf("no GPU rail found on the Jetson module")
+	}
+	for _, r := range rails {
+		log.Printf("reading the Jetson GPU power from %s\n", r.Name)
+	}
+	jetson = &jetsonGPU{rails: rails}
+	return nil
+}
+
+// power returns the power of the GPU rails in mW
+func (j *jetsonGPU) power() uint32 {
+	total := float64(0)
+	for _, r := range j.rails {
+		p, err := r.Power()
+		if err != nil {
+			log.Printf("failed to read the GPU rail %s: %v\n", r.Name, err)
+			continue
+		}
+		total += p
+	}
+	return uint32(total)
+}
+
+func (j *jetsonGPU) powerPerPid() map[uint32]float64 {
+	m := make(map[uint32]float64)
+	power := j.power()
+	data, err := ioutil.ReadFile(nvmapClientsPath)
+	if err != nil {
+		if !j.nvmapWarned {
+			log.Printf("failed to read the GPU memory of the processes from %s, is debugfs mounted? %v\n", nvmapClientsPath, err)
+			j.nvmapWarned = true
+		}
+		return m
+	}
+	pm := parseNvmapClients(data)
+	totalMem := uint64(0)
+	for _, p := range pm {
+		totalMem += p.mem
+	}
+	if totalMem == 0 {
+		return m
+	}
+	for _, p := range pm {
+		m[p.pid] += float64(uint64(power) * p.mem / totalMem)
+	}
+	return m
+}
+
+// parseNvmapClients parses the nvmap clients, e.g.
+//
+//	CLIENT                        PROCESS      PID        SIZE
+//	user                        gst-launch    2710      52480K
+//	total                                               52480K
+func parseNvmapClients(data []byte) []pidMem {
+	pm := []pidMem{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 {
+			continue
+		}
+		pid, err := strconv.ParseUint(fields[2], 10, 32)
+		if err != nil {
+			continue
+		}
+		kb, err := strconv.ParseUint(strings.TrimSuffix(fields[3], "K"), 10, 64)
+		if err != nil {
+			continue
+		}
+		pm = append(pm, pidMem{pid: uint32(pid), mem: kb * 1024})
+	}
+	return pm
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nic
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/battery"
+)
+
+// The calibration sends UDP traffic on the interface at several rates, with small and large packets
+// so that the per-byte and the per-packet costs can be told apart, while reading the wall (hwmon power
+// meter) or battery power. The power above the idle baseline is fitted by least squares:
+//
+//	power - idle = energyPerByte * bytes/s + energyPerPacket * packets/s
+const (
+	hwmonPowerGlob   = "/sys/class/hwmon/hwmon*/device/power*_average"
+	powerSamplePause = 200 * time.Millisecond
+	// the payloads of the small and large packets
+	smallPayload = 64
+	largePayload = 1400
+)
+
+type measure struct {
+	byteRate   float64
+	packetRate float64
+	// power above the idle baseline in W
+	power float64
+}
+
+// Calibrate measures the coefficients of iface, sending to target (host:port, any UDP sink) at each rate
+// in bytes per second for stepDuration
+func Calibrate(iface, target string, rates []float64, stepDuration time.Duration) (Coefficients, error) {
+	log.Printf("measuring the idle power for %v\n", stepDuration)
+	idle, err := averagePower(stepDuration, nil)
+	if err != nil {
+		return Coefficients{}, err
+	}
+	measures := []measure{}
+	for _, rate := range rates {
+		for _, payload := range []int{smallPayload, largePayload} {
+			m, err := measureRate(iface, target, rate, payload, stepDuration)
+			if err != nil {
+				return Coefficients{}, err
+			}
+			m.power -= idle
+			log.Printf("%s: %.0f B/s %.0f packets/s: %.3f W above idle\n", iface, m.byteRate, m.packetRate, m.power)
+			measures = append(measures, m)
+		}
+	}
+	return fit(measures)
+}
+
+func measureRate(iface, target string, rate float64, payload int, duration time.Duration) (measure, error) {
+	conn, err := dialOnInterface(iface, target)
+	if err != nil {
+		return measure{}, err
+	}
+	defer conn.Close()
+	bytes0, packets0, err := readTxStats(iface)
+	if err != nil {
+		return measure{}, err
+	}
+	start := time.Now()
+	stop := make(chan bool)
+	go send(conn, rate, payload, stop)
+	power, err := averagePower(duration, stop)
+	if err != nil {
+		return measure{}, err
+	}
+	elapsed := time.Since(start).Seconds()
+	bytes1, packets1, err := readTxStats(iface)
+	if err != nil {
+		return measure{}, err
+	}
+	return measure{
+		byteRate:   float64(bytes1-bytes0) / elapsed,
+		packetRate: float64(packets1-packets0) / elapsed,
+		power:      power,
+	}, nil
+}
+
+func dialOnInterface(iface, target string) (net.Conn, error) {
+	dialer := net.Dialer{
+		Control: func(network, address string, c syscall.RawConn) error {
+			var err error
+			if cerr := c.Control(func(fd uintptr) {
+				err = syscall.BindToDevice(int(fd), iface)
+			}); cerr != nil {
+				return cerr
+			}
+			return err
+		},
+	}
+	conn, err := dialer.DialContext(context.Background(), "udp", target)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send on %s to %s: %v", iface, target, err)
+	}
+	return conn, nil
+}
+
+// send paces the packets every millisecond to keep the rate
+func send(conn net.Conn, rate float64, payload int, stop chan bool) {
+	buf := make([]byte, payload)
+	ticker := time.NewTicker(time.Millisecond)
+	defer ticker.Stop()
+	start := time.Now()
+	sent := float64(0)
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			due := rate * time.Since(start).Seconds()
+			for sent < due {
+				// a full socket buffer is expected at saturation
+				_, _ = conn.Write(buf)
+				sent += float64(payload)
+			}
+		}
+	}
+}
+
+// averagePower samples the power for duration, then closes stop if not nil
+func averagePower(duration time.Duration, stop chan bool) (float64, error) {
+	if stop != nil {
+		defer close(stop)
+	}
+	total, n := float64(0), 0
+	for deadline := time.Now().Add(duration); time.Now().Before(deadline); time.Sleep(powerSamplePause) {
+		power, err := ReadSystemPower()
+		if err != nil {
+			return 0, err
+		}
+		total += power
+		n++
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no power sample in %v", duration)
+	}
+	return total / float64(n), nil
+}
+
+// ReadSystemPower returns the wall power in W from the hwmon power meter, or the battery discharge power
+func ReadSystemPower() (float64, error) {
+	paths, _ := filepath.Glob(hwmonPowerGlob)
+	if len(paths) > 0 {
+		power := float64(0)
+		for _, path := range paths {
+			microWatts, err := readUint(path)
+			if err != nil {
+				return 0, err
+			}
+			power += float64(microWatts) / 1e6
+		}
+		return power, nil
+	}
+	power, err := battery.ReadPower()
+	if err != nil {
+		return 0, fmt.Errorf("no power meter: %v", err)
+	}
+	return power, nil
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
+
+// fit solves the least squares normal equations of power = a * byteRate + b * packetRate
+func fit(measures []measure) (Coefficients, error) {
+	var sbb, sbp, spp, sby, spy float64
+	for _, m := range measures {
+		sbb += m.byteRate * m.byteRate
+		sbp += m.byteRate * m.packetRate
+		spp += m.packetRate * m.packetRate
+		sby += m.byteRate * m.power
+		spy += m.packetRate * m.power
+	}
+	det := sbb*spp - sbp*sbp
+	if det == 0 {
+		return Coefficients{}, fmt.Errorf("cannot fit the coefficients, the traffic did not vary")
+	}
+	a := (sby*spp - spy*sbp) / det
+	b := (spy*sbb - sby*sbp) / det
+	/* J to nJ */
+	return Coefficients{EnergyPerByte: a * 1e9, EnergyPerPacket: b * 1e9}, nil
+}
@@ -0,0 +1,122 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package nic
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// The NIC attribution model estimates the energy of the traffic of an interface from its byte and
+// packet counts. The coefficients depend on the NIC and the driver, they are measured on the device
+// by the calibration routine and kept in the store.
+const (
+	modelKey     = "nic_model"
+	netClassPath = "/sys/class/net"
+)
+
+// Coefficients are the energy costs of an interface in nJ
+type Coefficients struct {
+	EnergyPerByte   float64 `json:"energy_per_byte"`
+	EnergyPerPacket float64 `json:"energy_per_packet"`
+}
+
+var (
+	model = map[string]Coefficients{}
+	lock  sync.Mutex
+)
+
+// LoadModel reads the calibrated coefficients from the store
+func LoadModel() error {
+	lock.Lock()
+	defer lock.Unlock()
+	m := map[string]Coefficients{}
+	if _, err := store.Load(modelKey, &m); err != nil {
+		return err
+	}
+	model = m
+	return nil
+}
+
+// SetCoefficients saves the calibrated coefficients of an interface in the store
+func SetCoefficients(iface string, c Coefficients) error {
+	lock.Lock()
+	defer lock.Unlock()
+	model[iface] = c
+	return store.Save(modelKey, model)
+}
+
+func GetCoefficients(iface string) (Coefficients, bool) {
+	lock.Lock()
+	defer lock.Unlock()
+	c, ok := model[iface]
+	return c, ok
+}
+
+// GetModelCoefficients returns the coefficients of an interface, or those of the only calibrated
+// interface if iface is empty
+func GetModelCoefficients(iface string) (Coefficients, bool) {
+	if len(iface) > 0 {
+		return GetCoefficients(iface)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	if len(model) != 1 {
+		return Coefficients{}, false
+	}
+	for _, c := range model {
+		return c, true
+	}
+	return Coefficients{}, false
+}
+
+// GetEnergy returns the energy in mJ of the given traffic on a calibrated interface
+func GetEnergy(iface string, bytes, packets uint64) (float64, bool) {
+	c, ok := GetCoefficients(iface)
+	if !ok {
+		return 0, false
+	}
+	/* nJ to mJ */
+	return (c.EnergyPerByte*float64(bytes) + c.EnergyPerPacket*float64(packets)) / 1e6, true
+}
+
+// readTxStats returns the transmitted bytes and packets of an interface
+func readTxStats(iface string) (uint64, uint64, error) {
+	bytes, err := readStat(iface, "tx_bytes")
+	if err != nil {
+		return 0, 0, err
+	}
+	packets, err := readStat(iface, "tx_packets")
+	if err != nil {
+		return 0, 0, err
+	}
+	return bytes, packets, nil
+}
+
+func readStat(iface, name string) (uint64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(netClassPath, iface, "statistics", name))
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s of %s: %v", name, iface, err)
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
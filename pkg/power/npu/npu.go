@@ -0,0 +1,165 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Edge AI boxes run most of their inference on the NPU (NVDLA on Jetson, RKNPU on RK3588). The NPU is
+// registered as an accelerator: its energy is split evenly among the processes holding the NPU device
+// open, since neither driver reports the per-process utilization.
+const (
+	DeviceClass = "npu"
+
+	procPath = "/proc"
+)
+
+// backend reads the NPU of a SoC family
+type backend interface {
+	name() string
+	// read returns the NPU power in mW and the utilization (0-100) of each core
+	read() (float64, map[string]float64, error)
+	// devices are the device nodes opened by the processes using the NPU
+	devices() []string
+	close()
+}
+
+type NPU struct {
+	backend backend
+
+	lock        sync.Mutex
+	lastRead    time.Time
+	lastPower   float64
+	utilization map[string]float64
+}
+
+var utilizationDesc = prometheus.NewDesc(
+	"node_npu_utilization_percent",
+	"NPU core utilization.",
+	[]string{
+		"backend",
+		"core",
+	},
+	nil,
+)
+
+// Detect returns the NPU of the SoC, or nil if none is supported
+func Detect() *NPU {
+	if b := newRKNPU(); b != nil {
+		return &NPU{backend: b, utilization: map[string]float64{}}
+	}
+	if b := newTegra(); b != nil {
+		return &NPU{backend: b, utilization: map[string]float64{}}
+	}
+	return nil
+}
+
+func (n *NPU) Name() string {
+	return DeviceClass
+}
+
+// GetEnergy returns the NPU energy in mJ since the last call
+func (n *NPU) GetEnergy() (float64, error) {
+	power, utilization, err := n.backend.read()
+	if err != nil {
+		return 0, fmt.Errorf("failed to read the %s npu: %v", n.backend.name(), err)
+	}
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	now := time.Now()
+	energy := float64(0)
+	if !n.lastRead.IsZero() {
+		/* energy (mJ) = average power (mW) * time(second) */
+		energy = (n.lastPower + power) / 2 * now.Sub(n.lastRead).Seconds()
+	}
+	n.lastRead = now
+	n.lastPower = power
+	n.utilization = utilization
+	return energy, nil
+}
+
+// GetProcessUtilization returns an even share for each process holding the NPU device open
+func (n *NPU) GetProcessUtilization() (map[uint32]float64, error) {
+	return processesUsing(n.backend.devices())
+}
+
+func (n *NPU) Close() {
+	n.backend.close()
+}
+
+func (n *NPU) Describe(ch chan<- *prometheus.Desc) {
+	ch <- utilizationDesc
+}
+
+func (n *NPU) Collect(ch chan<- prometheus.Metric) {
+	n.lock.Lock()
+	defer n.lock.Unlock()
+	for core, u := range n.utilization {
+		ch <- prometheus.MustNewConstMetric(utilizationDesc, prometheus.GaugeValue, u, n.backend.name(), core)
+	}
+}
+
+// processesUsing scans the open file descriptors of all processes for the device nodes, the processes are
+// keyed by their pid, the tgid the collector looks their threads up with as they share the descriptors
+func processesUsing(devices []string) (map[uint32]float64, error) {
+	utilization := map[uint32]float64{}
+	if len(devices) == 0 {
+		return utilization, nil
+	}
+	procs, err := ioutil.ReadDir(procPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, p := range procs {
+		pid, err := strconv.ParseUint(p.Name(), 10, 32)
+		if err != nil {
+			continue
+		}
+		fdDir := filepath.Join(procPath, p.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			target, err := os.Readlink(filepath.Join(fdDir, fd.Name()))
+			if err == nil && isDevice(target, devices) {
+				utilization[uint32(pid)] = 1
+				break
+			}
+		}
+	}
+	return utilization, nil
+}
+
+func isDevice(path string, devices []string) bool {
+	for _, d := range devices {
+		if strings.HasPrefix(path, d) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npu
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power/soc"
+)
+
+// The Rockchip NPU driver reports its load in debugfs, e.g. "NPU load:  Core0: 35%, Core1:  0%, Core2:  0%,"
+// (a single "NPU load:  35%" on the older drivers). The NPU power is estimated from the load, capped by
+// the power of its regulator rail when the PMIC exposes the current: the rail reports its current limit,
+// an upper bound of the NPU power.
+const (
+	rknpuLoadPath   = "/sys/kernel/debug/rknpu/load"
+	rknpuMiscDevice = "/dev/rknpu"
+	drmClassPath    = "/sys/class/drm"
+	rknpuDriver     = "RKNPU"
+
+	// RK3588 NPU power estimate in mW, idle and at full load on the 3 cores
+	rknpuIdlePower = 100
+	rknpuMaxPower  = 3000
+)
+
+var (
+	reRKNPUCoreLoad = regexp.MustCompile(`(Core\d+):\s*(\d+)%`)
+	reRKNPULoad     = regexp.MustCompile(`NPU load:\s*(\d+)%`)
+)
+
+type rknpu struct {
+	topology *soc.Topology
+	devs     []string
+}
+
+func newRKNPU() backend {
+	if _, err := os.Stat(rknpuLoadPath); err != nil {
+		return nil
+	}
+	r := &rknpu{}
+	r.topology, _ = soc.ParseDeviceTree()
+	if _, err := os.Stat(rknpuMiscDevice); err == nil {
+		r.devs = append(r.devs, rknpuMiscDevice)
+	}
+	// the newer drivers are DRM drivers, find their render node
+	nodes, _ := filepath.Glob(filepath.Join(drmClassPath, "renderD*"))
+	for _, node := range nodes {
+		driver, err := os.Readlink(filepath.Join(node, "device", "driver"))
+		if err == nil && filepath.Base(driver) == rknpuDriver {
+			r.devs = append(r.devs, "/dev/dri/"+filepath.Base(node))
+		}
+	}
+	return r
+}
+
+func (r *rknpu) name() string {
+	return "rknpu"
+}
+
+func (r *rknpu) read() (float64, map[string]float64, error) {
+	data, err := ioutil.ReadFile(rknpuLoadPath)
+	if err != nil {
+		return 0, nil, err
+	}
+	utilization := map[string]float64{}
+	for _, m := range reRKNPUCoreLoad.FindAllStringSubmatch(string(data), -1) {
+		utilization[m[1]], _ = strconv.ParseFloat(m[2], 64)
+	}
+	if len(utilization) == 0 {
+		m := reRKNPULoad.FindStringSubmatch(string(data))
+		if m == nil {
+			return 0, nil, fmt.Errorf("unexpected npu load %q", string(data))
+		}
+		utilization["Core0"], _ = strconv.ParseFloat(m[1], 64)
+	}
+
+	load := float64(0)
+	for _, u := range utilization {
+		load += u
+	}
+	load /= float64(len(utilization)) * 100
+	power := rknpuIdlePower + load*(rknpuMaxPower-rknpuIdlePower)
+	if r.topology != nil {
+		if rails, err := soc.ReadRegulatorPower(); err == nil {
+			if limit, ok := r.topology.Breakdown(rails)[soc.SubsystemNPU]; ok && limit < power {
+				power = limit
+			}
+		}
+	}
+	return power, utilization, nil
+}
+
+func (r *rknpu) devices() []string {
+	return r.devs
+}
+
+func (r *rknpu) close() {
+}
@@ -0,0 +1,123 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package npu
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// On Jetson the DLA cores sit on the CV rail, read through tegrastats. A line contains e.g.
+// "... NVDLA0@1395 NVDLA1 off ... CV 812mW/790mW ..." (Xavier prints "CV 812/790", Orin "VDD_CV 812mW/790mW").
+// A DLA core with a clock is busy, tegrastats does not report a finer load.
+const (
+	tegrastatsCommand  = "tegrastats"
+	tegrastatsInterval = "1000"
+	nvdlaDevicePrefix  = "/dev/nvhost-ctrl-nvdla"
+	nvdlaDevicePrefix2 = "/dev/nvhost-nvdla"
+)
+
+var (
+	reTegraCVPower = regexp.MustCompile(`\b(?:VDD_)?CV\w*\s+(\d+)(?:mW)?/(\d+)(?:mW)?`)
+	reTegraDLA     = regexp.MustCompile(`\b(NVDLA\d+)(@\d+|\s+off|\s+(\d+)%)`)
+)
+
+type tegra struct {
+	cmd *exec.Cmd
+
+	lock        sync.Mutex
+	power       float64
+	utilization map[string]float64
+	err         error
+}
+
+func newTegra() backend {
+	path, err := exec.LookPath(tegrastatsCommand)
+	if err != nil {
+		return nil
+	}
+	cmd := exec.Command(path, "--interval", tegrastatsInterval)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil
+	}
+	if err = cmd.Start(); err != nil {
+		log.Printf("failed to start %s: %v\n", path, err)
+		return nil
+	}
+	t := &tegra{cmd: cmd, utilization: map[string]float64{}, err: fmt.Errorf("no tegrastats output yet")}
+	supervisor.Go("npu", func() {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			t.parse(scanner.Text())
+		}
+		t.lock.Lock()
+		t.err = fmt.Errorf("tegrastats exited")
+		t.lock.Unlock()
+	})
+	return t
+}
+
+func (t *tegra) parse(line string) {
+	utilization := map[string]float64{}
+	for _, m := range reTegraDLA.FindAllStringSubmatch(line, -1) {
+		switch {
+		case len(m[3]) > 0:
+			utilization[m[1]], _ = strconv.ParseFloat(m[3], 64)
+		case m[2][0] == '@':
+			utilization[m[1]] = 100
+		default:
+			utilization[m[1]] = 0
+		}
+	}
+	power := float64(0)
+	if m := reTegraCVPower.FindStringSubmatch(line); m != nil {
+		power, _ = strconv.ParseFloat(m[1], 64)
+	}
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.power = power
+	t.utilization = utilization
+	t.err = nil
+}
+
+func (t *tegra) name() string {
+	return "nvdla"
+}
+
+func (t *tegra) read() (float64, map[string]float64, error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.power, t.utilization, t.err
+}
+
+func (t *tegra) devices() []string {
+	return []string{nvdlaDevicePrefix, nvdlaDevicePrefix2}
+}
+
+func (t *tegra) close() {
+	if t.cmd.Process != nil {
+		_ = t.cmd.Process.Kill()
+	}
+}
@@ -0,0 +1,82 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+)
+
+const (
+	ipmitool = "ipmitool"
+	// ipmiTimeout bounds a reading, a BMC over LAN may not answer
+	ipmiTimeout = 10 * time.Second
+)
+
+// e.g. "    Instantaneous power reading:                   212 Watts"
+var reIPMIPower = regexp.MustCompile(`Instantaneous power reading:\s*([0-9.]+)\s*Watts`)
+
+// ipmi reads the DCMI power reading with ipmitool, of the local BMC or of a BMC over LAN
+type ipmi struct {
+	args []string
+}
+
+func newIPMI(c *config.PlatformMeter) (*ipmi, error) {
+	if _, err := exec.LookPath(ipmitool); err != nil {
+		return nil, fmt.Errorf("failed to find %s: %v", ipmitool, err)
+	}
+	args := []string{}
+	if len(c.Endpoint) > 0 {
+		args = append(args, "-I", "lanplus", "-H", c.Endpoint)
+		// without a user, ipmitool uses the null user of the BMC
+		if len(c.Username) > 0 {
+			args = append(args, "-U", c.Username)
+		}
+		if len(c.PasswordFile) > 0 {
+			args = append(args, "-f", c.PasswordFile)
+		}
+	}
+	return &ipmi{args: append(args, "dcmi", "power", "reading")}, nil
+}
+
+func (i *ipmi) name() string {
+	return "IPMI DCMI"
+}
+
+func (i *ipmi) readPower() (float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ipmiTimeout)
+	defer cancel()
+	out, err := exec.CommandContext(ctx, ipmitool, i.args...).Output()
+	if err != nil {
+		return 0, fmt.Errorf("failed to run %s: %v", ipmitool, err)
+	}
+	return parseDCMIPower(out)
+}
+
+func parseDCMIPower(out []byte) (float64, error) {
+	m := reIPMIPower.FindSubmatch(out)
+	if m == nil {
+		return 0, fmt.Errorf("no power reading in the %s output", ipmitool)
+	}
+	return strconv.ParseFloat(string(m[1]), 64)
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+)
+
+// The plugs publish their power on an MQTT broker: the tele/<topic>/SENSOR telemetry of Tasmota (every
+// TelePeriod, 300 s unless set lower), the <prefix>/status/switch:0 status of the Shelly Gen2+ devices and
+// the shellies/<id>/relay/0/power number of Gen1. The last power published is read at each poll, until it
+// is older than mqttPlugMaxAge.
+const (
+	mqttPlugConnectTimeout = 10 * time.Second
+	mqttPlugMaxAge         = 10 * time.Minute
+)
+
+// mqttPlug reads the power a Tasmota or Shelly plug publishes on a topic
+type mqttPlug struct {
+	kind   string
+	broker string
+	topic  string
+
+	lock       sync.Mutex
+	power      float64
+	received   time.Time
+	parseError error
+}
+
+func newMQTTPlug(c *config.PlatformMeter, clientID string) (*mqttPlug, error) {
+	if len(c.Endpoint) == 0 {
+		return nil, fmt.Errorf("no %s MQTT broker configured", c.Type)
+	}
+	p := &mqttPlug{kind: c.Type, broker: c.Endpoint, topic: c.Topic}
+	opts := mqtt.NewClientOptions().
+		AddBroker(c.Endpoint).
+		SetClientID(clientID).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(client mqtt.Client) {
+			// subscribe again after a reconnection
+			token := client.Subscribe(p.topic, 0, func(_ mqtt.Client, msg mqtt.Message) {
+				p.handleMessage(msg.Payload(), time.Now())
+			})
+			if token.WaitTimeout(mqttPlugConnectTimeout) && token.Error() != nil {
+				p.lock.Lock()
+				p.parseError = fmt.Errorf("failed to subscribe to %s: %v", p.topic, token.Error())
+				p.lock.Unlock()
+			}
+		})
+	if len(c.Username) > 0 {
+		password, err := readPassword(c)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetUsername(c.Username).SetPassword(password)
+	}
+	client := mqtt.NewClient(opts)
+	token := client.Connect()
+	if !token.WaitTimeout(mqttPlugConnectTimeout) {
+		return nil, fmt.Errorf("timeout connecting to %s", c.Endpoint)
+	}
+	if err := token.Error(); err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", c.Endpoint, err)
+	}
+	return p, nil
+}
+
+func (p *mqttPlug) name() string {
+	return p.kind + " " + p.broker + " " + p.topic
+}
+
+func (p *mqttPlug) handleMessage(payload []byte, now time.Time) {
+	power, err := parsePlugPayload(p.kind, payload)
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.parseError = err
+	if err == nil {
+		p.power, p.received = power, now
+	}
+}
+
+func (p *mqttPlug) readPower() (float64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.parseError != nil {
+		return 0, p.parseError
+	}
+	if p.received.IsZero() || time.Since(p.received) > mqttPlugMaxAge {
+		return 0, fmt.Errorf("no power published on %s for %v", p.topic, mqttPlugMaxAge)
+	}
+	return p.power, nil
+}
+
+// parsePlugPayload returns the power of a Tasmota telemetry or status, or of a Shelly switch status or power
+func parsePlugPayload(kind string, payload []byte) (float64, error) {
+	if kind == TypeShelly {
+		if power, err := strconv.ParseFloat(strings.TrimSpace(string(payload)), 64); err == nil {
+			return power, nil
+		}
+		var sw shellySwitchStatus
+		if err := json.Unmarshal(payload, &sw); err != nil || sw.APower == nil {
+			return 0, fmt.Errorf("no Shelly power in %q", payload)
+		}
+		return *sw.APower, nil
+	}
+	var telemetry struct {
+		Energy *struct {
+			Power json.RawMessage `json:"Power"`
+		} `json:"ENERGY"`
+		tasmotaStatus
+	}
+	if err := json.Unmarshal(payload, &telemetry); err != nil {
+		return 0, fmt.Errorf("invalid Tasmota payload: %v", err)
+	}
+	if telemetry.Energy != nil {
+		return parseTasmotaPower(telemetry.Energy.Power)
+	}
+	if telemetry.StatusSNS.Energy != nil {
+		return parseTasmotaPower(telemetry.StatusSNS.Energy.Power)
+	}
+	return 0, fmt.Errorf("no Tasmota energy in %q", payload)
+}
@@ -0,0 +1,146 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// Bare-metal edge servers often lack the ACPI power meter, but their BMC reports the node power, through
+// IPMI DCMI or the Redfish Chassis Power resource. The BMC is polled in the background, its readings are
+// slow (seconds) so the energy of a sample is the last reading integrated over the time since the
+// previous sample, the readings older than staleIntervals polls are not used.
+const (
+	TypeIPMI    = "ipmi"
+	TypeRedfish = "redfish"
+
+	defaultPollInterval = 10 * time.Second
+	staleIntervals      = 3
+	sensorID            = "platform"
+)
+
+// reader reads the node power in W from the BMC or the plug
+type reader interface {
+	name() string
+	readPower() (float64, error)
+}
+
+// Meter polls the BMC or the smart plug, it replaces the ACPI power meter as the host energy source
+type Meter struct {
+	reader   reader
+	interval time.Duration
+
+	lock       sync.Mutex
+	power      float64
+	readAt     time.Time
+	lastEnergy time.Time
+	lastErr    error
+}
+
+// New returns the meter selected by the config, nil if none is configured. The smart plugs are polled at
+// the sample period unless a poll interval is configured. The MQTT client ID must be unique among the
+// clients of the broker.
+func New(c *config.PlatformMeter, samplePeriod time.Duration, mqttClientID string) (*Meter, error) {
+	var r reader
+	var err error
+	switch c.Type {
+	case "":
+		return nil, nil
+	case TypeIPMI:
+		r, err = newIPMI(c)
+	case TypeRedfish:
+		r, err = newRedfish(c)
+	case TypeTasmota, TypeShelly:
+		if len(c.Topic) > 0 {
+			r, err = newMQTTPlug(c, mqttClientID)
+		} else {
+			r, err = newHTTPPlug(c)
+		}
+	case TypeTPLink:
+		r, err = newKasaPlug(c)
+	default:
+		return nil, fmt.Errorf("unknown platform meter %q", c.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	interval := c.PollInterval
+	if interval <= 0 && isPlug(c.Type) {
+		interval = samplePeriod
+	}
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	return &Meter{reader: r, interval: interval}, nil
+}
+
+// Run polls the meter in the background
+func (m *Meter) Run() {
+	supervisor.Go("platform", func() {
+		ticker := time.NewTicker(m.interval)
+		for {
+			m.poll()
+			<-ticker.C
+		}
+	})
+}
+
+func (m *Meter) poll() {
+	power, err := m.reader.readPower()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	if err != nil {
+		if m.lastErr == nil || m.lastErr.Error() != err.Error() {
+			log.Printf("failed to read the node power from %s: %v\n", m.reader.name(), err)
+		}
+		m.lastErr = err
+		return
+	}
+	if m.lastErr != nil {
+		log.Printf("reading the node power from %s again\n", m.reader.name())
+		m.lastErr = nil
+	}
+	m.power, m.readAt = power, time.Now()
+}
+
+// GetEnergyFromHost returns the energy in mJ since the last call, like the ACPI power meter
+func (m *Meter) GetEnergyFromHost() (map[string]float64, error) {
+	now := time.Now()
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	last := m.lastEnergy
+	m.lastEnergy = now
+	if m.readAt.IsZero() || now.Sub(m.readAt) > staleIntervals*m.interval {
+		return map[string]float64{}, fmt.Errorf("no recent power reading from %s", m.reader.name())
+	}
+	if last.IsZero() {
+		return map[string]float64{}, nil
+	}
+	/* energy (mJ) = power (W) * 1000 * time(second) */
+	return map[string]float64{sensorID: m.power * 1000 * now.Sub(last).Seconds()}, nil
+}
+
+// Name returns the BMC interface or the plug read by the meter
+func (m *Meter) Name() string {
+	return m.reader.name()
+}
@@ -0,0 +1,379 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+)
+
+// Edge labs often measure the whole device with a smart plug. The plugs are read through their local
+// API: the Status 8 command of Tasmota, the Switch.GetStatus RPC of the Shelly Gen2+ devices (the
+// /status meters of Gen1), and the emeter get_realtime command of the TP-Link Kasa plugs on TCP 9999,
+// or through the MQTT broker they publish to. They measure the wall power, including the losses of the
+// power supply. The Shelly Gen1 devices take the basic authentication, Gen2+ the SHA-256 digest
+// authentication of the user admin.
+const (
+	TypeTasmota = "tasmota"
+	TypeShelly  = "shelly"
+	TypeTPLink  = "tplink"
+
+	plugTimeout = 5 * time.Second
+	kasaPort    = "9999"
+	// the Kasa protocol XORs each byte with the previous encrypted byte, starting with this key
+	kasaKey = 171
+)
+
+// isPlug returns whether the meter type is a smart plug, polled at the sample period
+func isPlug(kind string) bool {
+	return kind == TypeTasmota || kind == TypeShelly || kind == TypeTPLink
+}
+
+// httpPlug reads the plugs with an HTTP API
+type httpPlug struct {
+	kind     string
+	endpoint string
+	username string
+	password string
+	client   *http.Client
+	// shellyGen1 is set once the Shelly device answered the Gen1 API only
+	shellyGen1 bool
+	// digest is the last digest authentication challenge of a Shelly Gen2+ device
+	digest *digestChallenge
+}
+
+// digestChallenge is a WWW-Authenticate Digest challenge, nc counts the requests with its nonce
+type digestChallenge struct {
+	realm, nonce, opaque, algorithm string
+	nc                              uint32
+}
+
+func newHTTPPlug(c *config.PlatformMeter) (*httpPlug, error) {
+	if len(c.Endpoint) == 0 {
+		return nil, fmt.Errorf("no %s endpoint configured", c.Type)
+	}
+	endpoint := strings.TrimSuffix(c.Endpoint, "/")
+	if !strings.Contains(endpoint, "://") {
+		endpoint = "http://" + endpoint
+	}
+	password, err := readPassword(c)
+	if err != nil {
+		return nil, err
+	}
+	return &httpPlug{kind: c.Type, endpoint: endpoint, username: c.Username, password: password, client: &http.Client{Timeout: plugTimeout}}, nil
+}
+
+// readPassword reads the password file of the plug, empty if none is set
+func readPassword(c *config.PlatformMeter) (string, error) {
+	if len(c.PasswordFile) == 0 {
+		return "", nil
+	}
+	password, err := ioutil.ReadFile(c.PasswordFile)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %v", c.PasswordFile, err)
+	}
+	return strings.TrimSpace(string(password)), nil
+}
+
+func (p *httpPlug) name() string {
+	return p.kind + " " + p.endpoint
+}
+
+func (p *httpPlug) get(path string, v interface{}) error {
+	res, err := p.do(path)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", path, err)
+	}
+	// a new or expired digest nonce, the request is sent again with it
+	if res.StatusCode == http.StatusUnauthorized && p.kind == TypeShelly && len(p.username) > 0 {
+		if challenge := parseDigestChallenge(res.Header.Get("WWW-Authenticate")); challenge != nil {
+			res.Body.Close()
+			p.digest = challenge
+			if res, err = p.do(path); err != nil {
+				return fmt.Errorf("failed to get %s: %v", path, err)
+			}
+		}
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", path, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+func (p *httpPlug) do(path string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", p.endpoint+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.kind == TypeShelly && len(p.username) > 0 {
+		if p.digest != nil {
+			req.Header.Set("Authorization", p.digest.authorization(p.username, p.password, "GET", req.URL.RequestURI()))
+		} else {
+			req.SetBasicAuth(p.username, p.password)
+		}
+	}
+	return p.client.Do(req)
+}
+
+// parseDigestChallenge returns the Digest challenge of a WWW-Authenticate header, nil if there is none
+func parseDigestChallenge(header string) *digestChallenge {
+	if !strings.HasPrefix(header, "Digest ") {
+		return nil
+	}
+	c := &digestChallenge{algorithm: "MD5"}
+	for _, param := range strings.Split(strings.TrimPrefix(header, "Digest "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(param), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		value := strings.Trim(kv[1], `"`)
+		switch strings.ToLower(kv[0]) {
+		case "realm":
+			c.realm = value
+		case "nonce":
+			c.nonce = value
+		case "opaque":
+			c.opaque = value
+		case "algorithm":
+			c.algorithm = value
+		}
+	}
+	// the Shelly Gen2+ devices challenge with SHA-256 only
+	if len(c.nonce) == 0 || c.algorithm != "SHA-256" {
+		return nil
+	}
+	return c
+}
+
+// authorization returns the Authorization header of a request, RFC 7616 with qop auth
+func (c *digestChallenge) authorization(username, password, method, uri string) string {
+	hash := func(s string) string {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	c.nc++
+	nc := fmt.Sprintf("%08x", c.nc)
+	b := make([]byte, 8)
+	_, _ = rand.Read(b)
+	cnonce := hex.EncodeToString(b)
+	ha1 := hash(username + ":" + c.realm + ":" + password)
+	ha2 := hash(method + ":" + uri)
+	response := hash(ha1 + ":" + c.nonce + ":" + nc + ":" + cnonce + ":auth:" + ha2)
+	header := fmt.Sprintf(`Digest username="%s", realm="%s", nonce="%s", uri="%s", algorithm=SHA-256, qop=auth, nc=%s, cnonce="%s", response="%s"`,
+		username, c.realm, c.nonce, uri, nc, cnonce, response)
+	if len(c.opaque) > 0 {
+		header += fmt.Sprintf(`, opaque="%s"`, c.opaque)
+	}
+	return header
+}
+
+func (p *httpPlug) readPower() (float64, error) {
+	if p.kind == TypeTasmota {
+		return p.readTasmota()
+	}
+	return p.readShelly()
+}
+
+type tasmotaStatus struct {
+	StatusSNS struct {
+		Energy *struct {
+			// a number, or an array on the multi channel devices
+			Power json.RawMessage `json:"Power"`
+		} `json:"ENERGY"`
+	} `json:"StatusSNS"`
+}
+
+func (p *httpPlug) readTasmota() (float64, error) {
+	params := url.Values{}
+	params.Set("cmnd", "Status 8")
+	if len(p.username) > 0 {
+		params.Set("user", p.username)
+		params.Set("password", p.password)
+	}
+	var status tasmotaStatus
+	if err := p.get("/cm?"+params.Encode(), &status); err != nil {
+		return 0, err
+	}
+	if status.StatusSNS.Energy == nil {
+		return 0, fmt.Errorf("no energy monitoring on the Tasmota device")
+	}
+	return parseTasmotaPower(status.StatusSNS.Energy.Power)
+}
+
+func parseTasmotaPower(raw json.RawMessage) (float64, error) {
+	var power float64
+	if err := json.Unmarshal(raw, &power); err == nil {
+		return power, nil
+	}
+	var channels []float64
+	if err := json.Unmarshal(raw, &channels); err != nil {
+		return 0, fmt.Errorf("invalid Tasmota power %s", string(raw))
+	}
+	power = 0
+	for _, c := range channels {
+		power += c
+	}
+	return power, nil
+}
+
+type shellySwitchStatus struct {
+	APower *float64 `json:"apower"`
+}
+
+type shellyStatus struct {
+	Meters []struct {
+		Power float64 `json:"power"`
+	} `json:"meters"`
+	EMeters []struct {
+		Power float64 `json:"power"`
+	} `json:"emeters"`
+}
+
+func (p *httpPlug) readShelly() (float64, error) {
+	if !p.shellyGen1 {
+		var sw shellySwitchStatus
+		err := p.get("/rpc/Switch.GetStatus?id=0", &sw)
+		if err == nil && sw.APower != nil {
+			return *sw.APower, nil
+		}
+	}
+	var status shellyStatus
+	if err := p.get("/status", &status); err != nil {
+		return 0, err
+	}
+	p.shellyGen1 = true
+	if len(status.Meters) == 0 && len(status.EMeters) == 0 {
+		return 0, fmt.Errorf("no power meter on the Shelly device")
+	}
+	power := float64(0)
+	for _, m := range status.Meters {
+		power += m.Power
+	}
+	for _, m := range status.EMeters {
+		power += m.Power
+	}
+	return power, nil
+}
+
+// kasaPlug reads the TP-Link Kasa plugs with an energy meter (HS110, KP115)
+type kasaPlug struct {
+	address string
+}
+
+type kasaRealtime struct {
+	Emeter struct {
+		Realtime struct {
+			ErrCode int `json:"err_code"`
+			// the newer firmwares report mW, the older W
+			PowerMW *float64 `json:"power_mw"`
+			Power   *float64 `json:"power"`
+		} `json:"get_realtime"`
+	} `json:"emeter"`
+}
+
+func newKasaPlug(c *config.PlatformMeter) (*kasaPlug, error) {
+	if len(c.Endpoint) == 0 {
+		return nil, fmt.Errorf("no %s endpoint configured", c.Type)
+	}
+	address := c.Endpoint
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, kasaPort)
+	}
+	return &kasaPlug{address: address}, nil
+}
+
+func (k *kasaPlug) name() string {
+	return "tplink " + k.address
+}
+
+func (k *kasaPlug) readPower() (float64, error) {
+	conn, err := net.DialTimeout("tcp", k.address, plugTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("failed to connect to %s: %v", k.address, err)
+	}
+	defer conn.Close()
+	_ = conn.SetDeadline(time.Now().Add(plugTimeout))
+	if _, err = conn.Write(kasaEncrypt([]byte(`{"emeter":{"get_realtime":{}}}`))); err != nil {
+		return 0, fmt.Errorf("failed to send to %s: %v", k.address, err)
+	}
+	var length uint32
+	if err = binary.Read(conn, binary.BigEndian, &length); err != nil {
+		return 0, fmt.Errorf("failed to read from %s: %v", k.address, err)
+	}
+	payload := make([]byte, length)
+	if _, err = io.ReadFull(conn, payload); err != nil {
+		return 0, fmt.Errorf("failed to read from %s: %v", k.address, err)
+	}
+	var rt kasaRealtime
+	if err = json.Unmarshal(kasaDecrypt(payload), &rt); err != nil {
+		return 0, fmt.Errorf("failed to parse the response of %s: %v", k.address, err)
+	}
+	r := rt.Emeter.Realtime
+	switch {
+	case r.ErrCode != 0:
+		return 0, fmt.Errorf("%s has no energy meter (error %d)", k.address, r.ErrCode)
+	case r.PowerMW != nil:
+		return *r.PowerMW / 1000, nil
+	case r.Power != nil:
+		return *r.Power, nil
+	}
+	return 0, fmt.Errorf("no power in the response of %s", k.address)
+}
+
+// kasaEncrypt encrypts a request, prefixed with its big endian length
+func kasaEncrypt(plain []byte) []byte {
+	var buf bytes.Buffer
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(plain)))
+	key := byte(kasaKey)
+	for _, b := range plain {
+		key ^= b
+		buf.WriteByte(key)
+	}
+	return buf.Bytes()
+}
+
+func kasaDecrypt(cipher []byte) []byte {
+	plain := make([]byte, len(cipher))
+	key := byte(kasaKey)
+	for i, b := range cipher {
+		plain[i] = key ^ b
+		key = b
+	}
+	return plain
+}
@@ -0,0 +1,175 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package platform
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/config"
+)
+
+const redfishTimeout = 10 * time.Second
+
+// redfish reads the PowerConsumedWatts of the Power resource of a chassis, or the PowerWatts of its
+// EnvironmentMetrics on the services having deprecated the Power resource
+type redfish struct {
+	endpoint string
+	username string
+	password string
+	chassis  string
+	client   *http.Client
+	// powerPath is the resource of the chassis found by the first read
+	powerPath string
+}
+
+type redfishCollection struct {
+	Members []struct {
+		ID string `json:"@odata.id"`
+	} `json:"Members"`
+}
+
+type redfishPower struct {
+	PowerControl []struct {
+		PowerConsumedWatts *float64 `json:"PowerConsumedWatts"`
+	} `json:"PowerControl"`
+}
+
+type redfishEnvironmentMetrics struct {
+	PowerWatts *struct {
+		Reading *float64 `json:"Reading"`
+	} `json:"PowerWatts"`
+}
+
+func newRedfish(c *config.PlatformMeter) (*redfish, error) {
+	if len(c.Endpoint) == 0 {
+		return nil, fmt.Errorf("no Redfish endpoint configured")
+	}
+	r := &redfish{
+		endpoint: strings.TrimSuffix(c.Endpoint, "/"),
+		username: c.Username,
+		chassis:  c.Chassis,
+		client: &http.Client{
+			Timeout: redfishTimeout,
+			Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{InsecureSkipVerify: c.InsecureSkipVerify}, // BMCs often have self-signed certificates
+			},
+		},
+	}
+	if len(c.PasswordFile) > 0 {
+		password, err := ioutil.ReadFile(c.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", c.PasswordFile, err)
+		}
+		r.password = strings.TrimSpace(string(password))
+	}
+	return r, nil
+}
+
+func (r *redfish) name() string {
+	return "Redfish " + r.endpoint
+}
+
+func (r *redfish) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", r.endpoint+path, nil)
+	if err != nil {
+		return err
+	}
+	if len(r.username) > 0 {
+		req.SetBasicAuth(r.username, r.password)
+	}
+	req.Header.Set("Accept", "application/json")
+	res, err := r.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get %s: %v", path, err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to get %s: %s", path, res.Status)
+	}
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	return nil
+}
+
+func (r *redfish) readPower() (float64, error) {
+	if len(r.powerPath) > 0 {
+		power, ok, err := r.readPowerOf(r.powerPath)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return power, nil
+		}
+	}
+	chassis := []string{}
+	if len(r.chassis) > 0 {
+		chassis = append(chassis, "/redfish/v1/Chassis/"+r.chassis)
+	} else {
+		var collection redfishCollection
+		if err := r.get("/redfish/v1/Chassis", &collection); err != nil {
+			return 0, err
+		}
+		for _, m := range collection.Members {
+			chassis = append(chassis, m.ID)
+		}
+	}
+	for _, c := range chassis {
+		for _, path := range []string{c + "/Power", c + "/EnvironmentMetrics"} {
+			power, ok, err := r.readPowerOf(path)
+			if err == nil && ok {
+				r.powerPath = path
+				return power, nil
+			}
+		}
+	}
+	return 0, fmt.Errorf("no chassis reporting its power")
+}
+
+// readPowerOf reads a Power or EnvironmentMetrics resource, ok is false if it holds no reading
+func (r *redfish) readPowerOf(path string) (float64, bool, error) {
+	if strings.HasSuffix(path, "/EnvironmentMetrics") {
+		var metrics redfishEnvironmentMetrics
+		if err := r.get(path, &metrics); err != nil {
+			return 0, false, err
+		}
+		if metrics.PowerWatts == nil || metrics.PowerWatts.Reading == nil {
+			return 0, false, nil
+		}
+		return *metrics.PowerWatts.Reading, true, nil
+	}
+	var power redfishPower
+	if err := r.get(path, &power); err != nil {
+		return 0, false, err
+	}
+	for _, p := range power.PowerControl {
+		if p.PowerConsumedWatts != nil {
+			return *p.PowerConsumedWatts, true, nil
+		}
+	}
+	return 0, false, nil
+}
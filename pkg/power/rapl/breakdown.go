@@ -0,0 +1,84 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rapl
+
+import (
+	"log"
+	"strconv"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	packageEnergyDesc = prometheus.NewDesc(
+		"node_cpu_package_energy_joule_total",
+		"Energy of the CPU package measured by RAPL.",
+		[]string{"node", "package"},
+		nil,
+	)
+	packageCoreEnergyDesc = prometheus.NewDesc(
+		"node_cpu_package_core_energy_joule_total",
+		"Energy of the cores of the CPU package measured by RAPL.",
+		[]string{"node", "package"},
+		nil,
+	)
+	ccdEnergyDesc = prometheus.NewDesc(
+		"node_cpu_ccd_energy_joule_total",
+		"Energy of the cores of the AMD CCD (the cores sharing an L3 cache) measured by RAPL.",
+		[]string{"node", "package", "ccd"},
+		nil,
+	)
+)
+
+// Breakdown exports the energy per CPU package, of its cores and per CCD, as far as the selected backend measures them
+type Breakdown struct {
+	node string
+}
+
+func NewBreakdown(node string) *Breakdown {
+	return &Breakdown{node: node}
+}
+
+func (b *Breakdown) Describe(ch chan<- *prometheus.Desc) {
+	ch <- packageEnergyDesc
+	ch <- packageCoreEnergyDesc
+	ch <- ccdEnergyDesc
+}
+
+func (b *Breakdown) Collect(ch chan<- prometheus.Metric) {
+	packages, err := GetEnergyPerPackage()
+	if err != nil {
+		log.Printf("failed to get the package energy: %v\n", err)
+	}
+	for pkg, e := range packages {
+		ch <- prometheus.MustNewConstMetric(packageEnergyDesc, prometheus.CounterValue, float64(e)/1000, b.node, strconv.Itoa(pkg))
+	}
+	cores, err := GetCoreEnergyPerPackage()
+	if err != nil {
+		log.Printf("failed to get the package core energy: %v\n", err)
+	}
+	for pkg, e := range cores {
+		ch <- prometheus.MustNewConstMetric(packageCoreEnergyDesc, prometheus.CounterValue, float64(e)/1000, b.node, strconv.Itoa(pkg))
+	}
+	ccds, err := GetEnergyPerCCD()
+	if err != nil {
+		log.Printf("failed to get the CCD energy: %v\n", err)
+	}
+	for ccd, e := range ccds {
+		ch <- prometheus.MustNewConstMetric(ccdEnergyDesc, prometheus.CounterValue, float64(e)/1000, b.node, strconv.Itoa(ccd.Package), strconv.Itoa(ccd.ID))
+	}
+}
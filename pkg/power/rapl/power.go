@@ -18,6 +18,8 @@ package rapl
 
 import (
 	"fmt"
+	"log"
+	"sync"
 
 	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
 )
@@ -36,50 +38,228 @@ type powerInterface interface {
 }
 
 var (
-	dummyImpl                   = &source.PowerDummy{}
-	sysfsImpl                   = &source.PowerSysfs{}
-	msrImpl                     = &source.PowerMSR{}
-	estimateImpl                = &source.PowerEstimate{}
-	powerImpl    powerInterface = sysfsImpl
-	useMSR                      = false // it looks MSR on kvm or hyper-v is not working
+	dummyImpl                    = &source.PowerDummy{}
+	sysfsImpl                    = &source.PowerSysfs{}
+	msrImpl                      = &source.PowerMSR{}
+	amdMSRImpl                   = &source.PowerAMDMSR{}
+	amdEnergyImpl                = &source.PowerAMDEnergy{}
+	estimateImpl                 = &source.PowerEstimate{}
+	powerImpl     powerInterface = sysfsImpl
+	useMSR                       = false // it looks MSR on kvm or hyper-v is not working
+	// msrFailed is set once the MSR reads failed, they are not selected again
+	msrFailed bool
+
+	powerLock sync.Mutex
+	// lastEnergy is the last energy returned per domain, energyOffset keeps the counters
+	// continuous when the MSR reads fail and the powercap sysfs takes over
+	lastEnergy    = map[string]uint64{}
+	energyOffset  = map[string]uint64{}
+	domainReaders = map[string]func(powerInterface) (uint64, error){
+		"dram":    powerInterface.GetEnergyFromDram,
+		"core":    powerInterface.GetEnergyFromCore,
+		"uncore":  powerInterface.GetEnergyFromUncore,
+		"package": powerInterface.GetEnergyFromPackage,
+	}
 )
 
 func init() {
-	if sysfsImpl.IsSupported() /*&& false*/ {
+	selectPower()
+}
+
+// SetUseMSR sets whether the RAPL MSRs are read in preference to the powercap sysfs of the intel_rapl driver,
+// the sysfs remains the fallback when the MSRs can not be opened or read (kernel lockdown, unprivileged containers)
+func SetUseMSR(use bool) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	if use == useMSR {
+		return
+	}
+	powerImpl.StopPower()
+	useMSR = use
+	selectPower()
+	rebaseEnergy()
+}
+
+// selectPower selects the backend of the CPU architecture: Zen has its own MSRs and the amd_energy hwmon driver,
+// which unlike the powercap zones of the intel_rapl driver break the core energy down per CCD
+func selectPower() {
+	arch, _ := source.GetCPUArchitecture()
+	amd := source.IsAMDArchitecture(arch)
+	msr := powerInterface(msrImpl)
+	if amd {
+		msr = amdMSRImpl
+	}
+	switch {
+	case useMSR && !msrFailed && msr.IsSupported():
+		fmt.Println("use MSR to obtain power")
+		powerImpl = msr
+	case amd && amdEnergyImpl.IsSupported():
+		fmt.Println("use amd_energy to obtain power")
+		powerImpl = amdEnergyImpl
+	case sysfsImpl.IsSupported():
 		fmt.Println("use sysfs to obtain power")
 		powerImpl = sysfsImpl
-	} else {
-		if msrImpl.IsSupported() && useMSR {
-			fmt.Println("use MSR to obtain power")
-			powerImpl = msrImpl
-		} else {
-			if estimateImpl.IsSupported() {
-				fmt.Println("use power estimate to obtain power")
-				powerImpl = estimateImpl
-			} else {
-				fmt.Println("power not supported")
-				powerImpl = dummyImpl
-			}
+	case amd && !useMSR && !msrFailed && msr.IsSupported():
+		// before kernel 5.8 the intel_rapl driver does not handle Zen
+		fmt.Println("use MSR to obtain power")
+		powerImpl = msr
+	case estimateImpl.IsSupported():
+		fmt.Println("use power estimate to obtain power")
+		powerImpl = estimateImpl
+	default:
+		fmt.Println("power not supported")
+		powerImpl = dummyImpl
+	}
+}
+
+func isMSR(p powerInterface) bool {
+	return p == msrImpl || p == amdMSRImpl
+}
+
+// readEnergy reads the energy of a domain, falling back from the MSRs to the next backend when they fail
+func readEnergy(domain string) (uint64, error) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	read := domainReaders[domain]
+	e, err := read(powerImpl)
+	if err != nil && isMSR(powerImpl) {
+		log.Printf("failed to read the RAPL MSRs, falling back: %v\n", err)
+		fallbackFromMSR()
+		e, err = read(powerImpl)
+	}
+	if err != nil {
+		return 0, err
+	}
+	e += energyOffset[domain]
+	lastEnergy[domain] = e
+	return e, nil
+}
+
+func fallbackFromMSR() {
+	powerImpl.StopPower()
+	msrFailed = true
+	selectPower()
+	rebaseEnergy()
+}
+
+// rebaseEnergy offsets the counters of a newly selected backend to continue from the last energy returned,
+// as the callers compute the energy deltas between the reads
+func rebaseEnergy() {
+	for domain, last := range lastEnergy {
+		if e, err := domainReaders[domain](powerImpl); err == nil {
+			energyOffset[domain] = last - e
 		}
 	}
 }
 
 func GetEnergyFromDram() (uint64, error) {
-	return powerImpl.GetEnergyFromDram()
+	return readEnergy("dram")
 }
 
 func GetEnergyFromCore() (uint64, error) {
-	return powerImpl.GetEnergyFromCore()
+	return readEnergy("core")
 }
 
 func GetEnergyFromUncore() (uint64, error) {
-	return powerImpl.GetEnergyFromUncore()
+	return readEnergy("uncore")
 }
 
 func GetEnergyFromPackage() (uint64, error) {
-	return powerImpl.GetEnergyFromPackage()
+	return readEnergy("package")
 }
 
 func StopPower() {
+	powerLock.Lock()
+	defer powerLock.Unlock()
 	powerImpl.StopPower()
 }
+
+// IsPlatformSupported returns whether the platform (psys) energy is available
+func IsPlatformSupported() bool {
+	return sysfsImpl.IsSupported() && source.IsPsysSupported()
+}
+
+// GetEnergyFromPlatform returns mJ in the platform (psys) domain
+func GetEnergyFromPlatform() (uint64, error) {
+	return source.GetEnergyFromPsys()
+}
+
+// IsMeasured returns whether the core and DRAM energy are measured by RAPL, rather than estimated or missing
+func IsMeasured() bool {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	return powerImpl != estimateImpl && powerImpl != dummyImpl
+}
+
+// IsEstimated returns whether the core and DRAM energy are estimated from the CPU model and utilization
+func IsEstimated() bool {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	return powerImpl == estimateImpl
+}
+
+// HasDramDomain returns whether the DRAM energy is measured by its own domain
+func HasDramDomain() bool {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	return powerImpl == sysfsImpl && source.HasDramDomain()
+}
+
+type packageBreakdown interface {
+	GetEnergyPerPackage() (map[int]uint64, error)
+}
+
+type packageCoreBreakdown interface {
+	GetCoreEnergyPerPackage() (map[int]uint64, error)
+}
+
+type ccdBreakdown interface {
+	GetEnergyPerCCD() (map[source.CCD]uint64, error)
+}
+
+// GetEnergyPerPackage returns mJ per package, nil if the backend does not measure the packages separately
+func GetEnergyPerPackage() (map[int]uint64, error) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	if b, ok := powerImpl.(packageBreakdown); ok {
+		return b.GetEnergyPerPackage()
+	}
+	return nil, nil
+}
+
+// GetCoreEnergyPerPackage returns mJ in the cores of each package, the sum of its CCDs on AMD, nil if the
+// backend does not measure the packages separately
+func GetCoreEnergyPerPackage() (map[int]uint64, error) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	if b, ok := powerImpl.(packageCoreBreakdown); ok {
+		return b.GetCoreEnergyPerPackage()
+	}
+	if b, ok := powerImpl.(ccdBreakdown); ok {
+		ccds, err := b.GetEnergyPerCCD()
+		if err != nil || ccds == nil {
+			return nil, err
+		}
+		energy := map[int]uint64{}
+		for ccd, e := range ccds {
+			energy[ccd.Package] += e
+		}
+		return energy, nil
+	}
+	return nil, nil
+}
+
+// GetCPUPackages returns the package of each CPU
+func GetCPUPackages() (map[int]int, error) {
+	return source.GetCPUPackages()
+}
+
+// GetEnergyPerCCD returns mJ in the cores of each CCD, nil if the backend does not measure the cores separately
+func GetEnergyPerCCD() (map[source.CCD]uint64, error) {
+	powerLock.Lock()
+	defer powerLock.Unlock()
+	if b, ok := powerImpl.(ccdBreakdown); ok {
+		return b.GetEnergyPerCCD()
+	}
+	return nil, nil
+}
@@ -0,0 +1,101 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package rapl
+
+import (
+	"log"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/power"
+)
+
+// The RAPL counters are registered twice: measured, above the board sensors, and estimated from the CPU
+// model, below them.
+const (
+	SourceName         = "rapl"
+	EstimateSourceName = "rapl-estimate"
+)
+
+// raplSource turns the cumulative core and DRAM counters into the energy since the previous call
+type raplSource struct {
+	name      string
+	available func() bool
+	lock      sync.Mutex
+	last      map[string]uint64
+}
+
+func init() {
+	power.Register(&raplSource{name: SourceName, available: IsMeasured}, power.PriorityMeasured)
+	power.Register(&raplSource{name: EstimateSourceName, available: IsEstimated}, power.PriorityEstimate)
+}
+
+func (s *raplSource) Name() string {
+	return s.name
+}
+
+// Init reads the counters, the first reading is the energy since then
+func (s *raplSource) Init() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.last = map[string]uint64{}
+	for _, zone := range []string{power.ZoneCore, power.ZoneDram} {
+		if e, err := domainEnergy(zone); err == nil {
+			s.last[zone] = e
+		}
+	}
+	return nil
+}
+
+func (s *raplSource) IsAvailable() bool {
+	return s.available()
+}
+
+func (s *raplSource) GetEnergy(zone string) (float64, error) {
+	if zone != power.ZoneCore && zone != power.ZoneDram {
+		return 0, power.ErrNoZone
+	}
+	e, err := domainEnergy(zone)
+	if err != nil {
+		return 0, err
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	last, ok := s.last[zone]
+	s.last[zone] = e
+	if !ok {
+		return 0, nil
+	}
+	if e < last {
+		// the counters are accumulated across their wraps, a decrease is a re-initialization, the interval is lost
+		log.Printf("%s energy counter re-initialized from %v to %v mJ\n", zone, last, e)
+		return 0, nil
+	}
+	return float64(e - last), nil
+}
+
+func (s *raplSource) Shutdown() {
+	if s.name == SourceName {
+		StopPower()
+	}
+}
+
+func domainEnergy(zone string) (uint64, error) {
+	if zone == power.ZoneCore {
+		return GetEnergyFromCore()
+	}
+	return GetEnergyFromDram()
+}
@@ -0,0 +1,155 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AMD Zen processors have RAPL package and per-core energy counters but no DRAM domain. They are read
+// through the powercap sysfs (intel_rapl driver, kernel 5.8+), the amd_energy hwmon driver or the MSRs.
+// The per-core counters are summed per CCD, the cores sharing an L3 cache: a CCD since Zen 3, a CCX on Zen and Zen 2.
+const (
+	amdVendor = "AuthenticAMD"
+
+	l3CacheIDPath      = "/sys/devices/system/cpu/cpu%d/cache/index3/id"
+	threadSiblingsPath = "/sys/devices/system/cpu/cpu%d/topology/thread_siblings_list"
+)
+
+// CCD identifies the CCD of a package
+type CCD struct {
+	Package int
+	ID      int
+}
+
+// cpuIdentity is the vendor, family and model of the first processor of /proc/cpuinfo
+type cpuIdentity struct {
+	vendor string
+	family int
+	model  int
+}
+
+func getCPUIdentity() (cpuIdentity, error) {
+	var id cpuIdentity
+	b, err := ioutil.ReadFile("/proc/cpuinfo")
+	if err != nil {
+		return id, err
+	}
+	for _, line := range strings.Split(string(b), "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			if len(id.vendor) > 0 {
+				// end of the first processor
+				break
+			}
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		switch key {
+		case "vendor_id":
+			id.vendor = value
+		case "cpu family":
+			id.family, _ = strconv.Atoi(value)
+		case "model":
+			id.model, _ = strconv.Atoi(value)
+		}
+	}
+	if len(id.vendor) == 0 {
+		return id, fmt.Errorf("no vendor_id in /proc/cpuinfo")
+	}
+	return id, nil
+}
+
+// getAMDArchitecture returns the Zen generation of the AMD family and model
+func getAMDArchitecture(family, model int) (string, error) {
+	switch family {
+	case 0x17:
+		// Naples, Summit and Pinnacle Ridge, Raven Ridge, Picasso: Zen and Zen+
+		if model < 0x30 {
+			return "Zen", nil
+		}
+		return "Zen 2", nil
+	case 0x19:
+		// Genoa, Raphael, Phoenix, Bergamo
+		if (model >= 0x10 && model < 0x20) || model >= 0x60 {
+			return "Zen 4", nil
+		}
+		return "Zen 3", nil
+	case 0x1a:
+		return "Zen 5", nil
+	}
+	return "", fmt.Errorf("no Zen architecture for AMD family 0x%x model 0x%x", family, model)
+}
+
+// IsAMDArchitecture returns whether the architecture returned by GetCPUArchitecture is an AMD Zen generation
+func IsAMDArchitecture(arch string) bool {
+	return strings.HasPrefix(arch, "Zen")
+}
+
+// getCCD returns the package and CCD of a CPU
+func getCCD(cpu int) (CCD, error) {
+	pkg, err := readIntFile(fmt.Sprintf(topologyPath, cpu))
+	if err != nil {
+		return CCD{}, err
+	}
+	l3, err := readIntFile(fmt.Sprintf(l3CacheIDPath, cpu))
+	if err != nil {
+		// no L3 id before kernel 5.x, one CCD per package
+		l3 = 0
+	}
+	return CCD{Package: pkg, ID: l3}, nil
+}
+
+// getPhysicalCores returns the first CPU of each physical core, the per-core counters are shared by the SMT siblings
+func getPhysicalCores() ([]int, error) {
+	paths, err := filepath.Glob(strings.Replace(threadSiblingsPath, "%d", "[0-9]*", 1))
+	if err != nil || len(paths) == 0 {
+		return nil, fmt.Errorf("failed to list the CPU topology: %v", err)
+	}
+	seen := map[int]bool{}
+	var cores []int
+	for _, p := range paths {
+		data, err := ioutil.ReadFile(p)
+		if err != nil {
+			continue
+		}
+		// e.g. "0,64" or "0-1"
+		siblings := strings.FieldsFunc(strings.TrimSpace(string(data)), func(r rune) bool { return r == ',' || r == '-' })
+		if len(siblings) == 0 {
+			continue
+		}
+		first, err := strconv.Atoi(siblings[0])
+		if err != nil || seen[first] {
+			continue
+		}
+		seen[first] = true
+		cores = append(cores, first)
+	}
+	return cores, nil
+}
+
+func readIntFile(path string) (int, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
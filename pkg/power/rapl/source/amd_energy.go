@@ -0,0 +1,163 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// The amd_energy hwmon driver (out of tree since kernel 5.13) exposes the per-core and per-socket RAPL
+// counters, accumulated to 64 bits, as energyN_input in µJ labelled EcoreNNN (the core of CPU NNN) and EsocketN.
+const (
+	hwmonNameGlob   = "/sys/class/hwmon/hwmon*/name"
+	amdEnergyDriver = "amd_energy"
+)
+
+type PowerAMDEnergy struct {
+	// coreInputs and socketInputs are the energyN_input files per CPU and per package
+	coreInputs   map[int]string
+	socketInputs map[int]string
+	ccds         map[int]CCD
+}
+
+func (r *PowerAMDEnergy) IsSupported() bool {
+	names, _ := filepath.Glob(hwmonNameGlob)
+	for _, name := range names {
+		data, err := ioutil.ReadFile(name)
+		if err != nil || strings.TrimSpace(string(data)) != amdEnergyDriver {
+			continue
+		}
+		if err := r.discover(filepath.Dir(name)); err != nil {
+			return false
+		}
+		return len(r.socketInputs) > 0
+	}
+	return false
+}
+
+// discover maps the energy inputs of the hwmon device to the CPUs and packages
+func (r *PowerAMDEnergy) discover(dir string) error {
+	labels, err := filepath.Glob(filepath.Join(dir, "energy*_label"))
+	if err != nil {
+		return err
+	}
+	r.coreInputs = map[int]string{}
+	r.socketInputs = map[int]string{}
+	r.ccds = map[int]CCD{}
+	for _, label := range labels {
+		data, err := ioutil.ReadFile(label)
+		if err != nil {
+			continue
+		}
+		input := strings.TrimSuffix(label, "_label") + "_input"
+		name := strings.TrimSpace(string(data))
+		switch {
+		case strings.HasPrefix(name, "Ecore"):
+			cpu, err := strconv.Atoi(strings.TrimPrefix(name, "Ecore"))
+			if err != nil {
+				continue
+			}
+			ccd, err := getCCD(cpu)
+			if err != nil {
+				continue
+			}
+			r.coreInputs[cpu] = input
+			r.ccds[cpu] = ccd
+		case strings.HasPrefix(name, "Esocket"):
+			pkg, err := strconv.Atoi(strings.TrimPrefix(name, "Esocket"))
+			if err != nil {
+				continue
+			}
+			r.socketInputs[pkg] = input
+		}
+	}
+	return nil
+}
+
+func sumEnergyInputs(inputs map[int]string) (uint64, error) {
+	energy := uint64(0)
+	for _, input := range inputs {
+		e, err := readEnergyFile(input)
+		if err != nil {
+			return 0, err
+		}
+		energy += e
+	}
+	return energy, nil
+}
+
+// GetEnergyFromDram returns 0 as Zen has no DRAM domain
+func (r *PowerAMDEnergy) GetEnergyFromDram() (uint64, error) {
+	return 0, nil
+}
+
+func (r *PowerAMDEnergy) GetEnergyFromCore() (uint64, error) {
+	return sumEnergyInputs(r.coreInputs)
+}
+
+// GetEnergyFromUncore returns the package energy not spent in the cores: the I/O die, the L3 caches and the fabric
+func (r *PowerAMDEnergy) GetEnergyFromUncore() (uint64, error) {
+	pkg, err := r.GetEnergyFromPackage()
+	if err != nil {
+		return 0, err
+	}
+	core, err := r.GetEnergyFromCore()
+	if err != nil {
+		return 0, err
+	}
+	if core > pkg {
+		return 0, nil
+	}
+	return pkg - core, nil
+}
+
+func (r *PowerAMDEnergy) GetEnergyFromPackage() (uint64, error) {
+	return sumEnergyInputs(r.socketInputs)
+}
+
+func (r *PowerAMDEnergy) StopPower() {
+}
+
+// GetEnergyPerPackage returns mJ per package
+func (r *PowerAMDEnergy) GetEnergyPerPackage() (map[int]uint64, error) {
+	energy := map[int]uint64{}
+	for pkg, input := range r.socketInputs {
+		e, err := readEnergyFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the energy of package %d: %v", pkg, err)
+		}
+		energy[pkg] = e
+	}
+	return energy, nil
+}
+
+// GetEnergyPerCCD returns mJ in the cores of each CCD
+func (r *PowerAMDEnergy) GetEnergyPerCCD() (map[CCD]uint64, error) {
+	energy := map[CCD]uint64{}
+	for cpu, input := range r.coreInputs {
+		e, err := readEnergyFile(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the energy of cpu %d: %v", cpu, err)
+		}
+		energy[r.ccds[cpu]] += e
+	}
+	return energy, nil
+}
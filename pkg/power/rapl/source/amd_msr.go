@@ -0,0 +1,194 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"fmt"
+	"math"
+	"syscall"
+)
+
+// The AMD RAPL MSRs: a 32-bit energy counter per core and per package, in the energy unit of the power unit MSR.
+// The counters wrap within minutes on a loaded EPYC, they are accumulated to 64 bits at each read.
+const (
+	MSR_AMD_RAPL_POWER_UNIT    = 0xc0010299
+	MSR_AMD_CORE_ENERGY_STATUS = 0xc001029a
+	MSR_AMD_PKG_ENERGY_STATUS  = 0xc001029b
+)
+
+// msrCounter accumulates a wrapping 32-bit energy counter
+type msrCounter struct {
+	fd    int
+	last  uint32
+	total uint64
+	init  bool
+}
+
+func (c *msrCounter) read(msr int64) (uint64, error) {
+	buf := make([]byte, 8)
+	n, err := syscall.Pread(c.fd, buf, msr)
+	if err != nil {
+		return 0, err
+	}
+	if n != 8 {
+		return 0, fmt.Errorf("wrong bytes: %d", n)
+	}
+	raw := uint32(byteOrder.Uint64(buf))
+	// the counters start at 0 on the first read, the package and core ones from the same time
+	if c.init {
+		c.total += uint64(raw - c.last)
+	}
+	c.init = true
+	c.last = raw
+	return c.total, nil
+}
+
+type PowerAMDMSR struct {
+	energyUnit float64 // J
+	cores      map[int]*msrCounter
+	packages   map[int]*msrCounter
+	ccds       map[int]CCD
+}
+
+func (r *PowerAMDMSR) IsSupported() bool {
+	if err := r.open(); err != nil {
+		fmt.Printf("no AMD RAPL MSR: %v\n", err)
+		r.StopPower()
+		return false
+	}
+	return true
+}
+
+// open opens the MSR device of the first CPU of each physical core, and reads the energy unit
+func (r *PowerAMDMSR) open() error {
+	cpus, err := getPhysicalCores()
+	if err != nil {
+		return err
+	}
+	r.cores = map[int]*msrCounter{}
+	r.packages = map[int]*msrCounter{}
+	r.ccds = map[int]CCD{}
+	for _, cpu := range cpus {
+		ccd, err := getCCD(cpu)
+		if err != nil {
+			return err
+		}
+		path := fmt.Sprintf(msrPath, cpu)
+		fd, err := syscall.Open(path, syscall.O_RDONLY, 0)
+		if err != nil {
+			return fmt.Errorf("failed to open path %s: %v", path, err)
+		}
+		r.cores[cpu] = &msrCounter{fd: fd}
+		r.ccds[cpu] = ccd
+		if _, exist := r.packages[ccd.Package]; !exist {
+			r.packages[ccd.Package] = &msrCounter{fd: fd}
+		}
+	}
+	for _, c := range r.packages {
+		buf := make([]byte, 8)
+		if _, err := syscall.Pread(c.fd, buf, MSR_AMD_RAPL_POWER_UNIT); err != nil {
+			return fmt.Errorf("failed to read power unit: %v", err)
+		}
+		r.energyUnit = math.Pow(0.5, float64((byteOrder.Uint64(buf)>>8)&0x1f))
+		break
+	}
+	return nil
+}
+
+// toMilliJoules converts accumulated energy units
+func (r *PowerAMDMSR) toMilliJoules(units uint64) uint64 {
+	return uint64(float64(units) * r.energyUnit * 1000 /*mJ*/)
+}
+
+func (r *PowerAMDMSR) sum(counters map[int]*msrCounter, msr int64) (uint64, error) {
+	units := uint64(0)
+	for id, c := range counters {
+		u, err := c.read(msr)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read energy of %d: %v", id, err)
+		}
+		units += u
+	}
+	return r.toMilliJoules(units), nil
+}
+
+// GetEnergyFromDram returns 0 as Zen has no DRAM domain
+func (r *PowerAMDMSR) GetEnergyFromDram() (uint64, error) {
+	return 0, nil
+}
+
+func (r *PowerAMDMSR) GetEnergyFromCore() (uint64, error) {
+	return r.sum(r.cores, MSR_AMD_CORE_ENERGY_STATUS)
+}
+
+// GetEnergyFromUncore returns the package energy not spent in the cores: the I/O die, the L3 caches and the fabric
+func (r *PowerAMDMSR) GetEnergyFromUncore() (uint64, error) {
+	pkg, err := r.GetEnergyFromPackage()
+	if err != nil {
+		return 0, err
+	}
+	core, err := r.GetEnergyFromCore()
+	if err != nil {
+		return 0, err
+	}
+	if core > pkg {
+		return 0, nil
+	}
+	return pkg - core, nil
+}
+
+func (r *PowerAMDMSR) GetEnergyFromPackage() (uint64, error) {
+	return r.sum(r.packages, MSR_AMD_PKG_ENERGY_STATUS)
+}
+
+func (r *PowerAMDMSR) StopPower() {
+	for _, c := range r.cores {
+		syscall.Close(c.fd)
+	}
+	r.cores = nil
+	r.packages = nil
+}
+
+// GetEnergyPerPackage returns mJ per package
+func (r *PowerAMDMSR) GetEnergyPerPackage() (map[int]uint64, error) {
+	energy := map[int]uint64{}
+	for pkg, c := range r.packages {
+		u, err := c.read(MSR_AMD_PKG_ENERGY_STATUS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the energy of package %d: %v", pkg, err)
+		}
+		energy[pkg] = r.toMilliJoules(u)
+	}
+	return energy, nil
+}
+
+// GetEnergyPerCCD returns mJ in the cores of each CCD
+func (r *PowerAMDMSR) GetEnergyPerCCD() (map[CCD]uint64, error) {
+	units := map[CCD]uint64{}
+	for cpu, c := range r.cores {
+		u, err := c.read(MSR_AMD_CORE_ENERGY_STATUS)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the energy of cpu %d: %v", cpu, err)
+		}
+		units[r.ccds[cpu]] += u
+	}
+	energy := map[CCD]uint64{}
+	for ccd, u := range units {
+		energy[ccd] = r.toMilliJoules(u)
+	}
+	return energy, nil
+}
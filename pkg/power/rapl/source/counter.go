@@ -0,0 +1,116 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"io/ioutil"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// The energy_uj of a powercap zone wraps at its max_energy_range_uj, within tens of minutes on a loaded
+// server, and some platforms reset it on resume from suspend. The zones are accumulated to 64 bits at
+// each read, so that the sums over the packages and the deltas of the callers stay monotonic.
+const (
+	// suspendDrift is the wall clock advance over the monotonic clock, which stops in suspend, at which the
+	// reads are taken as across a suspend
+	suspendDrift = 2 * time.Second
+)
+
+// energyCounter accumulates the µJ of a wrapping energy counter
+type energyCounter struct {
+	max   uint64
+	last  uint64
+	total uint64
+	read  time.Time
+	init  bool
+}
+
+var (
+	counterLock sync.Mutex
+	// zoneCounters are the counters per energy_uj path
+	zoneCounters = map[string]*energyCounter{}
+)
+
+// counterDelta returns the increase of a counter wrapping at max. A counter below its last value wrapped,
+// unless max is not known or the counter was re-initialized, then the interval is lost and reset is set.
+// A delta above max can not be told from several wraps, it is clamped to max.
+func counterDelta(curr, last, max uint64, reinitialized bool) (delta uint64, reset bool) {
+	switch {
+	case curr >= last:
+		delta = curr - last
+	case max > last && !reinitialized:
+		// the counter goes from max to 0
+		delta = max - last + curr + 1
+	default:
+		return 0, true
+	}
+	if max > 0 && delta > max {
+		delta = max
+	}
+	return delta, false
+}
+
+// suspended returns whether the host was suspended between two reads, the monotonic clock of Go does not
+// advance in suspend unlike the wall clock
+func suspended(last, now time.Time) bool {
+	return now.Round(0).Sub(last.Round(0))-now.Sub(last) > suspendDrift
+}
+
+// update accumulates a read of the counter, returning the total µJ
+func (c *energyCounter) update(curr uint64, resumed bool) uint64 {
+	if !c.init {
+		c.total, c.init = curr, true
+	} else {
+		delta, reset := counterDelta(curr, c.last, c.max, resumed)
+		if reset {
+			log.Printf("energy counter reset from %v to %v µJ, resumed from suspend: %v\n", c.last, curr, resumed)
+		}
+		c.total += delta
+	}
+	c.last = curr
+	return c.total
+}
+
+// readZoneEnergy returns the accumulated mJ of a zone
+func readZoneEnergy(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path + energyFile)
+	if err != nil {
+		return 0, err
+	}
+	e, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	counterLock.Lock()
+	defer counterLock.Unlock()
+	c, ok := zoneCounters[path]
+	if !ok {
+		c = &energyCounter{}
+		if data, err := ioutil.ReadFile(path + maxEnergyRangeFile); err == nil {
+			c.max, _ = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+		}
+		zoneCounters[path] = c
+	}
+	now := time.Now()
+	resumed := c.init && suspended(c.read, now)
+	c.read = now
+	return c.update(e, resumed) / 1000 /*mJ*/, nil
+}
@@ -0,0 +1,76 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"testing"
+)
+
+const testMaxEnergyRange = 262143328850
+
+func TestCounterDelta(t *testing.T) {
+	tests := []struct {
+		name          string
+		curr, last    uint64
+		max           uint64
+		reinitialized bool
+		delta         uint64
+		reset         bool
+	}{
+		{name: "increase", curr: 1500, last: 1000, max: testMaxEnergyRange, delta: 500},
+		{name: "unchanged", curr: 1000, last: 1000, max: testMaxEnergyRange, delta: 0},
+		{name: "wrap", curr: 200, last: testMaxEnergyRange - 300, max: testMaxEnergyRange, delta: 501},
+		{name: "wrap at zero", curr: 0, last: testMaxEnergyRange - 1, max: testMaxEnergyRange, delta: 2},
+		{name: "decrease without max", curr: 200, last: 1000, reset: true},
+		{name: "last above max", curr: 200, last: testMaxEnergyRange + 1, max: testMaxEnergyRange, reset: true},
+		{name: "reset on resume", curr: 200, last: 1000, max: testMaxEnergyRange, reinitialized: true, reset: true},
+		{name: "increase on resume", curr: 1500, last: 1000, max: testMaxEnergyRange, reinitialized: true, delta: 500},
+		{name: "clamped", curr: testMaxEnergyRange + 1000, last: 0, max: testMaxEnergyRange, delta: testMaxEnergyRange},
+		{name: "not clamped without max", curr: testMaxEnergyRange + 1000, last: 0, delta: testMaxEnergyRange + 1000},
+	}
+	for _, tt := range tests {
+		delta, reset := counterDelta(tt.curr, tt.last, tt.max, tt.reinitialized)
+		if delta != tt.delta || reset != tt.reset {
+			t.Errorf("%s: got delta %v reset %v, want %v %v", tt.name, delta, reset, tt.delta, tt.reset)
+		}
+	}
+}
+
+func TestEnergyCounter(t *testing.T) {
+	c := &energyCounter{max: 1000}
+	reads := []struct {
+		curr    uint64
+		resumed bool
+		total   uint64
+	}{
+		{curr: 900, total: 900},
+		{curr: 950, total: 950},
+		// wrap
+		{curr: 50, total: 1051},
+		{curr: 400, total: 1401},
+		// re-initialized on resume from suspend, the interval is lost
+		{curr: 10, resumed: true, total: 1401},
+		{curr: 60, total: 1451},
+		// counted on after a suspend without reset
+		{curr: 100, resumed: true, total: 1491},
+	}
+	for i, r := range reads {
+		if total := c.update(r.curr, r.resumed); total != r.total {
+			t.Errorf("read %d of %v: got total %v, want %v", i, r.curr, total, r.total)
+		}
+	}
+}
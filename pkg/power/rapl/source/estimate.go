@@ -40,6 +40,9 @@ var (
 	cpuCores         = runtime.NumCPU()
 
 	startTime = time.Now()
+	// the core energy is integrated since the leakage varies with the temperature
+	coreEnergy   float64
+	lastCoreRead time.Time
 
 	perThreadMinPowerEstimate, perThreadMaxPowerEstimate, perGBPowerEstimate float64
 
@@ -48,6 +51,14 @@ var (
 		"(.)*Intel(.)*( [-a-zA-Z0-9]+[0-9]+[A-Z]*)",  // Intel, e.g. "model name      : 12th Gen Intel(R) Core(TM) i7-12700H". This is seen on Hyper-V
 	}
 	dramRegex = "^MemTotal:[\\s]+([0-9]+)"
+	// estimateArchitectures names the Zen generations in the power data, the power data has no
+	// EPYC 4th Gen yet, Zen 4 is estimated as Zen 3
+	estimateArchitectures = map[string]string{
+		"Zen":   "EPYC 1st Gen",
+		"Zen 2": "EPYC 2nd Gen",
+		"Zen 3": "EPYC 3rd Gen",
+		"Zen 4": "EPYC 3rd Gen",
+	}
 )
 
 type PowerEstimateData struct {
@@ -79,6 +90,9 @@ func getCPUModel() (string, error) {
 }
 
 func GetCPUArchitecture() (string, error) {
+	if id, err := getCPUIdentity(); err == nil && id.vendor == amdVendor {
+		return getAMDArchitecture(id.family, id.model)
+	}
 	myCPUModel, err := getCPUModel()
 	if err != nil {
 		return "", err
@@ -125,6 +139,9 @@ func getDram() (int, error) {
 }
 
 func getCPUPowerEstimate(cpu string) (float64, float64, float64, error) {
+	if arch, ok := estimateArchitectures[cpu]; ok {
+		cpu = arch
+	}
 	file, _ := os.Open(powerDataPath)
 	reader := csv.NewReader(file)
 
@@ -164,6 +181,8 @@ func (r *PowerEstimate) IsSupported() bool {
 
 func (r *PowerEstimate) StopPower() {
 	startTime = time.Now()
+	coreEnergy = 0
+	lastCoreRead = time.Time{}
 }
 
 func (r *PowerEstimate) GetEnergyFromDram() (uint64, error) {
@@ -175,10 +194,17 @@ func (r *PowerEstimate) GetEnergyFromDram() (uint64, error) {
 
 func (r *PowerEstimate) GetEnergyFromCore() (uint64, error) {
 	now := time.Now()
-	diff := now.Sub(startTime)
-	seconds := diff.Seconds()
+	if lastCoreRead.IsZero() {
+		lastCoreRead = startTime
+	}
+	seconds := now.Sub(lastCoreRead).Seconds()
+	lastCoreRead = now
+	// the min power is mostly leakage, derated with the temperature
+	staticPower := perThreadMinPowerEstimate * GetLeakageFactor()
 	//TODO use utilization
-	return uint64(float64(cpuCores)*seconds*(perThreadMinPowerEstimate+perThreadMaxPowerEstimate)/2) * 1000, nil
+	dynamicPower := (perThreadMaxPowerEstimate - perThreadMinPowerEstimate) / 2
+	coreEnergy += float64(cpuCores) * seconds * (staticPower + dynamicPower) * 1000
+	return uint64(coreEnergy), nil
 }
 
 func (r *PowerEstimate) GetEnergyFromUncore() (uint64, error) {
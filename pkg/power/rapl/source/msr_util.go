@@ -171,7 +171,7 @@ func ReadPkgPower(packageId int) (uint64, error) {
 	if err != nil {
 		return 0, fmt.Errorf("failed to read pkg energy: %v", err)
 	}
-	return uint64(cpuEnergyUnits[packageId] * float64(result)), nil
+	return uint64(cpuEnergyUnits[packageId] * float64(result) * 1000 /*mJ*/), nil
 }
 
 func ReadCorePower(packageId int) (uint64, error) {
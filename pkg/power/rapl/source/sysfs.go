@@ -25,30 +25,30 @@ import (
 )
 
 const (
-	// sysfs path templates
-	numPkgPathTemplate      = "/sys/devices/system/cpu/cpu%d/topology/physical_package_id"
-	packageNamePathTemplate = "/sys/class/powercap/intel-rapl/intel-rapl:%d/"
-	eventNamePathTemplate   = "/sys/class/powercap/intel-rapl/intel-rapl:%d/intel-rapl:%d:%d/"
-	cpuInfoPath             = "/proc/cpuinfo"
-	energyFile              = "energy_uj"
-
-	// RAPL number of events (core, dram and uncore)
-	numRAPLEvents = 3
+	energyFile = "energy_uj"
 
 	// RAPL events
 	dramEvent    = "dram"
 	coreEvent    = "core"
 	uncoreEvent  = "uncore"
 	packageEvent = "package"
+	// psys is the platform domain of the client platforms since Skylake: the package, the DRAM and
+	// the rest of the platform (PCH, eDRAM, VR losses)
+	psysEvent = "psys"
+
+	zonePathGlob       = "/sys/class/powercap/intel-rapl/intel-rapl:*"
+	maxEnergyRangeFile = "max_energy_range_uj"
 )
 
 var (
 	eventPaths map[string]map[string]string
+	psysPath   string
 )
 
 func init() {
 	eventPaths = map[string]map[string]string{}
 	detectEventPaths()
+	psysPath = detectPsysPath()
 }
 
 // getEnergy returns the sum of the energy consumption of all sockets for a given event
@@ -89,19 +89,11 @@ func readEventEnergy(eventName string) map[string]uint64 {
 	for pkId, subTree := range eventPaths {
 		for event, path := range subTree {
 			if strings.Index(event, eventName) == 0 {
-				var e uint64
-				var err error
-				var data []byte
-
-				if data, err = ioutil.ReadFile(path + energyFile); err != nil {
+				e, err := readZoneEnergy(path)
+				if err != nil {
 					log.Println(err)
 					continue
 				}
-				if e, err = strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64); err != nil {
-					log.Println(err)
-					continue
-				}
-				e /= 1000 /*mJ*/
 				energy[pkId] = e
 			}
 		}
@@ -111,10 +103,19 @@ func readEventEnergy(eventName string) map[string]uint64 {
 
 type PowerSysfs struct{}
 
+// IsSupported returns whether a package zone was discovered and its energy can be read,
+// energy_uj is only readable by root since CVE-2020-8694
 func (r *PowerSysfs) IsSupported() bool {
-	path := fmt.Sprintf(packageNamePathTemplate, 0)
-	_, err := ioutil.ReadFile(path + energyFile)
-	return err == nil
+	for _, subTree := range eventPaths {
+		for event, path := range subTree {
+			if strings.HasPrefix(event, packageEvent) {
+				if _, err := ioutil.ReadFile(path + energyFile); err == nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
 }
 
 func (r *PowerSysfs) GetEnergyFromDram() (uint64, error) {
@@ -135,3 +136,69 @@ func (r *PowerSysfs) GetEnergyFromPackage() (uint64, error) {
 
 func (r *PowerSysfs) StopPower() {
 }
+
+// GetEnergyPerPackage returns mJ per package zone
+func (r *PowerSysfs) GetEnergyPerPackage() (map[int]uint64, error) {
+	energy := map[int]uint64{}
+	for name, e := range readEventEnergy(packageEvent) {
+		pkg, err := strconv.Atoi(strings.TrimPrefix(name, packageEvent+"-"))
+		if err != nil {
+			continue
+		}
+		energy[pkg] = e
+	}
+	return energy, nil
+}
+
+// GetCoreEnergyPerPackage returns mJ in the cores of each package zone, the package minus the DRAM
+// where the core subzone is missing
+func (r *PowerSysfs) GetCoreEnergyPerPackage() (map[int]uint64, error) {
+	core := map[string]uint64{}
+	if hasEvent(coreEvent) {
+		core = readEventEnergy(coreEvent)
+	} else {
+		dram := readEventEnergy(dramEvent)
+		for name, e := range readEventEnergy(packageEvent) {
+			core[name] = e - dram[name]
+		}
+	}
+	energy := map[int]uint64{}
+	for name, e := range core {
+		pkg, err := strconv.Atoi(strings.TrimPrefix(name, packageEvent+"-"))
+		if err != nil {
+			continue
+		}
+		energy[pkg] = e
+	}
+	return energy, nil
+}
+
+// IsPsysSupported returns whether the platform (psys) RAPL domain exists
+func IsPsysSupported() bool {
+	return len(psysPath) > 0
+}
+
+// GetEnergyFromPsys returns mJ in the platform domain
+func GetEnergyFromPsys() (uint64, error) {
+	if len(psysPath) == 0 {
+		return 0, fmt.Errorf("no psys RAPL domain")
+	}
+	return readZoneEnergy(psysPath)
+}
+
+// HasDramDomain returns whether the DRAM energy is measured, rather than derived from the package and core
+func HasDramDomain() bool {
+	return hasEvent(dramEvent)
+}
+
+func readEnergyFile(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	e, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return e / 1000 /*mJ*/, nil
+}
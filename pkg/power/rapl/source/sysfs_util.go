@@ -17,62 +17,54 @@ limitations under the License.
 package source
 
 import (
-	"fmt"
 	"io/ioutil"
-	"strconv"
+	"path/filepath"
 	"strings"
 )
 
-func getNumCPUs() int {
-	data, err := ioutil.ReadFile(cpuInfoPath)
+// detectEventPaths discovers the package zones of the intel_rapl driver and their core, uncore and dram subzones
+// by name, as the zone indices are not contiguous when a package is offline or the psys zone is listed first
+func detectEventPaths() {
+	zones, err := filepath.Glob(zonePathGlob)
 	if err != nil {
-		fmt.Println(err)
+		return
 	}
-	return strings.Count(string(data), "processor")
-}
-
-func getNumPackage() int {
-	var numPackage int
-	pkgMap := map[int]bool{}
-	numCPUs := getNumCPUs()
-	for i := 0; i < numCPUs; i++ {
-		path := fmt.Sprintf(numPkgPathTemplate, i)
-		data, err := ioutil.ReadFile(path)
-		if err != nil {
-			break
+	for _, zone := range zones {
+		packageName := readZoneName(zone)
+		if !strings.HasPrefix(packageName, packageEvent) {
+			continue
 		}
-
-		if id, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
-			if _, exist := pkgMap[id]; !exist {
-				numPackage++
-				pkgMap[id] = true
+		eventPaths[packageName] = map[string]string{packageName: zone + "/"}
+		subzones, _ := filepath.Glob(zone + "/" + filepath.Base(zone) + ":*")
+		for _, subzone := range subzones {
+			if eventName := readZoneName(subzone); len(eventName) > 0 {
+				eventPaths[packageName][eventName] = subzone + "/"
 			}
 		}
 	}
-	return numPackage
 }
 
-func detectEventPaths() {
-	numPackage := getNumPackage()
-	for i := 0; i < numPackage; i++ {
-		packagePath := fmt.Sprintf(packageNamePathTemplate, i)
-		data, err := ioutil.ReadFile(packagePath + "name")
-		packageName := strings.TrimSpace(string(data))
-		if err != nil {
-			continue
-		}
-		eventPaths[string(packageName)] = map[string]string{}
-		eventPaths[string(packageName)][string(packageName)] = packagePath
-		for j := 0; j < numRAPLEvents; j++ {
-			eventNamePath := fmt.Sprintf(eventNamePathTemplate, i, i, j)
-			data, err := ioutil.ReadFile(eventNamePath + "name")
-			eventName := strings.TrimSpace(string(data))
-			if err != nil {
-				continue
-			}
-			eventPaths[string(packageName)][string(eventName)] = eventNamePath
+// readZoneName returns the name of a powercap zone, empty if it can not be read
+func readZoneName(zone string) string {
+	data, err := ioutil.ReadFile(filepath.Join(zone, "name"))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+// detectPsysPath returns the path of the top level zone named psys, it is not a package zone
+func detectPsysPath() string {
+	zones, err := filepath.Glob(zonePathGlob)
+	if err != nil {
+		return ""
+	}
+	for _, zone := range zones {
+		if readZoneName(zone) == psysEvent {
+			return zone + "/"
 		}
 	}
+	return ""
 }
 
 func hasEvent(event string) bool {
@@ -0,0 +1,98 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// The static (leakage) power of a chip grows exponentially with its temperature, roughly doubling every
+// 20-30°C. The power estimates are measured on a bench at a reference temperature, while passively cooled
+// edge devices run much hotter in the field, so the static part of the estimate is derated with the SoC
+// temperature: static(T) = static(Tref) * 2^((T - Tref) / doubling)
+const (
+	DefaultReferenceTemperature = 50.0
+	DefaultLeakageDoubling      = 25.0
+
+	thermalZoneGlob = "/sys/class/thermal/thermal_zone*"
+)
+
+var (
+	referenceTemperature = DefaultReferenceTemperature
+	leakageDoubling      = DefaultLeakageDoubling
+	// the thermal zones measuring the CPU or the whole SoC
+	socZoneKeywords = []string{"cpu", "soc", "x86_pkg_temp", "tcpu", "package"}
+	derateLock      sync.Mutex
+)
+
+// SetTemperatureDerating sets the bench temperature of the estimates and the temperature increase doubling the leakage,
+// in °C. A doubling of 0 disables the derating.
+func SetTemperatureDerating(reference, doubling float64) {
+	derateLock.Lock()
+	defer derateLock.Unlock()
+	referenceTemperature = reference
+	leakageDoubling = doubling
+}
+
+// GetLeakageFactor returns the factor to apply to the static power at the current SoC temperature
+func GetLeakageFactor() float64 {
+	derateLock.Lock()
+	reference, doubling := referenceTemperature, leakageDoubling
+	derateLock.Unlock()
+	if doubling <= 0 {
+		return 1
+	}
+	temperature, ok := GetSoCTemperature()
+	if !ok {
+		return 1
+	}
+	return math.Pow(2, (temperature-reference)/doubling)
+}
+
+// GetSoCTemperature returns the hottest CPU/SoC thermal zone in °C, or the hottest zone if none is named after the CPU
+func GetSoCTemperature() (float64, bool) {
+	zones, _ := filepath.Glob(thermalZoneGlob)
+	socMax, anyMax := math.Inf(-1), math.Inf(-1)
+	for _, zone := range zones {
+		data, err := ioutil.ReadFile(filepath.Join(zone, "temp"))
+		if err != nil {
+			continue
+		}
+		milliCelsius, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		if err != nil {
+			continue
+		}
+		temperature := float64(milliCelsius) / 1000
+		anyMax = math.Max(anyMax, temperature)
+		typ, _ := ioutil.ReadFile(filepath.Join(zone, "type"))
+		for _, k := range socZoneKeywords {
+			if strings.Contains(strings.ToLower(string(typ)), k) {
+				socMax = math.Max(socMax, temperature)
+				break
+			}
+		}
+	}
+	if !math.IsInf(socMax, -1) {
+		return socMax, true
+	}
+	return anyMax, !math.IsInf(anyMax, -1)
+}
@@ -0,0 +1,52 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package source
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const cpuPackageGlob = "/sys/devices/system/cpu/cpu[0-9]*/topology/physical_package_id"
+
+// GetCPUPackages returns the package of each online CPU
+func GetCPUPackages() (map[int]int, error) {
+	paths, err := filepath.Glob(cpuPackageGlob)
+	if err != nil {
+		return nil, err
+	}
+	packages := map[int]int{}
+	for _, path := range paths {
+		cpuDir := filepath.Base(filepath.Dir(filepath.Dir(path)))
+		cpu, err := strconv.Atoi(strings.TrimPrefix(cpuDir, "cpu"))
+		if err != nil {
+			continue
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		pkg, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			continue
+		}
+		packages[cpu] = pkg
+	}
+	return packages, nil
+}
@@ -0,0 +1,260 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package soc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ARM SoCs describe their power topology in the device tree: the regulators (power rails) are nodes
+// with a regulator-name, the devices reference the rails supplying them with <name>-supply phandle
+// properties, and the thermal zones are listed under /thermal-zones. Following the supply references
+// gives the subsystem (CPU cluster, GPU, NPU) of each rail.
+const (
+	deviceTreePath = "/proc/device-tree"
+
+	SubsystemCPU   = "cpu"
+	SubsystemGPU   = "gpu"
+	SubsystemNPU   = "npu"
+	SubsystemDRAM  = "dram"
+	SubsystemOther = "other"
+)
+
+var (
+	// substrings of the compatible strings and node names identifying the subsystem of a consumer
+	gpuKeywords  = []string{"mali", "gpu", "gv11b", "ga10b", "adreno", "powervr"}
+	npuKeywords  = []string{"npu", "nvdla", "dla", "rknn", "vip", "ethos"}
+	dramKeywords = []string{"dmc", "ddr", "dram", "emc"}
+)
+
+// Rail is a regulator of the SoC
+type Rail struct {
+	Name string
+	// Node is the device tree path of the regulator
+	Node      string
+	Subsystem string
+	// Consumers are the device tree paths of the nodes supplied by the rail
+	Consumers []string
+	// SuppliesRails is set for the rails feeding other regulators, their power is already counted downstream
+	SuppliesRails bool
+}
+
+type ThermalZone struct {
+	Name      string
+	Subsystem string
+}
+
+type Topology struct {
+	Rails        map[string]*Rail
+	ThermalZones []ThermalZone
+}
+
+type node struct {
+	path  string
+	props map[string][]byte
+}
+
+// ParseDeviceTree reads the power topology from the device tree, it returns an error on non device tree platforms
+func ParseDeviceTree() (*Topology, error) {
+	return parseDeviceTree(deviceTreePath)
+}
+
+func parseDeviceTree(root string) (*Topology, error) {
+	// /proc/device-tree is a link to /sys/firmware/devicetree/base, the walk does not follow a linked root
+	root, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, fmt.Errorf("no device tree: %v", err)
+	}
+	nodes := []*node{}
+	err = filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.IsDir() {
+			return err
+		}
+		n := &node{path: "/" + strings.TrimPrefix(strings.TrimPrefix(path, root), "/"), props: map[string][]byte{}}
+		files, err := ioutil.ReadDir(path)
+		if err != nil {
+			return err
+		}
+		for _, f := range files {
+			if f.IsDir() {
+				continue
+			}
+			if data, err := ioutil.ReadFile(filepath.Join(path, f.Name())); err == nil {
+				n.props[f.Name()] = data
+			}
+		}
+		nodes = append(nodes, n)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the device tree: %v", err)
+	}
+
+	topology := &Topology{Rails: map[string]*Rail{}}
+	byPhandle := map[uint32]*Rail{}
+	for _, n := range nodes {
+		name, ok := n.props["regulator-name"]
+		if !ok {
+			continue
+		}
+		rail := &Rail{Name: cString(name), Node: n.path, Subsystem: SubsystemOther}
+		topology.Rails[rail.Name] = rail
+		if phandle, ok := n.props["phandle"]; ok && len(phandle) == 4 {
+			byPhandle[binary.BigEndian.Uint32(phandle)] = rail
+		}
+	}
+	for _, n := range nodes {
+		for prop, value := range n.props {
+			if !strings.HasSuffix(prop, "-supply") || len(value) != 4 {
+				continue
+			}
+			rail, ok := byPhandle[binary.BigEndian.Uint32(value)]
+			if !ok {
+				continue
+			}
+			rail.Consumers = append(rail.Consumers, n.path)
+			if _, ok := n.props["regulator-name"]; ok {
+				rail.SuppliesRails = true
+				continue
+			}
+			// a rail supplying several subsystems is kept in the first one found, the CPU wins
+			if s := consumerSubsystem(n, prop); rail.Subsystem == SubsystemOther || s == SubsystemCPU {
+				rail.Subsystem = s
+			}
+		}
+	}
+	topology.ThermalZones = parseThermalZones(nodes)
+	return topology, nil
+}
+
+func consumerSubsystem(n *node, prop string) string {
+	if strings.HasPrefix(n.path, "/cpus/") {
+		return SubsystemCPU
+	}
+	id := strings.ToLower(filepath.Base(n.path) + " " + prop + " " + strings.Join(cStrings(n.props["compatible"]), " "))
+	switch {
+	case containsAny(id, npuKeywords):
+		return SubsystemNPU
+	case containsAny(id, gpuKeywords):
+		return SubsystemGPU
+	case containsAny(id, dramKeywords):
+		return SubsystemDRAM
+	}
+	return SubsystemOther
+}
+
+func parseThermalZones(nodes []*node) []ThermalZone {
+	zones := []ThermalZone{}
+	for _, n := range nodes {
+		if filepath.Dir(n.path) != "/thermal-zones" {
+			continue
+		}
+		name := filepath.Base(n.path)
+		subsystem := SubsystemOther
+		switch lower := strings.ToLower(name); {
+		case strings.Contains(lower, "cpu") || strings.Contains(lower, "soc"):
+			subsystem = SubsystemCPU
+		case containsAny(lower, npuKeywords):
+			subsystem = SubsystemNPU
+		case containsAny(lower, gpuKeywords):
+			subsystem = SubsystemGPU
+		case containsAny(lower, dramKeywords):
+			subsystem = SubsystemDRAM
+		}
+		zones = append(zones, ThermalZone{Name: name, Subsystem: subsystem})
+	}
+	sort.Slice(zones, func(i, j int) bool { return zones[i].Name < zones[j].Name })
+	return zones
+}
+
+// CPUClusters names the CPU rails cpu_cluster0, cpu_cluster1... in the order of their first CPU,
+// since big.LITTLE SoCs have one rail per cluster
+func (t *Topology) CPUClusters() map[string]string {
+	rails := []*Rail{}
+	for _, r := range t.Rails {
+		if r.Subsystem == SubsystemCPU {
+			sort.Strings(r.Consumers)
+			rails = append(rails, r)
+		}
+	}
+	sort.Slice(rails, func(i, j int) bool { return rails[i].Consumers[0] < rails[j].Consumers[0] })
+	clusters := map[string]string{}
+	for i, r := range rails {
+		clusters[r.Name] = fmt.Sprintf("cpu_cluster%d", i)
+	}
+	return clusters
+}
+
+// Subsystem returns the subsystem of a rail, the CPU rails are split per cluster
+func (t *Topology) Subsystem(rail string) string {
+	if cluster, ok := t.CPUClusters()[rail]; ok {
+		return cluster
+	}
+	if r, ok := t.Rails[rail]; ok {
+		return r.Subsystem
+	}
+	return SubsystemOther
+}
+
+// IsLeaf returns false for the rails feeding other regulators, so that they are not counted twice
+func (t *Topology) IsLeaf(rail string) bool {
+	r, ok := t.Rails[rail]
+	return !ok || !r.SuppliesRails
+}
+
+// Breakdown groups per-rail values (e.g. power) of the leaf rails by subsystem, splitting the CPU per cluster
+func (t *Topology) Breakdown(rails map[string]float64) map[string]float64 {
+	clusters := t.CPUClusters()
+	breakdown := map[string]float64{}
+	for name, v := range rails {
+		if !t.IsLeaf(name) {
+			continue
+		}
+		subsystem := SubsystemOther
+		if r, ok := t.Rails[name]; ok {
+			subsystem = r.Subsystem
+		}
+		if cluster, ok := clusters[name]; ok {
+			subsystem = cluster
+		}
+		breakdown[subsystem] += v
+	}
+	return breakdown
+}
+
+func containsAny(s string, keywords []string) bool {
+	for _, k := range keywords {
+		if strings.Contains(s, k) {
+			return true
+		}
+	}
+	return false
+}
+
+func cString(b []byte) string {
+	return strings.TrimRight(string(b), "\x00")
+}
+
+func cStrings(b []byte) []string {
+	return strings.Split(cString(b), "\x00")
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package soc
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeNode(t *testing.T, dir string, props map[string]string) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for name, value := range props {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(value), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestParseDeviceTreeLinkedRoot(t *testing.T) {
+	dir := t.TempDir()
+	base := filepath.Join(dir, "firmware", "devicetree", "base")
+	writeNode(t, filepath.Join(base, "regulators", "vdd-gpu"), map[string]string{
+		"regulator-name": "vdd_gpu\x00",
+		"phandle":        "\x00\x00\x00\x2a",
+	})
+	writeNode(t, filepath.Join(base, "gpu@fb000000"), map[string]string{
+		"compatible":  "rockchip,rk3588-mali\x00arm,mali-valhall-csf\x00",
+		"mali-supply": "\x00\x00\x00\x2a",
+	})
+	writeNode(t, filepath.Join(base, "thermal-zones", "gpu-thermal"), nil)
+	// /proc/device-tree links to /sys/firmware/devicetree/base
+	root := filepath.Join(dir, "device-tree")
+	if err := os.Symlink(base, root); err != nil {
+		t.Fatal(err)
+	}
+
+	topology, err := parseDeviceTree(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rail, ok := topology.Rails["vdd_gpu"]
+	if !ok {
+		t.Fatalf("no vdd_gpu rail in %+v", topology.Rails)
+	}
+	if rail.Node != "/regulators/vdd-gpu" || rail.Subsystem != SubsystemGPU {
+		t.Errorf("got rail %+v, want node /regulators/vdd-gpu of the gpu", rail)
+	}
+	if len(rail.Consumers) != 1 || rail.Consumers[0] != "/gpu@fb000000" {
+		t.Errorf("got consumers %v, want /gpu@fb000000", rail.Consumers)
+	}
+	if len(topology.ThermalZones) != 1 || topology.ThermalZones[0] != (ThermalZone{Name: "gpu-thermal", Subsystem: SubsystemGPU}) {
+		t.Errorf("got thermal zones %+v", topology.ThermalZones)
+	}
+}
@@ -0,0 +1,106 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package soc
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SoCs without energy counters or power monitors often expose the voltage and the current of their rails
+// through the Linux regulator framework (drivers reading the PMIC registers through regmap). For most
+// regulator drivers microamps is the configured current limit, not a measured current, so the power of a
+// rail, microvolts * microamps, is an upper bound: the rails are an estimate ranked below the real meters.
+// The energy is integrated between two reads.
+const (
+	regulatorClassPath = "/sys/class/regulator"
+)
+
+type RegulatorMeter struct {
+	lastRead  time.Time
+	lastPower map[string]float64
+}
+
+func NewRegulatorMeter() *RegulatorMeter {
+	return &RegulatorMeter{lastPower: map[string]float64{}}
+}
+
+// IsRegulatorPowerSupported returns whether at least one regulator exposes its voltage and current
+func IsRegulatorPowerSupported() bool {
+	power, err := ReadRegulatorPower()
+	return err == nil && len(power) > 0
+}
+
+// ReadRegulatorPower returns the power in mW of the regulators exposing their voltage and current, by name.
+// The current is usually the limit of the regulator, so the power overestimates the rail.
+func ReadRegulatorPower() (map[string]float64, error) {
+	regulators, err := ioutil.ReadDir(regulatorClassPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", regulatorClassPath, err)
+	}
+	power := map[string]float64{}
+	for _, r := range regulators {
+		dir := filepath.Join(regulatorClassPath, r.Name())
+		microVolts, err := readUint(filepath.Join(dir, "microvolts"))
+		if err != nil {
+			continue
+		}
+		microAmps, err := readUint(filepath.Join(dir, "microamps"))
+		if err != nil {
+			continue
+		}
+		name, err := ioutil.ReadFile(filepath.Join(dir, "name"))
+		if err != nil {
+			continue
+		}
+		/* µV * µA = pW, to mW */
+		power[strings.TrimSpace(string(name))] += float64(microVolts) * float64(microAmps) / 1e9
+	}
+	return power, nil
+}
+
+// GetEnergy returns the energy in mJ of each rail since the last call
+func (m *RegulatorMeter) GetEnergy() (map[string]float64, error) {
+	power, err := ReadRegulatorPower()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	energy := map[string]float64{}
+	if !m.lastRead.IsZero() {
+		seconds := now.Sub(m.lastRead).Seconds()
+		for name, p := range power {
+			/* energy (mJ) = average power (mW) * time(second) */
+			energy[name] = (m.lastPower[name] + p) / 2 * seconds
+		}
+	}
+	m.lastRead = now
+	m.lastPower = power
+	return energy, nil
+}
+
+func readUint(path string) (uint64, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,378 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package soc
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Boards without RAPL often carry current/power monitors on their rails: the INA219/INA226/INA260 and the
+// 3-channel INA3221 (Jetson modules) bound to the hwmon or the IIO (ina2xx-adc) drivers, or the PMIC ADCs of
+// the Raspberry Pi 5 read with vcgencmd. A sensor is mapped to the subsystem of its rail from its label,
+// e.g. VDD_CPU_GPU_CV is counted as CPU, DDR_VDDQ as DRAM and VDD_IN as the board input.
+const (
+	// SubsystemTotal is the board input, measuring the whole node
+	SubsystemTotal = "total"
+
+	hwmonClassPath = "/sys/class/hwmon"
+	iioDevicesPath = "/sys/bus/iio/devices"
+	vcgencmd       = "vcgencmd"
+)
+
+var (
+	// the label tokens of the rails, checked in this order
+	totalTokens = []string{"in", "input", "total", "ext5v", "vin"}
+	cpuTokens   = []string{"cpu", "core", "cpus", "big", "little"}
+	dramTokens  = []string{"ddr", "dram", "vddq", "mem", "lpddr", "emc"}
+	gpuTokens   = []string{"gpu"}
+	npuTokens   = []string{"npu", "cv", "dla"}
+
+	// e.g. "   VDD_CORE_A current(7)=1.87404000A" and "   VDD_CORE_V volt(15)=0.72000000V"
+	rePMICReading = regexp.MustCompile(`^\s*(\S+)_([AV])\s+(?:current|volt)\(\d+\)=([0-9.]+)[AV]`)
+
+	sensorSubsystems = map[string]string{}
+	sensorLock       sync.Mutex
+)
+
+// Sensor is a power monitor of a rail
+type Sensor struct {
+	Name      string
+	Subsystem string
+	// read returns the power in mW
+	read func() (float64, error)
+}
+
+// SensorMeter integrates the power of the sensors between two reads
+type SensorMeter struct {
+	sensors   []Sensor
+	lastRead  time.Time
+	lastPower map[string]float64
+}
+
+// SetSensorSubsystems overrides the subsystem of the sensors, from a comma separated list of sensor=subsystem,
+// the subsystem being one of cpu, dram, gpu, npu, other and total
+func SetSensorSubsystems(spec string) error {
+	subsystems := map[string]string{}
+	for _, s := range strings.Split(spec, ",") {
+		s = strings.TrimSpace(s)
+		if len(s) == 0 {
+			continue
+		}
+		fields := strings.SplitN(s, "=", 2)
+		if len(fields) != 2 {
+			return fmt.Errorf("invalid sensor subsystem %q, expected sensor=subsystem", s)
+		}
+		switch fields[1] {
+		case SubsystemCPU, SubsystemDRAM, SubsystemGPU, SubsystemNPU, SubsystemOther, SubsystemTotal:
+			subsystems[fields[0]] = fields[1]
+		default:
+			return fmt.Errorf("unknown subsystem %q of sensor %s", fields[1], fields[0])
+		}
+	}
+	sensorLock.Lock()
+	defer sensorLock.Unlock()
+	sensorSubsystems = subsystems
+	return nil
+}
+
+// SensorSubsystem returns the subsystem of the rail measured by a sensor
+func SensorSubsystem(name string) string {
+	sensorLock.Lock()
+	s, ok := sensorSubsystems[name]
+	sensorLock.Unlock()
+	if ok {
+		return s
+	}
+	tokens := strings.FieldsFunc(strings.ToLower(name), func(r rune) bool {
+		return r == '_' || r == '-' || r == ' ' || r == '.'
+	})
+	hasAny := func(keywords []string) bool {
+		for _, t := range tokens {
+			for _, k := range keywords {
+				if t == k {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	switch {
+	case hasAny(totalTokens):
+		return SubsystemTotal
+	case hasAny(cpuTokens):
+		return SubsystemCPU
+	case hasAny(dramTokens):
+		return SubsystemDRAM
+	case hasAny(gpuTokens):
+		return SubsystemGPU
+	case hasAny(npuTokens):
+		return SubsystemNPU
+	}
+	return SubsystemOther
+}
+
+// DiscoverSensors returns the power sensors of the board
+func DiscoverSensors() []Sensor {
+	sensors := discoverHwmonSensors()
+	sensors = append(sensors, discoverIIOSensors()...)
+	sensors = append(sensors, discoverPMICSensors()...)
+	return sensors
+}
+
+// Power returns the power of the rail in mW
+func (s Sensor) Power() (float64, error) {
+	return s.read()
+}
+
+// NewSensorMeter returns a meter of the power sensors of the board, nil if there is none
+func NewSensorMeter() *SensorMeter {
+	sensors := DiscoverSensors()
+	if len(sensors) == 0 {
+		return nil
+	}
+	return &SensorMeter{sensors: sensors, lastPower: map[string]float64{}}
+}
+
+// Sensors returns the names of the sensors read by the meter
+func (m *SensorMeter) Sensors() []string {
+	names := []string{}
+	for _, s := range m.sensors {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+// GetEnergy returns the energy in mJ measured by each sensor since the last call
+func (m *SensorMeter) GetEnergy() map[string]float64 {
+	now := time.Now()
+	power := map[string]float64{}
+	for _, s := range m.sensors {
+		p, err := s.read()
+		if err != nil {
+			continue
+		}
+		power[s.Name] += p
+	}
+	energy := map[string]float64{}
+	if !m.lastRead.IsZero() {
+		seconds := now.Sub(m.lastRead).Seconds()
+		for name, p := range power {
+			/* energy (mJ) = average power (mW) * time(second) */
+			energy[name] = (m.lastPower[name] + p) / 2 * seconds
+		}
+	}
+	m.lastRead = now
+	m.lastPower = power
+	return energy
+}
+
+// discoverHwmonSensors lists the channels of the INA hwmon devices: the ina2xx driver reports the power
+// (power1_input, µW), the ina3221 driver the bus voltage (inN_input, mV) and the current (currN_input, mA)
+func discoverHwmonSensors() []Sensor {
+	sensors := []Sensor{}
+	devices, _ := filepath.Glob(filepath.Join(hwmonClassPath, "hwmon*"))
+	for _, dir := range devices {
+		chip := readString(filepath.Join(dir, "name"))
+		if !strings.HasPrefix(chip, "ina") {
+			continue
+		}
+		powers, _ := filepath.Glob(filepath.Join(dir, "power*_input"))
+		for _, path := range powers {
+			channel := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "power"), "_input")
+			name := readString(filepath.Join(dir, "power"+channel+"_label"))
+			if len(name) == 0 {
+				name = fmt.Sprintf("%s_%s_%s", chip, filepath.Base(dir), channel)
+			}
+			path := path
+			sensors = append(sensors, Sensor{Name: name, read: func() (float64, error) {
+				microWatts, err := readUint(path)
+				return float64(microWatts) / 1000, err
+			}})
+		}
+		if len(powers) > 0 {
+			continue
+		}
+		currents, _ := filepath.Glob(filepath.Join(dir, "curr*_input"))
+		for _, currPath := range currents {
+			channel := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(currPath), "curr"), "_input")
+			voltPath := filepath.Join(dir, "in"+channel+"_input")
+			if _, err := readUint(voltPath); err != nil {
+				// disabled channel
+				continue
+			}
+			name := readString(filepath.Join(dir, "in"+channel+"_label"))
+			if len(name) == 0 {
+				name = readString(filepath.Join(dir, "curr"+channel+"_label"))
+			}
+			if len(name) == 0 {
+				name = fmt.Sprintf("%s_%s_%s", chip, filepath.Base(dir), channel)
+			}
+			currPath := currPath
+			sensors = append(sensors, Sensor{Name: name, read: func() (float64, error) {
+				milliVolts, err := readUint(voltPath)
+				if err != nil {
+					return 0, err
+				}
+				milliAmps, err := readUint(currPath)
+				/* mV * mA = µW, to mW */
+				return float64(milliVolts) * float64(milliAmps) / 1000, err
+			}})
+		}
+	}
+	return sensors
+}
+
+// discoverIIOSensors lists the ina2xx-adc IIO devices, their power channel is in mW once scaled, and the
+// ina3221x devices of the L4T kernels (Jetson Nano, TX2 and Xavier) naming their channels in rail_name_N
+func discoverIIOSensors() []Sensor {
+	sensors := []Sensor{}
+	devices, _ := filepath.Glob(filepath.Join(iioDevicesPath, "iio:device*"))
+	for _, dir := range devices {
+		chip := readString(filepath.Join(dir, "name"))
+		if !strings.HasPrefix(chip, "ina") {
+			continue
+		}
+		raws, _ := filepath.Glob(filepath.Join(dir, "in_power*_raw"))
+		for _, rawPath := range raws {
+			scalePath := strings.TrimSuffix(rawPath, "_raw") + "_scale"
+			name := readString(filepath.Join(dir, "label"))
+			if len(name) == 0 {
+				name = fmt.Sprintf("%s_%s", chip, strings.TrimPrefix(filepath.Base(dir), "iio:"))
+			}
+			rawPath := rawPath
+			sensors = append(sensors, Sensor{Name: name, read: func() (float64, error) {
+				raw, err := readFloat(rawPath)
+				if err != nil {
+					return 0, err
+				}
+				scale, err := readFloat(scalePath)
+				return raw * scale, err
+			}})
+		}
+		inputs, _ := filepath.Glob(filepath.Join(dir, "in_power*_input"))
+		for _, path := range inputs {
+			channel := strings.TrimSuffix(strings.TrimPrefix(filepath.Base(path), "in_power"), "_input")
+			name := readString(filepath.Join(dir, "rail_name_"+channel))
+			if len(name) == 0 {
+				name = fmt.Sprintf("%s_%s_%s", chip, strings.TrimPrefix(filepath.Base(dir), "iio:"), channel)
+			}
+			path := path
+			sensors = append(sensors, Sensor{Name: name, read: func() (float64, error) {
+				milliWatts, err := readUint(path)
+				return float64(milliWatts), err
+			}})
+		}
+	}
+	return sensors
+}
+
+// pmicReading is the current and the voltage of a PMIC rail
+type pmicReading struct {
+	amps, volts       float64
+	hasAmps, hasVolts bool
+}
+
+// discoverPMICSensors lists the rails of the Raspberry Pi 5 PMIC having both a current and a voltage ADC,
+// the ADCs of all the rails are read with one vcgencmd call per sample
+func discoverPMICSensors() []Sensor {
+	if _, err := exec.LookPath(vcgencmd); err != nil {
+		return nil
+	}
+	readings, err := readPMIC()
+	if err != nil {
+		return nil
+	}
+	var cache map[string]pmicReading
+	var cacheTime time.Time
+	sensors := []Sensor{}
+	for rail, r := range readings {
+		if !r.hasAmps || !r.hasVolts {
+			continue
+		}
+		rail := rail
+		sensors = append(sensors, Sensor{Name: rail, read: func() (float64, error) {
+			if time.Since(cacheTime) > time.Second {
+				readings, err := readPMIC()
+				if err != nil {
+					return 0, err
+				}
+				cache, cacheTime = readings, time.Now()
+			}
+			r, ok := cache[rail]
+			if !ok {
+				return 0, fmt.Errorf("no PMIC reading of %s", rail)
+			}
+			/* A * V = W, to mW */
+			return r.amps * r.volts * 1000, nil
+		}})
+	}
+	return sensors
+}
+
+// readPMIC returns the current and the voltage of each PMIC rail
+func readPMIC() (map[string]pmicReading, error) {
+	out, err := exec.Command(vcgencmd, "pmic_read_adc").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the PMIC ADCs: %v", err)
+	}
+	return parsePMIC(out), nil
+}
+
+func parsePMIC(out []byte) map[string]pmicReading {
+	readings := map[string]pmicReading{}
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		m := rePMICReading.FindStringSubmatch(scanner.Text())
+		if m == nil {
+			continue
+		}
+		v, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			continue
+		}
+		r := readings[m[1]]
+		if m[2] == "A" {
+			r.amps, r.hasAmps = v, true
+		} else {
+			r.volts, r.hasVolts = v, true
+		}
+		readings[m[1]] = r
+	}
+	return readings
+}
+
+func readString(path string) string {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func readFloat(path string) (float64, error) {
+	return strconv.ParseFloat(readString(path), 64)
+}
@@ -0,0 +1,121 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solar
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/goburrow/modbus"
+)
+
+// The EPEVER (Tracer, LandStar...) controllers answer Modbus RTU on their RS485 port, 115200 8N1 with
+// the slave id 1. The values are input registers scaled by 100, the 32 bits values are split in a low
+// and a high register.
+const (
+	epeverBaudRate     = 115200
+	epeverSlaveID      = 1
+	epeverTimeout      = 2 * time.Second
+	epeverPollInterval = 5 * time.Second
+
+	// 0x3100-0x310F: PV voltage, current, power L/H, battery voltage, charge current, charge power L/H, ..., load voltage, current, power L/H
+	epeverRealTime      = 0x3100
+	epeverRealTimeCount = 16
+	epeverBatterySoC    = 0x311A
+	// D3-D2 of the charging equipment status: no charging, float, boost, equalization
+	epeverChargingStatus = 0x3201
+	// battery current L/H, signed, positive when charging
+	epeverBatteryCurrent = 0x331B
+)
+
+var epeverChargeStates = []string{"off", "float", "boost", "equalize"}
+
+type epever struct {
+	handler *modbus.RTUClientHandler
+	client  modbus.Client
+}
+
+func newEPEVER(device string) (*epever, error) {
+	handler := modbus.NewRTUClientHandler(device)
+	handler.BaudRate = epeverBaudRate
+	handler.DataBits = 8
+	handler.Parity = "N"
+	handler.StopBits = 1
+	handler.SlaveId = epeverSlaveID
+	handler.Timeout = epeverTimeout
+	if err := handler.Connect(); err != nil {
+		return nil, err
+	}
+	return &epever{handler: handler, client: modbus.NewClient(handler)}, nil
+}
+
+func (e *epever) name() string {
+	return EPEVER
+}
+
+func (e *epever) read() (*Status, error) {
+	regs, err := e.readRegisters(epeverRealTime, epeverRealTimeCount)
+	if err != nil {
+		return nil, err
+	}
+	soc, err := e.readRegisters(epeverBatterySoC, 1)
+	if err != nil {
+		return nil, err
+	}
+	status, err := e.readRegisters(epeverChargingStatus, 1)
+	if err != nil {
+		return nil, err
+	}
+	current, err := e.readRegisters(epeverBatteryCurrent, 2)
+	if err != nil {
+		return nil, err
+	}
+	return &Status{
+		PVVoltage:      float64(regs[0]) / 100,
+		PVPower:        float64(uint32(regs[2])|uint32(regs[3])<<16) / 100,
+		BatteryVoltage: float64(regs[4]) / 100,
+		BatteryCurrent: float64(int32(uint32(current[0])|uint32(current[1])<<16)) / 100,
+		BatterySoC:     float64(soc[0]),
+		LoadPower:      float64(uint32(regs[14])|uint32(regs[15])<<16) / 100,
+		ChargeState:    epeverChargeStates[(status[0]>>2)&0x3],
+	}, nil
+}
+
+// readRegisters reads count input registers from address
+func (e *epever) readRegisters(address, count uint16) ([]uint16, error) {
+	data, err := e.client.ReadInputRegisters(address, count)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read register %#x: %v", address, err)
+	}
+	if len(data) != int(count)*2 {
+		return nil, fmt.Errorf("short read of register %#x: %d bytes", address, len(data))
+	}
+	regs := make([]uint16, count)
+	for i := range regs {
+		regs[i] = binary.BigEndian.Uint16(data[i*2:])
+	}
+	return regs, nil
+}
+
+func (e *epever) pollInterval() time.Duration {
+	return epeverPollInterval
+}
+
+func (e *epever) close() {
+	e.handler.Close()
+}
@@ -0,0 +1,228 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solar
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// Off-grid edge sites run from a PV panel and a battery behind a charge controller. The controller
+// reports the PV generation and the battery state, read over its serial port: Modbus RTU for the
+// EPEVER controllers, the VE.Direct text protocol for the Victron ones. Exported next to the node
+// power, the generation and the consumption can be compared in one place.
+const (
+	EPEVER  = "epever"
+	Victron = "victron"
+
+	// a failing controller (e.g. unplugged) is retried after this delay
+	retryInterval = 10 * time.Second
+)
+
+// Status is the last reading of the charge controller
+type Status struct {
+	PVVoltage float64 `json:"pv_voltage"`
+	// PVPower is in W
+	PVPower        float64 `json:"pv_power"`
+	BatteryVoltage float64 `json:"battery_voltage"`
+	// BatteryCurrent is in A, positive when charging
+	BatteryCurrent float64 `json:"battery_current"`
+	// BatterySoC is the battery state of charge in %, -1 if the controller does not report it
+	BatterySoC float64 `json:"battery_soc"`
+	// LoadPower is the power in W of the load output
+	LoadPower   float64 `json:"load_power"`
+	ChargeState string  `json:"charge_state"`
+	// PVEnergy and LoadEnergy are in J since the exporter started
+	PVEnergy   float64 `json:"pv_energy"`
+	LoadEnergy float64 `json:"load_energy"`
+}
+
+// controller reads a charge controller model
+type controller interface {
+	name() string
+	// read returns the current status, without the energy
+	read() (*Status, error)
+	// pollInterval is the delay between reads, 0 if the controller streams its readings
+	pollInterval() time.Duration
+	close()
+}
+
+type Controller struct {
+	ctrl controller
+
+	lock     sync.Mutex
+	status   *Status
+	lastRead time.Time
+}
+
+var (
+	current *Controller
+
+	pvPowerDesc = prometheus.NewDesc(
+		"node_solar_pv_power_watts",
+		"PV power reported by the charge controller.",
+		[]string{"controller"},
+		nil,
+	)
+	pvEnergyDesc = prometheus.NewDesc(
+		"node_solar_pv_energy_joule_total",
+		"PV energy generated since the exporter started.",
+		[]string{"controller"},
+		nil,
+	)
+	loadPowerDesc = prometheus.NewDesc(
+		"node_solar_load_power_watts",
+		"Power of the charge controller load output.",
+		[]string{"controller"},
+		nil,
+	)
+	loadEnergyDesc = prometheus.NewDesc(
+		"node_solar_load_energy_joule_total",
+		"Energy of the charge controller load output since the exporter started.",
+		[]string{"controller"},
+		nil,
+	)
+	batteryVoltageDesc = prometheus.NewDesc(
+		"node_battery_voltage_volts",
+		"Battery voltage reported by the charge controller.",
+		[]string{"controller"},
+		nil,
+	)
+	batteryCurrentDesc = prometheus.NewDesc(
+		"node_battery_current_amperes",
+		"Battery current reported by the charge controller, positive when charging.",
+		[]string{"controller"},
+		nil,
+	)
+	batterySoCDesc = prometheus.NewDesc(
+		"node_battery_soc_percent",
+		"Battery state of charge reported by the charge controller.",
+		[]string{"controller"},
+		nil,
+	)
+	chargeStateDesc = prometheus.NewDesc(
+		"node_charge_controller_state",
+		"Charge state of the charge controller, 1 for the current state.",
+		[]string{"controller", "state"},
+		nil,
+	)
+)
+
+// Start opens the charge controller of the kind (epever or victron) on the serial device and reads it in the background
+func Start(kind, device string) (*Controller, error) {
+	var ctrl controller
+	var err error
+	switch kind {
+	case EPEVER:
+		ctrl, err = newEPEVER(device)
+	case Victron:
+		ctrl, err = newVictron(device)
+	default:
+		return nil, fmt.Errorf("unknown charge controller %q", kind)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the %s charge controller on %s: %v", kind, device, err)
+	}
+	c := &Controller{ctrl: ctrl}
+	current = c
+	supervisor.Go("solar", c.run)
+	return c, nil
+}
+
+// GetStatus returns a copy of the last status of the started controller, or nil
+func GetStatus() *Status {
+	if current == nil {
+		return nil
+	}
+	return current.Status()
+}
+
+func (c *Controller) run() {
+	for {
+		s, err := c.ctrl.read()
+		if err != nil {
+			log.Printf("failed to read the %s charge controller: %v\n", c.ctrl.name(), err)
+			time.Sleep(retryInterval)
+			continue
+		}
+		c.update(s, time.Now())
+		time.Sleep(c.ctrl.pollInterval())
+	}
+}
+
+func (c *Controller) update(s *Status, now time.Time) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.status != nil {
+		/* energy (J) = average power (W) * time(second) */
+		seconds := now.Sub(c.lastRead).Seconds()
+		s.PVEnergy = c.status.PVEnergy + (c.status.PVPower+s.PVPower)/2*seconds
+		s.LoadEnergy = c.status.LoadEnergy + (c.status.LoadPower+s.LoadPower)/2*seconds
+	}
+	c.status = s
+	c.lastRead = now
+}
+
+// Status returns a copy of the last status, or nil before the first reading
+func (c *Controller) Status() *Status {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.status == nil {
+		return nil
+	}
+	s := *c.status
+	return &s
+}
+
+func (c *Controller) Close() {
+	c.ctrl.close()
+}
+
+func (c *Controller) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pvPowerDesc
+	ch <- pvEnergyDesc
+	ch <- loadPowerDesc
+	ch <- loadEnergyDesc
+	ch <- batteryVoltageDesc
+	ch <- batteryCurrentDesc
+	ch <- batterySoCDesc
+	ch <- chargeStateDesc
+}
+
+func (c *Controller) Collect(ch chan<- prometheus.Metric) {
+	s := c.Status()
+	if s == nil {
+		return
+	}
+	name := c.ctrl.name()
+	ch <- prometheus.MustNewConstMetric(pvPowerDesc, prometheus.GaugeValue, s.PVPower, name)
+	ch <- prometheus.MustNewConstMetric(pvEnergyDesc, prometheus.CounterValue, s.PVEnergy, name)
+	ch <- prometheus.MustNewConstMetric(loadPowerDesc, prometheus.GaugeValue, s.LoadPower, name)
+	ch <- prometheus.MustNewConstMetric(loadEnergyDesc, prometheus.CounterValue, s.LoadEnergy, name)
+	ch <- prometheus.MustNewConstMetric(batteryVoltageDesc, prometheus.GaugeValue, s.BatteryVoltage, name)
+	ch <- prometheus.MustNewConstMetric(batteryCurrentDesc, prometheus.GaugeValue, s.BatteryCurrent, name)
+	if s.BatterySoC >= 0 {
+		ch <- prometheus.MustNewConstMetric(batterySoCDesc, prometheus.GaugeValue, s.BatterySoC, name)
+	}
+	ch <- prometheus.MustNewConstMetric(chargeStateDesc, prometheus.GaugeValue, 1, name, s.ChargeState)
+}
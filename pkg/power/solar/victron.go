@@ -0,0 +1,157 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package solar
+
+import (
+	"bufio"
+	"bytes"
+	"strconv"
+	"time"
+
+	"github.com/goburrow/serial"
+)
+
+// The Victron controllers (SmartSolar, BlueSolar) stream a block of "\r\n<label>\t<value>" fields every
+// second on their VE.Direct port, 19200 8N1. A block ends with a Checksum field whose value byte makes
+// the sum of the block bytes 0 mod 256. Messages of the hex protocol, ":...\n", may be interleaved.
+const (
+	victronBaudRate = 19200
+	victronTimeout  = 5 * time.Second
+	checksumLabel   = "Checksum"
+)
+
+// CS field values
+var victronChargeStates = map[int]string{
+	0:   "off",
+	2:   "fault",
+	3:   "bulk",
+	4:   "absorption",
+	5:   "float",
+	7:   "equalize",
+	245: "starting",
+	247: "equalize",
+	252: "external",
+}
+
+type victron struct {
+	port   serial.Port
+	reader *bufio.Reader
+}
+
+func newVictron(device string) (*victron, error) {
+	port, err := serial.Open(&serial.Config{
+		Address:  device,
+		BaudRate: victronBaudRate,
+		DataBits: 8,
+		StopBits: 1,
+		Parity:   "N",
+		Timeout:  victronTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &victron{port: port, reader: bufio.NewReader(port)}, nil
+}
+
+func (v *victron) name() string {
+	return Victron
+}
+
+// read returns the next block with a valid checksum
+func (v *victron) read() (*Status, error) {
+	fields := map[string]string{}
+	line := []byte{}
+	var sum byte
+	for {
+		b, err := v.reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == ':' && len(line) == 0 {
+			// hex protocol message, not part of the block
+			if _, err = v.reader.ReadBytes('\n'); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		sum += b
+		switch b {
+		case '\r':
+			continue
+		case '\n':
+			if i := bytes.IndexByte(line, '\t'); i > 0 {
+				fields[string(line[:i])] = string(line[i+1:])
+			}
+			line = line[:0]
+			continue
+		case '\t':
+			if string(line) == checksumLabel {
+				c, err := v.reader.ReadByte()
+				if err != nil {
+					return nil, err
+				}
+				sum += c
+				if sum == 0 && len(fields) > 0 {
+					return parseVictron(fields), nil
+				}
+				// started in the middle of a block or corrupted, wait for the next one
+				fields = map[string]string{}
+				line = line[:0]
+				sum = 0
+				continue
+			}
+		}
+		line = append(line, b)
+	}
+}
+
+func parseVictron(fields map[string]string) *Status {
+	value := func(label string, scale float64) float64 {
+		v, err := strconv.ParseFloat(fields[label], 64)
+		if err != nil {
+			return 0
+		}
+		return v * scale
+	}
+	s := &Status{
+		PVVoltage:      value("VPV", 0.001),
+		PVPower:        value("PPV", 1),
+		BatteryVoltage: value("V", 0.001),
+		BatteryCurrent: value("I", 0.001),
+		BatterySoC:     -1,
+		ChargeState:    "unknown",
+	}
+	// the load current is only reported by the controllers with a load output
+	s.LoadPower = value("IL", 0.001) * s.BatteryVoltage
+	if _, ok := fields["SOC"]; ok {
+		s.BatterySoC = value("SOC", 0.1)
+	}
+	if cs, err := strconv.Atoi(fields["CS"]); err == nil {
+		if state, ok := victronChargeStates[cs]; ok {
+			s.ChargeState = state
+		}
+	}
+	return s
+}
+
+func (v *victron) pollInterval() time.Duration {
+	return 0
+}
+
+func (v *victron) close() {
+	v.port.Close()
+}
@@ -0,0 +1,207 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package power
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+)
+
+// The power sources register themselves with a priority, the collector asks the registry for the energy of
+// a zone and gets the reading of the highest priority available source measuring it. All the available
+// sources of a zone are read each time, so their counters stay current and a lower priority source takes
+// over without a jump when a higher one stops reading.
+const (
+	ZoneNode = "node"
+	ZoneCore = "core"
+	ZoneDram = "dram"
+
+	// PriorityMeasured is for the sources measuring the zone directly (RAPL)
+	PriorityMeasured = 100
+	// PriorityHost is for the node power meters (ACPI, BMC, smart plugs)
+	PriorityHost = 90
+	// PriorityPlatform and PrioritySensors are for the psys RAPL domain and the board power sensors
+	PriorityPlatform = 70
+	PrioritySensors  = 60
+	// PriorityBattery is for the discharge of the battery, a node without any meter
+	PriorityBattery = 50
+	// PriorityRails is for the sum of the SoC regulators, an upper bound from their current limits
+	PriorityRails = 30
+	// PriorityEstimate is for the models estimating the zone
+	PriorityEstimate = 10
+)
+
+// ErrNoZone is returned by the sources for the zones they do not measure
+var ErrNoZone = errors.New("zone not measured")
+
+// Source is a power backend measuring the energy of one or more zones
+type Source interface {
+	Name() string
+	// Init prepares the source, it is called once before the first reading
+	Init() error
+	IsAvailable() bool
+	// GetEnergy returns the energy (mJ) of the zone since the previous call for the zone
+	GetEnergy(zone string) (float64, error)
+	Shutdown()
+}
+
+type registration struct {
+	source   Source
+	priority int
+	// order keeps the registration order among equal priorities
+	order       int
+	initialized bool
+	failed      bool
+}
+
+var (
+	registryLock sync.Mutex
+	registry     []*registration
+	registered   int
+	// selected is the source of the last reading per zone
+	selected = map[string]string{}
+)
+
+// Register adds a source, replacing the source of the same name, it may be called at any time
+func Register(s Source, priority int) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for i, r := range registry {
+		if r.source.Name() == s.Name() {
+			r.source.Shutdown()
+			registry = append(registry[:i], registry[i+1:]...)
+			break
+		}
+	}
+	registered++
+	registry = append(registry, &registration{source: s, priority: priority, order: registered})
+	sort.SliceStable(registry, func(i, j int) bool {
+		if registry[i].priority != registry[j].priority {
+			return registry[i].priority > registry[j].priority
+		}
+		return registry[i].order < registry[j].order
+	})
+}
+
+// Detect initializes the sources registered since the last call and returns the available ones
+// by priority
+func Detect() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	detect()
+	available := []string{}
+	for _, r := range registry {
+		if !r.failed && r.source.IsAvailable() {
+			available = append(available, r.source.Name())
+		}
+	}
+	return available
+}
+
+func detect() {
+	for _, r := range registry {
+		if r.initialized {
+			continue
+		}
+		r.initialized = true
+		if err := r.source.Init(); err != nil {
+			log.Printf("failed to initialize the %s power source: %v\n", r.source.Name(), err)
+			r.failed = true
+		}
+	}
+}
+
+// GetEnergy returns the energy (mJ) of the zone since the previous call and the source it was read from,
+// the first source by priority with a positive reading. The error is the last error of the sources if
+// none read.
+func GetEnergy(zone string) (float64, string, error) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	detect()
+	energy, source := float64(0), ""
+	var lastErr error
+	for _, r := range registry {
+		if r.failed || !r.source.IsAvailable() {
+			continue
+		}
+		e, err := r.source.GetEnergy(zone)
+		if err == ErrNoZone {
+			continue
+		}
+		if err != nil {
+			lastErr = fmt.Errorf("%s: %v", r.source.Name(), err)
+			continue
+		}
+		if e > 0 && len(source) == 0 {
+			energy, source = e, r.source.Name()
+		}
+	}
+	if len(source) > 0 && selected[zone] != source {
+		log.Printf("reading the %s energy from %s\n", zone, source)
+		selected[zone] = source
+	}
+	if len(source) > 0 {
+		lastErr = nil
+	}
+	return energy, source, lastErr
+}
+
+// Shutdown shuts the sources down
+func Shutdown() {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+	for _, r := range registry {
+		if r.initialized && !r.failed {
+			r.source.Shutdown()
+		}
+	}
+}
+
+// Pending serves the sources reading all their zones at once: a zone is served from the last read until
+// it is consumed, then the zones are read again, so that asking each zone once per sample reads the
+// hardware once.
+type Pending struct {
+	lock   sync.Mutex
+	energy map[string]float64
+}
+
+// Take returns the energy of the zone, calling read when the zone was consumed
+func (p *Pending) Take(zone string, read func() (map[string]float64, error)) (float64, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if p.energy == nil {
+		p.energy = map[string]float64{}
+	}
+	e, ok := p.energy[zone]
+	if !ok {
+		energy, err := read()
+		if err != nil {
+			return 0, err
+		}
+		for z, v := range energy {
+			p.energy[z] += v
+		}
+		if e, ok = p.energy[zone]; !ok {
+			return 0, ErrNoZone
+		}
+	}
+	delete(p.energy, zone)
+	return e, nil
+}
@@ -0,0 +1,110 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The embodied carbon and energy of the device (manufacturing and transport, from the vendor
+// product carbon footprint) are static, they are exported next to the operational energy and
+// amortized per day over the expected lifetime of the device.
+const (
+	DefaultLifetimeYears = 5
+
+	daysPerYear  = 365.25
+	joulesPerKWh = 3.6e6
+	gramsPerKilo = 1000
+)
+
+type Embodied struct {
+	CarbonKg      float64 `yaml:"carbon_kg"`
+	EnergyKWh     float64 `yaml:"energy_kwh"`
+	LifetimeYears float64 `yaml:"lifetime_years"`
+}
+
+var (
+	embodiedCarbonDesc = prometheus.NewDesc(
+		"node_embodied_carbon_grams",
+		"Embodied carbon of the EdgeDevice in gCO2e.",
+		nil,
+		nil,
+	)
+	embodiedEnergyDesc = prometheus.NewDesc(
+		"node_embodied_energy_joules",
+		"Embodied energy of the EdgeDevice.",
+		nil,
+		nil,
+	)
+	embodiedCarbonPerDayDesc = prometheus.NewDesc(
+		"node_embodied_carbon_grams_per_day",
+		"Embodied carbon of the EdgeDevice in gCO2e amortized per day over its lifetime.",
+		nil,
+		nil,
+	)
+	embodiedEnergyPerDayDesc = prometheus.NewDesc(
+		"node_embodied_energy_joules_per_day",
+		"Embodied energy of the EdgeDevice amortized per day over its lifetime.",
+		nil,
+		nil,
+	)
+)
+
+// Override replaces the values of the profile by the values set (non zero) in o
+func (e *Embodied) Override(o Embodied) {
+	if o.CarbonKg > 0 {
+		e.CarbonKg = o.CarbonKg
+	}
+	if o.EnergyKWh > 0 {
+		e.EnergyKWh = o.EnergyKWh
+	}
+	if o.LifetimeYears > 0 {
+		e.LifetimeYears = o.LifetimeYears
+	}
+}
+
+// IsSet returns whether any embodied value is configured
+func (e *Embodied) IsSet() bool {
+	return e.CarbonKg > 0 || e.EnergyKWh > 0
+}
+
+func (e *Embodied) lifetimeDays() float64 {
+	if e.LifetimeYears > 0 {
+		return e.LifetimeYears * daysPerYear
+	}
+	return DefaultLifetimeYears * daysPerYear
+}
+
+func (e *Embodied) Describe(ch chan<- *prometheus.Desc) {
+	ch <- embodiedCarbonDesc
+	ch <- embodiedEnergyDesc
+	ch <- embodiedCarbonPerDayDesc
+	ch <- embodiedEnergyPerDayDesc
+}
+
+func (e *Embodied) Collect(ch chan<- prometheus.Metric) {
+	if e.CarbonKg > 0 {
+		carbon := e.CarbonKg * gramsPerKilo
+		ch <- prometheus.MustNewConstMetric(embodiedCarbonDesc, prometheus.GaugeValue, carbon)
+		ch <- prometheus.MustNewConstMetric(embodiedCarbonPerDayDesc, prometheus.GaugeValue, carbon/e.lifetimeDays())
+	}
+	if e.EnergyKWh > 0 {
+		energy := e.EnergyKWh * joulesPerKWh
+		ch <- prometheus.MustNewConstMetric(embodiedEnergyDesc, prometheus.GaugeValue, energy)
+		ch <- prometheus.MustNewConstMetric(embodiedEnergyPerDayDesc, prometheus.GaugeValue, energy/e.lifetimeDays())
+	}
+}
@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profile
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/yaml.v2"
+
+	"github.com/sustainable-computing-io/kepler/pkg/model"
+)
+
+// The hardware profiles describe the known edge SKUs. The catalog shipped with the exporter (data/profiles)
+// is installed in DefaultCatalogDir, the user profiles in the override directory replace the shipped
+// profiles with the same name. The profile of the device is matched at startup with the device-tree
+// compatible strings (ARM boards) or the DMI product and board names (x86).
+const (
+	DefaultCatalogDir  = "/var/lib/kepler/data/profiles"
+	DefaultOverrideDir = "/etc/kepler/profiles"
+
+	deviceTreeCompatiblePath = "/proc/device-tree/compatible"
+	dmiProductPath           = "/sys/class/dmi/id/product_name"
+	dmiBoardPath             = "/sys/class/dmi/id/board_name"
+)
+
+// Match lists glob patterns (path.Match syntax), a profile matches if any pattern matches
+type Match struct {
+	DeviceTree []string `yaml:"device_tree"`
+	DMIProduct []string `yaml:"dmi_product"`
+	DMIBoard   []string `yaml:"dmi_board"`
+}
+
+type Profile struct {
+	Name           string       `yaml:"name"`
+	Match          Match        `yaml:"match"`
+	IdlePowerWatts float64      `yaml:"idle_power_watts"`
+	TDPWatts       float64      `yaml:"tdp_watts"`
+	Coefficients   *model.Coeff `yaml:"coefficients"`
+	// Embodied is the embodied carbon and energy of the SKU, if known
+	Embodied *Embodied `yaml:"embodied"`
+}
+
+// identity is what the device reports about itself
+type identity struct {
+	deviceTree []string
+	dmiProduct string
+	dmiBoard   string
+}
+
+// LoadCatalog reads the profiles of the catalog directory, then of the override directory
+func LoadCatalog(catalogDir, overrideDir string) (map[string]*Profile, error) {
+	catalog := map[string]*Profile{}
+	for _, dir := range []string{catalogDir, overrideDir} {
+		if len(dir) == 0 {
+			continue
+		}
+		files, err := filepath.Glob(filepath.Join(dir, "*.yaml"))
+		if err != nil {
+			return nil, err
+		}
+		for _, file := range files {
+			p, err := loadProfile(file)
+			if err != nil {
+				return nil, err
+			}
+			catalog[p.Name] = p
+		}
+	}
+	return catalog, nil
+}
+
+func loadProfile(file string) (*Profile, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	p := &Profile{}
+	if err = yaml.UnmarshalStrict(data, p); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", file, err)
+	}
+	if len(p.Name) == 0 {
+		p.Name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+	return p, nil
+}
+
+// Detect returns the profile matching the device, or nil. If several profiles match, the first by name wins.
+func Detect(catalog map[string]*Profile) *Profile {
+	id := readIdentity()
+	names := make([]string, 0, len(catalog))
+	for name := range catalog {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if catalog[name].matches(id) {
+			return catalog[name]
+		}
+	}
+	return nil
+}
+
+func readIdentity() identity {
+	id := identity{}
+	if data, err := ioutil.ReadFile(deviceTreeCompatiblePath); err == nil {
+		// the compatible strings are NUL separated, from the most to the least specific
+		for _, c := range strings.Split(string(data), "\x00") {
+			if len(c) > 0 {
+				id.deviceTree = append(id.deviceTree, c)
+			}
+		}
+	}
+	if data, err := ioutil.ReadFile(dmiProductPath); err == nil {
+		id.dmiProduct = strings.TrimSpace(string(data))
+	}
+	if data, err := ioutil.ReadFile(dmiBoardPath); err == nil {
+		id.dmiBoard = strings.TrimSpace(string(data))
+	}
+	return id
+}
+
+func (p *Profile) matches(id identity) bool {
+	for _, c := range id.deviceTree {
+		if matchAny(p.Match.DeviceTree, c) {
+			return true
+		}
+	}
+	return matchAny(p.Match.DMIProduct, id.dmiProduct) || matchAny(p.Match.DMIBoard, id.dmiBoard)
+}
+
+func matchAny(patterns []string, value string) bool {
+	if len(value) == 0 {
+		return false
+	}
+	for _, pattern := range patterns {
+		if ok, err := path.Match(pattern, value); err == nil && ok {
+			return true
+		} else if err != nil {
+			log.Printf("invalid profile pattern %q: %v\n", pattern, err)
+		}
+	}
+	return false
+}
+
+var (
+	idlePowerDesc = prometheus.NewDesc(
+		"node_hardware_profile_idle_power_watts",
+		"Idle power of the hardware profile matching the EdgeDevice.",
+		[]string{
+			"profile",
+		},
+		nil,
+	)
+	tdpDesc = prometheus.NewDesc(
+		"node_hardware_profile_tdp_watts",
+		"TDP of the hardware profile matching the EdgeDevice.",
+		[]string{
+			"profile",
+		},
+		nil,
+	)
+)
+
+func (p *Profile) Describe(ch chan<- *prometheus.Desc) {
+	ch <- idlePowerDesc
+	ch <- tdpDesc
+}
+
+func (p *Profile) Collect(ch chan<- prometheus.Metric) {
+	ch <- prometheus.MustNewConstMetric(idlePowerDesc, prometheus.GaugeValue, p.IdlePowerWatts, p.Name)
+	ch <- prometheus.MustNewConstMetric(tdpDesc, prometheus.GaugeValue, p.TDPWatts, p.Name)
+}
@@ -0,0 +1,173 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profiler
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sustainable-computing-io/kepler/pkg/attacher"
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The energy profile of a container samples its stacks with the profile BPF module and weights them by
+// the energy of the container: each sample the energy of the container is split among the stacks
+// sampled in the interval by their share of the samples. The profile is written in the folded format of
+// the flame graph tools, one "comm;outer;...;inner µJ" line per stack, the kernel frames suffixed _[k].
+type Profiler struct {
+	lock      sync.Mutex
+	namespace string
+	name      string
+	output    string
+	profile   *attacher.Profile
+	symbols   *symbolizer
+	// folded is the energy (µJ) per folded stack since the profiler started
+	folded map[string]float64
+}
+
+// New starts profiling the container namespace/name at frequency samples per second on every CPU,
+// writing the profile to output
+func New(container, output string, frequency int) (*Profiler, error) {
+	parts := strings.SplitN(container, "/", 2)
+	if len(parts) != 2 || len(parts[0]) == 0 || len(parts[1]) == 0 {
+		return nil, fmt.Errorf("invalid container %q, namespace/name", container)
+	}
+	if len(output) == 0 {
+		return nil, fmt.Errorf("no profile output")
+	}
+	profile, err := attacher.StartProfile(frequency)
+	if err != nil {
+		return nil, err
+	}
+	return &Profiler{
+		namespace: parts[0],
+		name:      parts[1],
+		output:    output,
+		profile:   profile,
+		symbols:   newSymbolizer(),
+		folded:    map[string]float64{},
+	}, nil
+}
+
+// Update weights the stacks sampled since the last sample by the energy of the container and writes the
+// profile, it is registered with collector.OnSample
+func (p *Profiler) Update(s *collector.Snapshot) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	if err := p.profile.SetCgroups(collector.ContainerCgroups(p.namespace, p.name)); err != nil {
+		log.Printf("failed to set the profiled cgroups: %v\n", err)
+	}
+	stacks, dropped, err := p.profile.Drain()
+	if err != nil {
+		log.Printf("failed to drain the profile: %v\n", err)
+		return
+	}
+	if dropped > 0 {
+		log.Printf("%d profile samples dropped, the stack map is full, raise --bpf-map-size (%d)\n", dropped, attacher.MapSize)
+	}
+	energy := containerEnergy(s, p.namespace, p.name)
+	total := uint64(0)
+	for _, stack := range stacks {
+		total += stack.Count
+	}
+	if total == 0 || energy == 0 {
+		return
+	}
+	p.symbols.reset()
+	for _, stack := range stacks {
+		/* energy (µJ) = energy (mJ) * 1000 */
+		p.folded[p.fold(stack)] += energy * 1000 * float64(stack.Count) / float64(total)
+	}
+	if err := p.write(); err != nil {
+		log.Printf("failed to write the energy profile: %v\n", err)
+	}
+}
+
+// containerEnergy returns the energy (mJ) of a container in a snapshot
+func containerEnergy(s *collector.Snapshot, namespace, name string) float64 {
+	for _, c := range s.Containers {
+		if c.Namespace != namespace || c.Name != name {
+			continue
+		}
+		e := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, a := range c.Accelerators {
+			e += a
+		}
+		return float64(e)
+	}
+	return 0
+}
+
+// fold returns the folded stack of a sample, from the command to the innermost kernel frame
+func (p *Profiler) fold(stack attacher.StackSample) string {
+	frames := make([]string, 0, 1+len(stack.User)+len(stack.Kernel))
+	frames = append(frames, sanitize(stack.Comm))
+	for i := len(stack.User) - 1; i >= 0; i-- {
+		frames = append(frames, sanitize(p.symbols.userFrame(stack.PID, stack.User[i])))
+	}
+	for i := len(stack.Kernel) - 1; i >= 0; i-- {
+		frames = append(frames, sanitize(p.symbols.kernelFrame(stack.Kernel[i])))
+	}
+	if len(stack.User) == 0 && len(stack.Kernel) == 0 {
+		frames = append(frames, unknownFrame)
+	}
+	return strings.Join(frames, ";")
+}
+
+// sanitize replaces the separators of the folded format in a frame
+func sanitize(frame string) string {
+	return strings.NewReplacer(";", ":", " ", "_", "\n", "_").Replace(frame)
+}
+
+// write replaces the profile file, the stacks sorted for stable diffs between profiles
+func (p *Profiler) write() error {
+	stacks := make([]string, 0, len(p.folded))
+	for stack := range p.folded {
+		stacks = append(stacks, stack)
+	}
+	sort.Strings(stacks)
+	var buf bytes.Buffer
+	for _, stack := range stacks {
+		if uj := math.Round(p.folded[stack]); uj > 0 {
+			fmt.Fprintf(&buf, "%s %.0f\n", stack, uj)
+		}
+	}
+	tmp := filepath.Join(filepath.Dir(p.output), "."+filepath.Base(p.output)+".tmp")
+	if err := ioutil.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, p.output); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// Close stops sampling the stacks, the profile file is kept
+func (p *Profiler) Close() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	p.profile.Close()
+}
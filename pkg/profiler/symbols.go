@@ -0,0 +1,265 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package profiler
+
+import (
+	"bufio"
+	"debug/elf"
+	"debug/gosym"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	kallsymsPath = "/proc/kallsyms"
+	unknownFrame = "[unknown]"
+)
+
+type symbol struct {
+	addr uint64
+	size uint64
+	name string
+}
+
+// symbolTable is sorted by address
+type symbolTable []symbol
+
+// lookup returns the symbol holding addr, a symbol without size holds the addresses up to the next one
+func (t symbolTable) lookup(addr uint64) (string, bool) {
+	i := sort.Search(len(t), func(i int) bool { return t[i].addr > addr }) - 1
+	if i < 0 || (t[i].size > 0 && addr >= t[i].addr+t[i].size) {
+		return "", false
+	}
+	return t[i].name, true
+}
+
+// mapping is an executable mapping of a process
+type mapping struct {
+	start, end, offset uint64
+	path               string
+	// file identifies the mapped file across the processes and mount namespaces, by device and inode
+	file string
+}
+
+// elfFile are the function symbols of an executable or library and its loadable segments, the functions
+// of the stripped Go binaries are found in their pclntab
+type elfFile struct {
+	symbols  symbolTable
+	goTable  *gosym.Table
+	segments []elf.ProgHeader
+}
+
+// lookup returns the function holding a virtual address of the file
+func (e *elfFile) lookup(addr uint64) (string, bool) {
+	if name, ok := e.symbols.lookup(addr); ok {
+		return name, true
+	}
+	if e.goTable != nil {
+		if fn := e.goTable.PCToFunc(addr); fn != nil {
+			return fn.Name, true
+		}
+	}
+	return "", false
+}
+
+// symbolizer resolves the kernel addresses with kallsyms and the user addresses with the symbols of the
+// mapped files, read from the root of the process so the files of the containers are found
+type symbolizer struct {
+	kernel symbolTable
+	files  map[string]*elfFile
+	// maps are the executable mappings of the processes, read again at each resolution
+	maps map[uint32][]mapping
+}
+
+func newSymbolizer() *symbolizer {
+	s := &symbolizer{files: map[string]*elfFile{}, maps: map[uint32][]mapping{}}
+	s.kernel = readKallsyms()
+	return s
+}
+
+// readKallsyms returns the text symbols of the kernel, none when the addresses are hidden by kptr_restrict
+func readKallsyms() symbolTable {
+	f, err := os.Open(kallsymsPath)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+	table := symbolTable{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 3 || (fields[1] != "t" && fields[1] != "T") {
+			continue
+		}
+		addr, err := strconv.ParseUint(fields[0], 16, 64)
+		if err != nil || addr == 0 {
+			continue
+		}
+		table = append(table, symbol{addr: addr, name: fields[2]})
+	}
+	sort.Slice(table, func(i, j int) bool { return table[i].addr < table[j].addr })
+	return table
+}
+
+// kernelFrame returns the frame of a kernel address, suffixed as the kernel frames of the flame graphs
+func (s *symbolizer) kernelFrame(addr uint64) string {
+	if name, ok := s.kernel.lookup(addr); ok {
+		return name + "_[k]"
+	}
+	return "[kernel]_[k]"
+}
+
+// reset drops the mappings of the processes, they are read again at the next resolution
+func (s *symbolizer) reset() {
+	s.maps = map[uint32][]mapping{}
+}
+
+// userFrame returns the frame of a user address of a process
+func (s *symbolizer) userFrame(pid uint32, addr uint64) string {
+	mappings, ok := s.maps[pid]
+	if !ok {
+		mappings, _ = readMaps(pid)
+		s.maps[pid] = mappings
+	}
+	for _, m := range mappings {
+		if addr < m.start || addr >= m.end {
+			continue
+		}
+		f := s.file(pid, m)
+		if f == nil {
+			return "[" + filepath.Base(m.path) + "]"
+		}
+		offset := addr - m.start + m.offset
+		for _, seg := range f.segments {
+			if offset < seg.Off || offset >= seg.Off+seg.Filesz {
+				continue
+			}
+			if name, ok := f.lookup(offset - seg.Off + seg.Vaddr); ok {
+				return name
+			}
+			break
+		}
+		return "[" + filepath.Base(m.path) + "]"
+	}
+	return unknownFrame
+}
+
+// file returns the symbols of a mapped file, nil if it can not be read
+func (s *symbolizer) file(pid uint32, m mapping) *elfFile {
+	if f, ok := s.files[m.file]; ok {
+		return f
+	}
+	f, err := readELF(fmt.Sprintf("/proc/%d/root%s", pid, m.path))
+	if err != nil {
+		// the process exited, its file is looked up again with the next process mapping it
+		return nil
+	}
+	s.files[m.file] = f
+	return f
+}
+
+// readELF reads the function symbols of a file, from its symbol table or, when stripped, its dynamic
+// symbols
+func readELF(path string) (*elfFile, error) {
+	f, err := elf.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	symbols, err := f.Symbols()
+	if err != nil || len(symbols) == 0 {
+		symbols, _ = f.DynamicSymbols()
+	}
+	e := &elfFile{goTable: goTable(f)}
+	for _, sym := range symbols {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || sym.Value == 0 {
+			continue
+		}
+		e.symbols = append(e.symbols, symbol{addr: sym.Value, size: sym.Size, name: sym.Name})
+	}
+	sort.Slice(e.symbols, func(i, j int) bool { return e.symbols[i].addr < e.symbols[j].addr })
+	for _, prog := range f.Progs {
+		if prog.Type == elf.PT_LOAD {
+			e.segments = append(e.segments, prog.ProgHeader)
+		}
+	}
+	return e, nil
+}
+
+// goTable returns the function table of a Go binary, nil for the other files
+func goTable(f *elf.File) *gosym.Table {
+	pclntab, text := f.Section(".gopclntab"), f.Section(".text")
+	if pclntab == nil || text == nil {
+		return nil
+	}
+	data, err := pclntab.Data()
+	if err != nil {
+		return nil
+	}
+	table, err := gosym.NewTable(nil, gosym.NewLineTable(data, text.Addr))
+	if err != nil {
+		return nil
+	}
+	return table
+}
+
+// readMaps returns the executable file mappings of a process
+func readMaps(pid uint32) ([]mapping, error) {
+	f, err := os.Open(fmt.Sprintf("/proc/%d/maps", pid))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	mappings := []mapping{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if m, ok := parseMapsLine(scanner.Text()); ok {
+			mappings = append(mappings, m)
+		}
+	}
+	return mappings, scanner.Err()
+}
+
+// parseMapsLine parses an executable file mapping of /proc/<pid>/maps, e.g.
+// 7f2c4a000000-7f2c4a1b5000 r-xp 00028000 08:01 1835037 /usr/lib/x86_64-linux-gnu/libc.so.6
+func parseMapsLine(line string) (mapping, bool) {
+	fields := strings.Fields(line)
+	if len(fields) < 6 || len(fields[1]) < 3 || fields[1][2] != 'x' || !strings.HasPrefix(fields[5], "/") {
+		return mapping{}, false
+	}
+	bounds := strings.SplitN(fields[0], "-", 2)
+	if len(bounds) != 2 {
+		return mapping{}, false
+	}
+	start, err1 := strconv.ParseUint(bounds[0], 16, 64)
+	end, err2 := strconv.ParseUint(bounds[1], 16, 64)
+	offset, err3 := strconv.ParseUint(fields[2], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return mapping{}, false
+	}
+	return mapping{
+		start:  start,
+		end:    end,
+		offset: offset,
+		path:   strings.Join(fields[5:], " "),
+		file:   fields[3] + ":" + fields[4],
+	}, true
+}
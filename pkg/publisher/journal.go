@@ -0,0 +1,147 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package publisher
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The journal writer logs the power of the node and of the containers to the systemd journal with
+// structured fields (CONTAINER=, NAMESPACE=, WATTS=...), for the minimal edge systems shipping only
+// their journal. It speaks the native journal protocol, one datagram per entry, e.g. filtered with
+// journalctl SYSLOG_IDENTIFIER=kepler NAMESPACE=default.
+const (
+	DefaultJournalSocket     = "/run/systemd/journal/socket"
+	DefaultJournalIdentifier = "kepler"
+
+	// journal priority of the entries, info
+	journalPriority = "6"
+)
+
+type JournalConfig struct {
+	Socket     string
+	Identifier string
+	// MinWatts skips the containers drawing less, the node is always logged
+	MinWatts float64
+}
+
+type Journal struct {
+	config   *JournalConfig
+	conn     *net.UnixConn
+	lastTime time.Time
+	// sequence is the number of the sample logged, the journal adds the boot id
+	sequence string
+}
+
+// NewJournal connects to the journal socket
+func NewJournal(config *JournalConfig) (*Journal, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: config.Socket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to the journal at %s: %v", config.Socket, err)
+	}
+	return &Journal{config: config, conn: conn}, nil
+}
+
+// Publish logs a sample, it is registered with collector.OnSample. The power is the energy over the
+// time since the previous sample, the first sample is only used as the start.
+func (j *Journal) Publish(s *collector.Snapshot) {
+	j.sequence = strconv.FormatUint(s.Sequence, 10)
+	last := j.lastTime
+	j.lastTime = s.Time
+	if last.IsZero() {
+		return
+	}
+	seconds := s.Time.Sub(last).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	// the energies are in mJ
+	watts := func(mJ float64) string {
+		return fmt.Sprintf("%.3f", mJ/1000/seconds)
+	}
+
+	n := s.EdgeDevice
+	total := n.EnergyInCore + n.EnergyInDram + n.EnergyInGPU + n.EnergyInOther
+	fields := [][2]string{
+		{"MESSAGE", fmt.Sprintf("node %s draws %s W", n.Name, watts(total))},
+		{"NODE", n.Name},
+		{"WATTS", watts(total)},
+		{"CORE_WATTS", watts(n.EnergyInCore)},
+		{"DRAM_WATTS", watts(n.EnergyInDram)},
+		{"GPU_WATTS", watts(n.EnergyInGPU)},
+		{"OTHER_WATTS", watts(n.EnergyInOther)},
+	}
+	if n.Quality != nil {
+		fields = append(fields, [2]string{"QUALITY", fmt.Sprintf("%.2f", n.Quality.Score)})
+	}
+	j.send(fields)
+	for _, c := range s.Containers {
+		total := float64(c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther)
+		if total/1000/seconds < j.config.MinWatts {
+			continue
+		}
+		j.send([][2]string{
+			{"MESSAGE", fmt.Sprintf("container %s/%s draws %s W", c.Namespace, c.Name, watts(total))},
+			{"NODE", n.Name},
+			{"CONTAINER", c.Name},
+			{"NAMESPACE", c.Namespace},
+			{"COMMAND", c.Command},
+			{"WATTS", watts(total)},
+			{"CORE_WATTS", watts(float64(c.EnergyInCore))},
+			{"DRAM_WATTS", watts(float64(c.EnergyInDram))},
+			{"GPU_WATTS", watts(float64(c.EnergyInGPU))},
+			{"OTHER_WATTS", watts(float64(c.EnergyInOther))},
+		})
+	}
+}
+
+func (j *Journal) send(fields [][2]string) {
+	fields = append(fields, [2]string{"SEQUENCE", j.sequence}, [2]string{"PRIORITY", journalPriority}, [2]string{"SYSLOG_IDENTIFIER", j.config.Identifier})
+	if _, err := j.conn.Write(encodeJournalEntry(fields)); err != nil {
+		log.Printf("failed to write to the journal: %v\n", err)
+	}
+}
+
+// encodeJournalEntry encodes the fields in the native journal protocol, FIELD=value lines, the values
+// holding a newline are written as the field name, their little endian 64 bit length and the raw value
+func encodeJournalEntry(fields [][2]string) []byte {
+	var buf bytes.Buffer
+	for _, f := range fields {
+		if !strings.Contains(f[1], "\n") {
+			buf.WriteString(f[0] + "=" + f[1] + "\n")
+			continue
+		}
+		buf.WriteString(f[0] + "\n")
+		_ = binary.Write(&buf, binary.LittleEndian, uint64(len(f[1])))
+		buf.WriteString(f[1] + "\n")
+	}
+	return buf.Bytes()
+}
+
+// Stop closes the journal socket
+func (j *Journal) Stop() {
+	j.conn.Close()
+}
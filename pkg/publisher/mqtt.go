@@ -0,0 +1,225 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package publisher
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"strings"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/fxamacker/cbor/v2"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The publisher pushes the snapshot of every sample to an MQTT broker, for the edge devices reporting
+// to the fleet over MQTT instead of being scraped. The samples are queued while the broker is not
+// reachable, the oldest are dropped once the queue is full.
+const (
+	FormatJSON = "json"
+	FormatCBOR = "cbor"
+
+	// DefaultTopic is the topic template, {node} is replaced by the node name
+	DefaultTopic = "kepler/{node}/energy"
+
+	queueSize          = 100
+	connectTimeout     = 10 * time.Second
+	publishTimeout     = 10 * time.Second
+	maxReconnectPeriod = 2 * time.Minute
+)
+
+type Config struct {
+	// Broker is the broker URL, e.g. tcp://broker:1883, ssl://broker:8883 or ws://broker:80/mqtt
+	Broker   string
+	Topic    string
+	QoS      byte
+	Retain   bool
+	Format   string
+	ClientID string
+	Username string
+	// PasswordFile holds the password, so it is not visible in the process arguments
+	PasswordFile string
+	// CAFile, CertFile and KeyFile configure TLS, the client certificate is optional
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+type Publisher struct {
+	config *Config
+	client mqtt.Client
+	topic  string
+	cbor   cbor.EncMode
+	queue  chan *collector.Snapshot
+	done   chan struct{}
+}
+
+// New validates the configuration and returns a publisher, it connects in Run
+func New(config *Config) (*Publisher, error) {
+	if config.QoS > 2 {
+		return nil, fmt.Errorf("invalid MQTT QoS %d", config.QoS)
+	}
+	if config.Format != FormatJSON && config.Format != FormatCBOR {
+		return nil, fmt.Errorf("unknown MQTT payload format %q", config.Format)
+	}
+	opts := mqtt.NewClientOptions().
+		AddBroker(config.Broker).
+		SetClientID(config.ClientID).
+		SetUsername(config.Username).
+		SetCleanSession(false).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectTimeout(connectTimeout).
+		SetMaxReconnectInterval(maxReconnectPeriod).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			log.Printf("lost connection to %s: %v\n", config.Broker, err)
+		}).
+		SetOnConnectHandler(func(_ mqtt.Client) {
+			log.Printf("connected to %s\n", config.Broker)
+		})
+	if len(config.PasswordFile) > 0 {
+		password, err := ioutil.ReadFile(config.PasswordFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", config.PasswordFile, err)
+		}
+		opts.SetPassword(strings.TrimSpace(string(password)))
+	}
+	if len(config.CAFile) > 0 || len(config.CertFile) > 0 {
+		tlsConfig, err := newTLSConfig(config)
+		if err != nil {
+			return nil, err
+		}
+		opts.SetTLSConfig(tlsConfig)
+	}
+	// the times are epoch floats in CBOR, more compact than strings
+	encMode, err := cbor.EncOptions{Time: cbor.TimeUnixMicro}.EncMode()
+	if err != nil {
+		return nil, err
+	}
+	return &Publisher{
+		config: config,
+		cbor:   encMode,
+		client: mqtt.NewClient(opts),
+		topic:  strings.ReplaceAll(config.Topic, "{node}", collector.EdgeDeviceName),
+		queue:  make(chan *collector.Snapshot, queueSize),
+		done:   make(chan struct{}),
+	}, nil
+}
+
+func newTLSConfig(config *Config) (*tls.Config, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if len(config.CAFile) > 0 {
+		ca, err := ioutil.ReadFile(config.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %v", config.CAFile, err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no certificate in %s", config.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if len(config.CertFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Run connects to the broker in the background, retrying with backoff, and publishes the queued samples
+func (p *Publisher) Run() {
+	// with SetConnectRetry the token completes once connected, the publications wait for it
+	p.client.Connect()
+	go func() {
+		defer close(p.done)
+		for s := range p.queue {
+			sample := s
+			supervisor.Call("mqtt", func() {
+				if err := p.Send(sample); err != nil {
+					log.Printf("%v\n", err)
+				}
+			})
+		}
+	}()
+}
+
+// Send publishes a sample, waiting up to publishTimeout for the broker, the samples replayed from the spool
+// are sent with it instead of Publish
+func (p *Publisher) Send(s *collector.Snapshot) error {
+	payload, err := p.encode(s)
+	if err != nil {
+		return fmt.Errorf("failed to encode the sample: %v", err)
+	}
+	token := p.client.Publish(p.topic, p.config.QoS, p.config.Retain, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return fmt.Errorf("timeout publishing to %s", p.topic)
+	}
+	if err = token.Error(); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", p.topic, err)
+	}
+	return nil
+}
+
+func (p *Publisher) encode(s *collector.Snapshot) ([]byte, error) {
+	if p.config.Format == FormatCBOR {
+		// the JSON field names are used as CBOR map keys
+		return p.cbor.Marshal(s)
+	}
+	return json.Marshal(s)
+}
+
+// Publish queues a sample, it is registered with collector.OnSample and never blocks the sampling
+func (p *Publisher) Publish(s *collector.Snapshot) {
+	enqueue(p.queue, s, "MQTT")
+}
+
+// enqueue queues a sample without blocking, dropping the oldest once the queue is full
+func enqueue(queue chan *collector.Snapshot, s *collector.Snapshot, name string) {
+	for {
+		select {
+		case queue <- s:
+			return
+		default:
+		}
+		select {
+		case <-queue:
+			log.Printf("%s queue full, dropping the oldest sample\n", name)
+		default:
+		}
+	}
+}
+
+// Stop publishes the queued samples and disconnects, it must be called once the sampling stopped
+func (p *Publisher) Stop() {
+	close(p.queue)
+	select {
+	case <-p.done:
+	case <-time.After(publishTimeout):
+		log.Printf("timeout publishing the queued samples to %s\n", p.topic)
+	}
+	p.client.Disconnect(uint(publishTimeout.Milliseconds()))
+}
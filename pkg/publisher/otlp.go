@@ -0,0 +1,316 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package publisher
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/version"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The OTLP exporter pushes the energy of every sample to an OpenTelemetry collector, in the JSON
+// encoding of OTLP/HTTP so it needs no SDK. The energies are sums in J with the delta temporality:
+// each data point is the energy of the sample, from the previous sample to this one, as the Curr
+// energies of the collector. The first sample starts a sample period before it.
+const (
+	DefaultOTLPPath = "/v1/metrics"
+
+	otlpQueueSize = 100
+	otlpTimeout   = 10 * time.Second
+	// AGGREGATION_TEMPORALITY_DELTA
+	otlpDeltaTemporality = 1
+	otlpScope            = "github.com/sustainable-computing-io/kepler"
+)
+
+type OTLPConfig struct {
+	// Endpoint is the URL of the collector, e.g. http://otel-collector:4318, DefaultOTLPPath is added
+	// when it has no path
+	Endpoint string
+	// Headers are comma separated key=value headers, e.g. for the authentication
+	Headers string
+	// Attributes are comma separated key=value resource attributes describing the device, e.g. site=plant-1
+	Attributes string
+}
+
+type OTLP struct {
+	url      string
+	headers  map[string]string
+	resource []otlpKeyValue
+	client   *http.Client
+	lastTime time.Time
+	queue    chan *collector.Snapshot
+	done     chan struct{}
+}
+
+type otlpRequest struct {
+	ResourceMetrics []otlpResourceMetrics `json:"resourceMetrics"`
+}
+
+type otlpResourceMetrics struct {
+	Resource     otlpResource       `json:"resource"`
+	ScopeMetrics []otlpScopeMetrics `json:"scopeMetrics"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeMetrics struct {
+	Scope   otlpInstrumentationScope `json:"scope"`
+	Metrics []otlpMetric             `json:"metrics"`
+}
+
+type otlpInstrumentationScope struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+}
+
+type otlpMetric struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Unit        string  `json:"unit"`
+	Sum         otlpSum `json:"sum"`
+}
+
+type otlpSum struct {
+	DataPoints             []otlpDataPoint `json:"dataPoints"`
+	AggregationTemporality int             `json:"aggregationTemporality"`
+	IsMonotonic            bool            `json:"isMonotonic"`
+}
+
+type otlpDataPoint struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+	// the 64 bit integers are strings in the JSON encoding
+	StartTimeUnixNano string  `json:"startTimeUnixNano"`
+	TimeUnixNano      string  `json:"timeUnixNano"`
+	AsDouble          float64 `json:"asDouble"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue string `json:"stringValue"`
+}
+
+// NewOTLP validates the configuration and returns an exporter, it pushes in Run
+func NewOTLP(config *OTLPConfig) (*OTLP, error) {
+	u, err := url.Parse(config.Endpoint)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return nil, fmt.Errorf("invalid OTLP endpoint %q", config.Endpoint)
+	}
+	if len(strings.Trim(u.Path, "/")) == 0 {
+		u.Path = DefaultOTLPPath
+	}
+	headers, err := parseKeyValues(config.Headers)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP headers: %v", err)
+	}
+	attributes, err := parseKeyValues(config.Attributes)
+	if err != nil {
+		return nil, fmt.Errorf("invalid OTLP resource attributes: %v", err)
+	}
+	arch, err := source.GetCPUArchitecture()
+	if err != nil {
+		arch = "unknown"
+	}
+	resource := []otlpKeyValue{
+		otlpAttribute("service.name", "kepler"),
+		otlpAttribute("service.version", version.Version),
+		otlpAttribute("host.name", collector.EdgeDeviceName),
+		otlpAttribute("host.arch", runtime.GOARCH),
+		otlpAttribute("host.cpu.architecture", arch),
+	}
+	for _, key := range sortedKeys(attributes) {
+		resource = append(resource, otlpAttribute(key, attributes[key]))
+	}
+	return &OTLP{
+		url:      u.String(),
+		headers:  headers,
+		resource: resource,
+		client:   &http.Client{Timeout: otlpTimeout},
+		queue:    make(chan *collector.Snapshot, otlpQueueSize),
+		done:     make(chan struct{}),
+	}, nil
+}
+
+// parseKeyValues parses comma separated key=value pairs
+func parseKeyValues(s string) (map[string]string, error) {
+	m := map[string]string{}
+	for _, kv := range strings.Split(s, ",") {
+		if len(strings.TrimSpace(kv)) == 0 {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%q is not key=value", kv)
+		}
+		m[strings.TrimSpace(kv[:i])] = strings.TrimSpace(kv[i+1:])
+	}
+	return m, nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func otlpAttribute(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: value}}
+}
+
+// Run pushes the queued samples in the background
+func (o *OTLP) Run() {
+	go func() {
+		defer close(o.done)
+		for s := range o.queue {
+			sample := s
+			supervisor.Call("otlp", func() {
+				if err := o.Send(sample); err != nil {
+					log.Printf("%v\n", err)
+					// the sample is dropped, the next interval starts after it
+					o.lastTime = sample.Time
+				}
+			})
+		}
+	}()
+}
+
+// Publish queues a sample, it is registered with collector.OnSample and never blocks the sampling
+func (o *OTLP) Publish(s *collector.Snapshot) {
+	enqueue(o.queue, s, "OTLP")
+}
+
+// Send pushes a sample, the samples replayed from the spool are sent with it instead of Publish
+func (o *OTLP) Send(s *collector.Snapshot) error {
+	last := o.lastTime
+	if last.IsZero() {
+		// a snapshot holds the energy of its own interval, the first one is sent as well
+		last = s.Time.Add(-collector.GetSamplePeriod())
+	} else if !s.Time.After(last) {
+		// already sent
+		return nil
+	}
+	payload, err := json.Marshal(o.request(s, last))
+	if err != nil {
+		return fmt.Errorf("failed to encode the OTLP metrics: %v", err)
+	}
+	req, err := http.NewRequest(http.MethodPost, o.url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("failed to create the OTLP request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range o.headers {
+		req.Header.Set(k, v)
+	}
+	res, err := o.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to push the metrics to %s: %v", o.url, err)
+	}
+	defer res.Body.Close()
+	body, _ := ioutil.ReadAll(res.Body)
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to push the metrics to %s: %s %s", o.url, res.Status, strings.TrimSpace(string(body)))
+	}
+	// a failed sample is sent again by the spool, the start of the next interval is only moved once sent
+	o.lastTime = s.Time
+	return nil
+}
+
+// request returns the energy of a sample since the previous one, the snapshot energies are in mJ
+func (o *OTLP) request(s *collector.Snapshot, start time.Time) *otlpRequest {
+	startNano := strconv.FormatInt(start.UnixNano(), 10)
+	timeNano := strconv.FormatInt(s.Time.UnixNano(), 10)
+	point := func(mJ float64, attributes ...otlpKeyValue) otlpDataPoint {
+		return otlpDataPoint{Attributes: attributes, StartTimeUnixNano: startNano, TimeUnixNano: timeNano, AsDouble: mJ / 1000}
+	}
+
+	n := s.EdgeDevice
+	node := []otlpDataPoint{
+		point(n.EnergyInCore, otlpAttribute("component", "core")),
+		point(n.EnergyInDram, otlpAttribute("component", "dram")),
+		point(n.EnergyInGPU, otlpAttribute("component", "gpu")),
+		point(n.EnergyInOther, otlpAttribute("component", "other")),
+	}
+	for class, e := range n.Accelerators {
+		node = append(node, point(e, otlpAttribute("component", class)))
+	}
+	containers := []otlpDataPoint{}
+	for _, c := range s.Containers {
+		labels := []otlpKeyValue{
+			otlpAttribute("k8s.pod.name", c.Name),
+			otlpAttribute("k8s.namespace.name", c.Namespace),
+			otlpAttribute("process.command", c.Command),
+		}
+		with := func(component string) []otlpKeyValue {
+			return append(append([]otlpKeyValue{}, labels...), otlpAttribute("component", component))
+		}
+		containers = append(containers,
+			point(float64(c.EnergyInCore), with("core")...),
+			point(float64(c.EnergyInDram), with("dram")...),
+			point(float64(c.EnergyInGPU), with("gpu")...),
+			point(float64(c.EnergyInOther), with("other")...),
+		)
+		for class, e := range c.Accelerators {
+			containers = append(containers, point(float64(e), with(class)...))
+		}
+	}
+	sum := func(points []otlpDataPoint) otlpSum {
+		return otlpSum{DataPoints: points, AggregationTemporality: otlpDeltaTemporality, IsMonotonic: true}
+	}
+	return &otlpRequest{ResourceMetrics: []otlpResourceMetrics{{
+		Resource: otlpResource{Attributes: o.resource},
+		ScopeMetrics: []otlpScopeMetrics{{
+			Scope: otlpInstrumentationScope{Name: otlpScope, Version: version.Version},
+			Metrics: []otlpMetric{
+				{Name: "kepler.node.energy", Description: "Energy consumed by the node per component", Unit: "J", Sum: sum(node)},
+				{Name: "kepler.container.energy", Description: "Energy consumed by the pod per component", Unit: "J", Sum: sum(containers)},
+			},
+		}},
+	}}}
+}
+
+// Stop pushes the queued samples, it must be called once the sampling stopped
+func (o *OTLP) Stop() {
+	close(o.queue)
+	select {
+	case <-o.done:
+	case <-time.After(otlpTimeout):
+		log.Printf("timeout pushing the queued samples to %s\n", o.url)
+	}
+}
@@ -0,0 +1,364 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+)
+
+const (
+	// like prometheus, an instant selector returns the last point up to 5 minutes old
+	lookbackDelta = 5 * time.Minute
+	// bounds the range queries
+	maxRangePoints = 11000
+)
+
+// Sample is the value of a series at the evaluation time
+type Sample struct {
+	Metric map[string]string
+	Value  float64
+}
+
+// Result is a vector, or a scalar if Scalar is set
+type Result struct {
+	Scalar *float64
+	Vector []Sample
+}
+
+type Engine struct {
+	db *history.DB
+}
+
+func NewEngine(db *history.DB) *Engine {
+	return &Engine{db: db}
+}
+
+// Instant evaluates the query at t
+func (e *Engine) Instant(q string, t time.Time) (*Result, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	return e.eval(expr, toMillis(t))
+}
+
+// Range evaluates the query every step from start to end, it returns the series of the vector
+// results, or a single series without labels for a scalar query
+func (e *Engine) Range(q string, start, end time.Time, step time.Duration) ([]history.Series, error) {
+	expr, err := Parse(q)
+	if err != nil {
+		return nil, err
+	}
+	if step <= 0 || end.Before(start) {
+		return nil, fmt.Errorf("invalid range")
+	}
+	if end.Sub(start)/step > maxRangePoints {
+		return nil, fmt.Errorf("exceeded the maximum of %d points per series, increase the step", maxRangePoints)
+	}
+	series := map[string]*history.Series{}
+	for t := start; !t.After(end); t = t.Add(step) {
+		ts := toMillis(t)
+		result, err := e.eval(expr, ts)
+		if err != nil {
+			return nil, err
+		}
+		samples := result.Vector
+		if result.Scalar != nil {
+			samples = []Sample{{Metric: map[string]string{}, Value: *result.Scalar}}
+		}
+		for _, s := range samples {
+			key := seriesKey(s.Metric)
+			if _, ok := series[key]; !ok {
+				series[key] = &history.Series{Metric: s.Metric}
+			}
+			series[key].Points = append(series[key].Points, history.Point{T: ts, V: s.Value})
+		}
+	}
+	keys := make([]string, 0, len(series))
+	for key := range series {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	matrix := make([]history.Series, 0, len(keys))
+	for _, key := range keys {
+		matrix = append(matrix, *series[key])
+	}
+	return matrix, nil
+}
+
+func toMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func (e *Engine) eval(expr Expr, t int64) (*Result, error) {
+	switch expr := expr.(type) {
+	case *NumberLiteral:
+		v := expr.Value
+		return &Result{Scalar: &v}, nil
+	case *VectorSelector:
+		return &Result{Vector: e.evalSelector(expr, t)}, nil
+	case *MatrixSelector:
+		return nil, fmt.Errorf("a range selector must be the argument of a function, e.g. rate(metric[5m])")
+	case *Call:
+		return &Result{Vector: e.evalCall(expr, t)}, nil
+	case *Aggregate:
+		inner, err := e.eval(expr.Expr, t)
+		if err != nil {
+			return nil, err
+		}
+		if inner.Scalar != nil {
+			return nil, fmt.Errorf("%s expects a vector", expr.Op)
+		}
+		return &Result{Vector: aggregate(expr, inner.Vector)}, nil
+	case *Binary:
+		return e.evalBinary(expr, t)
+	}
+	return nil, fmt.Errorf("unsupported expression %T", expr)
+}
+
+func (e *Engine) selectSeries(v *VectorSelector, mint, maxt int64) []history.Series {
+	return e.db.Select(mint, maxt, func(metric map[string]string) bool {
+		for _, m := range v.Matchers {
+			if !m.Matches(metric[m.Name]) {
+				return false
+			}
+		}
+		return true
+	})
+}
+
+func (e *Engine) evalSelector(v *VectorSelector, t int64) []Sample {
+	vector := []Sample{}
+	for _, s := range e.selectSeries(v, t-int64(lookbackDelta/time.Millisecond), t) {
+		vector = append(vector, Sample{Metric: s.Metric, Value: s.Points[len(s.Points)-1].V})
+	}
+	return vector
+}
+
+func (e *Engine) evalCall(c *Call, t int64) []Sample {
+	vector := []Sample{}
+	// the range is left-open, like prometheus
+	for _, s := range e.selectSeries(c.Arg.Vector, t-int64(c.Arg.Range/time.Millisecond)+1, t) {
+		v, ok := evalRangeFunction(c.Func, s.Points)
+		if !ok {
+			continue
+		}
+		delete(s.Metric, history.NameLabel)
+		vector = append(vector, Sample{Metric: s.Metric, Value: v})
+	}
+	return vector
+}
+
+func evalRangeFunction(name string, points []history.Point) (float64, bool) {
+	switch name {
+	case "rate", "increase":
+		if len(points) < 2 {
+			return 0, false
+		}
+		// a counter decreasing was reset, e.g. by a restart without a store
+		increase := 0.0
+		for i := 1; i < len(points); i++ {
+			if points[i].V < points[i-1].V {
+				increase += points[i].V
+			} else {
+				increase += points[i].V - points[i-1].V
+			}
+		}
+		if name == "increase" {
+			return increase, true
+		}
+		seconds := float64(points[len(points)-1].T-points[0].T) / 1000
+		return increase / seconds, true
+	case "count_over_time":
+		return float64(len(points)), true
+	}
+	sum, min, max := 0.0, math.Inf(1), math.Inf(-1)
+	for _, p := range points {
+		sum += p.V
+		min = math.Min(min, p.V)
+		max = math.Max(max, p.V)
+	}
+	switch name {
+	case "avg_over_time":
+		return sum / float64(len(points)), true
+	case "min_over_time":
+		return min, true
+	case "max_over_time":
+		return max, true
+	}
+	return sum, true
+}
+
+// groupLabels returns the labels kept by a grouping, all of them without a grouping
+func groupLabels(metric map[string]string, grouping []string, without bool) map[string]string {
+	if grouping == nil && !without {
+		return metric
+	}
+	labels := map[string]string{}
+	if without {
+		for name, value := range metric {
+			labels[name] = value
+		}
+		for _, name := range grouping {
+			delete(labels, name)
+		}
+		delete(labels, history.NameLabel)
+		return labels
+	}
+	for _, name := range grouping {
+		if value, ok := metric[name]; ok {
+			labels[name] = value
+		}
+	}
+	return labels
+}
+
+func seriesKey(metric map[string]string) string {
+	names := make([]string, 0, len(metric))
+	for name := range metric {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	key := ""
+	for _, name := range names {
+		key += name + "=" + metric[name] + ","
+	}
+	return key
+}
+
+type group struct {
+	labels  map[string]string
+	samples []Sample
+}
+
+func aggregate(a *Aggregate, vector []Sample) []Sample {
+	groups := map[string]*group{}
+	keys := []string{}
+	for _, s := range vector {
+		grouping := a.Grouping
+		if grouping == nil && !a.Without {
+			// without a grouping, everything is aggregated in one group
+			grouping = []string{}
+		}
+		labels := groupLabels(s.Metric, grouping, a.Without)
+		key := seriesKey(labels)
+		g, ok := groups[key]
+		if !ok {
+			g = &group{labels: labels}
+			groups[key] = g
+			keys = append(keys, key)
+		}
+		g.samples = append(g.samples, s)
+	}
+	sort.Strings(keys)
+
+	result := []Sample{}
+	for _, key := range keys {
+		g := groups[key]
+		switch a.Op {
+		case "topk", "bottomk":
+			samples := g.samples
+			sort.SliceStable(samples, func(i, j int) bool {
+				if a.Op == "topk" {
+					return samples[i].Value > samples[j].Value
+				}
+				return samples[i].Value < samples[j].Value
+			})
+			k := int(a.Param)
+			if k > len(samples) {
+				k = len(samples)
+			}
+			if k > 0 {
+				result = append(result, samples[:k]...)
+			}
+			continue
+		}
+		sum, min, max := 0.0, math.Inf(1), math.Inf(-1)
+		for _, s := range g.samples {
+			sum += s.Value
+			min = math.Min(min, s.Value)
+			max = math.Max(max, s.Value)
+		}
+		value := sum
+		switch a.Op {
+		case "avg":
+			value = sum / float64(len(g.samples))
+		case "min":
+			value = min
+		case "max":
+			value = max
+		case "count":
+			value = float64(len(g.samples))
+		}
+		result = append(result, Sample{Metric: g.labels, Value: value})
+	}
+	return result
+}
+
+func (e *Engine) evalBinary(b *Binary, t int64) (*Result, error) {
+	lhs, err := e.eval(b.LHS, t)
+	if err != nil {
+		return nil, err
+	}
+	rhs, err := e.eval(b.RHS, t)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case lhs.Scalar != nil && rhs.Scalar != nil:
+		v := arithmetic(b.Op, *lhs.Scalar, *rhs.Scalar)
+		return &Result{Scalar: &v}, nil
+	case lhs.Scalar == nil && rhs.Scalar == nil:
+		return nil, fmt.Errorf("binary operations between two vectors are not supported")
+	}
+	result := &Result{Vector: []Sample{}}
+	vector, scalar := lhs.Vector, rhs.Scalar
+	if lhs.Scalar != nil {
+		vector, scalar = rhs.Vector, lhs.Scalar
+	}
+	for _, s := range vector {
+		v := arithmetic(b.Op, s.Value, *scalar)
+		if lhs.Scalar != nil {
+			v = arithmetic(b.Op, *scalar, s.Value)
+		}
+		metric := map[string]string{}
+		for name, value := range s.Metric {
+			if name != history.NameLabel {
+				metric[name] = value
+			}
+		}
+		result.Vector = append(result.Vector, Sample{Metric: metric, Value: v})
+	}
+	return result, nil
+}
+
+func arithmetic(op string, a, b float64) float64 {
+	switch op {
+	case "+":
+		return a + b
+	case "-":
+		return a - b
+	case "*":
+		return a * b
+	}
+	return a / b
+}
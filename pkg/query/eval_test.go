@@ -0,0 +1,201 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// newTestEngine returns an engine over 5 points, one per minute from start, loaded from a saved history
+func newTestEngine(t *testing.T, start time.Time) *Engine {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	container := func(name, namespace string, values ...float64) *history.Series {
+		s := &history.Series{Metric: map[string]string{
+			history.NameLabel:     "container_energy_joule_total",
+			"container_name":      name,
+			"container_namespace": namespace,
+		}}
+		for i, v := range values {
+			s.Points = append(s.Points, history.Point{T: toMillis(start.Add(time.Duration(i) * time.Minute)), V: v})
+		}
+		return s
+	}
+	series := []*history.Series{
+		container("a", "ns1", 0, 60, 120, 180, 240),
+		// the counter of b is reset after the second point
+		container("b", "ns1", 10, 20, 5, 15, 25),
+		container("c", "ns2", 100, 100, 100, 100, 100),
+	}
+	if err := store.Save("history", series); err != nil {
+		t.Fatalf("failed to save the history: %v", err)
+	}
+	return NewEngine(history.New(history.DefaultRetention))
+}
+
+func TestInstant(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	e := newTestEngine(t, start)
+	now := start.Add(4 * time.Minute)
+	for _, c := range []struct {
+		query string
+		// the values by the series key of their labels
+		want map[string]float64
+	}{
+		{"container_energy_joule_total", map[string]float64{
+			"__name__=container_energy_joule_total,container_name=a,container_namespace=ns1,": 240,
+			"__name__=container_energy_joule_total,container_name=b,container_namespace=ns1,": 25,
+			"__name__=container_energy_joule_total,container_name=c,container_namespace=ns2,": 100,
+		}},
+		{`container_energy_joule_total{container_name="a"}`, map[string]float64{
+			"__name__=container_energy_joule_total,container_name=a,container_namespace=ns1,": 240,
+		}},
+		{`container_energy_joule_total{container_name!="a", container_namespace="ns1"}`, map[string]float64{
+			"__name__=container_energy_joule_total,container_name=b,container_namespace=ns1,": 25,
+		}},
+		{`{container_name=~"a|c"}`, map[string]float64{
+			"__name__=container_energy_joule_total,container_name=a,container_namespace=ns1,": 240,
+			"__name__=container_energy_joule_total,container_name=c,container_namespace=ns2,": 100,
+		}},
+		{`container_energy_joule_total{container_namespace!~"ns1"}`, map[string]float64{
+			"__name__=container_energy_joule_total,container_name=c,container_namespace=ns2,": 100,
+		}},
+		{"missing_metric", map[string]float64{}},
+		{"increase(container_energy_joule_total[5m])", map[string]float64{
+			"container_name=a,container_namespace=ns1,": 240,
+			// 10 before the reset, 5 from the reset, then 10 and 10
+			"container_name=b,container_namespace=ns1,": 35,
+			"container_name=c,container_namespace=ns2,": 0,
+		}},
+		{`rate(container_energy_joule_total{container_name="b"}[5m])`, map[string]float64{
+			"container_name=b,container_namespace=ns1,": 35.0 / 240,
+		}},
+		// the range is left-open, the point 2 minutes ago is out of the range
+		{`increase(container_energy_joule_total{container_name="a"}[2m])`, map[string]float64{
+			"container_name=a,container_namespace=ns1,": 60,
+		}},
+		{`max_over_time(container_energy_joule_total{container_name="b"}[5m])`, map[string]float64{
+			"container_name=b,container_namespace=ns1,": 25,
+		}},
+		{"sum by (container_namespace) (increase(container_energy_joule_total[5m]))", map[string]float64{
+			"container_namespace=ns1,": 275,
+			"container_namespace=ns2,": 0,
+		}},
+		{"sum(container_energy_joule_total)", map[string]float64{"": 365}},
+		{"count(container_energy_joule_total) without (container_name)", map[string]float64{
+			"container_namespace=ns1,": 2,
+			"container_namespace=ns2,": 1,
+		}},
+		{"topk(1, container_energy_joule_total)", map[string]float64{
+			"__name__=container_energy_joule_total,container_name=a,container_namespace=ns1,": 240,
+		}},
+		{`container_energy_joule_total{container_name="a"} / 1000`, map[string]float64{
+			"container_name=a,container_namespace=ns1,": 0.24,
+		}},
+	} {
+		result, err := e.Instant(c.query, now)
+		if err != nil {
+			t.Errorf("Instant(%q) failed: %v", c.query, err)
+			continue
+		}
+		if result.Scalar != nil {
+			t.Errorf("Instant(%q) = scalar %v, want a vector", c.query, *result.Scalar)
+			continue
+		}
+		got := map[string]float64{}
+		for _, s := range result.Vector {
+			got[seriesKey(s.Metric)] = s.Value
+		}
+		if len(got) != len(c.want) {
+			t.Errorf("Instant(%q) = %v, want %v", c.query, got, c.want)
+			continue
+		}
+		for key, want := range c.want {
+			if v, ok := got[key]; !ok || math.Abs(v-want) > 1e-9 {
+				t.Errorf("Instant(%q) = %v, want %v", c.query, got, c.want)
+				break
+			}
+		}
+	}
+}
+
+func TestInstantScalar(t *testing.T) {
+	e := newTestEngine(t, time.Unix(1700000000, 0))
+	result, err := e.Instant("(1 + 2) * -3", time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatalf("Instant failed: %v", err)
+	}
+	if result.Scalar == nil || *result.Scalar != -9 {
+		t.Errorf("Instant = %+v, want the scalar -9", result)
+	}
+}
+
+func TestInstantErrors(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	e := newTestEngine(t, start)
+	for _, query := range []string{
+		"sum(",
+		"container_energy_joule_total[5m]",
+		"container_energy_joule_total + container_energy_joule_total",
+		"sum(1)",
+	} {
+		if result, err := e.Instant(query, start); err == nil {
+			t.Errorf("Instant(%q) = %+v, want an error", query, result)
+		}
+	}
+}
+
+func TestRange(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	e := newTestEngine(t, start)
+	matrix, err := e.Range(`increase(container_energy_joule_total{container_name="b"}[2m])`,
+		start.Add(time.Minute), start.Add(4*time.Minute), time.Minute)
+	if err != nil {
+		t.Fatalf("Range failed: %v", err)
+	}
+	if len(matrix) != 1 {
+		t.Fatalf("Range returned %d series, want 1", len(matrix))
+	}
+	// each step sees the points of the last 2 minutes, including the reset
+	want := []float64{10, 5, 10, 10}
+	if len(matrix[0].Points) != len(want) {
+		t.Fatalf("Range returned %v, want the values %v", matrix[0].Points, want)
+	}
+	for i, p := range matrix[0].Points {
+		if p.V != want[i] || p.T != toMillis(start.Add(time.Duration(i+1)*time.Minute)) {
+			t.Errorf("point %d = %+v, want %v at %v", i, p, want[i], start.Add(time.Duration(i+1)*time.Minute))
+		}
+	}
+
+	for _, c := range []struct {
+		start, end time.Time
+		step       time.Duration
+	}{
+		{start, start.Add(time.Hour), 0},
+		{start.Add(time.Hour), start, time.Minute},
+		{start, start.Add(24 * time.Hour), time.Second},
+	} {
+		if _, err := e.Range("container_energy_joule_total", c.start, c.end, c.step); err == nil {
+			t.Errorf("Range(%v, %v, %v) succeeded, want an error", c.start, c.end, c.step)
+		}
+	}
+}
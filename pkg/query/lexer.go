@@ -0,0 +1,126 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+type tokenType int
+
+const (
+	tokenEOF tokenType = iota
+	tokenIdentifier
+	tokenNumber
+	tokenString
+	tokenDuration
+	// punctuation and operators, the token value is the text
+	tokenOperator
+)
+
+type token struct {
+	typ   tokenType
+	value string
+	pos   int
+}
+
+// lex splits the query in tokens
+func lex(input string) ([]token, error) {
+	tokens := []token{}
+	inRange := false
+	for pos := 0; pos < len(input); {
+		c := rune(input[pos])
+		switch {
+		case unicode.IsSpace(c):
+			pos++
+		case inRange && unicode.IsDigit(c):
+			// durations only appear between brackets, e.g. [5m]
+			end := pos
+			for end < len(input) && (unicode.IsDigit(rune(input[end])) || strings.ContainsRune("smhdwy", rune(input[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenDuration, value: input[pos:end], pos: pos})
+			pos = end
+		case unicode.IsDigit(c) || c == '.':
+			end := pos
+			for end < len(input) && (unicode.IsDigit(rune(input[end])) || strings.ContainsRune(".eE", rune(input[end])) ||
+				(strings.ContainsRune("+-", rune(input[end])) && strings.ContainsRune("eE", rune(input[end-1])))) {
+				end++
+			}
+			if _, err := strconv.ParseFloat(input[pos:end], 64); err != nil {
+				return nil, fmt.Errorf("invalid number %q at %d", input[pos:end], pos)
+			}
+			tokens = append(tokens, token{typ: tokenNumber, value: input[pos:end], pos: pos})
+			pos = end
+		case c == '_' || c == ':' || unicode.IsLetter(c):
+			end := pos
+			for end < len(input) && (input[end] == '_' || input[end] == ':' || unicode.IsLetter(rune(input[end])) || unicode.IsDigit(rune(input[end]))) {
+				end++
+			}
+			tokens = append(tokens, token{typ: tokenIdentifier, value: input[pos:end], pos: pos})
+			pos = end
+		case c == '"' || c == '\'':
+			end := pos + 1
+			for end < len(input) && rune(input[end]) != c {
+				if input[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(input) {
+				return nil, fmt.Errorf("unterminated string at %d", pos)
+			}
+			value, err := unquote(input[pos : end+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string at %d: %v", pos, err)
+			}
+			tokens = append(tokens, token{typ: tokenString, value: value, pos: pos})
+			pos = end + 1
+		default:
+			op := string(c)
+			if pos+1 < len(input) {
+				switch input[pos : pos+2] {
+				case "!=", "=~", "!~":
+					op = input[pos : pos+2]
+				}
+			}
+			if len(op) == 1 && !strings.Contains("(){}[],=+-*/", op) {
+				return nil, fmt.Errorf("unexpected character %q at %d", c, pos)
+			}
+			switch op {
+			case "[":
+				inRange = true
+			case "]":
+				inRange = false
+			}
+			tokens = append(tokens, token{typ: tokenOperator, value: op, pos: pos})
+			pos += len(op)
+		}
+	}
+	return append(tokens, token{typ: tokenEOF, pos: len(input)}), nil
+}
+
+func unquote(s string) (string, error) {
+	if s[0] == '\'' {
+		// strconv only unquotes single characters between single quotes
+		s = "\"" + strings.ReplaceAll(strings.ReplaceAll(s[1:len(s)-1], "\\'", "'"), "\"", "\\\"") + "\""
+	}
+	return strconv.Unquote(s)
+}
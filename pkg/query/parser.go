@@ -0,0 +1,403 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+)
+
+// The supported subset of PromQL:
+//
+//	selectors         name{label="v", label!="v", label=~"re", label!~"re"}, name{...}[5m]
+//	range functions   rate, increase, avg_over_time, min_over_time, max_over_time, sum_over_time, count_over_time
+//	aggregations      sum, avg, min, max, count with by (...) or without (...), topk(k, ...), bottomk(k, ...)
+//	arithmetic        + - * / between a vector and a number, or two numbers
+//
+// The binary operators between two vectors, offset, subqueries and the other functions are not supported.
+type Expr interface{}
+
+type NumberLiteral struct {
+	Value float64
+}
+
+type Matcher struct {
+	Name   string
+	Op     string
+	Value  string
+	regexp *regexp.Regexp
+}
+
+type VectorSelector struct {
+	Matchers []*Matcher
+}
+
+type MatrixSelector struct {
+	Vector *VectorSelector
+	Range  time.Duration
+}
+
+type Call struct {
+	Func string
+	Arg  *MatrixSelector
+}
+
+type Aggregate struct {
+	Op       string
+	Param    float64
+	Expr     Expr
+	Grouping []string
+	Without  bool
+}
+
+type Binary struct {
+	Op       string
+	LHS, RHS Expr
+}
+
+var (
+	rangeFunctions = map[string]bool{
+		"rate":            true,
+		"increase":        true,
+		"avg_over_time":   true,
+		"min_over_time":   true,
+		"max_over_time":   true,
+		"sum_over_time":   true,
+		"count_over_time": true,
+	}
+	aggregations = map[string]bool{
+		"sum":     true,
+		"avg":     true,
+		"min":     true,
+		"max":     true,
+		"count":   true,
+		"topk":    true,
+		"bottomk": true,
+	}
+	durationUnits = map[byte]time.Duration{
+		's': time.Second,
+		'm': time.Minute,
+		'h': time.Hour,
+		'd': 24 * time.Hour,
+		'w': 7 * 24 * time.Hour,
+		'y': 365 * 24 * time.Hour,
+	}
+)
+
+type parser struct {
+	tokens []token
+	pos    int
+}
+
+// Parse parses a query
+func Parse(input string) (Expr, error) {
+	tokens, err := lex(input)
+	if err != nil {
+		return nil, err
+	}
+	p := &parser{tokens: tokens}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	if t := p.peek(); t.typ != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at %d", t.value, t.pos)
+	}
+	return expr, nil
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.tokens[p.pos]
+	if t.typ != tokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) expect(op string) error {
+	t := p.next()
+	if t.typ != tokenOperator || t.value != op {
+		if t.typ == tokenEOF {
+			return fmt.Errorf("expected %q at the end of the query", op)
+		}
+		return fmt.Errorf("expected %q at %d, got %q", op, t.pos, t.value)
+	}
+	return nil
+}
+
+func precedence(op string) int {
+	switch op {
+	case "+", "-":
+		return 1
+	case "*", "/":
+		return 2
+	}
+	return 0
+}
+
+// parseExpr parses the binary operations of a precedence above min
+func (p *parser) parseExpr(min int) (Expr, error) {
+	lhs, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		t := p.peek()
+		prec := precedence(t.value)
+		if t.typ != tokenOperator || prec <= min {
+			return lhs, nil
+		}
+		p.next()
+		rhs, err := p.parseExpr(prec)
+		if err != nil {
+			return nil, err
+		}
+		lhs = &Binary{Op: t.value, LHS: lhs, RHS: rhs}
+	}
+}
+
+func (p *parser) parseUnary() (Expr, error) {
+	t := p.next()
+	switch t.typ {
+	case tokenNumber:
+		v, _ := strconv.ParseFloat(t.value, 64)
+		return &NumberLiteral{Value: v}, nil
+	case tokenOperator:
+		switch t.value {
+		case "(":
+			expr, err := p.parseExpr(0)
+			if err != nil {
+				return nil, err
+			}
+			return expr, p.expect(")")
+		case "-":
+			expr, err := p.parseUnary()
+			if err != nil {
+				return nil, err
+			}
+			return &Binary{Op: "*", LHS: &NumberLiteral{Value: -1}, RHS: expr}, nil
+		case "{":
+			p.pos--
+			return p.parseSelector("")
+		}
+	case tokenIdentifier:
+		if aggregations[t.value] {
+			return p.parseAggregate(t.value)
+		}
+		if next := p.peek(); next.typ == tokenOperator && next.value == "(" {
+			return p.parseCall(t)
+		}
+		return p.parseSelector(t.value)
+	case tokenEOF:
+		return nil, fmt.Errorf("unexpected end of the query")
+	}
+	return nil, fmt.Errorf("unexpected %q at %d", t.value, t.pos)
+}
+
+func (p *parser) parseCall(name token) (Expr, error) {
+	if !rangeFunctions[name.value] {
+		return nil, fmt.Errorf("unsupported function %s at %d", name.value, name.pos)
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	arg, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	matrix, ok := arg.(*MatrixSelector)
+	if !ok {
+		return nil, fmt.Errorf("%s expects a range selector, e.g. metric[5m]", name.value)
+	}
+	return &Call{Func: name.value, Arg: matrix}, p.expect(")")
+}
+
+func (p *parser) parseAggregate(op string) (Expr, error) {
+	a := &Aggregate{Op: op}
+	// the grouping is either before or after the arguments
+	if err := p.parseGrouping(a); err != nil {
+		return nil, err
+	}
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	if op == "topk" || op == "bottomk" {
+		t := p.next()
+		if t.typ != tokenNumber {
+			return nil, fmt.Errorf("%s expects a number of series at %d", op, t.pos)
+		}
+		a.Param, _ = strconv.ParseFloat(t.value, 64)
+		if err := p.expect(","); err != nil {
+			return nil, err
+		}
+	}
+	expr, err := p.parseExpr(0)
+	if err != nil {
+		return nil, err
+	}
+	a.Expr = expr
+	if err = p.expect(")"); err != nil {
+		return nil, err
+	}
+	return a, p.parseGrouping(a)
+}
+
+func (p *parser) parseGrouping(a *Aggregate) error {
+	t := p.peek()
+	if t.typ != tokenIdentifier || (t.value != "by" && t.value != "without") {
+		return nil
+	}
+	p.next()
+	a.Without = t.value == "without"
+	if err := p.expect("("); err != nil {
+		return err
+	}
+	for {
+		t := p.next()
+		if t.typ == tokenOperator && t.value == ")" {
+			return nil
+		}
+		if t.typ != tokenIdentifier {
+			return fmt.Errorf("expected a label name at %d", t.pos)
+		}
+		a.Grouping = append(a.Grouping, t.value)
+		if next := p.peek(); next.typ == tokenOperator && next.value == "," {
+			p.next()
+		}
+	}
+}
+
+func (p *parser) parseSelector(name string) (Expr, error) {
+	v := &VectorSelector{}
+	if len(name) > 0 {
+		v.Matchers = append(v.Matchers, &Matcher{Name: history.NameLabel, Op: "=", Value: name})
+	}
+	if t := p.peek(); t.typ == tokenOperator && t.value == "{" {
+		p.next()
+		for {
+			t := p.next()
+			if t.typ == tokenOperator && t.value == "}" {
+				break
+			}
+			if t.typ != tokenIdentifier {
+				return nil, fmt.Errorf("expected a label name at %d", t.pos)
+			}
+			op := p.next()
+			if op.typ != tokenOperator {
+				return nil, fmt.Errorf("expected a label matcher at %d", op.pos)
+			}
+			value := p.next()
+			if value.typ != tokenString {
+				return nil, fmt.Errorf("expected a label value at %d", value.pos)
+			}
+			m, err := newMatcher(t.value, op.value, value.value)
+			if err != nil {
+				return nil, err
+			}
+			v.Matchers = append(v.Matchers, m)
+			if next := p.peek(); next.typ == tokenOperator && next.value == "," {
+				p.next()
+			}
+		}
+	}
+	if len(v.Matchers) == 0 {
+		return nil, fmt.Errorf("a selector needs a metric name or a label matcher")
+	}
+	if t := p.peek(); t.typ != tokenOperator || t.value != "[" {
+		return v, nil
+	}
+	p.next()
+	t := p.next()
+	if t.typ != tokenDuration {
+		return nil, fmt.Errorf("expected a duration at %d", t.pos)
+	}
+	d, err := parseDuration(t.value)
+	if err != nil {
+		return nil, err
+	}
+	return &MatrixSelector{Vector: v, Range: d}, p.expect("]")
+}
+
+func newMatcher(name, op, value string) (*Matcher, error) {
+	m := &Matcher{Name: name, Op: op, Value: value}
+	switch op {
+	case "=", "!=":
+	case "=~", "!~":
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid regexp %q: %v", value, err)
+		}
+		m.regexp = re
+	default:
+		return nil, fmt.Errorf("unknown label matcher %q", op)
+	}
+	return m, nil
+}
+
+// Matches returns whether the label value matches, a missing label has the empty value
+func (m *Matcher) Matches(value string) bool {
+	switch m.Op {
+	case "=":
+		return value == m.Value
+	case "!=":
+		return value != m.Value
+	case "=~":
+		return m.regexp.MatchString(value)
+	case "!~":
+		return !m.regexp.MatchString(value)
+	}
+	return false
+}
+
+// parseDuration parses the PromQL durations, e.g. 5m, 1h30m, 7d
+func parseDuration(s string) (time.Duration, error) {
+	var d time.Duration
+	for len(s) > 0 {
+		i := 0
+		for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+			i++
+		}
+		if i == 0 || i == len(s) {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		n, err := strconv.Atoi(s[:i])
+		if err != nil {
+			return 0, fmt.Errorf("invalid duration %q", s)
+		}
+		unit, rest := durationUnits[s[i]], s[i+1:]
+		if s[i] == 'm' && len(rest) > 0 && rest[0] == 's' {
+			unit, rest = time.Millisecond, rest[1:]
+		}
+		if unit == 0 {
+			return 0, fmt.Errorf("invalid duration unit in %q", s)
+		}
+		d += time.Duration(n) * unit
+		s = rest
+	}
+	if d <= 0 {
+		return 0, fmt.Errorf("duration must be positive")
+	}
+	return d, nil
+}
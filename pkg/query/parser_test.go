@@ -0,0 +1,114 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package query
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/history"
+)
+
+func TestParse(t *testing.T) {
+	name := func(n string) *Matcher {
+		return &Matcher{Name: history.NameLabel, Op: "=", Value: n}
+	}
+	for _, c := range []struct {
+		query string
+		expr  Expr
+	}{
+		{"1.5", &NumberLiteral{Value: 1.5}},
+		{"foo", &VectorSelector{Matchers: []*Matcher{name("foo")}}},
+		{`foo{a="x", b!='y'}`, &VectorSelector{Matchers: []*Matcher{
+			name("foo"), {Name: "a", Op: "=", Value: "x"}, {Name: "b", Op: "!=", Value: "y"},
+		}}},
+		{`{a="x"}`, &VectorSelector{Matchers: []*Matcher{{Name: "a", Op: "=", Value: "x"}}}},
+		{"foo[1h30m]", &MatrixSelector{
+			Vector: &VectorSelector{Matchers: []*Matcher{name("foo")}},
+			Range:  90 * time.Minute,
+		}},
+		{"rate(foo[5m])", &Call{Func: "rate", Arg: &MatrixSelector{
+			Vector: &VectorSelector{Matchers: []*Matcher{name("foo")}},
+			Range:  5 * time.Minute,
+		}}},
+		{"sum by (a, b) (foo)", &Aggregate{
+			Op: "sum", Expr: &VectorSelector{Matchers: []*Matcher{name("foo")}}, Grouping: []string{"a", "b"},
+		}},
+		{"sum(foo) without (a)", &Aggregate{
+			Op: "sum", Expr: &VectorSelector{Matchers: []*Matcher{name("foo")}}, Grouping: []string{"a"}, Without: true,
+		}},
+		{"topk(3, foo)", &Aggregate{Op: "topk", Param: 3, Expr: &VectorSelector{Matchers: []*Matcher{name("foo")}}}},
+		// * binds tighter than +, and the operators are left associative
+		{"1 + 2 * 3", &Binary{Op: "+", LHS: &NumberLiteral{Value: 1}, RHS: &Binary{
+			Op: "*", LHS: &NumberLiteral{Value: 2}, RHS: &NumberLiteral{Value: 3},
+		}}},
+		{"1 - 2 - 3", &Binary{Op: "-", LHS: &Binary{
+			Op: "-", LHS: &NumberLiteral{Value: 1}, RHS: &NumberLiteral{Value: 2},
+		}, RHS: &NumberLiteral{Value: 3}}},
+		{"-foo", &Binary{Op: "*", LHS: &NumberLiteral{Value: -1}, RHS: &VectorSelector{Matchers: []*Matcher{name("foo")}}}},
+	} {
+		expr, err := Parse(c.query)
+		if err != nil {
+			t.Errorf("Parse(%q) failed: %v", c.query, err)
+			continue
+		}
+		if !reflect.DeepEqual(expr, c.expr) {
+			t.Errorf("Parse(%q) = %#v, want %#v", c.query, expr, c.expr)
+		}
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	for _, query := range []string{
+		"",
+		"   ",
+		"(",
+		"foo)",
+		"foo bar",
+		"1 +",
+		"1..2",
+		"foo{",
+		"foo{a}",
+		`foo{a="x"`,
+		`foo{a=}`,
+		`foo{a=~"("}`,
+		`foo{"a"="x"}`,
+		"{}",
+		"foo[",
+		"foo[5m",
+		"foo[5x]",
+		"foo[0m]",
+		"foo[m]",
+		`"unterminated`,
+		`'x\'`,
+		"foo # comment",
+		"rate(foo)",
+		"rate(foo[5m]",
+		"absent(foo)",
+		"sum(",
+		"sum by (a",
+		"sum by (1) (foo)",
+		"topk(foo)",
+		"topk(2 foo)",
+		"rate(foo[5m]) offset 5m",
+	} {
+		if expr, err := Parse(query); err == nil {
+			t.Errorf("Parse(%q) = %#v, want an error", query, expr)
+		}
+	}
+}
@@ -0,0 +1,307 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package resources
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The enrichment joins the energy of the pods with their CPU requests and limits read from the API
+// server, so the watts per requested core and the provisioning indicators are exported directly
+// instead of being PromQL joins with kube-state-metrics, which a tiny edge prometheus cannot afford.
+// The service account needs to list the pods.
+const (
+	// OverProvisionedRatio is the CPU usage relative to the request below which a pod is over-provisioned
+	OverProvisionedRatio = 0.25
+
+	refreshPeriod = time.Minute
+	// the usage and power are smoothed over a few minutes, a single busy sample is not under-provisioning
+	smoothing = 5 * time.Minute
+
+	saTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+	saCAPath    = "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"
+	nodeNameEnv = "NODE_NAME"
+)
+
+// podResources are the CPU requests and limits in cores of a pod, the sum of its containers
+type podResources struct {
+	request, limit float64
+}
+
+// usage is the smoothed CPU usage in cores and power in W of a pod
+type usage struct {
+	name, namespace string
+	cores, watts    float64
+}
+
+type Enricher struct {
+	podsURL string
+	client  *http.Client
+
+	lock       sync.Mutex
+	resources  map[string]podResources
+	usage      map[string]*usage
+	lastSample time.Time
+}
+
+var (
+	requestDesc = prometheus.NewDesc(
+		"container_cpu_request_cores",
+		"CPU request of the container (pod) in cores, from the API server",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	limitDesc = prometheus.NewDesc(
+		"container_cpu_limit_cores",
+		"CPU limit of the container (pod) in cores, from the API server",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	wattsPerCoreDesc = prometheus.NewDesc(
+		"container_power_per_requested_core_watts",
+		"Power of the container per requested CPU core, averaged over a few minutes",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	utilizationDesc = prometheus.NewDesc(
+		"container_cpu_request_utilization_ratio",
+		"CPU usage of the container relative to its request, averaged over a few minutes",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	overProvisionedDesc = prometheus.NewDesc(
+		"container_cpu_overprovisioned",
+		"Whether the container uses less than a quarter of its CPU request",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	underProvisionedDesc = prometheus.NewDesc(
+		"container_cpu_underprovisioned",
+		"Whether the container uses more than its CPU request, or has no request",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+)
+
+// New returns an enricher listing the pods of this node from the API server
+func New() (*Enricher, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	if len(host) == 0 {
+		return nil, fmt.Errorf("not running in a kubernetes cluster")
+	}
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if len(port) == 0 {
+		port = "443"
+	}
+	node := os.Getenv(nodeNameEnv)
+	if len(node) == 0 {
+		node = collector.EdgeDeviceName
+	}
+	// the API server is verified with the service account CA
+	ca, err := ioutil.ReadFile(saCAPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %q: %v", saCAPath, err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("no certificate in %q", saCAPath)
+	}
+	params := url.Values{}
+	params.Set("fieldSelector", "spec.nodeName="+node)
+	return &Enricher{
+		podsURL: "https://" + host + ":" + port + "/api/v1/pods?" + params.Encode(),
+		client: &http.Client{
+			Timeout:   refreshPeriod / 2,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+		resources: map[string]podResources{},
+		usage:     map[string]*usage{},
+	}, nil
+}
+
+// Run refreshes the pod resources in the background
+func (e *Enricher) Run() {
+	supervisor.Go("resources", func() {
+		ticker := time.NewTicker(refreshPeriod)
+		for {
+			if err := e.refresh(); err != nil {
+				log.Printf("failed to list the pod resources: %v\n", err)
+			}
+			<-ticker.C
+		}
+	})
+}
+
+func (e *Enricher) refresh() error {
+	token, err := ioutil.ReadFile(saTokenPath)
+	if err != nil {
+		return fmt.Errorf("failed to read from %q: %v", saTokenPath, err)
+	}
+	req, err := http.NewRequest("GET", e.podsURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Add("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to get response from %q: %v", e.podsURL, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	pods := &corev1.PodList{}
+	if err = json.Unmarshal(data, pods); err != nil {
+		return fmt.Errorf("failed to parse response body: %v", err)
+	}
+	resources := map[string]podResources{}
+	for _, pod := range pods.Items {
+		r := podResources{}
+		for _, c := range pod.Spec.Containers {
+			if q, ok := c.Resources.Requests[corev1.ResourceCPU]; ok {
+				r.request += float64(q.MilliValue()) / 1000
+			}
+			if q, ok := c.Resources.Limits[corev1.ResourceCPU]; ok {
+				r.limit += float64(q.MilliValue()) / 1000
+			}
+		}
+		resources[pod.Namespace+"/"+pod.Name] = r
+	}
+	e.lock.Lock()
+	e.resources = resources
+	e.lock.Unlock()
+	return nil
+}
+
+// Update smooths the CPU usage and power of the pods, it is registered with collector.OnSample
+func (e *Enricher) Update(s *collector.Snapshot) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	seconds := s.Time.Sub(e.lastSample).Seconds()
+	first := e.lastSample.IsZero()
+	e.lastSample = s.Time
+	if first || seconds <= 0 {
+		return
+	}
+	alpha := 1 - math.Exp(-seconds/smoothing.Seconds())
+	seen := map[string]bool{}
+	for _, c := range s.Containers {
+		key := c.Namespace + "/" + c.Name
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, a := range c.Accelerators {
+			energy += a
+		}
+		/* power (W) = energy (mJ) / 1000 / time(second) */
+		watts := float64(energy) / 1000 / seconds
+		// the CPU time of a sample is in seconds
+		cores := c.CPUTime / seconds
+		u, ok := e.usage[key]
+		if !ok {
+			e.usage[key] = &usage{name: c.Name, namespace: c.Namespace, cores: cores, watts: watts}
+		} else {
+			u.cores += alpha * (cores - u.cores)
+			u.watts += alpha * (watts - u.watts)
+		}
+		seen[key] = true
+	}
+	for key := range e.usage {
+		if !seen[key] {
+			delete(e.usage, key)
+		}
+	}
+}
+
+func (e *Enricher) Describe(ch chan<- *prometheus.Desc) {
+	ch <- requestDesc
+	ch <- limitDesc
+	ch <- wattsPerCoreDesc
+	ch <- utilizationDesc
+	ch <- overProvisionedDesc
+	ch <- underProvisionedDesc
+}
+
+func (e *Enricher) Collect(ch chan<- prometheus.Metric) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	for key, u := range e.usage {
+		// the system processes and the pods listed after the last refresh have no resources
+		r, ok := e.resources[key]
+		if !ok {
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(requestDesc, prometheus.GaugeValue, r.request, u.name, u.namespace)
+		if r.limit > 0 {
+			ch <- prometheus.MustNewConstMetric(limitDesc, prometheus.GaugeValue, r.limit, u.name, u.namespace)
+		}
+		over, under := 0.0, 0.0
+		if r.request > 0 {
+			utilization := u.cores / r.request
+			ch <- prometheus.MustNewConstMetric(wattsPerCoreDesc, prometheus.GaugeValue, u.watts/r.request, u.name, u.namespace)
+			ch <- prometheus.MustNewConstMetric(utilizationDesc, prometheus.GaugeValue, utilization, u.name, u.namespace)
+			if utilization < OverProvisionedRatio {
+				over = 1
+			} else if utilization > 1 {
+				under = 1
+			}
+		} else if u.cores > 0 {
+			// a best effort pod competes for the CPU with no guarantee
+			under = 1
+		}
+		ch <- prometheus.MustNewConstMetric(overProvisionedDesc, prometheus.GaugeValue, over, u.name, u.namespace)
+		ch <- prometheus.MustNewConstMetric(underProvisionedDesc, prometheus.GaugeValue, under, u.name, u.namespace)
+	}
+}
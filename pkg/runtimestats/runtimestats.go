@@ -0,0 +1,302 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package runtimestats
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/expfmt"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The garbage collection of the managed runtimes costs CPU time, hence energy, the workload code does not
+// account for. The metrics endpoints of the workloads (the Prometheus Go collector, the JMX exporter or
+// Micrometer for the JVM) are scraped in the background, and the GC time and allocation rate over the last
+// scrape interval are attached to the container snapshots, so GC behaviour and energy are in one dataset.
+// The GC time of Go is its CPU time (the /cpu/classes/gc/total:cpu-seconds runtime metric of Go 1.20), or
+// the stop-the-world pause time of go_gc_duration_seconds when the workload does not export it.
+const (
+	Go  = "go"
+	JVM = "jvm"
+
+	DefaultInterval = 15 * time.Second
+	// the stats of a target missing that many scrapes are dropped, e.g. a deleted pod
+	staleScrapes  = 3
+	scrapeTimeout = 5 * time.Second
+)
+
+// Target is the metrics endpoint of the pod namespace/pod, the runtime is detected if empty
+type Target struct {
+	Namespace string `json:"namespace"`
+	Pod       string `json:"pod"`
+	URL       string `json:"url"`
+	Runtime   string `json:"runtime"`
+}
+
+type Config struct {
+	Targets []Target `json:"targets"`
+}
+
+// Stats are the runtime metrics of a pod over the last scrape interval
+type Stats struct {
+	Runtime string `json:"runtime"`
+	// GCTime is the GC time in s over the interval, GCTimeRatio its fraction of the interval. GCPause tells
+	// it is the pause time, not the CPU time of the GC
+	GCTime      float64 `json:"gc_time"`
+	GCPause     bool    `json:"gc_pause,omitempty"`
+	GCTimeRatio float64 `json:"gc_time_ratio"`
+	GCCount     float64 `json:"gc_count"`
+	// AllocRate is in bytes/s, HeapBytes is the heap in use at the scrape
+	AllocRate float64   `json:"alloc_rate"`
+	HeapBytes float64   `json:"heap_bytes"`
+	Scraped   time.Time `json:"scraped"`
+}
+
+// counters are the cumulative values of a scrape
+type counters struct {
+	runtime    string
+	gcSeconds  float64
+	gcPause    bool
+	gcCount    float64
+	allocBytes float64
+	heapBytes  float64
+	time       time.Time
+}
+
+var (
+	lock     sync.Mutex
+	stats    = map[string]*Stats{}
+	last     = map[string]*counters{}
+	interval = DefaultInterval
+)
+
+// LoadConfig reads the runtime metrics targets in JSON
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err = json.Unmarshal(data, config); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for _, t := range config.Targets {
+		if len(t.Namespace) == 0 || len(t.Pod) == 0 || len(t.URL) == 0 {
+			return nil, fmt.Errorf("target %+v needs a namespace, a pod and a url", t)
+		}
+		if t.Runtime != "" && t.Runtime != Go && t.Runtime != JVM {
+			return nil, fmt.Errorf("unknown runtime %q of %s/%s", t.Runtime, t.Namespace, t.Pod)
+		}
+	}
+	return config, nil
+}
+
+// Start scrapes the targets every scrapeInterval in the background
+func Start(config *Config, scrapeInterval time.Duration) {
+	if scrapeInterval > 0 {
+		interval = scrapeInterval
+	}
+	client := &http.Client{Timeout: scrapeTimeout}
+	supervisor.Go("runtimestats", func() {
+		for {
+			for _, t := range config.Targets {
+				if err := scrapeTarget(client, t, time.Now()); err != nil {
+					log.Printf("failed to scrape the runtime metrics of %s/%s: %v\n", t.Namespace, t.Pod, err)
+				}
+			}
+			time.Sleep(interval)
+		}
+	})
+}
+
+// Get returns a copy of the stats of the pod, or nil
+func Get(namespace, pod string) *Stats {
+	lock.Lock()
+	defer lock.Unlock()
+	key := namespace + "/" + pod
+	s, ok := stats[key]
+	if !ok {
+		return nil
+	}
+	if time.Since(s.Scraped) > staleScrapes*interval {
+		delete(stats, key)
+		delete(last, key)
+		return nil
+	}
+	c := *s
+	return &c
+}
+
+func scrapeTarget(client *http.Client, t Target, now time.Time) error {
+	resp, err := client.Get(t.URL)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("status %s", resp.Status)
+	}
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(resp.Body)
+	if err != nil {
+		return err
+	}
+	c, err := extract(families, t.Runtime)
+	if err != nil {
+		return err
+	}
+	c.time = now
+	update(t.Namespace+"/"+t.Pod, c)
+	return nil
+}
+
+// update sets the stats from the difference with the previous scrape, a restarted workload
+// resets its counters and has stats again from the next scrape
+func update(key string, c *counters) {
+	lock.Lock()
+	defer lock.Unlock()
+	prev, ok := last[key]
+	last[key] = c
+	if !ok || prev.runtime != c.runtime || prev.gcPause != c.gcPause || c.gcSeconds < prev.gcSeconds || c.gcCount < prev.gcCount || c.allocBytes < prev.allocBytes {
+		return
+	}
+	seconds := c.time.Sub(prev.time).Seconds()
+	if seconds <= 0 {
+		return
+	}
+	gcTime := c.gcSeconds - prev.gcSeconds
+	stats[key] = &Stats{
+		Runtime:     c.runtime,
+		GCTime:      gcTime,
+		GCPause:     c.gcPause,
+		GCTimeRatio: gcTime / seconds,
+		GCCount:     c.gcCount - prev.gcCount,
+		AllocRate:   (c.allocBytes - prev.allocBytes) / seconds,
+		HeapBytes:   c.heapBytes,
+		Scraped:     c.time,
+	}
+}
+
+// extract reads the GC and allocation counters of the Prometheus Go collector, or of the JMX exporter
+// (client_java hotspot) or Micrometer for the JVM
+func extract(families map[string]*dto.MetricFamily, runtime string) (*counters, error) {
+	if runtime == "" {
+		_, cpu := families["go_cpu_classes_gc_total_cpu_seconds_total"]
+		if _, ok := families["go_gc_duration_seconds"]; ok || cpu {
+			runtime = Go
+		} else {
+			runtime = JVM
+		}
+	}
+	c := &counters{runtime: runtime}
+	var gc, alloc bool
+	switch runtime {
+	case Go:
+		_, c.gcCount, gc = sumDurations(families["go_gc_duration_seconds"])
+		if cpuSeconds, ok := sumValues(families["go_cpu_classes_gc_total_cpu_seconds_total"], nil); ok {
+			c.gcSeconds, gc = cpuSeconds, true
+		} else {
+			c.gcSeconds, _, _ = sumDurations(families["go_gc_duration_seconds"])
+			c.gcPause = true
+		}
+		if c.allocBytes, alloc = sumValues(families["go_memstats_alloc_bytes_total"], nil); !alloc {
+			c.allocBytes, alloc = sumValues(families["go_gc_heap_allocs_bytes_total"], nil)
+		}
+		c.heapBytes, _ = sumValues(families["go_memstats_heap_alloc_bytes"], nil)
+	case JVM:
+		heap := map[string]string{"area": "heap"}
+		if c.gcSeconds, c.gcCount, gc = sumDurations(families["jvm_gc_collection_seconds"]); !gc {
+			c.gcSeconds, c.gcCount, gc = sumDurations(families["jvm_gc_pause_seconds"])
+		}
+		if c.allocBytes, alloc = sumValues(families["jvm_memory_pool_allocated_bytes_total"], nil); !alloc {
+			c.allocBytes, alloc = sumValues(families["jvm_gc_memory_allocated_bytes_total"], nil)
+		}
+		if c.heapBytes, _ = sumValues(families["jvm_memory_bytes_used"], heap); c.heapBytes == 0 {
+			c.heapBytes, _ = sumValues(families["jvm_memory_used_bytes"], heap)
+		}
+	}
+	if !gc && !alloc {
+		return nil, fmt.Errorf("no %s GC or allocation metric", runtime)
+	}
+	return c, nil
+}
+
+// sumDurations sums the time and the count of the series of a summary or histogram
+func sumDurations(f *dto.MetricFamily) (sum, count float64, ok bool) {
+	if f == nil {
+		return 0, 0, false
+	}
+	for _, m := range f.Metric {
+		switch f.GetType() {
+		case dto.MetricType_SUMMARY:
+			sum += m.GetSummary().GetSampleSum()
+			count += float64(m.GetSummary().GetSampleCount())
+		case dto.MetricType_HISTOGRAM:
+			sum += m.GetHistogram().GetSampleSum()
+			count += float64(m.GetHistogram().GetSampleCount())
+		default:
+			continue
+		}
+		ok = true
+	}
+	return sum, count, ok
+}
+
+// sumValues sums the counter, gauge or untyped series having the labels
+func sumValues(f *dto.MetricFamily, labels map[string]string) (sum float64, ok bool) {
+	if f == nil {
+		return 0, false
+	}
+	for _, m := range f.Metric {
+		if !hasLabels(m, labels) {
+			continue
+		}
+		switch f.GetType() {
+		case dto.MetricType_COUNTER:
+			sum += m.GetCounter().GetValue()
+		case dto.MetricType_GAUGE:
+			sum += m.GetGauge().GetValue()
+		case dto.MetricType_UNTYPED:
+			sum += m.GetUntyped().GetValue()
+		default:
+			continue
+		}
+		ok = true
+	}
+	return sum, ok
+}
+
+func hasLabels(m *dto.Metric, labels map[string]string) bool {
+	found := 0
+	for _, l := range m.GetLabel() {
+		if v, ok := labels[l.GetName()]; ok {
+			if v != l.GetValue() {
+				return false
+			}
+			found++
+		}
+	}
+	return found == len(labels)
+}
@@ -0,0 +1,203 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package script
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"go.starlark.net/starlark"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// A sample hook is a Starlark script defining on_sample(snapshot), called after each sample with a
+// read-only copy of the snapshot (the JSON fields of collector.Snapshot as dicts and lists). It returns
+// a dict of derived values, exported as kepler_script_metric{name=...}, or None. e.g.
+//
+//	def on_sample(snapshot):
+//	    ml = [c for c in snapshot["containers"] if c["namespace"] == "ml"]
+//	    return {"ml_core_mj": sum([c["energy_in_core"] for c in ml])}
+const (
+	hookFunction = "on_sample"
+	// bounds the execution of a hook, a script looping forever must not stall the collector
+	maxExecutionSteps = 1000000
+)
+
+type Hook struct {
+	path string
+	fn   starlark.Value
+
+	lock    sync.Mutex
+	metrics map[string]float64
+}
+
+var desc = prometheus.NewDesc(
+	"kepler_script_metric",
+	"Value derived by the sample hook script.",
+	[]string{
+		"name",
+	},
+	nil,
+)
+
+// Load runs the script once to define its functions
+func Load(path string) (*Hook, error) {
+	thread := newThread(path)
+	globals, err := starlark.ExecFile(thread, path, nil, starlark.StringDict{
+		"log": starlark.NewBuiltin("log", logBuiltin),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %v", path, err)
+	}
+	fn, ok := globals[hookFunction]
+	if !ok {
+		return nil, fmt.Errorf("%s does not define %s(snapshot)", path, hookFunction)
+	}
+	if _, ok := fn.(starlark.Callable); !ok {
+		return nil, fmt.Errorf("%s in %s is not a function", hookFunction, path)
+	}
+	return &Hook{path: path, fn: fn, metrics: map[string]float64{}}, nil
+}
+
+func newThread(path string) *starlark.Thread {
+	thread := &starlark.Thread{
+		Name: path,
+		Print: func(_ *starlark.Thread, msg string) {
+			log.Printf("%s: %s\n", path, msg)
+		},
+	}
+	thread.SetMaxExecutionSteps(maxExecutionSteps)
+	return thread
+}
+
+func logBuiltin(thread *starlark.Thread, b *starlark.Builtin, args starlark.Tuple, kwargs []starlark.Tuple) (starlark.Value, error) {
+	var msg string
+	if err := starlark.UnpackPositionalArgs(b.Name(), args, kwargs, 1, &msg); err != nil {
+		return nil, err
+	}
+	log.Printf("%s: %s\n", thread.Name, msg)
+	return starlark.None, nil
+}
+
+// Run calls on_sample with the snapshot, it is registered with collector.OnSample
+func (h *Hook) Run(s *collector.Snapshot) {
+	arg, err := toStarlark(s)
+	if err != nil {
+		log.Printf("failed to convert the snapshot for %s: %v\n", h.path, err)
+		return
+	}
+	result, err := starlark.Call(newThread(h.path), h.fn, starlark.Tuple{arg}, nil)
+	if err != nil {
+		log.Printf("failed to run %s: %v\n", h.path, err)
+		return
+	}
+	metrics := map[string]float64{}
+	if result != starlark.None {
+		dict, ok := result.(*starlark.Dict)
+		if !ok {
+			log.Printf("%s in %s must return a dict, got %s\n", hookFunction, h.path, result.Type())
+			return
+		}
+		for _, item := range dict.Items() {
+			name, ok := starlark.AsString(item[0])
+			if !ok {
+				log.Printf("%s: metric name %s is not a string\n", h.path, item[0])
+				continue
+			}
+			value, ok := starlark.AsFloat(item[1])
+			if !ok {
+				log.Printf("%s: metric %s value %s is not a number\n", h.path, name, item[1])
+				continue
+			}
+			metrics[name] = value
+		}
+	}
+	h.lock.Lock()
+	h.metrics = metrics
+	h.lock.Unlock()
+}
+
+// toStarlark converts the snapshot through its JSON form, so the script sees the documented field names
+func toStarlark(s *collector.Snapshot) (starlark.Value, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	var v interface{}
+	if err = json.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	value, err := toValue(v)
+	if err != nil {
+		return nil, err
+	}
+	// the snapshot is read-only
+	value.Freeze()
+	return value, nil
+}
+
+func toValue(v interface{}) (starlark.Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return starlark.None, nil
+	case bool:
+		return starlark.Bool(v), nil
+	case float64:
+		return starlark.Float(v), nil
+	case string:
+		return starlark.String(v), nil
+	case []interface{}:
+		elems := make([]starlark.Value, 0, len(v))
+		for _, e := range v {
+			value, err := toValue(e)
+			if err != nil {
+				return nil, err
+			}
+			elems = append(elems, value)
+		}
+		return starlark.NewList(elems), nil
+	case map[string]interface{}:
+		dict := starlark.NewDict(len(v))
+		for k, e := range v {
+			value, err := toValue(e)
+			if err != nil {
+				return nil, err
+			}
+			if err = dict.SetKey(starlark.String(k), value); err != nil {
+				return nil, err
+			}
+		}
+		return dict, nil
+	}
+	return nil, fmt.Errorf("unsupported type %T", v)
+}
+
+func (h *Hook) Describe(ch chan<- *prometheus.Desc) {
+	ch <- desc
+}
+
+func (h *Hook) Collect(ch chan<- prometheus.Metric) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	for name, value := range h.metrics {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, value, name)
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snmp
+
+import (
+	"fmt"
+	"log"
+	"net"
+
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+
+	"github.com/gosnmp/gosnmp"
+)
+
+// The agent answers the SNMPv1 and v2c get, get-next and get-bulk requests of the community, the
+// objects are read-only.
+const (
+	maxPacketSize = 65535
+	// bounds the get-bulk responses
+	maxBulkVariables = 64
+	// gosnmp decodes max-repetitions as 0, the net-snmp tools default is used instead
+	defaultMaxRepetitions = 10
+)
+
+// ListenAndServe answers the SNMP requests on the UDP address (e.g. ":161") in the background
+func (a *Agent) ListenAndServe(address string) error {
+	conn, err := net.ListenPacket("udp", address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+	supervisor.Go("snmp", func() {
+		buf := make([]byte, maxPacketSize)
+		for {
+			n, addr, err := conn.ReadFrom(buf)
+			if err != nil {
+				log.Printf("failed to read snmp request: %v\n", err)
+				return
+			}
+			response, err := a.handle(buf[:n])
+			if err != nil {
+				log.Printf("invalid snmp request from %s: %v\n", addr, err)
+				continue
+			}
+			if response == nil {
+				continue
+			}
+			if _, err = conn.WriteTo(response, addr); err != nil {
+				log.Printf("failed to send snmp response to %s: %v\n", addr, err)
+			}
+		}
+	})
+	return nil
+}
+
+// handle returns the encoded response of an encoded request, nil if the request must be dropped
+func (a *Agent) handle(request []byte) ([]byte, error) {
+	decoder := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	packet, err := decoder.SnmpDecodePacket(request)
+	if err != nil {
+		return nil, err
+	}
+	if packet.Version == gosnmp.Version3 {
+		return nil, fmt.Errorf("SNMPv3 is not supported")
+	}
+	if packet.Community != a.community {
+		// like the other agents, a wrong community is silently dropped
+		return nil, nil
+	}
+	response := &gosnmp.SnmpPacket{
+		Version:   packet.Version,
+		Community: packet.Community,
+		PDUType:   gosnmp.GetResponse,
+		RequestID: packet.RequestID,
+	}
+	switch packet.PDUType {
+	case gosnmp.GetRequest:
+		for i, v := range packet.Variables {
+			pdu, ok := a.get(v.Name)
+			if !ok {
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+				if a.setV1Error(response, packet, i) {
+					break
+				}
+			}
+			response.Variables = append(response.Variables, pdu)
+		}
+	case gosnmp.GetNextRequest:
+		for i, v := range packet.Variables {
+			pdu, ok := a.next(v.Name)
+			if !ok {
+				pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.EndOfMibView}
+				if a.setV1Error(response, packet, i) {
+					break
+				}
+			}
+			response.Variables = append(response.Variables, pdu)
+		}
+	case gosnmp.GetBulkRequest:
+		response.Variables = a.bulk(packet)
+	default:
+		response.Error = gosnmp.ReadOnly
+		response.Variables = packet.Variables
+	}
+	return response.MarshalMsg()
+}
+
+// setV1Error sets the noSuchName error of SNMPv1, which has no exception values, and returns true if set
+func (a *Agent) setV1Error(response, request *gosnmp.SnmpPacket, index int) bool {
+	if request.Version != gosnmp.Version1 {
+		return false
+	}
+	response.Error = gosnmp.NoSuchName
+	response.ErrorIndex = uint8(index + 1)
+	response.Variables = request.Variables
+	return true
+}
+
+// bulk walks the first NonRepeaters variables once, the others MaxRepetitions times
+func (a *Agent) bulk(request *gosnmp.SnmpPacket) []gosnmp.SnmpPDU {
+	var variables []gosnmp.SnmpPDU
+	nonRepeaters := int(request.NonRepeaters)
+	if nonRepeaters > len(request.Variables) {
+		nonRepeaters = len(request.Variables)
+	}
+	for _, v := range request.Variables[:nonRepeaters] {
+		pdu, ok := a.next(v.Name)
+		if !ok {
+			pdu = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.EndOfMibView}
+		}
+		variables = append(variables, pdu)
+	}
+	repeaters := request.Variables[nonRepeaters:]
+	last := make([]string, len(repeaters))
+	for i, v := range repeaters {
+		last[i] = v.Name
+	}
+	maxRepetitions := request.MaxRepetitions
+	if maxRepetitions == 0 {
+		maxRepetitions = defaultMaxRepetitions
+	}
+	for r := uint32(0); r < maxRepetitions && len(repeaters) > 0; r++ {
+		for i := range repeaters {
+			if len(variables) >= maxBulkVariables {
+				return variables
+			}
+			pdu, ok := a.next(last[i])
+			if !ok {
+				pdu = gosnmp.SnmpPDU{Name: last[i], Type: gosnmp.EndOfMibView}
+			}
+			variables = append(variables, pdu)
+			last[i] = pdu.Name
+		}
+	}
+	return variables
+}
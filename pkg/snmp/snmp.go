@@ -0,0 +1,177 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package snmp
+
+import (
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+
+	"github.com/sustainable-computing-io/kepler/pkg/ambient"
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/power/rapl/source"
+)
+
+// Sites whose NMS only speaks SNMP read the node power from the embedded agent. The objects are the
+// scalars of KEPLER-EDGE-MIB (data/snmp/KEPLER-EDGE-MIB.txt), under the experimental arc, updated after
+// each sample.
+const (
+	keplerNodeOID = ".1.3.6.1.3.2022.1"
+
+	nodeName               = 1
+	nodePower              = 2
+	nodeEnergy             = 3
+	nodeCorePower          = 4
+	nodeDramPower          = 5
+	nodeGPUPower           = 6
+	nodeOtherPower         = 7
+	nodeTemperature        = 8
+	nodeAmbientTemperature = 9
+)
+
+type Agent struct {
+	community string
+
+	lock       sync.Mutex
+	values     map[string]gosnmp.SnmpPDU
+	oids       []string
+	lastSample time.Time
+	energy     float64
+}
+
+func New(community string) *Agent {
+	return &Agent{community: community, values: map[string]gosnmp.SnmpPDU{}}
+}
+
+// scalarOID returns the OID of the instance of a keplerNode scalar
+func scalarOID(object int) string {
+	return keplerNodeOID + "." + strconv.Itoa(object) + ".0"
+}
+
+// Update refreshes the MIB with a sample, it is registered with collector.OnSample
+func (a *Agent) Update(snapshot *collector.Snapshot) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	node := snapshot.EdgeDevice
+	values := map[string]gosnmp.SnmpPDU{}
+	set := func(object int, typ gosnmp.Asn1BER, value interface{}) {
+		oid := scalarOID(object)
+		values[oid] = gosnmp.SnmpPDU{Name: oid, Type: typ, Value: value}
+	}
+	set(nodeName, gosnmp.OctetString, node.Name)
+
+	/* energy (J) = energy (mJ) / 1000 */
+	a.energy += (node.EnergyInCore + node.EnergyInDram + node.EnergyInGPU + node.EnergyInOther) / 1000
+	set(nodeEnergy, gosnmp.Counter64, uint64(a.energy))
+	if !a.lastSample.IsZero() {
+		if seconds := snapshot.Time.Sub(a.lastSample).Seconds(); seconds > 0 {
+			/* power (mW) = energy (mJ) / time(second) */
+			set(nodePower, gosnmp.Gauge32, toGauge((node.EnergyInCore+node.EnergyInDram+node.EnergyInGPU+node.EnergyInOther)/seconds))
+			set(nodeCorePower, gosnmp.Gauge32, toGauge(node.EnergyInCore/seconds))
+			set(nodeDramPower, gosnmp.Gauge32, toGauge(node.EnergyInDram/seconds))
+			set(nodeGPUPower, gosnmp.Gauge32, toGauge(node.EnergyInGPU/seconds))
+			set(nodeOtherPower, gosnmp.Gauge32, toGauge(node.EnergyInOther/seconds))
+		}
+	}
+	a.lastSample = snapshot.Time
+
+	// temperatures in tenths of °C
+	if t, ok := source.GetSoCTemperature(); ok {
+		set(nodeTemperature, gosnmp.Integer, int(math.Round(t*10)))
+	}
+	if t, ok := ambientTemperature(node.Ambient); ok {
+		set(nodeAmbientTemperature, gosnmp.Integer, int(math.Round(t*10)))
+	}
+
+	oids := make([]string, 0, len(values))
+	for oid := range values {
+		oids = append(oids, oid)
+	}
+	sort.Slice(oids, func(i, j int) bool {
+		return compareOID(oids[i], oids[j]) < 0
+	})
+	a.values = values
+	a.oids = oids
+}
+
+// ambientTemperature returns the temperature of the first sensor by name
+func ambientTemperature(readings map[string]map[string]float64) (float64, bool) {
+	sensors := make([]string, 0, len(readings))
+	for sensor, values := range readings {
+		if _, ok := values[ambient.Temperature]; ok {
+			sensors = append(sensors, sensor)
+		}
+	}
+	if len(sensors) == 0 {
+		return 0, false
+	}
+	sort.Strings(sensors)
+	return readings[sensors[0]][ambient.Temperature], true
+}
+
+func toGauge(value float64) uint {
+	if value <= 0 {
+		return 0
+	}
+	if value > math.MaxUint32 {
+		return math.MaxUint32
+	}
+	return uint(value)
+}
+
+// get returns the value of an OID
+func (a *Agent) get(oid string) (gosnmp.SnmpPDU, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	pdu, ok := a.values[oid]
+	return pdu, ok
+}
+
+// next returns the value of the first OID after oid in lexicographic order
+func (a *Agent) next(oid string) (gosnmp.SnmpPDU, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	i := sort.Search(len(a.oids), func(i int) bool {
+		return compareOID(a.oids[i], oid) > 0
+	})
+	if i == len(a.oids) {
+		return gosnmp.SnmpPDU{}, false
+	}
+	return a.values[a.oids[i]], true
+}
+
+// compareOID compares the dotted OIDs a and b component by component
+func compareOID(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "."), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "."), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		x, _ := strconv.ParseUint(as[i], 10, 32)
+		y, _ := strconv.ParseUint(bs[i], 10, 32)
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return len(as) - len(bs)
+}
@@ -0,0 +1,323 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+// Edge devices lose the link to the central collector for hours. The spool persists every sample on the
+// local disk, in segments of JSON lines named after the time of their first sample, bounded in size and
+// age, the oldest segments being dropped first. Each consumer (the MQTT publisher, the OTLP exporter)
+// replays the samples from its cursor, kept in the store, and advances it once a sample is sent, so the
+// samples of the disconnection are backfilled in order once the collector is reachable again.
+const (
+	DefaultMaxBytes  = 64 << 20
+	DefaultRetention = 7 * 24 * time.Hour
+
+	segmentBytes  = 1 << 20
+	segmentSuffix = ".jsonl"
+	cursorKey     = "spool_cursor_"
+	// retryPeriod is the wait before replaying again after a failed send
+	retryPeriod = 30 * time.Second
+	// the cursors are saved at most every cursorSavePeriod, sparing the flash of the device, the samples
+	// sent since are sent again after a restart
+	cursorSavePeriod = time.Minute
+)
+
+// Sender sends a sample, an error stops the replay until the next attempt
+type Sender func(*collector.Snapshot) error
+
+type Spool struct {
+	lock      sync.Mutex
+	dir       string
+	maxBytes  int64
+	retention time.Duration
+	// current is the segment appended to, its size in bytes
+	current     *os.File
+	currentSize int64
+	// appended wakes up the replays
+	appended []chan struct{}
+	// cursors are the positions of the consumers, saved in the store
+	cursors     map[string]cursor
+	cursorSaved map[string]time.Time
+}
+
+// cursor is the position of a consumer, the offset of the next sample in a segment
+type cursor struct {
+	Segment string `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+// New opens the spool in dir, keeping up to maxBytes and retention of samples
+func New(dir string, maxBytes int64, retention time.Duration) (*Spool, error) {
+	if maxBytes < segmentBytes {
+		return nil, fmt.Errorf("spool size %d below the segment size %d", maxBytes, segmentBytes)
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create the spool %s: %v", dir, err)
+	}
+	return &Spool{dir: dir, maxBytes: maxBytes, retention: retention, cursors: map[string]cursor{}, cursorSaved: map[string]time.Time{}}, nil
+}
+
+// segments returns the segment names in time order
+func (s *Spool) segments() []string {
+	files, _ := ioutil.ReadDir(s.dir)
+	names := []string{}
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), segmentSuffix) {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Append persists a sample, it is registered with collector.OnSample
+func (s *Spool) Append(snapshot *collector.Snapshot) {
+	line, err := json.Marshal(snapshot)
+	if err != nil {
+		log.Printf("failed to encode the sample: %v\n", err)
+		return
+	}
+	line = append(line, '\n')
+	s.lock.Lock()
+	if s.current == nil || s.currentSize+int64(len(line)) > segmentBytes {
+		if err = s.rotate(snapshot.Time); err != nil {
+			s.lock.Unlock()
+			log.Printf("failed to rotate the spool: %v\n", err)
+			return
+		}
+	}
+	// a single write per sample, a replay reading the segment sees whole lines or a partial last one
+	n, err := s.current.Write(line)
+	s.currentSize += int64(n)
+	appended := s.appended
+	s.lock.Unlock()
+	if err != nil {
+		log.Printf("failed to write the spool: %v\n", err)
+		return
+	}
+	for _, ch := range appended {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// rotate starts a new segment and drops the segments over the limits, the lock must be held
+func (s *Spool) rotate(t time.Time) error {
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	name := fmt.Sprintf("%019d%s", t.UnixNano(), segmentSuffix)
+	f, err := os.OpenFile(filepath.Join(s.dir, name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.current, s.currentSize = f, info.Size()
+	s.trim(t)
+	return nil
+}
+
+// trim drops the oldest segments over the size or older than the retention, never the current one
+func (s *Spool) trim(now time.Time) {
+	segments := s.segments()
+	sizes := make([]int64, len(segments))
+	total := int64(0)
+	for i, name := range segments {
+		if info, err := os.Stat(filepath.Join(s.dir, name)); err == nil {
+			sizes[i] = info.Size()
+			total += sizes[i]
+		}
+	}
+	for i, name := range segments[:len(segments)-1] {
+		// a segment ends where the next one starts
+		end, ok := segmentTime(segments[i+1])
+		if total <= s.maxBytes && (!ok || now.Sub(end) <= s.retention) {
+			break
+		}
+		if err := os.Remove(filepath.Join(s.dir, name)); err != nil {
+			log.Printf("failed to remove the spool segment %s: %v\n", name, err)
+			break
+		}
+		total -= sizes[i]
+	}
+}
+
+// segmentTime returns the time of the first sample of a segment
+func segmentTime(name string) (time.Time, bool) {
+	var nanos int64
+	if _, err := fmt.Sscanf(strings.TrimSuffix(name, segmentSuffix), "%d", &nanos); err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(0, nanos), true
+}
+
+// Run replays the samples to a consumer from its cursor on every new sample, or every retryPeriod while the
+// sends fail, until the context is done
+func (s *Spool) Run(ctx context.Context, name string, send Sender) {
+	wake := make(chan struct{}, 1)
+	s.lock.Lock()
+	s.appended = append(s.appended, wake)
+	s.lock.Unlock()
+	retry := time.NewTicker(retryPeriod)
+	defer retry.Stop()
+	failing := false
+	for {
+		sent, err := s.Replay(name, send)
+		switch {
+		case err != nil && !failing:
+			log.Printf("failed to send the samples to %s, spooling them: %v\n", name, err)
+		case err == nil && failing:
+			log.Printf("sending the samples to %s again\n", name)
+		}
+		if failing && sent > 1 {
+			log.Printf("backfilled %d samples to %s\n", sent, name)
+		}
+		failing = err != nil
+		if failing {
+			// the new samples are spooled until the next retry
+			select {
+			case <-ctx.Done():
+				return
+			case <-retry.C:
+			}
+			continue
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-wake:
+		case <-retry.C:
+		}
+	}
+}
+
+// Replay sends the samples after the cursor of the consumer up to the first failure, it returns the number sent
+func (s *Spool) Replay(name string, send Sender) (int, error) {
+	s.lock.Lock()
+	c, ok := s.cursors[name]
+	s.lock.Unlock()
+	if !ok {
+		if _, err := store.Load(cursorKey+name, &c); err != nil {
+			log.Printf("failed to load the spool cursor of %s: %v\n", name, err)
+		}
+	}
+	sent := 0
+	defer func() {
+		s.lock.Lock()
+		defer s.lock.Unlock()
+		s.cursors[name] = c
+		if sent == 0 || time.Since(s.cursorSaved[name]) < cursorSavePeriod {
+			return
+		}
+		s.cursorSaved[name] = time.Now()
+		if err := store.Save(cursorKey+name, c); err != nil {
+			log.Printf("failed to save the spool cursor of %s: %v\n", name, err)
+		}
+	}()
+	segments := s.segments()
+	if len(c.Segment) > 0 && len(segments) > 0 && c.Segment < segments[0] {
+		log.Printf("the spooled samples of %s from %s were dropped, replaying from %s\n", name, c.Segment, segments[0])
+	}
+	for _, segment := range segments {
+		if segment < c.Segment {
+			continue
+		}
+		if segment > c.Segment {
+			c = cursor{Segment: segment}
+		}
+		n, offset, err := replaySegment(filepath.Join(s.dir, segment), c.Offset, send)
+		sent += n
+		c.Offset = offset
+		if err != nil {
+			return sent, err
+		}
+	}
+	return sent, nil
+}
+
+// replaySegment sends the whole lines of a segment from offset, it returns the number sent and the next offset
+func replaySegment(path string, offset int64, send Sender) (int, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, offset, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return 0, offset, err
+	}
+	reader := bufio.NewReader(f)
+	sent := 0
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// the partial last line of the segment being written is read again next time
+			return sent, offset, nil
+		}
+		if err != nil {
+			return sent, offset, err
+		}
+		snapshot := &collector.Snapshot{}
+		if err = json.Unmarshal(line, snapshot); err != nil {
+			log.Printf("skipping a corrupted sample of %s: %v\n", path, err)
+		} else if err = send(snapshot); err != nil {
+			return sent, offset, err
+		} else {
+			sent++
+		}
+		offset += int64(len(line))
+	}
+}
+
+// Close closes the current segment and saves the cursors
+func (s *Spool) Close() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.current != nil {
+		s.current.Close()
+		s.current = nil
+	}
+	for name, c := range s.cursors {
+		if err := store.Save(cursorKey+name, c); err != nil {
+			log.Printf("failed to save the spool cursor of %s: %v\n", name, err)
+		}
+	}
+}
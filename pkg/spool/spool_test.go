@@ -0,0 +1,235 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package spool
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/store"
+)
+
+var start = time.Unix(1700000000, 0)
+
+func newTestSpool(t *testing.T, dir string) *Spool {
+	s, err := New(dir, DefaultMaxBytes, DefaultRetention)
+	if err != nil {
+		t.Fatalf("failed to open the spool: %v", err)
+	}
+	return s
+}
+
+func appendSamples(s *Spool, from, to uint64) {
+	for seq := from; seq <= to; seq++ {
+		s.Append(&collector.Snapshot{Time: start.Add(time.Duration(seq) * time.Second), Sequence: seq})
+	}
+}
+
+// replay returns the sequences of the samples replayed to the consumer
+func replay(t *testing.T, s *Spool, name string) []uint64 {
+	sequences := []uint64{}
+	if _, err := s.Replay(name, func(snapshot *collector.Snapshot) error {
+		sequences = append(sequences, snapshot.Sequence)
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to replay the spool: %v", err)
+	}
+	return sequences
+}
+
+func TestReplayAfterRestart(t *testing.T) {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	dir := t.TempDir()
+
+	s := newTestSpool(t, dir)
+	appendSamples(s, 1, 3)
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{1, 2, 3}) {
+		t.Errorf("replayed %v, want [1 2 3]", got)
+	}
+	appendSamples(s, 4, 4)
+	s.Close()
+
+	// the sample appended after the last replay is sent after the restart, the sent ones are not
+	s = newTestSpool(t, dir)
+	defer s.Close()
+	appendSamples(s, 5, 6)
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{4, 5, 6}) {
+		t.Errorf("replayed %v after the restart, want [4 5 6]", got)
+	}
+	if got := replay(t, s, "test"); len(got) != 0 {
+		t.Errorf("replayed %v again, want nothing", got)
+	}
+	// a new consumer starts from the oldest sample
+	if got := replay(t, s, "other"); !reflect.DeepEqual(got, []uint64{1, 2, 3, 4, 5, 6}) {
+		t.Errorf("replayed %v to a new consumer, want [1 2 3 4 5 6]", got)
+	}
+}
+
+func TestReplayTornWrite(t *testing.T) {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	dir := t.TempDir()
+
+	s := newTestSpool(t, dir)
+	appendSamples(s, 1, 2)
+	s.Close()
+	segments := s.segments()
+	if len(segments) != 1 {
+		t.Fatalf("spooled %d segments, want 1", len(segments))
+	}
+	// a power loss during a write leaves a partial last line
+	f, err := os.OpenFile(filepath.Join(dir, segments[0]), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the segment: %v", err)
+	}
+	if _, err = f.WriteString(`{"time":"2023-11-14T22:13:23Z","seq`); err != nil {
+		t.Fatalf("failed to write the segment: %v", err)
+	}
+	f.Close()
+
+	// the partial line is skipped, the samples after the restart are in a new segment
+	s = newTestSpool(t, dir)
+	defer s.Close()
+	appendSamples(s, 3, 4)
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{1, 2, 3, 4}) {
+		t.Errorf("replayed %v, want [1 2 3 4]", got)
+	}
+}
+
+func TestReplayPartialLine(t *testing.T) {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	dir := t.TempDir()
+	s := newTestSpool(t, dir)
+	defer s.Close()
+	appendSamples(s, 1, 1)
+
+	// a replay reading the current segment during a write sees a partial line, and a corrupted line
+	path := filepath.Join(dir, s.segments()[0])
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("failed to open the segment: %v", err)
+	}
+	defer f.Close()
+	if _, err = f.WriteString("{not json}\n{\"sequence\":"); err != nil {
+		t.Fatalf("failed to write the segment: %v", err)
+	}
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{1}) {
+		t.Errorf("replayed %v, want [1]", got)
+	}
+	// the line is read again once complete
+	if _, err = f.WriteString("2}\n"); err != nil {
+		t.Fatalf("failed to write the segment: %v", err)
+	}
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{2}) {
+		t.Errorf("replayed %v, want [2]", got)
+	}
+}
+
+func TestReplayFailure(t *testing.T) {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	s := newTestSpool(t, t.TempDir())
+	defer s.Close()
+	appendSamples(s, 1, 3)
+
+	// the replay stops at the failed sample and sends it again next time
+	sent, err := s.Replay("test", func(snapshot *collector.Snapshot) error {
+		if snapshot.Sequence == 2 {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+	if sent != 1 || err == nil {
+		t.Errorf("Replay = %d, %v, want 1 sample sent and an error", sent, err)
+	}
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, []uint64{2, 3}) {
+		t.Errorf("replayed %v, want [2 3]", got)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	store.SetDir(t.TempDir())
+	defer store.SetDir("")
+	dir := t.TempDir()
+	s := newTestSpool(t, dir)
+	defer s.Close()
+
+	// samples of about 100KB fill a segment every 10 samples
+	name := strings.Repeat("x", 100<<10)
+	want := []uint64{}
+	for seq := uint64(1); seq <= 25; seq++ {
+		s.Append(&collector.Snapshot{Time: start.Add(time.Duration(seq) * time.Second), Sequence: seq,
+			EdgeDevice: collector.EdgeDeviceSnapshot{Name: name}})
+		want = append(want, seq)
+	}
+	segments := s.segments()
+	if len(segments) != 3 {
+		t.Errorf("spooled %d segments, want 3", len(segments))
+	}
+	for _, segment := range segments {
+		if info, err := os.Stat(filepath.Join(dir, segment)); err != nil || info.Size() > segmentBytes {
+			t.Errorf("segment %s over the segment size: %v", segment, err)
+		}
+	}
+	if got := replay(t, s, "test"); !reflect.DeepEqual(got, want) {
+		t.Errorf("replayed %v, want %v", got, want)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	dir := t.TempDir()
+	s, err := New(dir, 2*segmentBytes, time.Hour)
+	if err != nil {
+		t.Fatalf("failed to open the spool: %v", err)
+	}
+	segment := func(t0 time.Time) string {
+		name := filepath.Join(dir, fmt.Sprintf("%019d%s", t0.UnixNano(), segmentSuffix))
+		if err := ioutil.WriteFile(name, make([]byte, segmentBytes*6/10), 0644); err != nil {
+			t.Fatalf("failed to write the segment: %v", err)
+		}
+		return filepath.Base(name)
+	}
+	segment(start)
+	old := segment(start.Add(time.Minute))
+	recent := segment(start.Add(2 * time.Hour))
+	last := segment(start.Add(2*time.Hour + time.Minute))
+
+	// over the size, the oldest segment is dropped
+	s.trim(start.Add(time.Hour))
+	if got := s.segments(); !reflect.DeepEqual(got, []string{old, recent, last}) {
+		t.Errorf("segments %v over the size, want %v", got, []string{old, recent, last})
+	}
+	// the segments ending before the retention are dropped, never the last one
+	s.trim(start.Add(3*time.Hour + time.Second))
+	if got := s.segments(); !reflect.DeepEqual(got, []string{recent, last}) {
+		t.Errorf("segments %v over the retention, want %v", got, []string{recent, last})
+	}
+	s.trim(start.Add(10 * time.Hour))
+	if got := s.segments(); !reflect.DeepEqual(got, []string{last}) {
+		t.Errorf("segments %v, want %v", got, []string{last})
+	}
+}
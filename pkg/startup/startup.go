@@ -0,0 +1,332 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package startup
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	corev1 "k8s.io/api/core/v1"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/pod_lister"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The startup energy of a pod is the energy of its cgroup from its creation to its Ready condition:
+// the init containers and the warmup until the readiness probe passes, plus the energy of the container
+// runtime from the pull start to the start of the last container: the image pulls and the container
+// creations run in the runtime service, outside of the pod cgroup. The pull starts once the sandbox is
+// ready, the runtime energy is split between the pods pulling at the same time, and it is measured only
+// with the system processes accounted per systemd service (-system-processes=unit). The pods created
+// before the exporter started are not measured, their startup is partly unknown.
+const (
+	pollPeriod = 5 * time.Second
+	// pods not ready within this time (e.g. jobs, crash loops) are no longer measured
+	maxStartup = time.Hour
+	// the result of a pod is kept this long after it is gone, so the short-lived pods are scraped
+	retention = time.Hour
+)
+
+// runtimeUnits are the systemd services of the container runtimes
+var runtimeUnits = map[string]bool{
+	"containerd.service": true,
+	"crio.service":       true,
+	"docker.service":     true,
+	"k3s.service":        true,
+	"k3s-agent.service":  true,
+}
+
+// sandboxReadyConditions are the pod conditions set once the sandbox is created, before the image pulls
+var sandboxReadyConditions = []corev1.PodConditionType{"PodReadyToStartContainers", "PodHasNetwork"}
+
+// sample is the energy in mJ of a pod over (start, end]
+type sample struct {
+	start, end time.Time
+	energy     float64
+}
+
+// window is the image pull and the container creations of a pod, end is zero until the last container started
+type window struct {
+	start, end time.Time
+}
+
+type result struct {
+	name, namespace string
+	energy          float64
+	duration        float64
+	gone            time.Time
+}
+
+type Tracker struct {
+	started time.Time
+	lister  pod_lister.KubeletPodLister
+
+	lock    sync.Mutex
+	last    time.Time
+	samples map[string][]sample
+	// runtime are the samples of the container runtime, pulls the windows of the pods measured
+	runtime []sample
+	pulls   map[string]window
+	// done are the pods whose startup was measured or cannot be
+	done    map[string]bool
+	results map[string]*result
+}
+
+var (
+	energyDesc = prometheus.NewDesc(
+		"pod_startup_energy_joules",
+		"Energy consumed by the pod from its creation to Ready",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+	durationDesc = prometheus.NewDesc(
+		"pod_startup_duration_seconds",
+		"Time from the creation of the pod to Ready",
+		[]string{
+			"container_name",
+			"container_namespace",
+		},
+		nil,
+	)
+)
+
+func New() *Tracker {
+	return &Tracker{
+		started: time.Now(),
+		samples: map[string][]sample{},
+		done:    map[string]bool{},
+		results: map[string]*result{},
+		pulls:   map[string]window{},
+	}
+}
+
+// Run polls the pods of the kubelet in the background for their Ready condition
+func (t *Tracker) Run() {
+	supervisor.Go("startup", func() {
+		ticker := time.NewTicker(pollPeriod)
+		for {
+			<-ticker.C
+			pods, err := t.lister.ListPods()
+			if err != nil {
+				log.Printf("failed to list the pods: %v\n", err)
+				continue
+			}
+			t.refresh(*pods, time.Now())
+		}
+	})
+}
+
+// readyTime returns the time the pod became Ready, zero if it is not
+func readyTime(pod *corev1.Pod) time.Time {
+	for _, c := range pod.Status.Conditions {
+		if c.Type == corev1.PodReady && c.Status == corev1.ConditionTrue {
+			return c.LastTransitionTime.Time
+		}
+	}
+	return time.Time{}
+}
+
+// pullWindow returns the window of the image pulls and the container creations of a pod, from its
+// sandbox ready, or its creation, to the start of its last container
+func pullWindow(pod *corev1.Pod) window {
+	w := window{start: pod.CreationTimestamp.Time}
+	for _, c := range pod.Status.Conditions {
+		for _, t := range sandboxReadyConditions {
+			if c.Type == t && c.Status == corev1.ConditionTrue {
+				w.start = c.LastTransitionTime.Time
+			}
+		}
+	}
+	statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+	for _, status := range statuses {
+		var started time.Time
+		if status.State.Running != nil {
+			started = status.State.Running.StartedAt.Time
+		} else if status.State.Terminated != nil {
+			started = status.State.Terminated.StartedAt.Time
+		} else {
+			// a container still waiting is being pulled or created
+			return window{start: w.start}
+		}
+		if started.After(w.end) {
+			w.end = started
+		}
+	}
+	if len(statuses) == 0 {
+		return window{start: w.start}
+	}
+	return w
+}
+
+// until returns the end of the window, now if it is not over
+func (w window) until(now time.Time) time.Time {
+	if w.end.IsZero() {
+		return now
+	}
+	return w.end
+}
+
+// pullEnergy returns the runtime energy in mJ of the pull window of a pod, split with the pods pulling
+// during the same samples
+func (t *Tracker) pullEnergy(key string, now time.Time) float64 {
+	w := t.pulls[key]
+	energy := float64(0)
+	for _, s := range t.runtime {
+		f := overlap(s, w.start, w.until(now))
+		if f == 0 {
+			continue
+		}
+		pulling := 0
+		for _, p := range t.pulls {
+			if overlap(s, p.start, p.until(now)) > 0 {
+				pulling++
+			}
+		}
+		energy += s.energy * f / float64(pulling)
+	}
+	return energy
+}
+
+func (t *Tracker) refresh(pods []corev1.Pod, now time.Time) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	listed := map[string]bool{}
+	for i := range pods {
+		pod := &pods[i]
+		key := pod.Namespace + "/" + pod.Name
+		listed[key] = true
+		if t.done[key] {
+			continue
+		}
+		created := pod.CreationTimestamp.Time
+		if created.Before(t.started) || now.Sub(created) > maxStartup {
+			t.done[key] = true
+			delete(t.samples, key)
+			delete(t.pulls, key)
+			continue
+		}
+		t.pulls[key] = pullWindow(pod)
+		ready := readyTime(pod)
+		if ready.IsZero() {
+			continue
+		}
+		// the sample covering the Ready transition is needed before the energy is final
+		if t.last.Before(ready) {
+			continue
+		}
+		r := &result{name: pod.Name, namespace: pod.Namespace, duration: ready.Sub(created).Seconds()}
+		for _, s := range t.samples[key] {
+			r.energy += s.energy * overlap(s, created, ready)
+		}
+		r.energy += t.pullEnergy(key, now)
+		/* energy (J) = energy (mJ) / 1000 */
+		r.energy /= 1000
+		t.results[key] = r
+		t.done[key] = true
+		delete(t.samples, key)
+	}
+	for key := range t.done {
+		if listed[key] {
+			continue
+		}
+		delete(t.done, key)
+		delete(t.samples, key)
+		delete(t.pulls, key)
+		if r, ok := t.results[key]; ok && r.gone.IsZero() {
+			r.gone = now
+		}
+	}
+	for key, r := range t.results {
+		if !r.gone.IsZero() && now.Sub(r.gone) > retention {
+			delete(t.results, key)
+		}
+	}
+}
+
+// overlap returns the fraction of the sample within [from, to]
+func overlap(s sample, from, to time.Time) float64 {
+	start, end := s.start, s.end
+	if from.After(start) {
+		start = from
+	}
+	if to.Before(end) {
+		end = to
+	}
+	length := s.end.Sub(s.start).Seconds()
+	if !end.After(start) || length <= 0 {
+		return 0
+	}
+	return end.Sub(start).Seconds() / length
+}
+
+// Update records the energy of the pods starting, it is registered with collector.OnSample
+func (t *Tracker) Update(s *collector.Snapshot) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.last.IsZero() {
+		t.last = s.Time
+		return
+	}
+	start := t.last
+	t.last = s.Time
+	runtime := sample{start: start, end: s.Time}
+	for _, c := range s.Containers {
+		key := c.Namespace + "/" + c.Name
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, a := range c.Accelerators {
+			energy += a
+		}
+		if c.Namespace == pod_lister.GetSystemProcessNamespace() && runtimeUnits[c.Name] {
+			runtime.energy += float64(energy)
+			continue
+		}
+		if t.done[key] {
+			continue
+		}
+		t.samples[key] = append(t.samples[key], sample{start: start, end: s.Time, energy: float64(energy)})
+	}
+	t.runtime = append(t.runtime, runtime)
+	for len(t.runtime) > 0 && s.Time.Sub(t.runtime[0].end) > maxStartup {
+		t.runtime = t.runtime[1:]
+	}
+	// the system processes and the pods never listed are not measured
+	for key, samples := range t.samples {
+		if s.Time.Sub(samples[0].end) > maxStartup {
+			delete(t.samples, key)
+		}
+	}
+}
+
+func (t *Tracker) Describe(ch chan<- *prometheus.Desc) {
+	ch <- energyDesc
+	ch <- durationDesc
+}
+
+func (t *Tracker) Collect(ch chan<- prometheus.Metric) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	for _, r := range t.results {
+		ch <- prometheus.MustNewConstMetric(energyDesc, prometheus.GaugeValue, r.energy, r.name, r.namespace)
+		ch <- prometheus.MustNewConstMetric(durationDesc, prometheus.GaugeValue, r.duration, r.name, r.namespace)
+	}
+}
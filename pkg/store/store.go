@@ -0,0 +1,87 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// The store keeps the state that must survive an exporter restart (e.g. running energy totals)
+// on the local disk of the edge device, one JSON file per key.
+const (
+	DefaultDir = "/var/lib/kepler"
+)
+
+var (
+	dir  = DefaultDir
+	lock sync.Mutex
+)
+
+// SetDir sets the store directory, an empty dir disables the store
+func SetDir(d string) {
+	lock.Lock()
+	defer lock.Unlock()
+	dir = d
+}
+
+// Load reads the value saved under key, it returns false if nothing was saved yet
+func Load(key string, v interface{}) (bool, error) {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(dir) == 0 {
+		return false, nil
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, key+".json"))
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if err = json.Unmarshal(data, v); err != nil {
+		return false, fmt.Errorf("failed to parse %s: %v", key, err)
+	}
+	return true, nil
+}
+
+// Save writes the value under key, replacing the file atomically so that a power loss
+// does not leave a truncated file
+func Save(key string, v interface{}) error {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(dir) == 0 {
+		return nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	path := filepath.Join(dir, key+".json")
+	tmp := path + ".tmp"
+	if err = ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
@@ -0,0 +1,733 @@
+//
+//Copyright 2022.
+//
+//Licensed under the Apache License, Version 2.0 (the "License");
+//you may not use this file except in compliance with the License.
+//You may obtain a copy of the License at
+//
+//http://www.apache.org/licenses/LICENSE-2.0
+//
+//Unless required by applicable law or agreed to in writing, software
+//distributed under the License is distributed on an "AS IS" BASIS,
+//WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//See the License for the specific language governing permissions and
+//limitations under the License.
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.27.1
+// 	protoc        (unknown)
+// source: energy.proto
+
+package energypb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	timestamppb "google.golang.org/protobuf/types/known/timestamppb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type WatchContainersRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// Only the containers of these namespaces are streamed, all if empty.
+	Namespaces []string `protobuf:"bytes,1,rep,name=namespaces,proto3" json:"namespaces,omitempty"`
+	// Only the containers with at least this energy in mJ in the sample are streamed.
+	MinEnergy uint64 `protobuf:"varint,2,opt,name=min_energy,json=minEnergy,proto3" json:"min_energy,omitempty"`
+}
+
+func (x *WatchContainersRequest) Reset() {
+	*x = WatchContainersRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchContainersRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchContainersRequest) ProtoMessage() {}
+
+func (x *WatchContainersRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchContainersRequest.ProtoReflect.Descriptor instead.
+func (*WatchContainersRequest) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *WatchContainersRequest) GetNamespaces() []string {
+	if x != nil {
+		return x.Namespaces
+	}
+	return nil
+}
+
+func (x *WatchContainersRequest) GetMinEnergy() uint64 {
+	if x != nil {
+		return x.MinEnergy
+	}
+	return 0
+}
+
+type WatchNodeRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *WatchNodeRequest) Reset() {
+	*x = WatchNodeRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *WatchNodeRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*WatchNodeRequest) ProtoMessage() {}
+
+func (x *WatchNodeRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use WatchNodeRequest.ProtoReflect.Descriptor instead.
+func (*WatchNodeRequest) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{1}
+}
+
+// The samples are identified by the boot of the device and their sequence in the boot, a gap in the
+// sequence is a sample dropped for a slow consumer.
+type SampleHeader struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Time     *timestamppb.Timestamp `protobuf:"bytes,1,opt,name=time,proto3" json:"time,omitempty"`
+	BootId   string                 `protobuf:"bytes,2,opt,name=boot_id,json=bootId,proto3" json:"boot_id,omitempty"`
+	Sequence uint64                 `protobuf:"varint,3,opt,name=sequence,proto3" json:"sequence,omitempty"`
+	Node     string                 `protobuf:"bytes,4,opt,name=node,proto3" json:"node,omitempty"`
+}
+
+func (x *SampleHeader) Reset() {
+	*x = SampleHeader{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SampleHeader) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SampleHeader) ProtoMessage() {}
+
+func (x *SampleHeader) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SampleHeader.ProtoReflect.Descriptor instead.
+func (*SampleHeader) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SampleHeader) GetTime() *timestamppb.Timestamp {
+	if x != nil {
+		return x.Time
+	}
+	return nil
+}
+
+func (x *SampleHeader) GetBootId() string {
+	if x != nil {
+		return x.BootId
+	}
+	return ""
+}
+
+func (x *SampleHeader) GetSequence() uint64 {
+	if x != nil {
+		return x.Sequence
+	}
+	return 0
+}
+
+func (x *SampleHeader) GetNode() string {
+	if x != nil {
+		return x.Node
+	}
+	return ""
+}
+
+// ContainerEnergy is the energy of a container in mJ and its CPU time over a sample.
+type ContainerEnergy struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Name          string            `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Namespace     string            `protobuf:"bytes,2,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	Command       string            `protobuf:"bytes,3,opt,name=command,proto3" json:"command,omitempty"`
+	CpuTime       float64           `protobuf:"fixed64,4,opt,name=cpu_time,json=cpuTime,proto3" json:"cpu_time,omitempty"`
+	EnergyInCore  uint64            `protobuf:"varint,5,opt,name=energy_in_core,json=energyInCore,proto3" json:"energy_in_core,omitempty"`
+	EnergyInDram  uint64            `protobuf:"varint,6,opt,name=energy_in_dram,json=energyInDram,proto3" json:"energy_in_dram,omitempty"`
+	EnergyInGpu   uint64            `protobuf:"varint,7,opt,name=energy_in_gpu,json=energyInGpu,proto3" json:"energy_in_gpu,omitempty"`
+	EnergyInOther uint64            `protobuf:"varint,8,opt,name=energy_in_other,json=energyInOther,proto3" json:"energy_in_other,omitempty"`
+	Accelerators  map[string]uint64 `protobuf:"bytes,9,rep,name=accelerators,proto3" json:"accelerators,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"varint,2,opt,name=value,proto3"`
+	// terminated marks the last sample of a removed container.
+	Terminated bool `protobuf:"varint,10,opt,name=terminated,proto3" json:"terminated,omitempty"`
+}
+
+func (x *ContainerEnergy) Reset() {
+	*x = ContainerEnergy{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ContainerEnergy) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContainerEnergy) ProtoMessage() {}
+
+func (x *ContainerEnergy) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContainerEnergy.ProtoReflect.Descriptor instead.
+func (*ContainerEnergy) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *ContainerEnergy) GetName() string {
+	if x != nil {
+		return x.Name
+	}
+	return ""
+}
+
+func (x *ContainerEnergy) GetNamespace() string {
+	if x != nil {
+		return x.Namespace
+	}
+	return ""
+}
+
+func (x *ContainerEnergy) GetCommand() string {
+	if x != nil {
+		return x.Command
+	}
+	return ""
+}
+
+func (x *ContainerEnergy) GetCpuTime() float64 {
+	if x != nil {
+		return x.CpuTime
+	}
+	return 0
+}
+
+func (x *ContainerEnergy) GetEnergyInCore() uint64 {
+	if x != nil {
+		return x.EnergyInCore
+	}
+	return 0
+}
+
+func (x *ContainerEnergy) GetEnergyInDram() uint64 {
+	if x != nil {
+		return x.EnergyInDram
+	}
+	return 0
+}
+
+func (x *ContainerEnergy) GetEnergyInGpu() uint64 {
+	if x != nil {
+		return x.EnergyInGpu
+	}
+	return 0
+}
+
+func (x *ContainerEnergy) GetEnergyInOther() uint64 {
+	if x != nil {
+		return x.EnergyInOther
+	}
+	return 0
+}
+
+func (x *ContainerEnergy) GetAccelerators() map[string]uint64 {
+	if x != nil {
+		return x.Accelerators
+	}
+	return nil
+}
+
+func (x *ContainerEnergy) GetTerminated() bool {
+	if x != nil {
+		return x.Terminated
+	}
+	return false
+}
+
+type ContainerSample struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Header     *SampleHeader      `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	Containers []*ContainerEnergy `protobuf:"bytes,2,rep,name=containers,proto3" json:"containers,omitempty"`
+}
+
+func (x *ContainerSample) Reset() {
+	*x = ContainerSample{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ContainerSample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ContainerSample) ProtoMessage() {}
+
+func (x *ContainerSample) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ContainerSample.ProtoReflect.Descriptor instead.
+func (*ContainerSample) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *ContainerSample) GetHeader() *SampleHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *ContainerSample) GetContainers() []*ContainerEnergy {
+	if x != nil {
+		return x.Containers
+	}
+	return nil
+}
+
+// NodeSample is the energy of the node in mJ and its CPU time over a sample.
+type NodeSample struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Header        *SampleHeader      `protobuf:"bytes,1,opt,name=header,proto3" json:"header,omitempty"`
+	CpuTime       float64            `protobuf:"fixed64,2,opt,name=cpu_time,json=cpuTime,proto3" json:"cpu_time,omitempty"`
+	EnergyInCore  float64            `protobuf:"fixed64,3,opt,name=energy_in_core,json=energyInCore,proto3" json:"energy_in_core,omitempty"`
+	EnergyInDram  float64            `protobuf:"fixed64,4,opt,name=energy_in_dram,json=energyInDram,proto3" json:"energy_in_dram,omitempty"`
+	EnergyInGpu   float64            `protobuf:"fixed64,5,opt,name=energy_in_gpu,json=energyInGpu,proto3" json:"energy_in_gpu,omitempty"`
+	EnergyInOther float64            `protobuf:"fixed64,6,opt,name=energy_in_other,json=energyInOther,proto3" json:"energy_in_other,omitempty"`
+	Accelerators  map[string]float64 `protobuf:"bytes,7,rep,name=accelerators,proto3" json:"accelerators,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"fixed64,2,opt,name=value,proto3"`
+	// states are the states of the node in the sample, such as in a maintenance window or cordoned.
+	States []string `protobuf:"bytes,8,rep,name=states,proto3" json:"states,omitempty"`
+}
+
+func (x *NodeSample) Reset() {
+	*x = NodeSample{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_energy_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *NodeSample) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*NodeSample) ProtoMessage() {}
+
+func (x *NodeSample) ProtoReflect() protoreflect.Message {
+	mi := &file_energy_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use NodeSample.ProtoReflect.Descriptor instead.
+func (*NodeSample) Descriptor() ([]byte, []int) {
+	return file_energy_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *NodeSample) GetHeader() *SampleHeader {
+	if x != nil {
+		return x.Header
+	}
+	return nil
+}
+
+func (x *NodeSample) GetCpuTime() float64 {
+	if x != nil {
+		return x.CpuTime
+	}
+	return 0
+}
+
+func (x *NodeSample) GetEnergyInCore() float64 {
+	if x != nil {
+		return x.EnergyInCore
+	}
+	return 0
+}
+
+func (x *NodeSample) GetEnergyInDram() float64 {
+	if x != nil {
+		return x.EnergyInDram
+	}
+	return 0
+}
+
+func (x *NodeSample) GetEnergyInGpu() float64 {
+	if x != nil {
+		return x.EnergyInGpu
+	}
+	return 0
+}
+
+func (x *NodeSample) GetEnergyInOther() float64 {
+	if x != nil {
+		return x.EnergyInOther
+	}
+	return 0
+}
+
+func (x *NodeSample) GetAccelerators() map[string]float64 {
+	if x != nil {
+		return x.Accelerators
+	}
+	return nil
+}
+
+func (x *NodeSample) GetStates() []string {
+	if x != nil {
+		return x.States
+	}
+	return nil
+}
+
+var File_energy_proto protoreflect.FileDescriptor
+
+var file_energy_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x10,
+	0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31,
+	0x1a, 0x1f, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75,
+	0x66, 0x2f, 0x74, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x22, 0x57, 0x0a, 0x16, 0x57, 0x61, 0x74, 0x63, 0x68, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69,
+	0x6e, 0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x6e,
+	0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52,
+	0x0a, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x73, 0x12, 0x1d, 0x0a, 0x0a, 0x6d,
+	0x69, 0x6e, 0x5f, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52,
+	0x09, 0x6d, 0x69, 0x6e, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x22, 0x12, 0x0a, 0x10, 0x57, 0x61,
+	0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x22, 0x87,
+	0x01, 0x0a, 0x0c, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12,
+	0x2e, 0x0a, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x54, 0x69, 0x6d, 0x65, 0x73, 0x74, 0x61, 0x6d, 0x70, 0x52, 0x04, 0x74, 0x69, 0x6d, 0x65, 0x12,
+	0x17, 0x0a, 0x07, 0x62, 0x6f, 0x6f, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x06, 0x62, 0x6f, 0x6f, 0x74, 0x49, 0x64, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x71, 0x75,
+	0x65, 0x6e, 0x63, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x04, 0x52, 0x08, 0x73, 0x65, 0x71, 0x75,
+	0x65, 0x6e, 0x63, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x18, 0x04, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x6e, 0x6f, 0x64, 0x65, 0x22, 0xca, 0x03, 0x0a, 0x0f, 0x43, 0x6f, 0x6e,
+	0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x12, 0x12, 0x0a, 0x04,
+	0x6e, 0x61, 0x6d, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x1c, 0x0a, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x09, 0x6e, 0x61, 0x6d, 0x65, 0x73, 0x70, 0x61, 0x63, 0x65, 0x12, 0x18,
+	0x0a, 0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x07, 0x63, 0x6f, 0x6d, 0x6d, 0x61, 0x6e, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x63, 0x70, 0x75, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x63, 0x70, 0x75, 0x54,
+	0x69, 0x6d, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e,
+	0x5f, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0c, 0x65, 0x6e, 0x65,
+	0x72, 0x67, 0x79, 0x49, 0x6e, 0x43, 0x6f, 0x72, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x65, 0x6e, 0x65,
+	0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e, 0x5f, 0x64, 0x72, 0x61, 0x6d, 0x18, 0x06, 0x20, 0x01, 0x28,
+	0x04, 0x52, 0x0c, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x49, 0x6e, 0x44, 0x72, 0x61, 0x6d, 0x12,
+	0x22, 0x0a, 0x0d, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e, 0x5f, 0x67, 0x70, 0x75,
+	0x18, 0x07, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0b, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x49, 0x6e,
+	0x47, 0x70, 0x75, 0x12, 0x26, 0x0a, 0x0f, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e,
+	0x5f, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x18, 0x08, 0x20, 0x01, 0x28, 0x04, 0x52, 0x0d, 0x65, 0x6e,
+	0x65, 0x72, 0x67, 0x79, 0x49, 0x6e, 0x4f, 0x74, 0x68, 0x65, 0x72, 0x12, 0x57, 0x0a, 0x0c, 0x61,
+	0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72, 0x73, 0x18, 0x09, 0x20, 0x03, 0x28,
+	0x0b, 0x32, 0x33, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67,
+	0x79, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x45, 0x6e,
+	0x65, 0x72, 0x67, 0x79, 0x2e, 0x41, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72,
+	0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0c, 0x61, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61,
+	0x74, 0x6f, 0x72, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x74, 0x65, 0x72, 0x6d, 0x69, 0x6e, 0x61, 0x74,
+	0x65, 0x64, 0x18, 0x0a, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0a, 0x74, 0x65, 0x72, 0x6d, 0x69, 0x6e,
+	0x61, 0x74, 0x65, 0x64, 0x1a, 0x3f, 0x0a, 0x11, 0x41, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61,
+	0x74, 0x6f, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x04, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
+	0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x8c, 0x01, 0x0a, 0x0f, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69,
+	0x6e, 0x65, 0x72, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x12, 0x36, 0x0a, 0x06, 0x68, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x6b, 0x65, 0x70, 0x6c,
+	0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x48, 0x65, 0x61, 0x64, 0x65, 0x72, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65,
+	0x72, 0x12, 0x41, 0x0a, 0x0a, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x73, 0x18,
+	0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x21, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65,
+	0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e,
+	0x65, 0x72, 0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x52, 0x0a, 0x63, 0x6f, 0x6e, 0x74, 0x61, 0x69,
+	0x6e, 0x65, 0x72, 0x73, 0x22, 0xa4, 0x03, 0x0a, 0x0a, 0x4e, 0x6f, 0x64, 0x65, 0x53, 0x61, 0x6d,
+	0x70, 0x6c, 0x65, 0x12, 0x36, 0x0a, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65,
+	0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x48, 0x65, 0x61,
+	0x64, 0x65, 0x72, 0x52, 0x06, 0x68, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x19, 0x0a, 0x08, 0x63,
+	0x70, 0x75, 0x5f, 0x74, 0x69, 0x6d, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01, 0x52, 0x07, 0x63,
+	0x70, 0x75, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x24, 0x0a, 0x0e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x5f, 0x69, 0x6e, 0x5f, 0x63, 0x6f, 0x72, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0c,
+	0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x49, 0x6e, 0x43, 0x6f, 0x72, 0x65, 0x12, 0x24, 0x0a, 0x0e,
+	0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e, 0x5f, 0x64, 0x72, 0x61, 0x6d, 0x18, 0x04,
+	0x20, 0x01, 0x28, 0x01, 0x52, 0x0c, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x49, 0x6e, 0x44, 0x72,
+	0x61, 0x6d, 0x12, 0x22, 0x0a, 0x0d, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x5f, 0x69, 0x6e, 0x5f,
+	0x67, 0x70, 0x75, 0x18, 0x05, 0x20, 0x01, 0x28, 0x01, 0x52, 0x0b, 0x65, 0x6e, 0x65, 0x72, 0x67,
+	0x79, 0x49, 0x6e, 0x47, 0x70, 0x75, 0x12, 0x26, 0x0a, 0x0f, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x5f, 0x69, 0x6e, 0x5f, 0x6f, 0x74, 0x68, 0x65, 0x72, 0x18, 0x06, 0x20, 0x01, 0x28, 0x01, 0x52,
+	0x0d, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x49, 0x6e, 0x4f, 0x74, 0x68, 0x65, 0x72, 0x12, 0x52,
+	0x0a, 0x0c, 0x61, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72, 0x73, 0x18, 0x07,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x2e, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e,
+	0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x53, 0x61, 0x6d, 0x70,
+	0x6c, 0x65, 0x2e, 0x41, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72, 0x73, 0x45,
+	0x6e, 0x74, 0x72, 0x79, 0x52, 0x0c, 0x61, 0x63, 0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f,
+	0x72, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x74, 0x61, 0x74, 0x65, 0x73, 0x18, 0x08, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x06, 0x73, 0x74, 0x61, 0x74, 0x65, 0x73, 0x1a, 0x3f, 0x0a, 0x11, 0x41, 0x63,
+	0x63, 0x65, 0x6c, 0x65, 0x72, 0x61, 0x74, 0x6f, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
+	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
+	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x01,
+	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x32, 0xc2, 0x01, 0x0a, 0x0d,
+	0x45, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x53, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x12, 0x60, 0x0a,
+	0x0f, 0x57, 0x61, 0x74, 0x63, 0x68, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x73,
+	0x12, 0x28, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x2e, 0x76, 0x31, 0x2e, 0x57, 0x61, 0x74, 0x63, 0x68, 0x43, 0x6f, 0x6e, 0x74, 0x61, 0x69, 0x6e,
+	0x65, 0x72, 0x73, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e, 0x6b, 0x65, 0x70,
+	0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e, 0x43, 0x6f,
+	0x6e, 0x74, 0x61, 0x69, 0x6e, 0x65, 0x72, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x30, 0x01, 0x12,
+	0x4f, 0x0a, 0x09, 0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x12, 0x22, 0x2e, 0x6b,
+	0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79, 0x2e, 0x76, 0x31, 0x2e,
+	0x57, 0x61, 0x74, 0x63, 0x68, 0x4e, 0x6f, 0x64, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74,
+	0x1a, 0x1c, 0x2e, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2e, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x2e, 0x76, 0x31, 0x2e, 0x4e, 0x6f, 0x64, 0x65, 0x53, 0x61, 0x6d, 0x70, 0x6c, 0x65, 0x30, 0x01,
+	0x42, 0x40, 0x5a, 0x3e, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x73,
+	0x75, 0x73, 0x74, 0x61, 0x69, 0x6e, 0x61, 0x62, 0x6c, 0x65, 0x2d, 0x63, 0x6f, 0x6d, 0x70, 0x75,
+	0x74, 0x69, 0x6e, 0x67, 0x2d, 0x69, 0x6f, 0x2f, 0x6b, 0x65, 0x70, 0x6c, 0x65, 0x72, 0x2f, 0x70,
+	0x6b, 0x67, 0x2f, 0x73, 0x74, 0x72, 0x65, 0x61, 0x6d, 0x2f, 0x65, 0x6e, 0x65, 0x72, 0x67, 0x79,
+	0x70, 0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_energy_proto_rawDescOnce sync.Once
+	file_energy_proto_rawDescData = file_energy_proto_rawDesc
+)
+
+func file_energy_proto_rawDescGZIP() []byte {
+	file_energy_proto_rawDescOnce.Do(func() {
+		file_energy_proto_rawDescData = protoimpl.X.CompressGZIP(file_energy_proto_rawDescData)
+	})
+	return file_energy_proto_rawDescData
+}
+
+var file_energy_proto_msgTypes = make([]protoimpl.MessageInfo, 8)
+var file_energy_proto_goTypes = []interface{}{
+	(*WatchContainersRequest)(nil), // 0: kepler.energy.v1.WatchContainersRequest
+	(*WatchNodeRequest)(nil),       // 1: kepler.energy.v1.WatchNodeRequest
+	(*SampleHeader)(nil),           // 2: kepler.energy.v1.SampleHeader
+	(*ContainerEnergy)(nil),        // 3: kepler.energy.v1.ContainerEnergy
+	(*ContainerSample)(nil),        // 4: kepler.energy.v1.ContainerSample
+	(*NodeSample)(nil),             // 5: kepler.energy.v1.NodeSample
+	nil,                            // 6: kepler.energy.v1.ContainerEnergy.AcceleratorsEntry
+	nil,                            // 7: kepler.energy.v1.NodeSample.AcceleratorsEntry
+	(*timestamppb.Timestamp)(nil),  // 8: google.protobuf.Timestamp
+}
+var file_energy_proto_depIdxs = []int32{
+	8, // 0: kepler.energy.v1.SampleHeader.time:type_name -> google.protobuf.Timestamp
+	6, // 1: kepler.energy.v1.ContainerEnergy.accelerators:type_name -> kepler.energy.v1.ContainerEnergy.AcceleratorsEntry
+	2, // 2: kepler.energy.v1.ContainerSample.header:type_name -> kepler.energy.v1.SampleHeader
+	3, // 3: kepler.energy.v1.ContainerSample.containers:type_name -> kepler.energy.v1.ContainerEnergy
+	2, // 4: kepler.energy.v1.NodeSample.header:type_name -> kepler.energy.v1.SampleHeader
+	7, // 5: kepler.energy.v1.NodeSample.accelerators:type_name -> kepler.energy.v1.NodeSample.AcceleratorsEntry
+	0, // 6: kepler.energy.v1.EnergyService.WatchContainers:input_type -> kepler.energy.v1.WatchContainersRequest
+	1, // 7: kepler.energy.v1.EnergyService.WatchNode:input_type -> kepler.energy.v1.WatchNodeRequest
+	4, // 8: kepler.energy.v1.EnergyService.WatchContainers:output_type -> kepler.energy.v1.ContainerSample
+	5, // 9: kepler.energy.v1.EnergyService.WatchNode:output_type -> kepler.energy.v1.NodeSample
+	8, // [8:10] is the sub-list for method output_type
+	6, // [6:8] is the sub-list for method input_type
+	6, // [6:6] is the sub-list for extension type_name
+	6, // [6:6] is the sub-list for extension extendee
+	0, // [0:6] is the sub-list for field type_name
+}
+
+func init() { file_energy_proto_init() }
+func file_energy_proto_init() {
+	if File_energy_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_energy_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchContainersRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_energy_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*WatchNodeRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_energy_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SampleHeader); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_energy_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ContainerEnergy); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_energy_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ContainerSample); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_energy_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*NodeSample); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_energy_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   8,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_energy_proto_goTypes,
+		DependencyIndexes: file_energy_proto_depIdxs,
+		MessageInfos:      file_energy_proto_msgTypes,
+	}.Build()
+	File_energy_proto = out.File
+	file_energy_proto_rawDesc = nil
+	file_energy_proto_goTypes = nil
+	file_energy_proto_depIdxs = nil
+}
@@ -0,0 +1,199 @@
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.2.0
+// - protoc             (unknown)
+// source: energy.proto
+
+package energypb
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+// EnergyServiceClient is the client API for EnergyService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type EnergyServiceClient interface {
+	// WatchContainers streams the energy of the containers accounted in each sample.
+	WatchContainers(ctx context.Context, in *WatchContainersRequest, opts ...grpc.CallOption) (EnergyService_WatchContainersClient, error)
+	// WatchNode streams the energy of the node in each sample.
+	WatchNode(ctx context.Context, in *WatchNodeRequest, opts ...grpc.CallOption) (EnergyService_WatchNodeClient, error)
+}
+
+type energyServiceClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewEnergyServiceClient(cc grpc.ClientConnInterface) EnergyServiceClient {
+	return &energyServiceClient{cc}
+}
+
+func (c *energyServiceClient) WatchContainers(ctx context.Context, in *WatchContainersRequest, opts ...grpc.CallOption) (EnergyService_WatchContainersClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EnergyService_ServiceDesc.Streams[0], "/kepler.energy.v1.EnergyService/WatchContainers", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &energyServiceWatchContainersClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EnergyService_WatchContainersClient interface {
+	Recv() (*ContainerSample, error)
+	grpc.ClientStream
+}
+
+type energyServiceWatchContainersClient struct {
+	grpc.ClientStream
+}
+
+func (x *energyServiceWatchContainersClient) Recv() (*ContainerSample, error) {
+	m := new(ContainerSample)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *energyServiceClient) WatchNode(ctx context.Context, in *WatchNodeRequest, opts ...grpc.CallOption) (EnergyService_WatchNodeClient, error) {
+	stream, err := c.cc.NewStream(ctx, &EnergyService_ServiceDesc.Streams[1], "/kepler.energy.v1.EnergyService/WatchNode", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &energyServiceWatchNodeClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type EnergyService_WatchNodeClient interface {
+	Recv() (*NodeSample, error)
+	grpc.ClientStream
+}
+
+type energyServiceWatchNodeClient struct {
+	grpc.ClientStream
+}
+
+func (x *energyServiceWatchNodeClient) Recv() (*NodeSample, error) {
+	m := new(NodeSample)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// EnergyServiceServer is the server API for EnergyService service.
+// All implementations must embed UnimplementedEnergyServiceServer
+// for forward compatibility
+type EnergyServiceServer interface {
+	// WatchContainers streams the energy of the containers accounted in each sample.
+	WatchContainers(*WatchContainersRequest, EnergyService_WatchContainersServer) error
+	// WatchNode streams the energy of the node in each sample.
+	WatchNode(*WatchNodeRequest, EnergyService_WatchNodeServer) error
+	mustEmbedUnimplementedEnergyServiceServer()
+}
+
+// UnimplementedEnergyServiceServer must be embedded to have forward compatible implementations.
+type UnimplementedEnergyServiceServer struct {
+}
+
+func (UnimplementedEnergyServiceServer) WatchContainers(*WatchContainersRequest, EnergyService_WatchContainersServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchContainers not implemented")
+}
+func (UnimplementedEnergyServiceServer) WatchNode(*WatchNodeRequest, EnergyService_WatchNodeServer) error {
+	return status.Errorf(codes.Unimplemented, "method WatchNode not implemented")
+}
+func (UnimplementedEnergyServiceServer) mustEmbedUnimplementedEnergyServiceServer() {}
+
+// UnsafeEnergyServiceServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to EnergyServiceServer will
+// result in compilation errors.
+type UnsafeEnergyServiceServer interface {
+	mustEmbedUnimplementedEnergyServiceServer()
+}
+
+func RegisterEnergyServiceServer(s grpc.ServiceRegistrar, srv EnergyServiceServer) {
+	s.RegisterService(&EnergyService_ServiceDesc, srv)
+}
+
+func _EnergyService_WatchContainers_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchContainersRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EnergyServiceServer).WatchContainers(m, &energyServiceWatchContainersServer{stream})
+}
+
+type EnergyService_WatchContainersServer interface {
+	Send(*ContainerSample) error
+	grpc.ServerStream
+}
+
+type energyServiceWatchContainersServer struct {
+	grpc.ServerStream
+}
+
+func (x *energyServiceWatchContainersServer) Send(m *ContainerSample) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _EnergyService_WatchNode_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(WatchNodeRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(EnergyServiceServer).WatchNode(m, &energyServiceWatchNodeServer{stream})
+}
+
+type EnergyService_WatchNodeServer interface {
+	Send(*NodeSample) error
+	grpc.ServerStream
+}
+
+type energyServiceWatchNodeServer struct {
+	grpc.ServerStream
+}
+
+func (x *energyServiceWatchNodeServer) Send(m *NodeSample) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// EnergyService_ServiceDesc is the grpc.ServiceDesc for EnergyService service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var EnergyService_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "kepler.energy.v1.EnergyService",
+	HandlerType: (*EnergyServiceServer)(nil),
+	Methods:     []grpc.MethodDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "WatchContainers",
+			Handler:       _EnergyService_WatchContainers_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "WatchNode",
+			Handler:       _EnergyService_WatchNode_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "energy.proto",
+}
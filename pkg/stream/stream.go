@@ -0,0 +1,193 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package stream
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"sync"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+	"github.com/sustainable-computing-io/kepler/pkg/stream/energypb"
+	"github.com/sustainable-computing-io/kepler/pkg/supervisor"
+)
+
+// The gRPC server streams the snapshot of each sample to the subscribers of the EnergyService. Each
+// subscriber has a queue of samples, a subscriber falling behind misses the samples its queue has no
+// room for, the gap shows in the sequence of the samples.
+const (
+	subscriberQueue = 8
+	unixPrefix      = "unix://"
+)
+
+type subscriber struct {
+	samples chan *collector.Snapshot
+	dropped uint64
+}
+
+type Server struct {
+	energypb.UnimplementedEnergyServiceServer
+	lock        sync.Mutex
+	subscribers map[*subscriber]struct{}
+	server      *grpc.Server
+}
+
+func New() *Server {
+	s := &Server{subscribers: map[*subscriber]struct{}{}}
+	s.server = grpc.NewServer()
+	energypb.RegisterEnergyServiceServer(s.server, s)
+	return s
+}
+
+// ListenAndServe serves the EnergyService on address in the background, a TCP address (e.g. ":9102") or a
+// unix socket (e.g. unix:///run/kepler/energy.sock)
+func (s *Server) ListenAndServe(address string) error {
+	network := "tcp"
+	if strings.HasPrefix(address, unixPrefix) {
+		network, address = "unix", strings.TrimPrefix(address, unixPrefix)
+		// a socket left by a previous run
+		if err := os.Remove(address); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %v", address, err)
+		}
+	}
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", address, err)
+	}
+	supervisor.Go("grpc", func() {
+		if err := s.server.Serve(listener); err != nil {
+			log.Printf("failed to serve grpc: %v\n", err)
+		}
+	})
+	return nil
+}
+
+// Stop closes the streams and the listener
+func (s *Server) Stop() {
+	s.server.Stop()
+}
+
+// Update queues the snapshot of a sample to the subscribers, it is registered with collector.OnSample
+func (s *Server) Update(snapshot *collector.Snapshot) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for sub := range s.subscribers {
+		select {
+		case sub.samples <- snapshot:
+		default:
+			sub.dropped++
+		}
+	}
+}
+
+func (s *Server) subscribe() *subscriber {
+	sub := &subscriber{samples: make(chan *collector.Snapshot, subscriberQueue)}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.subscribers[sub] = struct{}{}
+	return sub
+}
+
+func (s *Server) unsubscribe(sub *subscriber, method string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.subscribers, sub)
+	if sub.dropped > 0 {
+		log.Printf("grpc %s subscriber missed %d samples\n", method, sub.dropped)
+	}
+}
+
+// WatchContainers streams the containers of each sample, filtered by the namespaces and minimal energy
+func (s *Server) WatchContainers(req *energypb.WatchContainersRequest, stream energypb.EnergyService_WatchContainersServer) error {
+	namespaces := map[string]bool{}
+	for _, ns := range req.Namespaces {
+		namespaces[ns] = true
+	}
+	return s.watch(stream.Context().Done(), "WatchContainers", func(snapshot *collector.Snapshot) error {
+		sample := &energypb.ContainerSample{Header: header(snapshot), Containers: []*energypb.ContainerEnergy{}}
+		for i := range snapshot.Containers {
+			c := &snapshot.Containers[i]
+			if len(namespaces) > 0 && !namespaces[c.Namespace] {
+				continue
+			}
+			if c.EnergyInCore+c.EnergyInDram+c.EnergyInGPU+c.EnergyInOther < req.MinEnergy && !c.Terminated {
+				continue
+			}
+			sample.Containers = append(sample.Containers, &energypb.ContainerEnergy{
+				Name:          c.Name,
+				Namespace:     c.Namespace,
+				Command:       c.Command,
+				CpuTime:       c.CPUTime,
+				EnergyInCore:  c.EnergyInCore,
+				EnergyInDram:  c.EnergyInDram,
+				EnergyInGpu:   c.EnergyInGPU,
+				EnergyInOther: c.EnergyInOther,
+				Accelerators:  c.Accelerators,
+				Terminated:    c.Terminated,
+			})
+		}
+		return stream.Send(sample)
+	})
+}
+
+// WatchNode streams the node of each sample
+func (s *Server) WatchNode(req *energypb.WatchNodeRequest, stream energypb.EnergyService_WatchNodeServer) error {
+	return s.watch(stream.Context().Done(), "WatchNode", func(snapshot *collector.Snapshot) error {
+		n := &snapshot.EdgeDevice
+		return stream.Send(&energypb.NodeSample{
+			Header:        header(snapshot),
+			CpuTime:       n.CPUTime,
+			EnergyInCore:  n.EnergyInCore,
+			EnergyInDram:  n.EnergyInDram,
+			EnergyInGpu:   n.EnergyInGPU,
+			EnergyInOther: n.EnergyInOther,
+			Accelerators:  n.Accelerators,
+			States:        n.States,
+		})
+	})
+}
+
+// watch sends the samples of a subscription until the stream is done or a send fails
+func (s *Server) watch(done <-chan struct{}, method string, send func(*collector.Snapshot) error) error {
+	sub := s.subscribe()
+	defer s.unsubscribe(sub, method)
+	for {
+		select {
+		case <-done:
+			return nil
+		case snapshot := <-sub.samples:
+			if err := send(snapshot); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func header(s *collector.Snapshot) *energypb.SampleHeader {
+	return &energypb.SampleHeader{
+		Time:     timestamppb.New(s.Time),
+		BootId:   s.BootID,
+		Sequence: s.Sequence,
+		Node:     s.EdgeDevice.Name,
+	}
+}
@@ -0,0 +1,169 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Package supervisor isolates the panics of the subsystems (power meters, drivers, publishers),
+// so that one of them failing does not stop the node energy collection
+package supervisor
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	minBackoff = time.Second
+	maxBackoff = 5 * time.Minute
+	// a goroutine running that long without panicking restarts from minBackoff again
+	stableAfter = 10 * time.Minute
+)
+
+var (
+	panicsDesc = prometheus.NewDesc(
+		"kepler_subsystem_panics_total",
+		"Panics recovered in the subsystem.",
+		[]string{"subsystem"},
+		nil,
+	)
+	restartsDesc = prometheus.NewDesc(
+		"kepler_subsystem_restarts_total",
+		"Restarts of the goroutines of the subsystem after a panic.",
+		[]string{"subsystem"},
+		nil,
+	)
+
+	lock      sync.Mutex
+	panics    = map[string]uint64{}
+	restarts  = map[string]uint64{}
+	callState = map[string]*backoff{}
+)
+
+type backoff struct {
+	delay time.Duration
+	until time.Time
+}
+
+// next returns the delay after another panic, doubling up to maxBackoff
+func (b *backoff) next() time.Duration {
+	b.delay *= 2
+	if b.delay == 0 {
+		b.delay = minBackoff
+	}
+	if b.delay > maxBackoff {
+		b.delay = maxBackoff
+	}
+	return b.delay
+}
+
+// Go runs fn in a goroutine like Run, until fn returns
+func Go(name string, fn func()) {
+	go Run(context.Background(), name, fn)
+}
+
+// Run calls fn, calling it again with an exponential backoff whenever it panics,
+// until fn returns or the context is done
+func Run(ctx context.Context, name string, fn func()) {
+	b := &backoff{}
+	for {
+		started := time.Now()
+		if !protect(name, fn) {
+			return
+		}
+		if time.Since(started) > stableAfter {
+			b.delay = 0
+		}
+		delay := b.next()
+		log.Printf("restarting %s in %v\n", name, delay)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+		lock.Lock()
+		restarts[name]++
+		lock.Unlock()
+	}
+}
+
+// Call calls fn, recovering its panic. After a panic the subsystem is skipped with an exponential backoff,
+// it returns whether fn completed
+func Call(name string, fn func()) bool {
+	lock.Lock()
+	b, ok := callState[name]
+	if !ok {
+		b = &backoff{}
+		callState[name] = b
+	}
+	skip := time.Now().Before(b.until)
+	lock.Unlock()
+	if skip {
+		return false
+	}
+	if !protect(name, fn) {
+		lock.Lock()
+		b.delay = 0
+		lock.Unlock()
+		return true
+	}
+	lock.Lock()
+	delay := b.next()
+	b.until = time.Now().Add(delay)
+	lock.Unlock()
+	log.Printf("skipping %s for %v\n", name, delay)
+	return false
+}
+
+// protect calls fn and returns whether it panicked
+func protect(name string, fn func()) (panicked bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Printf("recovered panic in %s: %v\n%s", name, r, debug.Stack())
+			lock.Lock()
+			panics[name]++
+			lock.Unlock()
+			panicked = true
+		}
+	}()
+	fn()
+	return false
+}
+
+// Collector exports the panic and restart counters of the subsystems
+type Collector struct{}
+
+func NewCollector() *Collector {
+	return &Collector{}
+}
+
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- panicsDesc
+	ch <- restartsDesc
+}
+
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	lock.Lock()
+	defer lock.Unlock()
+	for name, n := range panics {
+		ch <- prometheus.MustNewConstMetric(panicsDesc, prometheus.CounterValue, float64(n), name)
+	}
+	for name, n := range restarts {
+		ch <- prometheus.MustNewConstMetric(restartsDesc, prometheus.CounterValue, float64(n), name)
+	}
+}
@@ -0,0 +1,217 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package trend
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/sustainable-computing-io/kepler/pkg/collector"
+)
+
+// The trends are the average power over sliding windows (1m, 15m and 1h by default), computed in
+// the agent so the alerting rules of the edge are threshold comparisons rather than range queries.
+// Until a window is filled, the average is over the samples seen so far.
+const DefaultWindows = "1m,15m,1h"
+
+type Window struct {
+	Name     string
+	Duration time.Duration
+}
+
+// sample is the energy in mJ over (previous sample, t]
+type sample struct {
+	t      time.Time
+	energy float64
+}
+
+// series are the samples of a container or of the node within the largest window
+type series struct {
+	name, namespace string
+	samples         []sample
+	// first is the start of the first sample, bounding the average of the windows not yet filled
+	first time.Time
+}
+
+type Trends struct {
+	windows []Window
+	longest time.Duration
+
+	lock       sync.Mutex
+	last       time.Time
+	node       string
+	nodeSeries *series
+	containers map[string]*series
+}
+
+var (
+	nodeDesc = prometheus.NewDesc(
+		"node_power_average_watts",
+		"Average power of the node over the window",
+		[]string{
+			"EdgeDevice_name",
+			"window",
+		},
+		nil,
+	)
+	containerDesc = prometheus.NewDesc(
+		"container_power_average_watts",
+		"Average power of the container over the window",
+		[]string{
+			"container_name",
+			"container_namespace",
+			"window",
+		},
+		nil,
+	)
+)
+
+// ParseWindows parses a comma separated list of durations, e.g. "1m,15m,1h"
+func ParseWindows(s string) ([]Window, error) {
+	windows := []Window{}
+	for _, name := range strings.Split(s, ",") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		d, err := time.ParseDuration(name)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid trend window %q", name)
+		}
+		windows = append(windows, Window{Name: name, Duration: d})
+	}
+	if len(windows) == 0 {
+		return nil, fmt.Errorf("no trend window in %q", s)
+	}
+	sort.Slice(windows, func(i, j int) bool {
+		return windows[i].Duration < windows[j].Duration
+	})
+	for i := 1; i < len(windows); i++ {
+		if windows[i].Duration == windows[i-1].Duration {
+			return nil, fmt.Errorf("duplicate trend window %q", windows[i].Name)
+		}
+	}
+	return windows, nil
+}
+
+func New(windows []Window) *Trends {
+	t := &Trends{
+		windows:    windows,
+		nodeSeries: &series{},
+		containers: map[string]*series{},
+	}
+	for _, w := range windows {
+		if w.Duration > t.longest {
+			t.longest = w.Duration
+		}
+	}
+	return t
+}
+
+func (s *series) add(t, start time.Time, energy float64, longest time.Duration) {
+	if len(s.samples) == 0 {
+		s.first = start
+	}
+	s.samples = append(s.samples, sample{t: t, energy: energy})
+	i := 0
+	for i < len(s.samples) && t.Sub(s.samples[i].t) >= longest {
+		i++
+	}
+	if i > 0 {
+		s.first = s.samples[i-1].t
+		s.samples = append(s.samples[:0], s.samples[i:]...)
+	}
+}
+
+// average returns the average power in W over the window ending at now
+func (s *series) average(now time.Time, window time.Duration) float64 {
+	start := now.Add(-window)
+	if s.first.After(start) {
+		start = s.first
+	}
+	seconds := now.Sub(start).Seconds()
+	if seconds <= 0 {
+		return 0
+	}
+	energy := 0.0
+	for i := len(s.samples) - 1; i >= 0 && s.samples[i].t.After(start); i-- {
+		energy += s.samples[i].energy
+	}
+	/* power (W) = energy (mJ) / 1000 / time(second) */
+	return energy / 1000 / seconds
+}
+
+// Update adds a sample to the windows, it is registered with collector.OnSample
+func (t *Trends) Update(s *collector.Snapshot) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if t.last.IsZero() {
+		// the first sample has no interval, the windows start from it
+		t.last = s.Time
+		return
+	}
+	start := t.last
+	t.last = s.Time
+	node := s.EdgeDevice
+	t.node = node.Name
+	t.nodeSeries.add(s.Time, start, node.EnergyInCore+node.EnergyInDram+node.EnergyInGPU+node.EnergyInOther, t.longest)
+	seen := map[string]bool{}
+	for _, c := range s.Containers {
+		key := c.Namespace + "/" + c.Name
+		energy := c.EnergyInCore + c.EnergyInDram + c.EnergyInGPU + c.EnergyInOther
+		for _, e := range c.Accelerators {
+			energy += e
+		}
+		cs, ok := t.containers[key]
+		if !ok {
+			cs = &series{name: c.Name, namespace: c.Namespace}
+			t.containers[key] = cs
+		}
+		cs.add(s.Time, start, float64(energy), t.longest)
+		seen[key] = true
+	}
+	// the containers gone for the longest window are dropped
+	for key, cs := range t.containers {
+		if !seen[key] && s.Time.Sub(cs.samples[len(cs.samples)-1].t) >= t.longest {
+			delete(t.containers, key)
+		}
+	}
+}
+
+func (t *Trends) Describe(ch chan<- *prometheus.Desc) {
+	ch <- nodeDesc
+	ch <- containerDesc
+}
+
+func (t *Trends) Collect(ch chan<- prometheus.Metric) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	if len(t.nodeSeries.samples) == 0 {
+		return
+	}
+	for _, w := range t.windows {
+		ch <- prometheus.MustNewConstMetric(nodeDesc, prometheus.GaugeValue, t.nodeSeries.average(t.last, w.Duration), t.node, w.Name)
+		for _, cs := range t.containers {
+			ch <- prometheus.MustNewConstMetric(containerDesc, prometheus.GaugeValue, cs.average(t.last, w.Duration), cs.name, cs.namespace, w.Name)
+		}
+	}
+}
@@ -0,0 +1,208 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package units
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The reports and the dashboard show the energies (J) and the powers (W) in the units of their readers,
+// kWh for the industrial customers or mJ for the embedded developers, with the decimal and the thousands
+// separators of their locale. The default units and locale keep the plain J, W and C number format.
+const (
+	DefaultEnergy = "J"
+	DefaultPower  = "W"
+
+	// btu is the International Table BTU in J
+	btu = 1055.05585262
+)
+
+var (
+	// energyUnits and powerUnits are the J and W per unit
+	energyUnits = map[string]float64{
+		"mJ":  1e-3,
+		"J":   1,
+		"kJ":  1e3,
+		"Wh":  3600,
+		"kWh": 3.6e6,
+		"MWh": 3.6e9,
+		"BTU": btu,
+	}
+	powerUnits = map[string]float64{
+		"mW":    1e-3,
+		"W":     1,
+		"kW":    1e3,
+		"BTU/h": btu / 3600,
+	}
+	// locales are the decimal and thousands separators per language, C is the plain number format
+	locales = map[string][2]string{
+		"c":  {".", ""},
+		"en": {".", ","},
+		"de": {",", "."},
+		"es": {",", "."},
+		"it": {",", "."},
+		"nl": {",", "."},
+		"pt": {",", "."},
+		"da": {",", "."},
+		"fr": {",", " "},
+		"sv": {",", " "},
+		"fi": {",", " "},
+		"nb": {",", " "},
+		"pl": {",", " "},
+		"cs": {",", " "},
+		"ru": {",", " "},
+		"ja": {".", ","},
+		"zh": {".", ","},
+	}
+)
+
+// Format converts and formats the energies and the powers
+type Format struct {
+	energyUnit, powerUnit string
+	language              string
+	decimal, group        string
+}
+
+// New returns the format of the energy and power units and the locale, e.g. de_DE.UTF-8 or en-US, the
+// empty values are the defaults
+func New(energyUnit, powerUnit, locale string) (*Format, error) {
+	if len(energyUnit) == 0 {
+		energyUnit = DefaultEnergy
+	}
+	if len(powerUnit) == 0 {
+		powerUnit = DefaultPower
+	}
+	if _, ok := energyUnits[energyUnit]; !ok {
+		return nil, fmt.Errorf("unknown energy unit %q, expected one of %s", energyUnit, names(energyUnits))
+	}
+	if _, ok := powerUnits[powerUnit]; !ok {
+		return nil, fmt.Errorf("unknown power unit %q, expected one of %s", powerUnit, names(powerUnits))
+	}
+	language := Language(locale)
+	separators, ok := locales[language]
+	if !ok {
+		return nil, fmt.Errorf("unsupported locale %q", locale)
+	}
+	return &Format{
+		energyUnit: energyUnit,
+		powerUnit:  powerUnit,
+		language:   language,
+		decimal:    separators[0],
+		group:      separators[1],
+	}, nil
+}
+
+// Language returns the lower case language of a locale, c for the empty and the POSIX locales
+func Language(locale string) string {
+	fields := strings.FieldsFunc(locale, func(r rune) bool {
+		return r == '_' || r == '-' || r == '.' || r == '@'
+	})
+	if len(fields) == 0 || fields[0] == "POSIX" {
+		return "c"
+	}
+	return strings.ToLower(fields[0])
+}
+
+// EnergyUnit and PowerUnit are the unit symbols, for the headers
+func (f *Format) EnergyUnit() string {
+	return f.energyUnit
+}
+
+func (f *Format) PowerUnit() string {
+	return f.powerUnit
+}
+
+// EnergyFactor and PowerFactor are the J and W per unit
+func (f *Format) EnergyFactor() float64 {
+	return energyUnits[f.energyUnit]
+}
+
+func (f *Format) PowerFactor() float64 {
+	return powerUnits[f.powerUnit]
+}
+
+// Language is the language of the locale, c for the plain number format
+func (f *Format) Language() string {
+	return f.language
+}
+
+// Energy formats an energy in J with the decimals of J, more for the larger units
+func (f *Format) Energy(joules float64, decimals int) string {
+	factor := f.EnergyFactor()
+	return f.Number(joules/factor, scaleDecimals(decimals, factor))
+}
+
+// Power formats a power in W with the decimals of W, more for the larger units
+func (f *Format) Power(watts float64, decimals int) string {
+	factor := f.PowerFactor()
+	return f.Number(watts/factor, scaleDecimals(decimals, factor))
+}
+
+// scaleDecimals keeps the precision of the base unit in a unit factor times larger
+func scaleDecimals(decimals int, factor float64) int {
+	d := decimals + int(math.Round(math.Log10(factor)))
+	if d < 0 {
+		return 0
+	}
+	if d > 9 {
+		return 9
+	}
+	return d
+}
+
+// Number formats a value with the separators of the locale
+func (f *Format) Number(v float64, decimals int) string {
+	s := strconv.FormatFloat(v, 'f', decimals, 64)
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return s
+	}
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	integer, fraction := s, ""
+	if i := strings.IndexByte(s, '.'); i >= 0 {
+		integer, fraction = s[:i], s[i+1:]
+	}
+	if len(f.group) > 0 {
+		var b strings.Builder
+		for i, c := range integer {
+			if i > 0 && (len(integer)-i)%3 == 0 {
+				b.WriteString(f.group)
+			}
+			b.WriteRune(c)
+		}
+		integer = b.String()
+	}
+	if len(fraction) > 0 {
+		return sign + integer + f.decimal + fraction
+	}
+	return sign + integer
+}
+
+func names(units map[string]float64) string {
+	list := make([]string, 0, len(units))
+	for name := range units {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return strings.Join(list, ", ")
+}
@@ -0,0 +1,97 @@
+/*
+Copyright 2022.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package wasm
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// WASM runtimes (wasmtime, spin, wasmedge) host several modules in a single process, so the
+// eBPF accounting only sees the runtime. The program is keyed by thread id, which lets us split
+// the runtime among its modules when the thread of each module instance can be identified, either:
+// - by the thread naming convention "wasm:<module>" (the kernel comm is limited to 15 chars)
+// - by the runtime API, returning the list of {"tid": <thread id>, "module": "<name>"} as JSON
+const (
+	threadNamePrefix = "wasm:"
+	httpTimeout      = 2 * time.Second
+)
+
+type threadModule struct {
+	TID    uint64 `json:"tid"`
+	Module string `json:"module"`
+}
+
+var (
+	runtimeEndpoint string
+	tidToModule     = map[uint64]string{}
+	lock            sync.Mutex
+)
+
+func SetRuntimeEndpoint(ep string) {
+	runtimeEndpoint = ep
+}
+
+// UpdateModules refreshes the thread to module mapping from the runtime API
+func UpdateModules() error {
+	if len(runtimeEndpoint) == 0 {
+		return nil
+	}
+	client := &http.Client{Timeout: httpTimeout}
+	res, err := client.Get(runtimeEndpoint)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %v", runtimeEndpoint, err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		return fmt.Errorf("failed to read response body: %v", err)
+	}
+	threads := []threadModule{}
+	if err = json.Unmarshal(body, &threads); err != nil {
+		return fmt.Errorf("failed to parse response body: %v", err)
+	}
+	m := make(map[uint64]string, len(threads))
+	for _, t := range threads {
+		m[t.TID] = t.Module
+	}
+	lock.Lock()
+	tidToModule = m
+	lock.Unlock()
+	return nil
+}
+
+// GetModuleName returns the WASM module running in the given thread
+func GetModuleName(tid uint64, command string) (string, bool) {
+	if strings.HasPrefix(command, threadNamePrefix) && len(command) > len(threadNamePrefix) {
+		return strings.TrimPrefix(command, threadNamePrefix), true
+	}
+	lock.Lock()
+	defer lock.Unlock()
+	module, ok := tidToModule[tid]
+	return module, ok
+}
+
+// GetModuleEntryName returns the name used to account a module hosted by the given pod
+func GetModuleEntryName(podName, module string) string {
+	return podName + "/" + module
+}